@@ -5,13 +5,23 @@
 package action
 
 import (
-	"errors"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/httpclient"
 	"github.com/tsuru/tsuru-autoscale/log"
+	"github.com/tsuru/tsuru-autoscale/secret"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -20,36 +30,196 @@ func logger() *log.Logger {
 	return log.Log()
 }
 
+const (
+	// DefaultTimeout is the timeout of action requests when neither the
+	// action nor the AUTOSCALE_ACTION_TIMEOUT environment variable define
+	// one.
+	DefaultTimeout = 30 * time.Second
+	// DefaultRetryInterval is the interval before the first retry of
+	// actions that do not define one. It doubles on each retry.
+	DefaultRetryInterval = time.Second
+	// MaxRetryInterval is the maximum interval between retries.
+	MaxRetryInterval = time.Minute
+	// MaxRetryAfter is the maximum interval before retrying throttled
+	// requests, requested by the Retry-After header.
+	MaxRetryAfter = 5 * time.Minute
+)
+
+// sleep is replaced in tests.
+var sleep = time.Sleep
+
+// Action types. Actions without type are http actions.
+const (
+	TypeHTTP      = "http"
+	TypeSlack     = "slack"
+	TypeTeams     = "teams"
+	TypeEmail     = "email"
+	TypePagerDuty = "pagerduty"
+	TypeOpsgenie  = "opsgenie"
+	TypeTsuru     = "tsuru"
+	TypeExec      = "exec"
+)
+
 // Action represents an AutoScale action to increase or decrease the
-// number of the units.
+// number of the units. Requests failing with connection errors, 429 or 5xx
+// responses are retried Retries times, with exponential backoff starting at
+// RetryInterval seconds, or after the Retry-After of throttled responses. Each request times out after Timeout seconds.
+// Responses must have one of the ExpectedStatus, when defined, and match
+// the ResponseExpression. When Secret is set, the requests are signed with
+// it. Dry run actions are rendered and logged, but not sent. Concurrency
+// limits the alarms executing the action at the same time in each autoscale
+// instance, see Acquire.
+// Rollback is the action executed by the alarms when the action, or a later
+// action of a pipeline, fails.
+//
+// Slack and Teams actions post Message, titled by Subject, to the incoming
+// webhook URL. Email actions send Subject and Body to the To recipients,
+// using the SMTP server configured in the environment. PagerDuty and
+// Opsgenie actions create or resolve incidents, see Incident. Tsuru actions
+// add or remove units of the app using the tsuru api, see Scale. Exec
+// actions run Command in the app units using the tsuru api. Other types can
+// be added with Register.
 type Action struct {
-	Name    string
-	URL     string
-	Method  string
-	Body    string
-	Headers map[string]string
+	Name               string
+	Type               string `json:"type,omitempty" bson:",omitempty"`
+	URL                string
+	Method             string
+	Body               string
+	Headers            map[string]string
+	TLS                *httpclient.TLS `json:"tls,omitempty" bson:",omitempty"`
+	Retries            int             `json:"retries,omitempty" bson:",omitempty"`
+	RetryInterval      int             `json:"retry_interval,omitempty" bson:",omitempty"`
+	Secret             string          `json:"secret,omitempty" bson:",omitempty"`
+	Message            string          `json:"message,omitempty" bson:",omitempty"`
+	To                 []string        `json:"to,omitempty" bson:",omitempty"`
+	Subject            string          `json:"subject,omitempty" bson:",omitempty"`
+	RoutingKey         string          `json:"routing_key,omitempty" bson:",omitempty"`
+	Incident           string          `json:"incident,omitempty" bson:",omitempty"`
+	Severity           string          `json:"severity,omitempty" bson:",omitempty"`
+	Scale              string          `json:"scale,omitempty" bson:",omitempty"`
+	Units              string          `json:"units,omitempty" bson:",omitempty"`
+	Process            string          `json:"process,omitempty" bson:",omitempty"`
+	Token              string          `json:"token,omitempty" bson:",omitempty"`
+	DryRun             bool            `json:"dry_run,omitempty" bson:",omitempty"`
+	Timeout            int             `json:"timeout,omitempty" bson:",omitempty"`
+	ExpectedStatus     []int           `json:"expected_status,omitempty" bson:",omitempty"`
+	ResponseExpression string          `json:"response_expression,omitempty" bson:",omitempty"`
+	Concurrency        int             `json:"concurrency,omitempty" bson:",omitempty"`
+	ConcurrencyPolicy  string          `json:"concurrency_policy,omitempty" bson:",omitempty"`
+	Rollback           string          `json:"rollback,omitempty" bson:",omitempty"`
+	Command            string          `json:"command,omitempty" bson:",omitempty"`
+	Once               bool            `json:"once,omitempty" bson:",omitempty"`
+
+	// lg is the logger of the alarm executing the action.
+	lg *log.Logger
+}
+
+// SetLogger sets the logger of the action executions, so their logs carry
+// the fields of the alarm, or request, executing the action.
+func (a *Action) SetLogger(l *log.Logger) {
+	a.lg = l
+}
+
+func (a *Action) logger() *log.Logger {
+	if a.lg != nil {
+		return a.lg
+	}
+	return logger()
+}
+
+// Attempt represents a request made to execute an action. Response is the
+// beginning of the response body, to show the errors of the integrations.
+// Throttled attempts got a 429 or 503 response, and RetryAfter is the
+// interval before the retry requested by the response.
+type Attempt struct {
+	Time       time.Time     `json:"time"`
+	Duration   time.Duration `json:"duration"`
+	StatusCode int           `json:"statusCode,omitempty" bson:",omitempty"`
+	Error      string        `json:"error,omitempty" bson:",omitempty"`
+	DryRun     bool          `json:"dryRun,omitempty" bson:",omitempty"`
+	Request    string        `json:"request,omitempty" bson:",omitempty"`
+	URL        string        `json:"url,omitempty" bson:",omitempty"`
+	Output     string        `json:"output,omitempty" bson:",omitempty"`
+	Response   string        `json:"response,omitempty" bson:",omitempty"`
+	Throttled  bool          `json:"throttled,omitempty" bson:",omitempty"`
+	RetryAfter time.Duration `json:"retryAfter,omitempty" bson:",omitempty"`
+	body       []byte
+}
+
+// ValidationError is returned when an action is invalid. Action is the name
+// of the invalid action in the bulk updates.
+type ValidationError struct {
+	Action  string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Action != "" {
+		return fmt.Sprintf("action %q: %s", e.Action, e.Message)
+	}
+	return "action: " + e.Message
+}
+
+// invalid returns the *ValidationError with the formatted message.
+func invalid(format string, args ...interface{}) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...)}
 }
 
 // New creates a new action.
 func New(a *Action) error {
-	if a.URL == "" {
-		return errors.New("action: url required")
+	if err := a.validate(); err != nil {
+		return err
 	}
-	if a.Method == "" {
-		return errors.New("action: method required")
+	encrypted := *a
+	err := encrypted.transformSecrets(secret.Encrypt)
+	if err != nil {
+		logger().Error(err)
+		return err
 	}
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return err
 	}
 	defer conn.Close()
-	return conn.Actions().Insert(&a)
+	return conn.Actions().Insert(&encrypted)
+}
+
+func (a *Action) validate() error {
+	e, ok := executorFor(a.Type)
+	if !ok {
+		return invalid("invalid type %q", a.Type)
+	}
+	templates, err := e.Validate(a)
+	if err != nil {
+		return err
+	}
+	if a.Retries < 0 || a.RetryInterval < 0 {
+		return invalid("retries and retry_interval must not be negative")
+	}
+	if a.Timeout < 0 {
+		return invalid("timeout must not be negative")
+	}
+	if err := a.validateResponseChecks(); err != nil {
+		return err
+	}
+	if err := a.validateConcurrency(); err != nil {
+		return err
+	}
+	if a.Rollback != "" && a.Rollback == a.Name {
+		return invalid("an action can't be its own rollback")
+	}
+	for _, text := range templates {
+		if _, err := parseTemplate(text); err != nil {
+			return invalid("invalid template: %s", err)
+		}
+	}
+	return nil
 }
 
 // FindByName finds action by name.
 func FindByName(name string) (*Action, error) {
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return nil, err
@@ -59,17 +229,22 @@ func FindByName(name string) (*Action, error) {
 	err = conn.Actions().Find(bson.M{"name": name}).One(&action)
 	if err != nil {
 		if err == mgo.ErrNotFound {
-			err = fmt.Errorf("action %q not found", name)
+			err = &db.NotFoundError{Resource: "action", Name: name}
 		}
 		logger().Error(err)
 		return nil, err
 	}
+	err = action.transformSecrets(secret.Decrypt)
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
 	return &action, nil
 }
 
 // Remove removes an action.
 func Remove(a *Action) error {
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return err
@@ -80,44 +255,315 @@ func Remove(a *Action) error {
 
 // All return a list of all actions
 func All() ([]Action, error) {
-	conn, err := db.Conn()
+	actions, _, err := List(nil)
+	return actions, err
+}
+
+// List returns the page of the actions selected by opts and the total of
+// actions matching its filters.
+func List(opts *db.ListOptions) ([]Action, int, error) {
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
-		return nil, err
+		return nil, 0, err
 	}
 	defer conn.Close()
 	var actions []Action
-	err = conn.Actions().Find(nil).All(&actions)
+	total, err := db.List(conn.Actions(), nil, opts, &actions)
 	if err != nil {
 		logger().Error(err)
-		return nil, err
+		return nil, 0, err
+	}
+	for i := range actions {
+		err = actions[i].transformSecrets(secret.Decrypt)
+		if err != nil {
+			logger().Error(err)
+			return nil, 0, err
+		}
 	}
-	return actions, nil
+	return actions, total, nil
 }
 
-// Do executes the action
+// Do executes the action.
 func (a *Action) Do(appName string, envs map[string]string) error {
-	body := a.Body
-	url := strings.Replace(a.URL, "{app}", appName, -1)
-	for key, value := range envs {
-		body = strings.Replace(body, fmt.Sprintf("{%s}", key), value, -1)
-		url = strings.Replace(url, fmt.Sprintf("{%s}", key), value, -1)
+	_, err := a.Execute(&Context{App: appName, Envs: envs})
+	return err
+}
+
+// Execute executes the action with the url and body rendered using ctx,
+// retrying on connection errors and 5xx responses, and returns the attempts
+// made. Dry run actions are rendered but not sent, returning an attempt
+// with the request. When ctx has outputs, the response of the action is
+// added to them, by action name, to be used by the next actions.
+func (a *Action) Execute(ctx *Context) ([]Attempt, error) {
+	e, ok := executorFor(a.Type)
+	if !ok {
+		err := fmt.Errorf("action: invalid type %q", a.Type)
+		a.logger().Error(err)
+		return nil, err
 	}
-	logger().Printf("action %s - url: %s - body: %s - method: %s", a.Name, url, body, a.Method)
-	req, err := http.NewRequest(a.Method, url, strings.NewReader(body))
+	exec, err := e.Prepare(a, ctx)
 	if err != nil {
-		logger().Error(err)
-		return err
+		a.logger().Error(err)
+		return nil, err
+	}
+	if a.DryRun {
+		request := a.redactRequest(exec.Request, ctx)
+		a.logger().Printf("action %s - dry run: %s", a.Name, request)
+		return []Attempt{{Time: time.Now().UTC(), DryRun: true, Request: request, URL: a.redactRequest(exec.URL, ctx)}}, nil
+	}
+	var attempts []Attempt
+	for i := 0; ; i++ {
+		attempt, err := exec.Send()
+		attempt.URL = a.redactRequest(exec.URL, ctx)
+		attempt.Error = a.redactRequest(attempt.Error, ctx)
+		attempt.Response = a.redactRequest(attempt.response(), ctx)
+		attempts = append(attempts, attempt)
+		if err == nil && attempt.body != nil && ctx.Outputs != nil {
+			ctx.Outputs[a.Name] = output(attempt.body)
+		}
+		if err == nil || i >= a.Retries {
+			return attempts, err
+		}
+		if _, ok := err.(*permanentError); ok {
+			return attempts, err
+		}
+		wait := a.backoff(i)
+		if attempt.RetryAfter > 0 {
+			wait = attempt.RetryAfter
+			a.logger().Printf("action %s - attempt %d throttled - retrying after %s", a.Name, i+1, wait)
+		}
+		a.logger().Printf("action %s - attempt %d failed: %s - retrying in %s", a.Name, i+1, err, wait)
+		sleep(wait)
+	}
+}
+
+// permanentError is an error of a request that is not retried, because it
+// would fail again.
+type permanentError struct {
+	error
+}
+
+// timeout returns the timeout of the action requests: the action timeout,
+// the AUTOSCALE_ACTION_TIMEOUT environment variable, in seconds, or
+// DefaultTimeout.
+func (a *Action) timeout() time.Duration {
+	if a.Timeout > 0 {
+		return time.Duration(a.Timeout) * time.Second
+	}
+	if t := os.Getenv("AUTOSCALE_ACTION_TIMEOUT"); t != "" {
+		v, err := strconv.Atoi(t)
+		if err == nil && v > 0 {
+			return time.Duration(v) * time.Second
+		}
+		a.logger().Printf("invalid AUTOSCALE_ACTION_TIMEOUT %q", t)
+	}
+	return DefaultTimeout
+}
+
+// client returns the HTTP client used by the action, with its timeout.
+func (a *Action) client() (*http.Client, error) {
+	c, err := httpclient.Client(a.TLS)
+	if err != nil {
+		return nil, err
+	}
+	client := *c
+	client.Timeout = a.timeout()
+	return &client, nil
+}
+
+// request is a request made to execute an action.
+type request struct {
+	method  string
+	url     string
+	body    string
+	headers map[string]string
+}
+
+// String returns the request in the HTTP format, with the secret headers
+// redacted.
+func (r *request) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s\n", r.method, r.url)
+	keys := make([]string, 0, len(r.headers))
+	for key := range r.headers {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := r.headers[key]
+		if secret.IsSecretHeader(key) {
+			value = secret.Redact(value)
+		}
+		fmt.Fprintf(&buf, "%s: %s\n", key, value)
+	}
+	fmt.Fprintf(&buf, "\n%s", r.body)
+	return buf.String()
+}
+
+// renderRequest renders the action with ctx, returning the description of
+// its request with the secrets redacted.
+func (a *Action) renderRequest(ctx *Context) (string, error) {
+	e, ok := executorFor(a.Type)
+	if !ok {
+		return "", invalid("invalid type %q", a.Type)
+	}
+	exec, err := e.Prepare(a, ctx)
+	if err != nil {
+		return "", err
+	}
+	return a.redactRequest(exec.Request, ctx), nil
+}
+
+// redactRequest redacts the action secrets, and the secrets resolved
+// rendering the action with ctx, from the request description.
+func (a *Action) redactRequest(request string, ctx *Context) string {
+	for _, value := range append([]string{a.Secret, a.RoutingKey, a.Token}, ctx.secrets...) {
+		if value != "" {
+			request = strings.Replace(request, value, secret.Redact(value), -1)
+		}
+	}
+	return request
+}
+
+func validateHTTP(a *Action) ([]string, error) {
+	if a.URL == "" {
+		return nil, invalid("url required")
+	}
+	if a.Method == "" {
+		return nil, invalid("method required")
+	}
+	return []string{a.URL, a.Body}, nil
+}
+
+// httpSender returns the execution sending the request of http actions.
+func (a *Action) httpSender(ctx *Context) (*Prepared, error) {
+	url, err := Render(a.URL, ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := Render(a.Body, ctx)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := a.headers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	a.logger().Printf("action %s - url: %s - body: %s - method: %s", a.Name, a.redactRequest(url, ctx), a.redactRequest(body, ctx), a.Method)
+	return a.sender(&request{method: a.Method, url: url, body: body, headers: headers})
+}
+
+// headers returns the action headers with the {secret.NAME} placeholders
+// replaced.
+func (a *Action) headers(ctx *Context) (map[string]string, error) {
+	headers := make(map[string]string, len(a.Headers))
 	for key, value := range a.Headers {
+		value, err := ctx.expandSecrets(value)
+		if err != nil {
+			return nil, err
+		}
+		headers[key] = value
+	}
+	return headers, nil
+}
+
+// sender returns the execution sending r.
+func (a *Action) sender(r *request) (*Prepared, error) {
+	client, err := a.client()
+	if err != nil {
+		return nil, err
+	}
+	send := func() (Attempt, error) {
+		return a.do(client, r)
+	}
+	return &Prepared{Request: r.String(), URL: r.url, Send: send}, nil
+}
+
+// jsonSender returns the execution posting v, encoded as JSON, to url, with
+// the action headers and the given ones.
+func (a *Action) jsonSender(ctx *Context, url string, v interface{}, headers map[string]string) (*Prepared, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	actionHeaders, err := a.headers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	h := map[string]string{"Content-Type": "application/json"}
+	for key, value := range actionHeaders {
+		h[key] = value
+	}
+	for key, value := range headers {
+		h[key] = value
+	}
+	return a.sender(&request{method: http.MethodPost, url: url, body: string(body), headers: h})
+}
+
+// do executes a single request.
+func (a *Action) do(client *http.Client, r *request) (Attempt, error) {
+	attempt := Attempt{Time: time.Now().UTC()}
+	req, err := http.NewRequest(r.method, r.url, strings.NewReader(r.body))
+	if err != nil {
+		a.logger().Error(err)
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+	for key, value := range r.headers {
 		req.Header.Add(key, value)
 	}
-	client := &http.Client{}
+	a.sign(req, r.body)
 	resp, err := client.Do(req)
+	attempt.Duration = time.Since(attempt.Time)
 	if err != nil {
-		logger().Error(err)
-		return err
+		a.logger().Error(err)
+		attempt.Error = err.Error()
+		return attempt, err
 	}
 	defer resp.Body.Close()
-	return nil
+	attempt.StatusCode = resp.StatusCode
+	if httpclient.Throttled(resp) {
+		attempt.Throttled = true
+		attempt.RetryAfter = limitRetryAfter(httpclient.RetryAfter(resp))
+	}
+	attempt.body, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		a.logger().Error(err)
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+	if err = a.checkResponse(resp, attempt.body); err != nil {
+		a.logger().Error(err)
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+	return attempt, nil
+}
+
+// limitRetryAfter limits the interval requested by a Retry-After header to
+// MaxRetryAfter.
+func limitRetryAfter(d time.Duration) time.Duration {
+	if d > MaxRetryAfter {
+		return MaxRetryAfter
+	}
+	return d
+}
+
+// backoff returns the interval before the retry following the given
+// attempt: the retry interval doubled on each attempt, up to
+// MaxRetryInterval, with a random jitter of up to half of it.
+func (a *Action) backoff(attempt int) time.Duration {
+	interval := DefaultRetryInterval
+	if a.RetryInterval > 0 {
+		interval = time.Duration(a.RetryInterval) * time.Second
+	}
+	for i := 0; i < attempt && interval < MaxRetryInterval; i++ {
+		interval *= 2
+	}
+	if interval > MaxRetryInterval {
+		interval = MaxRetryInterval
+	}
+	half := interval / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
 }
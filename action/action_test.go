@@ -5,33 +5,35 @@
 package action
 
 import (
-	"errors"
+	"encoding/pem"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/tsuru/tsuru-autoscale/db"
-	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru-autoscale/db/storagetest"
+	"github.com/tsuru/tsuru-autoscale/httpclient"
+	"github.com/tsuru/tsuru-autoscale/secret"
 	"gopkg.in/check.v1"
 )
 
 func Test(t *testing.T) { check.TestingT(t) }
 
 type S struct {
-	conn *db.Storage
+	conn *storagetest.Storage
 }
 
 func (s *S) SetUpSuite(c *check.C) {
-	err := os.Setenv("MONGODB_DATABASE_NAME", "tsuru_autoscale_action")
-	c.Assert(err, check.IsNil)
-	s.conn, err = db.Conn()
+	var err error
+	s.conn, err = storagetest.New("tsuru_autoscale_action")
 	c.Assert(err, check.IsNil)
 }
 
 func (s *S) TearDownTest(c *check.C) {
-	dbtest.ClearAllCollections(s.conn.Actions().Database)
+	s.conn.Clear()
 }
 
 func (s *S) TearDownSuite(c *check.C) {
@@ -46,9 +48,19 @@ func (s *S) TestNew(c *check.C) {
 		a   *Action
 		err error
 	}{
-		{&Action{URL: "http://tsuru.io", Method: "GET"}, nil},
-		{&Action{URL: "http://tsuru.io"}, errors.New("action: method required")},
-		{&Action{Method: ""}, errors.New("action: url required")},
+		{&Action{Name: "get", URL: "http://tsuru.io", Method: "GET"}, nil},
+		{&Action{URL: "http://tsuru.io"}, &ValidationError{Message: "method required"}},
+		{&Action{Method: ""}, &ValidationError{Message: "url required"}},
+		{&Action{URL: "http://tsuru.io", Method: "GET", Retries: -1}, &ValidationError{Message: "retries and retry_interval must not be negative"}},
+		{&Action{Name: "slack", Type: TypeSlack, URL: "https://hooks.slack.com/services/T/B/X", Message: "{app} scaled"}, nil},
+		{&Action{Type: TypeSlack, URL: "https://hooks.slack.com/services/T/B/X"}, &ValidationError{Message: "message required"}},
+		{&Action{Name: "teams", Type: TypeTeams, URL: "https://example.webhook.office.com/webhookb2/x", Message: "{app} scaled", Subject: "{{.Alarm.Name}}"}, nil},
+		{&Action{Type: TypeTeams, Message: "{app} scaled"}, &ValidationError{Message: "url required"}},
+		{&Action{Type: "sms", URL: "http://tsuru.io"}, &ValidationError{Message: `invalid type "sms"`}},
+		{&Action{Name: "email", Type: TypeEmail, To: []string{"team@example.com"}, Subject: "{{.Alarm.Name}}"}, nil},
+		{&Action{Type: TypeEmail, Subject: "scaled"}, &ValidationError{Message: "to required"}},
+		{&Action{Name: "scale_up", URL: "http://tsuru.io", Method: "GET", Rollback: "scale_down"}, nil},
+		{&Action{Name: "scale_up", URL: "http://tsuru.io", Method: "GET", Rollback: "scale_up"}, &ValidationError{Message: "an action can't be its own rollback"}},
 	}
 	for _, tt := range actionTests {
 		err := New(tt.a)
@@ -74,6 +86,103 @@ func (s *S) TestDo(c *check.C) {
 	c.Assert(called, check.Equals, true)
 }
 
+func (s *S) TestDoWithCustomCA(c *check.C) {
+	var called bool
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer ts.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	a := Action{URL: ts.URL, Method: "GET", TLS: &httpclient.TLS{CACert: string(ca)}}
+	err := a.Do("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(called, check.Equals, true)
+	a.TLS = nil
+	err = a.Do("app", nil)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestExecuteRetries(c *check.C) {
+	var waits []time.Duration
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	defer func() { sleep = time.Sleep }()
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+	a := Action{Name: "scale_up", URL: ts.URL, Method: "POST", Retries: 3, RetryInterval: 2}
+	attempts, err := a.Execute(&Context{App: "app"})
+	c.Assert(err, check.IsNil)
+	c.Assert(calls, check.Equals, 3)
+	c.Assert(attempts, check.HasLen, 3)
+	c.Assert(attempts[0].StatusCode, check.Equals, http.StatusBadGateway)
+	c.Assert(attempts[0].Error, check.Equals, `action "scale_up": request failed with status 502`)
+	c.Assert(attempts[2].StatusCode, check.Equals, http.StatusOK)
+	c.Assert(attempts[2].Error, check.Equals, "")
+	c.Assert(waits, check.HasLen, 2)
+	c.Assert(waits[0] >= time.Second && waits[0] <= 2*time.Second, check.Equals, true)
+	c.Assert(waits[1] >= 2*time.Second && waits[1] <= 4*time.Second, check.Equals, true)
+}
+
+func (s *S) TestExecuteRetryAfter(c *check.C) {
+	var waits []time.Duration
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	defer func() { sleep = time.Sleep }()
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 3:
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+	a := Action{Name: "scale_up", URL: ts.URL, Method: "POST", Retries: 3}
+	attempts, err := a.Execute(&Context{App: "app"})
+	c.Assert(err, check.IsNil)
+	c.Assert(attempts, check.HasLen, 4)
+	c.Assert(attempts[0].Throttled, check.Equals, true)
+	c.Assert(attempts[0].RetryAfter, check.Equals, 7*time.Second)
+	c.Assert(attempts[1].RetryAfter, check.Equals, MaxRetryAfter)
+	c.Assert(attempts[2].Throttled, check.Equals, true)
+	c.Assert(attempts[2].RetryAfter, check.Equals, time.Duration(0))
+	c.Assert(attempts[3].Throttled, check.Equals, false)
+	c.Assert(waits, check.HasLen, 3)
+	c.Assert(waits[0], check.Equals, 7*time.Second)
+	c.Assert(waits[1], check.Equals, MaxRetryAfter)
+	c.Assert(waits[2] <= 4*time.Second, check.Equals, true)
+}
+
+func (s *S) TestExecuteRetriesExhausted(c *check.C) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+	a := Action{Name: "scale_up", URL: "http://127.0.0.1:1", Method: "POST", Retries: 2}
+	attempts, err := a.Execute(&Context{App: "app"})
+	c.Assert(err, check.NotNil)
+	c.Assert(attempts, check.HasLen, 3)
+	c.Assert(attempts[2].Error, check.Equals, err.Error())
+}
+
+func (s *S) TestBackoff(c *check.C) {
+	a := Action{RetryInterval: 10}
+	for i, max := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute} {
+		wait := a.backoff(i)
+		c.Check(wait >= max/2 && wait <= max, check.Equals, true, check.Commentf("attempt %d: %s", i, wait))
+	}
+	a.RetryInterval = 0
+	c.Assert(a.backoff(0) <= DefaultRetryInterval, check.Equals, true)
+}
+
 func (s *S) TestAll(c *check.C) {
 	a := Action{
 		Name:    "xpto",
@@ -117,3 +226,108 @@ func (s *S) TestRemove(c *check.C) {
 	_, err = FindByName(a.Name)
 	c.Assert(err, check.NotNil)
 }
+
+func (s *S) TestExecuteDryRun(c *check.C) {
+	var called bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer ts.Close()
+	a := Action{
+		Name:    "scale_up",
+		URL:     ts.URL + "/apps/{app}/units",
+		Method:  "PUT",
+		Body:    "units={step}",
+		Headers: map[string]string{"Authorization": "bearer mytoken", "Content-Type": "application/x-www-form-urlencoded"},
+		DryRun:  true,
+	}
+	attempts, err := a.Execute(&Context{App: "myapp", Envs: map[string]string{"step": "2"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(called, check.Equals, false)
+	c.Assert(attempts, check.HasLen, 1)
+	c.Assert(attempts[0].DryRun, check.Equals, true)
+	c.Assert(attempts[0].Request, check.Equals, "PUT "+ts.URL+"/apps/myapp/units\nAuthorization: "+secret.Redact("bearer mytoken")+"\nContent-Type: application/x-www-form-urlencoded\n\nunits=2")
+}
+
+func (s *S) TestExecuteDryRunRedactsSecrets(c *check.C) {
+	a := Action{Name: "page", Type: TypePagerDuty, URL: "http://127.0.0.1:1", RoutingKey: "myroutingkey", DryRun: true}
+	attempts, err := a.Execute(&Context{Alarm: AlarmContext{Name: "cpu"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(attempts, check.HasLen, 1)
+	c.Assert(strings.Contains(attempts[0].Request, "myroutingkey"), check.Equals, false)
+	c.Assert(strings.Contains(attempts[0].Request, `"dedup_key":"autoscale:cpu:"`), check.Equals, true)
+}
+
+func (s *S) TestExecuteSecretPlaceholders(c *check.C) {
+	os.Setenv("AUTOSCALE_SECRET_API_TOKEN", "mytoken")
+	defer os.Unsetenv("AUTOSCALE_SECRET_API_TOKEN")
+	var path, header, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		header = r.Header.Get("X-Api")
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer ts.Close()
+	a := Action{
+		Name:    "notify",
+		URL:     ts.URL + "/hooks/{secret.API_TOKEN}",
+		Method:  "POST",
+		Body:    "token={secret.API_TOKEN}",
+		Headers: map[string]string{"X-Api": "{secret.API_TOKEN}"},
+	}
+	attempts, err := a.Execute(&Context{})
+	c.Assert(err, check.IsNil)
+	c.Assert(path, check.Equals, "/hooks/mytoken")
+	c.Assert(header, check.Equals, "mytoken")
+	c.Assert(body, check.Equals, "token=mytoken")
+	c.Assert(attempts[0].URL, check.Equals, ts.URL+"/hooks/*****")
+	a.DryRun = true
+	attempts, err = a.Execute(&Context{})
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(attempts[0].Request, "mytoken"), check.Equals, false)
+	a.Headers = map[string]string{"X-Api": "{secret.MISSING}"}
+	_, err = a.Execute(&Context{})
+	c.Assert(err, check.ErrorMatches, `secret "MISSING" not found`)
+}
+
+func (s *S) TestExecuteRecordsResponse(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error": "quota exceeded for mytoken"}`))
+	}))
+	defer ts.Close()
+	a := Action{Name: "scale", URL: ts.URL, Method: "POST", ExpectedStatus: []int{200}, Headers: map[string]string{"Authorization": "mytoken"}, Token: "mytoken"}
+	attempts, err := a.Execute(&Context{})
+	c.Assert(err, check.NotNil)
+	c.Assert(attempts, check.HasLen, 1)
+	c.Assert(attempts[0].StatusCode, check.Equals, http.StatusForbidden)
+	c.Assert(attempts[0].Response, check.Equals, `{"error": "quota exceeded for *****"}`)
+}
+
+func (s *S) TestTimeout(c *check.C) {
+	a := Action{}
+	c.Assert(a.timeout(), check.Equals, DefaultTimeout)
+	os.Setenv("AUTOSCALE_ACTION_TIMEOUT", "10")
+	defer os.Unsetenv("AUTOSCALE_ACTION_TIMEOUT")
+	c.Assert(a.timeout(), check.Equals, 10*time.Second)
+	a.Timeout = 2
+	c.Assert(a.timeout(), check.Equals, 2*time.Second)
+	os.Setenv("AUTOSCALE_ACTION_TIMEOUT", "ten")
+	a.Timeout = 0
+	c.Assert(a.timeout(), check.Equals, DefaultTimeout)
+}
+
+func (s *S) TestExecuteTimeout(c *check.C) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+	a := Action{Name: "notify", URL: ts.URL, Method: "POST", Timeout: 1}
+	attempts, err := a.Execute(&Context{})
+	c.Assert(err, check.NotNil)
+	c.Assert(attempts, check.HasLen, 1)
+	c.Assert(attempts[0].Duration < 2*time.Second, check.Equals, true)
+}
@@ -0,0 +1,212 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"strings"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/secret"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ImportResult is the result of an import: the names of the actions
+// created and updated.
+type ImportResult struct {
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+}
+
+// Update replaces the definition of an existing action. Redacted secrets,
+// like the ones of listed or exported actions, keep their stored values.
+func Update(a *Action) error {
+	return UpdateAll([]Action{*a})
+}
+
+// UpdateAll replaces the definitions of existing actions. All the actions
+// are validated, and must exist, before any of them is updated.
+func UpdateAll(actions []Action) error {
+	updated, err := prepare(actions)
+	if err != nil {
+		return err
+	}
+	for i := range actions {
+		if updated[i] == nil {
+			return &db.NotFoundError{Resource: "action", Name: actions[i].Name}
+		}
+	}
+	_, err = save(updated)
+	return err
+}
+
+// Import creates or updates the actions, usually exported from another
+// deployment. All the actions are validated before any of them is saved.
+// Redacted secrets keep the values stored in the existing actions.
+func Import(actions []Action) (*ImportResult, error) {
+	prepared, err := prepare(actions)
+	if err != nil {
+		return nil, err
+	}
+	for i := range prepared {
+		if prepared[i] == nil {
+			a := actions[i]
+			prepared[i] = &a
+		}
+	}
+	return save(prepared)
+}
+
+// prepare validates the actions and returns the existing ones with the new
+// definitions, or nil for the actions that don't exist.
+func prepare(actions []Action) ([]*Action, error) {
+	names := map[string]bool{}
+	result := make([]*Action, len(actions))
+	for i := range actions {
+		a := &actions[i]
+		if a.Name == "" {
+			return nil, invalid("name required")
+		}
+		if names[a.Name] {
+			return nil, &ValidationError{Action: a.Name, Message: "duplicated"}
+		}
+		names[a.Name] = true
+		existing, err := FindByName(a.Name)
+		if err != nil && !db.IsNotFound(err) {
+			return nil, err
+		}
+		if existing != nil {
+			a.keepSecrets(existing)
+		}
+		if err := a.validate(); err != nil {
+			return nil, &ValidationError{Action: a.Name, Message: strings.TrimPrefix(err.Error(), "action: ")}
+		}
+		if a.hasRedactedSecrets() {
+			return nil, &ValidationError{Action: a.Name, Message: "redacted secrets must be set"}
+		}
+		if existing != nil {
+			result[i] = a
+		}
+	}
+	return result, nil
+}
+
+// keepSecrets replaces the redacted secrets of the action by the ones of
+// the existing action.
+func (a *Action) keepSecrets(existing *Action) {
+	keep := func(value, stored string) string {
+		if value == secret.Redacted {
+			return stored
+		}
+		return value
+	}
+	a.Secret = keep(a.Secret, existing.Secret)
+	a.RoutingKey = keep(a.RoutingKey, existing.RoutingKey)
+	a.Token = keep(a.Token, existing.Token)
+}
+
+func (a *Action) hasRedactedSecrets() bool {
+	return a.Secret == secret.Redacted || a.RoutingKey == secret.Redacted || a.Token == secret.Redacted
+}
+
+// save upserts the actions, with their secrets encrypted.
+func save(actions []*Action) (*ImportResult, error) {
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	defer conn.Close()
+	result := ImportResult{Created: []string{}, Updated: []string{}}
+	for _, a := range actions {
+		encrypted := *a
+		if err = encrypted.transformSecrets(secret.Encrypt); err != nil {
+			logger().Error(err)
+			return nil, err
+		}
+		info, err := conn.Actions().Upsert(bson.M{"name": a.Name}, &encrypted)
+		if err != nil {
+			logger().Error(err)
+			return nil, err
+		}
+		if info.UpsertedId != nil {
+			result.Created = append(result.Created, a.Name)
+		} else {
+			result.Updated = append(result.Updated, a.Name)
+		}
+	}
+	return &result, nil
+}
+
+// ReplaceHost replaces the host of the urls of all actions from the given
+// host by the new one, returning the names of the updated actions. The
+// hosts may include the scheme, like https://tsuru.example.com, to match or
+// replace it too.
+func ReplaceHost(from, to string) ([]string, error) {
+	if from == "" || to == "" {
+		return nil, invalid("from and to hosts required")
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	defer conn.Close()
+	var actions []Action
+	err = conn.Actions().Find(bson.M{"url": bson.M{"$ne": ""}}).Select(bson.M{"name": 1, "url": 1}).All(&actions)
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	updated := []string{}
+	for _, a := range actions {
+		u, ok := replaceHost(a.URL, from, to)
+		if !ok {
+			continue
+		}
+		err = conn.Actions().Update(bson.M{"name": a.Name, "url": a.URL}, bson.M{"$set": bson.M{"url": u}})
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			logger().Error(err)
+			return updated, err
+		}
+		updated = append(updated, a.Name)
+	}
+	return updated, nil
+}
+
+// replaceHost replaces the host of rawurl when it matches from, returning
+// whether it was replaced. Only the scheme and host are compared, so urls
+// with templates in the path and query are supported.
+func replaceHost(rawurl, from, to string) (string, bool) {
+	scheme, host, rest := splitURL(rawurl)
+	if scheme == "" || host == "" {
+		return rawurl, false
+	}
+	fromScheme, fromHost, _ := splitURL(from)
+	if !strings.EqualFold(host, fromHost) || (fromScheme != "" && !strings.EqualFold(scheme, fromScheme)) {
+		return rawurl, false
+	}
+	toScheme, toHost, _ := splitURL(to)
+	if toScheme == "" {
+		toScheme = scheme
+	}
+	return toScheme + "://" + toHost + rest, true
+}
+
+// splitURL splits the scheme, host and the rest of the url. Urls without
+// scheme are considered hosts.
+func splitURL(rawurl string) (scheme, host, rest string) {
+	if i := strings.Index(rawurl, "://"); i >= 0 {
+		scheme, rawurl = rawurl[:i], rawurl[i+3:]
+	}
+	end := strings.IndexAny(rawurl, "/?#")
+	if end < 0 {
+		end = len(rawurl)
+	}
+	return scheme, rawurl[:end], rawurl[end:]
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"github.com/tsuru/tsuru-autoscale/secret"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestImport(c *check.C) {
+	err := New(&Action{Name: "scale_up", Type: TypeTsuru, Scale: ScaleUp, Token: "mytoken"})
+	c.Assert(err, check.IsNil)
+	actions := []Action{
+		{Name: "scale_up", Type: TypeTsuru, Scale: ScaleUp, Token: secret.Redacted, Retries: 2},
+		{Name: "notify", URL: "http://tsuru.io", Method: "POST"},
+	}
+	result, err := Import(actions)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, &ImportResult{Created: []string{"notify"}, Updated: []string{"scale_up"}})
+	a, err := FindByName("scale_up")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Token, check.Equals, "mytoken")
+	c.Assert(a.Retries, check.Equals, 2)
+	_, err = FindByName("notify")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestImportInvalid(c *check.C) {
+	actions := []Action{
+		{Name: "notify", URL: "http://tsuru.io", Method: "POST"},
+		{Name: "page", Type: TypePagerDuty, RoutingKey: secret.Redacted},
+		{Name: "other", URL: "http://tsuru.io"},
+	}
+	_, err := Import(actions)
+	c.Assert(err, check.ErrorMatches, `action "page": redacted secrets must be set`)
+	_, err = Import(actions[2:])
+	c.Assert(err, check.ErrorMatches, `action "other": method required`)
+	_, err = Import([]Action{actions[0], actions[0]})
+	c.Assert(err, check.ErrorMatches, `action "notify": duplicated`)
+	_, err = FindByName("notify")
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestUpdateAll(c *check.C) {
+	err := New(&Action{Name: "notify", URL: "http://tsuru.io", Method: "POST"})
+	c.Assert(err, check.IsNil)
+	err = UpdateAll([]Action{
+		{Name: "notify", URL: "http://tsuru.io", Method: "PUT"},
+		{Name: "missing", URL: "http://tsuru.io", Method: "PUT"},
+	})
+	c.Assert(err, check.ErrorMatches, `action "missing" not found`)
+	err = Update(&Action{Name: "notify", URL: "http://tsuru.io", Method: "PUT"})
+	c.Assert(err, check.IsNil)
+	a, err := FindByName("notify")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Method, check.Equals, "PUT")
+}
+
+func (s *S) TestReplaceHost(c *check.C) {
+	actions := []Action{
+		{Name: "scale_up", URL: "http://tsuru.old.io/apps/{app}/units", Method: "PUT"},
+		{Name: "scale_down", URL: "https://TSURU.old.io", Method: "DELETE"},
+		{Name: "notify", URL: "http://other.io/tsuru.old.io", Method: "POST"},
+	}
+	for i := range actions {
+		err := New(&actions[i])
+		c.Assert(err, check.IsNil)
+	}
+	updated, err := ReplaceHost("tsuru.old.io", "https://tsuru.new.io:8080")
+	c.Assert(err, check.IsNil)
+	c.Assert(updated, check.DeepEquals, []string{"scale_up", "scale_down"})
+	a, err := FindByName("scale_up")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.URL, check.Equals, "https://tsuru.new.io:8080/apps/{app}/units")
+	a, err = FindByName("notify")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.URL, check.Equals, "http://other.io/tsuru.old.io")
+}
+
+func (s *S) TestReplaceHostURL(c *check.C) {
+	tests := []struct {
+		url, from, to string
+		expected      string
+		replaced      bool
+	}{
+		{"http://tsuru.io/apps", "tsuru.io", "tsuru.com", "http://tsuru.com/apps", true},
+		{"http://tsuru.io?a=b", "tsuru.io", "https://tsuru.com", "https://tsuru.com?a=b", true},
+		{"http://tsuru.io:8080/apps", "tsuru.io", "tsuru.com", "http://tsuru.io:8080/apps", false},
+		{"http://tsuru.io/apps", "https://tsuru.io", "tsuru.com", "http://tsuru.io/apps", false},
+		{"https://tsuru.io", "https://tsuru.io", "http://tsuru.com", "http://tsuru.com", true},
+		{"{{.Envs.host}}/apps", "tsuru.io", "tsuru.com", "{{.Envs.host}}/apps", false},
+	}
+	for _, tt := range tests {
+		u, ok := replaceHost(tt.url, tt.from, tt.to)
+		c.Check(u, check.Equals, tt.expected)
+		c.Check(ok, check.Equals, tt.replaced)
+	}
+}
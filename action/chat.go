@@ -0,0 +1,124 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+// chatMessage is the message posted by the chat actions, like slack and
+// teams, formatted by each chat platform: the rendered Subject and Message
+// of the action and the facts of the alarm event. Status is empty while the
+// event runs, and succeeded or failed in the notifications of finished
+// events.
+type chatMessage struct {
+	Title  string
+	Text   string
+	Facts  []chatFact
+	Status string
+}
+
+type chatFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// chatFormatter returns the webhook payload of a chat platform with the
+// message.
+type chatFormatter func(m *chatMessage) interface{}
+
+func validateChat(a *Action) ([]string, error) {
+	if a.URL == "" {
+		return nil, invalid("url required")
+	}
+	if a.Message == "" {
+		return nil, invalid("message required")
+	}
+	return []string{a.URL, a.Message, a.Subject}, nil
+}
+
+// chatSender returns the function preparing the execution posting the
+// message of the chat actions, formatted by format, to the webhook url.
+func chatSender(format chatFormatter) func(a *Action, ctx *Context) (*Prepared, error) {
+	return func(a *Action, ctx *Context) (*Prepared, error) {
+		url, err := Render(a.URL, ctx)
+		if err != nil {
+			return nil, err
+		}
+		m, err := a.chatMessage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		a.logger().Printf("action %s - %s message: %s", a.Name, a.Type, m.Text)
+		return a.jsonSender(ctx, url, format(m), nil)
+	}
+}
+
+// chatMessage renders the message of the action with ctx.
+func (a *Action) chatMessage(ctx *Context) (*chatMessage, error) {
+	title, err := Render(a.Subject, ctx)
+	if err != nil {
+		return nil, err
+	}
+	text, err := Render(a.Message, ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := chatMessage{Title: title, Text: text}
+	if !ctx.Event.EndTime.IsZero() {
+		m.Status = "failed"
+		if ctx.Event.Successful {
+			m.Status = "succeeded"
+		}
+	}
+	for _, f := range []chatFact{
+		{"App", ctx.App},
+		{"Alarm", ctx.Alarm.Name},
+		{"Event", ctx.Event.Type},
+		{"Status", m.Status},
+		{"Error", ctx.Event.Error},
+	} {
+		if f.Value != "" {
+			m.Facts = append(m.Facts, f)
+		}
+	}
+	return &m, nil
+}
+
+// slackMessage formats the message as a Slack incoming webhook payload,
+// with the title in bold.
+func slackMessage(m *chatMessage) interface{} {
+	text := m.Text
+	if m.Title != "" {
+		text = "*" + m.Title + "*\n" + text
+	}
+	return map[string]string{"text": text}
+}
+
+// teamsColors are the card colors of the event statuses.
+var teamsColors = map[string]string{
+	"":          "0076D7",
+	"succeeded": "2EB886",
+	"failed":    "A30200",
+}
+
+// teamsCard formats the message as a Microsoft Teams incoming webhook
+// message card, with the facts of the event and colored by its status.
+func teamsCard(m *chatMessage) interface{} {
+	summary := m.Title
+	if summary == "" {
+		summary = m.Text
+	}
+	card := map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    summary,
+		"themeColor": teamsColors[m.Status],
+		"text":       m.Text,
+	}
+	if m.Title != "" {
+		card["title"] = m.Title
+	}
+	if len(m.Facts) > 0 {
+		card["sections"] = []map[string]interface{}{{"facts": m.Facts}}
+	}
+	return card
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestExecuteSlack(c *check.C) {
+	var method, contentType string
+	var body map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		contentType = r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer ts.Close()
+	a := Action{
+		Name:    "notify",
+		Type:    TypeSlack,
+		URL:     ts.URL,
+		Message: `{{.Alarm.Name}} scaled {app} by {step}{{if .Event.Error}}: "{{.Event.Error}}"{{end}}`,
+	}
+	ctx := &Context{
+		App:   "myapp",
+		Envs:  map[string]string{"step": "2"},
+		Alarm: AlarmContext{Name: "cpu_high"},
+		Event: EventContext{Error: "timeout"},
+	}
+	attempts, err := a.Execute(ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(attempts, check.HasLen, 1)
+	c.Assert(method, check.Equals, "POST")
+	c.Assert(contentType, check.Equals, "application/json")
+	c.Assert(body, check.DeepEquals, map[string]string{"text": `cpu_high scaled myapp by 2: "timeout"`})
+}
+
+func (s *S) TestExecuteSlackTitle(c *check.C) {
+	var body map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer ts.Close()
+	a := Action{Name: "notify", Type: TypeSlack, URL: ts.URL, Subject: "{{.Alarm.Name}}", Message: "{app} scaled"}
+	_, err := a.Execute(&Context{App: "myapp", Alarm: AlarmContext{Name: "cpu_high"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(body, check.DeepEquals, map[string]string{"text": "*cpu_high*\nmyapp scaled"})
+}
+
+func (s *S) TestExecuteTeams(c *check.C) {
+	var contentType string
+	var body map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer ts.Close()
+	a := Action{
+		Name:    "notify",
+		Type:    TypeTeams,
+		URL:     ts.URL,
+		Subject: "{{.Alarm.Name}} fired",
+		Message: "{app} scaled by {step}",
+	}
+	ctx := &Context{
+		App:   "myapp",
+		Envs:  map[string]string{"step": "2"},
+		Alarm: AlarmContext{Name: "cpu_high"},
+		Event: EventContext{Type: "scale_up", EndTime: time.Now(), Error: "timeout"},
+	}
+	attempts, err := a.Execute(ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(attempts, check.HasLen, 1)
+	c.Assert(contentType, check.Equals, "application/json")
+	c.Assert(body, check.DeepEquals, map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    "cpu_high fired",
+		"title":      "cpu_high fired",
+		"themeColor": "A30200",
+		"text":       "myapp scaled by 2",
+		"sections": []interface{}{map[string]interface{}{"facts": []interface{}{
+			map[string]interface{}{"name": "App", "value": "myapp"},
+			map[string]interface{}{"name": "Alarm", "value": "cpu_high"},
+			map[string]interface{}{"name": "Event", "value": "scale_up"},
+			map[string]interface{}{"name": "Status", "value": "failed"},
+			map[string]interface{}{"name": "Error", "value": "timeout"},
+		}}},
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"errors"
+	"sync"
+)
+
+// Concurrency policies of actions with concurrency limit. Queue waits for
+// a running execution to finish and skip gives up.
+const (
+	ConcurrencyQueue = "queue"
+	ConcurrencySkip  = "skip"
+)
+
+// ErrConcurrencyLimit is returned by Acquire when the action is executed by
+// as many alarms as its concurrency limit and its policy is skip.
+var ErrConcurrencyLimit = errors.New("action: concurrency limit reached")
+
+var limits = struct {
+	sync.Mutex
+	slots map[string]chan struct{}
+}{slots: map[string]chan struct{}{}}
+
+func (a *Action) validateConcurrency() error {
+	if a.Concurrency < 0 {
+		return invalid("concurrency must not be negative")
+	}
+	switch a.ConcurrencyPolicy {
+	case "", ConcurrencyQueue, ConcurrencySkip:
+		return nil
+	}
+	return invalid("invalid concurrency policy %q", a.ConcurrencyPolicy)
+}
+
+// slots returns the semaphore limiting the executions of the action. It is
+// replaced when the action limit changes.
+func (a *Action) slots() chan struct{} {
+	limits.Lock()
+	defer limits.Unlock()
+	slots, ok := limits.slots[a.Name]
+	if !ok || cap(slots) != a.Concurrency {
+		slots = make(chan struct{}, a.Concurrency)
+		limits.slots[a.Name] = slots
+	}
+	return slots
+}
+
+// Acquire reserves one of the concurrent executions of the action, waiting
+// for one to be released or returning ErrConcurrencyLimit, depending on the
+// action policy. The returned function releases it. Actions without
+// concurrency limit are not limited. The executions are counted in memory,
+// so the limit applies to each autoscale process: with N replicas, up to N
+// times the limit may run at the same time.
+func (a *Action) Acquire() (func(), error) {
+	if a.Concurrency <= 0 {
+		return func() {}, nil
+	}
+	slots := a.slots()
+	if a.ConcurrencyPolicy == ConcurrencySkip {
+		select {
+		case slots <- struct{}{}:
+		default:
+			return nil, ErrConcurrencyLimit
+		}
+	} else {
+		slots <- struct{}{}
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-slots })
+	}, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAcquireSkip(c *check.C) {
+	a := Action{Name: "skip", Concurrency: 2, ConcurrencyPolicy: ConcurrencySkip}
+	release1, err := a.Acquire()
+	c.Assert(err, check.IsNil)
+	release2, err := a.Acquire()
+	c.Assert(err, check.IsNil)
+	_, err = a.Acquire()
+	c.Assert(err, check.Equals, ErrConcurrencyLimit)
+	release1()
+	release1()
+	release3, err := a.Acquire()
+	c.Assert(err, check.IsNil)
+	_, err = a.Acquire()
+	c.Assert(err, check.Equals, ErrConcurrencyLimit)
+	release2()
+	release3()
+}
+
+func (s *S) TestAcquireQueue(c *check.C) {
+	a := Action{Name: "queue", Concurrency: 1}
+	release, err := a.Acquire()
+	c.Assert(err, check.IsNil)
+	acquired := make(chan struct{})
+	go func() {
+		r, _ := a.Acquire()
+		close(acquired)
+		r()
+	}()
+	select {
+	case <-acquired:
+		c.Fatal("acquired while the limit was reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		c.Fatal("not acquired after release")
+	}
+}
+
+func (s *S) TestAcquireWithoutLimit(c *check.C) {
+	a := Action{Name: "unlimited"}
+	for i := 0; i < 10; i++ {
+		_, err := a.Acquire()
+		c.Assert(err, check.IsNil)
+	}
+}
+
+func (s *S) TestValidateConcurrency(c *check.C) {
+	a := Action{URL: "http://tsuru.io", Method: "POST", Concurrency: 2, ConcurrencyPolicy: ConcurrencySkip}
+	c.Assert(a.validate(), check.IsNil)
+	a.Concurrency = -1
+	c.Assert(a.validate(), check.ErrorMatches, "action: concurrency must not be negative")
+	a.Concurrency, a.ConcurrencyPolicy = 1, "drop"
+	c.Assert(a.validate(), check.ErrorMatches, `action: invalid concurrency policy "drop"`)
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DeadLetter is an execution of an action that failed after its retries,
+// stored to be inspected and replayed after an outage of the integration:
+// the rendered request, with its secrets redacted, the attempts made, the
+// error and the context the action was executed with, used by the replays.
+type DeadLetter struct {
+	ID       bson.ObjectId   `json:"id" bson:"_id"`
+	Action   string          `json:"action"`
+	Alarm    string          `json:"alarm,omitempty" bson:",omitempty"`
+	Event    string          `json:"event,omitempty" bson:",omitempty"`
+	Time     time.Time       `json:"time"`
+	Request  string          `json:"request"`
+	Attempts []Attempt       `json:"attempts"`
+	Error    string          `json:"error"`
+	Context  json.RawMessage `json:"context"`
+	Replays  int             `json:"replays,omitempty" bson:",omitempty"`
+}
+
+// ReplayResult is the result of a dead letter replay: the attempts made and
+// the execution error.
+type ReplayResult struct {
+	Attempts []Attempt `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// RecordDeadLetter stores the execution of the action with ctx in the dead
+// letters when it failed after making attempts. Executions failing before
+// sending the action, like the ones with invalid templates, are not stored.
+func RecordDeadLetter(a *Action, ctx *Context, attempts []Attempt, err error) error {
+	if err == nil || len(attempts) == 0 {
+		return nil
+	}
+	letter := DeadLetter{
+		ID:       bson.NewObjectId(),
+		Action:   a.Name,
+		Alarm:    ctx.Alarm.Name,
+		Event:    ctx.Event.ID,
+		Time:     time.Now().UTC(),
+		Attempts: attempts,
+		Error:    a.redactRequest(err.Error(), ctx),
+	}
+	// the action was rendered to make the attempts, so rendering it again
+	// only fails when a secret is gone, keeping the letter without request.
+	letter.Request, _ = a.renderRequest(ctx)
+	data, err := json.Marshal(ctx)
+	if err != nil {
+		logger().Error(err)
+		return err
+	}
+	letter.Context = data
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return err
+	}
+	defer conn.Close()
+	if err = conn.ActionDeadLetters().Insert(&letter); err != nil {
+		logger().Error(err)
+	}
+	return err
+}
+
+// FindDeadLetters returns the latest dead letters, optionally filtered by
+// action, up to limit.
+func FindDeadLetters(action string, limit int) ([]DeadLetter, error) {
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	defer conn.Close()
+	q := bson.M{}
+	if action != "" {
+		q["action"] = action
+	}
+	letters := []DeadLetter{}
+	err = conn.ActionDeadLetters().Find(q).Sort("-time").Limit(limit).All(&letters)
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	return letters, nil
+}
+
+// FindDeadLetter finds a dead letter by id.
+func FindDeadLetter(id string) (*DeadLetter, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, &db.NotFoundError{Resource: "dead letter", Name: id}
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	defer conn.Close()
+	var letter DeadLetter
+	err = conn.ActionDeadLetters().FindId(bson.ObjectIdHex(id)).One(&letter)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			err = &db.NotFoundError{Resource: "dead letter", Name: id}
+		}
+		logger().Error(err)
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// RemoveDeadLetter removes a dead letter, discarding its execution.
+func RemoveDeadLetter(id string) error {
+	letter, err := FindDeadLetter(id)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return err
+	}
+	defer conn.Close()
+	return conn.ActionDeadLetters().Remove(bson.M{"_id": letter.ID})
+}
+
+// ReplayDeadLetter executes the current definition of the action of the
+// dead letter again, with the context of the failed execution. Replayed
+// executions are recorded in the executions history, and the dead letter is
+// removed when the replay succeeds.
+func ReplayDeadLetter(id string) (*ReplayResult, error) {
+	letter, err := FindDeadLetter(id)
+	if err != nil {
+		return nil, err
+	}
+	a, err := FindByName(letter.Action)
+	if err != nil {
+		return nil, err
+	}
+	var ctx Context
+	decoder := json.NewDecoder(bytes.NewReader(letter.Context))
+	decoder.UseNumber()
+	if err = decoder.Decode(&ctx); err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	attempts, aErr := a.Execute(&ctx)
+	RecordExecutions(a, &ctx, attempts)
+	result := ReplayResult{Attempts: attempts}
+	if result.Attempts == nil {
+		result.Attempts = []Attempt{}
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	defer conn.Close()
+	if aErr == nil {
+		logger().Printf("action %s - dead letter %s replayed", a.Name, id)
+		return &result, conn.ActionDeadLetters().Remove(bson.M{"_id": letter.ID})
+	}
+	result.Error = a.redactRequest(aErr.Error(), &ctx)
+	update := bson.M{
+		"$set": bson.M{"attempts": attempts, "error": result.Error},
+		"$inc": bson.M{"replays": 1},
+	}
+	if err = conn.ActionDeadLetters().UpdateId(letter.ID, update); err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRecordDeadLetter(c *check.C) {
+	a := Action{Name: "notify", URL: "http://tsuru.io/{app}", Method: "POST", Secret: "mysecret", Body: "{data.cpu}"}
+	ctx := &Context{App: "myapp", Alarm: AlarmContext{Name: "cpu"}, Data: map[string]interface{}{"cpu": 95}}
+	err := RecordDeadLetter(&a, ctx, nil, errors.New("not sent"))
+	c.Assert(err, check.IsNil)
+	err = RecordDeadLetter(&a, ctx, []Attempt{{StatusCode: 200}}, nil)
+	c.Assert(err, check.IsNil)
+	letters, err := FindDeadLetters("", 10)
+	c.Assert(err, check.IsNil)
+	c.Assert(letters, check.HasLen, 0)
+	err = RecordDeadLetter(&a, ctx, []Attempt{{StatusCode: 502}}, errors.New("request with mysecret failed"))
+	c.Assert(err, check.IsNil)
+	letters, err = FindDeadLetters("notify", 10)
+	c.Assert(err, check.IsNil)
+	c.Assert(letters, check.HasLen, 1)
+	c.Assert(letters[0].Alarm, check.Equals, "cpu")
+	c.Assert(letters[0].Request, check.Equals, "POST http://tsuru.io/myapp\n\n95")
+	c.Assert(letters[0].Error, check.Equals, "request with ***** failed")
+	c.Assert(letters[0].Attempts, check.HasLen, 1)
+	letter, err := FindDeadLetter(letters[0].ID.Hex())
+	c.Assert(err, check.IsNil)
+	c.Assert(letter.Action, check.Equals, "notify")
+	_, err = FindDeadLetter("invalid")
+	c.Assert(err, check.ErrorMatches, `dead letter "invalid" not found`)
+}
+
+func (s *S) TestReplayDeadLetter(c *check.C) {
+	var paths []string
+	status := http.StatusBadGateway
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+	a := Action{Name: "scale_up", URL: ts.URL + "/{app}/{data.cpu.value}", Method: "POST"}
+	err := New(&a)
+	c.Assert(err, check.IsNil)
+	ctx := &Context{App: "myapp", Data: map[string]interface{}{"cpu": map[string]interface{}{"value": 95}}}
+	attempts, err := a.Execute(ctx)
+	c.Assert(err, check.NotNil)
+	err = RecordDeadLetter(&a, ctx, attempts, err)
+	c.Assert(err, check.IsNil)
+	letters, err := FindDeadLetters("scale_up", 10)
+	c.Assert(err, check.IsNil)
+	c.Assert(letters, check.HasLen, 1)
+	id := letters[0].ID.Hex()
+	result, err := ReplayDeadLetter(id)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Error, check.Not(check.Equals), "")
+	letter, err := FindDeadLetter(id)
+	c.Assert(err, check.IsNil)
+	c.Assert(letter.Replays, check.Equals, 1)
+	status = http.StatusOK
+	result, err = ReplayDeadLetter(id)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Error, check.Equals, "")
+	c.Assert(paths, check.DeepEquals, []string{"/myapp/95", "/myapp/95", "/myapp/95"})
+	_, err = FindDeadLetter(id)
+	c.Assert(err, check.ErrorMatches, `dead letter ".*" not found`)
+	executions, err := FindExecutions("scale_up", "", 10)
+	c.Assert(err, check.IsNil)
+	c.Assert(executions, check.HasLen, 2)
+}
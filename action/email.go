@@ -0,0 +1,158 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// sendMail is replaced in tests.
+var sendMail = sendMailTimeout
+
+// sendMailTimeout sends the message like smtp.SendMail, failing when the
+// conversation with the server takes longer than timeout.
+func sendMailTimeout(addr string, timeout time.Duration, auth smtp.Auth, from string, to []string, msg []byte) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err = c.Auth(auth); err != nil {
+				return err
+			}
+		}
+	}
+	if err = c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err = c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// smtpConfig is the SMTP server used by email actions, configured by the
+// AUTOSCALE_SMTP_ADDR, AUTOSCALE_SMTP_USERNAME, AUTOSCALE_SMTP_PASSWORD and
+// AUTOSCALE_SMTP_FROM environment variables.
+type smtpConfig struct {
+	addr     string
+	username string
+	password string
+	from     string
+}
+
+func loadSMTPConfig() (*smtpConfig, error) {
+	config := smtpConfig{
+		addr:     os.Getenv("AUTOSCALE_SMTP_ADDR"),
+		username: os.Getenv("AUTOSCALE_SMTP_USERNAME"),
+		password: os.Getenv("AUTOSCALE_SMTP_PASSWORD"),
+		from:     os.Getenv("AUTOSCALE_SMTP_FROM"),
+	}
+	if config.addr == "" || config.from == "" {
+		return nil, errors.New("action: smtp server not configured")
+	}
+	return &config, nil
+}
+
+func (c *smtpConfig) auth() smtp.Auth {
+	if c.username == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(c.addr)
+	if err != nil {
+		host = c.addr
+	}
+	return smtp.PlainAuth("", c.username, c.password, host)
+}
+
+func validateEmail(a *Action) ([]string, error) {
+	if len(a.To) == 0 {
+		return nil, invalid("to required")
+	}
+	return []string{a.Subject, a.Body}, nil
+}
+
+// emailSender returns the execution sending the rendered subject and body of
+// email actions.
+func (a *Action) emailSender(ctx *Context) (*Prepared, error) {
+	config, err := loadSMTPConfig()
+	if err != nil {
+		return nil, err
+	}
+	subject, err := Render(a.Subject, ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := Render(a.Body, ctx)
+	if err != nil {
+		return nil, err
+	}
+	a.logger().Printf("action %s - email to: %s - subject: %s", a.Name, strings.Join(a.To, ", "), subject)
+	message := emailMessage(config.from, a.To, subject, body)
+	send := func() (Attempt, error) {
+		attempt := Attempt{Time: time.Now().UTC()}
+		err := sendMail(config.addr, a.timeout(), config.auth(), config.from, a.To, message)
+		attempt.Duration = time.Since(attempt.Time)
+		if err != nil {
+			err = fmt.Errorf("action %q: sending email failed: %s", a.Name, err)
+			a.logger().Error(err)
+			attempt.Error = err.Error()
+		}
+		return attempt, err
+	}
+	return &Prepared{Request: string(message), Send: send}, nil
+}
+
+func emailMessage(from string, to []string, subject, body string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	body = strings.Replace(body, "\r\n", "\n", -1)
+	buf.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return buf.Bytes()
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"errors"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestExecuteEmail(c *check.C) {
+	os.Setenv("AUTOSCALE_SMTP_ADDR", "smtp.example.com:587")
+	os.Setenv("AUTOSCALE_SMTP_FROM", "autoscale@example.com")
+	defer os.Unsetenv("AUTOSCALE_SMTP_ADDR")
+	defer os.Unsetenv("AUTOSCALE_SMTP_FROM")
+	var addr, from string
+	var to []string
+	var msg []byte
+	var auth smtp.Auth
+	var timeout time.Duration
+	sendMail = func(a string, tm time.Duration, au smtp.Auth, f string, t []string, m []byte) error {
+		addr, timeout, auth, from, to, msg = a, tm, au, f, t, m
+		return nil
+	}
+	defer func() { sendMail = sendMailTimeout }()
+	a := Action{
+		Name:    "notify",
+		Type:    TypeEmail,
+		To:      []string{"team@example.com", "ops@example.com"},
+		Subject: "{{.Alarm.Name}} fired for {app}",
+		Body:    "Event {{.Event.Type}}\nstep: {step}",
+		Timeout: 5,
+	}
+	ctx := &Context{App: "myapp", Envs: map[string]string{"step": "2"}, Alarm: AlarmContext{Name: "cpu_high"}, Event: EventContext{Type: "scale_up"}}
+	attempts, err := a.Execute(ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(attempts, check.HasLen, 1)
+	c.Assert(addr, check.Equals, "smtp.example.com:587")
+	c.Assert(timeout, check.Equals, 5*time.Second)
+	c.Assert(auth, check.IsNil)
+	c.Assert(from, check.Equals, "autoscale@example.com")
+	c.Assert(to, check.DeepEquals, a.To)
+	message := string(msg)
+	c.Assert(strings.Contains(message, "To: team@example.com, ops@example.com\r\n"), check.Equals, true)
+	c.Assert(strings.Contains(message, "Subject: cpu_high fired for myapp\r\n"), check.Equals, true)
+	c.Assert(strings.HasSuffix(message, "\r\n\r\nEvent scale_up\r\nstep: 2"), check.Equals, true)
+}
+
+func (s *S) TestExecuteEmailFailure(c *check.C) {
+	os.Setenv("AUTOSCALE_SMTP_ADDR", "smtp.example.com:587")
+	os.Setenv("AUTOSCALE_SMTP_FROM", "autoscale@example.com")
+	os.Setenv("AUTOSCALE_SMTP_USERNAME", "autoscale")
+	defer os.Unsetenv("AUTOSCALE_SMTP_ADDR")
+	defer os.Unsetenv("AUTOSCALE_SMTP_FROM")
+	defer os.Unsetenv("AUTOSCALE_SMTP_USERNAME")
+	var auth smtp.Auth
+	sendMail = func(a string, tm time.Duration, au smtp.Auth, f string, t []string, m []byte) error {
+		auth = au
+		return errors.New("connection refused")
+	}
+	defer func() { sendMail = sendMailTimeout }()
+	a := Action{Name: "notify", Type: TypeEmail, To: []string{"team@example.com"}}
+	attempts, err := a.Execute(&Context{})
+	c.Assert(err, check.ErrorMatches, `action "notify": sending email failed: connection refused`)
+	c.Assert(attempts, check.HasLen, 1)
+	c.Assert(attempts[0].Error, check.Equals, err.Error())
+	c.Assert(auth, check.NotNil)
+}
+
+func (s *S) TestExecuteEmailWithoutSMTP(c *check.C) {
+	a := Action{Name: "notify", Type: TypeEmail, To: []string{"team@example.com"}}
+	_, err := a.Execute(&Context{})
+	c.Assert(err, check.ErrorMatches, "action: smtp server not configured")
+}
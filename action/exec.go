@@ -0,0 +1,100 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/tsuru"
+)
+
+// Limits of exec actions.
+const (
+	MaxCommandLength = 1024
+	MaxExecTimeout   = 10 * time.Minute
+	maxExecOutput    = 16 * 1024
+)
+
+// execEnabled returns whether exec actions can be executed, enabled by the
+// AUTOSCALE_EXEC_ENABLED environment variable.
+func execEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("AUTOSCALE_EXEC_ENABLED"))
+	return enabled
+}
+
+// validateExec validates exec actions. Commands are not idempotent, so
+// they are not retried, and are not rendered as templates, so the values of
+// the data sources can't be injected in them.
+func validateExec(a *Action) ([]string, error) {
+	if a.Command == "" {
+		return nil, invalid("command required")
+	}
+	if len(a.Command) > MaxCommandLength {
+		return nil, invalid("command must have at most %d characters", MaxCommandLength)
+	}
+	if a.Token == "" {
+		return nil, invalid("token required")
+	}
+	if a.Retries > 0 {
+		return nil, invalid("exec actions are not retried")
+	}
+	if time.Duration(a.Timeout)*time.Second > MaxExecTimeout {
+		return nil, invalid("timeout of exec actions must be at most %d seconds", int(MaxExecTimeout.Seconds()))
+	}
+	return []string{a.URL}, nil
+}
+
+// execSender returns the execution running the command of exec actions in
+// the app units, or in one of them when Once is set, using the tsuru api at
+// the action url, or TSURU_HOST by default. The output of the command,
+// truncated to 16KB, is recorded in the attempt.
+func (a *Action) execSender(ctx *Context) (*Prepared, error) {
+	if !execEnabled() {
+		return nil, fmt.Errorf("action %q: exec actions are disabled, set AUTOSCALE_EXEC_ENABLED to enable them", a.Name)
+	}
+	host, err := Render(a.URL, ctx)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := a.client()
+	if err != nil {
+		return nil, err
+	}
+	token, err := ctx.expandSecrets(a.Token)
+	if err != nil {
+		return nil, err
+	}
+	client := tsuru.Client{Host: host, Token: token, HTTPClient: httpClient}
+	units := "all units"
+	if a.Once {
+		units = "one unit"
+	}
+	a.logger().Printf("action %s - run %q in %s of app %s", a.Name, a.Command, units, ctx.App)
+	send := func() (Attempt, error) {
+		attempt := Attempt{Time: time.Now().UTC()}
+		output, truncated, err := client.Run(ctx.App, a.Command, a.Once, maxExecOutput)
+		attempt.Duration = time.Since(attempt.Time)
+		attempt.Output = output
+		if truncated {
+			attempt.Output += "\n[output truncated]"
+		}
+		attempt.SetResponse([]byte(output))
+		if err != nil {
+			if apiErr, ok := err.(*tsuru.APIError); ok {
+				attempt.StatusCode = apiErr.StatusCode
+			}
+			err = fmt.Errorf("action %q: run %q in app %q failed: %s", a.Name, a.Command, ctx.App, err)
+			a.logger().Error(err)
+			attempt.Error = err.Error()
+			return attempt, Permanent(err)
+		}
+		return attempt, nil
+	}
+	request := fmt.Sprintf("run %q in %s of app %s at %s", a.Command, units, ctx.App, host)
+	return &Prepared{Request: request, URL: host, Send: send}, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestValidateExec(c *check.C) {
+	tests := []struct {
+		a   Action
+		err error
+	}{
+		{Action{Type: TypeExec, Command: "./warm-cache", Token: "token"}, nil},
+		{Action{Type: TypeExec, Token: "token"}, &ValidationError{Message: "command required"}},
+		{Action{Type: TypeExec, Command: strings.Repeat("a", MaxCommandLength+1), Token: "token"}, &ValidationError{Message: "command must have at most 1024 characters"}},
+		{Action{Type: TypeExec, Command: "./warm-cache"}, &ValidationError{Message: "token required"}},
+		{Action{Type: TypeExec, Command: "./warm-cache", Token: "token", Retries: 1}, &ValidationError{Message: "exec actions are not retried"}},
+		{Action{Type: TypeExec, Command: "./warm-cache", Token: "token", Timeout: 601}, &ValidationError{Message: "timeout of exec actions must be at most 600 seconds"}},
+		{Action{Type: TypeExec, Command: "./warm-cache", Token: "token", ExpectedStatus: []int{200}}, &ValidationError{Message: "response validation is not supported by exec actions"}},
+	}
+	for _, tt := range tests {
+		c.Check(tt.a.validate(), check.DeepEquals, tt.err)
+	}
+}
+
+func (s *S) TestExecuteExec(c *check.C) {
+	var path, command string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		r.ParseForm()
+		command = r.PostForm.Get("command")
+		w.Write([]byte(`{"Message":"cache warmed\n"}` + "\n"))
+	}))
+	defer ts.Close()
+	a := Action{Name: "warm", Type: TypeExec, URL: ts.URL, Command: "./warm-cache {app}", Token: "token", Once: true}
+	_, err := a.Execute(&Context{App: "myapp"})
+	c.Assert(err, check.ErrorMatches, `action "warm": exec actions are disabled, .*`)
+	os.Setenv("AUTOSCALE_EXEC_ENABLED", "true")
+	defer os.Unsetenv("AUTOSCALE_EXEC_ENABLED")
+	attempts, err := a.Execute(&Context{App: "myapp"})
+	c.Assert(err, check.IsNil)
+	c.Assert(path, check.Equals, "/apps/myapp/run")
+	c.Assert(command, check.Equals, "./warm-cache {app}")
+	c.Assert(attempts, check.HasLen, 1)
+	c.Assert(attempts[0].Output, check.Equals, "cache warmed\n")
+}
+
+func (s *S) TestExecuteExecFailure(c *check.C) {
+	os.Setenv("AUTOSCALE_EXEC_ENABLED", "true")
+	defer os.Unsetenv("AUTOSCALE_EXEC_ENABLED")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Message":"warming\n"}` + "\n" + `{"Error":"exit status 1"}` + "\n"))
+	}))
+	defer ts.Close()
+	a := Action{Name: "warm", Type: TypeExec, URL: ts.URL, Command: "./warm-cache", Token: "token"}
+	attempts, err := a.Execute(&Context{App: "myapp"})
+	c.Assert(err, check.ErrorMatches, `action "warm": run "./warm-cache" in app "myapp" failed: tsuru: exit status 1 \(status 200\)`)
+	c.Assert(attempts, check.HasLen, 1)
+	c.Assert(attempts[0].Output, check.Equals, "warming\n")
+	c.Assert(attempts[0].StatusCode, check.Equals, http.StatusOK)
+}
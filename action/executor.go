@@ -0,0 +1,124 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"sort"
+	"sync"
+)
+
+// ActionExecutor executes the actions of a type. Validate checks the
+// fields of the action used by the type, returning the texts rendered as
+// templates, which are parsed by the action validation. Prepare renders
+// the action with ctx, returning the execution that sends it. Executions
+// are retried, dry run and recorded by Execute, like the built-in types.
+type ActionExecutor interface {
+	Validate(a *Action) ([]string, error)
+	Prepare(a *Action, ctx *Context) (*Prepared, error)
+}
+
+// Prepared is a rendered action execution: the description of its
+// request, shown in dry runs, the url it is sent to, recorded in the
+// executions history, and the function sending it, called on each attempt.
+type Prepared struct {
+	Request string
+	URL     string
+	Send    func() (Attempt, error)
+}
+
+// SetResponse sets the response of the attempt, used as the action output
+// and recorded in the executions history.
+func (a *Attempt) SetResponse(body []byte) {
+	a.body = body
+}
+
+// Permanent returns an error of an execution that is not retried, because
+// it would fail again.
+func Permanent(err error) error {
+	return &permanentError{err}
+}
+
+var executors = struct {
+	sync.RWMutex
+	m map[string]ActionExecutor
+}{m: map[string]ActionExecutor{}}
+
+// Register registers the executor of an action type, replacing the
+// executor registered before for the type, so embedders can add action
+// types, or replace the built-in ones, before using the actions.
+func Register(actionType string, e ActionExecutor) {
+	executors.Lock()
+	defer executors.Unlock()
+	executors.m[actionType] = e
+}
+
+// Types returns the registered action types.
+func Types() []string {
+	executors.RLock()
+	defer executors.RUnlock()
+	types := make([]string, 0, len(executors.m))
+	for t := range executors.m {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// EnabledTypes returns the action types which can be executed in this
+// installation: exec actions must be enabled and email actions need a
+// SMTP server.
+func EnabledTypes() []string {
+	var types []string
+	for _, t := range Types() {
+		if t == TypeExec && !execEnabled() {
+			continue
+		}
+		if t == TypeEmail {
+			if _, err := loadSMTPConfig(); err != nil {
+				continue
+			}
+		}
+		types = append(types, t)
+	}
+	return types
+}
+
+// executorFor returns the executor of the action type. Actions without
+// type are http actions.
+func executorFor(actionType string) (ActionExecutor, bool) {
+	if actionType == "" {
+		actionType = TypeHTTP
+	}
+	executors.RLock()
+	defer executors.RUnlock()
+	e, ok := executors.m[actionType]
+	return e, ok
+}
+
+// executorFuncs implements ActionExecutor with functions, used by the
+// built-in types.
+type executorFuncs struct {
+	validate func(a *Action) ([]string, error)
+	prepare  func(a *Action, ctx *Context) (*Prepared, error)
+}
+
+func (e executorFuncs) Validate(a *Action) ([]string, error) {
+	return e.validate(a)
+}
+
+func (e executorFuncs) Prepare(a *Action, ctx *Context) (*Prepared, error) {
+	return e.prepare(a, ctx)
+}
+
+func init() {
+	Register(TypeHTTP, executorFuncs{validateHTTP, (*Action).httpSender})
+	Register(TypeSlack, executorFuncs{validateChat, chatSender(slackMessage)})
+	Register(TypeTeams, executorFuncs{validateChat, chatSender(teamsCard)})
+	Register(TypeEmail, executorFuncs{validateEmail, (*Action).emailSender})
+	Register(TypePagerDuty, executorFuncs{validateIncident, (*Action).incidentSender})
+	Register(TypeOpsgenie, executorFuncs{validateIncident, (*Action).incidentSender})
+	Register(TypeTsuru, executorFuncs{validateTsuru, (*Action).tsuruSender})
+	Register(TypeExec, executorFuncs{validateExec, (*Action).execSender})
+}
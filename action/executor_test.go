@@ -0,0 +1,101 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+type topicExecutor struct {
+	sent []string
+	fail int
+}
+
+func (e *topicExecutor) Validate(a *Action) ([]string, error) {
+	if a.URL == "" {
+		return nil, errors.New("action: topic url required")
+	}
+	return []string{a.URL, a.Body}, nil
+}
+
+func (e *topicExecutor) Prepare(a *Action, ctx *Context) (*Prepared, error) {
+	message, err := Render(a.Body, ctx)
+	if err != nil {
+		return nil, err
+	}
+	send := func() (Attempt, error) {
+		attempt := Attempt{Time: time.Now().UTC()}
+		if e.fail > 0 {
+			e.fail--
+			attempt.Error = "unavailable"
+			return attempt, errors.New(attempt.Error)
+		}
+		e.sent = append(e.sent, message)
+		attempt.SetResponse([]byte(`{"offset": 42}`))
+		return attempt, nil
+	}
+	return &Prepared{Request: "publish " + message, URL: a.URL, Send: send}, nil
+}
+
+func (s *S) TestRegister(c *check.C) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+	e := &topicExecutor{fail: 1}
+	Register("topic", e)
+	defer func() {
+		executors.Lock()
+		delete(executors.m, "topic")
+		executors.Unlock()
+	}()
+	c.Assert(Types(), check.DeepEquals, []string{"email", "exec", "http", "opsgenie", "pagerduty", "slack", "teams", "topic", "tsuru"})
+	a := Action{Name: "publish", Type: "topic", Body: "{app} scaled", Retries: 1}
+	c.Assert(a.validate(), check.ErrorMatches, "action: topic url required")
+	a.URL = "kafka://broker/scales"
+	c.Assert(a.validate(), check.IsNil)
+	ctx := &Context{App: "myapp", Outputs: map[string]interface{}{}}
+	attempts, err := a.Execute(ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(attempts, check.HasLen, 2)
+	c.Assert(attempts[1].URL, check.Equals, "kafka://broker/scales")
+	c.Assert(e.sent, check.DeepEquals, []string{"myapp scaled"})
+	c.Assert(ctx.Outputs["publish"], check.NotNil)
+	e.fail = 2
+	_, err = a.Execute(ctx)
+	c.Assert(err, check.ErrorMatches, "unavailable")
+	e.fail = 1
+	Register("topic", executorFuncs{e.Validate, func(a *Action, ctx *Context) (*Prepared, error) {
+		p, err := e.Prepare(a, ctx)
+		if err != nil {
+			return nil, err
+		}
+		send := p.Send
+		p.Send = func() (Attempt, error) {
+			attempt, err := send()
+			if err != nil {
+				err = Permanent(err)
+			}
+			return attempt, err
+		}
+		return p, nil
+	}})
+	attempts, err = a.Execute(ctx)
+	c.Assert(err, check.ErrorMatches, "unavailable")
+	c.Assert(attempts, check.HasLen, 1)
+}
+
+func (s *S) TestEnabledTypes(c *check.C) {
+	c.Assert(EnabledTypes(), check.DeepEquals, []string{"http", "opsgenie", "pagerduty", "slack", "teams", "tsuru"})
+	os.Setenv("AUTOSCALE_EXEC_ENABLED", "true")
+	os.Setenv("AUTOSCALE_SMTP_ADDR", "localhost:25")
+	os.Setenv("AUTOSCALE_SMTP_FROM", "autoscale@example.com")
+	defer os.Unsetenv("AUTOSCALE_EXEC_ENABLED")
+	defer os.Unsetenv("AUTOSCALE_SMTP_ADDR")
+	defer os.Unsetenv("AUTOSCALE_SMTP_FROM")
+	c.Assert(EnabledTypes(), check.DeepEquals, Types())
+}
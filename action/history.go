@@ -0,0 +1,100 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// maxExecutionResponse is the maximum size, in bytes, of the responses
+// stored in the attempts and the executions history.
+const maxExecutionResponse = 4 << 10
+
+// Execution represents an attempt to execute an action, stored in the
+// executions history to debug the integrations.
+type Execution struct {
+	ID         bson.ObjectId `json:"id" bson:"_id"`
+	Action     string        `json:"action"`
+	Alarm      string        `json:"alarm,omitempty" bson:",omitempty"`
+	Event      string        `json:"event,omitempty" bson:",omitempty"`
+	Attempt    int           `json:"attempt"`
+	Time       time.Time     `json:"time"`
+	Duration   time.Duration `json:"duration"`
+	URL        string        `json:"url,omitempty" bson:",omitempty"`
+	StatusCode int           `json:"statusCode,omitempty" bson:",omitempty"`
+	Error      string        `json:"error,omitempty" bson:",omitempty"`
+	Response   string        `json:"response,omitempty" bson:",omitempty"`
+	DryRun     bool          `json:"dryRun,omitempty" bson:",omitempty"`
+}
+
+// RecordExecutions stores the attempts of an execution of the action with
+// ctx in the executions history.
+func RecordExecutions(a *Action, ctx *Context, attempts []Attempt) error {
+	if len(attempts) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(attempts))
+	for i, attempt := range attempts {
+		docs[i] = Execution{
+			ID:         bson.NewObjectId(),
+			Action:     a.Name,
+			Alarm:      ctx.Alarm.Name,
+			Event:      ctx.Event.ID,
+			Attempt:    i + 1,
+			Time:       attempt.Time,
+			Duration:   attempt.Duration,
+			URL:        attempt.URL,
+			StatusCode: attempt.StatusCode,
+			Error:      attempt.Error,
+			Response:   attempt.Response,
+			DryRun:     attempt.DryRun,
+		}
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return err
+	}
+	defer conn.Close()
+	err = conn.ActionExecutions().Insert(docs...)
+	if err != nil {
+		logger().Error(err)
+	}
+	return err
+}
+
+// response returns the response of the attempt, truncated to
+// maxExecutionResponse bytes.
+func (a *Attempt) response() string {
+	if len(a.body) > maxExecutionResponse {
+		return string(a.body[:maxExecutionResponse])
+	}
+	return string(a.body)
+}
+
+// FindExecutions returns the latest executions of the action, optionally
+// filtered by alarm, up to limit.
+func FindExecutions(action, alarm string, limit int) ([]Execution, error) {
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	defer conn.Close()
+	q := bson.M{"action": action}
+	if alarm != "" {
+		q["alarm"] = alarm
+	}
+	executions := []Execution{}
+	err = conn.ActionExecutions().Find(q).Sort("-time", "-attempt").Limit(limit).All(&executions)
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	return executions, nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRecordExecutions(c *check.C) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		w.Write([]byte(strings.Repeat("a", maxExecutionResponse+10)))
+	}))
+	defer ts.Close()
+	a := Action{Name: "scale_up", URL: ts.URL + "/units?app={app}", Method: "POST", Retries: 1}
+	ctx := &Context{App: "myapp", Alarm: AlarmContext{Name: "cpu"}, Event: EventContext{ID: "event"}}
+	attempts, err := a.Execute(ctx)
+	c.Assert(err, check.IsNil)
+	err = RecordExecutions(&a, ctx, attempts)
+	c.Assert(err, check.IsNil)
+	executions, err := FindExecutions("scale_up", "", 10)
+	c.Assert(err, check.IsNil)
+	c.Assert(executions, check.HasLen, 2)
+	c.Assert(executions[1].Attempt, check.Equals, 1)
+	c.Assert(executions[1].StatusCode, check.Equals, http.StatusBadGateway)
+	c.Assert(executions[1].URL, check.Equals, ts.URL+"/units?app=myapp")
+	c.Assert(executions[1].Alarm, check.Equals, "cpu")
+	c.Assert(executions[1].Event, check.Equals, "event")
+	c.Assert(executions[0].Attempt, check.Equals, 2)
+	c.Assert(executions[0].StatusCode, check.Equals, http.StatusOK)
+	c.Assert(executions[0].Response, check.HasLen, maxExecutionResponse)
+	executions, err = FindExecutions("scale_up", "other", 10)
+	c.Assert(err, check.IsNil)
+	c.Assert(executions, check.HasLen, 0)
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Incident operations of PagerDuty and Opsgenie actions. Event triggers an
+// incident when the event of the context failed and resolves it when the
+// event was successful, to be used in alarm notifications.
+const (
+	IncidentTrigger = "trigger"
+	IncidentResolve = "resolve"
+	IncidentEvent   = "event"
+)
+
+// Default incident api urls.
+const (
+	PagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	OpsgenieURL  = "https://api.opsgenie.com/v2/alerts"
+)
+
+const incidentSource = "tsuru-autoscale"
+
+// opsgeniePriorities maps the PagerDuty severities to Opsgenie priorities.
+var opsgeniePriorities = map[string]string{
+	"critical": "P1",
+	"error":    "P2",
+	"warning":  "P3",
+	"info":     "P4",
+}
+
+func validateIncident(a *Action) ([]string, error) {
+	if a.RoutingKey == "" {
+		return nil, invalid("routing_key required")
+	}
+	switch a.Incident {
+	case "", IncidentTrigger, IncidentResolve, IncidentEvent:
+	default:
+		return nil, invalid("invalid incident %q", a.Incident)
+	}
+	if _, ok := opsgeniePriorities[a.Severity]; a.Severity != "" && !ok {
+		return nil, invalid("invalid severity %q", a.Severity)
+	}
+	return []string{a.URL, a.Message}, nil
+}
+
+// dedupKey returns the key identifying the incidents of an alarm instance.
+func dedupKey(ctx *Context) string {
+	return fmt.Sprintf("autoscale:%s:%s", ctx.Alarm.Name, ctx.Alarm.Instance)
+}
+
+// incidentSender returns the execution creating or resolving the incident of
+// PagerDuty and Opsgenie actions.
+func (a *Action) incidentSender(ctx *Context) (*Prepared, error) {
+	operation := a.Incident
+	switch operation {
+	case "":
+		operation = IncidentTrigger
+	case IncidentEvent:
+		operation = IncidentTrigger
+		if ctx.Event.Successful {
+			operation = IncidentResolve
+		}
+	}
+	summary, err := Render(a.Message, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if summary == "" {
+		summary = fmt.Sprintf("alarm %s fired for %s", ctx.Alarm.Name, ctx.App)
+		if ctx.Event.Error != "" {
+			summary = fmt.Sprintf("alarm %s: %s of %s failed: %s", ctx.Alarm.Name, ctx.Event.Type, ctx.App, ctx.Event.Error)
+		}
+	}
+	u, err := Render(a.URL, ctx)
+	if err != nil {
+		return nil, err
+	}
+	severity := a.Severity
+	if severity == "" {
+		severity = "critical"
+	}
+	details := map[string]string{
+		"alarm":      ctx.Alarm.Name,
+		"instance":   ctx.Alarm.Instance,
+		"expression": ctx.Alarm.Expression,
+		"app":        ctx.App,
+		"event":      ctx.Event.Type,
+	}
+	if ctx.Event.Error != "" {
+		details["error"] = ctx.Event.Error
+	}
+	routingKey, err := ctx.expandSecrets(a.RoutingKey)
+	if err != nil {
+		return nil, err
+	}
+	key := dedupKey(ctx)
+	a.logger().Printf("action %s - %s %s incident %s: %s", a.Name, operation, a.Type, key, summary)
+	if a.Type == TypeOpsgenie {
+		if u == "" {
+			u = OpsgenieURL
+		}
+		headers := map[string]string{"Authorization": "GenieKey " + routingKey}
+		if operation == IncidentResolve {
+			alias := strings.Replace(url.QueryEscape(key), "+", "%20", -1)
+			u = strings.TrimRight(u, "/") + "/" + alias + "/close?identifierType=alias"
+			return a.jsonSender(ctx, u, map[string]string{"source": incidentSource}, headers)
+		}
+		if len(summary) > 130 {
+			summary = summary[:130]
+		}
+		alert := map[string]interface{}{
+			"message":  summary,
+			"alias":    key,
+			"priority": opsgeniePriorities[severity],
+			"source":   incidentSource,
+			"details":  details,
+		}
+		return a.jsonSender(ctx, u, alert, headers)
+	}
+	if u == "" {
+		u = PagerDutyURL
+	}
+	event := map[string]interface{}{
+		"routing_key":  routingKey,
+		"event_action": operation,
+		"dedup_key":    key,
+	}
+	if operation == IncidentTrigger {
+		event["payload"] = map[string]interface{}{
+			"summary":        summary,
+			"source":         incidentSource,
+			"severity":       severity,
+			"custom_details": details,
+		}
+	}
+	return a.jsonSender(ctx, u, event, nil)
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+type incidentRequest struct {
+	path          string
+	query         string
+	authorization string
+	body          map[string]interface{}
+}
+
+func incidentServer(requests *[]incidentRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := incidentRequest{path: r.URL.EscapedPath(), query: r.URL.RawQuery, authorization: r.Header.Get("Authorization")}
+		json.NewDecoder(r.Body).Decode(&req.body)
+		*requests = append(*requests, req)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+}
+
+func incidentContext() *Context {
+	return &Context{
+		App:   "myapp",
+		Alarm: AlarmContext{Name: "cpu high", Instance: "myinstance"},
+		Event: EventContext{Type: "scale_up"},
+	}
+}
+
+func (s *S) TestExecutePagerDuty(c *check.C) {
+	var requests []incidentRequest
+	ts := incidentServer(&requests)
+	defer ts.Close()
+	a := Action{Name: "page", Type: TypePagerDuty, URL: ts.URL, RoutingKey: "key", Severity: "warning"}
+	_, err := a.Execute(incidentContext())
+	c.Assert(err, check.IsNil)
+	a.Incident = IncidentResolve
+	_, err = a.Execute(incidentContext())
+	c.Assert(err, check.IsNil)
+	c.Assert(requests, check.HasLen, 2)
+	c.Assert(requests[0].body["routing_key"], check.Equals, "key")
+	c.Assert(requests[0].body["event_action"], check.Equals, "trigger")
+	c.Assert(requests[0].body["dedup_key"], check.Equals, "autoscale:cpu high:myinstance")
+	payload := requests[0].body["payload"].(map[string]interface{})
+	c.Assert(payload["summary"], check.Equals, "alarm cpu high fired for myapp")
+	c.Assert(payload["severity"], check.Equals, "warning")
+	c.Assert(requests[1].body["event_action"], check.Equals, "resolve")
+	c.Assert(requests[1].body["dedup_key"], check.Equals, "autoscale:cpu high:myinstance")
+	c.Assert(requests[1].body["payload"], check.IsNil)
+}
+
+func (s *S) TestExecutePagerDutyEvent(c *check.C) {
+	var requests []incidentRequest
+	ts := incidentServer(&requests)
+	defer ts.Close()
+	a := Action{Name: "page", Type: TypePagerDuty, URL: ts.URL, RoutingKey: "key", Incident: IncidentEvent}
+	ctx := incidentContext()
+	ctx.Event.Error = "timeout"
+	_, err := a.Execute(ctx)
+	c.Assert(err, check.IsNil)
+	ctx = incidentContext()
+	ctx.Event.Successful = true
+	_, err = a.Execute(ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(requests, check.HasLen, 2)
+	c.Assert(requests[0].body["event_action"], check.Equals, "trigger")
+	payload := requests[0].body["payload"].(map[string]interface{})
+	c.Assert(payload["summary"], check.Equals, "alarm cpu high: scale_up of myapp failed: timeout")
+	c.Assert(payload["severity"], check.Equals, "critical")
+	c.Assert(requests[1].body["event_action"], check.Equals, "resolve")
+}
+
+func (s *S) TestExecuteOpsgenie(c *check.C) {
+	var requests []incidentRequest
+	ts := incidentServer(&requests)
+	defer ts.Close()
+	a := Action{Name: "page", Type: TypeOpsgenie, URL: ts.URL + "/v2/alerts", RoutingKey: "key", Message: "{{.Alarm.Name}} on {app}"}
+	_, err := a.Execute(incidentContext())
+	c.Assert(err, check.IsNil)
+	a.Incident = IncidentResolve
+	_, err = a.Execute(incidentContext())
+	c.Assert(err, check.IsNil)
+	c.Assert(requests, check.HasLen, 2)
+	c.Assert(requests[0].authorization, check.Equals, "GenieKey key")
+	c.Assert(requests[0].path, check.Equals, "/v2/alerts")
+	c.Assert(requests[0].body["message"], check.Equals, "cpu high on myapp")
+	c.Assert(requests[0].body["alias"], check.Equals, "autoscale:cpu high:myinstance")
+	c.Assert(requests[0].body["priority"], check.Equals, "P1")
+	c.Assert(requests[1].authorization, check.Equals, "GenieKey key")
+	c.Assert(requests[1].path, check.Equals, "/v2/alerts/autoscale%3Acpu%20high%3Amyinstance/close")
+	c.Assert(requests[1].query, check.Equals, "identifierType=alias")
+}
+
+func (s *S) TestValidateIncident(c *check.C) {
+	tests := []struct {
+		a   Action
+		err string
+	}{
+		{Action{Type: TypePagerDuty, RoutingKey: "key"}, ""},
+		{Action{Type: TypeOpsgenie, RoutingKey: "key", Incident: IncidentEvent, Severity: "info"}, ""},
+		{Action{Type: TypePagerDuty}, "action: routing_key required"},
+		{Action{Type: TypePagerDuty, RoutingKey: "key", Incident: "close"}, `action: invalid incident "close"`},
+		{Action{Type: TypeOpsgenie, RoutingKey: "key", Severity: "high"}, `action: invalid severity "high"`},
+	}
+	for _, tt := range tests {
+		err := tt.a.validate()
+		if tt.err == "" {
+			c.Check(err, check.IsNil)
+		} else {
+			c.Check(err, check.ErrorMatches, tt.err)
+		}
+	}
+}
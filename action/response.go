@@ -0,0 +1,125 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// maxResponseSize is the maximum size, in bytes, of the response bodies
+// read by the response expressions and used as action output.
+const maxResponseSize = 1 << 20
+
+// ResponseExpressionTimeout is the maximum time a response expression can
+// run.
+const ResponseExpressionTimeout = time.Second
+
+var errResponseExpressionTimeout = errors.New("timeout")
+
+func (a *Action) validateResponseChecks() error {
+	if len(a.ExpectedStatus) == 0 && a.ResponseExpression == "" {
+		return nil
+	}
+	if a.Type == TypeEmail || a.Type == TypeTsuru || a.Type == TypeExec {
+		return invalid("response validation is not supported by %s actions", a.Type)
+	}
+	for _, status := range a.ExpectedStatus {
+		if status < 100 || status > 599 {
+			return invalid("invalid expected status %d", status)
+		}
+	}
+	if a.ResponseExpression != "" {
+		if _, err := otto.New().Compile("response_expression", "("+a.ResponseExpression+")"); err != nil {
+			return invalid("invalid response expression: %s", err)
+		}
+	}
+	return nil
+}
+
+// checkResponse validates the response of a request, with its body. Responses with a 429
+// or 5xx status not expected by the action fail and may be retried. Responses
+// with other unexpected statuses, or not matching the response expression,
+// fail permanently.
+func (a *Action) checkResponse(resp *http.Response, body []byte) error {
+	retryable := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+	expected := len(a.ExpectedStatus) == 0 && !retryable
+	for _, status := range a.ExpectedStatus {
+		expected = expected || status == resp.StatusCode
+	}
+	if !expected {
+		err := fmt.Errorf("action %q: request failed with status %d", a.Name, resp.StatusCode)
+		if !retryable {
+			return &permanentError{err}
+		}
+		return err
+	}
+	if a.ResponseExpression == "" {
+		return nil
+	}
+	ok, err := evalResponse(a.ResponseExpression, resp.StatusCode, body)
+	if err != nil {
+		return &permanentError{fmt.Errorf("action %q: response expression failed: %s", a.Name, err)}
+	}
+	if !ok {
+		return &permanentError{fmt.Errorf("action %q: response does not match %s", a.Name, a.ResponseExpression)}
+	}
+	return nil
+}
+
+// evalResponse evaluates the response expression with the status, the
+// body and the response, the body parsed as JSON or the body itself when it
+// is not JSON. Expressions running for more than ResponseExpressionTimeout
+// are interrupted and fail.
+func evalResponse(expression string, status int, body []byte) (ok bool, err error) {
+	vm := otto.New()
+	vm.Interrupt = make(chan func(), 1)
+	timer := time.AfterFunc(ResponseExpressionTimeout, func() {
+		vm.Interrupt <- func() {
+			panic(errResponseExpressionTimeout)
+		}
+	})
+	defer timer.Stop()
+	defer func() {
+		if r := recover(); r != nil {
+			if r != errResponseExpressionTimeout {
+				panic(r)
+			}
+			ok, err = false, fmt.Errorf("exceeded %s", ResponseExpressionTimeout)
+		}
+	}()
+	if err := vm.Set("status", status); err != nil {
+		return false, err
+	}
+	if err := vm.Set("body", string(body)); err != nil {
+		return false, err
+	}
+	if _, err := vm.Run("var response; try { response = JSON.parse(body); } catch (e) { response = body; }"); err != nil {
+		return false, err
+	}
+	value, err := vm.Run("(" + expression + ")")
+	if err != nil {
+		return false, err
+	}
+	return value.ToBoolean()
+}
+
+// output returns the output of an action response: the body parsed as JSON,
+// or the body itself when it is not JSON.
+func output(body []byte) interface{} {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return string(body)
+	}
+	return v
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestExecuteExpectedStatus(c *check.C) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	a := Action{Name: "scale_up", URL: ts.URL, Method: "POST", ExpectedStatus: []int{http.StatusAccepted}, Retries: 2}
+	attempts, err := a.Execute(&Context{})
+	c.Assert(err, check.ErrorMatches, `action "scale_up": request failed with status 200`)
+	c.Assert(calls, check.Equals, 1)
+	c.Assert(attempts, check.HasLen, 1)
+	a.ExpectedStatus = []int{http.StatusOK, http.StatusAccepted}
+	_, err = a.Execute(&Context{})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestExecuteResponseExpression(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ok" {
+			w.Write([]byte(`{"status": "ok"}`))
+			return
+		}
+		w.Write([]byte(`{"status": "error", "message": "quota exceeded"}`))
+	}))
+	defer ts.Close()
+	a := Action{Name: "scale_up", URL: ts.URL + "/ok", Method: "POST", ResponseExpression: `status == 200 && response.status == "ok"`}
+	_, err := a.Execute(&Context{})
+	c.Assert(err, check.IsNil)
+	a.URL = ts.URL + "/error"
+	attempts, err := a.Execute(&Context{})
+	c.Assert(err, check.ErrorMatches, `action "scale_up": response does not match status == 200 && response.status == "ok"`)
+	c.Assert(attempts[0].Error, check.Equals, err.Error())
+	a.ResponseExpression = `body.indexOf("quota") < 0`
+	_, err = a.Execute(&Context{})
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestEvalResponse(c *check.C) {
+	ok, err := evalResponse(`response == "done"`, 200, []byte("done"))
+	c.Assert(err, check.IsNil)
+	c.Assert(ok, check.Equals, true)
+	ok, err = evalResponse(`response.units > 2`, 200, []byte(`{"units": 3}`))
+	c.Assert(err, check.IsNil)
+	c.Assert(ok, check.Equals, true)
+	_, err = evalResponse(`response.a.b`, 200, []byte(`{}`))
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestEvalResponseTimeout(c *check.C) {
+	ok, err := evalResponse(`(function() { while (true) {} })()`, 200, []byte("done"))
+	c.Assert(err, check.ErrorMatches, "exceeded 1s")
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *S) TestValidateResponseChecks(c *check.C) {
+	a := Action{URL: "http://tsuru.io", Method: "POST", ExpectedStatus: []int{200, 202}, ResponseExpression: "response.ok"}
+	c.Assert(a.validate(), check.IsNil)
+	a.ExpectedStatus = []int{2000}
+	c.Assert(a.validate(), check.ErrorMatches, "action: invalid expected status 2000")
+	a.ExpectedStatus = nil
+	a.ResponseExpression = "response.ok ==="
+	c.Assert(a.validate(), check.ErrorMatches, "action: invalid response expression: .*")
+	a = Action{Type: TypeTsuru, Scale: ScaleUp, Token: "token", ExpectedStatus: []int{200}}
+	c.Assert(a.validate(), check.ErrorMatches, "action: response validation is not supported by tsuru actions")
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/secret"
+)
+
+// Headers of signed action requests.
+const (
+	TimestampHeader = "X-Autoscale-Timestamp"
+	SignatureHeader = "X-Autoscale-Signature"
+)
+
+// Sign returns the signature of an action request: the hex encoded
+// HMAC-SHA256, keyed by the action secret, of the unix timestamp, the
+// method, the request uri (path and query) and the body, separated by
+// new lines. Receivers must compute it, compare it with the signature
+// header and reject old timestamps, to prevent replays.
+func Sign(key string, timestamp int64, method, requestURI, body string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "\n" + method + "\n" + requestURI + "\n" + body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sign adds the timestamp and signature headers to the request when the
+// action has a secret.
+func (a *Action) sign(req *http.Request, body string) {
+	if a.Secret == "" {
+		return
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(a.Secret, timestamp, req.Method, req.URL.RequestURI(), body))
+}
+
+// transformSecrets replaces the action secrets, the signing secret, the
+// incident routing key and the tsuru token, by the result of fn.
+func (a *Action) transformSecrets(fn func(string) (string, error)) error {
+	var err error
+	if a.Secret, err = fn(a.Secret); err != nil {
+		return err
+	}
+	if a.RoutingKey, err = fn(a.RoutingKey); err != nil {
+		return err
+	}
+	a.Token, err = fn(a.Token)
+	return err
+}
+
+// Redacted returns a copy of the action with its secrets redacted, to be
+// shown to users.
+func (a *Action) Redacted() *Action {
+	c := *a
+	c.transformSecrets(func(value string) (string, error) {
+		return secret.Redact(value), nil
+	})
+	return &c
+}
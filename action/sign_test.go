@@ -0,0 +1,73 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/secret"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestSign(c *check.C) {
+	signature := Sign("secret", 1500000000, "POST", "/apps/myapp/units?process=web", "units=1")
+	c.Assert(signature, check.Equals, Sign("secret", 1500000000, "POST", "/apps/myapp/units?process=web", "units=1"))
+	c.Assert(signature, check.Matches, "sha256=[0-9a-f]{64}")
+	c.Assert(Sign("secret", 1500000001, "POST", "/apps/myapp/units?process=web", "units=1"), check.Not(check.Equals), signature)
+	c.Assert(Sign("other", 1500000000, "POST", "/apps/myapp/units?process=web", "units=1"), check.Not(check.Equals), signature)
+	c.Assert(Sign("secret", 1500000000, "POST", "/apps/other/units?process=web", "units=1"), check.Not(check.Equals), signature)
+}
+
+func (s *S) TestExecuteSignsRequests(c *check.C) {
+	var valid bool
+	var timestamp int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp, _ = strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		expected := Sign("secret", timestamp, r.Method, r.URL.RequestURI(), string(body))
+		valid = r.Header.Get(SignatureHeader) == expected
+	}))
+	defer ts.Close()
+	a := Action{URL: ts.URL + "/apps/{app}/units?units={step}", Method: "PUT", Body: "process=web", Secret: "secret"}
+	_, err := a.Execute(&Context{App: "myapp", Envs: map[string]string{"step": "2"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(valid, check.Equals, true)
+	c.Assert(time.Now().Unix()-timestamp < 5, check.Equals, true)
+}
+
+func (s *S) TestExecuteWithoutSecret(c *check.C) {
+	var signature string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer ts.Close()
+	a := Action{URL: ts.URL, Method: "POST"}
+	_, err := a.Execute(&Context{})
+	c.Assert(err, check.IsNil)
+	c.Assert(signature, check.Equals, "")
+}
+
+func (s *S) TestNewEncryptsSecret(c *check.C) {
+	os.Setenv("AUTOSCALE_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	defer os.Unsetenv("AUTOSCALE_ENCRYPTION_KEY")
+	a := Action{Name: "notify", URL: "http://tsuru.io", Method: "POST", Secret: "secret"}
+	err := New(&a)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Secret, check.Equals, "secret")
+	var stored Action
+	err = s.conn.Actions().Find(bson.M{"name": "notify"}).One(&stored)
+	c.Assert(err, check.IsNil)
+	c.Assert(secret.IsEncrypted(stored.Secret), check.Equals, true)
+	found, err := FindByName("notify")
+	c.Assert(err, check.IsNil)
+	c.Assert(found.Secret, check.Equals, "secret")
+	c.Assert(found.Redacted().Secret, check.Equals, secret.Redacted)
+}
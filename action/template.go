@@ -0,0 +1,242 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/secret"
+)
+
+// Context is the data available to the action url and body templates:
+// the app name, the alarm envs, the alarm and event that triggered the
+// action, the data of the alarm data sources, by data source name, and the
+// outputs of the actions executed before, by action name.
+type Context struct {
+	App     string
+	Envs    map[string]string
+	Alarm   AlarmContext
+	Event   EventContext
+	Data    map[string]interface{}
+	Outputs map[string]interface{}
+
+	// secrets are the values of the {secret.NAME} placeholders resolved
+	// while rendering, redacted from the request descriptions.
+	secrets []string
+}
+
+// AlarmContext describes the alarm that triggered the action.
+type AlarmContext struct {
+	Name       string
+	Instance   string
+	Expression string
+	Envs       map[string]string
+}
+
+// EventContext describes the event of the action execution. Type is the
+// action name, like scale_up. EndTime, Successful and Error are set for the
+// notifications of finished events.
+type EventContext struct {
+	ID         string
+	Type       string
+	StartTime  time.Time
+	EndTime    time.Time
+	Successful bool
+	Error      string
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"unix": func(t time.Time) int64 {
+		return t.Unix()
+	},
+	"rfc3339": func(t time.Time) string {
+		return t.Format(time.RFC3339)
+	},
+}
+
+// parseTemplate parses text as an action template. Texts without template
+// actions are not parsed.
+func parseTemplate(text string) (*template.Template, error) {
+	if !strings.Contains(text, "{{") {
+		return nil, nil
+	}
+	return template.New("action").Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// Render executes text as a template using ctx and replaces the {app},
+// envs, data and {secret.NAME} placeholders. The placeholders are only
+// replaced in the text of the action, out of the template actions, and the
+// values inserted by the template and by the placeholders are final: they
+// are not scanned for placeholders again, so the data of the data sources
+// and the envs of the alarms can not resolve secrets.
+func Render(text string, ctx *Context) (string, error) {
+	var values []string
+	var err error
+	expand := func(segment string) string {
+		return placeholders.ReplaceAllStringFunc(segment, func(p string) string {
+			if err != nil {
+				return p
+			}
+			value, ok, lookupErr := ctx.placeholder(p[1 : len(p)-1])
+			if lookupErr != nil {
+				err = lookupErr
+			}
+			if !ok || lookupErr != nil {
+				return p
+			}
+			values = append(values, value)
+			return fmt.Sprintf("{{placeholder %d}}", len(values)-1)
+		})
+	}
+	var rendered bytes.Buffer
+	rest := text
+	for {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			rendered.WriteString(expand(rest))
+			break
+		}
+		end := strings.Index(rest[start:], "}}")
+		if end < 0 {
+			rendered.WriteString(expand(rest[:start]))
+			rendered.WriteString(rest[start:])
+			break
+		}
+		end += start + len("}}")
+		rendered.WriteString(expand(rest[:start]))
+		rendered.WriteString(rest[start:end])
+		rest = rest[end:]
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(values) == 0 && !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	funcs := template.FuncMap{"placeholder": func(i int) string {
+		return values[i]
+	}}
+	tmpl, err := template.New("action").Funcs(templateFuncs).Funcs(funcs).Option("missingkey=zero").Parse(rendered.String())
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, ctx); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// placeholders matches the candidates of placeholders, like {app}, resolved
+// by Context.placeholder.
+var placeholders = regexp.MustCompile(`\{[^{}]*\}`)
+
+// dataPlaceholder matches the names of the {data.NAME.path} placeholders,
+// where path is a dot separated list of object keys and array indexes.
+var dataPlaceholder = regexp.MustCompile(`^data\.([A-Za-z_$][A-Za-z0-9_$]*)((?:\.[A-Za-z0-9_$-]+)*)$`)
+
+// secretPlaceholder matches the names of the {secret.NAME} placeholders.
+var secretPlaceholder = regexp.MustCompile(`^secret\.([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// placeholder returns the value of the placeholder name, in order: {app},
+// the alarm envs, {value}, the data of the only data source of the alarm,
+// {data.NAME.path}, the value at the path of the data of the data source
+// NAME, and {secret.NAME}, the secret NAME, kept to be redacted. Strings
+// and numbers of the data are replaced by their values, and other values
+// are encoded as JSON. Unknown names are not placeholders.
+func (ctx *Context) placeholder(name string) (string, bool, error) {
+	if name == "app" {
+		return ctx.App, true, nil
+	}
+	if value, ok := ctx.Envs[name]; ok {
+		return value, true, nil
+	}
+	if name == "value" {
+		if len(ctx.Data) != 1 {
+			return "", false, nil
+		}
+		for _, value := range ctx.Data {
+			formatted, err := formatData(value)
+			return formatted, true, err
+		}
+	}
+	if match := dataPlaceholder.FindStringSubmatch(name); match != nil {
+		value, ok := ctx.Data[match[1]]
+		if ok && match[2] != "" {
+			value, ok = lookupData(value, strings.Split(match[2][1:], "."))
+		}
+		if !ok {
+			return "", false, fmt.Errorf("action: data placeholder {%s} not found", name)
+		}
+		formatted, err := formatData(value)
+		return formatted, true, err
+	}
+	if match := secretPlaceholder.FindStringSubmatch(name); match != nil {
+		value, err := secret.Lookup(match[1])
+		if err != nil {
+			return "", false, err
+		}
+		if value != "" {
+			ctx.secrets = append(ctx.secrets, value)
+		}
+		return value, true, nil
+	}
+	return "", false, nil
+}
+
+// lookupData returns the value at the path of keys and array indexes.
+func lookupData(value interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func formatData(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// expandSecrets replaces the {secret.NAME} placeholders in text, keeping
+// the values to redact them.
+func (ctx *Context) expandSecrets(text string) (string, error) {
+	text, values, err := secret.Expand(text)
+	if err != nil {
+		return "", err
+	}
+	ctx.secrets = append(ctx.secrets, values...)
+	return text, nil
+}
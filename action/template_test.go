@@ -0,0 +1,159 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRender(c *check.C) {
+	ctx := &Context{
+		App:   "myapp",
+		Envs:  map[string]string{"step": "2"},
+		Alarm: AlarmContext{Name: "scale_up_myapp", Instance: "myinstance"},
+		Event: EventContext{ID: "abc", Type: "scale_up", StartTime: time.Date(2017, 5, 1, 10, 0, 0, 0, time.UTC)},
+		Data:  map[string]interface{}{"cpu": map[string]interface{}{"value": 95}},
+	}
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"units={step}&app={app}", "units=2&app=myapp"},
+		{`{"alarm": "{{.Alarm.Name}}", "at": "{{rfc3339 .Event.StartTime}}", "cpu": {{.Data.cpu.value}}, "step": {step}}`, `{"alarm": "scale_up_myapp", "at": "2017-05-01T10:00:00Z", "cpu": 95, "step": 2}`},
+		{`{{json .Data}} {{.Event.Type}} {{unix .Event.StartTime}}`, `{"cpu":{"value":95}} scale_up 1493632800`},
+	}
+	for _, tt := range tests {
+		result, err := Render(tt.text, ctx)
+		c.Check(err, check.IsNil)
+		c.Check(result, check.Equals, tt.expected)
+	}
+	_, err := Render("{{.Unknown}}", ctx)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestRenderDataPlaceholders(c *check.C) {
+	ctx := &Context{
+		Data: map[string]interface{}{
+			"cpu": map[string]interface{}{"value": json.Number("95.5"), "samples": []interface{}{json.Number("90"), json.Number("95.5")}, "host": "node1"},
+		},
+	}
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"cpu={data.cpu.value}&host={data.cpu.host}", "cpu=95.5&host=node1"},
+		{"{data.cpu.samples.1} {data.cpu.samples}", "95.5 [90,95.5]"},
+		{"{{.Data.cpu.host}}: {value}", `node1: {"host":"node1","samples":[90,95.5],"value":95.5}`},
+	}
+	for _, tt := range tests {
+		result, err := Render(tt.text, ctx)
+		c.Check(err, check.IsNil)
+		c.Check(result, check.Equals, tt.expected)
+	}
+	for _, text := range []string{"{data.mem.value}", "{data.cpu.unknown}", "{data.cpu.samples.2}", "{data.cpu.value.max}"} {
+		_, err := Render(text, ctx)
+		c.Check(err, check.ErrorMatches, `action: data placeholder \{data\..*\} not found`)
+	}
+	ctx.Data["mem"] = json.Number("512")
+	result, err := Render("{value}", ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "{value}")
+}
+
+func (s *S) TestRenderDoesNotExpandSecretsInValues(c *check.C) {
+	os.Setenv("AUTOSCALE_SECRET_API_TOKEN", "mytoken")
+	defer os.Unsetenv("AUTOSCALE_SECRET_API_TOKEN")
+	ctx := &Context{
+		App:   "myapp",
+		Alarm: AlarmContext{Name: "{secret.API_TOKEN}"},
+		Envs:  map[string]string{"reason": "{secret.API_TOKEN}"},
+		Data:  map[string]interface{}{"cpu": map[string]interface{}{"host": "{secret.API_TOKEN}"}},
+	}
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"{value}", `{"host":"{secret.API_TOKEN}"}`},
+		{"{data.cpu.host}", "{secret.API_TOKEN}"},
+		{"{reason}", "{secret.API_TOKEN}"},
+		{"{{.Alarm.Name}}", "{secret.API_TOKEN}"},
+		{"{{.Data.cpu.host}}: {secret.API_TOKEN}", "{secret.API_TOKEN}: mytoken"},
+	}
+	for _, tt := range tests {
+		result, err := Render(tt.text, ctx)
+		c.Check(err, check.IsNil)
+		c.Check(result, check.Equals, tt.expected)
+	}
+}
+
+func (s *S) TestRenderDoesNotExpandPlaceholdersInData(c *check.C) {
+	ctx := &Context{
+		App:  "myapp",
+		Envs: map[string]string{"step": "2"},
+		Data: map[string]interface{}{"cpu": map[string]interface{}{"host": "{app}/{step}", "query": "{data.mem.value}"}},
+	}
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"{data.cpu.host}", "{app}/{step}"},
+		{"{data.cpu.query}", "{data.mem.value}"},
+		{"{{.Data.cpu.query}} {app}", "{data.mem.value} myapp"},
+		{"{value}", `{"host":"{app}/{step}","query":"{data.mem.value}"}`},
+	}
+	for _, tt := range tests {
+		result, err := Render(tt.text, ctx)
+		c.Check(err, check.IsNil)
+		c.Check(result, check.Equals, tt.expected)
+	}
+}
+
+func (s *S) TestExecuteRendersTemplates(c *check.C) {
+	var body, path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body, path = string(data), r.URL.Path
+	}))
+	defer ts.Close()
+	a := Action{Name: "notify", URL: ts.URL + "/{{.Alarm.Instance}}/{app}", Method: "POST", Body: `{"reason": "{{.Alarm.Name}}"}`}
+	_, err := a.Execute(&Context{App: "myapp", Alarm: AlarmContext{Name: "scale_up_myapp", Instance: "myinstance"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(path, check.Equals, "/myinstance/myapp")
+	c.Assert(body, check.Equals, `{"reason": "scale_up_myapp"}`)
+}
+
+func (s *S) TestNewInvalidTemplate(c *check.C) {
+	err := New(&Action{URL: "http://tsuru.io", Method: "POST", Body: "{{.Alarm.Name"})
+	c.Assert(err, check.ErrorMatches, `action: invalid template: .*`)
+}
+
+func (s *S) TestExecuteOutputs(c *check.C) {
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/deployments/latest" {
+			w.Write([]byte(`{"id": "d-42", "units": 3}`))
+			return
+		}
+		path = r.URL.Path
+	}))
+	defer ts.Close()
+	ctx := &Context{App: "myapp", Outputs: map[string]interface{}{}}
+	deploy := Action{Name: "deploy", URL: ts.URL + "/deployments/latest", Method: "GET"}
+	_, err := deploy.Execute(ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(ctx.Outputs["deploy"], check.DeepEquals, map[string]interface{}{"id": "d-42", "units": json.Number("3")})
+	scale := Action{Name: "scale", URL: ts.URL + "/deployments/{{.Outputs.deploy.id}}/scale", Method: "POST"}
+	_, err = scale.Execute(ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(path, check.Equals, "/deployments/d-42/scale")
+	c.Assert(ctx.Outputs["scale"], check.Equals, "")
+}
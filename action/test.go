@@ -0,0 +1,76 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import "time"
+
+// SampleEvent is the synthetic alarm event used to test fire an action: the
+// app, the alarm envs, the alarm name and instance and the data of the alarm
+// data sources, by data source name.
+type SampleEvent struct {
+	App      string                 `json:"app"`
+	Envs     map[string]string      `json:"envs"`
+	Alarm    string                 `json:"alarm"`
+	Instance string                 `json:"instance"`
+	Data     map[string]interface{} `json:"data"`
+	DryRun   bool                   `json:"dry_run"`
+}
+
+// TestFireResult is the result of a test fire: the rendered request, with
+// its secrets redacted, the attempts made and the execution error.
+type TestFireResult struct {
+	Request  string    `json:"request"`
+	Attempts []Attempt `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// TestFire executes the action with the sample event, or only renders it in
+// dry runs, and returns the request and the response. Failed executions are
+// not retried, and the execution is not recorded in the history.
+func TestFire(name string, sample *SampleEvent) (*TestFireResult, error) {
+	a, err := FindByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return a.testFire(sample), nil
+}
+
+func (a *Action) testFire(sample *SampleEvent) *TestFireResult {
+	ctx := sample.context(a.Name)
+	result := TestFireResult{Attempts: []Attempt{}}
+	request, err := a.renderRequest(ctx)
+	if err != nil {
+		result.Error = err.Error()
+		return &result
+	}
+	result.Request = request
+	fired := *a
+	fired.Retries = 0
+	fired.DryRun = fired.DryRun || sample.DryRun
+	attempts, err := fired.Execute(ctx)
+	if attempts != nil {
+		result.Attempts = attempts
+	}
+	if err != nil {
+		result.Error = a.redactRequest(err.Error(), ctx)
+	}
+	return &result
+}
+
+// context returns the execution context of the sample event.
+func (e *SampleEvent) context(actionName string) *Context {
+	alarm := e.Alarm
+	if alarm == "" {
+		alarm = "test-fire"
+	}
+	return &Context{
+		App:     e.App,
+		Envs:    e.Envs,
+		Alarm:   AlarmContext{Name: alarm, Instance: e.Instance, Envs: e.Envs},
+		Event:   EventContext{ID: "test-fire", Type: actionName, StartTime: time.Now().UTC()},
+		Data:    e.Data,
+		Outputs: map[string]interface{}{},
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestTestFire(c *check.C) {
+	var calls int
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("upstream unavailable"))
+	}))
+	defer ts.Close()
+	a := Action{Name: "notify", URL: ts.URL + "/{app}", Method: "POST", Body: `{"alarm": "{{.Alarm.Name}}", "cpu": {data.cpu.value}}`, Retries: 3}
+	sample := SampleEvent{App: "myapp", Data: map[string]interface{}{"cpu": map[string]interface{}{"value": 95}}}
+	result := a.testFire(&sample)
+	c.Assert(calls, check.Equals, 1)
+	c.Assert(body, check.Equals, `{"alarm": "test-fire", "cpu": 95}`)
+	c.Assert(result.Request, check.Equals, "POST "+ts.URL+"/myapp\n\n"+body)
+	c.Assert(result.Attempts, check.HasLen, 1)
+	c.Assert(result.Attempts[0].StatusCode, check.Equals, http.StatusBadGateway)
+	c.Assert(result.Attempts[0].Response, check.Equals, "upstream unavailable")
+	c.Assert(result.Error, check.Not(check.Equals), "")
+}
+
+func (s *S) TestTestFireDryRun(c *check.C) {
+	a := Action{Name: "notify", URL: "http://tsuru.io/{app}", Method: "POST", Body: "{data.cpu.value}"}
+	result := a.testFire(&SampleEvent{App: "myapp", DryRun: true, Data: map[string]interface{}{"cpu": map[string]interface{}{"value": 95}}})
+	c.Assert(result.Error, check.Equals, "")
+	c.Assert(result.Request, check.Equals, "POST http://tsuru.io/myapp\n\n95")
+	c.Assert(result.Attempts, check.HasLen, 1)
+	c.Assert(result.Attempts[0].DryRun, check.Equals, true)
+	result = a.testFire(&SampleEvent{App: "myapp", DryRun: true})
+	c.Assert(result.Error, check.Equals, "action: data placeholder {data.cpu.value} not found")
+	c.Assert(result.Attempts, check.HasLen, 0)
+}
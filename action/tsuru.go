@@ -0,0 +1,305 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/tsuru"
+)
+
+// Scale operations of tsuru actions. Set scales the process to the units.
+const (
+	ScaleUp   = "up"
+	ScaleDown = "down"
+	ScaleSet  = "set"
+)
+
+// Default units and process templates of tsuru actions, using the step,
+// units and process alarm envs.
+const (
+	defaultUnits    = `{{or .Envs.step "1"}}`
+	defaultSetUnits = `{{.Envs.units}}`
+	defaultProcess  = `{{.Envs.process}}`
+)
+
+func validateTsuru(a *Action) ([]string, error) {
+	if a.Scale != ScaleUp && a.Scale != ScaleDown && a.Scale != ScaleSet {
+		return nil, invalid("scale must be %q, %q or %q", ScaleUp, ScaleDown, ScaleSet)
+	}
+	if a.Token == "" {
+		return nil, invalid("token required")
+	}
+	return []string{a.URL, a.Units, a.Process}, nil
+}
+
+// tsuruSender returns the execution adding or removing units of the app
+// using the tsuru api at the action url, or TSURU_HOST by default. The
+// units may be a percentage of the current units, and are limited by the
+// minUnits and maxUnits envs. Requests rejected by the api are not retried.
+func (a *Action) tsuruSender(ctx *Context) (*Prepared, error) {
+	if a.Scale == ScaleSet {
+		return a.setUnitsSender(ctx)
+	}
+	host, err := Render(a.URL, ctx)
+	if err != nil {
+		return nil, err
+	}
+	unitsTemplate := a.Units
+	if unitsTemplate == "" {
+		unitsTemplate = defaultUnits
+	}
+	rendered, err := Render(unitsTemplate, ctx)
+	if err != nil {
+		return nil, err
+	}
+	step, err := parseStep(rendered)
+	if err != nil {
+		return nil, fmt.Errorf("action %q: invalid units %q", a.Name, rendered)
+	}
+	processTemplate := a.Process
+	if processTemplate == "" {
+		processTemplate = defaultProcess
+	}
+	process, err := Render(processTemplate, ctx)
+	if err != nil {
+		return nil, err
+	}
+	bounds, err := parseBounds(ctx.Envs)
+	if err != nil {
+		return nil, fmt.Errorf("action %q: %s", a.Name, err)
+	}
+	httpClient, err := a.client()
+	if err != nil {
+		return nil, err
+	}
+	token, err := ctx.expandSecrets(a.Token)
+	if err != nil {
+		return nil, err
+	}
+	client := tsuru.Client{Host: host, Token: token, HTTPClient: httpClient}
+	scale := client.AddUnits
+	if a.Scale == ScaleDown {
+		scale = client.RemoveUnits
+	}
+	a.logger().Printf("action %s - scale %s app %s process %q by %s", a.Name, a.Scale, ctx.App, process, step)
+	send := func() (Attempt, error) {
+		attempt := Attempt{Time: time.Now().UTC()}
+		units := step.units
+		if step.percent > 0 || bounds.min > 0 || bounds.max > 0 {
+			current, err := client.Units(ctx.App, process)
+			if err != nil {
+				return a.tsuruError(attempt, ctx.App, err)
+			}
+			units = bounds.limit(a.Scale, current, step.delta(current))
+			a.logger().Printf("action %s - app %s process %q has %d units - scaling %s by %d units", a.Name, ctx.App, process, current, a.Scale, units)
+		}
+		if units > 0 {
+			if err := scale(ctx.App, process, units); err != nil {
+				return a.tsuruError(attempt, ctx.App, err)
+			}
+		}
+		attempt.Duration = time.Since(attempt.Time)
+		return attempt, nil
+	}
+	request := fmt.Sprintf("scale %s app %s process %q by %s%s at %s", a.Scale, ctx.App, process, step, bounds, host)
+	return &Prepared{Request: request, URL: host, Send: send}, nil
+}
+
+// setUnitsSender returns the execution scaling the app process to the
+// action units, limited by the minUnits and maxUnits envs. Fractional units,
+// like the ones computed by target tracking alarms, are rounded up.
+func (a *Action) setUnitsSender(ctx *Context) (*Prepared, error) {
+	host, err := Render(a.URL, ctx)
+	if err != nil {
+		return nil, err
+	}
+	unitsTemplate := a.Units
+	if unitsTemplate == "" {
+		unitsTemplate = defaultSetUnits
+	}
+	rendered, err := Render(unitsTemplate, ctx)
+	if err != nil {
+		return nil, err
+	}
+	target, err := strconv.ParseFloat(strings.TrimSpace(rendered), 64)
+	if err != nil || target < 1 || math.IsInf(target, 0) {
+		return nil, fmt.Errorf("action %q: invalid units %q", a.Name, rendered)
+	}
+	bounds, err := parseBounds(ctx.Envs)
+	if err != nil {
+		return nil, fmt.Errorf("action %q: %s", a.Name, err)
+	}
+	units := bounds.clamp(int(math.Ceil(target)))
+	processTemplate := a.Process
+	if processTemplate == "" {
+		processTemplate = defaultProcess
+	}
+	process, err := Render(processTemplate, ctx)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := a.client()
+	if err != nil {
+		return nil, err
+	}
+	token, err := ctx.expandSecrets(a.Token)
+	if err != nil {
+		return nil, err
+	}
+	client := tsuru.Client{Host: host, Token: token, HTTPClient: httpClient}
+	a.logger().Printf("action %s - set app %s process %q units to %d", a.Name, ctx.App, process, units)
+	send := func() (Attempt, error) {
+		attempt := Attempt{Time: time.Now().UTC()}
+		current, err := client.Units(ctx.App, process)
+		if err != nil {
+			return a.tsuruError(attempt, ctx.App, err)
+		}
+		switch {
+		case units > current:
+			err = client.AddUnits(ctx.App, process, units-current)
+		case units < current:
+			err = client.RemoveUnits(ctx.App, process, current-units)
+		}
+		if err != nil {
+			return a.tsuruError(attempt, ctx.App, err)
+		}
+		a.logger().Printf("action %s - app %s process %q scaled from %d to %d units", a.Name, ctx.App, process, current, units)
+		attempt.Duration = time.Since(attempt.Time)
+		return attempt, nil
+	}
+	request := fmt.Sprintf("set app %s process %q units to %d at %s", ctx.App, process, units, host)
+	return &Prepared{Request: request, URL: host, Send: send}, nil
+}
+
+// tsuruError returns the attempt failed with err. Errors of requests
+// rejected by the api are permanent.
+func (a *Action) tsuruError(attempt Attempt, app string, err error) (Attempt, error) {
+	attempt.Duration = time.Since(attempt.Time)
+	apiErr, isAPIErr := err.(*tsuru.APIError)
+	err = fmt.Errorf("action %q: scale %s of app %q failed: %s", a.Name, a.Scale, app, err)
+	a.logger().Error(err)
+	attempt.Error = err.Error()
+	if isAPIErr {
+		attempt.StatusCode = apiErr.StatusCode
+		if apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode == http.StatusServiceUnavailable {
+			attempt.Throttled = true
+			attempt.RetryAfter = limitRetryAfter(apiErr.RetryAfter)
+		}
+		if !apiErr.Temporary() {
+			return attempt, Permanent(err)
+		}
+	}
+	return attempt, err
+}
+
+// unitsStep is the number of units added or removed by tsuru actions,
+// absolute or a percentage of the current units.
+type unitsStep struct {
+	units   int
+	percent float64
+}
+
+func parseStep(value string) (unitsStep, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent <= 0 || math.IsInf(percent, 0) {
+			return unitsStep{}, errors.New("invalid percentage")
+		}
+		return unitsStep{percent: percent}, nil
+	}
+	units, err := strconv.Atoi(value)
+	if err != nil || units < 1 {
+		return unitsStep{}, errors.New("invalid units")
+	}
+	return unitsStep{units: units}, nil
+}
+
+// delta returns the number of units of the step for an app process with
+// current units. Percentages are rounded up, to at least one unit.
+func (s unitsStep) delta(current int) int {
+	if s.percent == 0 {
+		return s.units
+	}
+	delta := int(math.Ceil(float64(current) * s.percent / 100))
+	if delta < 1 {
+		delta = 1
+	}
+	return delta
+}
+
+func (s unitsStep) String() string {
+	if s.percent > 0 {
+		return strconv.FormatFloat(s.percent, 'f', -1, 64) + "%"
+	}
+	return fmt.Sprintf("%d units", s.units)
+}
+
+// unitsBounds are the minimum and maximum units of the app process, from
+// the minUnits and maxUnits envs. Zero means no bound.
+type unitsBounds struct {
+	min int
+	max int
+}
+
+func parseBounds(envs map[string]string) (unitsBounds, error) {
+	var b unitsBounds
+	for name, bound := range map[string]*int{"minUnits": &b.min, "maxUnits": &b.max} {
+		value, ok := envs[name]
+		if !ok || value == "" {
+			continue
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil || v < 0 {
+			return b, fmt.Errorf("invalid %s %q", name, value)
+		}
+		*bound = v
+	}
+	return b, nil
+}
+
+// limit returns delta limited so the app process with current units stays
+// within the bounds after scaling.
+func (b unitsBounds) limit(scale string, current, delta int) int {
+	if scale == ScaleUp && b.max > 0 && current+delta > b.max {
+		delta = b.max - current
+	}
+	if scale == ScaleDown && b.min > 0 && current-delta < b.min {
+		delta = current - b.min
+	}
+	if delta < 0 {
+		return 0
+	}
+	return delta
+}
+
+// clamp returns units within the bounds.
+func (b unitsBounds) clamp(units int) int {
+	if b.max > 0 && units > b.max {
+		units = b.max
+	}
+	if units < b.min {
+		units = b.min
+	}
+	return units
+}
+
+func (b unitsBounds) String() string {
+	var s string
+	if b.min > 0 {
+		s += fmt.Sprintf(", min %d units", b.min)
+	}
+	if b.max > 0 {
+		s += fmt.Sprintf(", max %d units", b.max)
+	}
+	return s
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package action
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestExecuteTsuru(c *check.C) {
+	var method, path, units, process string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		method, path, units, process = r.Method, r.URL.Path, r.Form.Get("units"), r.Form.Get("process")
+	}))
+	defer ts.Close()
+	a := Action{Name: "scale_up", Type: TypeTsuru, URL: ts.URL, Scale: ScaleUp, Token: "token"}
+	attempts, err := a.Execute(&Context{App: "myapp", Envs: map[string]string{"step": "3", "process": "web"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(attempts, check.HasLen, 1)
+	c.Assert(method, check.Equals, "PUT")
+	c.Assert(path, check.Equals, "/apps/myapp/units")
+	c.Assert(units, check.Equals, "3")
+	c.Assert(process, check.Equals, "web")
+	a = Action{Name: "scale_down", Type: TypeTsuru, URL: ts.URL, Scale: ScaleDown, Token: "token", Process: "worker"}
+	_, err = a.Execute(&Context{App: "myapp"})
+	c.Assert(err, check.IsNil)
+	c.Assert(method, check.Equals, "DELETE")
+	c.Assert(units, check.Equals, "1")
+	c.Assert(process, check.Equals, "worker")
+}
+
+func (s *S) TestExecuteTsuruDoesNotRetryRejectedRequests(c *check.C) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+	a := Action{Name: "scale_up", Type: TypeTsuru, URL: ts.URL, Scale: ScaleUp, Token: "token", Retries: 3}
+	attempts, err := a.Execute(&Context{App: "myapp"})
+	c.Assert(err, check.ErrorMatches, `action "scale_up": scale up of app "myapp" failed: tsuru: unauthorized, the token is invalid or expired \(status 401\)`)
+	c.Assert(calls, check.Equals, 1)
+	c.Assert(attempts, check.HasLen, 1)
+	c.Assert(attempts[0].StatusCode, check.Equals, http.StatusUnauthorized)
+}
+
+func (s *S) TestExecuteTsuruInvalidUnits(c *check.C) {
+	a := Action{Name: "scale_up", Type: TypeTsuru, Scale: ScaleUp, Token: "token", Units: "{{.Envs.step}}"}
+	_, err := a.Execute(&Context{App: "myapp", Envs: map[string]string{"step": "many"}})
+	c.Assert(err, check.ErrorMatches, `action "scale_up": invalid units "many"`)
+}
+
+func (s *S) TestValidateTsuru(c *check.C) {
+	a := Action{Type: TypeTsuru, Scale: ScaleUp, Token: "token"}
+	c.Assert(a.validate(), check.IsNil)
+	a.Scale = "sideways"
+	c.Assert(a.validate(), check.ErrorMatches, `action: scale must be "up", "down" or "set"`)
+	a.Scale, a.Token = ScaleDown, ""
+	c.Assert(a.validate(), check.ErrorMatches, "action: token required")
+}
+
+func tsuruServer(units int, scaled *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			var list []string
+			for i := 0; i < units; i++ {
+				list = append(list, `{"ProcessName": "web"}`)
+			}
+			list = append(list, `{"ProcessName": "worker"}`)
+			w.Write([]byte(`{"name": "myapp", "units": [` + strings.Join(list, ",") + `]}`))
+			return
+		}
+		r.ParseForm()
+		*scaled = append(*scaled, r.Method+" "+r.Form.Get("units"))
+	}))
+}
+
+func (s *S) TestExecuteTsuruPercentage(c *check.C) {
+	var scaled []string
+	ts := tsuruServer(4, &scaled)
+	defer ts.Close()
+	tests := []struct {
+		scale string
+		envs  map[string]string
+	}{
+		{ScaleUp, map[string]string{"step": "25%", "process": "web"}},
+		{ScaleUp, map[string]string{"step": "60%", "process": "web"}},
+		{ScaleUp, map[string]string{"step": "10%", "process": "web"}},
+		{ScaleUp, map[string]string{"step": "100%", "process": "web", "maxUnits": "6"}},
+		{ScaleUp, map[string]string{"step": "2", "process": "web", "maxUnits": "4"}},
+		{ScaleDown, map[string]string{"step": "50%", "process": "web", "minUnits": "3"}},
+	}
+	for _, tt := range tests {
+		a := Action{Name: "scale", Type: TypeTsuru, URL: ts.URL, Scale: tt.scale, Token: "token"}
+		_, err := a.Execute(&Context{App: "myapp", Envs: tt.envs})
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(scaled, check.DeepEquals, []string{"PUT 1", "PUT 3", "PUT 1", "PUT 2", "DELETE 1"})
+}
+
+func (s *S) TestExecuteTsuruInvalidBounds(c *check.C) {
+	a := Action{Name: "scale_up", Type: TypeTsuru, Scale: ScaleUp, Token: "token"}
+	_, err := a.Execute(&Context{App: "myapp", Envs: map[string]string{"maxUnits": "ten"}})
+	c.Assert(err, check.ErrorMatches, `action "scale_up": invalid maxUnits "ten"`)
+}
+
+func (s *S) TestParseStep(c *check.C) {
+	step, err := parseStep(" 25% ")
+	c.Assert(err, check.IsNil)
+	c.Assert(step, check.Equals, unitsStep{percent: 25})
+	c.Assert(step.delta(2), check.Equals, 1)
+	c.Assert(step.delta(10), check.Equals, 3)
+	step, err = parseStep("3")
+	c.Assert(err, check.IsNil)
+	c.Assert(step.delta(10), check.Equals, 3)
+	for _, invalid := range []string{"0", "-1%", "%", "many", "0%"} {
+		_, err = parseStep(invalid)
+		c.Check(err, check.NotNil, check.Commentf(invalid))
+	}
+}
+
+func (s *S) TestExecuteTsuruSetUnits(c *check.C) {
+	var scaled []string
+	ts := tsuruServer(4, &scaled)
+	defer ts.Close()
+	for _, envs := range []map[string]string{
+		{"units": "6", "process": "web"},
+		{"units": "2", "process": "web"},
+		{"units": "4", "process": "web"},
+		{"units": "2.3", "process": "web"},
+		{"units": "10", "process": "web", "maxUnits": "8"},
+		{"units": "1", "process": "web", "minUnits": "2"},
+	} {
+		a := Action{Name: "set_units", Type: TypeTsuru, URL: ts.URL, Scale: ScaleSet, Token: "token"}
+		_, err := a.Execute(&Context{App: "myapp", Envs: envs})
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(scaled, check.DeepEquals, []string{"PUT 2", "DELETE 2", "DELETE 1", "PUT 4", "DELETE 2"})
+}
+
+func (s *S) TestExecuteTsuruSetUnitsTemplate(c *check.C) {
+	var scaled []string
+	ts := tsuruServer(2, &scaled)
+	defer ts.Close()
+	a := Action{Name: "set_units", Type: TypeTsuru, URL: ts.URL, Scale: ScaleSet, Token: "token", Units: "{{.Data.target.units}}", Process: "web"}
+	_, err := a.Execute(&Context{App: "myapp", Data: map[string]interface{}{"target": map[string]interface{}{"units": 5}}})
+	c.Assert(err, check.IsNil)
+	c.Assert(scaled, check.DeepEquals, []string{"PUT 3"})
+	a.Units = "{{.Envs.units}}"
+	_, err = a.Execute(&Context{App: "myapp"})
+	c.Assert(err, check.ErrorMatches, `action "set_units": invalid units ""`)
+}
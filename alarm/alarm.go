@@ -5,6 +5,7 @@
 package alarm
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -23,30 +24,146 @@ import (
 	"gopkg.in/mgo.v2/bson"
 )
 
-// StartAutoScale start the auto scale
+// runner holds the channels stopping the auto scale and signaling that it
+// stopped.
+var runner struct {
+	sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartAutoScale start the auto scale, checking the alarms periodically
+// until StopAutoScale is called.
 func StartAutoScale() {
-	runAutoScale()
+	runner.Lock()
+	if runner.stop == nil {
+		runner.stop = make(chan struct{})
+	}
+	stop := runner.stop
+	done := make(chan struct{})
+	runner.done = done
+	runner.Unlock()
+	defer close(done)
+	runAutoScale(stop)
+}
+
+// StopAutoScale stops the auto scale, waiting for the check in progress, so
+// the actions being executed are not cut off.
+func StopAutoScale() {
+	runner.Lock()
+	if runner.stop == nil {
+		runner.stop = make(chan struct{})
+	}
+	select {
+	case <-runner.stop:
+	default:
+		close(runner.stop)
+	}
+	done := runner.done
+	runner.Unlock()
+	if done != nil {
+		<-done
+	}
 }
 
 func logger() *log.Logger {
 	return log.Log()
 }
 
-// Alarm represents the configuration for the auto scale.
+// Alarm states, set on each check.
+const (
+	// StateOK means the alarm expression is false.
+	StateOK = "OK"
+	// StateAlarm means the alarm expression is true.
+	StateAlarm = "ALARM"
+	// StateInsufficientData means the data sources responses did not match
+	// their response schema, so the expression was not evaluated.
+	StateInsufficientData = "INSUFFICIENT_DATA"
+)
+
+// Alarm represents the configuration for the auto scale. State and
+// StateReason are the result of the last check. Notifications are actions
+// executed when the events of the alarm actions finish. Conditions are
+// expressions, by action name, that must be true for the action to be
+// executed. The actions run in order and can use the outputs of the ones
+// executed before; in pipeline alarms, a failed action stops the next ones
+// and rolls back the previous ones.
+// Groups are sets of actions, by name, executed concurrently when the group
+// name is in the alarm actions.
 type Alarm struct {
-	Name        string            `json:"name"`
-	Actions     []string          `json:"actions"`
-	Expression  string            `json:"expression"`
-	Enabled     bool              `json:"enabled"`
-	Wait        time.Duration     `json:"wait"`
-	DataSources []string          `json:"datasources"`
-	Instance    string            `json:"instance"`
-	Envs        map[string]string `json:"envs"`
+	Name          string                 `json:"name"`
+	Actions       []string               `json:"actions"`
+	Expression    string                 `json:"expression"`
+	Enabled       bool                   `json:"enabled"`
+	Wait          time.Duration          `json:"wait"`
+	DataSources   []string               `json:"datasources"`
+	Instance      string                 `json:"instance"`
+	Envs          map[string]string      `json:"envs"`
+	Notifications []string               `json:"notifications,omitempty" bson:",omitempty"`
+	Conditions    map[string]string      `json:"conditions,omitempty" bson:",omitempty"`
+	Pipeline      bool                   `json:"pipeline,omitempty" bson:",omitempty"`
+	Groups        map[string]ActionGroup `json:"groups,omitempty" bson:",omitempty"`
+	State         string                 `json:"state,omitempty" bson:",omitempty"`
+	StateReason   string                 `json:"stateReason,omitempty" bson:",omitempty"`
+
+	// StateSince, StateChecks, LastCheck and LastCheckError are the runtime
+	// data of the checks, returned by Runtime.
+	StateSince     time.Time `json:"-" bson:",omitempty"`
+	StateChecks    int       `json:"-" bson:",omitempty"`
+	LastCheck      time.Time `json:"-" bson:",omitempty"`
+	LastCheckError string    `json:"-" bson:",omitempty"`
+
+	// user is the user that scaled the instance manually, recorded in the
+	// events of the actions.
+	user string
+
+	// lg is the logger of the request that triggered the alarm.
+	lg *log.Logger
 }
 
-// NewAlarm creates a new alarm
+// SetLogger sets the logger of the alarm checks and of the actions executed
+// by them, so their logs carry the fields of the request that triggered
+// the alarm, like its request id.
+func (a *Alarm) SetLogger(l *log.Logger) {
+	a.lg = l
+}
+
+func (a *Alarm) logger() *log.Logger {
+	if a.lg != nil {
+		return a.lg
+	}
+	return logger()
+}
+
+// ActionGroup is a set of actions executed concurrently. The group
+// succeeds when at least MinSuccess actions succeed, or all of them when
+// MinSuccess is zero.
+type ActionGroup struct {
+	Actions    []string `json:"actions"`
+	MinSuccess int      `json:"min_success,omitempty" bson:",omitempty"`
+}
+
+// ValidationError is returned when an alarm, or an operation on its
+// events, is invalid.
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return "alarm: " + e.Message
+}
+
+// invalid returns the *ValidationError with the formatted message.
+func invalid(format string, args ...interface{}) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// NewAlarm creates a new alarm, within the quotas of its instance.
 func NewAlarm(a *Alarm) error {
-	conn, err := db.Conn()
+	if err := tsuru.CheckQuota(a.Instance, 1, 0); err != nil {
+		return err
+	}
+	conn, err := db.Open()
 	if err != nil {
 		return err
 	}
@@ -56,8 +173,9 @@ func NewAlarm(a *Alarm) error {
 
 func runAutoScaleOnce() {
 	logger().Print("checking alarms")
+	start := time.Now()
 	alarms := []Alarm{}
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return
@@ -81,6 +199,9 @@ func runAutoScaleOnce() {
 		}(alarm)
 	}
 	wg.Wait()
+	if err = recordCycle(start); err != nil {
+		logger().Error(err)
+	}
 }
 
 func interval() time.Duration {
@@ -94,10 +215,91 @@ func interval() time.Duration {
 	return time.Duration(10)
 }
 
-func runAutoScale() {
+// ExpressionEngine is the engine evaluating the alarm expressions and
+// conditions.
+const ExpressionEngine = "javascript"
+
+// CheckInterval returns the interval between the alarm checks, configured
+// by the AUTOSCALE_INTERVAL environment variable, in seconds.
+func CheckInterval() time.Duration {
+	return interval() * time.Second
+}
+
+func runAutoScale(stop <-chan struct{}) {
 	for {
-		runAutoScaleOnce()
-		time.Sleep(interval() * time.Second)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		wait := runCycle()
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// degraded is the state of the runner while the storage is unreachable:
+// the alarms checks are paused, instead of failing on every alarm, and the
+// storage is checked again with a backoff, doubled after each failure up to
+// the check interval.
+var degraded struct {
+	sync.Mutex
+	since    time.Time
+	failures int
+}
+
+// runCycle checks the alarms, unless the storage is unreachable, returning
+// how long the runner waits for the next cycle.
+func runCycle() time.Duration {
+	if err := pingStorage(); err != nil {
+		return pause(err)
+	}
+	resume()
+	runAutoScaleOnce()
+	return interval() * time.Second
+}
+
+// pingStorage checks that the storage is reachable.
+func pingStorage() error {
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Ping()
+}
+
+// pause records a failed check of the storage, logging the first one,
+// and returns the backoff of the next check.
+func pause(err error) time.Duration {
+	degraded.Lock()
+	defer degraded.Unlock()
+	if degraded.failures == 0 {
+		degraded.since = time.Now()
+		logger().Printf("storage unreachable, pausing the alarms checks: %s", err)
+	}
+	degraded.failures++
+	backoff := time.Second
+	for i := 1; i < degraded.failures && backoff < interval()*time.Second; i++ {
+		backoff *= 2
+	}
+	if max := interval() * time.Second; backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// resume leaves the degraded state, when the storage is reachable again.
+func resume() {
+	degraded.Lock()
+	defer degraded.Unlock()
+	if degraded.failures > 0 {
+		paused := time.Since(degraded.since)
+		logger().Printf("storage reachable again after %s, resuming the alarms checks", paused-paused%time.Second)
+		degraded.failures = 0
 	}
 }
 
@@ -105,56 +307,211 @@ func scaleIfNeeded(alarm *Alarm) error {
 	if alarm == nil {
 		return errors.New("alarm: alarm is not configured")
 	}
-	check, err := alarm.Check()
+	check, data, err := alarm.checkState()
 	if err != nil {
-		logger().Error(err)
 		return err
 	}
-	logger().Printf("alarm %s - %s - check: %t", alarm.Name, alarm.Expression, check)
 	if check {
-		if wait, err := shouldWait(alarm); err != nil {
-			logger().Printf("waiting for alarm %s", alarm.Name)
-			return err
-		} else if wait {
-			return nil
+		_, err = alarm.fire(data)
+		return err
+	}
+	return nil
+}
+
+// checkState executes the alarm expression and stores the alarm state,
+// returning the result and the data of the data sources.
+func (a *Alarm) checkState() (bool, map[string]string, error) {
+	check, data, err := a.check()
+	if err != nil {
+		a.logger().Error(err)
+		// failed checks keep the state, unless the data is insufficient.
+		state := ""
+		if datasource.IsInsufficientData(err) {
+			state = StateInsufficientData
 		}
-		for _, alarmName := range alarm.Actions {
-			a, err := action.FindByName(alarmName)
-			if err != nil {
-				logger().Error(err)
-			} else {
-				logger().Printf("executing alarm %s action %s", alarm.Name, a.Name)
-				instance, err := tsuru.GetInstanceByName(alarm.Instance)
-				if err != nil {
-					logger().Error(err)
-					return err
-				}
-				if len(instance.Apps) < 1 {
-					msg := "Error trying to get app instance, auto scale aborted."
-					logger().Printf(msg)
-					err = errors.New(msg)
-					return err
-				}
-				appName := instance.Apps[0]
-				evt, err := NewEvent(alarm, a)
-				if err != nil {
-					logger().Error(err)
-				}
-				aErr := a.Do(appName, alarm.Envs)
-				if aErr != nil {
-					logger().Error(aErr)
-				} else {
-					logger().Printf("alarm %s action %s executed", alarm.Name, a.Name)
-				}
-				err = evt.update(aErr)
-				if err != nil {
-					logger().Error(err)
-				}
+		if sErr := a.setState(state, err); sErr != nil {
+			a.logger().Error(sErr)
+		}
+		return false, nil, err
+	}
+	a.logger().Printf("alarm %s - %s - check: %t", a.Name, a.Expression, check)
+	state := StateOK
+	if check {
+		state = StateAlarm
+	}
+	if err = a.setState(state, nil); err != nil {
+		a.logger().Error(err)
+	}
+	return check, data, nil
+}
+
+// fire executes the alarm actions with the data of the data sources, unless
+// the alarm is waiting since its last event, returning whether the actions
+// were executed.
+func (a *Alarm) fire(data map[string]string) (bool, error) {
+	if wait, err := shouldWait(a); err != nil {
+		a.logger().Printf("waiting for alarm %s", a.Name)
+		return false, err
+	} else if wait {
+		return false, nil
+	}
+	return a.execute(data)
+}
+
+// execute executes the alarm actions with the data of the data sources,
+// returning whether the actions were executed.
+func (a *Alarm) execute(data map[string]string) (bool, error) {
+	instance, err := tsuru.GetInstanceByName(a.Instance)
+	if err != nil {
+		a.logger().Error(err)
+		return false, err
+	}
+	if len(instance.Apps) < 1 {
+		msg := "Error trying to get app instance, auto scale aborted."
+		a.logger().Print(msg)
+		err = errors.New(msg)
+		return false, err
+	}
+	appName := instance.Apps[0]
+	// the parameters of the plan of the instance are the defaults of the envs.
+	a.Envs = instance.Defaults(a.Envs)
+	outputs := map[string]interface{}{}
+	var executed []executedAction
+	for _, name := range a.Actions {
+		var steps []executedAction
+		if group, ok := a.Groups[name]; ok {
+			steps, err = a.executeGroup(name, group, appName, data, outputs)
+		} else {
+			var step *executedAction
+			step, err = a.executeAction(name, appName, data, outputs)
+			if step != nil {
+				steps = append(steps, *step)
 			}
 		}
-		return nil
+		executed = append(executed, steps...)
+		if err != nil && a.Pipeline {
+			a.logger().Printf("alarm %s - pipeline stopped at action %s", a.Name, name)
+			a.rollback(executed, err)
+			return true, err
+		}
 	}
-	return nil
+	return true, nil
+}
+
+// executeAction executes the alarm action, returning it when it was
+// executed successfully. Failed actions are rolled back. Actions whose
+// condition is false are skipped, returning no action and no error.
+func (a *Alarm) executeAction(name, appName string, data map[string]string, outputs map[string]interface{}) (*executedAction, error) {
+	act, err := action.FindByName(name)
+	if err != nil {
+		a.logger().Error(err)
+		return nil, err
+	}
+	act.SetLogger(a.logger())
+	a.logger().Printf("executing alarm %s action %s", a.Name, act.Name)
+	if !a.shouldExecute(act.Name, appName, data) {
+		return nil, nil
+	}
+	release, err := act.Acquire()
+	if err != nil {
+		a.logger().Printf("alarm %s - skipping action %s: %s", a.Name, act.Name, err)
+		return nil, err
+	}
+	rateLimitMutex.Lock()
+	if err = checkRateLimit(a.Instance); err != nil {
+		rateLimitMutex.Unlock()
+		release()
+		a.logger().Printf("alarm %s - skipping action %s: %s", a.Name, act.Name, err)
+		return nil, err
+	}
+	evt, err := NewEvent(a, act.Redacted())
+	rateLimitMutex.Unlock()
+	if err != nil {
+		// actions without an event would not be rate limited nor waited
+		// for, so they are not executed.
+		release()
+		a.logger().Error(err)
+		return nil, err
+	}
+	ctx := a.actionContext(appName, evt, act, data, outputs)
+	attempts, aErr := act.Execute(ctx)
+	release()
+	action.RecordExecutions(act, ctx, attempts)
+	action.RecordDeadLetter(act, ctx, attempts, aErr)
+	if aErr != nil {
+		a.logger().Error(aErr)
+	} else {
+		a.logger().Printf("alarm %s action %s executed", a.Name, act.Name)
+	}
+	evt.Attempts = attempts
+	err = evt.update(aErr)
+	if err != nil {
+		a.logger().Error(err)
+	}
+	step := executedAction{action: act, event: evt, ctx: a.actionContext(appName, evt, act, data, outputs)}
+	if aErr != nil {
+		a.rollback([]executedAction{step}, aErr)
+	}
+	a.notify(a.actionContext(appName, evt, act, data, outputs))
+	if aErr != nil {
+		return nil, aErr
+	}
+	return &step, nil
+}
+
+// executeGroup executes the actions of the group concurrently, returning
+// the ones executed successfully. The group fails when less than its
+// MinSuccess actions, or all the executed ones by default, succeed. The
+// outputs of the actions are added to outputs when all of them finish.
+func (a *Alarm) executeGroup(name string, group ActionGroup, appName string, data map[string]string, outputs map[string]interface{}) ([]executedAction, error) {
+	type result struct {
+		step    *executedAction
+		err     error
+		outputs map[string]interface{}
+	}
+	results := make([]result, len(group.Actions))
+	var wg sync.WaitGroup
+	for i, actionName := range group.Actions {
+		own := make(map[string]interface{}, len(outputs))
+		for key, value := range outputs {
+			own[key] = value
+		}
+		wg.Add(1)
+		go func(i int, actionName string, own map[string]interface{}) {
+			defer wg.Done()
+			step, err := a.executeAction(actionName, appName, data, own)
+			results[i] = result{step: step, err: err, outputs: own}
+		}(i, actionName, own)
+	}
+	wg.Wait()
+	var steps []executedAction
+	var errs []string
+	executed := 0
+	for i, r := range results {
+		if r.step != nil {
+			steps = append(steps, *r.step)
+			if value, ok := r.outputs[r.step.action.Name]; ok {
+				outputs[r.step.action.Name] = value
+			}
+		}
+		if r.err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", group.Actions[i], r.err))
+		}
+		if r.step != nil || r.err != nil {
+			executed++
+		}
+	}
+	required := group.MinSuccess
+	if required <= 0 || required > executed {
+		required = executed
+	}
+	if len(steps) < required {
+		err := fmt.Errorf("alarm %s: group %s: %d of %d actions succeeded (%s)", a.Name, name, len(steps), executed, strings.Join(errs, "; "))
+		a.logger().Error(err)
+		return steps, err
+	}
+	a.logger().Printf("alarm %s group %s executed: %d of %d actions succeeded", a.Name, name, len(steps), executed)
+	return steps, nil
 }
 
 func shouldWait(alarm *Alarm) (bool, error) {
@@ -164,21 +521,53 @@ func shouldWait(alarm *Alarm) (bool, error) {
 		return false, err
 	}
 	if err != mgo.ErrNotFound && lastEvent.EndTime.IsZero() {
-		logger().Printf("last event not finished yet for alarm %s - waiting", alarm.Name)
+		alarm.logger().Printf("last event not finished yet for alarm %s - waiting", alarm.Name)
 		return true, nil
 	}
 	diff := now.Sub(lastEvent.EndTime)
 	if diff > alarm.Wait {
-		logger().Printf("diff %d > %d form alarm %s - not waiting", diff, alarm.Wait, alarm.Name)
+		alarm.logger().Printf("diff %d > %d form alarm %s - not waiting", diff, alarm.Wait, alarm.Name)
 		return false, nil
 	}
-	logger().Printf("diff %d < %d form alarm %s - waiting", diff, alarm.Wait, alarm.Name)
+	alarm.logger().Printf("diff %d < %d form alarm %s - waiting", diff, alarm.Wait, alarm.Name)
 	return true, nil
 }
 
+// setState stores the result of the alarm check: the time and the error of
+// the check and, unless it is empty, the state, with the error as its
+// reason, counting the consecutive checks in the state.
+func (a *Alarm) setState(state string, checkErr error) error {
+	now := time.Now().UTC()
+	a.LastCheck = now
+	a.LastCheckError = ""
+	if checkErr != nil {
+		a.LastCheckError = checkErr.Error()
+	}
+	set := bson.M{"lastcheck": a.LastCheck, "lastcheckerror": a.LastCheckError}
+	if state != "" {
+		if state != a.State || a.StateChecks == 0 {
+			a.StateSince = now
+			a.StateChecks = 0
+		}
+		a.State = state
+		a.StateReason = a.LastCheckError
+		a.StateChecks++
+		set["state"] = a.State
+		set["statereason"] = a.StateReason
+		set["statesince"] = a.StateSince
+		set["statechecks"] = a.StateChecks
+	}
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Alarms().Update(bson.M{"name": a.Name}, bson.M{"$set": set})
+}
+
 // Enable enables an alarm
 func Enable(alarm *Alarm) error {
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		return nil
 	}
@@ -188,7 +577,7 @@ func Enable(alarm *Alarm) error {
 
 // Disable disables an alarm
 func Disable(alarm *Alarm) error {
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		return nil
 	}
@@ -196,18 +585,31 @@ func Disable(alarm *Alarm) error {
 	return conn.Alarms().Update(bson.M{"name": alarm.Name}, bson.M{"$set": bson.M{"enabled": false}})
 }
 
-func (a *Alarm) data(appName string) (map[string]string, error) {
+// placeholders returns the values used to replace the data sources
+// placeholders: the instance values, the check interval and the alarm envs.
+func (a *Alarm) placeholders(instance *tsuru.Instance) map[string]string {
+	envs := instance.Envs()
+	envs["interval"] = strconv.Itoa(int(interval()))
+	for key, value := range a.Envs {
+		envs[key] = value
+	}
+	return envs
+}
+
+func (a *Alarm) data(instance *tsuru.Instance) (map[string]string, error) {
 	d := map[string]string{}
+	appName := instance.Apps[0]
+	envs := a.placeholders(instance)
 	for _, dataSource := range a.DataSources {
 		ds, err := datasource.Get(dataSource)
 		if err != nil {
 			return nil, err
 		}
-		data, err := ds.Get(appName, a.Envs)
+		data, err := ds.Get(appName, envs)
 		if err != nil {
 			return nil, err
 		}
-		logger().Printf("data for alarm %s - %s", a.Name, data)
+		a.logger().Printf("data for alarm %s - %s", a.Name, data)
 		d[ds.Name] = data
 	}
 	return d, nil
@@ -215,28 +617,49 @@ func (a *Alarm) data(appName string) (map[string]string, error) {
 
 // Check executes the alarm expression
 func (a *Alarm) Check() (bool, error) {
+	check, _, err := a.check()
+	return check, err
+}
+
+// check executes the alarm expression, returning its result and the data
+// of the data sources.
+func (a *Alarm) check() (bool, map[string]string, error) {
 	instance, err := tsuru.GetInstanceByName(a.Instance)
 	if err != nil {
-		return false, err
+		return false, nil, err
 	}
 	if len(instance.Apps) < 1 {
 		msg := "Error trying to get app instance."
-		logger().Printf(msg)
+		a.logger().Print(msg)
 		err = errors.New(msg)
-		return false, err
+		return false, nil, err
 	}
 	appName := instance.Apps[0]
-	dataSourceData, err := a.data(appName)
+	dataSourceData, err := a.data(instance)
 	if err != nil {
-		return false, err
+		return false, nil, err
+	}
+	check, err := a.evaluate(a.Expression, appName, dataSourceData)
+	if err != nil {
+		return false, nil, err
 	}
-	expression := strings.Replace(a.Expression, "{app}", appName, -1)
+	return check, dataSourceData, nil
+}
+
+// evaluate executes a javascript expression of the alarm, with the {app}
+// and envs placeholders replaced and the data of the data sources as
+// variables.
+func (a *Alarm) evaluate(expression, appName string, dataSourceData map[string]string) (bool, error) {
+	expression = strings.Replace(expression, "{app}", appName, -1)
 	for key, value := range a.Envs {
 		expression = strings.Replace(expression, fmt.Sprintf("{%s}", key), value, -1)
 	}
 	data := ""
 	for key, value := range dataSourceData {
 		data += fmt.Sprintf("var %s=%s;", key, value)
+		if len(dataSourceData) == 1 {
+			data += fmt.Sprintf("var value=%s;", value)
+		}
 	}
 	vm := otto.New()
 	vm.Run(data)
@@ -245,54 +668,167 @@ func (a *Alarm) Check() (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	check, err := result.ToBoolean()
+	return result.ToBoolean()
+}
+
+// shouldExecute returns whether the alarm action should be executed: the
+// actions without condition are always executed, the other ones when their
+// condition is true.
+func (a *Alarm) shouldExecute(actionName, appName string, data map[string]string) bool {
+	condition, ok := a.Conditions[actionName]
+	if !ok {
+		return true
+	}
+	execute, err := a.evaluate(condition, appName, data)
 	if err != nil {
-		return false, err
+		a.logger().Error(err)
+		return false
+	}
+	if !execute {
+		a.logger().Printf("alarm %s - skipping action %s - condition: %s", a.Name, actionName, condition)
+	}
+	return execute
+}
+
+// actionContext returns the context of the action templates.
+func (a *Alarm) actionContext(appName string, evt *Event, act *action.Action, data map[string]string, outputs map[string]interface{}) *action.Context {
+	ctx := action.Context{
+		App:  appName,
+		Envs: a.Envs,
+		Alarm: action.AlarmContext{
+			Name:       a.Name,
+			Instance:   a.Instance,
+			Expression: a.Expression,
+			Envs:       a.Envs,
+		},
+		Event:   action.EventContext{Type: act.Name},
+		Data:    map[string]interface{}{},
+		Outputs: outputs,
+	}
+	if evt != nil {
+		ctx.Event.ID = evt.ID.Hex()
+		ctx.Event.StartTime = evt.StartTime
+		ctx.Event.EndTime = evt.EndTime
+		ctx.Event.Successful = evt.Successful
+		ctx.Event.Error = evt.Error
+	}
+	for name, value := range data {
+		var decoded interface{}
+		decoder := json.NewDecoder(strings.NewReader(value))
+		decoder.UseNumber()
+		if err := decoder.Decode(&decoded); err != nil {
+			decoded = value
+		}
+		ctx.Data[name] = decoded
 	}
-	return check, nil
+	return &ctx
+}
+
+// executedAction is an action executed by the alarm, with its event and
+// the context of its execution.
+type executedAction struct {
+	action *action.Action
+	event  *Event
+	ctx    *action.Context
 }
 
-// ListAlarmsByToken lists alarms by token.
-func ListAlarmsByToken(token string) ([]Alarm, error) {
+// rollback executes the rollback actions of the executed actions, in
+// reverse order, because of cause, recording them on the actions events.
+func (a *Alarm) rollback(executed []executedAction, cause error) {
+	for i := len(executed) - 1; i >= 0; i-- {
+		step := executed[i]
+		if step.action.Rollback == "" {
+			continue
+		}
+		r, err := action.FindByName(step.action.Rollback)
+		if err != nil {
+			a.logger().Error(err)
+			continue
+		}
+		r.SetLogger(a.logger())
+		a.logger().Printf("alarm %s - rolling back action %s with %s: %s", a.Name, step.action.Name, r.Name, cause)
+		step.ctx.Event.Successful = false
+		step.ctx.Event.Error = cause.Error()
+		attempts, err := r.Execute(step.ctx)
+		action.RecordExecutions(r, step.ctx, attempts)
+		action.RecordDeadLetter(r, step.ctx, attempts, err)
+		if err != nil {
+			a.logger().Error(err)
+		}
+		if step.event == nil {
+			continue
+		}
+		if sErr := step.event.setRollback(r.Redacted(), attempts, err); sErr != nil {
+			a.logger().Error(sErr)
+		}
+	}
+}
+
+// notify executes the alarm notifications with the context of a finished
+// event.
+func (a *Alarm) notify(ctx *action.Context) {
+	for _, name := range a.Notifications {
+		n, err := action.FindByName(name)
+		if err != nil {
+			a.logger().Error(err)
+			continue
+		}
+		n.SetLogger(a.logger())
+		release, err := n.Acquire()
+		if err != nil {
+			a.logger().Printf("alarm %s - skipping notification %s: %s", a.Name, n.Name, err)
+			continue
+		}
+		attempts, err := n.Execute(ctx)
+		release()
+		action.RecordExecutions(n, ctx, attempts)
+		action.RecordDeadLetter(n, ctx, attempts, err)
+		if err != nil {
+			a.logger().Error(err)
+			continue
+		}
+		a.logger().Printf("alarm %s notification %s executed", a.Name, n.Name)
+	}
+}
+
+// ListAlarmsByToken lists the alarms of the instances of the token,
+// returning the page selected by opts and the total of alarms matching its
+// filters.
+func ListAlarmsByToken(token string, opts *db.ListOptions) ([]Alarm, int, error) {
 	i, err := tsuru.FindServiceInstance(token)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	instances := []string{}
 	for _, instance := range i {
 		instances = append(instances, instance.Name)
 	}
-	conn, err := db.Conn()
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-	var alarms []Alarm
-	err = conn.Alarms().Find(bson.M{"instance": bson.M{"$in": instances}}).All(&alarms)
-	if err != nil {
-		return nil, err
-	}
-	return alarms, nil
+	return listAlarms(bson.M{"instance": bson.M{"$in": instances}}, opts)
+}
+
+// ListAlarmsByInstance lists alarms by instance, returning the page
+// selected by opts and the total of alarms matching its filters.
+func ListAlarmsByInstance(instanceName string, opts *db.ListOptions) ([]Alarm, int, error) {
+	return listAlarms(bson.M{"instance": instanceName}, opts)
 }
 
-// ListAlarmsByInstance lists alarms by instance.
-func ListAlarmsByInstance(instanceName string) ([]Alarm, error) {
-	conn, err := db.Conn()
+func listAlarms(q bson.M, opts *db.ListOptions) ([]Alarm, int, error) {
+	conn, err := db.Open()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer conn.Close()
 	var alarms []Alarm
-	err = conn.Alarms().Find(bson.M{"instance": instanceName}).All(&alarms)
+	total, err := db.List(conn.Alarms(), q, opts, &alarms)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return alarms, nil
+	return alarms, total, nil
 }
 
 // FindAlarmBy finds alarm by query "q".
 func FindAlarmBy(q bson.M) ([]Alarm, error) {
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		return nil, err
 	}
@@ -307,7 +843,7 @@ func FindAlarmBy(q bson.M) ([]Alarm, error) {
 
 // FindAlarmByName find alarm by name.
 func FindAlarmByName(name string) (*Alarm, error) {
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		return nil, err
 	}
@@ -319,12 +855,12 @@ func FindAlarmByName(name string) (*Alarm, error) {
 	if len(alarms) > 0 {
 		return &alarms[0], nil
 	}
-	return nil, fmt.Errorf("Alarm %q not found", name)
+	return nil, &db.NotFoundError{Resource: "Alarm", Name: name}
 }
 
 // RemoveAlarm removes an alarm.
 func RemoveAlarm(a *Alarm) error {
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		return err
 	}
@@ -337,13 +873,53 @@ func RemoveAlarm(a *Alarm) error {
 	return nil
 }
 
-// UpdateAlarm updates an alarm
+// SaveAlarm creates the alarm, or replaces the existing one with the same
+// name, returning whether it was created. The state of existing alarms is
+// kept.
+func SaveAlarm(a *Alarm) (bool, error) {
+	if a.Name == "" {
+		return false, invalid("name required")
+	}
+	if a.Wait < 0 {
+		return false, invalid("wait must not be negative")
+	}
+	conn, err := db.Open()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	var existing Alarm
+	err = conn.Alarms().Find(bson.M{"name": a.Name}).One(&existing)
+	if err == mgo.ErrNotFound {
+		err = tsuru.CheckQuota(a.Instance, 1, 0)
+	}
+	if err != nil {
+		return false, err
+	}
+	a.keepState(&existing)
+	info, err := conn.Alarms().Upsert(bson.M{"name": a.Name}, a)
+	if err != nil {
+		return false, err
+	}
+	return info.UpsertedId != nil, nil
+}
+
+// keepState copies the runtime state of the checks of the stored alarm,
+// which is not edited by the users, to a.
+func (a *Alarm) keepState(existing *Alarm) {
+	a.State, a.StateReason = existing.State, existing.StateReason
+	a.StateSince, a.StateChecks = existing.StateSince, existing.StateChecks
+	a.LastCheck, a.LastCheckError = existing.LastCheck, existing.LastCheckError
+}
+
+// UpdateAlarm updates an alarm, keeping the state of its checks.
 func UpdateAlarm(a *Alarm) error {
-	_, err := FindAlarmByName(a.Name)
+	existing, err := FindAlarmByName(a.Name)
 	if err != nil {
 		return err
 	}
-	conn, err := db.Conn()
+	a.keepState(existing)
+	conn, err := db.Open()
 	if err != nil {
 		return err
 	}
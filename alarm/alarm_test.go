@@ -5,35 +5,39 @@
 package alarm
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/tsuru/tsuru-autoscale/action"
 	"github.com/tsuru/tsuru-autoscale/datasource"
 	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/db/storagetest"
+	"github.com/tsuru/tsuru-autoscale/log"
 	"github.com/tsuru/tsuru-autoscale/tsuru"
-	"github.com/tsuru/tsuru/db/dbtest"
 	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func Test(t *testing.T) { check.TestingT(t) }
 
 type S struct {
-	conn *db.Storage
+	conn *storagetest.Storage
 }
 
 func (s *S) SetUpSuite(c *check.C) {
-	err := os.Setenv("MONGODB_DATABASE_NAME", "tsuru_autoscale_alarm")
-	c.Assert(err, check.IsNil)
-	s.conn, err = db.Conn()
+	var err error
+	s.conn, err = storagetest.New("tsuru_autoscale_alarm")
 	c.Assert(err, check.IsNil)
 }
 
 func (s *S) TearDownTest(c *check.C) {
-	dbtest.ClearAllCollections(s.conn.Alarms().Database)
+	s.conn.Clear()
 }
 
 var _ = check.Suite(&S{})
@@ -178,6 +182,148 @@ func (s *S) TestRunAutoScaleOnce(c *check.C) {
 	c.Assert(events[0].Action.Name, check.Equals, myAction.Name)
 }
 
+func (s *S) TestRunAutoScaleOnceNotifications(c *check.C) {
+	var message string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slack" {
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			message = body["text"]
+			return
+		}
+		w.Write([]byte(`{"id":"ble"}`))
+	}))
+	defer ts.Close()
+	ds := datasource.DataSource{Name: "data", URL: ts.URL, Method: "GET"}
+	err := datasource.New(&ds)
+	c.Assert(err, check.IsNil)
+	myAction := action.Action{Name: "myaction", URL: ts.URL, Method: "GET"}
+	err = action.New(&myAction)
+	c.Assert(err, check.IsNil)
+	notification := action.Action{
+		Name:    "notify",
+		Type:    action.TypeSlack,
+		URL:     ts.URL + "/slack",
+		Message: "{{.Alarm.Name}} {{.Event.Type}} successful: {{.Event.Successful}}",
+	}
+	err = action.New(&notification)
+	c.Assert(err, check.IsNil)
+	instance := tsuru.Instance{Name: "instance", Apps: []string{"app"}}
+	err = tsuru.NewInstance(&instance)
+	c.Assert(err, check.IsNil)
+	alarm := Alarm{
+		Name:          "name",
+		Expression:    `data.id == "ble"`,
+		DataSources:   []string{ds.Name},
+		Actions:       []string{myAction.Name},
+		Notifications: []string{notification.Name},
+		Instance:      instance.Name,
+		Enabled:       true,
+	}
+	err = NewAlarm(&alarm)
+	c.Assert(err, check.IsNil)
+	runAutoScaleOnce()
+	c.Assert(message, check.Equals, "name myaction successful: true")
+}
+
+func (s *S) TestRunAutoScaleOnceRollback(c *check.C) {
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"id":"ble"}`))
+	}))
+	defer ts.Close()
+	ds := datasource.DataSource{Name: "data", URL: ts.URL, Method: "GET"}
+	err := datasource.New(&ds)
+	c.Assert(err, check.IsNil)
+	actions := []action.Action{
+		{Name: "scale", URL: ts.URL + "/scale", Method: "POST", Rollback: "unscale"},
+		{Name: "unscale", URL: ts.URL + "/unscale", Method: "POST"},
+		{Name: "annotate", URL: ts.URL + "/fail", Method: "POST", Rollback: "unannotate"},
+		{Name: "unannotate", URL: ts.URL + "/unannotate", Method: "POST"},
+	}
+	for i := range actions {
+		err = action.New(&actions[i])
+		c.Assert(err, check.IsNil)
+	}
+	instance := tsuru.Instance{Name: "instance", Apps: []string{"app"}}
+	err = tsuru.NewInstance(&instance)
+	c.Assert(err, check.IsNil)
+	alarm := Alarm{
+		Name:        "name",
+		Expression:  `data.id == "ble"`,
+		DataSources: []string{ds.Name},
+		Actions:     []string{"scale", "annotate"},
+		Pipeline:    true,
+		Instance:    instance.Name,
+		Enabled:     true,
+	}
+	err = NewAlarm(&alarm)
+	c.Assert(err, check.IsNil)
+	runAutoScaleOnce()
+	c.Assert(calls, check.DeepEquals, []string{"/", "/scale", "/fail", "/unannotate", "/unscale"})
+	events, err := EventsByAlarmName("name")
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 2)
+	for _, evt := range events {
+		c.Assert(evt.Attempts, check.HasLen, 1)
+		c.Assert(evt.RollbackAttempts, check.HasLen, 1)
+		c.Assert(evt.Rollback.Name, check.Equals, "un"+evt.Action.Name)
+	}
+}
+
+func (s *S) TestRunAutoScaleOnceGroups(c *check.C) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"id":"ble"}`))
+	}))
+	defer ts.Close()
+	ds := datasource.DataSource{Name: "data", URL: ts.URL, Method: "GET"}
+	err := datasource.New(&ds)
+	c.Assert(err, check.IsNil)
+	actions := []action.Action{
+		{Name: "scale", URL: ts.URL + "/scale", Method: "POST"},
+		{Name: "annotate", URL: ts.URL + "/fail", Method: "POST"},
+		{Name: "notify", URL: ts.URL + "/notify", Method: "POST"},
+	}
+	for i := range actions {
+		err = action.New(&actions[i])
+		c.Assert(err, check.IsNil)
+	}
+	instance := tsuru.Instance{Name: "instance", Apps: []string{"app"}}
+	err = tsuru.NewInstance(&instance)
+	c.Assert(err, check.IsNil)
+	alarm := Alarm{
+		Name:        "name",
+		Expression:  `data.id == "ble"`,
+		DataSources: []string{ds.Name},
+		Actions:     []string{"respond", "notify"},
+		Groups:      map[string]ActionGroup{"respond": {Actions: []string{"scale", "annotate"}, MinSuccess: 1}},
+		Pipeline:    true,
+		Instance:    instance.Name,
+	}
+	err = scaleIfNeeded(&alarm)
+	c.Assert(err, check.IsNil)
+	c.Assert(calls, check.DeepEquals, map[string]int{"/": 1, "/scale": 1, "/fail": 1, "/notify": 1})
+	alarm.Name = "all"
+	alarm.Groups["respond"] = ActionGroup{Actions: []string{"scale", "annotate"}}
+	err = scaleIfNeeded(&alarm)
+	c.Assert(err, check.ErrorMatches, `alarm all: group respond: 1 of 2 actions succeeded \(annotate: .*\)`)
+	c.Assert(calls["/notify"], check.Equals, 1)
+}
+
 func (s *S) TestAutoScaleEnable(c *check.C) {
 	alarm := Alarm{Name: "alarm"}
 	err := NewAlarm(&alarm)
@@ -326,6 +472,86 @@ func (s *S) TestAlarmCheck(c *check.C) {
 	c.Assert(ok, check.Equals, false)
 }
 
+func (s *S) TestAlarmCheckWithExtract(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"buckets": [{"max": {"value": 50}}, {"max": {"value": 85.5}}]}`))
+	}))
+	defer ts.Close()
+	ds := datasource.DataSource{
+		Name:    "cpu",
+		URL:     ts.URL,
+		Method:  "GET",
+		Extract: "$.buckets[-1].max.value",
+	}
+	err := datasource.New(&ds)
+	c.Assert(err, check.IsNil)
+	instance := tsuru.Instance{
+		Name: "instance",
+		Apps: []string{"app"},
+	}
+	err = tsuru.NewInstance(&instance)
+	c.Assert(err, check.IsNil)
+	alarm := &Alarm{
+		Name:        "rush",
+		Enabled:     true,
+		Expression:  `value > 80 && cpu > 80`,
+		DataSources: []string{ds.Name},
+		Instance:    instance.Name,
+	}
+	ok, err := alarm.Check()
+	c.Assert(err, check.IsNil)
+	c.Assert(ok, check.Equals, true)
+}
+
+func (s *S) TestScaleIfNeededInsufficientData(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "success", "data": {"result": []}}`))
+	}))
+	defer ts.Close()
+	ds := datasource.DataSource{
+		Name:           "cpu",
+		URL:            ts.URL,
+		Method:         "GET",
+		ResponseSchema: map[string]string{"$.data.result[0].value": "array"},
+	}
+	err := datasource.New(&ds)
+	c.Assert(err, check.IsNil)
+	instance := tsuru.Instance{
+		Name: "instance",
+		Apps: []string{"app"},
+	}
+	err = tsuru.NewInstance(&instance)
+	c.Assert(err, check.IsNil)
+	alarm := &Alarm{
+		Name:        "rush",
+		Enabled:     true,
+		Expression:  `cpu.data.result[0].value[1] > 80`,
+		DataSources: []string{ds.Name},
+		Instance:    instance.Name,
+	}
+	err = NewAlarm(alarm)
+	c.Assert(err, check.IsNil)
+	err = scaleIfNeeded(alarm)
+	c.Assert(err, check.NotNil)
+	a, err := FindAlarmByName(alarm.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.State, check.Equals, StateInsufficientData)
+	c.Assert(a.StateReason, check.Equals, `datasource "cpu": insufficient data: $.data.result[0].value is missing`)
+}
+
+func (s *S) TestAlarmPlaceholders(c *check.C) {
+	instance := &tsuru.Instance{Name: "instance", Team: "team", Pool: "pool", Apps: []string{"app"}}
+	alarm := &Alarm{Name: "rush", Envs: map[string]string{"process": "web", "team": "other"}}
+	expected := map[string]string{
+		"instance": "instance",
+		"team":     "other",
+		"pool":     "pool",
+		"interval": "10",
+		"process":  "web",
+	}
+	c.Assert(alarm.placeholders(instance), check.DeepEquals, expected)
+}
+
 func (s *S) TestAlarmCheckWithoutApps(c *check.C) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`{"id":"ble"}`))
@@ -373,9 +599,10 @@ func (s *S) TestListAlarmsByToken(c *check.C) {
 		Name: "xpto2",
 	}
 	s.conn.Alarms().Insert(&a)
-	all, err := ListAlarmsByToken("token")
+	all, total, err := ListAlarmsByToken("token", nil)
 	c.Assert(err, check.IsNil)
 	c.Assert(all, check.HasLen, 1)
+	c.Assert(total, check.Equals, 1)
 }
 
 func (s *S) TestFindAlarmByName(c *check.C) {
@@ -417,9 +644,15 @@ func (s *S) TestListAlarmsByInstance(c *check.C) {
 		Instance: "instance",
 	}
 	s.conn.Alarms().Insert(&a)
-	all, err := ListAlarmsByInstance("instance")
+	all, total, err := ListAlarmsByInstance("instance", nil)
 	c.Assert(err, check.IsNil)
 	c.Assert(all, check.HasLen, 2)
+	c.Assert(total, check.Equals, 2)
+	all, total, err = ListAlarmsByInstance("instance", &db.ListOptions{Limit: 1, Sort: []string{"-name"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(all, check.HasLen, 1)
+	c.Assert(all[0].Name, check.Equals, "xpto2")
+	c.Assert(total, check.Equals, 2)
 }
 
 func (s *S) TestUpdateAlarm(c *check.C) {
@@ -430,12 +663,42 @@ func (s *S) TestUpdateAlarm(c *check.C) {
 	}
 	err := NewAlarm(&a)
 	c.Assert(err, check.IsNil)
-	a.Enabled = false
+	err = a.setState(StateAlarm, nil)
+	c.Assert(err, check.IsNil)
+	stored, err := FindAlarmByName(a.Name)
+	c.Assert(err, check.IsNil)
+	a = Alarm{Name: "name", Expression: `data.id === "{var}"`, Enabled: false}
 	err = UpdateAlarm(&a)
 	c.Assert(err, check.IsNil)
 	r, err := FindAlarmByName(a.Name)
 	c.Assert(err, check.IsNil)
 	c.Assert(r.Enabled, check.Equals, false)
+	c.Assert(r.State, check.Equals, StateAlarm)
+	c.Assert(r.StateSince.Equal(stored.StateSince), check.Equals, true)
+	c.Assert(r.StateChecks, check.Equals, stored.StateChecks)
+	c.Assert(r.LastCheck.IsZero(), check.Equals, false)
+}
+
+func (s *S) TestSaveAlarm(c *check.C) {
+	a := Alarm{Name: "name", Expression: "true", Enabled: true}
+	created, err := SaveAlarm(&a)
+	c.Assert(err, check.IsNil)
+	c.Assert(created, check.Equals, true)
+	err = a.setState(StateAlarm, nil)
+	c.Assert(err, check.IsNil)
+	a = Alarm{Name: "name", Expression: "false"}
+	created, err = SaveAlarm(&a)
+	c.Assert(err, check.IsNil)
+	c.Assert(created, check.Equals, false)
+	r, err := FindAlarmByName("name")
+	c.Assert(err, check.IsNil)
+	c.Assert(r.Expression, check.Equals, "false")
+	c.Assert(r.Enabled, check.Equals, false)
+	c.Assert(r.State, check.Equals, StateAlarm)
+	_, err = SaveAlarm(&Alarm{})
+	c.Assert(err, check.ErrorMatches, "alarm: name required")
+	_, err = SaveAlarm(&Alarm{Name: "name", Wait: -1})
+	c.Assert(err, check.ErrorMatches, "alarm: wait must not be negative")
 }
 
 func (s *S) TestUpdateAlarmNotFound(c *check.C) {
@@ -454,6 +717,8 @@ func (s *S) TestNewAlarmWithoutDB(c *check.C) {
 	}(os.Getenv("MONGODB_URL"))
 	err := os.Setenv("MONGODB_URL", "invalid")
 	c.Assert(err, check.IsNil)
+	db.SetBackend(nil)
+	defer s.conn.Use()
 	a := Alarm{
 		Name:       "name",
 		Expression: `data.id === "{var}"`,
@@ -462,3 +727,109 @@ func (s *S) TestNewAlarmWithoutDB(c *check.C) {
 	err = NewAlarm(&a)
 	c.Assert(err, check.NotNil)
 }
+
+func (s *S) TestRunCycleDegraded(c *check.C) {
+	os.Setenv("AUTOSCALE_INTERVAL", "5")
+	defer os.Unsetenv("AUTOSCALE_INTERVAL")
+	db.SetBackend(func() (db.Storage, error) {
+		return nil, errors.New("unreachable")
+	})
+	defer s.conn.Use()
+	var waits []time.Duration
+	for i := 0; i < 5; i++ {
+		waits = append(waits, runCycle())
+	}
+	c.Assert(waits, check.DeepEquals, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second})
+	_, err := LastRunnerStatus()
+	c.Assert(err, check.NotNil)
+	s.conn.Use()
+	c.Assert(runCycle(), check.Equals, 5*time.Second)
+	c.Assert(degraded.failures, check.Equals, 0)
+	_, err = LastRunnerStatus()
+	c.Assert(err, check.IsNil)
+	c.Assert(pause(errors.New("unreachable")), check.Equals, time.Second)
+	resume()
+}
+
+// failingEvents is a storage whose events can not be inserted, like while
+// MongoDB is unavailable.
+type failingEvents struct {
+	db.Storage
+}
+
+func (s failingEvents) Events() db.Repository {
+	return failingRepository{s.Storage.Events()}
+}
+
+// Close keeps the storage of the suite open.
+func (s failingEvents) Close() {}
+
+type failingRepository struct {
+	db.Repository
+}
+
+func (r failingRepository) Insert(docs ...interface{}) error {
+	return errors.New("events unavailable")
+}
+
+func (s *S) TestExecuteActionWithoutEvent(c *check.C) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer ts.Close()
+	err := action.New(&action.Action{Name: "scale_up", URL: ts.URL, Method: "POST"})
+	c.Assert(err, check.IsNil)
+	db.SetBackend(func() (db.Storage, error) {
+		return failingEvents{s.conn.Storage}, nil
+	})
+	defer s.conn.Use()
+	a := Alarm{Name: "high", Actions: []string{"scale_up"}}
+	step, err := a.executeAction("scale_up", "app", map[string]string{}, map[string]interface{}{})
+	c.Assert(err, check.ErrorMatches, "events unavailable")
+	c.Assert(step, check.IsNil)
+	c.Assert(calls, check.Equals, 0)
+}
+
+func (s *S) TestActionContext(c *check.C) {
+	a := Alarm{Name: "scale_up", Instance: "instance", Expression: "cpu.value > 80", Envs: map[string]string{"step": "1"}}
+	evt := &Event{ID: bson.NewObjectId(), StartTime: time.Now().UTC()}
+	data := map[string]string{"cpu": `{"value": 95.5}`, "raw": "not json"}
+	outputs := map[string]interface{}{"deploy": "v2"}
+	ctx := a.actionContext("app", evt, &action.Action{Name: "scale_up"}, data, outputs)
+	c.Assert(ctx.App, check.Equals, "app")
+	c.Assert(ctx.Envs, check.DeepEquals, a.Envs)
+	c.Assert(ctx.Alarm, check.DeepEquals, action.AlarmContext{Name: "scale_up", Instance: "instance", Expression: "cpu.value > 80", Envs: a.Envs})
+	c.Assert(ctx.Event, check.DeepEquals, action.EventContext{ID: evt.ID.Hex(), Type: "scale_up", StartTime: evt.StartTime})
+	c.Assert(ctx.Data, check.DeepEquals, map[string]interface{}{
+		"cpu": map[string]interface{}{"value": json.Number("95.5")},
+		"raw": "not json",
+	})
+	c.Assert(ctx.Outputs, check.DeepEquals, outputs)
+}
+
+func (s *S) TestShouldExecute(c *check.C) {
+	a := Alarm{
+		Name:       "cpu",
+		Expression: "cpu.value > {threshold}",
+		Envs:       map[string]string{"threshold": "80"},
+		Conditions: map[string]string{"page": "cpu.value > 2 * {threshold}", "invalid": "cpu.value >"},
+	}
+	data := map[string]string{"cpu": `{"value": 120}`}
+	c.Assert(a.shouldExecute("scale_up", "app", data), check.Equals, true)
+	c.Assert(a.shouldExecute("page", "app", data), check.Equals, false)
+	c.Assert(a.shouldExecute("invalid", "app", data), check.Equals, false)
+	data["cpu"] = `{"value": 170}`
+	c.Assert(a.shouldExecute("page", "app", data), check.Equals, true)
+}
+
+func (s *S) TestAlarmLogger(c *check.C) {
+	a := Alarm{Name: "cpu"}
+	c.Assert(a.logger(), check.Equals, logger())
+	lg := log.New().With("request_id", "abc")
+	a.SetLogger(lg)
+	c.Assert(a.logger(), check.Equals, lg)
+	data, err := json.Marshal(&a)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Not(check.Matches), ".*request_id.*")
+}
@@ -5,23 +5,38 @@
 package alarm
 
 import (
+	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/tsuru/tsuru-autoscale/action"
 	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
 // Event represents an auto scale event with
-// the scale metadata.
+// the scale metadata. When the action is rolled back, the rollback action
+// and its attempts are recorded with the ones of the action. Manual events
+// are the ones of the instances scaled manually, by User. Failed events are
+// acknowledged by the users who reviewed them.
 type Event struct {
-	ID         bson.ObjectId `bson:"_id"`
-	StartTime  time.Time
-	EndTime    time.Time `bson:",omitempty"`
-	Alarm      *Alarm
-	Successful bool
-	Error      string `bson:",omitempty"`
-	Action     *action.Action
+	ID               bson.ObjectId `bson:"_id"`
+	StartTime        time.Time
+	EndTime          time.Time `bson:",omitempty"`
+	Alarm            *Alarm
+	Successful       bool
+	Error            string `bson:",omitempty"`
+	Action           *action.Action
+	Attempts         []action.Attempt `bson:",omitempty"`
+	Rollback         *action.Action   `bson:",omitempty"`
+	RollbackAttempts []action.Attempt `bson:",omitempty"`
+	RollbackError    string           `bson:",omitempty"`
+	Manual           bool             `bson:",omitempty"`
+	User             string           `bson:",omitempty"`
+	AcknowledgedBy   string           `json:"acknowledged_by,omitempty" bson:"acknowledged_by,omitempty"`
+	AcknowledgedAt   *time.Time       `json:"acknowledged_at,omitempty" bson:"acknowledged_at,omitempty"`
 }
 
 // NewEvent creates a new alarm event
@@ -31,14 +46,16 @@ func NewEvent(alarm *Alarm, action *action.Action) (*Event, error) {
 		StartTime: time.Now().UTC(),
 		Alarm:     alarm,
 		Action:    action,
+		Manual:    alarm.user != "",
+		User:      alarm.user,
 	}
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return nil, err
 	}
 	defer conn.Close()
-	return &evt, conn.Events().Insert(evt)
+	return &evt, writeEvent(conn.Events(), &evt, true)
 }
 
 func (evt *Event) update(err error) error {
@@ -47,18 +64,150 @@ func (evt *Event) update(err error) error {
 	}
 	evt.Successful = err == nil
 	evt.EndTime = time.Now().UTC()
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return err
 	}
 	defer conn.Close()
-	return conn.Events().UpdateId(evt.ID, evt)
+	return writeEvent(conn.Events(), evt, false)
+}
+
+// paddingOverhead is the size of the padding field of the events without
+// its value: its type, its name, the length and the terminator of the
+// string.
+const paddingOverhead = 1 + len("padding") + 1 + 4 + 1
+
+// truncateLimits are the sizes the requests, outputs and responses of the
+// attempts are truncated to, in turn, until an event fits in the document
+// size of the capped events.
+var truncateLimits = []int{4096, 1024, 256, 0}
+
+// writeEvent inserts the event, or replaces it when insert is false. When
+// the events collection is capped, the events are padded to its document
+// size, as MongoDB does not let the updates change the size of the
+// documents of a capped collection.
+func writeEvent(events db.Repository, evt *Event, insert bool) error {
+	capped, err := db.EventsCapped()
+	if err != nil {
+		return err
+	}
+	var doc interface{} = evt
+	if capped != nil {
+		if doc, err = paddedEvent(evt, capped.DocumentSize); err != nil {
+			return err
+		}
+	}
+	if insert {
+		return events.Insert(doc)
+	}
+	return events.UpdateId(evt.ID, doc)
+}
+
+// paddedEvent returns the document of the event with size bytes, filled by
+// a padding field. The attempts of the events larger than size are
+// truncated.
+func paddedEvent(evt *Event, size int) (bson.M, error) {
+	doc, n, err := eventDocument(evt)
+	for i := 0; err == nil && n+paddingOverhead > size && i < len(truncateLimits); i++ {
+		doc, n, err = eventDocument(evt.truncated(truncateLimits[i]))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n+paddingOverhead > size {
+		return nil, fmt.Errorf("alarm: event %s does not fit in the %d bytes of the capped events", evt.ID.Hex(), size)
+	}
+	doc["padding"] = strings.Repeat(" ", size-n-paddingOverhead)
+	return doc, nil
+}
+
+// eventDocument returns the document of the event and its size.
+func eventDocument(evt *Event) (bson.M, int, error) {
+	data, err := bson.Marshal(evt)
+	if err != nil {
+		return nil, 0, err
+	}
+	var doc bson.M
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		return nil, 0, err
+	}
+	return doc, len(data), nil
+}
+
+// truncated returns a copy of the event with the requests, outputs and
+// responses of its attempts truncated to limit bytes.
+func (evt *Event) truncated(limit int) *Event {
+	t := *evt
+	t.Attempts = truncateAttempts(evt.Attempts, limit)
+	t.RollbackAttempts = truncateAttempts(evt.RollbackAttempts, limit)
+	return &t
+}
+
+func truncateAttempts(attempts []action.Attempt, limit int) []action.Attempt {
+	if attempts == nil {
+		return nil
+	}
+	truncated := make([]action.Attempt, len(attempts))
+	for i, attempt := range attempts {
+		attempt.Request = truncate(attempt.Request, limit)
+		attempt.Output = truncate(attempt.Output, limit)
+		attempt.Response = truncate(attempt.Response, limit)
+		truncated[i] = attempt
+	}
+	return truncated
+}
+
+// truncate returns the first limit bytes of s, without splitting a rune.
+func truncate(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	for limit > 0 && !utf8.RuneStart(s[limit]) {
+		limit--
+	}
+	return s[:limit]
+}
+
+// LastAttempt returns the last attempt to execute the event action, with
+// its status code and response, or nil when the action was not executed.
+func (evt *Event) LastAttempt() *action.Attempt {
+	if len(evt.Attempts) == 0 {
+		return nil
+	}
+	return &evt.Attempts[len(evt.Attempts)-1]
+}
+
+// setRollback records the execution of the rollback action of the event.
+func (evt *Event) setRollback(rollback *action.Action, attempts []action.Attempt, err error) error {
+	evt.Rollback = rollback
+	evt.RollbackAttempts = attempts
+	if err != nil {
+		evt.RollbackError = err.Error()
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return err
+	}
+	defer conn.Close()
+	capped, err := db.EventsCapped()
+	if err != nil {
+		return err
+	}
+	if capped != nil {
+		return writeEvent(conn.Events(), evt, false)
+	}
+	return conn.Events().UpdateId(evt.ID, bson.M{"$set": bson.M{
+		"rollback":         evt.Rollback,
+		"rollbackattempts": evt.RollbackAttempts,
+		"rollbackerror":    evt.RollbackError,
+	}})
 }
 
 func lastScaleEvent(alarm *Alarm) (Event, error) {
 	var event Event
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return event, err
@@ -70,19 +219,228 @@ func lastScaleEvent(alarm *Alarm) (Event, error) {
 
 // FindEventsBy is an extensible way to find events by query
 func FindEventsBy(q bson.M, limit int) ([]Event, error) {
-	conn, err := db.Conn()
+	events, _, err := FindEvents(q, &db.ListOptions{Limit: limit})
+	return events, err
+}
+
+// FindEvents returns the page of the events matching q selected by opts,
+// the latest ones first by default, and the total of events matching q and
+// the filters.
+func FindEvents(q bson.M, opts *db.ListOptions) ([]Event, int, error) {
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
-		return nil, err
+		return nil, 0, err
 	}
 	defer conn.Close()
+	page := db.ListOptions{}
+	if opts != nil {
+		page = *opts
+	}
+	if len(page.Sort) == 0 {
+		page.Sort = []string{"-starttime"}
+	}
 	var events []Event
-	err = conn.Events().Find(q).Sort("-starttime").Limit(limit).All(&events)
+	total, err := db.List(conn.Events(), q, &page, &events)
 	if err != nil {
 		logger().Error(err)
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// EachEvent calls fn for each event matching q, the oldest first, reading
+// them from a cursor, so the events are not all loaded in memory. It stops
+// at the first error of fn. The events are read from the storage of the
+// reports, as the exports may read many of them.
+func EachEvent(q bson.M, fn func(*Event) error) error {
+	conn, err := db.OpenReports()
+	if err != nil {
+		logger().Error(err)
+		return err
+	}
+	defer conn.Close()
+	iter := conn.Events().Find(q).Sort("starttime").Iter()
+	var evt Event
+	for iter.Next(&evt) {
+		if err = fn(&evt); err != nil {
+			iter.Close()
+			return err
+		}
+		evt = Event{}
+	}
+	return iter.Close()
+}
+
+// RemoveEvents removes the events matching q in batches of batch events,
+// the oldest first, calling progress with the number of events removed
+// after each batch, so large removals do not hold the collection for long.
+// It stops at the first error of progress. The events of a capped
+// collection can not be removed, MongoDB removes the oldest ones when it
+// is full.
+func RemoveEvents(q bson.M, batch int, progress func(removed int) error) (int, error) {
+	if batch < 1 {
+		return 0, invalid("the batch size must be positive")
+	}
+	capped, err := db.EventsCapped()
+	if err != nil {
+		return 0, err
+	}
+	if capped != nil {
+		return 0, invalid("the events are capped and can not be removed")
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return 0, err
+	}
+	defer conn.Close()
+	removed := 0
+	for {
+		var events []struct {
+			ID bson.ObjectId `bson:"_id"`
+		}
+		err = conn.Events().Find(q).Sort("starttime").Select(bson.M{"_id": 1}).Limit(batch).All(&events)
+		if err != nil || len(events) == 0 {
+			return removed, err
+		}
+		ids := make([]bson.ObjectId, len(events))
+		for i, evt := range events {
+			ids[i] = evt.ID
+		}
+		info, err := conn.Events().RemoveAll(bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return removed, err
+		}
+		removed += info.Removed
+		if progress != nil {
+			if err = progress(removed); err != nil {
+				return removed, err
+			}
+		}
+		if len(events) < batch {
+			return removed, nil
+		}
+	}
+}
+
+// Failed returns whether the event ended with an error.
+func (evt *Event) Failed() bool {
+	return !evt.EndTime.IsZero() && !evt.Successful
+}
+
+// OutstandingEvents returns the query of the failed events not acknowledged
+// yet.
+func OutstandingEvents() bson.M {
+	return bson.M{
+		"endtime":         bson.M{"$exists": true},
+		"successful":      false,
+		"acknowledged_by": bson.M{"$exists": false},
+	}
+}
+
+// AcknowledgeEvent records that user reviewed the failed event. Events
+// acknowledged before keep their first acknowledgment.
+func AcknowledgeEvent(id, user string) (*Event, error) {
+	if user == "" {
+		return nil, invalid("the user of the acknowledgment is required")
+	}
+	if !bson.IsObjectIdHex(id) {
+		return nil, &db.NotFoundError{Resource: "event", Name: id}
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	defer conn.Close()
+	var evt Event
+	if err = conn.Events().FindId(bson.ObjectIdHex(id)).One(&evt); err == mgo.ErrNotFound {
+		return nil, &db.NotFoundError{Resource: "event", Name: id}
+	} else if err != nil {
+		return nil, err
+	}
+	if !evt.Failed() {
+		return nil, invalid("event %q has not failed", id)
+	}
+	if evt.AcknowledgedBy != "" {
+		return &evt, nil
+	}
+	now := time.Now().UTC()
+	evt.AcknowledgedBy = user
+	evt.AcknowledgedAt = &now
+	capped, err := db.EventsCapped()
+	if err != nil {
 		return nil, err
 	}
-	return events, nil
+	if capped != nil {
+		err = writeEvent(conn.Events(), &evt, false)
+	} else {
+		err = conn.Events().UpdateId(evt.ID, bson.M{"$set": bson.M{
+			"acknowledged_by": evt.AcknowledgedBy,
+			"acknowledged_at": evt.AcknowledgedAt,
+		}})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &evt, nil
+}
+
+// AcknowledgeEvents records that user reviewed the outstanding events with
+// the ids, returning how many were acknowledged. The other events, which
+// have not failed or were acknowledged before, are left untouched.
+func AcknowledgeEvents(ids []string, user string) (int, error) {
+	if user == "" {
+		return 0, invalid("the user of the acknowledgment is required")
+	}
+	if len(ids) == 0 {
+		return 0, invalid("the ids of the events are required")
+	}
+	objectIds := make([]bson.ObjectId, len(ids))
+	for i, id := range ids {
+		if !bson.IsObjectIdHex(id) {
+			return 0, invalid("invalid event id %q", id)
+		}
+		objectIds[i] = bson.ObjectIdHex(id)
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return 0, err
+	}
+	defer conn.Close()
+	q := OutstandingEvents()
+	q["_id"] = bson.M{"$in": objectIds}
+	now := time.Now().UTC()
+	capped, err := db.EventsCapped()
+	if err != nil {
+		return 0, err
+	}
+	if capped != nil {
+		// The events of a capped collection are replaced one by one, so
+		// they keep their size.
+		var events []Event
+		if err = conn.Events().Find(q).All(&events); err != nil {
+			return 0, err
+		}
+		for i := range events {
+			events[i].AcknowledgedBy = user
+			events[i].AcknowledgedAt = &now
+			if err = writeEvent(conn.Events(), &events[i], false); err != nil {
+				return i, err
+			}
+		}
+		return len(events), nil
+	}
+	info, err := conn.Events().UpdateAll(q, bson.M{"$set": bson.M{
+		"acknowledged_by": user,
+		"acknowledged_at": now,
+	}})
+	if err != nil {
+		return 0, err
+	}
+	return info.Updated, nil
 }
 
 // EventsByAlarmName returns a list of events by alarm name
@@ -5,8 +5,15 @@
 package alarm
 
 import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/db"
 	"gopkg.in/check.v1"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -82,3 +89,182 @@ func (s *S) TestFindEventsBy(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(events, check.HasLen, 2)
 }
+
+func (s *S) TestFindEvents(c *check.C) {
+	alarm := Alarm{Name: "config"}
+	for i := 0; i < 3; i++ {
+		evt, err := NewEvent(&alarm, nil)
+		c.Assert(err, check.IsNil)
+		evt.StartTime = evt.StartTime.Add(time.Duration(i) * time.Minute)
+		var failure error
+		if i == 0 {
+			failure = errors.New("failed")
+		}
+		err = evt.update(failure)
+		c.Assert(err, check.IsNil)
+	}
+	events, total, err := FindEvents(bson.M{"alarm.name": "config"}, &db.ListOptions{Limit: 1, Filter: bson.M{"successful": true}})
+	c.Assert(err, check.IsNil)
+	c.Assert(total, check.Equals, 2)
+	c.Assert(events, check.HasLen, 1)
+	c.Assert(events[0].Successful, check.Equals, true)
+	first, _, err := FindEvents(nil, &db.ListOptions{Sort: []string{"starttime"}, Limit: 1})
+	c.Assert(err, check.IsNil)
+	c.Assert(first[0].StartTime.Before(events[0].StartTime), check.Equals, true)
+}
+
+func (s *S) TestEachEvent(c *check.C) {
+	for _, name := range []string{"first", "second", "third"} {
+		_, err := NewEvent(&Alarm{Name: name, Instance: "instance"}, nil)
+		c.Assert(err, check.IsNil)
+		time.Sleep(10 * time.Millisecond)
+	}
+	var names []string
+	err := EachEvent(bson.M{"alarm.name": bson.M{"$ne": "second"}}, func(evt *Event) error {
+		names = append(names, evt.Alarm.Name)
+		return nil
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.DeepEquals, []string{"first", "third"})
+	err = EachEvent(nil, func(evt *Event) error {
+		return errors.New("stop")
+	})
+	c.Assert(err, check.ErrorMatches, "stop")
+}
+
+func (s *S) TestAcknowledgeEventFields(c *check.C) {
+	evt, err := NewEvent(&Alarm{Name: "ack"}, nil)
+	c.Assert(err, check.IsNil)
+	data, err := json.Marshal(evt)
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(string(data), "acknowledged"), check.Equals, false)
+	err = evt.update(errors.New("scale failed"))
+	c.Assert(err, check.IsNil)
+	acked, err := AcknowledgeEvent(evt.ID.Hex(), "admin")
+	c.Assert(err, check.IsNil)
+	data, err = json.Marshal(acked)
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(string(data), `"acknowledged_by":"admin"`), check.Equals, true)
+	c.Assert(strings.Contains(string(data), `"acknowledged_at":`), check.Equals, true)
+	conn, err := db.Open()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	n, err := conn.Events().Find(bson.M{"_id": evt.ID, "acknowledged_by": "admin", "acknowledged_at": bson.M{"$exists": true}}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+}
+
+func (s *S) TestAcknowledgeEvent(c *check.C) {
+	evt, err := NewEvent(&Alarm{Name: "ack"}, nil)
+	c.Assert(err, check.IsNil)
+	_, err = AcknowledgeEvent(evt.ID.Hex(), "admin")
+	c.Assert(err, check.ErrorMatches, `alarm: event ".*" has not failed`)
+	err = evt.update(errors.New("scale failed"))
+	c.Assert(err, check.IsNil)
+	acked, err := AcknowledgeEvent(evt.ID.Hex(), "admin")
+	c.Assert(err, check.IsNil)
+	c.Assert(acked.AcknowledgedBy, check.Equals, "admin")
+	c.Assert(acked.AcknowledgedAt, check.NotNil)
+	c.Assert(acked.AcknowledgedAt.IsZero(), check.Equals, false)
+	acked, err = AcknowledgeEvent(evt.ID.Hex(), "other")
+	c.Assert(err, check.IsNil)
+	c.Assert(acked.AcknowledgedBy, check.Equals, "admin")
+	_, err = AcknowledgeEvent(bson.NewObjectId().Hex(), "admin")
+	c.Assert(err, check.ErrorMatches, `event ".*" not found`)
+	_, err = AcknowledgeEvent(evt.ID.Hex(), "")
+	c.Assert(err, check.ErrorMatches, "alarm: the user of the acknowledgment is required")
+}
+
+func (s *S) TestAcknowledgeEvents(c *check.C) {
+	var ids []string
+	for _, failure := range []error{errors.New("failed"), errors.New("failed"), nil} {
+		evt, err := NewEvent(&Alarm{Name: "ack"}, nil)
+		c.Assert(err, check.IsNil)
+		err = evt.update(failure)
+		c.Assert(err, check.IsNil)
+		ids = append(ids, evt.ID.Hex())
+	}
+	n, err := AcknowledgeEvents(ids, "admin")
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 2)
+	n, err = AcknowledgeEvents(ids, "admin")
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+	events, err := FindEventsBy(OutstandingEvents(), 10)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 0)
+	_, err = AcknowledgeEvents([]string{"invalid"}, "admin")
+	c.Assert(err, check.ErrorMatches, `alarm: invalid event id "invalid"`)
+	_, err = AcknowledgeEvents(nil, "admin")
+	c.Assert(err, check.ErrorMatches, "alarm: the ids of the events are required")
+}
+
+func (s *S) TestRemoveEvents(c *check.C) {
+	for _, name := range []string{"old", "old", "old", "new"} {
+		_, err := NewEvent(&Alarm{Name: name}, nil)
+		c.Assert(err, check.IsNil)
+	}
+	var progress []int
+	removed, err := RemoveEvents(bson.M{"alarm.name": "old"}, 2, func(removed int) error {
+		progress = append(progress, removed)
+		return nil
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(removed, check.Equals, 3)
+	c.Assert(progress, check.DeepEquals, []int{2, 3})
+	events, err := FindEventsBy(nil, 10)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 1)
+	c.Assert(events[0].Alarm.Name, check.Equals, "new")
+	_, err = RemoveEvents(nil, 0, nil)
+	c.Assert(err, check.ErrorMatches, "alarm: the batch size must be positive")
+}
+
+func (s *S) TestCappedEvents(c *check.C) {
+	os.Setenv("AUTOSCALE_EVENTS_CAPPED_SIZE", "1048576")
+	defer os.Unsetenv("AUTOSCALE_EVENTS_CAPPED_SIZE")
+	os.Setenv("AUTOSCALE_EVENTS_CAPPED_DOCUMENT_SIZE", "2048")
+	defer os.Unsetenv("AUTOSCALE_EVENTS_CAPPED_DOCUMENT_SIZE")
+	size := func(id bson.ObjectId) int {
+		var doc bson.M
+		err := s.conn.Events().FindId(id).One(&doc)
+		c.Assert(err, check.IsNil)
+		data, err := bson.Marshal(doc)
+		c.Assert(err, check.IsNil)
+		return len(data)
+	}
+	evt, err := NewEvent(&Alarm{Name: "capped"}, &action.Action{Name: "scale_up"})
+	c.Assert(err, check.IsNil)
+	c.Assert(size(evt.ID), check.Equals, 2048)
+	evt.Attempts = []action.Attempt{{StatusCode: 500, Response: strings.Repeat("é", 2000)}}
+	err = evt.update(errors.New("scale failed"))
+	c.Assert(err, check.IsNil)
+	c.Assert(size(evt.ID), check.Equals, 2048)
+	err = evt.setRollback(&action.Action{Name: "scale_down"}, []action.Attempt{{StatusCode: 200}}, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(size(evt.ID), check.Equals, 2048)
+	_, err = AcknowledgeEvent(evt.ID.Hex(), "admin")
+	c.Assert(err, check.IsNil)
+	c.Assert(size(evt.ID), check.Equals, 2048)
+	other, err := NewEvent(&Alarm{Name: "capped"}, nil)
+	c.Assert(err, check.IsNil)
+	err = other.update(errors.New("scale failed"))
+	c.Assert(err, check.IsNil)
+	n, err := AcknowledgeEvents([]string{evt.ID.Hex(), other.ID.Hex()}, "admin")
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+	c.Assert(size(other.ID), check.Equals, 2048)
+	events, err := FindEventsBy(bson.M{"_id": evt.ID}, 1)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 1)
+	stored := events[0]
+	c.Assert(stored.Error, check.Equals, "scale failed")
+	c.Assert(stored.Rollback.Name, check.Equals, "scale_down")
+	c.Assert(stored.AcknowledgedBy, check.Equals, "admin")
+	c.Assert(len(stored.Attempts[0].Response) < 2048, check.Equals, true)
+	c.Assert(utf8.ValidString(stored.Attempts[0].Response), check.Equals, true)
+	_, err = RemoveEvents(nil, 10, nil)
+	c.Assert(err, check.ErrorMatches, "alarm: the events are capped and can not be removed")
+	err = evt.update(errors.New(strings.Repeat("e", 4096)))
+	c.Assert(err, check.ErrorMatches, "alarm: event .* does not fit in the 2048 bytes of the capped events")
+}
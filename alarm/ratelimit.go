@@ -0,0 +1,87 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alarm
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DefaultRateLimitWindow is the window of the instances rate limit when
+// AUTOSCALE_RATE_LIMIT_WINDOW is not set.
+const DefaultRateLimitWindow = time.Hour
+
+// rateLimitMutex serializes the rate limit checks and the creation of the
+// events they count.
+var rateLimitMutex sync.Mutex
+
+// RateLimitError is returned when an instance executed the maximum number
+// of actions in the rate limit window.
+type RateLimitError struct {
+	Instance string
+	Limit    int
+	Window   time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("alarm: instance %q reached the rate limit of %d actions in %s", e.Instance, e.Limit, e.Window)
+}
+
+// RateLimit returns the maximum number of actions executed by the alarms of
+// an instance in the window, configured by the AUTOSCALE_RATE_LIMIT and
+// AUTOSCALE_RATE_LIMIT_WINDOW, in seconds, environment variables. A zero
+// limit means the actions are not limited.
+func RateLimit() (int, time.Duration) {
+	limit, window := 0, DefaultRateLimitWindow
+	if l := os.Getenv("AUTOSCALE_RATE_LIMIT"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v < 0 {
+			logger().Printf("invalid AUTOSCALE_RATE_LIMIT %q", l)
+		} else {
+			limit = v
+		}
+	}
+	if w := os.Getenv("AUTOSCALE_RATE_LIMIT_WINDOW"); w != "" {
+		v, err := strconv.Atoi(w)
+		if err != nil || v <= 0 {
+			logger().Printf("invalid AUTOSCALE_RATE_LIMIT_WINDOW %q", w)
+		} else {
+			window = time.Duration(v) * time.Second
+		}
+	}
+	return limit, window
+}
+
+// checkRateLimit returns a RateLimitError when the alarms of the instance
+// executed the rate limit of actions, counted by their events, in the
+// window. It must be called with rateLimitMutex locked.
+func checkRateLimit(instance string) error {
+	limit, window := RateLimit()
+	if limit == 0 {
+		return nil
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return err
+	}
+	defer conn.Close()
+	since := time.Now().UTC().Add(-window)
+	n, err := conn.Events().Find(bson.M{"alarm.instance": instance, "starttime": bson.M{"$gte": since}}).Count()
+	if err != nil {
+		logger().Error(err)
+		return err
+	}
+	if n >= limit {
+		return &RateLimitError{Instance: instance, Limit: limit, Window: window}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alarm
+
+import (
+	"os"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRateLimit(c *check.C) {
+	limit, window := RateLimit()
+	c.Assert(limit, check.Equals, 0)
+	c.Assert(window, check.Equals, DefaultRateLimitWindow)
+	os.Setenv("AUTOSCALE_RATE_LIMIT", "5")
+	os.Setenv("AUTOSCALE_RATE_LIMIT_WINDOW", "600")
+	defer os.Unsetenv("AUTOSCALE_RATE_LIMIT")
+	defer os.Unsetenv("AUTOSCALE_RATE_LIMIT_WINDOW")
+	limit, window = RateLimit()
+	c.Assert(limit, check.Equals, 5)
+	c.Assert(window, check.Equals, 10*time.Minute)
+	os.Setenv("AUTOSCALE_RATE_LIMIT", "-1")
+	os.Setenv("AUTOSCALE_RATE_LIMIT_WINDOW", "ten")
+	limit, window = RateLimit()
+	c.Assert(limit, check.Equals, 0)
+	c.Assert(window, check.Equals, DefaultRateLimitWindow)
+}
+
+func (s *S) TestCheckRateLimit(c *check.C) {
+	os.Setenv("AUTOSCALE_RATE_LIMIT", "2")
+	defer os.Unsetenv("AUTOSCALE_RATE_LIMIT")
+	alarm := &Alarm{Name: "cpu", Instance: "instance"}
+	other := &Alarm{Name: "memory", Instance: "other"}
+	a := &action.Action{Name: "scale_up"}
+	_, err := NewEvent(alarm, a)
+	c.Assert(err, check.IsNil)
+	_, err = NewEvent(other, a)
+	c.Assert(err, check.IsNil)
+	c.Assert(checkRateLimit("instance"), check.IsNil)
+	_, err = NewEvent(&Alarm{Name: "memory", Instance: "instance"}, a)
+	c.Assert(err, check.IsNil)
+	err = checkRateLimit("instance")
+	c.Assert(err, check.DeepEquals, &RateLimitError{Instance: "instance", Limit: 2, Window: time.Hour})
+	c.Assert(err, check.ErrorMatches, `alarm: instance "instance" reached the rate limit of 2 actions in 1h0m0s`)
+	c.Assert(checkRateLimit("other"), check.IsNil)
+}
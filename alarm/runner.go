@@ -0,0 +1,99 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alarm
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DefaultRunnerMaxAge is the minimum age of the last cycle of the runners
+// after which they are considered dead, when AUTOSCALE_RUNNER_MAX_AGE is not
+// set.
+const DefaultRunnerMaxAge = 5 * time.Minute
+
+// RunnerStatus is the status of a runner, the agent checking the alarms:
+// the host running it, the end of its last completed cycle and the
+// duration of the cycle.
+type RunnerStatus struct {
+	Host      string        `json:"host" bson:"_id"`
+	LastCycle time.Time     `json:"lastCycle"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// recordCycle stores the end of the cycle started at start by the runner of
+// this host.
+func recordCycle(start time.Time) error {
+	host, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	now := time.Now().UTC()
+	status := RunnerStatus{Host: host, LastCycle: now, Duration: now.Sub(start)}
+	_, err = conn.Runners().Upsert(bson.M{"_id": host}, &status)
+	return err
+}
+
+// LastRunnerStatus returns the status of the runner that completed a cycle
+// last.
+func LastRunnerStatus() (*RunnerStatus, error) {
+	conn, err := db.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var status RunnerStatus
+	err = conn.Runners().Find(nil).Sort("-lastcycle").One(&status)
+	if err == mgo.ErrNotFound {
+		return nil, errors.New("alarm: no runner completed a cycle")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// RunnerStatuses returns the status of the runners, by host.
+func RunnerStatuses() ([]RunnerStatus, error) {
+	conn, err := db.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	statuses := []RunnerStatus{}
+	if err = conn.Runners().Find(nil).Sort("_id").All(&statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// RunnerMaxAge returns the age of the last cycle of the runners after which
+// they are considered dead, configured by the AUTOSCALE_RUNNER_MAX_AGE
+// environment variable, in seconds. It defaults to three check intervals,
+// or DefaultRunnerMaxAge when greater.
+func RunnerMaxAge() time.Duration {
+	if a := os.Getenv("AUTOSCALE_RUNNER_MAX_AGE"); a != "" {
+		v, err := strconv.Atoi(a)
+		if err == nil && v > 0 {
+			return time.Duration(v) * time.Second
+		}
+		logger().Printf("invalid AUTOSCALE_RUNNER_MAX_AGE %q", a)
+	}
+	if age := 3 * interval() * time.Second; age > DefaultRunnerMaxAge {
+		return age
+	}
+	return DefaultRunnerMaxAge
+}
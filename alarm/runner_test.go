@@ -0,0 +1,75 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alarm
+
+import (
+	"os"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRecordCycle(c *check.C) {
+	_, err := LastRunnerStatus()
+	c.Assert(err, check.ErrorMatches, "alarm: no runner completed a cycle")
+	start := time.Now().Add(-time.Second)
+	err = recordCycle(start)
+	c.Assert(err, check.IsNil)
+	err = recordCycle(start)
+	c.Assert(err, check.IsNil)
+	n, err := s.conn.Runners().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+	status, err := LastRunnerStatus()
+	c.Assert(err, check.IsNil)
+	host, _ := os.Hostname()
+	c.Assert(status.Host, check.Equals, host)
+	c.Assert(status.LastCycle.After(start), check.Equals, true)
+	c.Assert(status.Duration >= time.Second, check.Equals, true)
+	statuses, err := RunnerStatuses()
+	c.Assert(err, check.IsNil)
+	c.Assert(statuses, check.DeepEquals, []RunnerStatus{*status})
+}
+
+func (s *S) TestRunnerMaxAge(c *check.C) {
+	c.Assert(RunnerMaxAge(), check.Equals, DefaultRunnerMaxAge)
+	os.Setenv("AUTOSCALE_INTERVAL", "600")
+	defer os.Unsetenv("AUTOSCALE_INTERVAL")
+	c.Assert(RunnerMaxAge(), check.Equals, 30*time.Minute)
+	os.Setenv("AUTOSCALE_RUNNER_MAX_AGE", "90")
+	defer os.Unsetenv("AUTOSCALE_RUNNER_MAX_AGE")
+	c.Assert(RunnerMaxAge(), check.Equals, 90*time.Second)
+}
+
+func (s *S) TestStopAutoScale(c *check.C) {
+	defer func() {
+		runner.stop, runner.done = nil, nil
+	}()
+	os.Setenv("AUTOSCALE_INTERVAL", "3600")
+	defer os.Unsetenv("AUTOSCALE_INTERVAL")
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		close(started)
+		StartAutoScale()
+		close(stopped)
+	}()
+	<-started
+	StopAutoScale()
+	select {
+	case <-stopped:
+	case <-time.After(30 * time.Second):
+		c.Fatal("auto scale not stopped")
+	}
+}
+
+func (s *S) TestStopAutoScaleNotStarted(c *check.C) {
+	defer func() {
+		runner.stop, runner.done = nil, nil
+	}()
+	StopAutoScale()
+	StopAutoScale()
+	StartAutoScale()
+}
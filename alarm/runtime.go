@@ -0,0 +1,83 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alarm
+
+import (
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+// Runtime is the runtime state of an alarm, kept by the engine: the state
+// and since when the alarm is in it, the time, result and error of the
+// last check, the number of consecutive checks in the alarm state, whether
+// the alarm is waiting since its last event and the time of the next
+// check.
+type Runtime struct {
+	Alarm           string     `json:"alarm"`
+	Enabled         bool       `json:"enabled"`
+	State           string     `json:"state,omitempty"`
+	StateReason     string     `json:"stateReason,omitempty"`
+	StateSince      *time.Time `json:"stateSince,omitempty"`
+	LastCheck       *time.Time `json:"lastCheck,omitempty"`
+	LastCheckResult *bool      `json:"lastCheckResult,omitempty"`
+	LastCheckError  string     `json:"lastCheckError,omitempty"`
+	BreachStreak    int        `json:"breachStreak"`
+	Waiting         bool       `json:"waiting"`
+	WaitingUntil    *time.Time `json:"waitingUntil,omitempty"`
+	NextCheck       *time.Time `json:"nextCheck,omitempty"`
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Runtime returns the runtime state of the alarm. The result of the last
+// check is only known when it set the state, so it is empty after failed
+// checks. The alarm waits, without executing its actions, while its last
+// event is running and for its wait after the event finishes; the end of
+// the wait is unknown while the event is running. The next check is
+// estimated from the last cycle of the runners, and is unknown for
+// disabled alarms and when no runner completed a cycle.
+func (a *Alarm) Runtime() (*Runtime, error) {
+	r := Runtime{
+		Alarm:          a.Name,
+		Enabled:        a.Enabled,
+		State:          a.State,
+		StateReason:    a.StateReason,
+		StateSince:     timeOrNil(a.StateSince),
+		LastCheck:      timeOrNil(a.LastCheck),
+		LastCheckError: a.LastCheckError,
+	}
+	if a.LastCheckError == "" && (a.State == StateOK || a.State == StateAlarm) {
+		result := a.State == StateAlarm
+		r.LastCheckResult = &result
+	}
+	if a.State == StateAlarm {
+		r.BreachStreak = a.StateChecks
+	}
+	evt, err := lastScaleEvent(a)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	if err == nil {
+		if evt.EndTime.IsZero() {
+			r.Waiting = true
+		} else if until := evt.EndTime.Add(a.Wait); until.After(time.Now()) {
+			r.Waiting = true
+			r.WaitingUntil = &until
+		}
+	}
+	if a.Enabled {
+		if status, err := LastRunnerStatus(); err == nil {
+			next := status.LastCycle.Add(interval() * time.Second)
+			r.NextCheck = &next
+		}
+	}
+	return &r, nil
+}
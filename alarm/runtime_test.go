@@ -0,0 +1,75 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alarm
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestSetStateCountsChecks(c *check.C) {
+	a := Alarm{Name: "rush", Enabled: true}
+	err := NewAlarm(&a)
+	c.Assert(err, check.IsNil)
+	err = a.setState(StateAlarm, nil)
+	c.Assert(err, check.IsNil)
+	since := a.StateSince
+	err = a.setState(StateAlarm, nil)
+	c.Assert(err, check.IsNil)
+	err = a.setState("", errors.New("timeout"))
+	c.Assert(err, check.IsNil)
+	r, err := FindAlarmByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(r.State, check.Equals, StateAlarm)
+	c.Assert(r.StateChecks, check.Equals, 2)
+	c.Assert(r.StateSince.Equal(since.Truncate(time.Millisecond)), check.Equals, true)
+	c.Assert(r.LastCheckError, check.Equals, "timeout")
+	err = r.setState(StateOK, nil)
+	c.Assert(err, check.IsNil)
+	r, err = FindAlarmByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(r.State, check.Equals, StateOK)
+	c.Assert(r.StateChecks, check.Equals, 1)
+	c.Assert(r.LastCheckError, check.Equals, "")
+}
+
+func (s *S) TestRuntime(c *check.C) {
+	a := Alarm{Name: "rush", Enabled: true, Wait: time.Hour}
+	err := NewAlarm(&a)
+	c.Assert(err, check.IsNil)
+	err = a.setState(StateAlarm, nil)
+	c.Assert(err, check.IsNil)
+	err = a.setState(StateAlarm, nil)
+	c.Assert(err, check.IsNil)
+	evt, err := NewEvent(&a, nil)
+	c.Assert(err, check.IsNil)
+	err = evt.update(nil)
+	c.Assert(err, check.IsNil)
+	err = recordCycle(time.Now())
+	c.Assert(err, check.IsNil)
+	r, err := FindAlarmByName(a.Name)
+	c.Assert(err, check.IsNil)
+	runtime, err := r.Runtime()
+	c.Assert(err, check.IsNil)
+	c.Assert(runtime.Alarm, check.Equals, a.Name)
+	c.Assert(runtime.State, check.Equals, StateAlarm)
+	c.Assert(runtime.LastCheck, check.NotNil)
+	c.Assert(*runtime.LastCheckResult, check.Equals, true)
+	c.Assert(runtime.BreachStreak, check.Equals, 2)
+	c.Assert(runtime.Waiting, check.Equals, true)
+	c.Assert(runtime.WaitingUntil.After(time.Now().Add(59*time.Minute)), check.Equals, true)
+	c.Assert(runtime.NextCheck.After(time.Now()), check.Equals, true)
+}
+
+func (s *S) TestRuntimeNotChecked(c *check.C) {
+	a := Alarm{Name: "rush"}
+	err := NewAlarm(&a)
+	c.Assert(err, check.IsNil)
+	runtime, err := a.Runtime()
+	c.Assert(err, check.IsNil)
+	c.Assert(runtime, check.DeepEquals, &Runtime{Alarm: "rush"})
+}
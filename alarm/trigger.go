@@ -0,0 +1,112 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// TriggerResult is the result of an external trigger of an alarm: the
+// result of its expression, when it was evaluated, whether its actions were
+// executed, and the error of the actions.
+type TriggerResult struct {
+	Alarm    string `json:"alarm"`
+	Check    *bool  `json:"check,omitempty"`
+	Executed bool   `json:"executed"`
+	Error    string `json:"error,omitempty"`
+}
+
+// dataKey matches the valid javascript identifiers, as the data of each data
+// source is a variable of the expressions.
+var dataKey = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// checkTriggerData checks that the keys of the fired data are data sources
+// of the alarm and valid javascript identifiers, so the callers of the
+// trigger can not inject code in the expressions.
+func (a *Alarm) checkTriggerData(data map[string]json.RawMessage) error {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !dataKey.MatchString(key) {
+			return invalid("invalid data key %q: must be a valid javascript identifier", key)
+		}
+		var found bool
+		for _, name := range a.DataSources {
+			found = found || name == key
+		}
+		if !found {
+			return invalid("invalid data key %q: not a data source of alarm %q", key, a.Name)
+		}
+	}
+	return nil
+}
+
+// Trigger evaluates the alarm immediately, out of the runner cycle, and
+// executes its actions when the expression is true. When fire is true, the
+// expression is not evaluated and the actions are executed with data, the
+// payloads by data source name, as the data of the data sources, which must
+// be data sources of the alarm. Either way
+// the wait of the alarm and the conditions of the actions are respected.
+// Disabled alarms can not be triggered.
+func (a *Alarm) Trigger(fire bool, data map[string]json.RawMessage) (*TriggerResult, error) {
+	if !a.Enabled {
+		return nil, fmt.Errorf("alarm %q is disabled", a.Name)
+	}
+	result := TriggerResult{Alarm: a.Name}
+	var payload map[string]string
+	var err error
+	if fire {
+		if err = a.checkTriggerData(data); err != nil {
+			return nil, err
+		}
+		a.logger().Printf("alarm %s - fired by trigger", a.Name)
+		payload = make(map[string]string, len(data))
+		for key, value := range data {
+			payload[key] = string(value)
+		}
+	} else {
+		var check bool
+		check, payload, err = a.checkState()
+		if err != nil {
+			return nil, err
+		}
+		result.Check = &check
+		if !check {
+			return &result, nil
+		}
+	}
+	result.Executed, err = a.fire(payload)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return &result, nil
+}
+
+// Scale executes the actions of the alarm immediately on behalf of user,
+// who intervenes in the auto scale, without evaluating the expression and
+// without waiting since the last event. The events of the actions are
+// recorded as manual, with the user, and the rate limit of the instance is
+// respected. Disabled alarms can be used, as the auto scale may be disabled
+// while the operators intervene.
+func (a *Alarm) Scale(user string) (*TriggerResult, error) {
+	if user == "" {
+		return nil, invalid("the user of the manual scale is required")
+	}
+	a.logger().Printf("alarm %s - scaled manually by %s", a.Name, user)
+	a.user = user
+	result := TriggerResult{Alarm: a.Name}
+	var err error
+	result.Executed, err = a.execute(map[string]string{})
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return &result, nil
+}
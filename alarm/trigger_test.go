@@ -0,0 +1,114 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package alarm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/datasource"
+	"github.com/tsuru/tsuru-autoscale/tsuru"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestTriggerFire(c *check.C) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer ts.Close()
+	myAction := action.Action{Name: "myaction", URL: ts.URL, Method: "GET"}
+	err := action.New(&myAction)
+	c.Assert(err, check.IsNil)
+	err = tsuru.NewInstance(&tsuru.Instance{Name: "instance", Apps: []string{"app"}})
+	c.Assert(err, check.IsNil)
+	alarm := Alarm{
+		Name:        "name",
+		Enabled:     true,
+		Expression:  "false",
+		Actions:     []string{myAction.Name},
+		Instance:    "instance",
+		DataSources: []string{"queue"},
+		Conditions:  map[string]string{myAction.Name: "queue.size > 5"},
+	}
+	err = NewAlarm(&alarm)
+	c.Assert(err, check.IsNil)
+	result, err := alarm.Trigger(true, map[string]json.RawMessage{"queue": json.RawMessage(`{"size":10}`)})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, &TriggerResult{Alarm: "name", Executed: true})
+	c.Assert(calls, check.Equals, 1)
+	var events []Event
+	err = s.conn.Events().Find(nil).All(&events)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 1)
+}
+
+func (s *S) TestTriggerFireInvalidData(c *check.C) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer ts.Close()
+	myAction := action.Action{Name: "myaction", URL: ts.URL, Method: "GET"}
+	err := action.New(&myAction)
+	c.Assert(err, check.IsNil)
+	err = tsuru.NewInstance(&tsuru.Instance{Name: "instance", Apps: []string{"app"}})
+	c.Assert(err, check.IsNil)
+	alarm := Alarm{
+		Name:        "name",
+		Enabled:     true,
+		Expression:  "false",
+		Actions:     []string{myAction.Name},
+		Instance:    "instance",
+		DataSources: []string{"queue"},
+	}
+	err = NewAlarm(&alarm)
+	c.Assert(err, check.IsNil)
+	data := map[string]json.RawMessage{
+		"queue":                           json.RawMessage(`{"size":10}`),
+		"x=1;while(true){};var malicious": json.RawMessage(`1`),
+	}
+	_, err = alarm.Trigger(true, data)
+	c.Assert(err, check.ErrorMatches, `alarm: invalid data key "x=1;while\(true\){};var malicious": must be a valid javascript identifier`)
+	_, err = alarm.Trigger(true, map[string]json.RawMessage{"cpu": json.RawMessage(`1`)})
+	c.Assert(err, check.ErrorMatches, `alarm: invalid data key "cpu": not a data source of alarm "name"`)
+	c.Assert(calls, check.Equals, 0)
+}
+
+func (s *S) TestTriggerCheck(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"ble"}`))
+	}))
+	defer ts.Close()
+	ds := datasource.DataSource{Name: "data", URL: ts.URL, Method: "GET"}
+	err := datasource.New(&ds)
+	c.Assert(err, check.IsNil)
+	err = tsuru.NewInstance(&tsuru.Instance{Name: "instance", Apps: []string{"app"}})
+	c.Assert(err, check.IsNil)
+	alarm := Alarm{
+		Name:        "name",
+		Enabled:     true,
+		Expression:  `data.id === "other"`,
+		DataSources: []string{ds.Name},
+		Instance:    "instance",
+	}
+	err = NewAlarm(&alarm)
+	c.Assert(err, check.IsNil)
+	result, err := alarm.Trigger(false, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(*result.Check, check.Equals, false)
+	c.Assert(result.Executed, check.Equals, false)
+	a, err := FindAlarmByName(alarm.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.State, check.Equals, StateOK)
+}
+
+func (s *S) TestTriggerDisabled(c *check.C) {
+	alarm := Alarm{Name: "name"}
+	_, err := alarm.Trigger(false, nil)
+	c.Assert(err, check.ErrorMatches, `alarm "name" is disabled`)
+}
@@ -8,8 +8,8 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 
-	"github.com/gorilla/mux"
 	"github.com/tsuru/tsuru-autoscale/action"
 )
 
@@ -33,16 +33,23 @@ func newAction(w http.ResponseWriter, r *http.Request) error {
 }
 
 func allActions(w http.ResponseWriter, r *http.Request) error {
-	actions, err := action.All()
+	opts, err := actionList.options(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	actions, total, err := action.List(opts)
 	if err != nil {
 		return err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(actions)
+	redacted := make([]*action.Action, len(actions))
+	for i := range actions {
+		redacted[i] = actions[i].Redacted()
+	}
+	return writeList(w, total, redacted)
 }
 
 func removeAction(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	a, err := action.FindByName(vars["name"])
 	if err != nil {
 		return err
@@ -51,11 +58,183 @@ func removeAction(w http.ResponseWriter, r *http.Request) error {
 }
 
 func actionInfo(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
+	a, err := action.FindByName(vars["name"])
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(a.Redacted())
+}
+
+func actionExecutions(w http.ResponseWriter, r *http.Request) error {
+	vars := routeVars(r)
 	a, err := action.FindByName(vars["name"])
 	if err != nil {
 		return err
 	}
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 1000 {
+			return badRequest("limit must be between 1 and 1000")
+		}
+	}
+	executions, err := action.FindExecutions(a.Name, r.URL.Query().Get("alarm"), limit)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(executions)
+}
+
+func testFireAction(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var sample action.SampleEvent
+	err = json.Unmarshal(body, &sample)
+	if err != nil {
+		return err
+	}
+	result, err := action.TestFire(routeVars(r)["name"], &sample)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(result)
+}
+
+func updateAction(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var a action.Action
+	err = json.Unmarshal(body, &a)
+	if err != nil {
+		return err
+	}
+	a.Name = routeVars(r)["name"]
+	return action.Update(&a)
+}
+
+func updateActions(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var actions []action.Action
+	err = json.Unmarshal(body, &actions)
+	if err != nil {
+		return err
+	}
+	return action.UpdateAll(actions)
+}
+
+func exportActions(w http.ResponseWriter, r *http.Request) error {
+	actions, err := action.All()
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, name := range r.URL.Query()["name"] {
+		names[name] = true
+	}
+	exported := []*action.Action{}
+	for i := range actions {
+		if len(names) == 0 || names[actions[i].Name] {
+			exported = append(exported, actions[i].Redacted())
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="actions.json"`)
+	return json.NewEncoder(w).Encode(exported)
+}
+
+func importActions(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var actions []action.Action
+	err = json.Unmarshal(body, &actions)
+	if err != nil {
+		return err
+	}
+	result, err := action.Import(actions)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(result)
+}
+
+func replaceActionsHost(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var params struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	err = json.Unmarshal(body, &params)
+	if err != nil {
+		return err
+	}
+	if params.From == "" || params.To == "" {
+		return badRequest("from and to hosts required")
+	}
+	updated, err := action.ReplaceHost(params.From, params.To)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string][]string{"updated": updated})
+}
+
+func listDeadLetters(w http.ResponseWriter, r *http.Request) error {
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 1000 {
+			return badRequest("limit must be between 1 and 1000")
+		}
+	}
+	letters, err := action.FindDeadLetters(r.URL.Query().Get("action"), limit)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(letters)
+}
+
+func deadLetterInfo(w http.ResponseWriter, r *http.Request) error {
+	letter, err := action.FindDeadLetter(routeVars(r)["id"])
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(letter)
+}
+
+func removeDeadLetter(w http.ResponseWriter, r *http.Request) error {
+	return action.RemoveDeadLetter(routeVars(r)["id"])
+}
+
+func replayDeadLetter(w http.ResponseWriter, r *http.Request) error {
+	result, err := action.ReplayDeadLetter(routeVars(r)["id"])
+	if err != nil {
+		return err
+	}
 	w.Header().Set("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(a)
+	return json.NewEncoder(w).Encode(result)
 }
@@ -89,3 +89,197 @@ func (s *S) TestActionInfo(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(a.Name, check.Equals, got.Name)
 }
+
+func (s *S) TestActionExecutions(c *check.C) {
+	a := &action.Action{URL: "http://tsuru.io", Method: "GET", Name: "scale_up"}
+	err := action.New(a)
+	c.Assert(err, check.IsNil)
+	ctx := &action.Context{Alarm: action.AlarmContext{Name: "cpu"}}
+	err = action.RecordExecutions(a, ctx, []action.Attempt{{StatusCode: 502}, {StatusCode: 200}})
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/action/scale_up/executions?alarm=cpu&limit=1", nil)
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var executions []action.Execution
+	err = json.Unmarshal(recorder.Body.Bytes(), &executions)
+	c.Assert(err, check.IsNil)
+	c.Assert(executions, check.HasLen, 1)
+	c.Assert(executions[0].Action, check.Equals, "scale_up")
+	c.Assert(executions[0].Alarm, check.Equals, "cpu")
+}
+
+func (s *S) TestTestFireAction(c *check.C) {
+	a := &action.Action{URL: "http://tsuru.io/apps/{app}/units?cpu={data.cpu.value}", Method: "PUT", Name: "scale_up", DryRun: true}
+	err := action.New(a)
+	c.Assert(err, check.IsNil)
+	body := `{"app": "myapp", "data": {"cpu": {"value": 95}}}`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/action/scale_up/test", strings.NewReader(body))
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result action.TestFireResult
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Error, check.Equals, "")
+	c.Assert(result.Request, check.Matches, "(?s)PUT http://tsuru.io/apps/myapp/units\\?cpu=95.*")
+	c.Assert(result.Attempts, check.HasLen, 1)
+	c.Assert(result.Attempts[0].DryRun, check.Equals, true)
+}
+
+func (s *S) TestTestFireActionNotFound(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/action/unknown/test", strings.NewReader("{}"))
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestActionExecutionsInvalidLimit(c *check.C) {
+	a := &action.Action{URL: "http://tsuru.io", Method: "GET", Name: "scale_up"}
+	err := action.New(a)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/action/scale_up/executions?limit=0", nil)
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestUpdateAction(c *check.C) {
+	err := action.New(&action.Action{Name: "notify", URL: "http://tsuru.io", Method: "GET"})
+	c.Assert(err, check.IsNil)
+	body := `{"url":"http://tsuru.io","method":"POST"}`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("PUT", "/action/notify", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	a, err := action.FindByName("notify")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Method, check.Equals, "POST")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("PUT", "/action/missing", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestUpdateActions(c *check.C) {
+	err := action.New(&action.Action{Name: "notify", URL: "http://tsuru.io", Method: "GET"})
+	c.Assert(err, check.IsNil)
+	body := `[{"name":"notify","url":"http://tsuru.io","method":"POST"}]`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("PUT", "/action", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	a, err := action.FindByName("notify")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Method, check.Equals, "POST")
+}
+
+func (s *S) TestExportImportActions(c *check.C) {
+	err := action.New(&action.Action{Name: "notify", URL: "http://tsuru.io", Method: "GET", Secret: "mysecret"})
+	c.Assert(err, check.IsNil)
+	err = action.New(&action.Action{Name: "other", URL: "http://tsuru.io", Method: "GET"})
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/action/export?name=notify", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Disposition"), check.Equals, `attachment; filename="actions.json"`)
+	exported := recorder.Body.String()
+	c.Assert(strings.Contains(exported, "mysecret"), check.Equals, false)
+	var actions []action.Action
+	err = json.Unmarshal([]byte(exported), &actions)
+	c.Assert(err, check.IsNil)
+	c.Assert(actions, check.HasLen, 1)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/action/import", strings.NewReader(exported))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result action.ImportResult
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Updated, check.DeepEquals, []string{"notify"})
+	a, err := action.FindByName("notify")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Secret, check.Equals, "mysecret")
+}
+
+func (s *S) TestReplaceActionsHost(c *check.C) {
+	err := action.New(&action.Action{Name: "scale_up", URL: "http://tsuru.old.io/apps", Method: "GET"})
+	c.Assert(err, check.IsNil)
+	body := `{"from":"tsuru.old.io","to":"tsuru.new.io"}`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/action/replace-host", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result map[string][]string
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["updated"], check.DeepEquals, []string{"scale_up"})
+	a, err := action.FindByName("scale_up")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.URL, check.Equals, "http://tsuru.new.io/apps")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/action/replace-host", strings.NewReader(`{"from":"tsuru.io"}`))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestDeadLetters(c *check.C) {
+	failing := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+	a := &action.Action{URL: ts.URL + "/{app}", Method: "POST", Name: "scale_up"}
+	err := action.New(a)
+	c.Assert(err, check.IsNil)
+	ctx := &action.Context{App: "myapp", Alarm: action.AlarmContext{Name: "cpu"}}
+	attempts, err := a.Execute(ctx)
+	c.Assert(err, check.NotNil)
+	err = action.RecordDeadLetter(a, ctx, attempts, err)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/action/dead-letter?action=scale_up", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var letters []action.DeadLetter
+	err = json.Unmarshal(recorder.Body.Bytes(), &letters)
+	c.Assert(err, check.IsNil)
+	c.Assert(letters, check.HasLen, 1)
+	c.Assert(letters[0].Alarm, check.Equals, "cpu")
+	c.Assert(letters[0].Request, check.Equals, "POST "+ts.URL+"/myapp\n\n")
+	failing = false
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", fmt.Sprintf("/action/dead-letter/%s/replay", letters[0].ID.Hex()), nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result action.ReplayResult
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Error, check.Equals, "")
+	c.Assert(result.Attempts, check.HasLen, 1)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/action/dead-letter/"+letters[0].ID.Hex(), nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
@@ -0,0 +1,366 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/tsuru"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// defaultAdminWindow is the period of the failure counts of the overview,
+// when since is not set.
+const defaultAdminWindow = 24 * time.Hour
+
+// adminHandler is a handler of the admin routes, authorized by the
+// AUTOSCALE_ADMIN_TOKEN bearer token. The admin routes are disabled when the
+// token is not set.
+type adminHandler func(http.ResponseWriter, *http.Request) error
+
+func (fn adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := os.Getenv("AUTOSCALE_ADMIN_TOKEN")
+	if token == "" {
+		writeError(w, newError(http.StatusForbidden, codeForbidden, "admin routes are disabled"))
+		return
+	}
+	if !hasToken(r, token) {
+		writeError(w, newError(http.StatusUnauthorized, codeUnauthorized, "invalid admin token"))
+		return
+	}
+	handler(fn).ServeHTTP(w, r)
+}
+
+var adminInstanceList = listSpec{
+	filters: map[string]listField{"team": {field: "team"}, "enabled": {field: "enabled", boolean: true}},
+	sorts:   map[string]string{"name": "name", "team": "team", "failures": "failures", "lastevent": "lastevent"},
+}
+
+// adminEvent is the last event of an instance.
+type adminEvent struct {
+	Alarm      string    `json:"alarm"`
+	Action     string    `json:"action"`
+	StartTime  time.Time `json:"startTime"`
+	Successful bool      `json:"successful"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// adminInstance is the overview of a service instance: its team, whether
+// all its alarms are enabled, its last event and the counts of its events
+// and failed events since the start of the window.
+type adminInstance struct {
+	Name          string      `json:"name"`
+	Team          string      `json:"team"`
+	Pool          string      `json:"pool,omitempty"`
+	Plan          string      `json:"plan,omitempty"`
+	Apps          []string    `json:"apps"`
+	Enabled       bool        `json:"enabled"`
+	Alarms        int         `json:"alarms"`
+	EnabledAlarms int         `json:"enabledAlarms"`
+	Events        int         `json:"events"`
+	Failures      int         `json:"failures"`
+	LastEvent     *adminEvent `json:"lastEvent,omitempty"`
+}
+
+// alarmCounts are the numbers of alarms and enabled alarms of an instance.
+type alarmCounts struct {
+	Instance string `bson:"_id"`
+	Alarms   int    `bson:"alarms"`
+	Enabled  int    `bson:"enabled"`
+}
+
+// eventCounts are the numbers of events and failed events of an instance.
+type eventCounts struct {
+	Instance string `bson:"_id"`
+	Events   int    `bson:"events"`
+	Failures int    `bson:"failures"`
+}
+
+// lastEvent is the last event of an instance.
+type lastEvent struct {
+	Instance   string    `bson:"_id"`
+	Alarm      string    `bson:"alarm"`
+	Action     string    `bson:"action"`
+	StartTime  time.Time `bson:"starttime"`
+	Successful bool      `bson:"successful"`
+	Error      string    `bson:"error"`
+}
+
+// adminInstances returns the overview of the instances matching q, with
+// the failure counts since the time, in the order of the instances. The
+// statistics are aggregated from the storage of the reports.
+func adminInstances(q bson.M, since time.Time) ([]adminInstance, error) {
+	store, err := db.OpenReports()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	var instances []tsuru.Instance
+	if err = store.Instances().Find(q).Sort("name").All(&instances); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, i := range instances {
+		names = append(names, i.Name)
+	}
+	var alarms []alarmCounts
+	err = store.Alarms().Pipe([]bson.M{
+		{"$match": bson.M{"instance": bson.M{"$in": names}}},
+		{"$group": bson.M{
+			"_id":     "$instance",
+			"alarms":  bson.M{"$sum": 1},
+			"enabled": bson.M{"$sum": bson.M{"$cond": []interface{}{"$enabled", 1, 0}}},
+		}},
+	}).All(&alarms)
+	if err != nil {
+		return nil, err
+	}
+	var counts []eventCounts
+	err = store.Events().Pipe([]bson.M{
+		{"$match": bson.M{"alarm.instance": bson.M{"$in": names}, "starttime": bson.M{"$gte": since}}},
+		{"$group": bson.M{
+			"_id":    "$alarm.instance",
+			"events": bson.M{"$sum": 1},
+			// the failed events are the ones with an error, as the events
+			// in progress are not successful yet.
+			"failures": bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$ifNull": []interface{}{"$error", false}}, 1, 0}}},
+		}},
+	}).All(&counts)
+	if err != nil {
+		return nil, err
+	}
+	var last []lastEvent
+	err = store.Events().Pipe([]bson.M{
+		{"$match": bson.M{"alarm.instance": bson.M{"$in": names}}},
+		{"$sort": bson.M{"alarm.instance": 1, "starttime": -1}},
+		{"$group": bson.M{
+			"_id":        "$alarm.instance",
+			"alarm":      bson.M{"$first": "$alarm.name"},
+			"action":     bson.M{"$first": "$action.name"},
+			"starttime":  bson.M{"$first": "$starttime"},
+			"successful": bson.M{"$first": "$successful"},
+			"error":      bson.M{"$first": "$error"},
+		}},
+	}).All(&last)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]adminInstance, len(instances))
+	index := make(map[string]*adminInstance, len(instances))
+	for n, i := range instances {
+		result[n] = adminInstance{Name: i.Name, Team: i.Team, Pool: i.Pool, Plan: i.Plan, Apps: i.Apps}
+		if result[n].Apps == nil {
+			result[n].Apps = []string{}
+		}
+		index[i.Name] = &result[n]
+	}
+	for _, a := range alarms {
+		if i := index[a.Instance]; i != nil {
+			i.Alarms, i.EnabledAlarms = a.Alarms, a.Enabled
+			i.Enabled = a.Alarms > 0 && a.Enabled == a.Alarms
+		}
+	}
+	for _, c := range counts {
+		if i := index[c.Instance]; i != nil {
+			i.Events, i.Failures = c.Events, c.Failures
+		}
+	}
+	for _, e := range last {
+		if i := index[e.Instance]; i != nil {
+			i.LastEvent = &adminEvent{Alarm: e.Alarm, Action: e.Action, StartTime: e.StartTime.UTC(), Successful: e.Successful, Error: e.Error}
+		}
+	}
+	return result, nil
+}
+
+// adminLess returns whether the instance a comes before b by the field, the
+// ones with most failures and the latest events first.
+func adminLess(a, b *adminInstance, field string) (less, equal bool) {
+	switch field {
+	case "team":
+		return a.Team < b.Team, a.Team == b.Team
+	case "failures":
+		return a.Failures > b.Failures, a.Failures == b.Failures
+	case "lastevent":
+		var at, bt time.Time
+		if a.LastEvent != nil {
+			at = a.LastEvent.StartTime
+		}
+		if b.LastEvent != nil {
+			bt = b.LastEvent.StartTime
+		}
+		return at.After(bt), at.Equal(bt)
+	}
+	return a.Name < b.Name, a.Name == b.Name
+}
+
+// sortAdminInstances sorts the instances by the fields, prefixed by - for
+// the reverse order, and then by name.
+func sortAdminInstances(instances []adminInstance, fields []string) {
+	sort.SliceStable(instances, func(x, y int) bool {
+		a, b := &instances[x], &instances[y]
+		for _, field := range fields {
+			reverse := field[0] == '-'
+			if reverse {
+				field = field[1:]
+			}
+			less, equal := adminLess(a, b, field)
+			if equal {
+				continue
+			}
+			return less != reverse
+		}
+		return a.Name < b.Name
+	})
+}
+
+// adminWindow returns the start of the window of the failure counts, from
+// since, or the last 24 hours.
+func adminWindow(r *http.Request) (time.Time, error) {
+	since, err := exportTime(r, "since")
+	if err != nil || !since.IsZero() {
+		return since, err
+	}
+	return time.Now().UTC().Add(-defaultAdminWindow), nil
+}
+
+// listAdminInstances lists the service instances of all the teams, with
+// whether their alarms are enabled, their last events and the counts of
+// their failures since the start of the window. sort=failures lists the
+// ones with most failures first.
+func listAdminInstances(w http.ResponseWriter, r *http.Request) error {
+	opts, err := adminInstanceList.options(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	since, err := adminWindow(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	q := bson.M{}
+	if team, ok := opts.Filter["team"]; ok {
+		q["team"] = team
+	}
+	instances, err := adminInstances(q, since)
+	if err != nil {
+		return err
+	}
+	if enabled, ok := opts.Filter["enabled"].(bool); ok {
+		var filtered []adminInstance
+		for _, i := range instances {
+			if i.Enabled == enabled {
+				filtered = append(filtered, i)
+			}
+		}
+		instances = filtered
+	}
+	sortAdminInstances(instances, opts.Sort)
+	total := len(instances)
+	page := []adminInstance{}
+	if opts.Offset < total {
+		end := total
+		if opts.Limit > 0 && opts.Offset+opts.Limit < end {
+			end = opts.Offset + opts.Limit
+		}
+		page = append(page, instances[opts.Offset:end]...)
+	}
+	return writeList(w, total, page)
+}
+
+// adminInstanceInfo gets the overview of a service instance.
+func adminInstanceInfo(w http.ResponseWriter, r *http.Request) error {
+	since, err := adminWindow(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	name := routeVars(r)["name"]
+	instances, err := adminInstances(bson.M{"name": name}, since)
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		return newError(http.StatusNotFound, codeNotFound, fmt.Sprintf("instance %q not found", name))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(instances[0])
+}
+
+const (
+	// defaultRemoveBatch is the number of events removed by batch, when
+	// batch is not set.
+	defaultRemoveBatch = 1000
+	// maxRemoveBatch is the maximum number of events removed by batch.
+	maxRemoveBatch = 10000
+)
+
+// removeProgress is a progress line of the removal of events: the number
+// of events removed so far, out of the total matching the filters, and
+// the error stopping the removal.
+type removeProgress struct {
+	Removed int    `json:"removed"`
+	Total   int    `json:"total"`
+	Done    bool   `json:"done"`
+	Error   string `json:"error,omitempty"`
+}
+
+// removeEvents removes the events started before the before time, and
+// matching the filters of the exports, in batches, streaming the progress
+// as newline delimited JSON, for the installations that can not use a TTL
+// index to reclaim the space of the events collection.
+func removeEvents(w http.ResponseWriter, r *http.Request) error {
+	opts, err := eventExport.options(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	before, err := exportTime(r, "before")
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if before.IsZero() {
+		return badRequest("before is required")
+	}
+	batch := defaultRemoveBatch
+	if b := r.URL.Query().Get("batch"); b != "" {
+		batch, err = strconv.Atoi(b)
+		if err != nil || batch < 1 || batch > maxRemoveBatch {
+			return badRequest(fmt.Sprintf("batch must be between 1 and %d", maxRemoveBatch))
+		}
+	}
+	q := opts.Filter
+	q["starttime"] = bson.M{"$lt": before}
+	_, total, err := alarm.FindEvents(q, &db.ListOptions{Limit: 1})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	progress := removeProgress{Total: total}
+	removed, err := alarm.RemoveEvents(q, batch, func(removed int) error {
+		progress.Removed = removed
+		if err := encoder.Encode(progress); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	progress.Removed, progress.Done = removed, err == nil
+	if err != nil {
+		// the response is already sent, so the error is the last line.
+		requestLogger(r).Error(err)
+		progress.Error = err.Error()
+	}
+	return encoder.Encode(progress)
+}
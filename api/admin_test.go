@@ -0,0 +1,187 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/tsuru"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestSortAdminInstances(c *check.C) {
+	now := time.Now()
+	instances := []adminInstance{
+		{Name: "a", Team: "y", Failures: 1},
+		{Name: "b", Team: "x", Failures: 3, LastEvent: &adminEvent{StartTime: now}},
+		{Name: "c", Team: "x", Failures: 3, LastEvent: &adminEvent{StartTime: now.Add(-time.Minute)}},
+	}
+	names := func() []string {
+		var names []string
+		for _, i := range instances {
+			names = append(names, i.Name)
+		}
+		return names
+	}
+	sortAdminInstances(instances, []string{"-name"})
+	c.Assert(names(), check.DeepEquals, []string{"c", "b", "a"})
+	sortAdminInstances(instances, []string{"failures"})
+	c.Assert(names(), check.DeepEquals, []string{"b", "c", "a"})
+	sortAdminInstances(instances, []string{"-failures"})
+	c.Assert(names(), check.DeepEquals, []string{"a", "b", "c"})
+	sortAdminInstances(instances, []string{"team", "lastevent"})
+	c.Assert(names(), check.DeepEquals, []string{"b", "c", "a"})
+}
+
+func (s *S) TestAdminWithoutToken(c *check.C) {
+	os.Unsetenv("AUTOSCALE_ADMIN_TOKEN")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/admin/instances", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestAdminInvalidToken(c *check.C) {
+	os.Setenv("AUTOSCALE_ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_ADMIN_TOKEN")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/admin/instances", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer other")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+}
+
+func (s *S) TestListAdminInstancesInvalidSort(c *check.C) {
+	os.Setenv("AUTOSCALE_ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_ADMIN_TOKEN")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/admin/instances?sort=apps", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestListAdminInstances(c *check.C) {
+	os.Setenv("AUTOSCALE_ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_ADMIN_TOKEN")
+	for _, i := range []tsuru.Instance{{Name: "quiet", Team: "a"}, {Name: "noisy", Team: "b", Apps: []string{"web"}}} {
+		instance := i
+		err := tsuru.NewInstance(&instance)
+		c.Assert(err, check.IsNil)
+	}
+	err := alarm.NewAlarm(&alarm.Alarm{Name: "high", Instance: "noisy", Enabled: true})
+	c.Assert(err, check.IsNil)
+	now := time.Now().UTC()
+	events := []alarm.Event{
+		{ID: bson.NewObjectId(), StartTime: now.Add(-48 * time.Hour), Alarm: &alarm.Alarm{Name: "high", Instance: "noisy"}, Error: "old"},
+		{ID: bson.NewObjectId(), StartTime: now.Add(-time.Hour), Alarm: &alarm.Alarm{Name: "high", Instance: "noisy"}, Error: "failed"},
+		{ID: bson.NewObjectId(), StartTime: now, Alarm: &alarm.Alarm{Name: "high", Instance: "noisy"}, Action: &action.Action{Name: "scale_up"}, Successful: true},
+	}
+	for _, evt := range events {
+		err = s.conn.Events().Insert(evt)
+		c.Assert(err, check.IsNil)
+	}
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/admin/instances?sort=failures", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("X-Total-Count"), check.Equals, "2")
+	var instances []adminInstance
+	err = json.NewDecoder(recorder.Body).Decode(&instances)
+	c.Assert(err, check.IsNil)
+	c.Assert(instances, check.HasLen, 2)
+	c.Assert(instances[0].Name, check.Equals, "noisy")
+	c.Assert(instances[0].Team, check.Equals, "b")
+	c.Assert(instances[0].Enabled, check.Equals, true)
+	c.Assert(instances[0].Alarms, check.Equals, 1)
+	c.Assert(instances[0].Events, check.Equals, 2)
+	c.Assert(instances[0].Failures, check.Equals, 1)
+	c.Assert(instances[0].LastEvent.Action, check.Equals, "scale_up")
+	c.Assert(instances[0].LastEvent.Successful, check.Equals, true)
+	c.Assert(instances[1].Name, check.Equals, "quiet")
+	c.Assert(instances[1].Enabled, check.Equals, false)
+	c.Assert(instances[1].LastEvent, check.IsNil)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/admin/instances?team=a", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("X-Total-Count"), check.Equals, "1")
+}
+
+func (s *S) TestAdminInstanceInfoNotFound(c *check.C) {
+	os.Setenv("AUTOSCALE_ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_ADMIN_TOKEN")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/admin/instances/unknown", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRemoveEvents(c *check.C) {
+	os.Setenv("AUTOSCALE_ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_ADMIN_TOKEN")
+	now := time.Now().UTC()
+	events := []alarm.Event{
+		{ID: bson.NewObjectId(), StartTime: now.Add(-72 * time.Hour), Alarm: &alarm.Alarm{Name: "high", Instance: "noisy"}, Successful: true},
+		{ID: bson.NewObjectId(), StartTime: now.Add(-71 * time.Hour), Alarm: &alarm.Alarm{Name: "high", Instance: "noisy"}, Successful: true},
+		{ID: bson.NewObjectId(), StartTime: now.Add(-70 * time.Hour), Alarm: &alarm.Alarm{Name: "high", Instance: "noisy"}, Successful: true},
+		{ID: bson.NewObjectId(), StartTime: now.Add(-70 * time.Hour), Alarm: &alarm.Alarm{Name: "high", Instance: "noisy"}, Error: "failed"},
+		{ID: bson.NewObjectId(), StartTime: now, Alarm: &alarm.Alarm{Name: "high", Instance: "noisy"}, Successful: true},
+	}
+	for _, evt := range events {
+		err := s.conn.Events().Insert(evt)
+		c.Assert(err, check.IsNil)
+	}
+	before := now.Add(-time.Hour).Format(time.RFC3339)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("DELETE", "/admin/events?successful=true&batch=2&instance=noisy&before="+before, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-ndjson")
+	c.Assert(recorder.Body.String(), check.Equals, `{"removed":2,"total":3,"done":false}
+{"removed":3,"total":3,"done":false}
+{"removed":3,"total":3,"done":true}
+`)
+	n, err := s.conn.Events().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 2)
+}
+
+func (s *S) TestRemoveEventsInvalidParams(c *check.C) {
+	os.Setenv("AUTOSCALE_ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_ADMIN_TOKEN")
+	for query, msg := range map[string]string{
+		"":                                    "before is required",
+		"before=yesterday":                    "before must be a RFC 3339 time",
+		"before=2017-03-01T00:00:00Z&batch=0": "batch must be between 1 and 10000",
+		"before=2017-03-01T00:00:00Z&successful=x": "successful must be true or false",
+	} {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("DELETE", "/admin/events?"+query, nil)
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Authorization", "bearer secret")
+		server(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest)
+		c.Check(recorder.Body.String(), check.Matches, `(?s).*"message":"`+msg+`".*`)
+	}
+}
@@ -6,11 +6,14 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
 
-	"github.com/gorilla/mux"
 	"github.com/tsuru/tsuru-autoscale/alarm"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func newAlarm(w http.ResponseWriter, r *http.Request) error {
@@ -19,6 +22,9 @@ func newAlarm(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	if err = validateBody(schemas["alarm"], body); err != nil {
+		return err
+	}
 	var a alarm.Alarm
 	err = json.Unmarshal(body, &a)
 	if err != nil {
@@ -33,27 +39,33 @@ func newAlarm(w http.ResponseWriter, r *http.Request) error {
 }
 
 func listAlarms(w http.ResponseWriter, r *http.Request) error {
+	opts, err := alarmList.options(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
 	token := r.Header.Get("Authorization")
-	alarms, err := alarm.ListAlarmsByToken(token)
+	alarms, total, err := alarm.ListAlarmsByToken(token, opts)
 	if err != nil {
 		return err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(alarms)
+	return writeList(w, total, alarms)
 }
 
 func listAlarmsByInstance(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
-	alarms, err := alarm.ListAlarmsByInstance(vars["instance"])
+	opts, err := alarmList.options(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	vars := routeVars(r)
+	alarms, total, err := alarm.ListAlarmsByInstance(vars["instance"], opts)
 	if err != nil {
 		return err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(alarms)
+	return writeList(w, total, alarms)
 }
 
 func removeAlarm(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	a, err := alarm.FindAlarmByName(vars["name"])
 	if err != nil {
 		return err
@@ -62,7 +74,7 @@ func removeAlarm(w http.ResponseWriter, r *http.Request) error {
 }
 
 func enableAlarm(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	a, err := alarm.FindAlarmByName(vars["name"])
 	if err != nil {
 		return err
@@ -71,7 +83,7 @@ func enableAlarm(w http.ResponseWriter, r *http.Request) error {
 }
 
 func disableAlarm(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	a, err := alarm.FindAlarmByName(vars["name"])
 	if err != nil {
 		return err
@@ -80,7 +92,7 @@ func disableAlarm(w http.ResponseWriter, r *http.Request) error {
 }
 
 func getAlarm(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	a, err := alarm.FindAlarmByName(vars["name"])
 	if err != nil {
 		return err
@@ -89,16 +101,124 @@ func getAlarm(w http.ResponseWriter, r *http.Request) error {
 	return json.NewEncoder(w).Encode(a)
 }
 
+func alarmState(w http.ResponseWriter, r *http.Request) error {
+	a, err := alarm.FindAlarmByName(routeVars(r)["name"])
+	if err != nil {
+		return err
+	}
+	state, err := a.Runtime()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(state)
+}
+
+// ackRequest is the body of the acknowledgments: the ids of the events, in
+// bulk, and the user who reviewed them, the principal of the request by
+// default.
+type ackRequest struct {
+	IDs  []string `json:"ids"`
+	User string   `json:"user"`
+}
+
+func decodeAck(r *http.Request) (*ackRequest, error) {
+	defer r.Body.Close()
+	var req ackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return nil, badRequest("invalid body: " + err.Error())
+	}
+	if req.User == "" {
+		req.User = principal(r)
+	}
+	return &req, nil
+}
+
+func acknowledgeEvent(w http.ResponseWriter, r *http.Request) error {
+	req, err := decodeAck(r)
+	if err != nil {
+		return err
+	}
+	evt, err := alarm.AcknowledgeEvent(routeVars(r)["id"], req.User)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(newEventV2(evt))
+}
+
+func acknowledgeEvents(w http.ResponseWriter, r *http.Request) error {
+	req, err := decodeAck(r)
+	if err != nil {
+		return err
+	}
+	n, err := alarm.AcknowledgeEvents(req.IDs, req.User)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]int{"acknowledged": n})
+}
+
 func listEvents(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	a, err := alarm.FindAlarmByName(vars["name"])
 	if err != nil {
 		return err
 	}
-	events, err := alarm.EventsByAlarmName(a.Name)
+	opts, err := eventList.options(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	events, total, err := alarm.FindEvents(bson.M{"alarm.name": a.Name}, opts)
+	if err != nil {
+		return err
+	}
+	return writeList(w, total, events)
+}
+
+// triggerRequest is the body of the alarm triggers: when fire is true, the
+// actions are executed with data as the data of the data sources, without
+// evaluating the expression.
+type triggerRequest struct {
+	Fire bool                       `json:"fire"`
+	Data map[string]json.RawMessage `json:"data"`
+}
+
+// triggerAlarm lets external systems, authorized by the
+// AUTOSCALE_TRIGGER_TOKEN bearer token, evaluate or fire an alarm out of the
+// runner cycle. Triggers are disabled when the token is not set.
+func triggerAlarm(w http.ResponseWriter, r *http.Request) error {
+	token := os.Getenv("AUTOSCALE_TRIGGER_TOKEN")
+	if token == "" {
+		return newError(http.StatusForbidden, codeForbidden, "alarm triggers are disabled")
+	}
+	if !hasToken(r, token) {
+		return newError(http.StatusUnauthorized, codeUnauthorized, "invalid trigger token")
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var req triggerRequest
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &req); err != nil {
+			return badRequest("invalid body: " + err.Error())
+		}
+	}
+	a, err := alarm.FindAlarmByName(routeVars(r)["name"])
+	if err != nil {
+		return err
+	}
+	if !a.Enabled {
+		return newError(http.StatusConflict, codeConflict, fmt.Sprintf("alarm %q is disabled", a.Name))
+	}
+	a.SetLogger(requestLogger(r))
+	result, err := a.Trigger(req.Fire, req.Data)
 	if err != nil {
 		return err
 	}
 	w.Header().Set("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(events)
+	return json.NewEncoder(w).Encode(result)
 }
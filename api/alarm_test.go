@@ -11,9 +11,11 @@ import (
 	"net/http/httptest"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/tsuru/tsuru-autoscale/alarm"
 	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func (s *S) TestNewAlarm(c *check.C) {
@@ -120,6 +122,32 @@ func (s *S) TestGetAlarm(c *check.C) {
 	c.Assert(a.Name, check.Equals, got.Name)
 }
 
+func (s *S) TestAlarmState(c *check.C) {
+	a := &alarm.Alarm{Name: "myalarm", Enabled: true}
+	err := alarm.NewAlarm(a)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/alarm/myalarm/state", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Add("Authorization", "token")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.HeaderMap["Content-Type"], check.DeepEquals, []string{"application/json"})
+	var got alarm.Runtime
+	err = json.Unmarshal(recorder.Body.Bytes(), &got)
+	c.Assert(err, check.IsNil)
+	c.Assert(got, check.DeepEquals, alarm.Runtime{Alarm: "myalarm", Enabled: true})
+}
+
+func (s *S) TestAlarmStateNotFound(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/alarm/unknown/state", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Add("Authorization", "token")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
 func (s *S) TestListEvents(c *check.C) {
 	a := &alarm.Alarm{Name: "myalarm"}
 	err := alarm.NewAlarm(a)
@@ -140,6 +168,64 @@ func (s *S) TestListEvents(c *check.C) {
 	c.Assert(events, check.HasLen, 1)
 }
 
+func (s *S) TestAcknowledgeEvents(c *check.C) {
+	err := alarm.NewAlarm(&alarm.Alarm{Name: "myalarm"})
+	c.Assert(err, check.IsNil)
+	now := time.Now().UTC()
+	var ids []string
+	for _, failure := range []string{"failed", "failed", ""} {
+		evt := alarm.Event{ID: bson.NewObjectId(), StartTime: now, EndTime: now, Alarm: &alarm.Alarm{Name: "myalarm"}, Successful: failure == "", Error: failure}
+		err = s.conn.Events().Insert(evt)
+		c.Assert(err, check.IsNil)
+		ids = append(ids, evt.ID.Hex())
+	}
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/event/"+ids[0]+"/ack", strings.NewReader(`{"user":"oncall"}`))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var evt eventV2
+	err = json.NewDecoder(recorder.Body).Decode(&evt)
+	c.Assert(err, check.IsNil)
+	c.Assert(evt.AcknowledgedBy, check.Equals, "oncall")
+	c.Assert(evt.AcknowledgedAt, check.NotNil)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/alarm/myalarm/event?outstanding=true", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("X-Total-Count"), check.Equals, "1")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/event/ack", strings.NewReader(`{"ids":["`+strings.Join(ids, `","`)+`"]}`))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer mytoken")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, `{"acknowledged":1}`+"\n")
+	var events []alarm.Event
+	err = s.conn.Events().Find(bson.M{"acknowledged_by": hashOf("mytoken")[:12]}).All(&events)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 1)
+	c.Assert(events[0].ID.Hex(), check.Equals, ids[1])
+}
+
+func (s *S) TestAcknowledgeEventNotFailed(c *check.C) {
+	now := time.Now().UTC()
+	evt := alarm.Event{ID: bson.NewObjectId(), StartTime: now, EndTime: now, Alarm: &alarm.Alarm{Name: "myalarm"}, Successful: true}
+	err := s.conn.Events().Insert(evt)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/event/"+evt.ID.Hex()+"/ack", strings.NewReader(""))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/event/unknown/ack", strings.NewReader(""))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
 func (s *S) TestListAlarmsByInstance(c *check.C) {
 	err := alarm.NewAlarm(&alarm.Alarm{Name: "myalarm", Instance: "instance"})
 	c.Assert(err, check.IsNil)
@@ -156,3 +242,48 @@ func (s *S) TestListAlarmsByInstance(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(a, check.HasLen, 1)
 }
+
+func (s *S) TestTriggerAlarmWithoutToken(c *check.C) {
+	os.Unsetenv("AUTOSCALE_TRIGGER_TOKEN")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/alarm/myalarm/trigger", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestTriggerAlarmInvalidToken(c *check.C) {
+	os.Setenv("AUTOSCALE_TRIGGER_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_TRIGGER_TOKEN")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/alarm/myalarm/trigger", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer other")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+}
+
+func (s *S) TestTriggerAlarmDisabled(c *check.C) {
+	os.Setenv("AUTOSCALE_TRIGGER_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_TRIGGER_TOKEN")
+	err := alarm.NewAlarm(&alarm.Alarm{Name: "myalarm"})
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/alarm/myalarm/trigger", strings.NewReader(`{"fire":true}`))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+	c.Assert(recorder.Body.String(), check.Equals, `{"error":{"code":"conflict","message":"alarm \"myalarm\" is disabled"}}`+"\n")
+}
+
+func (s *S) TestTriggerAlarmInvalidBody(c *check.C) {
+	os.Setenv("AUTOSCALE_TRIGGER_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_TRIGGER_TOKEN")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/alarm/myalarm/trigger", strings.NewReader(`{"fire":`))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
@@ -6,7 +6,6 @@ package api
 
 import (
 	"net/http"
-	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/tsuru/tsuru-autoscale/log"
@@ -16,52 +15,44 @@ func logger() *log.Logger {
 	return log.Log()
 }
 
+// handler is a handler of the api, writing its errors in the error
+// envelope.
 type handler func(http.ResponseWriter, *http.Request) error
 
 func (fn handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	err := fn(w, r)
-	if err != nil {
-		logger().Error(err)
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, err.Error(), http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	if err := fn(w, r); err != nil {
+		requestLogger(r).Error(err)
+		writeError(w, err)
 	}
 }
 
-// Router return a http.Handler with all api routes
+// Router return a http.Handler with all api routes. The handlers have a
+// deadline, unless their routes have no timeout, the request bodies are
+// limited, the state changing requests are audited, the routes of the
+// configurations accept and return yaml and the api version is negotiated.
 func Router(m *mux.Router) {
-	m.HandleFunc("/healthcheck", healthcheck).Methods("GET")
-	m.Handle("/datasource", handler(newDataSource)).Methods("POST")
-	m.Handle("/datasource", handler(allDataSources)).Methods("GET")
-	m.Handle("/datasource/{name}", handler(removeDataSource)).Methods("DELETE")
-	m.Handle("/datasource/{name}", handler(getDataSource)).Methods("GET")
-	m.Handle("/action", handler(allActions)).Methods("GET")
-	m.Handle("/action", handler(newAction)).Methods("POST")
-	m.Handle("/action/{name}", handler(removeAction)).Methods("DELETE")
-	m.Handle("/action/{name}", handler(actionInfo)).Methods("GET")
-	m.Handle("/alarm", handler(newAlarm)).Methods("POST")
-	m.Handle("/alarm/instance/{instance}", handler(listAlarmsByInstance)).Methods("GET")
-	m.Handle("/alarm", authorizationRequiredHandler(listAlarms)).Methods("GET")
-	m.Handle("/alarm/{name}/enable", handler(enableAlarm)).Methods("PUT")
-	m.Handle("/alarm/{name}/disable", handler(disableAlarm)).Methods("PUT")
-	m.Handle("/alarm/{name}", handler(removeAlarm)).Methods("DELETE")
-	m.Handle("/alarm/{name}", handler(getAlarm)).Methods("GET")
-	m.Handle("/alarm/{name}/event", handler(listEvents)).Methods("GET")
-	m.Handle("/resources", handler(serviceAdd))
-	m.HandleFunc("/resources/{name}/bind", serviceBindUnit).Methods("POST")
-	m.Handle("/resources/{name}/bind-app", handler(serviceBindApp)).Methods("POST")
-	m.Handle("/resources/{name}/bind-app", handler(serviceUnbindApp)).Methods("DELETE")
-	m.HandleFunc("/resources/{name}/bind", serviceUnbindUnit).Methods("DELETE")
-	m.Handle("/resources/{name}", handler(serviceRemove)).Methods("DELETE")
-	m.Handle("/service/instance/{name}", handler(serviceInstanceByName)).Methods("GET")
-	m.Handle("/service/instance", authorizationRequiredHandler(serviceInstances)).Methods("GET")
-	m.Handle("/wizard/{name}/events", handler(eventsByWizardName)).Methods("GET")
-	m.Handle("/wizard/{name}", handler(wizardByName)).Methods("GET")
-	m.Handle("/wizard/{name}", handler(removeWizard)).Methods("DELETE")
-	m.Handle("/wizard/{name}", handler(wizardUpdate)).Methods("PUT")
-	m.Handle("/wizard/{name}/enable", handler(wizardEnable)).Methods("POST")
-	m.Handle("/wizard/{name}/disable", handler(wizardDisable)).Methods("POST")
-	m.Handle("/wizard", handler(newAutoScale)).Methods("POST")
+	timeout, limit := handlerTimeout(), maxBodySize()
+	for _, r := range routes {
+		yamlRoute := isYAMLRoute(r.path)
+		h := r.handler
+		if yamlRoute {
+			h = yamlResponses(h)
+		}
+		h = compress(h)
+		switch r.timeout {
+		case noTimeout:
+		case 0:
+			h = withTimeout(timeout, h)
+		default:
+			h = withTimeout(r.timeout, h)
+		}
+		h = audit(r.method, r.path, h)
+		if yamlRoute {
+			h = yamlRequests(h)
+		}
+		h = limitBody(limit, h)
+		h = versioned(r, h)
+		m.Handle(r.path, instrument(r.method, r.path, logRequests(r.method, r.path, h))).Methods(r.method)
+	}
+	m.Handle("/openapi.json", instrument("GET", "/openapi.json", logRequests("GET", "/openapi.json", compress(http.HandlerFunc(openAPI))))).Methods("GET")
 }
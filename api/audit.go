@@ -0,0 +1,294 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/datasource"
+	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/tsuru"
+	"github.com/tsuru/tsuru-autoscale/wizard"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// defaultAuditTTL is how long the audit records are kept, when
+// AUTOSCALE_AUDIT_TTL is not set.
+const defaultAuditTTL = 365 * 24 * time.Hour
+
+// auditResource is the resource changed by a request: its type and name,
+// empty for the requests changing many resources, like the bulk ones.
+type auditResource struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty" bson:",omitempty"`
+}
+
+// auditRecord is a state changing api request: who sent it, its route, the
+// resource it changed, the status of the response and the resource before
+// and after it, with the fields that changed.
+type auditRecord struct {
+	ID        bson.ObjectId   `json:"id" bson:"_id"`
+	Time      time.Time       `json:"time"`
+	RequestID string          `json:"requestId"`
+	Principal string          `json:"principal"`
+	Method    string          `json:"method"`
+	Route     string          `json:"route"`
+	Path      string          `json:"path"`
+	Resource  auditResource   `json:"resource"`
+	Status    int             `json:"status"`
+	Before    json.RawMessage `json:"before,omitempty" bson:",omitempty"`
+	After     json.RawMessage `json:"after,omitempty" bson:",omitempty"`
+	Changes   []string        `json:"changes,omitempty" bson:",omitempty"`
+	ExpiresAt time.Time       `json:"-"`
+}
+
+// auditType is a type of resource changed by the api: the prefix of its
+// routes and how its state is loaded, with the secrets redacted, nil for
+// the types whose state is not recorded.
+type auditType struct {
+	prefix string
+	name   string
+	load   func(name string) (interface{}, error)
+}
+
+// auditTypes are the types of resources of the api, matched in order.
+var auditTypes = []auditType{
+	{prefix: "/datasource", name: "datasource", load: loadDataSource},
+	{prefix: "/v2/datasources", name: "datasource", load: loadDataSource},
+	{prefix: "/action/dead-letter", name: "deadletter"},
+	{prefix: "/event", name: "event"},
+	{prefix: "/action", name: "action", load: loadAction},
+	{prefix: "/v2/actions", name: "action", load: loadAction},
+	{prefix: "/alarm", name: "alarm", load: loadAlarm},
+	{prefix: "/v2/alarms", name: "alarm", load: loadAlarm},
+	{prefix: "/wizard", name: "wizard", load: func(name string) (interface{}, error) { return wizard.FindByName(name) }},
+	{prefix: "/resources", name: "instance", load: func(name string) (interface{}, error) { return tsuru.GetInstanceByName(name) }},
+	{prefix: "/admin/import", name: "backup"},
+}
+
+func loadDataSource(name string) (interface{}, error) {
+	ds, err := datasource.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return ds.Redacted(), nil
+}
+
+func loadAction(name string) (interface{}, error) {
+	a, err := action.FindByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return a.Redacted(), nil
+}
+
+func loadAlarm(name string) (interface{}, error) {
+	return alarm.FindAlarmByName(name)
+}
+
+// auditTypeOf returns the type of the resources of the route.
+func auditTypeOf(route string) *auditType {
+	for i, t := range auditTypes {
+		if route == t.prefix || strings.HasPrefix(route, t.prefix+"/") {
+			return &auditTypes[i]
+		}
+	}
+	return nil
+}
+
+// auditTTL is how long the audit records are kept, from
+// AUTOSCALE_AUDIT_TTL, in seconds.
+func auditTTL() time.Duration {
+	if t := os.Getenv("AUTOSCALE_AUDIT_TTL"); t != "" {
+		v, err := strconv.Atoi(t)
+		if err == nil && v > 0 {
+			return time.Duration(v) * time.Second
+		}
+		logger().Printf("invalid AUTOSCALE_AUDIT_TTL %q", t)
+	}
+	return defaultAuditTTL
+}
+
+// auditName returns the name of the resource of the request: the variable
+// of its route or, for the creations, the name in its body.
+func auditName(r *http.Request, body []byte) string {
+	vars := routeVars(r)
+	if name := vars["name"]; name != "" {
+		return name
+	}
+	if id := vars["id"]; id != "" {
+		return id
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		values, _ := url.ParseQuery(string(body))
+		return values.Get("name")
+	}
+	var named struct {
+		Name string `json:"name"`
+	}
+	json.Unmarshal(body, &named)
+	return named.Name
+}
+
+// auditState returns the state of the resource, in json, or nil when it
+// does not exist.
+func auditState(t *auditType, name string) json.RawMessage {
+	if t == nil || t.load == nil || name == "" {
+		return nil
+	}
+	resource, err := t.load(name)
+	if err != nil {
+		return nil
+	}
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// auditChanges returns the fields of the resource that changed, sorted.
+func auditChanges(before, after json.RawMessage) []string {
+	var b, a map[string]interface{}
+	json.Unmarshal(before, &b)
+	json.Unmarshal(after, &a)
+	var changes []string
+	for key, value := range b {
+		if other, ok := a[key]; !ok || !reflect.DeepEqual(value, other) {
+			changes = append(changes, key)
+		}
+	}
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			changes = append(changes, key)
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// audit records the state changing requests of the route in the audit
+// log, with the state of the resource before and after them, so the
+// changes of the scaling control plane can be reviewed. The failures of
+// the audit log are logged, not sent to the clients.
+func audit(method, route string, h http.Handler) http.Handler {
+	if method == "GET" || method == "HEAD" {
+		return h
+	}
+	t := auditTypeOf(route)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		record := auditRecord{
+			ID:        bson.NewObjectId(),
+			Time:      time.Now().UTC(),
+			RequestID: w.Header().Get("X-Request-ID"),
+			Principal: principal(r),
+			Method:    method,
+			Route:     route,
+			Path:      r.URL.Path,
+		}
+		if t != nil {
+			record.Resource = auditResource{Type: t.name, Name: auditName(r, body)}
+		}
+		record.Before = auditState(t, record.Resource.Name)
+		rec := statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(&rec, r)
+		record.Status = rec.status
+		record.After = auditState(t, record.Resource.Name)
+		record.Changes = auditChanges(record.Before, record.After)
+		record.ExpiresAt = record.Time.Add(auditTTL())
+		if err := saveAuditRecord(&record); err != nil {
+			requestLogger(r).Error(err)
+		}
+	})
+}
+
+func saveAuditRecord(record *auditRecord) error {
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.AuditLog().Insert(record)
+}
+
+var auditList = listSpec{
+	filters: map[string]listField{
+		"type":      {field: "resource.type"},
+		"name":      {field: "resource.name"},
+		"principal": {field: "principal"},
+		"method":    {field: "method"},
+		"status":    {field: "status"},
+	},
+	sorts: map[string]string{"time": "time"},
+	limit: 100,
+}
+
+// listAuditLog lists the audit records, the latest first by default,
+// filtered by the resource, the principal, the method and the time range
+// [since, until).
+func listAuditLog(w http.ResponseWriter, r *http.Request) error {
+	opts, err := auditList.options(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if status, ok := opts.Filter["status"].(string); ok {
+		v, err := strconv.Atoi(status)
+		if err != nil {
+			return badRequest("status must be a number")
+		}
+		opts.Filter["status"] = v
+	}
+	q := bson.M{}
+	times := bson.M{}
+	for name, op := range map[string]string{"since": "$gte", "until": "$lt"} {
+		t, err := exportTime(r, name)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		if !t.IsZero() {
+			times[op] = t
+		}
+	}
+	if len(times) > 0 {
+		q["time"] = times
+	}
+	if len(opts.Sort) == 0 {
+		opts.Sort = []string{"-time"}
+	}
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	records := []auditRecord{}
+	total, err := db.List(conn.AuditLog(), q, opts, &records)
+	if err != nil {
+		return err
+	}
+	return writeList(w, total, records)
+}
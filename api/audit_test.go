@@ -0,0 +1,97 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAuditTypeOf(c *check.C) {
+	tests := []struct {
+		route string
+		name  string
+	}{
+		{"/datasource", "datasource"},
+		{"/datasource/{name}/rename", "datasource"},
+		{"/v2/datasources/{name}", "datasource"},
+		{"/action/dead-letter/{id}/replay", "deadletter"},
+		{"/action/{name}", "action"},
+		{"/alarm/{name}/enable", "alarm"},
+		{"/wizard/{name}/scale", "wizard"},
+		{"/resources/{name}/bind-app", "instance"},
+		{"/admin/import", "backup"},
+	}
+	for _, tt := range tests {
+		t := auditTypeOf(tt.route)
+		c.Assert(t, check.NotNil, check.Commentf(tt.route))
+		c.Assert(t.name, check.Equals, tt.name, check.Commentf(tt.route))
+	}
+	c.Assert(auditTypeOf("/actions"), check.IsNil)
+}
+
+func (s *S) TestAuditName(c *check.C) {
+	request, err := http.NewRequest("POST", "/datasource", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(auditName(request, []byte(`{"name":"cpu","url":"http://tsuru.io"}`)), check.Equals, "cpu")
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.Assert(auditName(request, []byte("name=myinstance&team=myteam")), check.Equals, "myinstance")
+	c.Assert(auditName(request, nil), check.Equals, "")
+}
+
+func (s *S) TestAuditChanges(c *check.C) {
+	before := json.RawMessage(`{"name":"cpu","url":"http://a","headers":{"a":"1"}}`)
+	after := json.RawMessage(`{"name":"cpu","url":"http://b","headers":{"a":"1"},"timeout":10}`)
+	c.Assert(auditChanges(before, after), check.DeepEquals, []string{"timeout", "url"})
+	c.Assert(auditChanges(nil, json.RawMessage(`{"name":"cpu"}`)), check.DeepEquals, []string{"name"})
+	c.Assert(auditChanges(before, before), check.IsNil)
+}
+
+func (s *S) TestAudit(c *check.C) {
+	os.Setenv("AUTOSCALE_ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_ADMIN_TOKEN")
+	body := `{"name":"cpu","url":"http://tsuru.io","method":"GET","headers":{"Authorization":"token"}}`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/datasource", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer mytoken")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/admin/audit?type=datasource&name=cpu", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("X-Total-Count"), check.Equals, "1")
+	var records []auditRecord
+	err = json.NewDecoder(recorder.Body).Decode(&records)
+	c.Assert(err, check.IsNil)
+	c.Assert(records, check.HasLen, 1)
+	c.Assert(records[0].Method, check.Equals, "POST")
+	c.Assert(records[0].Route, check.Equals, "/datasource")
+	c.Assert(records[0].Resource, check.Equals, auditResource{Type: "datasource", Name: "cpu"})
+	c.Assert(records[0].Status, check.Equals, http.StatusCreated)
+	c.Assert(records[0].Principal, check.Equals, hashOf("mytoken")[:12])
+	c.Assert(records[0].Before, check.IsNil)
+	c.Assert(string(records[0].After), check.Not(check.Matches), ".*token.*")
+	c.Assert(records[0].Changes, check.Not(check.HasLen), 0)
+}
+
+func (s *S) TestListAuditLogInvalidStatus(c *check.C) {
+	os.Setenv("AUTOSCALE_ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_ADMIN_TOKEN")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/admin/audit?status=ok", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
@@ -0,0 +1,49 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/tsuru/tsuru-autoscale/backup"
+)
+
+// exportBackup downloads the archive of the configuration of the
+// installation, with the secrets when secrets is true.
+func exportBackup(w http.ResponseWriter, r *http.Request) error {
+	var secrets bool
+	if value := r.URL.Query().Get("secrets"); value != "" {
+		var err error
+		if secrets, err = strconv.ParseBool(value); err != nil {
+			return badRequest("secrets must be a boolean")
+		}
+	}
+	archive, err := backup.Export(secrets)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="autoscale-%s.json"`, archive.CreatedAt.Format("20060102T150405Z")))
+	return json.NewEncoder(w).Encode(archive)
+}
+
+// importBackup restores the configuration of an archive, handling the
+// existing configurations by the conflict strategy, and writes the report.
+func importBackup(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	var archive backup.Archive
+	if err := json.NewDecoder(r.Body).Decode(&archive); err != nil {
+		return err
+	}
+	report, err := backup.Import(&archive, r.URL.Query().Get("conflict"))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(report)
+}
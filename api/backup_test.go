@@ -0,0 +1,97 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/backup"
+	"github.com/tsuru/tsuru-autoscale/secret"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestExportBackup(c *check.C) {
+	os.Setenv("AUTOSCALE_ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_ADMIN_TOKEN")
+	err := action.New(&action.Action{Name: "scale_up", URL: "http://tsuru.io", Method: "POST", Secret: "s3cret"})
+	c.Assert(err, check.IsNil)
+	err = alarm.NewAlarm(&alarm.Alarm{Name: "high", Expression: "true", Actions: []string{"scale_up"}})
+	c.Assert(err, check.IsNil)
+	for _, tt := range []struct {
+		query  string
+		secret string
+	}{
+		{"", secret.Redacted},
+		{"?secrets=true", "s3cret"},
+	} {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", "/admin/export"+tt.query, nil)
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Authorization", "bearer secret")
+		server(recorder, request)
+		c.Assert(recorder.Code, check.Equals, http.StatusOK)
+		c.Assert(recorder.Header().Get("Content-Disposition"), check.Matches, `attachment; filename="autoscale-.*\.json"`)
+		var archive backup.Archive
+		err = json.Unmarshal(recorder.Body.Bytes(), &archive)
+		c.Assert(err, check.IsNil)
+		c.Assert(archive.Actions, check.HasLen, 1)
+		c.Assert(archive.Actions[0].Secret, check.Equals, tt.secret)
+		c.Assert(archive.Alarms, check.HasLen, 1)
+	}
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/admin/export?secrets=maybe", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestImportBackup(c *check.C) {
+	os.Setenv("AUTOSCALE_ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_ADMIN_TOKEN")
+	err := alarm.NewAlarm(&alarm.Alarm{Name: "high", Expression: "true"})
+	c.Assert(err, check.IsNil)
+	body, err := json.Marshal(backup.Archive{
+		Version: backup.Version,
+		Actions: []action.Action{{Name: "scale_up", URL: "http://tsuru.io", Method: "POST"}},
+		Alarms:  []alarm.Alarm{{Name: "high", Expression: "false"}, {Name: "low", Expression: "false"}},
+	})
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/admin/import?conflict=fail", bytes.NewReader(body))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*"code":"conflict".*"details":\["alarm high"\].*`)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/admin/import", bytes.NewReader(body))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var report backup.Report
+	err = json.Unmarshal(recorder.Body.Bytes(), &report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Actions.Created, check.DeepEquals, []string{"scale_up"})
+	c.Assert(report.Alarms.Created, check.DeepEquals, []string{"low"})
+	c.Assert(report.Alarms.Skipped, check.DeepEquals, []string{"high"})
+	a, err := alarm.FindAlarmByName("high")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Expression, check.Equals, "true")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/admin/import?conflict=merge", bytes.NewReader(body))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*"code":"invalid".*`)
+}
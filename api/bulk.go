@@ -0,0 +1,143 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/datasource"
+	"github.com/tsuru/tsuru-autoscale/wizard"
+)
+
+// Statuses of the items of the bulk requests.
+const (
+	bulkCreated = "created"
+	bulkUpdated = "updated"
+	bulkFailed  = "failed"
+)
+
+// bulkItem is the result of an item of a bulk request.
+type bulkItem struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// bulkReport is the result of a bulk request, with the items in the
+// request order.
+type bulkReport struct {
+	Created int        `json:"created"`
+	Updated int        `json:"updated"`
+	Failed  int        `json:"failed"`
+	Items   []bulkItem `json:"items"`
+}
+
+// bulkDecoder decodes an item of a bulk request, returning its name and the
+// function saving it, that returns whether it was created.
+type bulkDecoder func(raw json.RawMessage) (string, func() (bool, error), error)
+
+// applyBulk saves each item of the array in the request body, all or
+// nothing per item, and writes the report, with multi-status when any of
+// them failed.
+func applyBulk(w http.ResponseWriter, r *http.Request, decode bulkDecoder) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var items []json.RawMessage
+	if err = json.Unmarshal(body, &items); err != nil {
+		return badRequest("body must be an array")
+	}
+	report := bulkReport{Items: make([]bulkItem, len(items))}
+	names := map[string]bool{}
+	for i, raw := range items {
+		name, save, err := decode(raw)
+		item := bulkItem{Name: name}
+		if err == nil && names[name] {
+			err = fmt.Errorf("%q is duplicated", name)
+		}
+		names[name] = true
+		var created bool
+		if err == nil {
+			created, err = save()
+		}
+		switch {
+		case err != nil:
+			item.Status, item.Error = bulkFailed, err.Error()
+			report.Failed++
+		case created:
+			item.Status = bulkCreated
+			report.Created++
+		default:
+			item.Status = bulkUpdated
+			report.Updated++
+		}
+		report.Items[i] = item
+	}
+	status := http.StatusOK
+	if report.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(report)
+}
+
+func bulkDataSources(w http.ResponseWriter, r *http.Request) error {
+	return applyBulk(w, r, func(raw json.RawMessage) (string, func() (bool, error), error) {
+		var ds datasource.DataSource
+		err := json.Unmarshal(raw, &ds)
+		return ds.Name, func() (bool, error) {
+			return datasource.Save(&ds)
+		}, err
+	})
+}
+
+func bulkActions(w http.ResponseWriter, r *http.Request) error {
+	return applyBulk(w, r, func(raw json.RawMessage) (string, func() (bool, error), error) {
+		var a action.Action
+		err := json.Unmarshal(raw, &a)
+		return a.Name, func() (bool, error) {
+			result, err := action.Import([]action.Action{a})
+			if err != nil {
+				return false, err
+			}
+			return len(result.Created) > 0, nil
+		}, err
+	})
+}
+
+func bulkAlarms(w http.ResponseWriter, r *http.Request) error {
+	return applyBulk(w, r, func(raw json.RawMessage) (string, func() (bool, error), error) {
+		var a alarm.Alarm
+		err := validateBody(schemas["alarm"], raw)
+		if jsonErr := json.Unmarshal(raw, &a); err == nil {
+			err = jsonErr
+		}
+		return a.Name, func() (bool, error) {
+			return alarm.SaveAlarm(&a)
+		}, err
+	})
+}
+
+func bulkWizards(w http.ResponseWriter, r *http.Request) error {
+	return applyBulk(w, r, func(raw json.RawMessage) (string, func() (bool, error), error) {
+		var a wizard.AutoScale
+		err := validateBody(schemas["autoscale"], raw)
+		if jsonErr := json.Unmarshal(raw, &a); err == nil {
+			err = jsonErr
+		}
+		return a.Name, func() (bool, error) {
+			a.SetLogger(requestLogger(r))
+			return wizard.Save(&a)
+		}, err
+	})
+}
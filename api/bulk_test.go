@@ -0,0 +1,91 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestBulkAlarms(c *check.C) {
+	err := alarm.NewAlarm(&alarm.Alarm{Name: "existing", Expression: "true"})
+	c.Assert(err, check.IsNil)
+	body := `[{"name":"existing","expression":"false"},{"name":"new","expression":"true"},{"name":"new"},{"name":""},"invalid"]`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/alarm/bulk", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusMultiStatus)
+	var report bulkReport
+	err = json.Unmarshal(recorder.Body.Bytes(), &report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Created, check.Equals, 1)
+	c.Assert(report.Updated, check.Equals, 1)
+	c.Assert(report.Failed, check.Equals, 3)
+	c.Assert(report.Items[0], check.Equals, bulkItem{Name: "existing", Status: "updated"})
+	c.Assert(report.Items[1], check.Equals, bulkItem{Name: "new", Status: "created"})
+	c.Assert(report.Items[2], check.Equals, bulkItem{Name: "new", Status: "failed", Error: `"new" is duplicated`})
+	c.Assert(report.Items[3], check.Equals, bulkItem{Status: "failed", Error: "name must not be empty"})
+	c.Assert(report.Items[4].Status, check.Equals, "failed")
+	a, err := alarm.FindAlarmByName("existing")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Expression, check.Equals, "false")
+}
+
+func (s *S) TestBulkActions(c *check.C) {
+	body := `[{"name":"scale_up","url":"http://tsuru.io","method":"POST"},{"name":"invalid","type":"unknown"}]`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/action/bulk", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusMultiStatus)
+	var report bulkReport
+	err = json.Unmarshal(recorder.Body.Bytes(), &report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Items, check.DeepEquals, []bulkItem{
+		{Name: "scale_up", Status: "created"},
+		{Name: "invalid", Status: "failed", Error: `action "invalid": invalid type "unknown"`},
+	})
+}
+
+func (s *S) TestBulkDataSources(c *check.C) {
+	body := `[{"name":"cpu","url":"http://tsuru.io","method":"GET"}]`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/datasource/bulk", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, `{"created":1,"updated":0,"failed":0,"items":[{"name":"cpu","status":"created"}]}`+"\n")
+}
+
+func (s *S) TestBulkInvalidBody(c *check.C) {
+	for _, path := range []string{"/datasource/bulk", "/action/bulk", "/alarm/bulk", "/wizard/bulk"} {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("POST", path, strings.NewReader(`{"name":"x"}`))
+		c.Assert(err, check.IsNil)
+		server(recorder, request)
+		c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+		c.Assert(recorder.Body.String(), check.Equals, `{"error":{"code":"bad_request","message":"body must be an array"}}`+"\n")
+	}
+}
+
+func (s *S) TestBulkInvalidItems(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/wizard/bulk", strings.NewReader(`[1,"x"]`))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusMultiStatus)
+	var report bulkReport
+	err = json.Unmarshal(recorder.Body.Bytes(), &report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Failed, check.Equals, 2)
+	c.Assert(report.Items[0].Status, check.Equals, "failed")
+	c.Assert(report.Items[0].Error, check.Equals, "body must be an object")
+}
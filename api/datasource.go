@@ -5,14 +5,12 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
-	"strconv"
 
-	"github.com/gorilla/mux"
 	"github.com/tsuru/tsuru-autoscale/datasource"
-	"gopkg.in/mgo.v2/bson"
 )
 
 func newDataSource(w http.ResponseWriter, r *http.Request) error {
@@ -35,21 +33,23 @@ func newDataSource(w http.ResponseWriter, r *http.Request) error {
 }
 
 func allDataSources(w http.ResponseWriter, r *http.Request) error {
-	var q bson.M
-	public, err := strconv.ParseBool(r.URL.Query().Get("public"))
-	if err == nil {
-		q = bson.M{"public": public}
+	opts, err := dataSourceList.options(r)
+	if err != nil {
+		return badRequest(err.Error())
 	}
-	ds, err := datasource.FindBy(q)
+	ds, total, err := datasource.List(nil, opts)
 	if err != nil {
 		return err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(ds)
+	redacted := make([]*datasource.DataSource, len(ds))
+	for i := range ds {
+		redacted[i] = ds[i].Redacted()
+	}
+	return writeList(w, total, redacted)
 }
 
 func removeDataSource(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	ds, err := datasource.Get(vars["name"])
 	if err != nil {
 		return err
@@ -58,11 +58,134 @@ func removeDataSource(w http.ResponseWriter, r *http.Request) error {
 }
 
 func getDataSource(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
+	ds, err := datasource.Get(vars["name"])
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(ds.Redacted())
+}
+
+func dataSourceStatus(w http.ResponseWriter, r *http.Request) error {
+	vars := routeVars(r)
+	ds, err := datasource.Get(vars["name"])
+	if err != nil {
+		return err
+	}
+	status, err := datasource.GetStatus(ds.Name)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(status)
+}
+
+func dataSourceUsage(w http.ResponseWriter, r *http.Request) error {
+	vars := routeVars(r)
+	refs, err := datasource.Usage(vars["name"])
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(refs)
+}
+
+func testDataSource(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var params struct {
+		Instance string            `json:"instance"`
+		Envs     map[string]string `json:"envs"`
+	}
+	err = json.Unmarshal(body, &params)
+	if err != nil {
+		return err
+	}
+	vars := routeVars(r)
 	ds, err := datasource.Get(vars["name"])
 	if err != nil {
 		return err
 	}
+	result, err := ds.Test(params.Instance, params.Envs)
+	if err != nil {
+		return err
+	}
 	w.Header().Set("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(ds)
+	return json.NewEncoder(w).Encode(result)
+}
+
+func renameDataSource(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var params struct {
+		Name string `json:"name"`
+	}
+	err = json.Unmarshal(body, &params)
+	if err != nil {
+		return err
+	}
+	vars := routeVars(r)
+	return datasource.Rename(vars["name"], params.Name)
+}
+
+func pushDataSource(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var samples []datasource.Sample
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(body, &samples)
+	} else {
+		var sample datasource.Sample
+		err = json.Unmarshal(body, &sample)
+		samples = append(samples, sample)
+	}
+	if err != nil {
+		return err
+	}
+	vars := routeVars(r)
+	ds, err := datasource.Get(vars["name"])
+	if err != nil {
+		return err
+	}
+	return ds.Push(samples)
+}
+
+func dataSourcePresets(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(datasource.Presets())
+}
+
+func newDataSourceFromPreset(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var params struct {
+		Name    string            `json:"name"`
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+		Public  bool              `json:"public"`
+	}
+	err = json.Unmarshal(body, &params)
+	if err != nil {
+		return err
+	}
+	vars := routeVars(r)
+	_, err = datasource.FromPreset(vars["preset"], params.Name, params.URL, params.Headers, params.Public)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
 }
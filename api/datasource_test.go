@@ -9,38 +9,32 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"strings"
 	"testing"
 
+	"github.com/tsuru/tsuru-autoscale/alarm"
 	"github.com/tsuru/tsuru-autoscale/datasource"
-	"github.com/tsuru/tsuru-autoscale/db"
-	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru-autoscale/db/storagetest"
+	"github.com/tsuru/tsuru-autoscale/tsuru"
 	"gopkg.in/check.v1"
 )
 
 func Test(t *testing.T) { check.TestingT(t) }
 
 type S struct {
-	conn *db.Storage
+	conn *storagetest.Storage
 }
 
 var _ = check.Suite(&S{})
 
 func (s *S) SetUpSuite(c *check.C) {
-	err := os.Setenv("MONGODB_DATABASE_NAME", "tsuru_autoscale_api")
-	c.Assert(err, check.IsNil)
-	s.conn, err = db.Conn()
+	var err error
+	s.conn, err = storagetest.New("tsuru_autoscale_api")
 	c.Assert(err, check.IsNil)
 }
 
 func (s *S) TearDownTest(c *check.C) {
-	dbtest.ClearAllCollections(s.conn.Actions().Database)
-	dbtest.ClearAllCollections(s.conn.Alarms().Database)
-	dbtest.ClearAllCollections(s.conn.DataSources().Database)
-	dbtest.ClearAllCollections(s.conn.Events().Database)
-	dbtest.ClearAllCollections(s.conn.Instances().Database)
-	dbtest.ClearAllCollections(s.conn.Wizard().Database)
+	s.conn.Clear()
 }
 
 func (s *S) TestNewDataSource(c *check.C) {
@@ -53,6 +47,25 @@ func (s *S) TestNewDataSource(c *check.C) {
 	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
 }
 
+func (s *S) TestNewDataSourceInvalid(c *check.C) {
+	body := `{"name":"new","url":"tsuru.io","method":"FETCH"}`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/datasource", strings.NewReader(body))
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.HeaderMap["Content-Type"], check.DeepEquals, []string{"application/json"})
+	var result struct{ Error apiError }
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Error.Code, check.Equals, "invalid")
+	c.Assert(result.Error.Fields, check.DeepEquals, []datasource.FieldError{
+		{Field: "url", Message: `invalid url "tsuru.io": must be an absolute http or https url`},
+		{Field: "method", Message: `invalid method "FETCH"`},
+	})
+}
+
 func (s *S) TestFindByDataSources(c *check.C) {
 	err := datasource.New(&datasource.DataSource{
 		URL:    "http://tsuru.io",
@@ -106,6 +119,106 @@ func (s *S) TestRemoveDataSource(c *check.C) {
 	c.Assert(err, check.NotNil)
 }
 
+func (s *S) TestRenameDataSource(c *check.C) {
+	ds := &datasource.DataSource{URL: "http://tsuru.io", Method: "GET", Name: "ds"}
+	err := datasource.New(ds)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader(`{"name": "cpu"}`)
+	request, err := http.NewRequest("POST", "/datasource/ds/rename", body)
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = datasource.Get("ds")
+	c.Assert(err, check.NotNil)
+	_, err = datasource.Get("cpu")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestRenameDataSourceNotFound(c *check.C) {
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader(`{"name": "cpu"}`)
+	request, err := http.NewRequest("POST", "/datasource/notfound/rename", body)
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRemoveDataSourceInUse(c *check.C) {
+	ds := &datasource.DataSource{URL: "http://tsuru.io", Method: "GET", Name: "ds"}
+	err := datasource.New(ds)
+	c.Assert(err, check.IsNil)
+	err = alarm.NewAlarm(&alarm.Alarm{Name: "scale_up", DataSources: []string{"ds"}})
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("DELETE", "/datasource/ds", nil)
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+	c.Assert(recorder.Body.String(), check.Equals, `{"error":{"code":"in_use","message":"datasource \"ds\" is used by alarms scale_up","details":{"alarms":["scale_up"],"wizards":[],"datasources":[]}}}`+"\n")
+}
+
+func (s *S) TestDataSourceUsage(c *check.C) {
+	ds := &datasource.DataSource{URL: "http://tsuru.io", Method: "GET", Name: "ds"}
+	err := datasource.New(ds)
+	c.Assert(err, check.IsNil)
+	err = alarm.NewAlarm(&alarm.Alarm{Name: "scale_up", DataSources: []string{"ds"}})
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/datasource/ds/usage", nil)
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.HeaderMap["Content-Type"], check.DeepEquals, []string{"application/json"})
+	var refs datasource.References
+	err = json.Unmarshal(recorder.Body.Bytes(), &refs)
+	c.Assert(err, check.IsNil)
+	c.Assert(refs.Alarms, check.DeepEquals, []string{"scale_up"})
+	c.Assert(refs.Wizards, check.DeepEquals, []string{})
+}
+
+func (s *S) TestDataSourceUsageNotFound(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/datasource/notfound/usage", nil)
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestPushDataSource(c *check.C) {
+	ds := &datasource.DataSource{Name: "orders", Type: datasource.TypePush, Extract: "$.sum"}
+	err := datasource.New(ds)
+	c.Assert(err, check.IsNil)
+	for _, body := range []string{`{"value": 2}`, `[{"value": 3}, {"value": 5}]`} {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("POST", "/datasource/orders/push", strings.NewReader(body))
+		request.Header.Add("Authorization", "token")
+		c.Assert(err, check.IsNil)
+		server(recorder, request)
+		c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	}
+	data, err := ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(data, check.Equals, "10")
+}
+
+func (s *S) TestPushDataSourceInvalidType(c *check.C) {
+	ds := &datasource.DataSource{URL: "http://tsuru.io", Method: "GET", Name: "ds"}
+	err := datasource.New(ds)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/datasource/ds/push", strings.NewReader(`{"value": 1}`))
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
 func (s *S) TestGetDataSource(c *check.C) {
 	ds := &datasource.DataSource{URL: "http://tsuru.io", Method: "GET", Name: "ds"}
 	err := datasource.New(ds)
@@ -123,3 +236,120 @@ func (s *S) TestGetDataSource(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(ds.Name, check.Equals, got.Name)
 }
+
+func (s *S) TestGetDataSourceRedactsSecrets(c *check.C) {
+	ds := &datasource.DataSource{
+		URL:     "http://tsuru.io",
+		Method:  "GET",
+		Name:    "ds",
+		Headers: map[string]string{"Authorization": "bearer abc"},
+	}
+	err := datasource.New(ds)
+	c.Assert(err, check.IsNil)
+	for _, url := range []string{"/datasource/ds", "/datasource"} {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", url, nil)
+		request.Header.Add("Authorization", "token")
+		c.Assert(err, check.IsNil)
+		server(recorder, request)
+		c.Assert(recorder.Code, check.Equals, http.StatusOK)
+		c.Assert(recorder.Body.String(), check.Not(check.Matches), "(?s).*bearer abc.*")
+	}
+}
+
+func (s *S) TestDataSourceStatus(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+	ds := &datasource.DataSource{URL: ts.URL, Method: "GET", Name: "ds"}
+	err := datasource.New(ds)
+	c.Assert(err, check.IsNil)
+	_, err = ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", fmt.Sprintf("/datasource/%s/status", ds.Name), nil)
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.HeaderMap["Content-Type"], check.DeepEquals, []string{"application/json"})
+	var status datasource.Status
+	err = json.Unmarshal(recorder.Body.Bytes(), &status)
+	c.Assert(err, check.IsNil)
+	c.Assert(status.Name, check.Equals, ds.Name)
+	c.Assert(status.Successes, check.Equals, 1)
+	c.Assert(status.Failures, check.Equals, 0)
+	c.Assert(status.LastSuccess.IsZero(), check.Equals, false)
+}
+
+func (s *S) TestDataSourceStatusNotFound(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/datasource/notfound/status", nil)
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestTestDataSource(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"app": "` + r.URL.Query().Get("app") + `"}`))
+	}))
+	defer ts.Close()
+	ds := &datasource.DataSource{URL: ts.URL + "?app={app}", Method: "GET", Name: "ds"}
+	err := datasource.New(ds)
+	c.Assert(err, check.IsNil)
+	instance := tsuru.Instance{Name: "instance", Apps: []string{"myapp"}}
+	err = tsuru.NewInstance(&instance)
+	c.Assert(err, check.IsNil)
+	body := `{"instance": "instance", "envs": {"process": "web"}}`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/datasource/ds/test", strings.NewReader(body))
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result datasource.TestResult
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(result.Body, check.Equals, `{"app": "myapp"}`)
+}
+
+func (s *S) TestDataSourcePresets(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/datasource/preset", nil)
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.HeaderMap["Content-Type"], check.DeepEquals, []string{"application/json"})
+	var presets []datasource.Preset
+	err = json.Unmarshal(recorder.Body.Bytes(), &presets)
+	c.Assert(err, check.IsNil)
+	c.Assert(presets, check.DeepEquals, datasource.Presets())
+}
+
+func (s *S) TestNewDataSourceFromPreset(c *check.C) {
+	body := `{"name": "units", "url": "http://tsuru.io/", "headers": {"Authorization": "bearer token"}}`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/datasource/preset/tsuru-units", strings.NewReader(body))
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	ds, err := datasource.Get("units")
+	c.Assert(err, check.IsNil)
+	c.Assert(ds.URL, check.Equals, "http://tsuru.io/apps/{app}")
+}
+
+func (s *S) TestNewDataSourceFromPresetNotFound(c *check.C) {
+	body := `{"name": "units", "url": "http://tsuru.io"}`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/datasource/preset/unknown", strings.NewReader(body))
+	request.Header.Add("Authorization", "token")
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
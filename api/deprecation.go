@@ -0,0 +1,124 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/metrics"
+)
+
+// Versions of the api, negotiated with the X-API-Version header.
+const (
+	apiV1 = "1"
+	apiV2 = "2"
+)
+
+var deprecatedRequests = metrics.NewCounterVec(
+	"autoscale_http_deprecated_requests_total",
+	"Total of api requests to deprecated routes, by method and route.",
+	"method", "route",
+)
+
+func init() {
+	metrics.Register(deprecatedRequests)
+}
+
+// v1Date is a date of the deprecation of the v1 routes, from the
+// environment variable, in RFC 3339, or the zero time when it is not set.
+func v1Date(env string) time.Time {
+	if d := os.Getenv(env); d != "" {
+		t, err := time.Parse(time.RFC3339, d)
+		if err == nil {
+			return t
+		}
+		logger().Printf("invalid %s %q", env, d)
+	}
+	return time.Time{}
+}
+
+// routeVersion returns the api version of the route: 2 for the v2 routes,
+// 1 for the deprecated ones, replaced by v2 routes, and empty for the routes
+// of both versions.
+func routeVersion(rt *route) string {
+	switch {
+	case strings.HasPrefix(rt.path, "/v2/"):
+		return apiV2
+	case rt.successor != "":
+		return apiV1
+	}
+	return ""
+}
+
+// successorURL returns the path of the successor of the deprecated route,
+// with the variables of the request.
+func successorURL(rt *route, r *http.Request) string {
+	successor := rt.successor
+	for name, value := range routeVars(r) {
+		successor = strings.Replace(successor, "{"+name+"}", value, -1)
+	}
+	return successor
+}
+
+// deprecate sets the Deprecation, Sunset and successor Link headers of the
+// requests to the deprecated route, and logs the client sending them, so
+// the clients still using the v1 routes can be found.
+func deprecate(rt *route, w http.ResponseWriter, r *http.Request) {
+	deprecation := "true"
+	if d := v1Date("AUTOSCALE_V1_DEPRECATION"); !d.IsZero() {
+		deprecation = fmt.Sprintf("@%d", d.Unix())
+	}
+	w.Header().Set("Deprecation", deprecation)
+	if s := v1Date("AUTOSCALE_V1_SUNSET"); !s.IsZero() {
+		w.Header().Set("Sunset", s.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successorURL(rt, r)))
+	deprecatedRequests.Inc(rt.method, rt.path)
+	requestLogger(r).With("method", rt.method).
+		With("route", rt.path).
+		With("user_agent", r.UserAgent()).
+		With("principal", principal(r)).
+		Print("deprecated route")
+}
+
+// versioned negotiates the api version of the requests to the route. The
+// clients pin a version with the X-API-Version header: pinned to 2, the
+// deprecated routes are gone, so the clients can check they are migrated,
+// and pinned to 1, the v2 routes are rejected. The version served is
+// returned in the X-API-Version header, 1 by default.
+func versioned(rt route, h http.Handler) http.Handler {
+	version := routeVersion(&rt)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested := r.Header.Get("X-API-Version")
+		if requested != "" && requested != apiV1 && requested != apiV2 {
+			writeError(w, badRequest(fmt.Sprintf("unsupported api version %q, must be %s or %s", requested, apiV1, apiV2)))
+			return
+		}
+		if rt.successor != "" {
+			deprecate(&rt, w, r)
+		}
+		if requested != "" && version != "" && requested != version {
+			if version == apiV1 {
+				writeError(w, newError(http.StatusGone, codeGone, fmt.Sprintf("%s %s is not available in api version %s, use %s", rt.method, rt.path, requested, rt.successor)))
+				return
+			}
+			writeError(w, badRequest(fmt.Sprintf("%s %s requires api version %s", rt.method, rt.path, version)))
+			return
+		}
+		served := version
+		if served == "" {
+			served = requested
+		}
+		if served == "" {
+			served = apiV1
+		}
+		w.Header().Set("X-API-Version", served)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/check.v1"
+)
+
+func versionedRouter(rt route) *mux.Router {
+	m := mux.NewRouter()
+	m.Handle(rt.path, versioned(rt, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))).Methods(rt.method)
+	return m
+}
+
+func (s *S) TestRouteVersion(c *check.C) {
+	c.Assert(routeVersion(&route{path: "/v2/alarms"}), check.Equals, apiV2)
+	c.Assert(routeVersion(&route{path: "/alarm", successor: "/v2/alarms"}), check.Equals, apiV1)
+	c.Assert(routeVersion(&route{path: "/wizard"}), check.Equals, "")
+}
+
+func (s *S) TestVersionedDeprecatedRoute(c *check.C) {
+	os.Setenv("AUTOSCALE_V1_DEPRECATION", "2017-06-01T00:00:00Z")
+	os.Setenv("AUTOSCALE_V1_SUNSET", "2018-01-01T00:00:00Z")
+	defer os.Unsetenv("AUTOSCALE_V1_DEPRECATION")
+	defer os.Unsetenv("AUTOSCALE_V1_SUNSET")
+	m := versionedRouter(route{method: "GET", path: "/alarm/instance/{instance}", successor: "/v2/alarms?instance={instance}"})
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/alarm/instance/myinstance", nil)
+	c.Assert(err, check.IsNil)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Deprecation"), check.Equals, "@1496275200")
+	c.Assert(recorder.Header().Get("Sunset"), check.Equals, "Mon, 01 Jan 2018 00:00:00 GMT")
+	c.Assert(recorder.Header().Get("Link"), check.Equals, `</v2/alarms?instance=myinstance>; rel="successor-version"`)
+	c.Assert(recorder.Header().Get("X-API-Version"), check.Equals, "1")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/alarm/instance/myinstance", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("X-API-Version", "2")
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusGone)
+	c.Assert(recorder.Body.String(), check.Equals, `{"error":{"code":"gone","message":"GET /alarm/instance/{instance} is not available in api version 2, use /v2/alarms?instance={instance}"}}`+"\n")
+}
+
+func (s *S) TestVersioned(c *check.C) {
+	tests := []struct {
+		path      string
+		requested string
+		status    int
+		served    string
+	}{
+		{"/wizard", "", http.StatusOK, "1"},
+		{"/wizard", "2", http.StatusOK, "2"},
+		{"/wizard", "3", http.StatusBadRequest, ""},
+		{"/v2/alarms", "", http.StatusOK, "2"},
+		{"/v2/alarms", "1", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		m := versionedRouter(route{method: "GET", path: tt.path})
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", tt.path, nil)
+		c.Assert(err, check.IsNil)
+		request.Header.Set("X-API-Version", tt.requested)
+		m.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, tt.status, check.Commentf("%s %s", tt.path, tt.requested))
+		c.Check(recorder.Header().Get("X-API-Version"), check.Equals, tt.served, check.Commentf("%s %s", tt.path, tt.requested))
+		c.Check(recorder.Header().Get("Deprecation"), check.Equals, "")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/backup"
+	"github.com/tsuru/tsuru-autoscale/datasource"
+	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/tsuru"
+	"github.com/tsuru/tsuru-autoscale/wizard"
+)
+
+// Codes of the error responses, so clients can tell the errors apart
+// without parsing the messages.
+const (
+	codeBadRequest   = "bad_request"
+	codeInvalid      = "invalid"
+	codeNotFound     = "not_found"
+	codeInUse        = "in_use"
+	codeQuota        = "quota_exceeded"
+	codeConflict     = "conflict"
+	codeKeyReused    = "idempotency_key_reused"
+	codeUnauthorized = "unauthorized"
+	codeForbidden    = "forbidden"
+	codeGone         = "gone"
+	codeTooLarge     = "too_large"
+	codeTimeout      = "timeout"
+	codeInternal     = "internal"
+)
+
+// apiError is an error response of the api, written in the error envelope,
+// {"error": {...}}, with a stable code, the message, the invalid fields of
+// the validation errors and the details of the error.
+type apiError struct {
+	Status  int                     `json:"-"`
+	Code    string                  `json:"code"`
+	Message string                  `json:"message"`
+	Fields  []datasource.FieldError `json:"fields,omitempty"`
+	Details interface{}             `json:"details,omitempty"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newError(status int, code, msg string) *apiError {
+	return &apiError{Status: status, Code: code, Message: msg}
+}
+
+func badRequest(msg string) *apiError {
+	return newError(http.StatusBadRequest, codeBadRequest, msg)
+}
+
+// errorFor maps the errors of the resources to the error responses: errors
+// of missing resources are not found, malformed bodies are bad requests,
+// validation errors are invalid, exceeded quotas are forbidden, existing
+// configurations of imports are conflicts, bodies larger than the limit are
+// too large and the others are internal.
+func errorFor(err error) *apiError {
+	switch e := err.(type) {
+	case *apiError:
+		return e
+	case *datasource.ValidationError:
+		return &apiError{Status: http.StatusBadRequest, Code: codeInvalid, Message: e.Error(), Fields: e.Errors}
+	case *action.ValidationError, *alarm.ValidationError, *backup.ValidationError, *wizard.ValidationError:
+		return newError(http.StatusBadRequest, codeInvalid, err.Error())
+	case *datasource.InUseError:
+		return &apiError{Status: http.StatusConflict, Code: codeInUse, Message: e.Error(), Details: e.References}
+	case *backup.ConflictError:
+		return &apiError{Status: http.StatusConflict, Code: codeConflict, Message: e.Error(), Details: e.Names}
+	case *tsuru.QuotaExceededError:
+		return &apiError{Status: http.StatusForbidden, Code: codeQuota, Message: e.Error(), Details: e.Quota}
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return badRequest("invalid body: " + err.Error())
+	}
+	if db.IsNotFound(err) {
+		return newError(http.StatusNotFound, codeNotFound, err.Error())
+	}
+	if err.Error() == errBodyTooLarge {
+		return tooLarge(maxBodySize())
+	}
+	return newError(http.StatusInternalServerError, codeInternal, err.Error())
+}
+
+// writeError writes the error envelope of the error.
+func writeError(w http.ResponseWriter, err error) {
+	e := errorFor(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(map[string]*apiError{"error": e})
+}
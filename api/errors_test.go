@@ -0,0 +1,77 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/backup"
+	"github.com/tsuru/tsuru-autoscale/datasource"
+	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/tsuru"
+	"github.com/tsuru/tsuru-autoscale/wizard"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+)
+
+func (s *S) TestErrorFor(c *check.C) {
+	var syntaxErr error = json.Unmarshal([]byte(`{"name":`), &struct{}{})
+	tests := []struct {
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{&db.NotFoundError{Resource: "Alarm", Name: "x"}, http.StatusNotFound, "not_found", `Alarm "x" not found`},
+		{mgo.ErrNotFound, http.StatusNotFound, "not_found", "not found"},
+		{&action.ValidationError{Message: "invalid type"}, http.StatusBadRequest, "invalid", "action: invalid type"},
+		{&action.ValidationError{Action: "x", Message: "duplicated"}, http.StatusBadRequest, "invalid", `action "x": duplicated`},
+		{&alarm.ValidationError{Message: "name required"}, http.StatusBadRequest, "invalid", "alarm: name required"},
+		{&backup.ValidationError{Message: "unsupported archive version 2"}, http.StatusBadRequest, "invalid", "backup: unsupported archive version 2"},
+		{&wizard.ValidationError{Message: "name required"}, http.StatusBadRequest, "invalid", "wizard: name required"},
+		{errors.New("no reachable servers"), http.StatusInternalServerError, "internal", "no reachable servers"},
+		{errors.New(`datasource: path "$.cpu": key "cpu" not found`), http.StatusInternalServerError, "internal", `datasource: path "$.cpu": key "cpu" not found`},
+		{errors.New("alarm: alarm is not configured"), http.StatusInternalServerError, "internal", "alarm: alarm is not configured"},
+		{badRequest("invalid body"), http.StatusBadRequest, "bad_request", "invalid body"},
+		{syntaxErr, http.StatusBadRequest, "bad_request", "invalid body: unexpected end of JSON input"},
+		{newError(http.StatusForbidden, codeForbidden, "denied"), http.StatusForbidden, "forbidden", "denied"},
+	}
+	for _, tt := range tests {
+		e := errorFor(tt.err)
+		c.Assert(e.Status, check.Equals, tt.status)
+		c.Assert(e.Code, check.Equals, tt.code)
+		c.Assert(e.Message, check.Equals, tt.message)
+	}
+}
+
+func (s *S) TestErrorForValidation(c *check.C) {
+	fields := []datasource.FieldError{{Field: "url", Message: "url is required"}}
+	e := errorFor(&datasource.ValidationError{Errors: fields})
+	c.Assert(e.Status, check.Equals, http.StatusBadRequest)
+	c.Assert(e.Code, check.Equals, "invalid")
+	c.Assert(e.Fields, check.DeepEquals, fields)
+}
+
+func (s *S) TestErrorForQuota(c *check.C) {
+	quota := tsuru.Quota{Resource: tsuru.QuotaAlarms, Scope: tsuru.ScopeTeam, Name: "myteam", Limit: 10, Usage: 10}
+	e := errorFor(&tsuru.QuotaExceededError{Quota: quota})
+	c.Assert(e.Status, check.Equals, http.StatusForbidden)
+	c.Assert(e.Code, check.Equals, "quota_exceeded")
+	c.Assert(e.Message, check.Equals, `team "myteam" exceeded the quota of 10 alarms`)
+	c.Assert(e.Details, check.DeepEquals, quota)
+}
+
+func (s *S) TestWriteError(c *check.C) {
+	recorder := httptest.NewRecorder()
+	writeError(recorder, &db.NotFoundError{Resource: "action", Name: "x"})
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	c.Assert(recorder.Body.String(), check.Equals, `{"error":{"code":"not_found","message":"action \"x\" not found"}}`+"\n")
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// exportFlushEvery is the number of events written between the flushes of
+// the exports.
+const exportFlushEvery = 100
+
+var eventExport = listSpec{
+	filters: map[string]listField{
+		"instance":    {field: "alarm.instance"},
+		"alarm":       {field: "alarm.name"},
+		"action":      {field: "action.name"},
+		"successful":  {field: "successful", boolean: true},
+		"outstanding": {boolean: true, query: outstandingEvents},
+	},
+}
+
+// eventColumns are the columns of the csv exports.
+var eventColumns = []string{
+	"id", "alarm", "instance", "action", "status", "start_time", "end_time",
+	"duration_seconds", "attempts", "status_code", "error", "rollback", "rollback_error",
+}
+
+// eventWriter writes the events of an export.
+type eventWriter interface {
+	write(evt *eventV2) error
+	flush() error
+}
+
+type jsonEventWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonEventWriter) write(evt *eventV2) error {
+	return w.encoder.Encode(evt)
+}
+
+func (w *jsonEventWriter) flush() error {
+	return nil
+}
+
+type csvEventWriter struct {
+	writer *csv.Writer
+}
+
+func (w *csvEventWriter) write(evt *eventV2) error {
+	var end, duration, statusCode, rollback, rollbackError string
+	if evt.EndTime != nil {
+		end = evt.EndTime.Format(time.RFC3339)
+		duration = strconv.FormatFloat(evt.EndTime.Sub(evt.StartTime).Seconds(), 'f', -1, 64)
+	}
+	if n := len(evt.Attempts); n > 0 && evt.Attempts[n-1].StatusCode != 0 {
+		statusCode = strconv.Itoa(evt.Attempts[n-1].StatusCode)
+	}
+	if evt.Rollback != nil {
+		rollback, rollbackError = evt.Rollback.Action, evt.Rollback.Error
+	}
+	return w.writer.Write([]string{
+		evt.ID, evt.Alarm, evt.Instance, evt.Action, evt.Status,
+		evt.StartTime.Format(time.RFC3339), end, duration,
+		strconv.Itoa(len(evt.Attempts)), statusCode, evt.Error, rollback, rollbackError,
+	})
+}
+
+func (w *csvEventWriter) flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// exportTime parses a time filter of the export, in RFC 3339.
+func exportTime(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a RFC 3339 time", name)
+	}
+	return t.UTC(), nil
+}
+
+// exportEvents streams the events of the installation, or of an instance,
+// oldest first, as newline delimited JSON or as CSV, filtered by the start
+// time range [since, until).
+func exportEvents(w http.ResponseWriter, r *http.Request) error {
+	opts, err := eventExport.options(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	q := opts.Filter
+	starttime := bson.M{}
+	for name, op := range map[string]string{"since": "$gte", "until": "$lt"} {
+		t, err := exportTime(r, name)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		if !t.IsZero() {
+			starttime[op] = t
+		}
+	}
+	if len(starttime) > 0 {
+		q["starttime"] = starttime
+	}
+	var out eventWriter
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		out = &jsonEventWriter{encoder: json.NewEncoder(w)}
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="events.csv"`)
+		cw := csv.NewWriter(w)
+		if err = cw.Write(eventColumns); err != nil {
+			return err
+		}
+		out = &csvEventWriter{writer: cw}
+	default:
+		return badRequest(fmt.Sprintf("invalid format %q, must be json or csv", format))
+	}
+	flusher, _ := w.(http.Flusher)
+	written := 0
+	err = alarm.EachEvent(q, func(evt *alarm.Event) error {
+		if err := out.write(newEventV2(evt)); err != nil {
+			return err
+		}
+		written++
+		if written%exportFlushEvery == 0 {
+			if err := out.flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = out.flush()
+	}
+	if err != nil && written > 0 {
+		// the response is already sent, so the export is just truncated.
+		requestLogger(r).Error(err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestCSVEventWriter(c *check.C) {
+	start := time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(1500 * time.Millisecond)
+	var buf bytes.Buffer
+	w := csvEventWriter{writer: csv.NewWriter(&buf)}
+	err := w.write(&eventV2{
+		ID:        "id",
+		Alarm:     "alarm1",
+		Instance:  "instance1",
+		Action:    "scale_up",
+		Status:    eventFailed,
+		StartTime: start,
+		EndTime:   &end,
+		Error:     "failed, twice",
+		Attempts:  []attemptV2{{StatusCode: 500}, {StatusCode: 502}},
+		Rollback:  &rollbackV2{Action: "scale_down"},
+	})
+	c.Assert(err, check.IsNil)
+	err = w.write(&eventV2{ID: "id2", Alarm: "alarm1", Status: eventRunning, StartTime: start})
+	c.Assert(err, check.IsNil)
+	c.Assert(w.flush(), check.IsNil)
+	expected := `id,alarm1,instance1,scale_up,failed,2017-03-01T10:00:00Z,2017-03-01T10:00:01Z,1.5,2,502,"failed, twice",scale_down,
+id2,alarm1,,,running,2017-03-01T10:00:00Z,,,0,,,,
+`
+	c.Assert(buf.String(), check.Equals, expected)
+}
+
+func (s *S) TestExportEventsInvalidParams(c *check.C) {
+	for _, query := range []string{"format=xml", "since=yesterday", "successful=maybe"} {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", "/event/export?"+query, nil)
+		c.Assert(err, check.IsNil)
+		server(recorder, request)
+		c.Assert(recorder.Code, check.Equals, http.StatusBadRequest, check.Commentf(query))
+	}
+}
+
+func (s *S) TestExportEvents(c *check.C) {
+	for _, a := range []alarm.Alarm{{Name: "alarm1", Instance: "instance1"}, {Name: "alarm2", Instance: "instance2"}} {
+		_, err := alarm.NewEvent(&a, nil)
+		c.Assert(err, check.IsNil)
+	}
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/event/export?instance=instance1", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-ndjson")
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	c.Assert(lines, check.HasLen, 1)
+	var evt eventV2
+	err = json.Unmarshal([]byte(lines[0]), &evt)
+	c.Assert(err, check.IsNil)
+	c.Assert(evt.Alarm, check.Equals, "alarm1")
+	c.Assert(evt.Instance, check.Equals, "instance1")
+	recorder = httptest.NewRecorder()
+	since := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	request, err = http.NewRequest("GET", "/event/export?format=csv&since="+since, nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "text/csv")
+	c.Assert(recorder.Body.String(), check.Equals, strings.Join(eventColumns, ",")+"\n")
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAcceptsGzip(c *check.C) {
+	tests := []struct {
+		header string
+		accept bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=1.0", true},
+		{"*", true},
+		{"gzip;q=0", false},
+		{"br", false},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("GET", "/", nil)
+		c.Assert(err, check.IsNil)
+		r.Header.Set("Accept-Encoding", tt.header)
+		c.Assert(acceptsGzip(r), check.Equals, tt.accept, check.Commentf(tt.header))
+	}
+}
+
+func (s *S) TestCompress(c *check.C) {
+	body := strings.Repeat("a", gzipMinSize)
+	h := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(body[:10]))
+		w.Write([]byte(body[10:]))
+	}))
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Header().Get("Content-Encoding"), check.Equals, "gzip")
+	c.Assert(recorder.Header().Get("Vary"), check.Equals, "Accept-Encoding")
+	reader, err := gzip.NewReader(recorder.Body)
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadAll(reader)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, body)
+}
+
+func (s *S) TestCompressSmallResponse(c *check.C) {
+	h := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+	}))
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Header().Get("Content-Encoding"), check.Equals, "")
+	c.Assert(recorder.Body.String(), check.Equals, "not found")
+}
+
+func (s *S) TestCompressWithoutAcceptEncoding(c *check.C) {
+	body := strings.Repeat("a", 2*gzipMinSize)
+	h := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, check.IsNil)
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Header().Get("Content-Encoding"), check.Equals, "")
+	c.Assert(recorder.Body.String(), check.Equals, body)
+}
+
+func (s *S) TestCompressFlush(c *check.C) {
+	h := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("line 1\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("line 2\n"))
+	}))
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Flushed, check.Equals, true)
+	c.Assert(recorder.Header().Get("Content-Encoding"), check.Equals, "gzip")
+	reader, err := gzip.NewReader(recorder.Body)
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadAll(reader)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "line 1\nline 2\n")
+}
@@ -5,22 +5,34 @@
 package api
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strings"
 )
 
+// bearerToken returns the token of the request, without the bearer prefix.
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(strings.TrimPrefix(r.Header.Get("Authorization"), "bearer "), "Bearer ")
+}
+
+// hasToken returns whether the request sends the token as a bearer token,
+// comparing them in constant time.
+func hasToken(r *http.Request, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(token)) == 1
+}
+
 type authorizationRequiredHandler func(http.ResponseWriter, *http.Request) error
 
 func (fn authorizationRequiredHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	token := r.Header.Get("Authorization")
 	if token == "" {
 		msg := "Authorization header is required."
-		logger().Print(msg)
-		http.Error(w, msg, http.StatusUnauthorized)
+		requestLogger(r).Print(msg)
+		writeError(w, newError(http.StatusUnauthorized, codeUnauthorized, msg))
 		return
 	}
-	err := fn(w, r)
-	if err != nil {
-		logger().Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := fn(w, r); err != nil {
+		requestLogger(r).Error(err)
+		writeError(w, err)
 	}
 }
@@ -5,10 +5,94 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/tsuru"
 )
 
 func healthcheck(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "WORKING")
 }
+
+// dependencyCheck checks a dependency of the autoscale, by name.
+type dependencyCheck struct {
+	name  string
+	check func() error
+}
+
+var dependencyChecks = []dependencyCheck{
+	{name: "mongodb", check: checkStorage},
+	{name: "tsuru", check: tsuru.Healthcheck},
+	{name: "runner", check: checkRunner},
+}
+
+// checkStatus is the status of a dependency and the time taken to check it.
+type checkStatus struct {
+	Status  string        `json:"status"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// checkStorage pings the storage, reported as mongodb, the default one.
+func checkStorage() error {
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Ping()
+}
+
+func checkRunner() error {
+	status, err := alarm.LastRunnerStatus()
+	if err != nil {
+		return err
+	}
+	age := time.Since(status.LastCycle)
+	if maxAge := alarm.RunnerMaxAge(); age > maxAge {
+		return fmt.Errorf("last cycle of runner %s completed %s ago, more than %s", status.Host, age, maxAge)
+	}
+	return nil
+}
+
+// deepHealthcheck checks the dependencies of the autoscale concurrently and
+// reports their status, with service unavailable when any of them fails.
+func deepHealthcheck(w http.ResponseWriter, r *http.Request) {
+	checks := make(map[string]checkStatus, len(dependencyChecks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, d := range dependencyChecks {
+		wg.Add(1)
+		go func(d dependencyCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := d.check()
+			status := checkStatus{Status: "ok", Latency: time.Since(start)}
+			if err != nil {
+				status.Status = "fail"
+				status.Error = err.Error()
+			}
+			mu.Lock()
+			checks[d.name] = status
+			mu.Unlock()
+		}(d)
+	}
+	wg.Wait()
+	result := map[string]interface{}{"status": "ok", "checks": checks}
+	code := http.StatusOK
+	for _, status := range checks {
+		if status.Status != "ok" {
+			result["status"] = "fail"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(result)
+}
@@ -5,9 +5,13 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"time"
 
+	"github.com/tsuru/tsuru-autoscale/alarm"
 	"gopkg.in/check.v1"
 )
 
@@ -19,3 +23,52 @@ func (s *S) TestHealthcheck(c *check.C) {
 	c.Assert(recorder.Code, check.Equals, http.StatusOK)
 	c.Assert(recorder.Body.String(), check.Equals, "WORKING")
 }
+
+func (s *S) TestDeepHealthcheck(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("WORKING"))
+	}))
+	defer ts.Close()
+	os.Setenv("TSURU_HOST", ts.URL)
+	defer os.Unsetenv("TSURU_HOST")
+	err := s.conn.Runners().Insert(alarm.RunnerStatus{Host: "host1", LastCycle: time.Now().UTC()})
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/healthcheck/deep", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result struct {
+		Status string
+		Checks map[string]checkStatus
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Status, check.Equals, "ok")
+	c.Assert(result.Checks, check.HasLen, 3)
+	for name, status := range result.Checks {
+		c.Assert(status.Status, check.Equals, "ok", check.Commentf("%s: %s", name, status.Error))
+	}
+}
+
+func (s *S) TestDeepHealthcheckFailure(c *check.C) {
+	os.Unsetenv("TSURU_HOST")
+	err := s.conn.Runners().Insert(alarm.RunnerStatus{Host: "host1", LastCycle: time.Now().UTC().Add(-time.Hour)})
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/healthcheck/deep", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusServiceUnavailable)
+	var result struct {
+		Status string
+		Checks map[string]checkStatus
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Status, check.Equals, "fail")
+	c.Assert(result.Checks["mongodb"].Status, check.Equals, "ok")
+	c.Assert(result.Checks["tsuru"].Error, check.Equals, "TSURU_HOST is not set")
+	c.Assert(result.Checks["runner"].Status, check.Equals, "fail")
+	c.Assert(result.Checks["runner"].Error, check.Matches, "last cycle of runner host1 completed .* ago, more than 5m0s")
+}
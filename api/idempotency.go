@@ -0,0 +1,216 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// defaultIdempotencyTTL is how long the outcomes of the requests are
+	// kept, when AUTOSCALE_IDEMPOTENCY_TTL is not set.
+	defaultIdempotencyTTL = 24 * time.Hour
+	// idempotencyLockTTL is how long a request in progress holds its key,
+	// so the keys of requests interrupted by a crash are released.
+	idempotencyLockTTL = time.Minute
+	// maxIdempotencyKey is the maximum length of the keys.
+	maxIdempotencyKey = 255
+)
+
+// idempotencyRecord is a request with an idempotency key, identified by the
+// key, the route and the token of the client, with the hash of its body
+// and, once it is done, its response.
+type idempotencyRecord struct {
+	ID          string `bson:"_id"`
+	RequestHash string
+	Done        bool
+	Status      int
+	ContentType string `bson:",omitempty"`
+	Body        []byte `bson:",omitempty"`
+	ExpiresAt   time.Time
+}
+
+// idempotencyTTL is how long the outcomes of the requests are kept, from
+// AUTOSCALE_IDEMPOTENCY_TTL, in seconds.
+func idempotencyTTL() time.Duration {
+	if t := os.Getenv("AUTOSCALE_IDEMPOTENCY_TTL"); t != "" {
+		v, err := strconv.Atoi(t)
+		if err == nil && v > 0 {
+			return time.Duration(v) * time.Second
+		}
+		logger().Printf("invalid AUTOSCALE_IDEMPOTENCY_TTL %q", t)
+	}
+	return defaultIdempotencyTTL
+}
+
+func hashOf(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyResponse records the response of a request while writing it.
+type idempotencyResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *idempotencyResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *idempotencyResponse) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// idempotent makes the create requests with the Idempotency-Key header
+// idempotent: the outcome of the first request is stored and replayed to
+// the retries, with the same key and body, instead of creating the resource
+// again. Retries of requests in progress are conflicts and the reuse of a
+// key with another body is rejected. Server errors are not stored, so the
+// requests can be retried.
+func idempotent(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			writeError(w, badRequest("Idempotency-Key must have at most 255 characters"))
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		record := idempotencyRecord{
+			ID:          hashOf(key, r.Method, r.URL.Path, r.Header.Get("Authorization")),
+			RequestHash: hashOf(string(body)),
+			ExpiresAt:   time.Now().UTC().Add(idempotencyLockTTL),
+		}
+		existing, err := lockIdempotencyKey(&record)
+		if err != nil {
+			requestLogger(r).Error(err)
+			writeError(w, err)
+			return
+		}
+		if existing != nil {
+			replay(w, &record, existing)
+			return
+		}
+		resp := idempotencyResponse{ResponseWriter: w}
+		h.ServeHTTP(&resp, r)
+		if resp.status == 0 {
+			resp.status = http.StatusOK
+		}
+		if resp.status >= http.StatusInternalServerError {
+			err = releaseIdempotencyKey(record.ID)
+		} else {
+			record.Done = true
+			record.Status = resp.status
+			record.ContentType = w.Header().Get("Content-Type")
+			record.Body = resp.body.Bytes()
+			record.ExpiresAt = time.Now().UTC().Add(idempotencyTTL())
+			err = storeIdempotencyKey(&record)
+		}
+		if err != nil {
+			requestLogger(r).Error(err)
+		}
+	})
+}
+
+// replay writes the outcome of the request with the same key, or the
+// error when it is not done or its body is different.
+func replay(w http.ResponseWriter, record, existing *idempotencyRecord) {
+	switch {
+	case existing.RequestHash != record.RequestHash:
+		writeError(w, newError(http.StatusUnprocessableEntity, codeKeyReused, "Idempotency-Key was used by a request with another body"))
+	case !existing.Done:
+		writeError(w, newError(http.StatusConflict, codeConflict, "a request with the same Idempotency-Key is in progress"))
+	default:
+		if existing.ContentType != "" {
+			w.Header().Set("Content-Type", existing.ContentType)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(existing.Status)
+		w.Write(existing.Body)
+	}
+}
+
+// lockIdempotencyKey stores the request in progress, returning the request
+// with the same key, when there is one.
+func lockIdempotencyKey(record *idempotencyRecord) (*idempotencyRecord, error) {
+	conn, err := db.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	err = conn.IdempotencyKeys().Insert(record)
+	if err == nil {
+		return nil, nil
+	}
+	if !mgo.IsDup(err) {
+		return nil, err
+	}
+	var existing idempotencyRecord
+	if err = conn.IdempotencyKeys().FindId(record.ID).One(&existing); err != nil {
+		return nil, err
+	}
+	if !existing.Done && existing.ExpiresAt.Before(time.Now()) {
+		// the request holding the key was interrupted.
+		err = conn.IdempotencyKeys().Update(bson.M{"_id": record.ID, "expiresat": existing.ExpiresAt}, record)
+		if err == nil {
+			return nil, nil
+		}
+		if err != mgo.ErrNotFound {
+			return nil, err
+		}
+	}
+	return &existing, nil
+}
+
+func storeIdempotencyKey(record *idempotencyRecord) error {
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.IdempotencyKeys().UpdateId(record.ID, record)
+}
+
+func releaseIdempotencyKey(id string) error {
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.IdempotencyKeys().Remove(bson.M{"_id": id})
+}
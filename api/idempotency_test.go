@@ -0,0 +1,128 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func postWithKey(path, key, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", path, strings.NewReader(body))
+	request.Header.Set("Idempotency-Key", key)
+	request.Header.Set("Authorization", "token")
+	server(recorder, request)
+	return recorder
+}
+
+func (s *S) TestIdempotentWithoutKey(c *check.C) {
+	calls := 0
+	h := idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	for i := 0; i < 2; i++ {
+		request, err := http.NewRequest("POST", "/alarm", nil)
+		c.Assert(err, check.IsNil)
+		h.ServeHTTP(httptest.NewRecorder(), request)
+	}
+	c.Assert(calls, check.Equals, 2)
+}
+
+func (s *S) TestIdempotentKeyTooLong(c *check.C) {
+	recorder := postWithKey("/alarm", strings.Repeat("k", 256), `{"name":"myalarm"}`)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s)\{"error":\{"code":"bad_request",.*`)
+}
+
+func (s *S) TestIdempotentReplay(c *check.C) {
+	body := `{"name":"myalarm","expression":"x > 1"}`
+	recorder := postWithKey("/alarm", "key1", body)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Header().Get("Idempotent-Replayed"), check.Equals, "")
+	recorder = postWithKey("/alarm", "key1", body)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Header().Get("Idempotent-Replayed"), check.Equals, "true")
+	n, err := s.conn.Alarms().Find(bson.M{"name": "myalarm"}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+	recorder = postWithKey("/alarm", "key2", body)
+	c.Assert(recorder.Code, check.Equals, http.StatusInternalServerError)
+}
+
+func (s *S) TestIdempotentKeyReused(c *check.C) {
+	recorder := postWithKey("/alarm", "key1", `{"name":"myalarm"}`)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	recorder = postWithKey("/alarm", "key1", `{"name":"other"}`)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnprocessableEntity)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s)\{"error":\{"code":"idempotency_key_reused",.*`)
+	_, err := alarm.FindAlarmByName("other")
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestIdempotentInProgress(c *check.C) {
+	calls := 0
+	h := idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "/alarm", strings.NewReader("{}"))
+		request.Header.Set("Idempotency-Key", "key1")
+		idempotent(http.NotFoundHandler()).ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusConflict)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/alarm", strings.NewReader("{}"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Idempotency-Key", "key1")
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(calls, check.Equals, 1)
+}
+
+func (s *S) TestIdempotentInterruptedRequest(c *check.C) {
+	record := idempotencyRecord{
+		ID:          hashOf("key1", "POST", "/alarm", ""),
+		RequestHash: hashOf("{}"),
+		ExpiresAt:   time.Now().Add(-time.Second),
+	}
+	err := s.conn.IdempotencyKeys().Insert(record)
+	c.Assert(err, check.IsNil)
+	h := idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/alarm", strings.NewReader("{}"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Idempotency-Key", "key1")
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	var stored idempotencyRecord
+	err = s.conn.IdempotencyKeys().FindId(record.ID).One(&stored)
+	c.Assert(err, check.IsNil)
+	c.Assert(stored.Done, check.Equals, true)
+	c.Assert(stored.Status, check.Equals, http.StatusCreated)
+}
+
+func (s *S) TestIdempotentServerErrorNotStored(c *check.C) {
+	calls := 0
+	h := idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	for i := 0; i < 2; i++ {
+		request, err := http.NewRequest("POST", "/alarm", strings.NewReader("{}"))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Idempotency-Key", "key1")
+		h.ServeHTTP(httptest.NewRecorder(), request)
+	}
+	c.Assert(calls, check.Equals, 2)
+}
@@ -0,0 +1,57 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/datasource"
+)
+
+// Version is the version of the server, set when building it:
+//
+//	go build -ldflags "-X github.com/tsuru/tsuru-autoscale/api.Version=1.2.0"
+var Version = "dev"
+
+// info describes what the installation supports, so clients can adapt to
+// it. The limits are the ones applied by default: zero actions per
+// instance means the actions are not rate limited.
+type info struct {
+	Version           string     `json:"version"`
+	DataSourceTypes   []string   `json:"datasourceTypes"`
+	ActionTypes       []string   `json:"actionTypes"`
+	ExpressionEngines []string   `json:"expressionEngines"`
+	Limits            infoLimits `json:"limits"`
+}
+
+type infoLimits struct {
+	CheckInterval         int   `json:"checkIntervalSeconds"`
+	ActionsPerInstance    int   `json:"actionsPerInstance"`
+	ActionsWindow         int   `json:"actionsWindowSeconds"`
+	MaxResponseSize       int64 `json:"maxResponseSize"`
+	IdempotencyKeysExpire int   `json:"idempotencyKeysExpireSeconds"`
+}
+
+func infoHandler(w http.ResponseWriter, r *http.Request) error {
+	limit, window := alarm.RateLimit()
+	i := info{
+		Version:           Version,
+		DataSourceTypes:   datasource.Types(),
+		ActionTypes:       action.EnabledTypes(),
+		ExpressionEngines: []string{alarm.ExpressionEngine},
+		Limits: infoLimits{
+			CheckInterval:         int(alarm.CheckInterval().Seconds()),
+			ActionsPerInstance:    limit,
+			ActionsWindow:         int(window.Seconds()),
+			MaxResponseSize:       datasource.MaxResponseSize(),
+			IdempotencyKeysExpire: int(idempotencyTTL().Seconds()),
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(i)
+}
@@ -0,0 +1,43 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/tsuru/tsuru-autoscale/datasource"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestInfo(c *check.C) {
+	os.Setenv("AUTOSCALE_RATE_LIMIT", "20")
+	defer os.Unsetenv("AUTOSCALE_RATE_LIMIT")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/info", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.HeaderMap["Content-Type"], check.DeepEquals, []string{"application/json"})
+	var got info
+	err = json.NewDecoder(recorder.Body).Decode(&got)
+	c.Assert(err, check.IsNil)
+	expected := info{
+		Version:           "dev",
+		DataSourceTypes:   datasource.Types(),
+		ActionTypes:       []string{"http", "opsgenie", "pagerduty", "slack", "teams", "tsuru"},
+		ExpressionEngines: []string{"javascript"},
+		Limits: infoLimits{
+			CheckInterval:         10,
+			ActionsPerInstance:    20,
+			ActionsWindow:         3600,
+			MaxResponseSize:       datasource.DefaultMaxResponseSize,
+			IdempotencyKeysExpire: 86400,
+		},
+	}
+	c.Assert(got, check.DeepEquals, expected)
+}
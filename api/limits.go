@@ -0,0 +1,153 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHandlerTimeout is the deadline of the handlers, when
+	// AUTOSCALE_HANDLER_TIMEOUT is not set.
+	defaultHandlerTimeout = time.Minute
+	// defaultMaxBodySize is the maximum size of the request bodies, when
+	// AUTOSCALE_MAX_BODY_SIZE is not set.
+	defaultMaxBodySize = 1 << 20
+	// noTimeout is the timeout of the routes without deadline, like the
+	// streaming ones, which are bounded by the write timeout of the server.
+	noTimeout = -1
+)
+
+// handlerTimeout is the deadline of the handlers, from
+// AUTOSCALE_HANDLER_TIMEOUT, in seconds.
+func handlerTimeout() time.Duration {
+	if t := os.Getenv("AUTOSCALE_HANDLER_TIMEOUT"); t != "" {
+		v, err := strconv.Atoi(t)
+		if err == nil && v > 0 {
+			return time.Duration(v) * time.Second
+		}
+		logger().Printf("invalid AUTOSCALE_HANDLER_TIMEOUT %q", t)
+	}
+	return defaultHandlerTimeout
+}
+
+// maxBodySize is the maximum size of the request bodies, from
+// AUTOSCALE_MAX_BODY_SIZE, in bytes.
+func maxBodySize() int64 {
+	if s := os.Getenv("AUTOSCALE_MAX_BODY_SIZE"); s != "" {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err == nil && v > 0 {
+			return v
+		}
+		logger().Printf("invalid AUTOSCALE_MAX_BODY_SIZE %q", s)
+	}
+	return defaultMaxBodySize
+}
+
+// errBodyTooLarge is the message of the error of the bodies read past the
+// limit by http.MaxBytesReader, which has no type of its own.
+const errBodyTooLarge = "http: request body too large"
+
+func tooLarge(limit int64) *apiError {
+	return newError(http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("request body must have at most %d bytes", limit))
+}
+
+// limitBody rejects the requests with bodies larger than limit, at once when
+// they send their length or while the handler reads them.
+func limitBody(limit int64, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			writeError(w, tooLarge(limit))
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// timeoutWriter buffers the response of a handler with a deadline, so it is
+// discarded when the deadline expires.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+// withTimeout responds with a 503 error when the handler does not finish
+// before the timeout. The handler keeps running, as the storage operations
+// can not be cancelled, but its response is discarded.
+func withTimeout(timeout time.Duration, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := timeoutWriter{header: http.Header{}}
+		done := make(chan struct{})
+		panics := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panics <- p
+				}
+			}()
+			h.ServeHTTP(&tw, r)
+			close(done)
+		}()
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case p := <-panics:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for key, values := range tw.header {
+				w.Header()[key] = values
+			}
+			if tw.status == 0 {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.body.Bytes())
+		case <-timer.C:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			requestLogger(r).Printf("request timed out after %s", timeout)
+			writeError(w, newError(http.StatusServiceUnavailable, codeTimeout, fmt.Sprintf("request timed out after %s", timeout)))
+		}
+	})
+}
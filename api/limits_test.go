@@ -0,0 +1,120 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestMaxBodySize(c *check.C) {
+	c.Assert(maxBodySize(), check.Equals, int64(defaultMaxBodySize))
+	os.Setenv("AUTOSCALE_MAX_BODY_SIZE", "10")
+	defer os.Unsetenv("AUTOSCALE_MAX_BODY_SIZE")
+	c.Assert(maxBodySize(), check.Equals, int64(10))
+	os.Setenv("AUTOSCALE_MAX_BODY_SIZE", "big")
+	c.Assert(maxBodySize(), check.Equals, int64(defaultMaxBodySize))
+}
+
+func (s *S) TestHandlerTimeout(c *check.C) {
+	c.Assert(handlerTimeout(), check.Equals, defaultHandlerTimeout)
+	os.Setenv("AUTOSCALE_HANDLER_TIMEOUT", "5")
+	defer os.Unsetenv("AUTOSCALE_HANDLER_TIMEOUT")
+	c.Assert(handlerTimeout(), check.Equals, 5*time.Second)
+}
+
+func (s *S) TestLimitBody(c *check.C) {
+	h := limitBody(4, handler(func(w http.ResponseWriter, r *http.Request) error {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		w.Write(body)
+		return nil
+	}))
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/", strings.NewReader("abc"))
+	c.Assert(err, check.IsNil)
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "abc")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/", strings.NewReader("abcdef"))
+	c.Assert(err, check.IsNil)
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusRequestEntityTooLarge)
+	c.Assert(recorder.Body.String(), check.Equals, `{"error":{"code":"too_large","message":"request body must have at most 4 bytes"}}`+"\n")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/", ioutil.NopCloser(strings.NewReader("abcdef")))
+	c.Assert(err, check.IsNil)
+	c.Assert(request.ContentLength, check.Equals, int64(0))
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusRequestEntityTooLarge)
+}
+
+func (s *S) TestWithTimeout(c *check.C) {
+	release := make(chan struct{})
+	defer close(release)
+	h := withTimeout(20*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	}))
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/fast", nil)
+	c.Assert(err, check.IsNil)
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "text/plain")
+	c.Assert(recorder.Body.String(), check.Equals, "done")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/slow", nil)
+	c.Assert(err, check.IsNil)
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusServiceUnavailable)
+	c.Assert(recorder.Body.String(), check.Equals, `{"error":{"code":"timeout","message":"request timed out after 20ms"}}`+"\n")
+}
+
+func (s *S) TestWithTimeoutPanic(c *check.C) {
+	h := withTimeout(time.Second, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	request, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(func() { h.ServeHTTP(httptest.NewRecorder(), request) }, check.PanicMatches, "boom")
+}
+
+func (s *S) TestWithTimeoutAfterTheRouterReturns(c *check.C) {
+	release := make(chan struct{})
+	type values struct {
+		name   string
+		logged bool
+	}
+	done := make(chan values, 1)
+	m := mux.NewRouter()
+	m.Handle("/alarm/{name}", logRequests("GET", "/alarm/{name}", withTimeout(20*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		done <- values{routeVars(r)["name"], requestLogger(r) != logger()}
+	}))))
+	request, err := http.NewRequest("GET", "/alarm/cpu", nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusServiceUnavailable)
+	close(release)
+	v := <-done
+	c.Assert(v.name, check.Equals, "cpu")
+	c.Assert(v.logged, check.Equals, true)
+}
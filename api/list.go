@@ -0,0 +1,158 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// maxListLimit is the maximum page size of the list endpoints.
+const maxListLimit = 1000
+
+// listSpec describes the query parameters of a list endpoint: the fields
+// that can be filtered and sorted, by parameter name, and the default page
+// size, 0 meaning all the items.
+type listSpec struct {
+	filters map[string]listField
+	sorts   map[string]string
+	limit   int
+}
+
+// listField is a filtered field and whether its values are booleans. The
+// boolean filters matching more than a field have a query instead.
+type listField struct {
+	field   string
+	boolean bool
+	query   func(value bool) bson.M
+}
+
+var (
+	dataSourceList = listSpec{
+		filters: map[string]listField{"name": {field: "name"}, "type": {field: "type"}, "public": {field: "public", boolean: true}},
+		sorts:   map[string]string{"name": "name", "type": "type"},
+	}
+	actionList = listSpec{
+		filters: map[string]listField{"name": {field: "name"}, "type": {field: "type"}},
+		sorts:   map[string]string{"name": "name", "type": "type"},
+	}
+	alarmList = listSpec{
+		filters: map[string]listField{
+			"name":       {field: "name"},
+			"instance":   {field: "instance"},
+			"enabled":    {field: "enabled", boolean: true},
+			"action":     {field: "actions"},
+			"datasource": {field: "datasources"},
+		},
+		sorts: map[string]string{"name": "name", "instance": "instance"},
+	}
+	wizardList = listSpec{
+		filters: map[string]listField{"name": {field: "name"}, "process": {field: "process"}},
+		sorts:   map[string]string{"name": "name"},
+	}
+	eventList = listSpec{
+		filters: map[string]listField{
+			"alarm":       {field: "alarm.name"},
+			"action":      {field: "action.name"},
+			"successful":  {field: "successful", boolean: true},
+			"outstanding": {boolean: true, query: outstandingEvents},
+		},
+		sorts: map[string]string{"starttime": "starttime", "endtime": "endtime"},
+		limit: 200,
+	}
+)
+
+// outstandingEvents selects the failed events not acknowledged yet, or the
+// other ones.
+func outstandingEvents(outstanding bool) bson.M {
+	if outstanding {
+		return alarm.OutstandingEvents()
+	}
+	return bson.M{"$nor": []bson.M{alarm.OutstandingEvents()}}
+}
+
+// options parses the list query parameters of the request: limit, up to
+// maxListLimit, offset, sort, a comma separated list of fields prefixed by
+// - for descending order, and the field filters.
+func (s *listSpec) options(r *http.Request) (*db.ListOptions, error) {
+	query := r.URL.Query()
+	opts := db.ListOptions{Limit: s.limit, Filter: bson.M{}}
+	if l := query.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		opts.Limit = limit
+	}
+	if o := query.Get("offset"); o != "" {
+		offset, err := strconv.Atoi(o)
+		if err != nil || offset < 0 {
+			return nil, errors.New("offset must not be negative")
+		}
+		opts.Offset = offset
+	}
+	if sort := query.Get("sort"); sort != "" {
+		for _, name := range strings.Split(sort, ",") {
+			prefix := ""
+			if strings.HasPrefix(name, "-") {
+				prefix, name = "-", name[1:]
+			}
+			field, ok := s.sorts[name]
+			if !ok {
+				return nil, fmt.Errorf("invalid sort field %q", name)
+			}
+			opts.Sort = append(opts.Sort, prefix+field)
+		}
+	}
+	for name, f := range s.filters {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		if !f.boolean {
+			opts.Filter[f.field] = value
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", name)
+		}
+		if f.query == nil {
+			opts.Filter[f.field] = b
+			continue
+		}
+		and, _ := opts.Filter["$and"].([]bson.M)
+		opts.Filter["$and"] = append(and, f.query(b))
+	}
+	return &opts, nil
+}
+
+// listQuery returns the query parameters of a list endpoint, sorted.
+func listQuery(s listSpec) []string {
+	params := []string{"limit", "offset", "sort"}
+	filters := make([]string, 0, len(s.filters))
+	for name := range s.filters {
+		filters = append(filters, name)
+	}
+	sort.Strings(filters)
+	return append(params, filters...)
+}
+
+// writeList writes the page of a list endpoint, with the total of items
+// matching the filters in the X-Total-Count header.
+func writeList(w http.ResponseWriter, total int, page interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	return json.NewEncoder(w).Encode(page)
+}
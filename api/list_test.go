@@ -0,0 +1,91 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestListOptions(c *check.C) {
+	request, err := http.NewRequest("GET", "/alarm?limit=10&offset=20&sort=-name,instance&enabled=true&datasource=cpu&unknown=x", nil)
+	c.Assert(err, check.IsNil)
+	opts, err := alarmList.options(request)
+	c.Assert(err, check.IsNil)
+	c.Assert(opts, check.DeepEquals, &db.ListOptions{
+		Limit:  10,
+		Offset: 20,
+		Sort:   []string{"-name", "instance"},
+		Filter: bson.M{"enabled": true, "datasources": "cpu"},
+	})
+	request, err = http.NewRequest("GET", "/alarm/a/event", nil)
+	c.Assert(err, check.IsNil)
+	opts, err = eventList.options(request)
+	c.Assert(err, check.IsNil)
+	c.Assert(opts, check.DeepEquals, &db.ListOptions{Limit: 200, Filter: bson.M{}})
+	for query, msg := range map[string]string{
+		"limit=0":         "limit must be between 1 and 1000",
+		"limit=1001":      "limit must be between 1 and 1000",
+		"offset=-1":       "offset must not be negative",
+		"sort=url":        `invalid sort field "url"`,
+		"successful=nope": "successful must be true or false",
+	} {
+		request, err = http.NewRequest("GET", "/alarm/a/event?"+query, nil)
+		c.Assert(err, check.IsNil)
+		_, err = eventList.options(request)
+		c.Check(err, check.ErrorMatches, msg)
+	}
+}
+
+func (s *S) TestListOptionsQuery(c *check.C) {
+	request, err := http.NewRequest("GET", "/alarm/a/event?outstanding=true&successful=false", nil)
+	c.Assert(err, check.IsNil)
+	opts, err := eventList.options(request)
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.Filter, check.DeepEquals, bson.M{
+		"successful": false,
+		"$and":       []bson.M{alarm.OutstandingEvents()},
+	})
+	request, err = http.NewRequest("GET", "/alarm/a/event?outstanding=false", nil)
+	c.Assert(err, check.IsNil)
+	opts, err = eventList.options(request)
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.Filter, check.DeepEquals, bson.M{"$and": []bson.M{{"$nor": []bson.M{alarm.OutstandingEvents()}}}})
+}
+
+func (s *S) TestListQuery(c *check.C) {
+	c.Assert(listQuery(actionList), check.DeepEquals, []string{"limit", "offset", "sort", "name", "type"})
+}
+
+func (s *S) TestAllActionsPagination(c *check.C) {
+	for _, name := range []string{"c", "a", "b"} {
+		err := action.New(&action.Action{Name: name, URL: "http://tsuru.io", Method: "GET"})
+		c.Assert(err, check.IsNil)
+	}
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/action?limit=2&offset=1&sort=-name", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("X-Total-Count"), check.Equals, "3")
+	var actions []action.Action
+	err = json.Unmarshal(recorder.Body.Bytes(), &actions)
+	c.Assert(err, check.IsNil)
+	c.Assert(actions, check.HasLen, 2)
+	c.Assert(actions[0].Name, check.Equals, "b")
+	c.Assert(actions[1].Name, check.Equals, "a")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/action?sort=url", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
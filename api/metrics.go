@@ -0,0 +1,138 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/metrics"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec(
+		"autoscale_http_requests_total",
+		"Total of api requests, by method, route and status code.",
+		"method", "route", "code",
+	)
+	httpDuration = metrics.NewHistogramVec(
+		"autoscale_http_request_duration_seconds",
+		"Latency of the api requests, by method and route.",
+		metrics.DefBuckets, "method", "route",
+	)
+	runnerLastCycle = metrics.NewGaugeVec(
+		"autoscale_runner_last_cycle_timestamp_seconds",
+		"End of the last alarms check cycle completed by each runner.",
+		"host",
+	)
+	runnerCycleDuration = metrics.NewGaugeVec(
+		"autoscale_runner_cycle_duration_seconds",
+		"Duration of the last alarms check cycle of each runner.",
+		"host",
+	)
+	alarmsTotal = metrics.NewGaugeVec(
+		"autoscale_alarms",
+		"Alarms, by enabled.",
+		"enabled",
+	)
+	deadLettersTotal = metrics.NewGaugeVec(
+		"autoscale_action_dead_letters",
+		"Failed action executions waiting in the dead letters.",
+	)
+	// collectMu serializes the scrapes, which reset the alarm engine gauges.
+	collectMu sync.Mutex
+)
+
+func init() {
+	metrics.Register(httpRequests, httpDuration, runnerLastCycle, runnerCycleDuration, alarmsTotal, deadLettersTotal)
+}
+
+// statusRecorder records the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush flushes the response of the streaming handlers.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument counts the requests of the route and observes their latency.
+func instrument(method, path string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(&rec, r)
+		httpDuration.Observe(time.Since(start).Seconds(), method, path)
+		httpRequests.Inc(method, path, strconv.Itoa(rec.status))
+	})
+}
+
+// collectEngineMetrics sets the alarm engine gauges from the runners status
+// and the alarms stored by the agents, which run in other processes.
+func collectEngineMetrics() error {
+	statuses, err := alarm.RunnerStatuses()
+	if err != nil {
+		return err
+	}
+	runnerLastCycle.Reset()
+	runnerCycleDuration.Reset()
+	for _, s := range statuses {
+		runnerLastCycle.Set(float64(s.LastCycle.UnixNano())/1e9, s.Host)
+		runnerCycleDuration.Set(s.Duration.Seconds(), s.Host)
+	}
+	store, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	for _, enabled := range []bool{true, false} {
+		n, err := store.Alarms().Find(bson.M{"enabled": enabled}).Count()
+		if err != nil {
+			return err
+		}
+		alarmsTotal.Set(float64(n), strconv.FormatBool(enabled))
+	}
+	n, err := store.ActionDeadLetters().Find(nil).Count()
+	if err != nil {
+		return err
+	}
+	deadLettersTotal.Set(float64(n))
+	return nil
+}
+
+// metricsHandler writes the api and alarm engine metrics in the Prometheus
+// text format. When AUTOSCALE_METRICS_TOKEN is set, the requests must send
+// it as a bearer token.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if token := os.Getenv("AUTOSCALE_METRICS_TOKEN"); token != "" {
+		if !hasToken(r, token) {
+			writeError(w, newError(http.StatusUnauthorized, codeUnauthorized, "invalid metrics token"))
+			return
+		}
+	}
+	collectMu.Lock()
+	defer collectMu.Unlock()
+	db.CollectMetrics()
+	if err := collectEngineMetrics(); err != nil {
+		logger().Error(err)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.Write(w)
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestMetrics(c *check.C) {
+	err := s.conn.Runners().Insert(alarm.RunnerStatus{Host: "host1", LastCycle: time.Unix(1500000000, 0), Duration: 2 * time.Second})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Alarms().Insert(alarm.Alarm{Name: "alarm1", Enabled: true})
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/action/unknown", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/metrics", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "text/plain; version=0.0.4")
+	body := recorder.Body.String()
+	c.Assert(body, check.Matches, `(?s).*autoscale_http_requests_total\{method="GET",route="/action/\{name\}",code="404"\} \d+\n.*`)
+	c.Assert(body, check.Matches, `(?s).*autoscale_http_deprecated_requests_total\{method="GET",route="/action/\{name\}"\} \d+\n.*`)
+	c.Assert(body, check.Matches, `(?s).*autoscale_http_request_duration_seconds_count\{method="GET",route="/action/\{name\}"\} \d+\n.*`)
+	c.Assert(body, check.Matches, `(?s).*autoscale_runner_last_cycle_timestamp_seconds\{host="host1"\} 1\.5e\+09\n.*`)
+	c.Assert(body, check.Matches, `(?s).*autoscale_runner_cycle_duration_seconds\{host="host1"\} 2\n.*`)
+	c.Assert(body, check.Matches, `(?s).*autoscale_alarms\{enabled="true"\} 1\n.*`)
+	c.Assert(body, check.Matches, `(?s).*autoscale_action_dead_letters 0\n.*`)
+}
+
+func (s *S) TestMetricsToken(c *check.C) {
+	os.Setenv("AUTOSCALE_METRICS_TOKEN", "secret")
+	defer os.Unsetenv("AUTOSCALE_METRICS_TOKEN")
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/metrics", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+	recorder = httptest.NewRecorder()
+	request.Header.Set("Authorization", "bearer wrong")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+	recorder = httptest.NewRecorder()
+	request.Header.Set("Authorization", "bearer secret")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+}
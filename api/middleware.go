@@ -18,9 +18,9 @@ func (a *authMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next
 	if r.URL.Path != "/healthcheck" {
 		token := r.Header.Get("Authorization")
 		if token == "" {
-			err := "Authorization header is required."
-			logger().Print(err)
-			http.Error(rw, err, http.StatusUnauthorized)
+			msg := "Authorization header is required."
+			logger().Print(msg)
+			writeError(rw, newError(http.StatusUnauthorized, codeUnauthorized, msg))
 		}
 	}
 	next(rw, r)
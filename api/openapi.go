@@ -0,0 +1,111 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// openAPISpec returns the OpenAPI 2.0 specification of the api routes.
+func openAPISpec() map[string]interface{} {
+	paths := map[string]map[string]interface{}{}
+	for _, r := range append(append([]route{}, routes...), openAPIRoute) {
+		if paths[r.path] == nil {
+			paths[r.path] = map[string]interface{}{}
+		}
+		paths[r.path][strings.ToLower(r.method)] = r.operation()
+	}
+	return map[string]interface{}{
+		"swagger": "2.0",
+		"info": map[string]string{
+			"title":   "tsuru-autoscale",
+			"version": "1.0",
+		},
+		"consumes": []string{"application/json"},
+		"produces": []string{"application/json"},
+		"securityDefinitions": map[string]interface{}{
+			"token": map[string]string{"type": "apiKey", "name": "Authorization", "in": "header"},
+		},
+		"paths": paths,
+	}
+}
+
+// openAPIRoute documents the route serving the specification, which is not
+// in routes because it depends on them.
+var openAPIRoute = route{method: "GET", path: "/openapi.json", summary: "Gets the OpenAPI specification of the api"}
+
+// operation returns the OpenAPI operation of the route.
+func (r *route) operation() map[string]interface{} {
+	params := []map[string]interface{}{}
+	for _, match := range pathParam.FindAllStringSubmatch(r.path, -1) {
+		params = append(params, map[string]interface{}{"name": match[1], "in": "path", "required": true, "type": "string"})
+	}
+	for _, name := range r.query {
+		params = append(params, map[string]interface{}{"name": name, "in": "query", "type": "string"})
+	}
+	op := map[string]interface{}{
+		"summary": r.summary,
+		"tags":    []string{r.tag()},
+	}
+	switch r.body {
+	case "json":
+		schema := r.schema
+		if schema == nil {
+			schema = &jsonSchema{Type: "object"}
+		}
+		params = append(params, map[string]interface{}{"name": "body", "in": "body", "required": true, "schema": schema})
+	case "form":
+		op["consumes"] = []string{"application/x-www-form-urlencoded"}
+	}
+	if isYAMLRoute(r.path) {
+		types := []string{"application/json", yamlContentType}
+		if r.body == "json" {
+			op["consumes"] = types
+		}
+		op["produces"] = types
+	}
+	op["parameters"] = params
+	status := r.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	op["responses"] = map[string]interface{}{
+		strconv.Itoa(status): map[string]string{"description": http.StatusText(status)},
+		"404":                map[string]string{"description": http.StatusText(http.StatusNotFound)},
+		"500":                map[string]string{"description": http.StatusText(http.StatusInternalServerError)},
+	}
+	if r.successor != "" {
+		op["deprecated"] = true
+	}
+	if _, ok := r.handler.(authorizationRequiredHandler); ok {
+		op["security"] = []map[string][]string{{"token": {}}}
+	}
+	return op
+}
+
+// tag returns the OpenAPI tag grouping the route: the first segment of its
+// path, with the events of the alarms and auto scales in the events tag and
+// the tsuru service routes in the service tag.
+func (r *route) tag() string {
+	if strings.Contains(r.path, "/event") {
+		return "events"
+	}
+	segment := strings.SplitN(strings.TrimPrefix(r.path, "/"), "/", 2)[0]
+	if segment == "resources" {
+		return "service"
+	}
+	return strings.TrimSuffix(segment, ".json")
+}
+
+func openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAPISpec())
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestOpenAPI(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/openapi.json", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.HeaderMap["Content-Type"], check.DeepEquals, []string{"application/json"})
+	var spec struct {
+		Swagger string
+		Paths   map[string]map[string]struct {
+			Summary    string
+			Tags       []string
+			Parameters []struct {
+				Name string
+				In   string
+			}
+			Responses map[string]interface{}
+			Security  []map[string][]string
+		}
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &spec)
+	c.Assert(err, check.IsNil)
+	c.Assert(spec.Swagger, check.Equals, "2.0")
+	for _, r := range routes {
+		op, ok := spec.Paths[r.path][strings.ToLower(r.method)]
+		c.Check(ok, check.Equals, true, check.Commentf("%s %s", r.method, r.path))
+		c.Check(op.Summary, check.Not(check.Equals), "")
+	}
+	c.Assert(spec.Paths["/openapi.json"]["get"].Tags, check.DeepEquals, []string{"openapi"})
+	op := spec.Paths["/action/{name}/executions"]["get"]
+	c.Assert(op.Tags, check.DeepEquals, []string{"action"})
+	c.Assert(op.Parameters, check.HasLen, 3)
+	c.Assert(op.Parameters[0].Name, check.Equals, "name")
+	c.Assert(op.Parameters[0].In, check.Equals, "path")
+	c.Assert(op.Parameters[1].In, check.Equals, "query")
+	op = spec.Paths["/action"]["post"]
+	c.Assert(op.Parameters[0].In, check.Equals, "body")
+	_, ok := op.Responses["201"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(spec.Paths["/alarm"]["get"].Security, check.DeepEquals, []map[string][]string{{"token": {}}})
+	c.Assert(spec.Paths["/alarm"]["post"].Security, check.IsNil)
+	c.Assert(spec.Paths["/alarm/{name}/event"]["get"].Tags, check.DeepEquals, []string{"events"})
+	c.Assert(spec.Paths["/resources/{name}/bind"]["post"].Tags, check.DeepEquals, []string{"service"})
+}
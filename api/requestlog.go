@@ -0,0 +1,102 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tsuru/tsuru-autoscale/log"
+)
+
+type contextKey int
+
+// Keys of the values of the request context.
+const (
+	// loggerKey is the key of the request logger.
+	loggerKey contextKey = iota
+	// varsKey is the key of the route variables.
+	varsKey
+)
+
+// withContext returns a shallow copy of r with ctx. The route variables,
+// which mux keeps by request until the router returns, are carried in the
+// context, so routeVars finds them in the copies, even in the handlers
+// still running after their deadline.
+func withContext(r *http.Request, ctx context.Context) *http.Request {
+	if _, ok := ctx.Value(varsKey).(map[string]string); !ok {
+		ctx = context.WithValue(ctx, varsKey, mux.Vars(r))
+	}
+	return r.WithContext(ctx)
+}
+
+// routeVars returns the route variables of the request.
+func routeVars(r *http.Request) map[string]string {
+	if vars, ok := r.Context().Value(varsKey).(map[string]string); ok {
+		return vars
+	}
+	return mux.Vars(r)
+}
+
+// requestIDPattern matches the request ids accepted from the clients, in
+// the X-Request-ID header.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// principal identifies the client of the request by a prefix of the hash
+// of its token, so the token itself is not logged.
+func principal(r *http.Request) string {
+	token := bearerToken(r)
+	if token == "" {
+		return "-"
+	}
+	return hashOf(token)[:12]
+}
+
+// requestLogger returns the logger of the request, which writes its
+// request id, or the api logger out of logRequests.
+func requestLogger(r *http.Request) *log.Logger {
+	if l, ok := r.Context().Value(loggerKey).(*log.Logger); ok {
+		return l
+	}
+	return logger()
+}
+
+// logRequests assigns a request id to the requests of the route, sent back
+// in the X-Request-ID header, and logs their method, route, status,
+// latency and principal. The request id is the one sent by the client,
+// when it is valid, so the requests can be traced across services. The
+// logs of the request, and of the alarms and actions it triggers, carry
+// the request id.
+func logRequests(method, route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		lg := logger().With("request_id", id)
+		r = withContext(r, context.WithValue(r.Context(), loggerKey, lg))
+		rec := statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(&rec, r)
+		lg.With("method", method).
+			With("route", route).
+			With("status", rec.status).
+			With("latency_ms", time.Since(start).Nanoseconds()/int64(time.Millisecond)).
+			With("principal", principal(r)).
+			Print("request")
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru-autoscale/log"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestLogRequests(c *check.C) {
+	var lg *log.Logger
+	h := logRequests("GET", "/alarm/{name}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lg = requestLogger(r)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/alarm/cpu", nil)
+	c.Assert(err, check.IsNil)
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+	c.Assert(recorder.Header().Get("X-Request-ID"), check.Matches, "[0-9a-f]{16}")
+	c.Assert(lg, check.NotNil)
+	c.Assert(lg, check.Not(check.Equals), logger())
+}
+
+func (s *S) TestLogRequestsClientRequestID(c *check.C) {
+	h := logRequests("GET", "/alarm", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"abc-123_x.y", true},
+		{"has space", false},
+		{strings.Repeat("a", 65), false},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", "/alarm", nil)
+		c.Assert(err, check.IsNil)
+		request.Header.Set("X-Request-ID", tt.id)
+		h.ServeHTTP(recorder, request)
+		id := recorder.Header().Get("X-Request-ID")
+		c.Assert(id == tt.id, check.Equals, tt.valid, check.Commentf(tt.id))
+		c.Assert(id, check.Matches, "[A-Za-z0-9._-]+")
+	}
+}
+
+func (s *S) TestRequestLoggerOutOfRequest(c *check.C) {
+	request, err := http.NewRequest("GET", "/alarm", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(requestLogger(request), check.Equals, logger())
+}
+
+func (s *S) TestPrincipal(c *check.C) {
+	request, err := http.NewRequest("GET", "/alarm", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(principal(request), check.Equals, "-")
+	request.Header.Set("Authorization", "bearer secret")
+	p := principal(request)
+	c.Assert(p, check.HasLen, 12)
+	c.Assert(strings.Contains(p, "secret"), check.Equals, false)
+	request.Header.Set("Authorization", "secret")
+	c.Assert(principal(request), check.Equals, p)
+}
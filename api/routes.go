@@ -0,0 +1,127 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// route is an api route, registered by Router and documented in the
+// OpenAPI specification: the query parameters read by the handler, the
+// encoding of the request body, json or form, the schema of the json bodies
+// validated by the handler, the status of the successful responses, 200 by
+// default, the deadline of the handler, the handler timeout by default or
+// noTimeout, and the v2 route replacing it, when it is deprecated.
+type route struct {
+	method    string
+	path      string
+	handler   http.Handler
+	summary   string
+	query     []string
+	body      string
+	schema    *jsonSchema
+	status    int
+	timeout   time.Duration
+	successor string
+}
+
+// routes are the api routes, matched in order, so the static paths, like
+// /action/export, come before the ones with variables.
+var routes = []route{
+	{method: "GET", path: "/healthcheck", handler: http.HandlerFunc(healthcheck), summary: "Checks the api health"},
+	{method: "GET", path: "/healthcheck/deep", handler: http.HandlerFunc(deepHealthcheck), summary: "Checks the health of the api dependencies"},
+	{method: "GET", path: "/schema", handler: handler(listSchemas), summary: "Lists the JSON Schemas of the request bodies"},
+	{method: "GET", path: "/schema/{name}", handler: handler(getSchema), summary: "Gets the JSON Schema of a request body"},
+	{method: "GET", path: "/info", handler: handler(infoHandler), summary: "Describes the version, the supported types and the limits of the installation"},
+	{method: "GET", path: "/search", handler: handler(searchResources), summary: "Searches the wizards, alarms, data sources and actions", query: []string{"q", "type", "limit"}},
+	{method: "DELETE", path: "/admin/events", handler: adminHandler(removeEvents), summary: "Removes the events matching the filters in batches, streaming the progress", query: []string{"before", "batch", "instance", "alarm", "action", "successful", "outstanding"}, timeout: noTimeout},
+	{method: "GET", path: "/admin/instances", handler: adminHandler(listAdminInstances), summary: "Lists the service instances of all the teams, with their last events and failures", query: append(listQuery(adminInstanceList), "since")},
+	{method: "GET", path: "/admin/instances/{name}", handler: adminHandler(adminInstanceInfo), summary: "Gets the overview of a service instance", query: []string{"since"}},
+	{method: "GET", path: "/admin/export", handler: adminHandler(exportBackup), summary: "Exports the data sources, actions, alarms and auto scales into an archive", query: []string{"secrets"}},
+	{method: "POST", path: "/admin/import", handler: adminHandler(importBackup), summary: "Imports an archive of data sources, actions, alarms and auto scales", query: []string{"conflict"}, body: "json"},
+	{method: "GET", path: "/admin/audit", handler: adminHandler(listAuditLog), summary: "Lists the state changing api requests", query: append(listQuery(auditList), "since", "until")},
+	{method: "GET", path: "/metrics", handler: http.HandlerFunc(metricsHandler), summary: "Gets the api and alarm engine metrics in the Prometheus format"},
+	{method: "POST", path: "/datasource", handler: idempotent(handler(newDataSource)), summary: "Adds a data source", body: "json", status: http.StatusCreated, successor: "/v2/datasources"},
+	{method: "GET", path: "/datasource", handler: handler(allDataSources), summary: "Lists the data sources", query: listQuery(dataSourceList), successor: "/v2/datasources"},
+	{method: "POST", path: "/datasource/bulk", handler: handler(bulkDataSources), summary: "Creates or updates multiple data sources", body: "json"},
+	{method: "GET", path: "/datasource/preset", handler: handler(dataSourcePresets), summary: "Lists the data source presets"},
+	{method: "POST", path: "/datasource/preset/{preset}", handler: handler(newDataSourceFromPreset), summary: "Adds a data source from a preset", body: "json", status: http.StatusCreated},
+	{method: "DELETE", path: "/datasource/{name}", handler: handler(removeDataSource), summary: "Removes a data source", successor: "/v2/datasources/{name}"},
+	{method: "GET", path: "/datasource/{name}", handler: handler(getDataSource), summary: "Gets a data source", successor: "/v2/datasources/{name}"},
+	{method: "GET", path: "/datasource/{name}/status", handler: handler(dataSourceStatus), summary: "Gets the status of the last data source requests"},
+	{method: "POST", path: "/datasource/{name}/test", handler: handler(testDataSource), summary: "Tests a data source request", body: "json"},
+	{method: "POST", path: "/datasource/{name}/rename", handler: handler(renameDataSource), summary: "Renames a data source", body: "json"},
+	{method: "GET", path: "/datasource/{name}/usage", handler: handler(dataSourceUsage), summary: "Lists the alarms using a data source"},
+	{method: "POST", path: "/datasource/{name}/push", handler: handler(pushDataSource), summary: "Pushes a sample to a push data source", body: "json"},
+	{method: "GET", path: "/action", handler: handler(allActions), summary: "Lists the actions", query: listQuery(actionList), successor: "/v2/actions"},
+	{method: "POST", path: "/action", handler: handler(newAction), summary: "Adds an action", body: "json", status: http.StatusCreated, successor: "/v2/actions"},
+	{method: "PUT", path: "/action", handler: handler(updateActions), summary: "Updates multiple actions", body: "json"},
+	{method: "POST", path: "/action/bulk", handler: handler(bulkActions), summary: "Creates or updates multiple actions", body: "json"},
+	{method: "GET", path: "/action/export", handler: handler(exportActions), summary: "Exports the actions", query: []string{"name"}},
+	{method: "POST", path: "/action/import", handler: handler(importActions), summary: "Imports actions", body: "json"},
+	{method: "POST", path: "/action/replace-host", handler: handler(replaceActionsHost), summary: "Replaces the host of the action urls", body: "json"},
+	{method: "GET", path: "/action/dead-letter", handler: handler(listDeadLetters), summary: "Lists the failed action executions", query: []string{"action", "limit"}},
+	{method: "GET", path: "/action/dead-letter/{id}", handler: handler(deadLetterInfo), summary: "Gets a failed action execution"},
+	{method: "DELETE", path: "/action/dead-letter/{id}", handler: handler(removeDeadLetter), summary: "Removes a failed action execution"},
+	{method: "POST", path: "/action/dead-letter/{id}/replay", handler: handler(replayDeadLetter), summary: "Replays a failed action execution"},
+	{method: "DELETE", path: "/action/{name}", handler: handler(removeAction), summary: "Removes an action", successor: "/v2/actions/{name}"},
+	{method: "GET", path: "/action/{name}", handler: handler(actionInfo), summary: "Gets an action", successor: "/v2/actions/{name}"},
+	{method: "PUT", path: "/action/{name}", handler: handler(updateAction), summary: "Updates an action", body: "json", successor: "/v2/actions/{name}"},
+	{method: "GET", path: "/action/{name}/executions", handler: handler(actionExecutions), summary: "Lists the executions of an action", query: []string{"alarm", "limit"}},
+	{method: "POST", path: "/action/{name}/test", handler: handler(testFireAction), summary: "Test fires an action with a sample event", body: "json"},
+	{method: "POST", path: "/alarm", handler: idempotent(handler(newAlarm)), summary: "Adds an alarm", body: "json", schema: schemas["alarm"], status: http.StatusCreated, successor: "/v2/alarms"},
+	{method: "POST", path: "/alarm/bulk", handler: handler(bulkAlarms), summary: "Creates or updates multiple alarms", body: "json"},
+	{method: "GET", path: "/alarm/instance/{instance}", handler: handler(listAlarmsByInstance), summary: "Lists the alarms of a service instance", query: listQuery(alarmList), successor: "/v2/alarms?instance={instance}"},
+	{method: "GET", path: "/alarm", handler: authorizationRequiredHandler(listAlarms), summary: "Lists the alarms of the token", query: listQuery(alarmList), successor: "/v2/alarms"},
+	{method: "PUT", path: "/alarm/{name}/enable", handler: handler(enableAlarm), summary: "Enables an alarm", successor: "/v2/alarms/{name}/enable"},
+	{method: "PUT", path: "/alarm/{name}/disable", handler: handler(disableAlarm), summary: "Disables an alarm", successor: "/v2/alarms/{name}/disable"},
+	{method: "DELETE", path: "/alarm/{name}", handler: handler(removeAlarm), summary: "Removes an alarm", successor: "/v2/alarms/{name}"},
+	{method: "GET", path: "/alarm/{name}", handler: handler(getAlarm), summary: "Gets an alarm", successor: "/v2/alarms/{name}"},
+	{method: "GET", path: "/alarm/{name}/state", handler: handler(alarmState), summary: "Gets the runtime state of an alarm"},
+	{method: "POST", path: "/alarm/{name}/trigger", handler: handler(triggerAlarm), summary: "Evaluates or fires an alarm immediately", body: "json"},
+	{method: "GET", path: "/alarm/{name}/event", handler: handler(listEvents), summary: "Lists the events of an alarm", query: listQuery(eventList), successor: "/v2/alarms/{name}/events"},
+	{method: "GET", path: "/event/export", handler: handler(exportEvents), summary: "Exports the events as newline delimited JSON or CSV", query: []string{"format", "since", "until", "instance", "alarm", "action", "successful", "outstanding"}, timeout: noTimeout},
+	{method: "POST", path: "/event/ack", handler: handler(acknowledgeEvents), summary: "Acknowledges failed events in bulk", body: "json"},
+	{method: "POST", path: "/event/{id}/ack", handler: handler(acknowledgeEvent), summary: "Acknowledges a failed event", body: "json"},
+	{method: "GET", path: "/events/watch", handler: handler(watchEvents), summary: "Waits for the events created after a cursor", query: []string{"after", "timeout", "instance", "alarm", "action", "successful"}, timeout: maxWatchTimeout + 10*time.Second},
+	{method: "GET", path: "/resources/plans", handler: handler(servicePlans), summary: "Lists the service plans, with the schemas of their parameters"},
+	{method: "POST", path: "/resources", handler: handler(serviceAdd), summary: "Adds a service instance", body: "form", status: http.StatusCreated},
+	{method: "POST", path: "/resources/{name}/bind", handler: http.HandlerFunc(serviceBindUnit), summary: "Binds a unit to a service instance", body: "form", status: http.StatusCreated},
+	{method: "POST", path: "/resources/{name}/bind-app", handler: handler(serviceBindApp), summary: "Binds an app to a service instance", body: "form", status: http.StatusCreated},
+	{method: "DELETE", path: "/resources/{name}/bind-app", handler: handler(serviceUnbindApp), summary: "Unbinds an app from a service instance", body: "form"},
+	{method: "DELETE", path: "/resources/{name}/bind", handler: http.HandlerFunc(serviceUnbindUnit), summary: "Unbinds a unit from a service instance", body: "form"},
+	{method: "DELETE", path: "/resources/{name}", handler: handler(serviceRemove), summary: "Removes a service instance"},
+	{method: "GET", path: "/service/instance/{name}", handler: handler(serviceInstanceByName), summary: "Gets a service instance"},
+	{method: "GET", path: "/service/instance/{name}/quota", handler: handler(serviceInstanceQuotas), summary: "Gets the usage of the quotas of a service instance and of its team"},
+	{method: "GET", path: "/service/instance", handler: authorizationRequiredHandler(serviceInstances), summary: "Lists the service instances of the token"},
+	{method: "POST", path: "/wizard/bulk", handler: handler(bulkWizards), summary: "Creates or updates multiple auto scales", body: "json"},
+	{method: "GET", path: "/wizard/{name}/events", handler: handler(eventsByWizardName), summary: "Lists the events of an auto scale", query: listQuery(eventList)},
+	{method: "GET", path: "/wizard/{name}", handler: handler(wizardByName), summary: "Gets an auto scale"},
+	{method: "DELETE", path: "/wizard/{name}", handler: handler(removeWizard), summary: "Removes an auto scale"},
+	{method: "PUT", path: "/wizard/{name}", handler: handler(wizardUpdate), summary: "Updates an auto scale", body: "json", schema: schemas["autoscale"].withoutRequired()},
+	{method: "PATCH", path: "/wizard/{name}", handler: handler(wizardPatch), summary: "Updates fields of an auto scale with a JSON merge patch", body: "json"},
+	{method: "POST", path: "/wizard/{name}/enable", handler: handler(wizardEnable), summary: "Enables an auto scale"},
+	{method: "POST", path: "/wizard/{name}/disable", handler: handler(wizardDisable), summary: "Disables an auto scale"},
+	{method: "POST", path: "/wizard/{name}/scale", handler: handler(wizardScale), summary: "Scales an auto scale instance manually", body: "json"},
+	{method: "POST", path: "/wizard", handler: idempotent(handler(newAutoScale)), summary: "Adds an auto scale", body: "json", schema: schemas["autoscale"], status: http.StatusCreated},
+	{method: "GET", path: "/wizard", handler: handler(listWizards), summary: "Lists the auto scales", query: listQuery(wizardList)},
+	{method: "GET", path: "/v2/datasources", handler: v2Handler(v2ListDataSources), summary: "Lists the data sources", query: listQuery(dataSourceList)},
+	{method: "POST", path: "/v2/datasources", handler: idempotent(v2Handler(v2NewDataSource)), summary: "Adds a data source", body: "json", status: http.StatusCreated},
+	{method: "GET", path: "/v2/datasources/{name}", handler: v2Handler(v2GetDataSource), summary: "Gets a data source"},
+	{method: "DELETE", path: "/v2/datasources/{name}", handler: v2Handler(v2RemoveDataSource), summary: "Removes a data source", status: http.StatusNoContent},
+	{method: "GET", path: "/v2/actions", handler: v2Handler(v2ListActions), summary: "Lists the actions", query: listQuery(actionList)},
+	{method: "POST", path: "/v2/actions", handler: v2Handler(v2NewAction), summary: "Adds an action", body: "json", status: http.StatusCreated},
+	{method: "GET", path: "/v2/actions/{name}", handler: v2Handler(v2GetAction), summary: "Gets an action"},
+	{method: "PUT", path: "/v2/actions/{name}", handler: v2Handler(v2UpdateAction), summary: "Updates an action", body: "json"},
+	{method: "DELETE", path: "/v2/actions/{name}", handler: v2Handler(v2RemoveAction), summary: "Removes an action", status: http.StatusNoContent},
+	{method: "GET", path: "/v2/alarms", handler: v2Handler(v2ListAlarms), summary: "Lists the alarms of a service instance, or of the token", query: listQuery(alarmList)},
+	{method: "POST", path: "/v2/alarms", handler: idempotent(v2Handler(v2NewAlarm)), summary: "Adds an alarm", body: "json", status: http.StatusCreated},
+	{method: "GET", path: "/v2/alarms/{name}", handler: v2Handler(v2GetAlarm), summary: "Gets an alarm"},
+	{method: "DELETE", path: "/v2/alarms/{name}", handler: v2Handler(v2RemoveAlarm), summary: "Removes an alarm", status: http.StatusNoContent},
+	{method: "POST", path: "/v2/alarms/{name}/enable", handler: v2SetAlarmEnabled(true), summary: "Enables an alarm"},
+	{method: "POST", path: "/v2/alarms/{name}/disable", handler: v2SetAlarmEnabled(false), summary: "Disables an alarm"},
+	{method: "GET", path: "/v2/alarms/{name}/events", handler: v2Handler(v2ListEvents), summary: "Lists the events of an alarm", query: listQuery(eventList)},
+}
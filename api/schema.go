@@ -0,0 +1,243 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/tsuru/tsuru-autoscale/datasource"
+)
+
+// schemaDialect is the JSON Schema version of the schemas.
+const schemaDialect = "http://json-schema.org/draft-04/schema#"
+
+// jsonSchema is the JSON Schema of a request body, with the subset of the
+// keywords validated by the api, which is also valid in the OpenAPI
+// specification.
+type jsonSchema struct {
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	MinLength            int                    `json:"minLength,omitempty"`
+	Minimum              *int                   `json:"minimum,omitempty"`
+}
+
+var zero = 0
+
+func stringSchema(description string) *jsonSchema {
+	return &jsonSchema{Type: "string", Description: description}
+}
+
+func stringsSchema(description string) *jsonSchema {
+	return &jsonSchema{Type: "array", Description: description, Items: &jsonSchema{Type: "string"}}
+}
+
+func stringMapSchema(description string) *jsonSchema {
+	return &jsonSchema{Type: "object", Description: description, AdditionalProperties: &jsonSchema{Type: "string"}}
+}
+
+var scaleActionSchema = &jsonSchema{
+	Type: "object",
+	Properties: map[string]*jsonSchema{
+		"aggregator": stringSchema("function aggregating the metric, max by default"),
+		"metric":     stringSchema("data source of the metric"),
+		"operator":   stringSchema("operator comparing the metric to the value"),
+		"value":      stringSchema("threshold of the metric"),
+		"step":       stringSchema("units added or removed"),
+		"wait":       {Type: "integer", Description: "seconds to wait after scaling", Minimum: &zero},
+		"vars":       stringMapSchema("variables of the data source requests"),
+	},
+}
+
+// schemas are the schemas of the request bodies, by name.
+var schemas = map[string]*jsonSchema{
+	"autoscale": {
+		Title: "AutoScale",
+		Type:  "object",
+		Properties: map[string]*jsonSchema{
+			"name":      {Type: "string", Description: "name of the service instance", MinLength: 1},
+			"scaleUp":   scaleActionSchema,
+			"scaleDown": scaleActionSchema,
+			"minUnits":  {Type: "integer", Description: "minimum number of units, 1 by default"},
+			"process":   stringSchema("process scaled, web by default"),
+		},
+		Required: []string{"name"},
+	},
+	"alarm": {
+		Title: "Alarm",
+		Type:  "object",
+		Properties: map[string]*jsonSchema{
+			"name":          {Type: "string", Description: "name of the alarm", MinLength: 1},
+			"actions":       stringsSchema("actions executed when the alarm fires"),
+			"expression":    stringSchema("JavaScript expression evaluated on the data sources"),
+			"enabled":       {Type: "boolean"},
+			"wait":          {Type: "integer", Description: "nanoseconds to wait after firing", Minimum: &zero},
+			"datasources":   stringsSchema("data sources of the expression"),
+			"instance":      stringSchema("service instance of the alarm"),
+			"envs":          stringMapSchema("variables of the data sources and actions"),
+			"notifications": stringsSchema("email addresses notified of the events"),
+			"conditions":    stringMapSchema("expressions of the action groups, by group"),
+			"pipeline":      {Type: "boolean", Description: "whether the actions are executed in order, each one with the result of the previous"},
+			"groups": {
+				Type:        "object",
+				Description: "action groups, by name",
+				AdditionalProperties: &jsonSchema{
+					Type: "object",
+					Properties: map[string]*jsonSchema{
+						"actions":     stringsSchema("actions of the group"),
+						"min_success": {Type: "integer", Description: "actions that must succeed", Minimum: &zero},
+					},
+				},
+			},
+			"state":       stringSchema("state of the alarm, read only"),
+			"stateReason": stringSchema("reason of the state of the alarm, read only"),
+		},
+		Required: []string{"name"},
+	},
+}
+
+// withoutRequired returns a copy of the schema without required
+// properties, for the bodies whose required fields are in the route.
+func (s *jsonSchema) withoutRequired() *jsonSchema {
+	copied := *s
+	copied.Required = nil
+	return &copied
+}
+
+// validate appends the errors of the value, decoded with numbers, at path
+// to errs. Nulls are accepted as missing values, except at the root.
+func (s *jsonSchema) validate(path string, value interface{}, errs []datasource.FieldError) []datasource.FieldError {
+	fail := func(format string, args ...interface{}) []datasource.FieldError {
+		field := path
+		if field == "" {
+			field = "body"
+		}
+		return append(errs, datasource.FieldError{Field: field, Message: field + " " + fmt.Sprintf(format, args...)})
+	}
+	if value == nil && path != "" {
+		return errs
+	}
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fail("must be an object")
+		}
+		for _, name := range s.Required {
+			if obj[name] == nil {
+				errs = append(errs, datasource.FieldError{Field: schemaPath(path, name), Message: schemaPath(path, name) + " is required"})
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if p, ok := s.Properties[key]; ok {
+				errs = p.validate(schemaPath(path, key), obj[key], errs)
+			} else if s.AdditionalProperties != nil {
+				errs = s.AdditionalProperties.validate(schemaPath(path, key), obj[key], errs)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fail("must be an array")
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				errs = s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fail("must be a string")
+		}
+		if len(str) < s.MinLength {
+			if len(str) == 0 {
+				return fail("must not be empty")
+			}
+			return fail("must have at least %d characters", s.MinLength)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fail("must be a boolean")
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		f, err := n.Float64()
+		if !ok || err != nil || f != math.Trunc(f) {
+			return fail("must be an integer")
+		}
+		if s.Minimum != nil && f < float64(*s.Minimum) {
+			return fail("must be at least %d", *s.Minimum)
+		}
+	}
+	return errs
+}
+
+func schemaPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// validateBody validates the json body against the schema, returning an
+// invalid error with all the invalid fields.
+func validateBody(s *jsonSchema, body []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return badRequest("invalid body: " + err.Error())
+	}
+	errs := s.validate("", value, nil)
+	if len(errs) == 0 {
+		return nil
+	}
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Message
+	}
+	return &apiError{Status: http.StatusBadRequest, Code: codeInvalid, Message: strings.Join(messages, "; "), Fields: errs}
+}
+
+func listSchemas(w http.ResponseWriter, r *http.Request) error {
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(names)
+}
+
+// getSchema writes the schema, with its dialect, so it can be used by
+// external validators.
+func getSchema(w http.ResponseWriter, r *http.Request) error {
+	name := routeVars(r)["name"]
+	s, ok := schemas[name]
+	if !ok {
+		return newError(http.StatusNotFound, codeNotFound, fmt.Sprintf("schema %q not found", name))
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	return json.NewEncoder(w).Encode(struct {
+		Schema string `json:"$schema"`
+		*jsonSchema
+	}{schemaDialect, s})
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru-autoscale/datasource"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestValidateBody(c *check.C) {
+	err := validateBody(schemas["alarm"], []byte(`{"name":"high","wait":60000000000,"envs":{"step":"1"},"actions":null,"unknown":1}`))
+	c.Assert(err, check.IsNil)
+	err = validateBody(schemas["alarm"], []byte(`{"wait":-1,"enabled":"yes","envs":{"step":1},"groups":{"g":{"actions":[1]}}}`))
+	c.Assert(err, check.FitsTypeOf, &apiError{})
+	e := err.(*apiError)
+	c.Assert(e.Status, check.Equals, http.StatusBadRequest)
+	c.Assert(e.Code, check.Equals, codeInvalid)
+	c.Assert(e.Fields, check.DeepEquals, []datasource.FieldError{
+		{Field: "name", Message: "name is required"},
+		{Field: "enabled", Message: "enabled must be a boolean"},
+		{Field: "envs.step", Message: "envs.step must be a string"},
+		{Field: "groups.g.actions[0]", Message: "groups.g.actions[0] must be a string"},
+		{Field: "wait", Message: "wait must be at least 0"},
+	})
+	c.Assert(e.Message, check.Equals, "name is required; enabled must be a boolean; envs.step must be a string; groups.g.actions[0] must be a string; wait must be at least 0")
+	err = validateBody(schemas["autoscale"], []byte(`{"name":"","scaleUp":{"wait":1.5}}`))
+	c.Assert(err, check.ErrorMatches, "name must not be empty; scaleUp.wait must be an integer")
+	err = validateBody(schemas["autoscale"].withoutRequired(), []byte(`{"minUnits":2}`))
+	c.Assert(err, check.IsNil)
+	c.Assert(schemas["autoscale"].Required, check.DeepEquals, []string{"name"})
+	err = validateBody(schemas["autoscale"], []byte(`[]`))
+	c.Assert(err, check.ErrorMatches, "body must be an object")
+	err = validateBody(schemas["autoscale"], []byte(`{`))
+	c.Assert(err, check.ErrorMatches, "invalid body: .*")
+}
+
+func (s *S) TestGetSchema(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/schema/autoscale", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/schema+json")
+	var schema struct {
+		Schema     string `json:"$schema"`
+		Title      string
+		Properties map[string]jsonSchema
+		Required   []string
+	}
+	err = json.NewDecoder(recorder.Body).Decode(&schema)
+	c.Assert(err, check.IsNil)
+	c.Assert(schema.Schema, check.Equals, schemaDialect)
+	c.Assert(schema.Title, check.Equals, "AutoScale")
+	c.Assert(schema.Required, check.DeepEquals, []string{"name"})
+	c.Assert(schema.Properties["scaleUp"].Properties["wait"].Type, check.Equals, "integer")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/schema/unknown", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/schema", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(strings.TrimSpace(recorder.Body.String()), check.Equals, `["alarm","autoscale"]`)
+}
+
+func (s *S) TestNewAlarmInvalidBody(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/alarm", strings.NewReader(`{"name":"high","wait":"1m"}`))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, `{"error":{"code":"invalid","message":"wait must be an integer","fields":[{"field":"wait","message":"wait must be an integer"}]}}`+"\n")
+}
@@ -0,0 +1,217 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// defaultSearchLimit is the maximum number of results of the searches that
+// do not set one.
+const defaultSearchLimit = 100
+
+// searchResult is a resource matching a search: its type, name, service
+// instance and the fields matching the search.
+type searchResult struct {
+	Type     string   `json:"type"`
+	Name     string   `json:"name"`
+	Instance string   `json:"instance,omitempty"`
+	Matches  []string `json:"matches"`
+}
+
+// searchField is a field of the resources matched by the searches: the
+// field of the documents, which may be nested, and its name in the results.
+type searchField struct {
+	stored string
+	name   string
+}
+
+// searchType is a type of resource searched: its collection, the fields
+// matched by the searches and the field with its instance.
+type searchType struct {
+	name       string
+	collection func(db.Storage) db.Repository
+	fields     []searchField
+	instance   string
+}
+
+// searchTypes are the resources searched, in the order of the results.
+// Secrets, like urls, headers and tokens, are not searched.
+var searchTypes = []searchType{
+	{
+		name:       "wizard",
+		collection: db.Storage.Wizards,
+		fields: []searchField{
+			{"name", "name"},
+			{"process", "process"},
+			{"scaleup.metric", "scaleUp.metric"},
+			{"scaledown.metric", "scaleDown.metric"},
+			{"scaleup.vars", "scaleUp.vars"},
+			{"scaledown.vars", "scaleDown.vars"},
+		},
+		instance: "name",
+	},
+	{
+		name:       "alarm",
+		collection: db.Storage.Alarms,
+		fields: []searchField{
+			{"name", "name"},
+			{"instance", "instance"},
+			{"expression", "expression"},
+			{"datasources", "datasources"},
+			{"actions", "actions"},
+			{"notifications", "notifications"},
+			{"conditions", "conditions"},
+			{"envs", "envs"},
+		},
+		instance: "instance",
+	},
+	{
+		name:       "datasource",
+		collection: db.Storage.DataSources,
+		fields: []searchField{
+			{"name", "name"},
+			{"type", "type"},
+			{"expressiontemplate", "expressionTemplate"},
+			{"sources", "sources"},
+		},
+	},
+	{
+		name:       "action",
+		collection: db.Storage.Actions,
+		fields: []searchField{
+			{"name", "name"},
+			{"type", "type"},
+			{"rollback", "rollback"},
+		},
+	},
+}
+
+// fieldValue returns the value of the field of the document, following the
+// dots of nested fields.
+func fieldValue(doc bson.M, field string) interface{} {
+	var value interface{} = doc
+	for _, key := range strings.Split(field, ".") {
+		m, ok := value.(bson.M)
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+// matchValue returns whether the value, or any of its elements, is a
+// string matching re.
+func matchValue(value interface{}, re *regexp.Regexp) bool {
+	switch v := value.(type) {
+	case string:
+		return re.MatchString(v)
+	case []interface{}:
+		for _, item := range v {
+			if matchValue(item, re) {
+				return true
+			}
+		}
+	case bson.M:
+		for _, item := range v {
+			if matchValue(item, re) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// search returns the resources of the type with fields matching re, sorted
+// by name.
+func (t *searchType) search(conn db.Storage, re *regexp.Regexp) ([]searchResult, error) {
+	selector := bson.M{"name": 1}
+	for _, f := range t.fields {
+		selector[f.stored] = 1
+	}
+	var docs []bson.M
+	if err := t.collection(conn).Find(nil).Select(selector).Sort("name").All(&docs); err != nil {
+		return nil, err
+	}
+	results := []searchResult{}
+	for _, doc := range docs {
+		var matches []string
+		for _, f := range t.fields {
+			if matchValue(fieldValue(doc, f.stored), re) {
+				matches = append(matches, f.name)
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		r := searchResult{Type: t.name, Matches: matches}
+		r.Name, _ = doc["name"].(string)
+		if t.instance != "" {
+			r.Instance, _ = doc[t.instance].(string)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// searchResources finds the wizards, alarms, data sources and actions with
+// names, instances, expressions or references containing q, ignoring the
+// case, so operators can find everything referencing a data source or an
+// action. The results can be restricted to a type and are limited to
+// limit, the total of matches is sent in the X-Total-Count header.
+func searchResources(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		return badRequest("q is required")
+	}
+	limit := defaultSearchLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v <= 0 || v > maxListLimit {
+			return badRequest(fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+		}
+		limit = v
+	}
+	typ := r.URL.Query().Get("type")
+	valid := typ == ""
+	var types []string
+	for _, t := range searchTypes {
+		types = append(types, t.name)
+		valid = valid || t.name == typ
+	}
+	if !valid {
+		return badRequest(fmt.Sprintf("invalid type %q, must be one of %s", typ, strings.Join(types, ", ")))
+	}
+	re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(q))
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	results := []searchResult{}
+	for i := range searchTypes {
+		if typ != "" && searchTypes[i].name != typ {
+			continue
+		}
+		found, err := searchTypes[i].search(conn, re)
+		if err != nil {
+			return err
+		}
+		results = append(results, found...)
+	}
+	total := len(results)
+	if total > limit {
+		results = results[:limit]
+	}
+	return writeList(w, total, results)
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/datasource"
+	"github.com/tsuru/tsuru-autoscale/wizard"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestMatchValue(c *check.C) {
+	re := regexp.MustCompile("(?i)" + regexp.QuoteMeta("CPU.value"))
+	doc := bson.M{
+		"name":        "scale_up",
+		"expression":  "cpu.value > 80",
+		"datasources": []interface{}{"mem", "cpu"},
+		"scaleup":     bson.M{"metric": "cpu.value"},
+		"envs":        bson.M{"step": "1"},
+		"wait":        300,
+	}
+	c.Assert(matchValue(fieldValue(doc, "name"), re), check.Equals, false)
+	c.Assert(matchValue(fieldValue(doc, "expression"), re), check.Equals, true)
+	c.Assert(matchValue(fieldValue(doc, "scaleup.metric"), re), check.Equals, true)
+	c.Assert(matchValue(fieldValue(doc, "envs"), re), check.Equals, false)
+	c.Assert(matchValue(fieldValue(doc, "wait"), re), check.Equals, false)
+	c.Assert(fieldValue(doc, "name.metric"), check.IsNil)
+	re = regexp.MustCompile("(?i)" + regexp.QuoteMeta("cpu"))
+	c.Assert(matchValue(fieldValue(doc, "datasources"), re), check.Equals, true)
+}
+
+func (s *S) TestSearch(c *check.C) {
+	err := datasource.New(&datasource.DataSource{Name: "cpu", URL: "http://cpu.tsuru.io", Method: "GET"})
+	c.Assert(err, check.IsNil)
+	err = datasource.New(&datasource.DataSource{Name: "all", Type: datasource.TypeAggregate, Sources: map[string]string{"c": "cpu"}})
+	c.Assert(err, check.IsNil)
+	err = action.New(&action.Action{Name: "scale_up", URL: "http://cpu.tsuru.io", Method: "POST"})
+	c.Assert(err, check.IsNil)
+	err = alarm.NewAlarm(&alarm.Alarm{Name: "high", Instance: "myinstance", Expression: "cpu.value > 80", DataSources: []string{"cpu"}, Actions: []string{"scale_up"}})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Wizards().Insert(&wizard.AutoScale{Name: "myinstance", ScaleUp: wizard.ScaleAction{Metric: "cpu"}})
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/search?q=CPU", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("X-Total-Count"), check.Equals, "4")
+	var results []searchResult
+	err = json.NewDecoder(recorder.Body).Decode(&results)
+	c.Assert(err, check.IsNil)
+	expected := []searchResult{
+		{Type: "wizard", Name: "myinstance", Instance: "myinstance", Matches: []string{"scaleUp.metric"}},
+		{Type: "alarm", Name: "high", Instance: "myinstance", Matches: []string{"expression", "datasources"}},
+		{Type: "datasource", Name: "all", Matches: []string{"sources"}},
+		{Type: "datasource", Name: "cpu", Matches: []string{"name"}},
+	}
+	c.Assert(results, check.DeepEquals, expected)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/search?q=scale&type=action&limit=1", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	results = nil
+	err = json.NewDecoder(recorder.Body).Decode(&results)
+	c.Assert(err, check.IsNil)
+	c.Assert(results, check.DeepEquals, []searchResult{{Type: "action", Name: "scale_up", Matches: []string{"name"}}})
+}
+
+func (s *S) TestSearchInvalid(c *check.C) {
+	for _, query := range []string{"", "?q=cpu&type=event", "?q=cpu&limit=0"} {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", "/search"+query, nil)
+		c.Assert(err, check.IsNil)
+		server(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest, check.Commentf(query))
+	}
+}
@@ -8,8 +8,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
-	"github.com/gorilla/mux"
 	"github.com/tsuru/tsuru-autoscale/tsuru"
 	"github.com/tsuru/tsuru-autoscale/wizard"
 )
@@ -19,6 +19,18 @@ func serviceAdd(w http.ResponseWriter, r *http.Request) error {
 		Name: r.FormValue("name"),
 		Team: r.FormValue("team"),
 		User: r.FormValue("user"),
+		Pool: r.FormValue("pool"),
+	}
+	// tsuru sends the parameters of the instance as parameters.<name>.
+	r.ParseForm()
+	params := map[string]string{}
+	for key := range r.PostForm {
+		if strings.HasPrefix(key, "parameters.") {
+			params[strings.TrimPrefix(key, "parameters.")] = r.PostForm.Get(key)
+		}
+	}
+	if err := i.SetPlan(r.FormValue("plan"), params); err != nil {
+		return badRequest(err.Error())
 	}
 	err := tsuru.NewInstance(&i)
 	if err != nil {
@@ -28,12 +40,17 @@ func serviceAdd(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+func servicePlans(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(tsuru.Plans())
+}
+
 func serviceBindUnit(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
 func serviceBindApp(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	i, err := tsuru.GetInstanceByName(vars["name"])
 	if err != nil {
 		return err
@@ -51,7 +68,7 @@ func serviceUnbindUnit(w http.ResponseWriter, r *http.Request) {
 }
 
 func serviceUnbindApp(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	i, err := tsuru.GetInstanceByName(vars["name"])
 	if err != nil {
 		return err
@@ -63,6 +80,7 @@ func serviceUnbindApp(w http.ResponseWriter, r *http.Request) error {
 	}
 	autoScale, err := wizard.FindByName(vars["name"])
 	if err == nil {
+		autoScale.SetLogger(requestLogger(r))
 		rerr := wizard.Remove(autoScale)
 		if rerr != nil {
 			return rerr
@@ -72,7 +90,7 @@ func serviceUnbindApp(w http.ResponseWriter, r *http.Request) error {
 }
 
 func serviceRemove(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	i, err := tsuru.GetInstanceByName(vars["name"])
 	if err != nil {
 		return nil
@@ -91,7 +109,7 @@ func serviceInstances(w http.ResponseWriter, r *http.Request) error {
 }
 
 func serviceInstanceByName(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	instance, err := tsuru.GetInstanceByName(vars["name"])
 	if err != nil {
 		return err
@@ -99,3 +117,19 @@ func serviceInstanceByName(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Content-Type", "application/json")
 	return json.NewEncoder(w).Encode(&instance)
 }
+
+// serviceInstanceQuotas reports the usage of the alarms and auto scales of
+// the instance and of its team versus their quotas, zero meaning
+// unlimited.
+func serviceInstanceQuotas(w http.ResponseWriter, r *http.Request) error {
+	instance, err := tsuru.GetInstanceByName(routeVars(r)["name"])
+	if err != nil {
+		return err
+	}
+	quotas, err := tsuru.Quotas(instance.Name)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(quotas)
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/tsuru/tsuru-autoscale/alarm"
 	"github.com/tsuru/tsuru-autoscale/tsuru"
 	"github.com/tsuru/tsuru-autoscale/wizard"
 	"gopkg.in/check.v1"
@@ -26,6 +27,63 @@ func (s *S) TestServiceAdd(c *check.C) {
 	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
 }
 
+func (s *S) TestServiceAddWithPool(c *check.C) {
+	recorder := httptest.NewRecorder()
+	body := `name=myscale3&team=admin&user=admin%40example.com&pool=mypool`
+	request, err := http.NewRequest("POST", "/resources", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	request.Header.Add("Authorization", "token")
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	i, err := tsuru.GetInstanceByName("myscale3")
+	c.Assert(err, check.IsNil)
+	c.Assert(i.Pool, check.Equals, "mypool")
+}
+
+func (s *S) TestServiceAddWithPlan(c *check.C) {
+	recorder := httptest.NewRecorder()
+	body := `name=myscale4&team=admin&user=admin%40example.com&plan=basic&parameters.maxUnits=10`
+	request, err := http.NewRequest("POST", "/resources", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	request.Header.Add("Authorization", "token")
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	i, err := tsuru.GetInstanceByName("myscale4")
+	c.Assert(err, check.IsNil)
+	c.Assert(i.Plan, check.Equals, "basic")
+	c.Assert(i.Params, check.DeepEquals, map[string]string{"step": "1", "minUnits": "1", "maxUnits": "10"})
+}
+
+func (s *S) TestServiceAddInvalidParameter(c *check.C) {
+	recorder := httptest.NewRecorder()
+	body := `name=myscale5&team=admin&user=admin%40example.com&plan=basic&parameters.cooldown=10`
+	request, err := http.NewRequest("POST", "/resources", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	request.Header.Add("Authorization", "token")
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*invalid parameter .*cooldown.* for plan .*basic.*`)
+	_, err = tsuru.GetInstanceByName("myscale5")
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestServicePlans(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/resources/plans", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Add("Authorization", "token")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.HeaderMap["Content-Type"], check.DeepEquals, []string{"application/json"})
+	var plans []tsuru.Plan
+	err = json.NewDecoder(recorder.Body).Decode(&plans)
+	c.Assert(err, check.IsNil)
+	c.Assert(plans, check.DeepEquals, tsuru.Plans())
+}
+
 func (s *S) TestServiceBindUnit(c *check.C) {
 	recorder := httptest.NewRecorder()
 	request, err := http.NewRequest("POST", "/resources/name/bind", nil)
@@ -230,3 +288,36 @@ func (s *S) TestServiceInstanceByName(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(instance.Name, check.Equals, "instance")
 }
+
+func (s *S) TestServiceInstanceQuotas(c *check.C) {
+	os.Setenv("AUTOSCALE_QUOTA_ALARMS_PER_INSTANCE", "5")
+	defer os.Unsetenv("AUTOSCALE_QUOTA_ALARMS_PER_INSTANCE")
+	err := tsuru.NewInstance(&tsuru.Instance{Name: "myinstance", Team: "myteam"})
+	c.Assert(err, check.IsNil)
+	err = alarm.NewAlarm(&alarm.Alarm{Name: "high", Instance: "myinstance"})
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/service/instance/myinstance/quota", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var quotas []tsuru.Quota
+	err = json.NewDecoder(recorder.Body).Decode(&quotas)
+	c.Assert(err, check.IsNil)
+	c.Assert(quotas, check.HasLen, 3)
+	c.Assert(quotas[0], check.DeepEquals, tsuru.Quota{Resource: "alarms", Scope: "instance", Name: "myinstance", Limit: 5, Usage: 1})
+}
+
+func (s *S) TestNewAlarmQuotaExceeded(c *check.C) {
+	os.Setenv("AUTOSCALE_QUOTA_ALARMS_PER_INSTANCE", "1")
+	defer os.Unsetenv("AUTOSCALE_QUOTA_ALARMS_PER_INSTANCE")
+	err := alarm.NewAlarm(&alarm.Alarm{Name: "high", Instance: "myinstance"})
+	c.Assert(err, check.IsNil)
+	body := `{"name":"low","instance":"myinstance","expression":"true","datasources":[],"actions":[]}`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/alarm", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*"code":"quota_exceeded".*`)
+}
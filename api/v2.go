@@ -0,0 +1,293 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/datasource"
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// v2Handler is a handler of the v2 api. The responses are wrapped in an
+// envelope, with the resources in data and the list pages in meta, and
+// the errors are returned in the error envelope.
+type v2Handler func(w http.ResponseWriter, r *http.Request) error
+
+// v2Envelope is the response of the v2 api.
+type v2Envelope struct {
+	Data interface{} `json:"data"`
+	Meta *v2Meta     `json:"meta,omitempty"`
+}
+
+// v2Meta describes a page of a list.
+type v2Meta struct {
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+func (fn v2Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := fn(w, r); err != nil {
+		requestLogger(r).Error(err)
+		writeError(w, err)
+	}
+}
+
+func writeV2(w http.ResponseWriter, status int, body interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(body)
+}
+
+// writeV2Page writes a page of a list, with the options of the page.
+func writeV2Page(w http.ResponseWriter, data interface{}, total int, opts *db.ListOptions) error {
+	return writeV2(w, http.StatusOK, v2Envelope{Data: data, Meta: &v2Meta{Total: total, Limit: opts.Limit, Offset: opts.Offset}})
+}
+
+// decodeV2 decodes the request body into v, answering malformed bodies as
+// bad requests.
+func decodeV2(r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return badRequest("invalid body: " + err.Error())
+	}
+	return nil
+}
+
+// v2ListOptions parses the list query parameters, answering bad requests
+// with the error envelope.
+func v2ListOptions(spec listSpec, r *http.Request) (*db.ListOptions, error) {
+	opts, err := spec.options(r)
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+	return opts, nil
+}
+
+func v2ListDataSources(w http.ResponseWriter, r *http.Request) error {
+	opts, err := v2ListOptions(dataSourceList, r)
+	if err != nil {
+		return err
+	}
+	sources, total, err := datasource.List(nil, opts)
+	if err != nil {
+		return err
+	}
+	data := make([]*dataSourceV2, len(sources))
+	for i := range sources {
+		data[i] = newDataSourceV2(sources[i].Redacted())
+	}
+	return writeV2Page(w, data, total, opts)
+}
+
+func v2NewDataSource(w http.ResponseWriter, r *http.Request) error {
+	var v dataSourceV2
+	if err := decodeV2(r, &v); err != nil {
+		return err
+	}
+	ds := v.model()
+	if err := datasource.New(ds); err != nil {
+		return err
+	}
+	return writeV2(w, http.StatusCreated, v2Envelope{Data: newDataSourceV2(ds.Redacted())})
+}
+
+func v2GetDataSource(w http.ResponseWriter, r *http.Request) error {
+	ds, err := datasource.Get(routeVars(r)["name"])
+	if err != nil {
+		return err
+	}
+	return writeV2(w, http.StatusOK, v2Envelope{Data: newDataSourceV2(ds.Redacted())})
+}
+
+func v2RemoveDataSource(w http.ResponseWriter, r *http.Request) error {
+	ds, err := datasource.Get(routeVars(r)["name"])
+	if err != nil {
+		return err
+	}
+	if err = datasource.Remove(ds); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func v2ListActions(w http.ResponseWriter, r *http.Request) error {
+	opts, err := v2ListOptions(actionList, r)
+	if err != nil {
+		return err
+	}
+	actions, total, err := action.List(opts)
+	if err != nil {
+		return err
+	}
+	data := make([]*actionV2, len(actions))
+	for i := range actions {
+		data[i] = newActionV2(actions[i].Redacted())
+	}
+	return writeV2Page(w, data, total, opts)
+}
+
+func v2NewAction(w http.ResponseWriter, r *http.Request) error {
+	var v actionV2
+	if err := decodeV2(r, &v); err != nil {
+		return err
+	}
+	a := v.model()
+	if err := action.New(a); err != nil {
+		return err
+	}
+	return writeV2(w, http.StatusCreated, v2Envelope{Data: newActionV2(a.Redacted())})
+}
+
+func v2GetAction(w http.ResponseWriter, r *http.Request) error {
+	a, err := action.FindByName(routeVars(r)["name"])
+	if err != nil {
+		return err
+	}
+	return writeV2(w, http.StatusOK, v2Envelope{Data: newActionV2(a.Redacted())})
+}
+
+func v2UpdateAction(w http.ResponseWriter, r *http.Request) error {
+	var v actionV2
+	if err := decodeV2(r, &v); err != nil {
+		return err
+	}
+	v.Name = routeVars(r)["name"]
+	if err := action.Update(v.model()); err != nil {
+		return err
+	}
+	a, err := action.FindByName(v.Name)
+	if err != nil {
+		return err
+	}
+	return writeV2(w, http.StatusOK, v2Envelope{Data: newActionV2(a.Redacted())})
+}
+
+func v2RemoveAction(w http.ResponseWriter, r *http.Request) error {
+	a, err := action.FindByName(routeVars(r)["name"])
+	if err != nil {
+		return err
+	}
+	if err = action.Remove(a); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func v2ListAlarms(w http.ResponseWriter, r *http.Request) error {
+	opts, err := v2ListOptions(alarmList, r)
+	if err != nil {
+		return err
+	}
+	var alarms []alarm.Alarm
+	var total int
+	if instance := r.URL.Query().Get("instance"); instance != "" {
+		alarms, total, err = alarm.ListAlarmsByInstance(instance, opts)
+	} else {
+		token := r.Header.Get("Authorization")
+		if token == "" {
+			return newError(http.StatusUnauthorized, codeUnauthorized, "Authorization header or instance is required.")
+		}
+		alarms, total, err = alarm.ListAlarmsByToken(token, opts)
+	}
+	if err != nil {
+		return err
+	}
+	data := make([]*alarmV2, len(alarms))
+	for i := range alarms {
+		data[i] = newAlarmV2(&alarms[i])
+	}
+	return writeV2Page(w, data, total, opts)
+}
+
+func v2NewAlarm(w http.ResponseWriter, r *http.Request) error {
+	var v alarmV2
+	if err := decodeV2(r, &v); err != nil {
+		return err
+	}
+	if v.Name == "" {
+		return badRequest("name is required")
+	}
+	if v.WaitSeconds < 0 {
+		return badRequest("wait_seconds must not be negative")
+	}
+	a := v.model()
+	if err := alarm.NewAlarm(a); err != nil {
+		return err
+	}
+	return writeV2(w, http.StatusCreated, v2Envelope{Data: newAlarmV2(a)})
+}
+
+func v2GetAlarm(w http.ResponseWriter, r *http.Request) error {
+	a, err := alarm.FindAlarmByName(routeVars(r)["name"])
+	if err != nil {
+		return err
+	}
+	return writeV2(w, http.StatusOK, v2Envelope{Data: newAlarmV2(a)})
+}
+
+func v2RemoveAlarm(w http.ResponseWriter, r *http.Request) error {
+	a, err := alarm.FindAlarmByName(routeVars(r)["name"])
+	if err != nil {
+		return err
+	}
+	if err = alarm.RemoveAlarm(a); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// v2SetAlarmEnabled returns the handler enabling or disabling an alarm.
+func v2SetAlarmEnabled(enabled bool) v2Handler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		a, err := alarm.FindAlarmByName(routeVars(r)["name"])
+		if err != nil {
+			return err
+		}
+		if enabled {
+			err = alarm.Enable(a)
+		} else {
+			err = alarm.Disable(a)
+		}
+		if err != nil {
+			return err
+		}
+		a.Enabled = enabled
+		return writeV2(w, http.StatusOK, v2Envelope{Data: newAlarmV2(a)})
+	}
+}
+
+func v2ListEvents(w http.ResponseWriter, r *http.Request) error {
+	a, err := alarm.FindAlarmByName(routeVars(r)["name"])
+	if err != nil {
+		return err
+	}
+	opts, err := v2ListOptions(eventList, r)
+	if err != nil {
+		return err
+	}
+	events, total, err := alarm.FindEvents(bson.M{"alarm.name": a.Name}, opts)
+	if err != nil {
+		return err
+	}
+	data := make([]*eventV2, len(events))
+	for i := range events {
+		data[i] = newEventV2(&events[i])
+	}
+	return writeV2Page(w, data, total, opts)
+}
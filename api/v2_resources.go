@@ -0,0 +1,461 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/datasource"
+	"github.com/tsuru/tsuru-autoscale/httpclient"
+)
+
+// The v2 representations are the api contract of the resources, decoupled
+// from the stored structs: snake case fields, durations in seconds or
+// milliseconds, as their names say, UTC timestamps and lower case enums.
+
+type tlsV2 struct {
+	CACert             string `json:"ca_cert,omitempty"`
+	Cert               string `json:"cert,omitempty"`
+	Key                string `json:"key,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+func newTLSV2(t *httpclient.TLS) *tlsV2 {
+	if t == nil {
+		return nil
+	}
+	return &tlsV2{CACert: t.CACert, Cert: t.Cert, Key: t.Key, InsecureSkipVerify: t.InsecureSkipVerify}
+}
+
+func (t *tlsV2) model() *httpclient.TLS {
+	if t == nil {
+		return nil
+	}
+	return &httpclient.TLS{CACert: t.CACert, Cert: t.Cert, Key: t.Key, InsecureSkipVerify: t.InsecureSkipVerify}
+}
+
+type oauth2V2 struct {
+	TokenURL     string   `json:"token_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
+type dataSourceV2 struct {
+	Name                 string                    `json:"name"`
+	Type                 string                    `json:"type"`
+	URL                  string                    `json:"url,omitempty"`
+	Method               string                    `json:"method,omitempty"`
+	Body                 string                    `json:"body,omitempty"`
+	Headers              map[string]string         `json:"headers,omitempty"`
+	Public               bool                      `json:"public"`
+	ExpressionTemplate   string                    `json:"expression_template,omitempty"`
+	OAuth2               *oauth2V2                 `json:"oauth2,omitempty"`
+	TLS                  *tlsV2                    `json:"tls,omitempty"`
+	TimeoutSeconds       int                       `json:"timeout_seconds,omitempty"`
+	Retries              int                       `json:"retries,omitempty"`
+	RetryIntervalSeconds int                       `json:"retry_interval_seconds,omitempty"`
+	FailoverURLs         []string                  `json:"failover_urls,omitempty"`
+	Extract              string                    `json:"extract,omitempty"`
+	MaxResponseSize      int64                     `json:"max_response_size,omitempty"`
+	Pagination           *datasource.Pagination    `json:"pagination,omitempty"`
+	RangeSeconds         int                       `json:"range_seconds,omitempty"`
+	Elasticsearch        *datasource.Elasticsearch `json:"elasticsearch,omitempty"`
+	ResponseSchema       map[string]string         `json:"response_schema,omitempty"`
+	Sources              map[string]string         `json:"sources,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Column               string                    `json:"column,omitempty"`
+	GRPC                 *datasource.GRPC          `json:"grpc,omitempty"`
+	ProxyURL             string                    `json:"proxy_url,omitempty"`
+	Username             string                    `json:"username,omitempty"`
+	Password             string                    `json:"password,omitempty"`
+	MaxSamples           int                       `json:"max_samples,omitempty"`
+	Transform            string                    `json:"transform,omitempty"`
+}
+
+func newDataSourceV2(ds *datasource.DataSource) *dataSourceV2 {
+	v := dataSourceV2{
+		Name:                 ds.Name,
+		Type:                 ds.Type,
+		URL:                  ds.URL,
+		Method:               ds.Method,
+		Body:                 ds.Body,
+		Headers:              ds.Headers,
+		Public:               ds.Public,
+		ExpressionTemplate:   ds.ExpressionTemplate,
+		TLS:                  newTLSV2(ds.TLS),
+		TimeoutSeconds:       ds.Timeout,
+		Retries:              ds.Retries,
+		RetryIntervalSeconds: ds.RetryInterval,
+		FailoverURLs:         ds.FailoverURLs,
+		Extract:              ds.Extract,
+		MaxResponseSize:      ds.MaxResponseSize,
+		Pagination:           ds.Pagination,
+		RangeSeconds:         ds.Range,
+		Elasticsearch:        ds.Elasticsearch,
+		ResponseSchema:       ds.ResponseSchema,
+		Sources:              ds.Sources,
+		Format:               ds.Format,
+		Column:               ds.Column,
+		GRPC:                 ds.GRPC,
+		ProxyURL:             ds.ProxyURL,
+		Username:             ds.Username,
+		Password:             ds.Password,
+		MaxSamples:           ds.MaxSamples,
+		Transform:            ds.Transform,
+	}
+	if v.Type == "" {
+		v.Type = datasource.TypeHTTP
+	}
+	if ds.OAuth2 != nil {
+		v.OAuth2 = &oauth2V2{TokenURL: ds.OAuth2.TokenURL, ClientID: ds.OAuth2.ClientID, ClientSecret: ds.OAuth2.ClientSecret, Scopes: ds.OAuth2.Scopes}
+	}
+	return &v
+}
+
+func (v *dataSourceV2) model() *datasource.DataSource {
+	ds := datasource.DataSource{
+		Name:               v.Name,
+		Type:               v.Type,
+		URL:                v.URL,
+		Method:             v.Method,
+		Body:               v.Body,
+		Headers:            v.Headers,
+		Public:             v.Public,
+		ExpressionTemplate: v.ExpressionTemplate,
+		TLS:                v.TLS.model(),
+		Timeout:            v.TimeoutSeconds,
+		Retries:            v.Retries,
+		RetryInterval:      v.RetryIntervalSeconds,
+		FailoverURLs:       v.FailoverURLs,
+		Extract:            v.Extract,
+		MaxResponseSize:    v.MaxResponseSize,
+		Pagination:         v.Pagination,
+		Range:              v.RangeSeconds,
+		Elasticsearch:      v.Elasticsearch,
+		ResponseSchema:     v.ResponseSchema,
+		Sources:            v.Sources,
+		Format:             v.Format,
+		Column:             v.Column,
+		GRPC:               v.GRPC,
+		ProxyURL:           v.ProxyURL,
+		Username:           v.Username,
+		Password:           v.Password,
+		MaxSamples:         v.MaxSamples,
+		Transform:          v.Transform,
+	}
+	if ds.Type == datasource.TypeHTTP {
+		ds.Type = ""
+	}
+	if v.OAuth2 != nil {
+		ds.OAuth2 = &datasource.OAuth2{TokenURL: v.OAuth2.TokenURL, ClientID: v.OAuth2.ClientID, ClientSecret: v.OAuth2.ClientSecret, Scopes: v.OAuth2.Scopes}
+	}
+	return &ds
+}
+
+type actionV2 struct {
+	Name                 string            `json:"name"`
+	Type                 string            `json:"type"`
+	URL                  string            `json:"url,omitempty"`
+	Method               string            `json:"method,omitempty"`
+	Body                 string            `json:"body,omitempty"`
+	Headers              map[string]string `json:"headers,omitempty"`
+	TLS                  *tlsV2            `json:"tls,omitempty"`
+	Retries              int               `json:"retries,omitempty"`
+	RetryIntervalSeconds int               `json:"retry_interval_seconds,omitempty"`
+	Secret               string            `json:"secret,omitempty"`
+	Message              string            `json:"message,omitempty"`
+	To                   []string          `json:"to,omitempty"`
+	Subject              string            `json:"subject,omitempty"`
+	RoutingKey           string            `json:"routing_key,omitempty"`
+	Incident             string            `json:"incident,omitempty"`
+	Severity             string            `json:"severity,omitempty"`
+	Scale                string            `json:"scale,omitempty"`
+	Units                string            `json:"units,omitempty"`
+	Process              string            `json:"process,omitempty"`
+	Token                string            `json:"token,omitempty"`
+	DryRun               bool              `json:"dry_run"`
+	TimeoutSeconds       int               `json:"timeout_seconds,omitempty"`
+	ExpectedStatus       []int             `json:"expected_status,omitempty"`
+	ResponseExpression   string            `json:"response_expression,omitempty"`
+	Concurrency          int               `json:"concurrency,omitempty"`
+	ConcurrencyPolicy    string            `json:"concurrency_policy,omitempty"`
+	Rollback             string            `json:"rollback,omitempty"`
+	Command              string            `json:"command,omitempty"`
+	Once                 bool              `json:"once"`
+}
+
+func newActionV2(a *action.Action) *actionV2 {
+	v := actionV2{
+		Name:                 a.Name,
+		Type:                 a.Type,
+		URL:                  a.URL,
+		Method:               a.Method,
+		Body:                 a.Body,
+		Headers:              a.Headers,
+		TLS:                  newTLSV2(a.TLS),
+		Retries:              a.Retries,
+		RetryIntervalSeconds: a.RetryInterval,
+		Secret:               a.Secret,
+		Message:              a.Message,
+		To:                   a.To,
+		Subject:              a.Subject,
+		RoutingKey:           a.RoutingKey,
+		Incident:             a.Incident,
+		Severity:             a.Severity,
+		Scale:                a.Scale,
+		Units:                a.Units,
+		Process:              a.Process,
+		Token:                a.Token,
+		DryRun:               a.DryRun,
+		TimeoutSeconds:       a.Timeout,
+		ExpectedStatus:       a.ExpectedStatus,
+		ResponseExpression:   a.ResponseExpression,
+		Concurrency:          a.Concurrency,
+		ConcurrencyPolicy:    a.ConcurrencyPolicy,
+		Rollback:             a.Rollback,
+		Command:              a.Command,
+		Once:                 a.Once,
+	}
+	if v.Type == "" {
+		v.Type = action.TypeHTTP
+	}
+	return &v
+}
+
+func (v *actionV2) model() *action.Action {
+	a := action.Action{
+		Name:               v.Name,
+		Type:               v.Type,
+		URL:                v.URL,
+		Method:             v.Method,
+		Body:               v.Body,
+		Headers:            v.Headers,
+		TLS:                v.TLS.model(),
+		Retries:            v.Retries,
+		RetryInterval:      v.RetryIntervalSeconds,
+		Secret:             v.Secret,
+		Message:            v.Message,
+		To:                 v.To,
+		Subject:            v.Subject,
+		RoutingKey:         v.RoutingKey,
+		Incident:           v.Incident,
+		Severity:           v.Severity,
+		Scale:              v.Scale,
+		Units:              v.Units,
+		Process:            v.Process,
+		Token:              v.Token,
+		DryRun:             v.DryRun,
+		Timeout:            v.TimeoutSeconds,
+		ExpectedStatus:     v.ExpectedStatus,
+		ResponseExpression: v.ResponseExpression,
+		Concurrency:        v.Concurrency,
+		ConcurrencyPolicy:  v.ConcurrencyPolicy,
+		Rollback:           v.Rollback,
+		Command:            v.Command,
+		Once:               v.Once,
+	}
+	if a.Type == action.TypeHTTP {
+		a.Type = ""
+	}
+	return &a
+}
+
+type actionGroupV2 struct {
+	Actions    []string `json:"actions"`
+	MinSuccess int      `json:"min_success"`
+}
+
+type alarmV2 struct {
+	Name          string                   `json:"name"`
+	Expression    string                   `json:"expression"`
+	Enabled       bool                     `json:"enabled"`
+	WaitSeconds   float64                  `json:"wait_seconds"`
+	Actions       []string                 `json:"actions"`
+	DataSources   []string                 `json:"datasources"`
+	Instance      string                   `json:"instance"`
+	Envs          map[string]string        `json:"envs"`
+	Notifications []string                 `json:"notifications"`
+	Conditions    map[string]string        `json:"conditions"`
+	Pipeline      bool                     `json:"pipeline"`
+	Groups        map[string]actionGroupV2 `json:"groups"`
+	State         string                   `json:"state"`
+	StateReason   string                   `json:"state_reason,omitempty"`
+}
+
+// alarmStates are the v2 alarm states, by stored state. Alarms not checked
+// yet have no state.
+var alarmStates = map[string]string{
+	"":                          "unknown",
+	alarm.StateOK:               "ok",
+	alarm.StateAlarm:            "alarm",
+	alarm.StateInsufficientData: "insufficient_data",
+}
+
+func newAlarmV2(a *alarm.Alarm) *alarmV2 {
+	v := alarmV2{
+		Name:          a.Name,
+		Expression:    a.Expression,
+		Enabled:       a.Enabled,
+		WaitSeconds:   a.Wait.Seconds(),
+		Actions:       nonNilStrings(a.Actions),
+		DataSources:   nonNilStrings(a.DataSources),
+		Instance:      a.Instance,
+		Envs:          a.Envs,
+		Notifications: nonNilStrings(a.Notifications),
+		Conditions:    a.Conditions,
+		Pipeline:      a.Pipeline,
+		Groups:        map[string]actionGroupV2{},
+		State:         alarmStates[a.State],
+		StateReason:   a.StateReason,
+	}
+	if v.State == "" {
+		v.State = strings.ToLower(a.State)
+	}
+	if v.Envs == nil {
+		v.Envs = map[string]string{}
+	}
+	if v.Conditions == nil {
+		v.Conditions = map[string]string{}
+	}
+	for name, g := range a.Groups {
+		v.Groups[name] = actionGroupV2{Actions: nonNilStrings(g.Actions), MinSuccess: g.MinSuccess}
+	}
+	return &v
+}
+
+// model returns the alarm of the representation. The state is managed by
+// the alarm checks, so it is not read.
+func (v *alarmV2) model() *alarm.Alarm {
+	a := alarm.Alarm{
+		Name:          v.Name,
+		Expression:    v.Expression,
+		Enabled:       v.Enabled,
+		Wait:          time.Duration(v.WaitSeconds * float64(time.Second)),
+		Actions:       v.Actions,
+		DataSources:   v.DataSources,
+		Instance:      v.Instance,
+		Envs:          v.Envs,
+		Notifications: v.Notifications,
+		Conditions:    v.Conditions,
+		Pipeline:      v.Pipeline,
+	}
+	if len(v.Groups) > 0 {
+		a.Groups = make(map[string]alarm.ActionGroup, len(v.Groups))
+		for name, g := range v.Groups {
+			a.Groups[name] = alarm.ActionGroup{Actions: g.Actions, MinSuccess: g.MinSuccess}
+		}
+	}
+	return &a
+}
+
+type attemptV2 struct {
+	Time       time.Time `json:"time"`
+	DurationMs float64   `json:"duration_ms"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DryRun     bool      `json:"dry_run"`
+	Throttled  bool      `json:"throttled"`
+}
+
+func newAttemptsV2(attempts []action.Attempt) []attemptV2 {
+	v := make([]attemptV2, len(attempts))
+	for i, a := range attempts {
+		v[i] = attemptV2{
+			Time:       a.Time.UTC(),
+			DurationMs: float64(a.Duration) / float64(time.Millisecond),
+			StatusCode: a.StatusCode,
+			Error:      a.Error,
+			DryRun:     a.DryRun,
+			Throttled:  a.Throttled,
+		}
+	}
+	return v
+}
+
+type rollbackV2 struct {
+	Action   string      `json:"action"`
+	Status   string      `json:"status"`
+	Error    string      `json:"error,omitempty"`
+	Attempts []attemptV2 `json:"attempts"`
+}
+
+type eventV2 struct {
+	ID             string      `json:"id"`
+	Alarm          string      `json:"alarm"`
+	Instance       string      `json:"instance,omitempty"`
+	Action         string      `json:"action"`
+	Status         string      `json:"status"`
+	StartTime      time.Time   `json:"start_time"`
+	EndTime        *time.Time  `json:"end_time"`
+	Error          string      `json:"error,omitempty"`
+	Attempts       []attemptV2 `json:"attempts"`
+	Rollback       *rollbackV2 `json:"rollback,omitempty"`
+	Manual         bool        `json:"manual,omitempty"`
+	User           string      `json:"user,omitempty"`
+	AcknowledgedBy string      `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time  `json:"acknowledged_at,omitempty"`
+}
+
+// Event statuses: running events have not ended yet.
+const (
+	eventRunning   = "running"
+	eventSucceeded = "succeeded"
+	eventFailed    = "failed"
+)
+
+func newEventV2(e *alarm.Event) *eventV2 {
+	v := eventV2{
+		ID:        e.ID.Hex(),
+		Status:    eventRunning,
+		StartTime: e.StartTime.UTC(),
+		Error:     e.Error,
+		Attempts:  newAttemptsV2(e.Attempts),
+		Manual:    e.Manual,
+		User:      e.User,
+	}
+	if e.AcknowledgedAt != nil {
+		at := e.AcknowledgedAt.UTC()
+		v.AcknowledgedBy = e.AcknowledgedBy
+		v.AcknowledgedAt = &at
+	}
+	if e.Alarm != nil {
+		v.Alarm = e.Alarm.Name
+		v.Instance = e.Alarm.Instance
+	}
+	if e.Action != nil {
+		v.Action = e.Action.Name
+	}
+	if !e.EndTime.IsZero() {
+		end := e.EndTime.UTC()
+		v.EndTime = &end
+		v.Status = eventFailed
+		if e.Successful {
+			v.Status = eventSucceeded
+		}
+	}
+	if e.Rollback != nil {
+		v.Rollback = &rollbackV2{
+			Action:   e.Rollback.Name,
+			Status:   eventSucceeded,
+			Error:    e.RollbackError,
+			Attempts: newAttemptsV2(e.RollbackAttempts),
+		}
+		if e.RollbackError != "" {
+			v.Rollback.Status = eventFailed
+		}
+	}
+	return &v
+}
+
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestAlarmV2(c *check.C) {
+	a := alarm.Alarm{
+		Name:    "alarm1",
+		Wait:    5 * time.Minute,
+		Actions: []string{"scale_up"},
+		Groups:  map[string]alarm.ActionGroup{"notify": {Actions: []string{"slack"}, MinSuccess: 1}},
+		State:   alarm.StateInsufficientData,
+	}
+	v := newAlarmV2(&a)
+	c.Assert(v.WaitSeconds, check.Equals, float64(300))
+	c.Assert(v.State, check.Equals, "insufficient_data")
+	c.Assert(v.DataSources, check.DeepEquals, []string{})
+	data, err := json.Marshal(v)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Matches, `.*"wait_seconds":300,.*`)
+	c.Assert(string(data), check.Matches, `.*"groups":\{"notify":\{"actions":\["slack"\],"min_success":1\}\}.*`)
+	model := v.model()
+	c.Assert(model.Wait, check.Equals, a.Wait)
+	c.Assert(model.Groups, check.DeepEquals, a.Groups)
+	c.Assert(model.State, check.Equals, "")
+	c.Assert(newAlarmV2(&alarm.Alarm{}).State, check.Equals, "unknown")
+}
+
+func (s *S) TestEventV2(c *check.C) {
+	start := time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)
+	evt := alarm.Event{
+		ID:        bson.NewObjectId(),
+		StartTime: start,
+		Alarm:     &alarm.Alarm{Name: "alarm1"},
+		Action:    &action.Action{Name: "scale_up"},
+		Attempts:  []action.Attempt{{Time: start, Duration: 1500 * time.Microsecond, StatusCode: 500}},
+	}
+	v := newEventV2(&evt)
+	c.Assert(v.Status, check.Equals, "running")
+	c.Assert(v.EndTime, check.IsNil)
+	c.Assert(v.Alarm, check.Equals, "alarm1")
+	c.Assert(v.Action, check.Equals, "scale_up")
+	c.Assert(v.Attempts[0].DurationMs, check.Equals, 1.5)
+	evt.EndTime = start.Add(time.Second)
+	evt.Error = "failed"
+	evt.Rollback = &action.Action{Name: "scale_down"}
+	evt.RollbackError = "failed too"
+	v = newEventV2(&evt)
+	c.Assert(v.Status, check.Equals, "failed")
+	c.Assert(*v.EndTime, check.Equals, evt.EndTime)
+	c.Assert(v.Rollback.Status, check.Equals, "failed")
+	c.Assert(v.Rollback.Attempts, check.DeepEquals, []attemptV2{})
+	evt.Successful = true
+	c.Assert(newEventV2(&evt).Status, check.Equals, "succeeded")
+}
+
+func (s *S) TestActionV2(c *check.C) {
+	a := action.Action{Name: "scale_up", URL: "http://tsuru.io", Method: "POST", RetryInterval: 2, Timeout: 10}
+	v := newActionV2(&a)
+	c.Assert(v.Type, check.Equals, action.TypeHTTP)
+	data, err := json.Marshal(v)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, `{"name":"scale_up","type":"http","url":"http://tsuru.io","method":"POST","retry_interval_seconds":2,"dry_run":false,"timeout_seconds":10,"once":false}`)
+	c.Assert(v.model(), check.DeepEquals, &a)
+}
+
+func (s *S) TestV2InvalidBody(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/v2/alarms", strings.NewReader(`{"name":`))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s)\{"error":\{"code":"bad_request","message":"invalid body: .*"\}\}\n`)
+}
+
+func (s *S) TestV2Alarms(c *check.C) {
+	body := `{"name":"alarm1","expression":"x > 1","enabled":true,"wait_seconds":60,"instance":"instance1","actions":["scale_up"]}`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/v2/alarms", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	a, err := alarm.FindAlarmByName("alarm1")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Wait, check.Equals, time.Minute)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/v2/alarms?instance=instance1", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var page struct {
+		Data []alarmV2
+		Meta v2Meta
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &page)
+	c.Assert(err, check.IsNil)
+	c.Assert(page.Meta, check.Equals, v2Meta{Total: 1})
+	c.Assert(page.Data, check.HasLen, 1)
+	c.Assert(page.Data[0].WaitSeconds, check.Equals, float64(60))
+	c.Assert(page.Data[0].State, check.Equals, "unknown")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/v2/alarms/alarm1/disable", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s)\{"data":\{"name":"alarm1",.*"enabled":false,.*`)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("DELETE", "/v2/alarms/alarm1", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/v2/alarms/alarm1", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s)\{"error":\{"code":"not_found",.*`)
+}
+
+func (s *S) TestV2ListAlarmsUnauthorized(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/v2/alarms", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s)\{"error":\{"code":"unauthorized",.*`)
+}
+
+func (s *S) TestV2Actions(c *check.C) {
+	body := `{"name":"scale_up","url":"http://tsuru.io","method":"POST","secret":"s3cr3t"}`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/v2/actions", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s)\{"data":\{"name":"scale_up","type":"http",.*"secret":"\*\*\*\*\*".*`)
+	body = `{"url":"http://tsuru.io/up","method":"POST","secret":"*****","timeout_seconds":5}`
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("PUT", "/v2/actions/scale_up", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	a, err := action.FindByName("scale_up")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.URL, check.Equals, "http://tsuru.io/up")
+	c.Assert(a.Timeout, check.Equals, 5)
+	c.Assert(a.Secret, check.Equals, "s3cr3t")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/v2/actions", strings.NewReader(`{"name":"invalid","type":"unknown"}`))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s)\{"error":\{"code":"invalid","message":"action: invalid type \\"unknown\\""\}\}\n`)
+}
+
+func (s *S) TestV2DataSources(c *check.C) {
+	body := `{"name":"ds","url":"http://tsuru.io","method":"GET","timeout_seconds":3}`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/v2/datasources", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/v2/datasources/ds", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var resp struct{ Data dataSourceV2 }
+	err = json.Unmarshal(recorder.Body.Bytes(), &resp)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.Data.Type, check.Equals, "http")
+	c.Assert(resp.Data.TimeoutSeconds, check.Equals, 3)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/v2/datasources", strings.NewReader(`{"name":"invalid","url":"tsuru.io","method":"FETCH"}`))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s)\{"error":\{"code":"invalid",.*"fields":\[.*`)
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// defaultWatchTimeout is how long the watches wait for new events, when
+	// timeout is not set.
+	defaultWatchTimeout = 30 * time.Second
+	// maxWatchTimeout is the maximum timeout of the watches.
+	maxWatchTimeout = 60 * time.Second
+	// maxWatchEvents is the maximum number of events of a watch response.
+	maxWatchEvents = 100
+)
+
+// watchPollInterval is the interval between the queries of the watches.
+var watchPollInterval = time.Second
+
+// watchResponse is the response of the watches: the new events, the oldest
+// first, and the cursor of the next watch.
+type watchResponse struct {
+	Events []*eventV2 `json:"events"`
+	Cursor string     `json:"cursor"`
+}
+
+// watchCursor parses the cursor of the watch, the id of the last event
+// received, or returns the cursor of the events created from now on.
+func watchCursor(r *http.Request) (bson.ObjectId, error) {
+	after := r.URL.Query().Get("after")
+	if after == "" {
+		return bson.NewObjectIdWithTime(time.Now()), nil
+	}
+	if !bson.IsObjectIdHex(after) {
+		return "", fmt.Errorf("invalid cursor %q", after)
+	}
+	return bson.ObjectIdHex(after), nil
+}
+
+// watchTimeout parses the timeout of the watch, in seconds.
+func watchTimeout(r *http.Request) (time.Duration, error) {
+	t := r.URL.Query().Get("timeout")
+	if t == "" {
+		return defaultWatchTimeout, nil
+	}
+	v, err := strconv.Atoi(t)
+	if err != nil || v < 0 || time.Duration(v)*time.Second > maxWatchTimeout {
+		return 0, fmt.Errorf("timeout must be between 0 and %d", int(maxWatchTimeout/time.Second))
+	}
+	return time.Duration(v) * time.Second, nil
+}
+
+// watchEvents long polls the events created after the cursor, matching the
+// filters of the export, so the clients are updated without polling
+// aggressively. It responds as soon as there are new events, or with no
+// events when the timeout expires, with the cursor of the next watch. The
+// events are identified by their ids, which grow with their creation, so
+// the events are reported when they start, not when they end.
+func watchEvents(w http.ResponseWriter, r *http.Request) error {
+	opts, err := eventExport.options(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	cursor, err := watchCursor(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	timeout, err := watchTimeout(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	q := opts.Filter
+	deadline := time.Now().Add(timeout)
+	var events []alarm.Event
+	for {
+		q["_id"] = bson.M{"$gt": cursor}
+		events, _, err = alarm.FindEvents(q, &db.ListOptions{Sort: []string{"_id"}, Limit: maxWatchEvents})
+		if err != nil {
+			return err
+		}
+		if len(events) > 0 || !time.Now().Before(deadline) {
+			break
+		}
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-time.After(watchPollInterval):
+		}
+	}
+	resp := watchResponse{Events: []*eventV2{}, Cursor: cursor.Hex()}
+	for i := range events {
+		resp.Events = append(resp.Events, newEventV2(&events[i]))
+		resp.Cursor = events[i].ID.Hex()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
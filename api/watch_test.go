@@ -0,0 +1,69 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestWatchEvents(c *check.C) {
+	before := bson.NewObjectIdWithTime(time.Now().Add(-time.Minute))
+	events := []alarm.Event{
+		{ID: bson.NewObjectId(), StartTime: time.Now().UTC(), Alarm: &alarm.Alarm{Name: "high", Instance: "myinstance"}},
+		{ID: bson.NewObjectId(), StartTime: time.Now().UTC(), Alarm: &alarm.Alarm{Name: "low", Instance: "other"}},
+	}
+	for _, evt := range events {
+		err := s.conn.Events().Insert(evt)
+		c.Assert(err, check.IsNil)
+	}
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/events/watch?timeout=0&instance=myinstance&after="+before.Hex(), nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var resp watchResponse
+	err = json.NewDecoder(recorder.Body).Decode(&resp)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.Events, check.HasLen, 1)
+	c.Assert(resp.Events[0].Alarm, check.Equals, "high")
+	c.Assert(resp.Cursor, check.Equals, events[0].ID.Hex())
+}
+
+func (s *S) TestWatchEventsWaits(c *check.C) {
+	interval := watchPollInterval
+	watchPollInterval = 10 * time.Millisecond
+	defer func() { watchPollInterval = interval }()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.conn.Events().Insert(alarm.Event{ID: bson.NewObjectId(), StartTime: time.Now().UTC(), Alarm: &alarm.Alarm{Name: "high"}})
+	}()
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/events/watch?timeout=5", nil)
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var resp watchResponse
+	err = json.NewDecoder(recorder.Body).Decode(&resp)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.Events, check.HasLen, 1)
+	c.Assert(resp.Events[0].Alarm, check.Equals, "high")
+}
+
+func (s *S) TestWatchEventsInvalid(c *check.C) {
+	for _, query := range []string{"after=invalid", "timeout=61", "timeout=-1", "successful=maybe"} {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", "/events/watch?"+query, nil)
+		c.Assert(err, check.IsNil)
+		server(recorder, request)
+		c.Assert(recorder.Code, check.Equals, http.StatusBadRequest, check.Commentf(query))
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"io/ioutil"
 	"net/http"
 
-	"github.com/gorilla/mux"
 	"github.com/tsuru/tsuru-autoscale/wizard"
 )
 
@@ -19,11 +18,15 @@ func newAutoScale(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	if err = validateBody(schemas["autoscale"], body); err != nil {
+		return err
+	}
 	var a wizard.AutoScale
 	err = json.Unmarshal(body, &a)
 	if err != nil {
 		return err
 	}
+	a.SetLogger(requestLogger(r))
 	err = wizard.New(&a)
 	if err != nil {
 		return err
@@ -32,8 +35,20 @@ func newAutoScale(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+func listWizards(w http.ResponseWriter, r *http.Request) error {
+	opts, err := wizardList.options(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	autoScales, total, err := wizard.List(nil, opts)
+	if err != nil {
+		return err
+	}
+	return writeList(w, total, autoScales)
+}
+
 func wizardByName(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	autoScale, err := wizard.FindByName(vars["name"])
 	if err != nil {
 		return err
@@ -43,30 +58,34 @@ func wizardByName(w http.ResponseWriter, r *http.Request) error {
 }
 
 func removeWizard(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	autoScale, err := wizard.FindByName(vars["name"])
 	if err != nil {
 		return err
 	}
+	autoScale.SetLogger(requestLogger(r))
 	return wizard.Remove(autoScale)
 }
 
 func eventsByWizardName(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	autoScale, err := wizard.FindByName(vars["name"])
 	if err != nil {
 		return err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	events, err := autoScale.Events()
+	opts, err := eventList.options(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	events, total, err := autoScale.ListEvents(opts)
 	if err != nil {
 		return err
 	}
-	return json.NewEncoder(w).Encode(&events)
+	return writeList(w, total, events)
 }
 
 func wizardEnable(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	autoScale, err := wizard.FindByName(vars["name"])
 	if err != nil {
 		return err
@@ -75,7 +94,7 @@ func wizardEnable(w http.ResponseWriter, r *http.Request) error {
 }
 
 func wizardDisable(w http.ResponseWriter, r *http.Request) error {
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	autoScale, err := wizard.FindByName(vars["name"])
 	if err != nil {
 		return err
@@ -83,22 +102,71 @@ func wizardDisable(w http.ResponseWriter, r *http.Request) error {
 	return autoScale.Disable()
 }
 
+func wizardPatch(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	a, err := wizard.Patch(routeVars(r)["name"], body)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(a)
+}
+
 func wizardUpdate(w http.ResponseWriter, r *http.Request) error {
 	defer r.Body.Close()
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return err
 	}
+	if err = validateBody(schemas["autoscale"].withoutRequired(), body); err != nil {
+		return err
+	}
 	var a wizard.AutoScale
 	err = json.Unmarshal(body, &a)
 	if err != nil {
 		return err
 	}
-	vars := mux.Vars(r)
+	vars := routeVars(r)
 	a.Name = vars["name"]
+	a.SetLogger(requestLogger(r))
 	err = wizard.Update(&a)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// scaleRequest is the body of the manual scales: the direction, up or
+// down, and the units added or removed, by default the step of the auto
+// scale.
+type scaleRequest struct {
+	Direction string `json:"direction"`
+	Units     int    `json:"units"`
+}
+
+// wizardScale scales an auto scale instance manually, executing its scale
+// up or scale down action immediately, through the same events, rate limit
+// and notifications of the auto scale. The events are recorded as manual,
+// with the fingerprint of the token of the request as their user.
+func wizardScale(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	var req scaleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return badRequest("invalid body: " + err.Error())
+	}
+	autoScale, err := wizard.FindByName(routeVars(r)["name"])
+	if err != nil {
+		return err
+	}
+	autoScale.SetLogger(requestLogger(r))
+	result, err := autoScale.Scale(req.Direction, req.Units, principal(r))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(result)
+}
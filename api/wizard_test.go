@@ -11,7 +11,9 @@ import (
 	"net/http/httptest"
 	"strings"
 
+	"github.com/tsuru/tsuru-autoscale/action"
 	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/tsuru"
 	"github.com/tsuru/tsuru-autoscale/wizard"
 	"gopkg.in/check.v1"
 )
@@ -240,3 +242,113 @@ func (s *S) TestUpdateAutoScale(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(a.MinUnits, check.Equals, 1)
 }
+
+func (s *S) TestPatchAutoScale(c *check.C) {
+	autoScale := &wizard.AutoScale{
+		Name:      "instance",
+		ScaleUp:   wizard.ScaleAction{Metric: "cpu", Operator: ">", Step: "1", Value: "10", Wait: 50},
+		ScaleDown: wizard.ScaleAction{Metric: "cpu", Operator: "<", Step: "1", Value: "2", Wait: 50},
+		Process:   "web",
+		MinUnits:  3,
+	}
+	err := wizard.New(autoScale)
+	c.Assert(err, check.IsNil)
+	body := `{"scaleUp":{"value":"90"}}`
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("PATCH", "/wizard/instance", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/merge-patch+json")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*"scaleUp":\{[^}]*"value":"90".*`)
+	a, err := wizard.FindByName(autoScale.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.ScaleUp.Value, check.Equals, "90")
+	c.Assert(a.ScaleUp.Operator, check.Equals, ">")
+	c.Assert(a.MinUnits, check.Equals, 3)
+}
+
+func (s *S) TestPatchAutoScaleInvalid(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("PATCH", "/wizard/instance", strings.NewReader(`["value"]`))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, `{"error":{"code":"invalid","message":"wizard: patch must be a json object"}}`+"\n")
+}
+
+func (s *S) TestWizardScale(c *check.C) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer ts.Close()
+	err := action.New(&action.Action{Name: "scale_down", URL: ts.URL + "/{app}?step={step}", Method: "POST"})
+	c.Assert(err, check.IsNil)
+	err = tsuru.NewInstance(&tsuru.Instance{Name: "myinstance", Apps: []string{"app"}})
+	c.Assert(err, check.IsNil)
+	autoScale := wizard.AutoScale{
+		Name:      "myinstance",
+		ScaleUp:   wizard.ScaleAction{Metric: "cpu", Operator: ">", Step: "1", Value: "10"},
+		ScaleDown: wizard.ScaleAction{Metric: "cpu", Operator: "<", Step: "1", Value: "2"},
+	}
+	err = wizard.New(&autoScale)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader(`{"direction":"down","units":2}`)
+	request, err := http.NewRequest("POST", "/wizard/myinstance/scale", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Add("Authorization", "bearer token")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, `{"alarm":"scale_down_myinstance","executed":true}`+"\n")
+	c.Assert(calls, check.Equals, 1)
+	events, err := autoScale.Events()
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 1)
+	c.Assert(events[0].Manual, check.Equals, true)
+	c.Assert(events[0].User, check.Equals, principal(request))
+}
+
+func (s *S) TestWizardScaleIgnoresBodyUser(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	err := action.New(&action.Action{Name: "scale_up", URL: ts.URL + "/{app}?step={step}", Method: "POST"})
+	c.Assert(err, check.IsNil)
+	err = tsuru.NewInstance(&tsuru.Instance{Name: "myinstance", Apps: []string{"app"}})
+	c.Assert(err, check.IsNil)
+	autoScale := wizard.AutoScale{
+		Name:      "myinstance",
+		ScaleUp:   wizard.ScaleAction{Metric: "cpu", Operator: ">", Step: "1", Value: "10"},
+		ScaleDown: wizard.ScaleAction{Metric: "cpu", Operator: "<", Step: "1", Value: "2"},
+	}
+	err = wizard.New(&autoScale)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader(`{"direction":"up","user":"someone@example.com"}`)
+	request, err := http.NewRequest("POST", "/wizard/myinstance/scale", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Add("Authorization", "bearer token")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	events, err := autoScale.Events()
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 1)
+	c.Assert(events[0].User, check.Equals, principal(request))
+}
+
+func (s *S) TestWizardScaleNotFound(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/wizard/notfound/scale", strings.NewReader(`{"direction":"up"}`))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestWizardScaleInvalidBody(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/wizard/myinstance/scale", strings.NewReader(`{"units":`))
+	c.Assert(err, check.IsNil)
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
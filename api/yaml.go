@@ -0,0 +1,192 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v1"
+)
+
+// yamlContentType is the media type of the yaml responses.
+const yamlContentType = "application/yaml"
+
+// yamlTypes are the media types of the yaml bodies.
+var yamlTypes = []string{yamlContentType, "application/x-yaml", "text/yaml", "text/x-yaml"}
+
+// yamlRoutes are the prefixes of the routes of the configurations, which
+// accept and return yaml.
+var yamlRoutes = []string{"/wizard", "/alarm", "/datasource", "/action", "/v2/alarms", "/v2/datasources", "/v2/actions"}
+
+func isYAMLRoute(route string) bool {
+	for _, prefix := range yamlRoutes {
+		if route == prefix || strings.HasPrefix(route, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func isYAML(mediaType string) bool {
+	name := strings.TrimSpace(strings.Split(mediaType, ";")[0])
+	for _, t := range yamlTypes {
+		if strings.EqualFold(name, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsYAML returns whether the client accepts yaml responses.
+func acceptsYAML(r *http.Request) bool {
+	for _, mediaType := range strings.Split(r.Header.Get("Accept"), ",") {
+		parts := strings.Split(mediaType, ";")
+		if !isYAML(parts[0]) {
+			continue
+		}
+		return len(parts) == 1 || strings.Replace(parts[1], " ", "", -1) != "q=0"
+	}
+	return false
+}
+
+// fromYAML converts the yaml values to the ones of json: maps with string
+// keys, instead of any keys.
+func fromYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = fromYAML(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = fromYAML(item)
+		}
+	}
+	return value
+}
+
+// toYAML converts the json values, decoded with numbers, to the ones of
+// yaml, with the integers kept as integers.
+func toYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = toYAML(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = toYAML(item)
+		}
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return value
+}
+
+func yamlToJSON(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fromYAML(value))
+}
+
+func jsonToYAML(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(toYAML(value))
+}
+
+// yamlRequests converts the yaml request bodies to json, so the handlers,
+// and the audit log, read the same structs from both.
+func yamlRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || !isYAML(r.Header.Get("Content-Type")) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		body, err := yamlToJSON(data)
+		if err != nil {
+			writeError(w, badRequest("invalid body: "+err.Error()))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Set("Content-Type", "application/json")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// yamlResponseWriter buffers the response, to convert it to yaml.
+type yamlResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *yamlResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *yamlResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+// yamlResponses converts the json responses of the handler to yaml, when
+// the client accepts it.
+func yamlResponses(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		if !acceptsYAML(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		yw := yamlResponseWriter{ResponseWriter: w}
+		h.ServeHTTP(&yw, r)
+		if yw.status == 0 {
+			yw.status = http.StatusOK
+		}
+		body := yw.body.Bytes()
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") && len(body) > 0 {
+			data, err := jsonToYAML(body)
+			if err != nil {
+				requestLogger(r).Error(err)
+			} else {
+				w.Header().Set("Content-Type", yamlContentType)
+				w.Header().Del("Content-Length")
+				body = data
+			}
+		}
+		w.WriteHeader(yw.status)
+		w.Write(body)
+	})
+}
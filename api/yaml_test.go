@@ -0,0 +1,97 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru-autoscale/datasource"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAcceptsYAML(c *check.C) {
+	tests := []struct {
+		accept string
+		yaml   bool
+	}{
+		{"", false},
+		{"application/json", false},
+		{"application/yaml", true},
+		{"text/html, application/x-yaml;q=0.9", true},
+		{"application/yaml;q=0", false},
+	}
+	for _, tt := range tests {
+		request, err := http.NewRequest("GET", "/", nil)
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Accept", tt.accept)
+		c.Check(acceptsYAML(request), check.Equals, tt.yaml, check.Commentf(tt.accept))
+	}
+}
+
+func (s *S) TestYAMLConversion(c *check.C) {
+	data, err := yamlToJSON([]byte("name: high\nwait: 60000000000\nenvs:\n  step: \"1\"\nactions: [scale_up]\ngroups:\n  g:\n    min_success: 1\n"))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, `{"actions":["scale_up"],"envs":{"step":"1"},"groups":{"g":{"min_success":1}},"name":"high","wait":60000000000}`)
+	data, err = jsonToYAML(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "actions:\n- scale_up\nenvs:\n  step: \"1\"\ngroups:\n  g:\n    min_success: 1\nname: high\nwait: 60000000000\n")
+	_, err = yamlToJSON([]byte("name: [high"))
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestYAMLHandlers(c *check.C) {
+	h := yamlRequests(yamlResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		c.Check(r.Header.Get("Content-Type"), check.Equals, "application/json")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	})))
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/alarm", strings.NewReader("name: high\nenabled: true\n"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/yaml")
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Body.String(), check.Equals, `{"enabled":true,"name":"high"}`)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/alarm", strings.NewReader(`{"name":"high"}`))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/yaml")
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/yaml")
+	c.Assert(recorder.Body.String(), check.Equals, "name: high\n")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/alarm", strings.NewReader("name: [high"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "text/yaml")
+	h.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestDataSourceYAML(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/datasource", strings.NewReader("name: cpu\nurl: http://tsuru.io\nmethod: GET\n"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/yaml")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	ds, err := datasource.Get("cpu")
+	c.Assert(err, check.IsNil)
+	c.Assert(ds.URL, check.Equals, "http://tsuru.io")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("GET", "/datasource/cpu", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Accept", "application/yaml")
+	server(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/yaml")
+	c.Assert(recorder.Body.String(), check.Matches, "(?s).*Name: cpu\n.*URL: http://tsuru.io\n.*")
+}
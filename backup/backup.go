@@ -0,0 +1,311 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package backup exports the configuration of tsuru-autoscale, its data
+// sources, actions, alarms and auto scales, into a single archive, and
+// imports the archives, for disaster recovery and to clone environments.
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/datasource"
+	"github.com/tsuru/tsuru-autoscale/wizard"
+)
+
+// Version is the version of the archives exported.
+const Version = 1
+
+// Strategies of the import for the configurations which already exist.
+const (
+	// ConflictSkip keeps the existing configurations.
+	ConflictSkip = "skip"
+	// ConflictOverwrite replaces the existing configurations.
+	ConflictOverwrite = "overwrite"
+	// ConflictFail imports nothing when any configuration exists.
+	ConflictFail = "fail"
+)
+
+// Archive is the configuration of an environment. The alarms of the auto
+// scales are exported with the other alarms, so their state, like whether
+// they are enabled, is restored.
+type Archive struct {
+	Version     int                     `json:"version"`
+	CreatedAt   time.Time               `json:"created_at"`
+	DataSources []datasource.DataSource `json:"datasources"`
+	Actions     []action.Action         `json:"actions"`
+	Alarms      []alarm.Alarm           `json:"alarms"`
+	Wizards     []wizard.AutoScale      `json:"wizards"`
+}
+
+// Result is the result of the import of a kind of configuration: the names
+// of the ones created, updated, skipped, as they already existed, and the
+// ones which failed.
+type Result struct {
+	Created []string  `json:"created"`
+	Updated []string  `json:"updated"`
+	Skipped []string  `json:"skipped"`
+	Failed  []Failure `json:"failed"`
+}
+
+func newResult() Result {
+	return Result{Created: []string{}, Updated: []string{}, Skipped: []string{}, Failed: []Failure{}}
+}
+
+// Failure is a configuration which could not be imported.
+type Failure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// Report is the result of an import.
+type Report struct {
+	DataSources Result `json:"datasources"`
+	Actions     Result `json:"actions"`
+	Alarms      Result `json:"alarms"`
+	Wizards     Result `json:"wizards"`
+}
+
+// ConflictError is returned by the imports with the fail strategy when
+// configurations of the archive already exist, like "alarm cpu_high".
+type ConflictError struct {
+	Names []string `json:"names"`
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("backup: %s already exist", strings.Join(e.Names, ", "))
+}
+
+// ValidationError is returned by the imports of invalid archives or
+// options.
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return "backup: " + e.Message
+}
+
+// Export returns the archive of the configuration, sorted by name. The
+// secrets are redacted, unless secrets is set, so the archive can restore
+// an environment where they are not stored.
+func Export(secrets bool) (*Archive, error) {
+	archive := Archive{
+		Version:     Version,
+		CreatedAt:   time.Now().UTC(),
+		DataSources: []datasource.DataSource{},
+		Actions:     []action.Action{},
+		Alarms:      []alarm.Alarm{},
+		Wizards:     []wizard.AutoScale{},
+	}
+	dataSources, err := datasource.FindBy(nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := range dataSources {
+		ds := &dataSources[i]
+		if !secrets {
+			ds = ds.Redacted()
+		}
+		archive.DataSources = append(archive.DataSources, *ds)
+	}
+	actions, err := action.All()
+	if err != nil {
+		return nil, err
+	}
+	for i := range actions {
+		a := &actions[i]
+		if !secrets {
+			a = a.Redacted()
+		}
+		archive.Actions = append(archive.Actions, *a)
+	}
+	alarms, err := alarm.FindAlarmBy(nil)
+	if err != nil {
+		return nil, err
+	}
+	archive.Alarms = append(archive.Alarms, alarms...)
+	wizards, err := wizard.FindBy(nil)
+	if err != nil {
+		return nil, err
+	}
+	archive.Wizards = append(archive.Wizards, wizards...)
+	sort.Slice(archive.DataSources, func(i, j int) bool { return archive.DataSources[i].Name < archive.DataSources[j].Name })
+	sort.Slice(archive.Actions, func(i, j int) bool { return archive.Actions[i].Name < archive.Actions[j].Name })
+	sort.Slice(archive.Alarms, func(i, j int) bool { return archive.Alarms[i].Name < archive.Alarms[j].Name })
+	sort.Slice(archive.Wizards, func(i, j int) bool { return archive.Wizards[i].Name < archive.Wizards[j].Name })
+	return &archive, nil
+}
+
+// Import restores the configuration of the archive: the data sources, the
+// actions, the auto scales and then the alarms, so the alarms of the auto
+// scales get the state of the archive. The configurations which already
+// exist are handled by the conflict strategy, skip by default. The failures
+// of single configurations, like redacted secrets of ones which do not
+// exist, are reported and do not stop the import.
+func Import(archive *Archive, conflict string) (*Report, error) {
+	if archive.Version != Version {
+		return nil, &ValidationError{Message: fmt.Sprintf("unsupported archive version %d", archive.Version)}
+	}
+	if conflict == "" {
+		conflict = ConflictSkip
+	}
+	if conflict != ConflictSkip && conflict != ConflictOverwrite && conflict != ConflictFail {
+		return nil, &ValidationError{Message: fmt.Sprintf("invalid conflict strategy %q, must be skip, overwrite or fail", conflict)}
+	}
+	existing, err := existingNames()
+	if err != nil {
+		return nil, err
+	}
+	if conflict == ConflictFail {
+		if err = checkConflicts(archive, existing); err != nil {
+			return nil, err
+		}
+	}
+	report := Report{DataSources: newResult(), Actions: newResult(), Alarms: newResult(), Wizards: newResult()}
+	imp := importer{conflict: conflict, existing: existing}
+	for i := range archive.DataSources {
+		ds := &archive.DataSources[i]
+		imp.save(&report.DataSources, "datasource", ds.Name, func() (bool, error) {
+			return datasource.Save(ds)
+		})
+	}
+	for i := range archive.Actions {
+		a := archive.Actions[i]
+		imp.save(&report.Actions, "action", a.Name, func() (bool, error) {
+			result, err := action.Import([]action.Action{a})
+			if err != nil {
+				return false, err
+			}
+			return len(result.Created) > 0, nil
+		})
+	}
+	saved := map[string]bool{}
+	owners := map[string]string{}
+	for i := range archive.Wizards {
+		w := &archive.Wizards[i]
+		for _, name := range w.AlarmNames() {
+			owners[name] = w.Name
+		}
+		saved[w.Name] = imp.save(&report.Wizards, "wizard", w.Name, func() (bool, error) {
+			return wizard.Save(w)
+		})
+	}
+	for i := range archive.Alarms {
+		a := &archive.Alarms[i]
+		save := func() (bool, error) {
+			return alarm.SaveAlarm(a)
+		}
+		if owner, ok := owners[a.Name]; ok {
+			// The alarms of the auto scales follow them, as saving an
+			// auto scale replaces its alarms.
+			if !saved[owner] {
+				report.Alarms.Skipped = append(report.Alarms.Skipped, a.Name)
+				continue
+			}
+			imp.overwrite(&report.Alarms, a.Name, save)
+			continue
+		}
+		imp.save(&report.Alarms, "alarm", a.Name, save)
+	}
+	return &report, nil
+}
+
+// importer saves the configurations of an import.
+type importer struct {
+	conflict string
+	existing map[string]bool
+}
+
+// save saves the configuration, unless it exists and the strategy is skip,
+// adding the outcome to the result. It returns whether it was saved.
+func (imp *importer) save(result *Result, kind, name string, save func() (bool, error)) bool {
+	if imp.conflict == ConflictSkip && imp.existing[kind+" "+name] {
+		result.Skipped = append(result.Skipped, name)
+		return false
+	}
+	return imp.overwrite(result, name, save)
+}
+
+// overwrite saves the configuration, adding the outcome to the result.
+func (imp *importer) overwrite(result *Result, name string, save func() (bool, error)) bool {
+	created, err := save()
+	switch {
+	case err != nil:
+		result.Failed = append(result.Failed, Failure{Name: name, Error: err.Error()})
+		return false
+	case created:
+		result.Created = append(result.Created, name)
+	default:
+		result.Updated = append(result.Updated, name)
+	}
+	return true
+}
+
+// existingNames returns the configurations stored, by kind and name, like
+// "alarm cpu_high".
+func existingNames() (map[string]bool, error) {
+	names := map[string]bool{}
+	dataSources, err := datasource.FindBy(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, ds := range dataSources {
+		names["datasource "+ds.Name] = true
+	}
+	actions, err := action.All()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range actions {
+		names["action "+a.Name] = true
+	}
+	alarms, err := alarm.FindAlarmBy(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range alarms {
+		names["alarm "+a.Name] = true
+	}
+	wizards, err := wizard.FindBy(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range wizards {
+		names["wizard "+w.Name] = true
+	}
+	return names, nil
+}
+
+// checkConflicts returns the ConflictError of the configurations of the
+// archive which already exist.
+func checkConflicts(archive *Archive, existing map[string]bool) error {
+	var names []string
+	check := func(kind, name string) {
+		if existing[kind+" "+name] {
+			names = append(names, kind+" "+name)
+		}
+	}
+	for _, ds := range archive.DataSources {
+		check("datasource", ds.Name)
+	}
+	for _, a := range archive.Actions {
+		check("action", a.Name)
+	}
+	for _, a := range archive.Alarms {
+		check("alarm", a.Name)
+	}
+	for _, w := range archive.Wizards {
+		check("wizard", w.Name)
+	}
+	if len(names) > 0 {
+		return &ConflictError{Names: names}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backup
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/tsuru/tsuru-autoscale/action"
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"github.com/tsuru/tsuru-autoscale/datasource"
+	"github.com/tsuru/tsuru-autoscale/db/storagetest"
+	"github.com/tsuru/tsuru-autoscale/secret"
+	"github.com/tsuru/tsuru-autoscale/wizard"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	conn *storagetest.Storage
+}
+
+func (s *S) SetUpSuite(c *check.C) {
+	var err error
+	s.conn, err = storagetest.New("tsuru_autoscale_backup")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	s.conn.Clear()
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	err := os.Unsetenv("MONGODB_DATABASE_NAME")
+	c.Assert(err, check.IsNil)
+}
+
+var _ = check.Suite(&S{})
+
+// configure stores a data source, an action, an alarm and an auto scale,
+// with one of its alarms disabled.
+func (s *S) configure(c *check.C) {
+	err := datasource.New(&datasource.DataSource{Name: "cpu", URL: "http://tsuru.io", Method: "GET", Username: "autoscale", Password: "p4ss"})
+	c.Assert(err, check.IsNil)
+	err = action.New(&action.Action{Name: "scale_up", URL: "http://tsuru.io", Method: "POST", Secret: "s3cret"})
+	c.Assert(err, check.IsNil)
+	err = alarm.NewAlarm(&alarm.Alarm{Name: "high", Expression: "true", Actions: []string{"scale_up"}, DataSources: []string{"cpu"}, Enabled: true})
+	c.Assert(err, check.IsNil)
+	err = wizard.New(&wizard.AutoScale{
+		Name:      "myinstance",
+		ScaleUp:   wizard.ScaleAction{Metric: "cpu", Operator: ">", Step: "1", Value: "10", Wait: 50},
+		ScaleDown: wizard.ScaleAction{Metric: "cpu", Operator: "<", Step: "1", Value: "2", Wait: 50},
+	})
+	c.Assert(err, check.IsNil)
+	al, err := alarm.FindAlarmByName("scale_down_myinstance")
+	c.Assert(err, check.IsNil)
+	err = alarm.Disable(al)
+	c.Assert(err, check.IsNil)
+}
+
+// roundTrip returns the archive encoded and decoded as JSON, like the
+// archives downloaded and uploaded.
+func roundTrip(c *check.C, archive *Archive) *Archive {
+	data, err := json.Marshal(archive)
+	c.Assert(err, check.IsNil)
+	var decoded Archive
+	err = json.Unmarshal(data, &decoded)
+	c.Assert(err, check.IsNil)
+	return &decoded
+}
+
+func (s *S) TestExport(c *check.C) {
+	s.configure(c)
+	archive, err := Export(false)
+	c.Assert(err, check.IsNil)
+	c.Assert(archive.Version, check.Equals, Version)
+	c.Assert(archive.DataSources, check.HasLen, 1)
+	c.Assert(archive.DataSources[0].Password, check.Equals, secret.Redacted)
+	c.Assert(archive.Actions, check.HasLen, 1)
+	c.Assert(archive.Actions[0].Secret, check.Equals, secret.Redacted)
+	var names []string
+	for _, a := range archive.Alarms {
+		names = append(names, a.Name)
+	}
+	c.Assert(names, check.DeepEquals, []string{"high", "scale_down_myinstance", "scale_up_myinstance"})
+	c.Assert(archive.Wizards, check.HasLen, 1)
+	c.Assert(archive.Wizards[0].Name, check.Equals, "myinstance")
+	archive, err = Export(true)
+	c.Assert(err, check.IsNil)
+	c.Assert(archive.DataSources[0].Password, check.Equals, "p4ss")
+	c.Assert(archive.Actions[0].Secret, check.Equals, "s3cret")
+}
+
+func (s *S) TestImport(c *check.C) {
+	s.configure(c)
+	archive, err := Export(true)
+	c.Assert(err, check.IsNil)
+	archive = roundTrip(c, archive)
+	s.conn.Clear()
+	report, err := Import(archive, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(report.DataSources.Created, check.DeepEquals, []string{"cpu"})
+	c.Assert(report.Actions.Created, check.DeepEquals, []string{"scale_up"})
+	c.Assert(report.Wizards.Created, check.DeepEquals, []string{"myinstance"})
+	c.Assert(report.Alarms.Created, check.DeepEquals, []string{"high"})
+	c.Assert(report.Alarms.Updated, check.DeepEquals, []string{"scale_down_myinstance", "scale_up_myinstance"})
+	c.Assert(report.Alarms.Failed, check.HasLen, 0)
+	ds, err := datasource.Get("cpu")
+	c.Assert(err, check.IsNil)
+	c.Assert(ds.Password, check.Equals, "p4ss")
+	a, err := action.FindByName("scale_up")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Secret, check.Equals, "s3cret")
+	al, err := alarm.FindAlarmByName("scale_down_myinstance")
+	c.Assert(err, check.IsNil)
+	c.Assert(al.Enabled, check.Equals, false)
+	n, err := s.conn.Alarms().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 3)
+}
+
+func (s *S) TestImportSkip(c *check.C) {
+	s.configure(c)
+	archive, err := Export(false)
+	c.Assert(err, check.IsNil)
+	archive.Alarms[0].Expression = "false"
+	report, err := Import(archive, ConflictSkip)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.DataSources.Skipped, check.DeepEquals, []string{"cpu"})
+	c.Assert(report.Actions.Skipped, check.DeepEquals, []string{"scale_up"})
+	c.Assert(report.Wizards.Skipped, check.DeepEquals, []string{"myinstance"})
+	c.Assert(report.Alarms.Skipped, check.DeepEquals, []string{"high", "scale_down_myinstance", "scale_up_myinstance"})
+	al, err := alarm.FindAlarmByName("high")
+	c.Assert(err, check.IsNil)
+	c.Assert(al.Expression, check.Equals, "true")
+}
+
+func (s *S) TestImportOverwrite(c *check.C) {
+	s.configure(c)
+	archive, err := Export(false)
+	c.Assert(err, check.IsNil)
+	archive = roundTrip(c, archive)
+	archive.Alarms[0].Expression = "false"
+	report, err := Import(archive, ConflictOverwrite)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.DataSources.Updated, check.DeepEquals, []string{"cpu"})
+	c.Assert(report.Actions.Updated, check.DeepEquals, []string{"scale_up"})
+	c.Assert(report.Wizards.Updated, check.DeepEquals, []string{"myinstance"})
+	c.Assert(report.Alarms.Updated, check.DeepEquals, []string{"high", "scale_down_myinstance", "scale_up_myinstance"})
+	al, err := alarm.FindAlarmByName("high")
+	c.Assert(err, check.IsNil)
+	c.Assert(al.Expression, check.Equals, "false")
+	ds, err := datasource.Get("cpu")
+	c.Assert(err, check.IsNil)
+	c.Assert(ds.Password, check.Equals, "p4ss")
+}
+
+func (s *S) TestImportFail(c *check.C) {
+	s.configure(c)
+	archive, err := Export(true)
+	c.Assert(err, check.IsNil)
+	archive.DataSources = append(archive.DataSources, datasource.DataSource{Name: "mem", URL: "http://tsuru.io", Method: "GET"})
+	_, err = Import(archive, ConflictFail)
+	c.Assert(err, check.FitsTypeOf, &ConflictError{})
+	c.Assert(err.(*ConflictError).Names, check.DeepEquals, []string{
+		"datasource cpu", "action scale_up", "alarm high", "alarm scale_down_myinstance", "alarm scale_up_myinstance", "wizard myinstance",
+	})
+	_, err = datasource.Get("mem")
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestImportRedactedSecrets(c *check.C) {
+	s.configure(c)
+	archive, err := Export(false)
+	c.Assert(err, check.IsNil)
+	s.conn.Clear()
+	report, err := Import(archive, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(report.DataSources.Failed, check.DeepEquals, []Failure{{Name: "cpu", Error: `datasource "cpu": redacted secrets must be set`}})
+	c.Assert(report.Actions.Failed, check.DeepEquals, []Failure{{Name: "scale_up", Error: `action "scale_up": redacted secrets must be set`}})
+	c.Assert(report.Alarms.Created, check.DeepEquals, []string{"high"})
+}
+
+func (s *S) TestImportInvalid(c *check.C) {
+	_, err := Import(&Archive{Version: 2}, "")
+	c.Assert(err, check.ErrorMatches, "backup: unsupported archive version 2")
+	_, err = Import(&Archive{Version: Version}, "merge")
+	c.Assert(err, check.ErrorMatches, `backup: invalid conflict strategy "merge", must be skip, overwrite or fail`)
+}
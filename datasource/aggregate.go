@@ -0,0 +1,66 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// aggregate gets the data of all the sources concurrently and merges it
+// into a JSON object, by source key. Data that is not valid JSON is kept as
+// a string.
+func (ds *DataSource) aggregate(appName string, envs map[string]string) (string, error) {
+	type result struct {
+		key   string
+		value interface{}
+		err   error
+	}
+	results := make(chan result, len(ds.Sources))
+	var wg sync.WaitGroup
+	for key, name := range ds.Sources {
+		wg.Add(1)
+		go func(key, name string) {
+			defer wg.Done()
+			source, err := Get(name)
+			if err != nil {
+				results <- result{key: key, err: err}
+				return
+			}
+			if source.Type == TypeAggregate {
+				results <- result{key: key, err: fmt.Errorf("datasource %q: aggregate data sources can not be nested", name)}
+				return
+			}
+			data, err := source.Get(appName, envs)
+			if err != nil {
+				results <- result{key: key, err: err}
+				return
+			}
+			value, err := decodeJSON(strings.NewReader(data))
+			if err != nil {
+				value = data
+			}
+			results <- result{key: key, value: value}
+		}(key, name)
+	}
+	wg.Wait()
+	close(results)
+	merged := map[string]interface{}{}
+	for r := range results {
+		if r.err != nil {
+			err := fmt.Errorf("datasource %q: source %q failed: %s", ds.Name, r.key, r.err)
+			logger().Error(err)
+			return "", err
+		}
+		merged[r.key] = r.value
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
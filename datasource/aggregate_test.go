@@ -0,0 +1,48 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestDataSourceGetAggregate(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cpu" {
+			w.Write([]byte(`{"app": "` + r.URL.Query().Get("app") + `", "value": 85}`))
+			return
+		}
+		w.Write([]byte(`3`))
+	}))
+	defer ts.Close()
+	err := New(&DataSource{Name: "cpu", URL: ts.URL + "/cpu?app={app}", Method: "GET"})
+	c.Assert(err, check.IsNil)
+	err = New(&DataSource{Name: "units", URL: ts.URL + "/units", Method: "GET"})
+	c.Assert(err, check.IsNil)
+	ds := DataSource{Name: "capacity", Type: TypeAggregate, Sources: map[string]string{"cpu": "cpu", "units": "units"}}
+	err = New(&ds)
+	c.Assert(err, check.IsNil)
+	result, err := ds.Get("myapp", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, `{"cpu":{"app":"myapp","value":85},"units":3}`)
+}
+
+func (s *S) TestDataSourceGetAggregateSourceFailure(c *check.C) {
+	ds := DataSource{Name: "capacity", Type: TypeAggregate, Sources: map[string]string{"cpu": "notfound"}}
+	_, err := ds.Get("myapp", nil)
+	c.Assert(err, check.ErrorMatches, `datasource "capacity": source "cpu" failed: datasource "notfound" not found`)
+}
+
+func (s *S) TestValidateAggregate(c *check.C) {
+	ds := DataSource{Name: "capacity", Type: TypeAggregate}
+	c.Assert(ds.Validate(), check.ErrorMatches, "datasource: sources required")
+	ds.Sources = map[string]string{"cpu-usage": "cpu", "self": "capacity", "units": "units"}
+	c.Assert(ds.Validate(), check.ErrorMatches, `datasource: invalid source key "cpu-usage": must be a valid javascript identifier; invalid source "capacity" for key "self"`)
+	ds.Sources = map[string]string{"cpu": "cpu"}
+	c.Assert(ds.Validate(), check.IsNil)
+}
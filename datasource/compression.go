@@ -0,0 +1,78 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// acceptEncoding is the Accept-Encoding header sent by data sources that do
+// not define one.
+const acceptEncoding = "gzip, deflate"
+
+// decompressedBody is a response body decoded according to its
+// Content-Encoding. The decoder is created on the first read, so empty
+// bodies do not fail.
+type decompressedBody struct {
+	body     io.ReadCloser
+	name     string
+	encoding string
+	reader   io.Reader
+	err      error
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	if b.reader == nil && b.err == nil {
+		b.reader, b.err = b.decoder()
+		if b.err != nil {
+			b.err = fmt.Errorf("datasource %q: invalid %s response: %s", b.name, b.encoding, b.err)
+		}
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.reader.Read(p)
+}
+
+func (b *decompressedBody) decoder() (io.Reader, error) {
+	if b.encoding == "gzip" {
+		return gzip.NewReader(b.body)
+	}
+	// deflate should be zlib wrapped, but some servers send raw deflate.
+	r := bufio.NewReader(b.body)
+	header, err := r.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(r)
+	}
+	return flate.NewReader(r), nil
+}
+
+func (b *decompressedBody) Close() error {
+	return b.body.Close()
+}
+
+// decompress replaces the response body with its decoded content when it is
+// gzip or deflate encoded.
+func (ds *DataSource) decompress(response *http.Response) {
+	encoding := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding")))
+	if encoding != "gzip" && encoding != "deflate" {
+		return
+	}
+	response.Body = &decompressedBody{body: response.Body, name: ds.Name, encoding: encoding}
+	response.Header.Del("Content-Encoding")
+	response.Header.Del("Content-Length")
+	response.ContentLength = -1
+	response.Uncompressed = true
+}
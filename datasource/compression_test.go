@@ -0,0 +1,60 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestGetCompressedResponse(c *check.C) {
+	var accepted string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted = r.Header.Get("Accept-Encoding")
+		encoding := r.URL.Query().Get("encoding")
+		w.Header().Set("Content-Encoding", encoding)
+		var writer io.WriteCloser
+		switch encoding {
+		case "gzip":
+			writer = gzip.NewWriter(w)
+		case "deflate":
+			writer = zlib.NewWriter(w)
+		default:
+			writer, _ = flate.NewWriter(w, flate.DefaultCompression)
+			w.Header().Set("Content-Encoding", "deflate")
+		}
+		writer.Write([]byte(`{"value": 42}`))
+		writer.Close()
+	}))
+	defer ts.Close()
+	for _, encoding := range []string{"gzip", "deflate", "raw"} {
+		ds := DataSource{Method: "GET", URL: ts.URL + "?encoding=" + encoding, Extract: "$.value", MaxResponseSize: 13}
+		result, err := ds.Get("app", nil)
+		c.Check(err, check.IsNil, check.Commentf(encoding))
+		c.Check(result, check.Equals, "42", check.Commentf(encoding))
+		c.Check(accepted, check.Equals, "gzip, deflate")
+	}
+	ds := DataSource{Method: "GET", URL: ts.URL + "?encoding=gzip", Headers: map[string]string{"Accept-Encoding": "gzip"}, MaxResponseSize: 12}
+	_, err := ds.Get("app", nil)
+	c.Assert(err, check.ErrorMatches, `.*response exceeds the limit of 12 bytes`)
+	c.Assert(accepted, check.Equals, "gzip")
+}
+
+func (s *S) TestGetInvalidCompressedResponse(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write([]byte(`{"value": 42}`))
+	}))
+	defer ts.Close()
+	ds := DataSource{Method: "GET", URL: ts.URL}
+	_, err := ds.Get("app", nil)
+	c.Assert(err, check.ErrorMatches, `datasource "": invalid gzip response: .*`)
+}
@@ -5,13 +5,18 @@
 package datasource
 
 import (
-	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/httpclient"
 	"github.com/tsuru/tsuru-autoscale/log"
 	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -21,7 +26,50 @@ func logger() *log.Logger {
 	return log.Log()
 }
 
-// DataSource represents a data source.
+const (
+	// DefaultTimeout is the timeout of data source requests when the data
+	// source does not define one.
+	DefaultTimeout = 30 * time.Second
+	// DefaultMaxResponseSize is the maximum size, in bytes, of data source
+	// responses when it is not configured.
+	DefaultMaxResponseSize = 10 << 20
+)
+
+// Data source types.
+const (
+	// TypeHTTP data sources execute plain HTTP requests. It is the default
+	// type.
+	TypeHTTP = "http"
+	// TypePrometheusRemoteRead data sources read series from Prometheus
+	// using the remote-read protocol. Body is the series selector and Range
+	// the time range read, in seconds.
+	TypePrometheusRemoteRead = "prometheus-remote-read"
+	// TypeElasticsearch data sources aggregate a field of an Elasticsearch
+	// index, defined by the Elasticsearch query, into a single value. URL is
+	// the Elasticsearch url.
+	TypeElasticsearch = "elasticsearch"
+	// TypeAggregate data sources get the data of other data sources,
+	// listed in Sources by key, and merge it into a single object.
+	TypeAggregate = "aggregate"
+	// TypeGRPC data sources execute the unary gRPC call defined by GRPC.
+	// Body is the request message, encoded as JSON, and URL the gRPC
+	// server, an http url for plaintext connections or an https one for
+	// TLS.
+	TypeGRPC = "grpc"
+	// TypePush data sources store the samples pushed to them, keeping the
+	// latest MaxSamples in their Range, in seconds.
+	TypePush = "push"
+)
+
+// DataSource represents a data source. FailoverURLs are used, in order,
+// when the request to URL fails. Timeout and RetryInterval are expressed in
+// seconds. When Extract is set, it is a JSONPath expression used
+// to select a single value from the response. ResponseSchema maps JSONPath
+// expressions to the JSON types the data must have. Format is the format of
+// the response, json, plain or csv, and Column the csv column selected.
+// Username and Password are the basic authentication credentials of the
+// requests. Transform is a javascript function body transforming the data,
+// available as data, into the value returned.
 type DataSource struct {
 	Name               string
 	URL                string
@@ -30,45 +78,119 @@ type DataSource struct {
 	Headers            map[string]string
 	Public             bool
 	ExpressionTemplate string
+	OAuth2             *OAuth2           `json:"oauth2,omitempty" bson:",omitempty"`
+	TLS                *httpclient.TLS   `json:"tls,omitempty" bson:",omitempty"`
+	Timeout            int               `json:"timeout,omitempty" bson:",omitempty"`
+	Retries            int               `json:"retries,omitempty" bson:",omitempty"`
+	RetryInterval      int               `json:"retry_interval,omitempty" bson:",omitempty"`
+	FailoverURLs       []string          `json:"failover_urls,omitempty" bson:",omitempty"`
+	Extract            string            `json:"extract,omitempty" bson:",omitempty"`
+	MaxResponseSize    int64             `json:"max_response_size,omitempty" bson:",omitempty"`
+	Pagination         *Pagination       `json:"pagination,omitempty" bson:",omitempty"`
+	Type               string            `json:"type,omitempty" bson:",omitempty"`
+	Range              int               `json:"range,omitempty" bson:",omitempty"`
+	Elasticsearch      *Elasticsearch    `json:"elasticsearch,omitempty" bson:",omitempty"`
+	ResponseSchema     map[string]string `json:"response_schema,omitempty" bson:",omitempty"`
+	Sources            map[string]string `json:"sources,omitempty" bson:",omitempty"`
+	Format             string            `json:"format,omitempty" bson:",omitempty"`
+	Column             string            `json:"column,omitempty" bson:",omitempty"`
+	GRPC               *GRPC             `json:"grpc,omitempty" bson:",omitempty"`
+	ProxyURL           string            `json:"proxy_url,omitempty" bson:",omitempty"`
+	Username           string            `json:"username,omitempty" bson:",omitempty"`
+	Password           string            `json:"password,omitempty" bson:",omitempty"`
+	MaxSamples         int               `json:"max_samples,omitempty" bson:",omitempty"`
+	Transform          string            `json:"transform,omitempty" bson:",omitempty"`
 }
 
-// New creates a new data source instance.
+// New validates and creates a new data source instance.
 func New(ds *DataSource) error {
-	if ds.URL == "" {
-		return errors.New("datasource: url required")
+	if err := ds.Validate(); err != nil {
+		return err
 	}
-	if ds.Method == "" {
-		return errors.New("datasource: method required")
+	encrypted, err := ds.encrypted()
+	if err != nil {
+		logger().Error(err)
+		return err
 	}
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return err
 	}
 	defer conn.Close()
-	return conn.DataSources().Insert(&ds)
+	return conn.DataSources().Insert(encrypted)
+}
+
+// Save validates and creates the data source, or replaces the existing one
+// with the same name, returning whether it was created. Redacted secrets,
+// like the ones of listed data sources, keep their stored values.
+func Save(ds *DataSource) (bool, error) {
+	existing, err := Get(ds.Name)
+	if err != nil && !db.IsNotFound(err) {
+		return false, err
+	}
+	if existing != nil {
+		ds.keepSecrets(existing)
+	}
+	if err = ds.Validate(); err != nil {
+		return false, err
+	}
+	if ds.hasRedactedSecrets() {
+		return false, fmt.Errorf("datasource %q: redacted secrets must be set", ds.Name)
+	}
+	encrypted, err := ds.encrypted()
+	if err != nil {
+		logger().Error(err)
+		return false, err
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return false, err
+	}
+	defer conn.Close()
+	info, err := conn.DataSources().Upsert(bson.M{"name": ds.Name}, encrypted)
+	if err != nil {
+		logger().Error(err)
+		return false, err
+	}
+	return info.UpsertedId != nil, nil
 }
 
 // FindBy returns a list of data sources filtered by "query".
 func FindBy(query bson.M) ([]DataSource, error) {
-	conn, err := db.Conn()
+	ds, _, err := List(query, nil)
+	return ds, err
+}
+
+// List returns the page of the data sources matching query selected by
+// opts and the total of data sources matching query and the filters.
+func List(query bson.M, opts *db.ListOptions) ([]DataSource, int, error) {
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
-		return nil, err
+		return nil, 0, err
 	}
 	defer conn.Close()
 	var ds []DataSource
-	err = conn.DataSources().Find(query).All(&ds)
+	total, err := db.List(conn.DataSources(), query, opts, &ds)
 	if err != nil {
 		logger().Error(err)
-		return nil, err
+		return nil, 0, err
 	}
-	return ds, nil
+	for i := range ds {
+		err = ds[i].decrypt()
+		if err != nil {
+			logger().Error(err)
+			return nil, 0, err
+		}
+	}
+	return ds, total, nil
 }
 
 // Get finds a data source by name.
 func Get(name string) (*DataSource, error) {
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return nil, err
@@ -78,50 +200,351 @@ func Get(name string) (*DataSource, error) {
 	err = conn.DataSources().Find(bson.M{"name": name}).One(&ds)
 	if err != nil {
 		if err == mgo.ErrNotFound {
-			err = fmt.Errorf("datasource %q not found", name)
+			err = &db.NotFoundError{Resource: "datasource", Name: name}
 		}
 		logger().Error(err)
 		return nil, err
 	}
+	err = ds.decrypt()
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
 	return &ds, nil
 }
 
-// Remove removes a data source.
+// Remove removes a data source. Data sources used by alarms, auto scales
+// or aggregate data sources can not be removed.
 func Remove(ds *DataSource) error {
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return err
 	}
 	defer conn.Close()
-	return conn.DataSources().Remove(ds)
+	refs, err := usage(conn, ds.Name)
+	if err != nil {
+		return err
+	}
+	if !refs.Empty() {
+		return &InUseError{Name: ds.Name, References: refs}
+	}
+	err = conn.DataSources().Remove(bson.M{"name": ds.Name})
+	if err != nil {
+		return err
+	}
+	err = removeSamples(ds.Name)
+	if err != nil {
+		return err
+	}
+	return removeStatus(ds.Name)
 }
 
-// Get tries to get the data from the data source.
-func (ds *DataSource) Get(appName string, envs map[string]string) (string, error) {
-	body := strings.Replace(ds.Body, "{app}", appName, -1)
-	url := strings.Replace(ds.URL, "{app}", appName, -1)
-	for key, value := range envs {
-		body = strings.Replace(body, fmt.Sprintf("{%s}", key), value, -1)
-		url = strings.Replace(url, fmt.Sprintf("{%s}", key), value, -1)
+var envPlaceholder = regexp.MustCompile(`\{env\.([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// allowedEnv returns whether the environment variable can be used in the
+// {env.NAME} placeholder. Only the variables listed in
+// AUTOSCALE_DATASOURCE_ENVS, separated by comma, are allowed.
+func allowedEnv(name string) bool {
+	for _, allowed := range strings.Split(os.Getenv("AUTOSCALE_DATASOURCE_ENVS"), ",") {
+		if strings.TrimSpace(allowed) == name {
+			return true
+		}
 	}
-	req, err := http.NewRequest(ds.Method, url, strings.NewReader(body))
+	return false
+}
+
+func expandEnv(s string) string {
+	return envPlaceholder.ReplaceAllStringFunc(s, func(placeholder string) string {
+		name := envPlaceholder.FindStringSubmatch(placeholder)[1]
+		if !allowedEnv(name) {
+			return placeholder
+		}
+		return os.Getenv(name)
+	})
+}
+
+// expandText executes text as a template and replaces its placeholders.
+// Besides {app} and the given envs, {now} is replaced by the current unix
+// timestamp and {env.NAME} by the value of the environment variable NAME.
+func expandText(text, appName string, envs map[string]string) (string, error) {
+	text, err := executeTemplate(text, appName, envs)
 	if err != nil {
 		return "", err
 	}
-	for key, value := range ds.Headers {
-		req.Header.Add(key, value)
+	text = strings.Replace(text, "{app}", appName, -1)
+	for key, value := range envs {
+		text = strings.Replace(text, fmt.Sprintf("{%s}", key), value, -1)
 	}
-	response, err := http.DefaultClient.Do(req)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	return expandEnv(strings.Replace(text, "{now}", now, -1)), nil
+}
+
+// expand returns the data source urls, the main one followed by the
+// failover ones, and body with their placeholders replaced. For
+// Elasticsearch data sources, they are the search urls and query.
+func (ds *DataSource) expand(appName string, envs map[string]string) ([]string, string, error) {
+	body, err := expandText(ds.Body, appName, envs)
+	if err != nil {
+		return nil, "", err
+	}
+	var urls []string
+	for _, u := range append([]string{ds.URL}, ds.FailoverURLs...) {
+		url, err := expandText(u, appName, envs)
+		if err != nil {
+			return nil, "", err
+		}
+		urls = append(urls, url)
+	}
+	if ds.Type == TypeElasticsearch {
+		query, err := ds.Elasticsearch.expanded(appName, envs)
+		if err != nil {
+			return nil, "", err
+		}
+		for i := range urls {
+			urls[i] = query.url(urls[i])
+		}
+		body, err = query.body()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return urls, body, nil
+}
+
+// Get tries to get the data from the data source. On each attempt, the
+// urls are tried in order until one of them succeeds. Aggregate data
+// sources get the data of their sources and push data sources return the
+// samples pushed to them.
+func (ds *DataSource) Get(appName string, envs map[string]string) (string, error) {
+	var data, served string
+	var err error
+	switch ds.Type {
+	case TypeAggregate:
+		data, err = ds.aggregate(appName, envs)
+	case TypePush:
+		data, err = ds.pushed()
+	default:
+		data, served, err = ds.request(appName, envs)
+	}
+	if err == nil {
+		err = ds.checkSchema(data)
+		if err != nil {
+			logger().Error(err)
+			data = ""
+		}
+	}
+	if err == nil && ds.Transform != "" {
+		data, err = ds.transform(data)
+	}
+	if ds.Name != "" {
+		if sErr := recordStatus(ds.Name, served, err); sErr != nil {
+			logger().Error(sErr)
+		}
+	}
+	return data, err
+}
+
+// request executes the data source request, with its retries and failover
+// urls, returning the data and the url that served it.
+func (ds *DataSource) request(appName string, envs map[string]string) (string, string, error) {
+	urls, body, err := ds.expand(appName, envs)
 	if err != nil {
 		logger().Error(err)
+		return "", "", err
+	}
+	client, err := ds.client()
+	if err != nil {
+		logger().Error(err)
+		return "", "", err
+	}
+	var data, served string
+	for attempt := 0; served == ""; attempt++ {
+		for _, url := range urls {
+			data, err = ds.fetch(client, url, body)
+			if err == nil {
+				served = url
+				break
+			}
+			logger().Printf("datasource %q - request to %s failed: %s", ds.Name, url, err)
+		}
+		if served != "" || attempt >= ds.Retries {
+			break
+		}
+		logger().Printf("datasource %q - attempt %d failed - retrying", ds.Name, attempt+1)
+		time.Sleep(time.Duration(ds.RetryInterval) * time.Second)
+	}
+	return data, served, err
+}
+
+// proxyURL returns the proxy used by the data source requests, defined by
+// the data source or by the environment variable
+// AUTOSCALE_DATASOURCE_PROXY_URL.
+func (ds *DataSource) proxyURL() string {
+	if ds.ProxyURL != "" {
+		return ds.ProxyURL
+	}
+	return os.Getenv("AUTOSCALE_DATASOURCE_PROXY_URL")
+}
+
+// client returns the HTTP client used by the data source, using the
+// data source timeout or DefaultTimeout.
+func (ds *DataSource) client() (*http.Client, error) {
+	c, err := httpclient.ProxyClient(ds.TLS, ds.proxyURL())
+	if err != nil {
+		return nil, err
+	}
+	client := *c
+	client.Timeout = DefaultTimeout
+	if ds.Timeout > 0 {
+		client.Timeout = time.Duration(ds.Timeout) * time.Second
+	}
+	return &client, nil
+}
+
+func (ds *DataSource) fetch(client *http.Client, url, body string) (string, error) {
+	switch ds.Type {
+	case TypePrometheusRemoteRead:
+		return ds.fetchRemoteRead(client, url, body)
+	case TypeElasticsearch:
+		return ds.fetchElasticsearch(client, url, body)
+	case TypeGRPC:
+		return ds.fetchGRPC(client, url, body)
+	}
+	if ds.Pagination != nil {
+		return ds.fetchPages(client, url, body)
+	}
+	response, err := ds.do(client, url, body)
+	if err != nil {
 		return "", err
 	}
 	defer response.Body.Close()
+	if response.StatusCode >= http.StatusInternalServerError {
+		err = fmt.Errorf("datasource %q: request failed with status %d", ds.Name, response.StatusCode)
+		logger().Error(err)
+		return "", err
+	}
+	if ds.Extract != "" && (ds.Format == "" || ds.Format == FormatJSON) {
+		return extract(response.Body, ds.Extract)
+	}
 	data, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		logger().Error(err)
 		return "", err
 	}
+	if ds.Format == FormatPlain || ds.Format == FormatCSV {
+		return ds.convert(data)
+	}
 	return string(data), nil
 }
+
+// maxResponseSize returns the maximum size of the data source responses,
+// defined by the data source or MaxResponseSize.
+func (ds *DataSource) maxResponseSize() int64 {
+	if ds.MaxResponseSize > 0 {
+		return ds.MaxResponseSize
+	}
+	return MaxResponseSize()
+}
+
+// MaxResponseSize returns the maximum size of the responses of the data
+// sources which do not define one, defined by the environment variable
+// AUTOSCALE_DATASOURCE_MAX_RESPONSE_SIZE or DefaultMaxResponseSize.
+func MaxResponseSize() int64 {
+	if size := os.Getenv("AUTOSCALE_DATASOURCE_MAX_RESPONSE_SIZE"); size != "" {
+		v, err := strconv.ParseInt(size, 10, 64)
+		if err == nil && v > 0 {
+			return v
+		}
+		logger().Printf("invalid AUTOSCALE_DATASOURCE_MAX_RESPONSE_SIZE %q", size)
+	}
+	return DefaultMaxResponseSize
+}
+
+// limitedBody is a response body that fails when more than limit bytes
+// are read from it.
+type limitedBody struct {
+	io.ReadCloser
+	name      string
+	limit     int64
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		return n, b.tooLarge()
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *limitedBody) tooLarge() error {
+	return fmt.Errorf("datasource %q: response exceeds the limit of %d bytes", b.name, b.limit)
+}
+
+// do executes a single request to the data source. The response body is
+// limited to the data source max response size and must be closed by the
+// caller.
+func (ds *DataSource) do(client *http.Client, url, body string) (*http.Response, error) {
+	req, err := ds.newRequest(client, ds.Method, url, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return ds.send(client, req)
+}
+
+// newRequest creates a request with the data source headers and
+// credentials, fetching the OAuth2 token with client.
+func (ds *DataSource) newRequest(client *http.Client, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range ds.Headers {
+		req.Header.Add(key, value)
+	}
+	if ds.Username != "" {
+		req.SetBasicAuth(ds.Username, ds.Password)
+	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	if ds.OAuth2 != nil {
+		err = ds.OAuth2.authorize(client, req)
+		if err != nil {
+			logger().Error(err)
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// send executes the request, decompressing gzip and deflate responses and
+// limiting the decompressed body to the data source max response size.
+func (ds *DataSource) send(client *http.Client, req *http.Request) (*http.Response, error) {
+	response, err := client.Do(req)
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	if response.StatusCode == http.StatusUnauthorized && ds.OAuth2 != nil {
+		ds.OAuth2.invalidate()
+	}
+	ds.decompress(response)
+	limit := ds.maxResponseSize()
+	limited := &limitedBody{ReadCloser: response.Body, name: ds.Name, limit: limit, remaining: limit}
+	if response.ContentLength > limit {
+		response.Body.Close()
+		err = limited.tooLarge()
+		logger().Error(err)
+		return nil, err
+	}
+	response.Body = limited
+	return response, nil
+}
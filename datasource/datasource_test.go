@@ -6,33 +6,32 @@ package datasource
 
 import (
 	"encoding/json"
-	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
-	"github.com/tsuru/tsuru-autoscale/db"
-	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru-autoscale/db/storagetest"
+	"github.com/tsuru/tsuru-autoscale/secret"
 	"gopkg.in/check.v1"
 )
 
 func Test(t *testing.T) { check.TestingT(t) }
 
 type S struct {
-	conn *db.Storage
+	conn *storagetest.Storage
 }
 
 func (s *S) SetUpSuite(c *check.C) {
-	err := os.Setenv("MONGODB_DATABASE_NAME", "tsuru_autoscale_datasource")
-	c.Assert(err, check.IsNil)
-	s.conn, err = db.Conn()
+	var err error
+	s.conn, err = storagetest.New("tsuru_autoscale_datasource")
 	c.Assert(err, check.IsNil)
 }
 
 func (s *S) TearDownTest(c *check.C) {
-	dbtest.ClearAllCollections(s.conn.DataSources().Database)
+	s.conn.Clear()
 }
 
 func (s *S) TearDownSuite(c *check.C) {
@@ -68,21 +67,193 @@ func (s *S) TestHttpDataSourceGet(c *check.C) {
 	c.Assert(data.Foo, check.Equals, "bar")
 }
 
+func (s *S) TestExpandPlaceholders(c *check.C) {
+	os.Setenv("AUTOSCALE_DATASOURCE_ENVS", "ES_INDEX")
+	os.Setenv("ES_INDEX", "metrics")
+	os.Setenv("SECRET", "secret")
+	defer os.Unsetenv("AUTOSCALE_DATASOURCE_ENVS")
+	defer os.Unsetenv("ES_INDEX")
+	defer os.Unsetenv("SECRET")
+	ds := DataSource{
+		URL:  "http://localhost/{env.ES_INDEX}/{env.SECRET}?app={app}&team={team}",
+		Body: `{"from": {now}, "pool": "{pool}", "interval": {interval}}`,
+	}
+	envs := map[string]string{"team": "myteam", "pool": "mypool", "interval": "10"}
+	before := time.Now().Unix()
+	urls, body, err := ds.expand("myapp", envs)
+	c.Assert(err, check.IsNil)
+	c.Assert(urls, check.DeepEquals, []string{"http://localhost/metrics/{env.SECRET}?app=myapp&team=myteam"})
+	var data struct {
+		From     int64
+		Pool     string
+		Interval int
+	}
+	err = json.Unmarshal([]byte(body), &data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data.From >= before, check.Equals, true)
+	c.Assert(data.Pool, check.Equals, "mypool")
+	c.Assert(data.Interval, check.Equals, 10)
+}
+
+func (s *S) TestHttpDataSourceGetRetries(c *check.C) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	ds := DataSource{Method: "GET", URL: ts.URL, Retries: 2}
+	result, err := ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "ok")
+	c.Assert(calls, check.Equals, 3)
+	calls = 0
+	ds.Retries = 1
+	_, err = ds.Get("app", nil)
+	c.Assert(err, check.NotNil)
+	c.Assert(calls, check.Equals, 2)
+}
+
+func (s *S) TestHttpDataSourceGetFailover(c *check.C) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Query().Get("app")))
+	}))
+	defer ts.Close()
+	ds := DataSource{
+		Method:       "GET",
+		URL:          "http://127.0.0.1:1",
+		FailoverURLs: []string{failing.URL, ts.URL + "?app={app}"},
+	}
+	result, err := ds.Get("myapp", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "myapp")
+	ds.FailoverURLs = ds.FailoverURLs[:1]
+	_, err = ds.Get("myapp", nil)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestHttpDataSourceGetTimeout(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+	}))
+	defer ts.Close()
+	ds := DataSource{Method: "GET", URL: ts.URL, Timeout: 1}
+	_, err := ds.Get("app", nil)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestHttpDataSourceGetMaxResponseSize(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chunked") != "" {
+			w.Write([]byte(`{"value": `))
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte(`"0123456789"}`))
+	}))
+	defer ts.Close()
+	var tests = []struct {
+		url     string
+		size    int64
+		extract string
+		err     bool
+	}{
+		{ts.URL, 13, "", false},
+		{ts.URL, 12, "", true},
+		{ts.URL + "?chunked=1", 23, "", false},
+		{ts.URL + "?chunked=1", 22, "", true},
+		{ts.URL + "?chunked=1", 22, "$.value", true},
+		{ts.URL + "?chunked=1", 23, "$.value", false},
+	}
+	for _, tt := range tests {
+		ds := DataSource{Method: "GET", URL: tt.url, MaxResponseSize: tt.size, Extract: tt.extract}
+		_, err := ds.Get("app", nil)
+		c.Check(err != nil, check.Equals, tt.err, check.Commentf("%#v", tt))
+	}
+}
+
+func (s *S) TestHttpDataSourceGetBasicAuth(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "user" || password != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"value": 1}`))
+	}))
+	defer ts.Close()
+	ds := DataSource{Method: "GET", URL: ts.URL, Username: "user", Password: "pass", Extract: "$.value"}
+	result, err := ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "1")
+}
+
+func (s *S) TestMaxResponseSize(c *check.C) {
+	ds := DataSource{}
+	c.Assert(ds.maxResponseSize(), check.Equals, int64(DefaultMaxResponseSize))
+	os.Setenv("AUTOSCALE_DATASOURCE_MAX_RESPONSE_SIZE", "1024")
+	defer os.Unsetenv("AUTOSCALE_DATASOURCE_MAX_RESPONSE_SIZE")
+	c.Assert(ds.maxResponseSize(), check.Equals, int64(1024))
+	ds.MaxResponseSize = 10
+	c.Assert(ds.maxResponseSize(), check.Equals, int64(10))
+}
+
 func (s *S) TestNew(c *check.C) {
 	dsConfigTests := []struct {
 		conf *DataSource
-		err  error
+		err  string
 	}{
-		{&DataSource{URL: "http://tsuru.io", Method: "GET"}, nil},
-		{&DataSource{URL: "http://tsuru.io"}, errors.New("datasource: method required")},
-		{&DataSource{Method: ""}, errors.New("datasource: url required")},
+		{&DataSource{Name: "get", URL: "http://tsuru.io", Method: "GET"}, ""},
+		{&DataSource{URL: "http://tsuru.io"}, "datasource: method required"},
+		{&DataSource{Method: "GET"}, "datasource: url required"},
+		{&DataSource{Method: ""}, "datasource: url required; method required"},
+		{&DataSource{URL: "http://tsuru.io", Method: "GET", Retries: -1}, "datasource: timeout, retries and retry_interval must not be negative"},
+		{&DataSource{Name: "remote_read", URL: "http://tsuru.io", Type: TypePrometheusRemoteRead, Body: "cpu"}, ""},
+		{&DataSource{URL: "http://tsuru.io", Type: TypePrometheusRemoteRead}, "datasource: body required"},
+		{&DataSource{URL: "http://tsuru.io", Type: TypePrometheusRemoteRead, Body: "cpu", Range: -1}, "datasource: range must not be negative"},
+		{&DataSource{Name: "elasticsearch", URL: "http://tsuru.io", Type: TypeElasticsearch, Elasticsearch: &Elasticsearch{Index: "metrics", Aggregation: "count"}}, ""},
+		{&DataSource{URL: "http://tsuru.io", Type: TypeElasticsearch}, "datasource: elasticsearch required"},
+		{&DataSource{URL: "http://tsuru.io", Method: "GET", Type: "ftp"}, `datasource: invalid type "ftp"`},
 	}
 	for _, tt := range dsConfigTests {
 		err := New(tt.conf)
-		c.Check(err, check.DeepEquals, tt.err)
+		if tt.err == "" {
+			c.Check(err, check.IsNil)
+			continue
+		}
+		c.Check(err, check.NotNil)
+		if err != nil {
+			c.Check(err.Error(), check.Equals, tt.err)
+		}
 	}
 }
 
+func (s *S) TestSave(c *check.C) {
+	ds := DataSource{Name: "ds", URL: "http://tsuru.io", Method: "GET", Username: "user", Password: "s3cr3t"}
+	created, err := Save(&ds)
+	c.Assert(err, check.IsNil)
+	c.Assert(created, check.Equals, true)
+	ds = DataSource{Name: "ds", URL: "http://tsuru.io/v2", Method: "GET", Username: "user", Password: secret.Redacted}
+	created, err = Save(&ds)
+	c.Assert(err, check.IsNil)
+	c.Assert(created, check.Equals, false)
+	r, err := Get("ds")
+	c.Assert(err, check.IsNil)
+	c.Assert(r.URL, check.Equals, "http://tsuru.io/v2")
+	c.Assert(r.Password, check.Equals, "s3cr3t")
+	_, err = Save(&DataSource{Name: "other", URL: "http://tsuru.io", Method: "GET", Username: "user", Password: secret.Redacted})
+	c.Assert(err, check.ErrorMatches, `datasource "other": redacted secrets must be set`)
+	_, err = Save(&DataSource{Name: "other", URL: "http://tsuru.io"})
+	c.Assert(err, check.ErrorMatches, "datasource: method required")
+}
+
 func (s *S) TestGet(c *check.C) {
 	ds := DataSource{
 		Name:    "xpto",
@@ -0,0 +1,218 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultElasticsearchVersion is the Elasticsearch major version used
+	// when the data source does not define one.
+	DefaultElasticsearchVersion = 7
+	// DefaultWindow is the time window, in seconds, aggregated by
+	// Elasticsearch data sources when they do not define one.
+	DefaultWindow = 300
+)
+
+var esAggregations = map[string]bool{
+	"avg":   true,
+	"max":   true,
+	"min":   true,
+	"sum":   true,
+	"count": true,
+}
+
+// Elasticsearch represents the query of an Elasticsearch data source: the
+// Aggregation (avg, max, min, sum or count) of Field over the documents of
+// Index in the last Window seconds, filtered by the term Filters. The count
+// aggregation counts the documents and does not require a field. The index,
+// aggregation and filter values accept placeholders.
+type Elasticsearch struct {
+	Index       string            `json:"index"`
+	Field       string            `json:"field,omitempty" bson:",omitempty"`
+	Aggregation string            `json:"aggregation"`
+	TimeField   string            `json:"time_field,omitempty" bson:",omitempty"`
+	Window      int               `json:"window,omitempty" bson:",omitempty"`
+	Filters     map[string]string `json:"filters,omitempty" bson:",omitempty"`
+	Version     int               `json:"version,omitempty" bson:",omitempty"`
+}
+
+func (e *Elasticsearch) validate() error {
+	if e.Index == "" {
+		return errors.New("datasource: elasticsearch index required")
+	}
+	if e.Aggregation == "" {
+		return errors.New("datasource: elasticsearch aggregation required")
+	}
+	if !strings.Contains(e.Aggregation, "{") {
+		if err := e.validateAggregation(e.Aggregation); err != nil {
+			return err
+		}
+	}
+	if e.Window < 0 {
+		return errors.New("datasource: elasticsearch window must not be negative")
+	}
+	switch e.Version {
+	case 0, 6, 7, 8:
+	default:
+		return fmt.Errorf("datasource: unsupported elasticsearch version %d", e.Version)
+	}
+	return nil
+}
+
+func (e *Elasticsearch) validateAggregation(aggregation string) error {
+	if !esAggregations[aggregation] {
+		return fmt.Errorf("datasource: invalid elasticsearch aggregation %q", aggregation)
+	}
+	if aggregation != "count" && e.Field == "" {
+		return errors.New("datasource: elasticsearch field required")
+	}
+	return nil
+}
+
+func (e *Elasticsearch) version() int {
+	if e.Version > 0 {
+		return e.Version
+	}
+	return DefaultElasticsearchVersion
+}
+
+// expanded returns a copy of the query with the placeholders of the index,
+// aggregation and filter values replaced.
+func (e *Elasticsearch) expanded(appName string, envs map[string]string) (*Elasticsearch, error) {
+	q := *e
+	var err error
+	for _, field := range []*string{&q.Index, &q.Aggregation} {
+		*field, err = expandText(*field, appName, envs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	q.Filters = make(map[string]string, len(e.Filters))
+	for key, value := range e.Filters {
+		q.Filters[key], err = expandText(value, appName, envs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &q, q.validateAggregation(q.Aggregation)
+}
+
+// url returns the search url of the index.
+func (e *Elasticsearch) url(baseURL string) string {
+	return strings.TrimRight(baseURL, "/") + "/" + e.Index + "/_search"
+}
+
+// body returns the search request body. Elasticsearch 7 and later only
+// count all the matching documents when track_total_hits is set.
+func (e *Elasticsearch) body() (string, error) {
+	timeField := e.TimeField
+	if timeField == "" {
+		timeField = "@timestamp"
+	}
+	window := e.Window
+	if window == 0 {
+		window = DefaultWindow
+	}
+	filters := []interface{}{
+		map[string]interface{}{
+			"range": map[string]interface{}{
+				timeField: map[string]string{"gte": fmt.Sprintf("now-%ds", window), "lte": "now"},
+			},
+		},
+	}
+	var keys []string
+	for key := range e.Filters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]string{key: e.Filters[key]},
+		})
+	}
+	query := map[string]interface{}{
+		"size":  0,
+		"query": map[string]interface{}{"bool": map[string]interface{}{"filter": filters}},
+	}
+	if e.Aggregation == "count" {
+		if e.version() >= 7 {
+			query["track_total_hits"] = true
+		}
+	} else {
+		query["aggs"] = map[string]interface{}{
+			"value": map[string]interface{}{
+				e.Aggregation: map[string]string{"field": e.Field},
+			},
+		}
+	}
+	data, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// esValue returns the aggregated value of the search response: the value
+// aggregation or, for the count aggregation, the total hits. Elasticsearch 6
+// returns the total hits as a number, and later versions as an object.
+func esValue(response interface{}) (interface{}, error) {
+	if value, err := lookup(response, "$.aggregations.value.value"); err == nil {
+		return value, nil
+	}
+	total, err := lookup(response, "$.hits.total")
+	if err != nil {
+		return nil, err
+	}
+	if object, ok := total.(map[string]interface{}); ok {
+		return object["value"], nil
+	}
+	return total, nil
+}
+
+// fetchElasticsearch executes the search and returns the aggregated value.
+func (ds *DataSource) fetchElasticsearch(client *http.Client, url, body string) (string, error) {
+	req, err := ds.newRequest(client, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	response, err := ds.send(client, req)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		err = fmt.Errorf("datasource %q: request failed with status %d", ds.Name, response.StatusCode)
+		logger().Error(err)
+		return "", err
+	}
+	value, err := decodeJSON(response.Body)
+	if err != nil {
+		logger().Error(err)
+		return "", err
+	}
+	value, err = esValue(value)
+	if err != nil {
+		logger().Error(err)
+		return "", err
+	}
+	if value == nil {
+		err = fmt.Errorf("datasource %q: no data in the time window", ds.Name)
+		logger().Error(err)
+		return "", err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
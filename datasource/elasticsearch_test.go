@@ -0,0 +1,104 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestElasticsearchValidate(c *check.C) {
+	tests := []struct {
+		query Elasticsearch
+		err   string
+	}{
+		{Elasticsearch{Index: "metrics", Field: "cpu", Aggregation: "max"}, ""},
+		{Elasticsearch{Index: "metrics", Aggregation: "count", Version: 6}, ""},
+		{Elasticsearch{Index: "metrics", Field: "cpu", Aggregation: "{aggregator}"}, ""},
+		{Elasticsearch{Field: "cpu", Aggregation: "max"}, "datasource: elasticsearch index required"},
+		{Elasticsearch{Index: "metrics", Field: "cpu"}, "datasource: elasticsearch aggregation required"},
+		{Elasticsearch{Index: "metrics", Field: "cpu", Aggregation: "median"}, `datasource: invalid elasticsearch aggregation "median"`},
+		{Elasticsearch{Index: "metrics", Aggregation: "avg"}, "datasource: elasticsearch field required"},
+		{Elasticsearch{Index: "metrics", Aggregation: "count", Window: -1}, "datasource: elasticsearch window must not be negative"},
+		{Elasticsearch{Index: "metrics", Aggregation: "count", Version: 5}, "datasource: unsupported elasticsearch version 5"},
+	}
+	for _, tt := range tests {
+		err := tt.query.validate()
+		if tt.err == "" {
+			c.Check(err, check.IsNil)
+		} else {
+			c.Check(err, check.ErrorMatches, tt.err)
+		}
+	}
+}
+
+func (s *S) TestElasticsearchBody(c *check.C) {
+	query := Elasticsearch{Index: "metrics", Field: "value", Aggregation: "max", Filters: map[string]string{"process": "web", "app": "myapp"}}
+	body, err := query.body()
+	c.Assert(err, check.IsNil)
+	c.Assert(body, check.Equals, `{"aggs":{"value":{"max":{"field":"value"}}},"query":{"bool":{"filter":[{"range":{"@timestamp":{"gte":"now-300s","lte":"now"}}},{"term":{"app":"myapp"}},{"term":{"process":"web"}}]}},"size":0}`)
+	query = Elasticsearch{Index: "metrics", Aggregation: "count", TimeField: "time", Window: 60}
+	body, err = query.body()
+	c.Assert(err, check.IsNil)
+	c.Assert(body, check.Equals, `{"query":{"bool":{"filter":[{"range":{"time":{"gte":"now-60s","lte":"now"}}}]}},"size":0,"track_total_hits":true}`)
+	query.Version = 6
+	body, err = query.body()
+	c.Assert(err, check.IsNil)
+	c.Assert(body, check.Equals, `{"query":{"bool":{"filter":[{"range":{"time":{"gte":"now-60s","lte":"now"}}}]}},"size":0}`)
+}
+
+func (s *S) TestDataSourceGetElasticsearch(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.URL.Path, check.Equals, "/metrics-myapp/_search")
+		c.Check(r.Header.Get("Content-Type"), check.Equals, "application/json")
+		body, _ := ioutil.ReadAll(r.Body)
+		c.Check(string(body), check.Equals, `{"aggs":{"value":{"avg":{"field":"cpu"}}},"query":{"bool":{"filter":[{"range":{"@timestamp":{"gte":"now-300s","lte":"now"}}},{"term":{"process":"web"}}]}},"size":0}`)
+		w.Write([]byte(`{"hits": {"total": {"value": 10, "relation": "eq"}}, "aggregations": {"value": {"value": 42.5}}}`))
+	}))
+	defer ts.Close()
+	ds := DataSource{
+		URL:  ts.URL + "/",
+		Type: TypeElasticsearch,
+		Elasticsearch: &Elasticsearch{
+			Index:       "metrics-{app}",
+			Field:       "cpu",
+			Aggregation: "{aggregator}",
+			Filters:     map[string]string{"process": "{process}"},
+		},
+	}
+	result, err := ds.Get("myapp", map[string]string{"aggregator": "avg", "process": "web"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "42.5")
+	_, err = ds.Get("myapp", map[string]string{"aggregator": "median", "process": "web"})
+	c.Assert(err, check.ErrorMatches, `datasource: invalid elasticsearch aggregation "median"`)
+}
+
+func (s *S) TestDataSourceGetElasticsearchCount(c *check.C) {
+	responses := []string{`{"hits": {"total": 7, "hits": []}}`, `{"hits": {"total": {"value": 8, "relation": "eq"}}}`}
+	for i, response := range responses {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(response))
+		}))
+		ds := DataSource{URL: ts.URL, Type: TypeElasticsearch, Elasticsearch: &Elasticsearch{Index: "logs", Aggregation: "count"}}
+		result, err := ds.Get("myapp", nil)
+		c.Check(err, check.IsNil)
+		c.Check(result, check.Equals, []string{"7", "8"}[i])
+		ts.Close()
+	}
+}
+
+func (s *S) TestDataSourceGetElasticsearchNoData(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"hits": {"total": {"value": 0}}, "aggregations": {"value": {"value": null}}}`))
+	}))
+	defer ts.Close()
+	ds := DataSource{URL: ts.URL, Type: TypeElasticsearch, Elasticsearch: &Elasticsearch{Index: "metrics", Field: "cpu", Aggregation: "max"}}
+	_, err := ds.Get("myapp", nil)
+	c.Assert(err, check.ErrorMatches, `datasource "": no data in the time window`)
+}
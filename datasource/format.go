@@ -0,0 +1,120 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Response formats.
+const (
+	// FormatJSON responses are used as they are. It is the default format.
+	FormatJSON = "json"
+	// FormatPlain responses are a bare value, converted to a JSON number
+	// when it is numeric and to a JSON string otherwise.
+	FormatPlain = "plain"
+	// FormatCSV responses are converted to an array with an object for each
+	// row, using the first row as header. When Column is set, they are
+	// converted to an array with the values of the column, selected by its
+	// header or its index.
+	FormatCSV = "csv"
+)
+
+var formats = map[string]bool{
+	"":          true,
+	FormatJSON:  true,
+	FormatPlain: true,
+	FormatCSV:   true,
+}
+
+// parseValue converts a plain text value to a JSON number when it is
+// numeric.
+func parseValue(text string) interface{} {
+	text = strings.TrimSpace(text)
+	if _, err := strconv.ParseFloat(text, 64); err == nil {
+		return json.Number(text)
+	}
+	return text
+}
+
+// parseCSV converts a CSV document to the rows objects, or to the values of
+// the column when it is set.
+func parseCSV(data []byte, column string) (interface{}, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return []interface{}{}, nil
+	}
+	header, rows := records[0], records[1:]
+	if column == "" {
+		result := make([]interface{}, len(rows))
+		for i, row := range rows {
+			object := map[string]interface{}{}
+			for j, value := range row {
+				if j < len(header) {
+					object[header[j]] = parseValue(value)
+				}
+			}
+			result[i] = object
+		}
+		return result, nil
+	}
+	index := -1
+	for i, name := range header {
+		if strings.TrimSpace(name) == column {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		if i, err := strconv.Atoi(column); err == nil && i >= 0 && i < len(header) {
+			index = i
+		}
+	}
+	if index == -1 {
+		return nil, fmt.Errorf("datasource: csv column %q not found", column)
+	}
+	result := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if index < len(row) {
+			result = append(result, parseValue(row[index]))
+		}
+	}
+	return result, nil
+}
+
+// convert converts a plain or CSV response to JSON, applying the data source
+// extract expression.
+func (ds *DataSource) convert(data []byte) (string, error) {
+	var value interface{}
+	var err error
+	switch ds.Format {
+	case FormatPlain:
+		value = parseValue(string(data))
+	case FormatCSV:
+		value, err = parseCSV(data, ds.Column)
+		if err != nil {
+			return "", err
+		}
+	}
+	if ds.Extract != "" {
+		value, err = lookup(value, ds.Extract)
+		if err != nil {
+			return "", err
+		}
+	}
+	result, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestDataSourceGetPlainFormat(c *check.C) {
+	for body, expected := range map[string]string{"42.5\n": "42.5", " OK ": `"OK"`} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		ds := DataSource{URL: ts.URL, Method: "GET", Format: FormatPlain}
+		result, err := ds.Get("app", nil)
+		c.Check(err, check.IsNil)
+		c.Check(result, check.Equals, expected)
+		ts.Close()
+	}
+}
+
+func (s *S) TestDataSourceGetCSVFormat(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("time,host,cpu\n1500000000,web-1,50\n1500000060,web-1,85.5\n"))
+	}))
+	defer ts.Close()
+	ds := DataSource{URL: ts.URL, Method: "GET", Format: FormatCSV}
+	result, err := ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, `[{"cpu":50,"host":"web-1","time":1500000000},{"cpu":85.5,"host":"web-1","time":1500000060}]`)
+	ds.Column = "cpu"
+	result, err = ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, `[50,85.5]`)
+	ds.Column = "1"
+	ds.Extract = "$[-1]"
+	result, err = ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, `"web-1"`)
+	ds.Column = "memory"
+	_, err = ds.Get("app", nil)
+	c.Assert(err, check.ErrorMatches, `datasource: csv column "memory" not found`)
+}
+
+func (s *S) TestValidateFormat(c *check.C) {
+	ds := DataSource{URL: "http://tsuru.io", Method: "GET", Format: "xml"}
+	c.Assert(ds.Validate(), check.ErrorMatches, `datasource: invalid format "xml"`)
+	ds = DataSource{URL: "http://tsuru.io", Method: "GET", Format: FormatCSV, Pagination: &Pagination{}}
+	c.Assert(ds.Validate(), check.ErrorMatches, `datasource: format "csv" is only supported by http data sources without pagination`)
+	ds = DataSource{URL: "http://tsuru.io", Method: "GET", Column: "cpu"}
+	c.Assert(ds.Validate(), check.ErrorMatches, `datasource: column requires the csv format`)
+}
@@ -0,0 +1,286 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+	"github.com/jhump/protoreflect/grpcreflect"
+	"github.com/tsuru/tsuru-autoscale/httpclient"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// GRPC represents the unary gRPC call of a gRPC data source. Method is the
+// full method name, e.g. package.Service/Method. Descriptor is the base64
+// encoded FileDescriptorSet with the service, generated by protoc
+// --include_imports --descriptor_set_out, and when it is empty the service
+// is resolved using the server reflection of the gRPC server. The data
+// source body is the request message, encoded as JSON.
+type GRPC struct {
+	Descriptor string `json:"descriptor,omitempty"`
+	Method     string `json:"method"`
+}
+
+var descriptors = struct {
+	sync.Mutex
+	cache map[[sha256.Size]byte]map[string]*desc.FileDescriptor
+}{cache: map[[sha256.Size]byte]map[string]*desc.FileDescriptor{}}
+
+// files returns the files of the descriptor, caching them by its content.
+func (g *GRPC) files() (map[string]*desc.FileDescriptor, error) {
+	key := sha256.Sum256([]byte(g.Descriptor))
+	descriptors.Lock()
+	defer descriptors.Unlock()
+	if files, ok := descriptors.cache[key]; ok {
+		return files, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(g.Descriptor)
+	if err != nil {
+		return nil, fmt.Errorf("datasource: invalid grpc descriptor: %s", err)
+	}
+	var set dpb.FileDescriptorSet
+	err = proto.Unmarshal(data, &set)
+	if err != nil {
+		return nil, fmt.Errorf("datasource: invalid grpc descriptor: %s", err)
+	}
+	files, err := desc.CreateFileDescriptors(set.File)
+	if err != nil {
+		return nil, fmt.Errorf("datasource: invalid grpc descriptor: %s", err)
+	}
+	descriptors.cache[key] = files
+	return files, nil
+}
+
+// methodName returns the service and method names of Method.
+func (g *GRPC) methodName() (string, string, error) {
+	name := strings.TrimPrefix(g.Method, "/")
+	i := strings.LastIndex(name, "/")
+	if i <= 0 || i == len(name)-1 {
+		return "", "", fmt.Errorf("datasource: invalid grpc method %q, it must be package.Service/Method", g.Method)
+	}
+	return name[:i], name[i+1:], nil
+}
+
+// service returns the descriptor of the service, found in Descriptor.
+func (g *GRPC) service(name string) (*desc.ServiceDescriptor, error) {
+	files, err := g.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if service := file.FindService(name); service != nil {
+			return service, nil
+		}
+	}
+	return nil, nil
+}
+
+// reflectService returns the descriptor of the service, resolved using the
+// server reflection of the server of conn.
+func reflectService(ctx context.Context, conn *grpc.ClientConn, name string) (*desc.ServiceDescriptor, error) {
+	client := grpcreflect.NewClient(ctx, rpb.NewServerReflectionClient(conn))
+	defer client.Reset()
+	service, err := client.ResolveService(name)
+	if err != nil {
+		if grpcreflect.IsElementNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("datasource: grpc server reflection failed: %s", err)
+	}
+	return service, nil
+}
+
+// unaryMethod returns the descriptor of the unary method of service,
+// which may be nil.
+func (g *GRPC) unaryMethod(service *desc.ServiceDescriptor, name string) (*desc.MethodDescriptor, error) {
+	var method *desc.MethodDescriptor
+	if service != nil {
+		method = service.FindMethodByName(name)
+	}
+	if method == nil {
+		if g.Descriptor == "" {
+			return nil, fmt.Errorf("datasource: grpc method %q not found by the server reflection", g.Method)
+		}
+		return nil, fmt.Errorf("datasource: grpc method %q not found in the descriptor", g.Method)
+	}
+	if method.IsClientStreaming() || method.IsServerStreaming() {
+		return nil, fmt.Errorf("datasource: grpc method %q is not unary", g.Method)
+	}
+	return method, nil
+}
+
+// method returns the descriptor of the method, found in Descriptor or, when
+// it is empty, using the server reflection of the server of conn.
+func (g *GRPC) method(ctx context.Context, conn *grpc.ClientConn) (*desc.MethodDescriptor, error) {
+	serviceName, methodName, err := g.methodName()
+	if err != nil {
+		return nil, err
+	}
+	var service *desc.ServiceDescriptor
+	if g.Descriptor != "" {
+		service, err = g.service(serviceName)
+	} else {
+		service, err = reflectService(ctx, conn, serviceName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g.unaryMethod(service, methodName)
+}
+
+// validate checks the method name and, when the descriptor is set, that it
+// defines the method. Without the descriptor, the method is checked by the
+// calls.
+func (g *GRPC) validate() error {
+	if g.Method == "" {
+		return errors.New("datasource: grpc method required")
+	}
+	serviceName, methodName, err := g.methodName()
+	if err != nil || g.Descriptor == "" {
+		return err
+	}
+	service, err := g.service(serviceName)
+	if err != nil {
+		return err
+	}
+	_, err = g.unaryMethod(service, methodName)
+	return err
+}
+
+// grpcTarget returns the address of the gRPC server of the data source url,
+// an http url for plaintext connections or an https one for TLS.
+func grpcTarget(rawURL string) (string, bool, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false, fmt.Errorf("datasource: invalid grpc url %q, it must be an absolute http or https url", rawURL)
+	}
+	secure := u.Scheme == "https"
+	if u.Port() != "" {
+		return u.Host, secure, nil
+	}
+	port := "80"
+	if secure {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port), secure, nil
+}
+
+// grpcMetadata returns the metadata of the calls, with the data source
+// headers and credentials, fetching the OAuth2 token with client.
+func (ds *DataSource) grpcMetadata(client *http.Client, rawURL string) (metadata.MD, error) {
+	req, err := ds.newRequest(client, http.MethodPost, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Del("Accept-Encoding")
+	md := metadata.MD{}
+	for key, values := range req.Header {
+		key = strings.ToLower(key)
+		md[key] = append(md[key], values...)
+	}
+	return md, nil
+}
+
+// fetchGRPC executes the unary call on the gRPC server of baseURL and
+// returns the response message encoded as JSON, using the proto3 field
+// names.
+func (ds *DataSource) fetchGRPC(client *http.Client, baseURL, body string) (string, error) {
+	target, secure, err := grpcTarget(baseURL)
+	if err != nil {
+		logger().Error(err)
+		return "", err
+	}
+	md, err := ds.grpcMetadata(client, baseURL)
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	if client.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.Timeout)
+		defer cancel()
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+	opts := []grpc.DialOption{grpc.WithBlock()}
+	if secure {
+		config, err := httpclient.TLSConfig(ds.TLS)
+		if err != nil {
+			logger().Error(err)
+			return "", err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	conn, err := grpc.DialContext(ctx, target, opts...)
+	if err != nil {
+		err = fmt.Errorf("datasource %q: grpc connection to %s failed: %s", ds.Name, target, err)
+		logger().Error(err)
+		return "", err
+	}
+	defer conn.Close()
+	method, err := ds.GRPC.method(ctx, conn)
+	if err != nil {
+		logger().Error(err)
+		return "", err
+	}
+	if strings.TrimSpace(body) == "" {
+		body = "{}"
+	}
+	request := dynamic.NewMessage(method.GetInputType())
+	err = request.UnmarshalJSON([]byte(body))
+	if err != nil {
+		return "", fmt.Errorf("datasource %q: invalid grpc request: %s", ds.Name, err)
+	}
+	stub := grpcdynamic.NewStub(conn)
+	reply, err := stub.InvokeRpc(ctx, method, request, grpc.MaxCallRecvMsgSize(int(ds.maxResponseSize())))
+	if err != nil {
+		if s, ok := status.FromError(err); ok {
+			if s.Code() == codes.Unauthenticated && ds.OAuth2 != nil {
+				ds.OAuth2.invalidate()
+			}
+			err = fmt.Errorf("datasource %q: grpc call failed with status %d: %s", ds.Name, s.Code(), s.Message())
+		} else {
+			err = fmt.Errorf("datasource %q: grpc call failed: %s", ds.Name, err)
+		}
+		logger().Error(err)
+		return "", err
+	}
+	message, err := dynamic.AsDynamicMessage(reply)
+	if err != nil {
+		logger().Error(err)
+		return "", err
+	}
+	data, err := message.MarshalJSONPB(&jsonpb.Marshaler{OrigName: true})
+	if err != nil {
+		logger().Error(err)
+		return "", err
+	}
+	return extractValue(json.RawMessage(data), ds.Extract)
+}
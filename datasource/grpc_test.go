@@ -0,0 +1,204 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"net"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"gopkg.in/check.v1"
+)
+
+func protoField(name string, number int32, label dpb.FieldDescriptorProto_Label, kind dpb.FieldDescriptorProto_Type, typeName string) *dpb.FieldDescriptorProto {
+	field := &dpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Label:  label.Enum(),
+		Type:   kind.Enum(),
+	}
+	if typeName != "" {
+		field.TypeName = proto.String(typeName)
+	}
+	return field
+}
+
+// metricsFile returns the descriptor of:
+//
+//	syntax = "proto3";
+//	package metrics;
+//	enum Status { UNKNOWN = 0; OK = 1; }
+//	message CPURequest { string app = 1; int64 window = 2; }
+//	message CPUReply { double value = 1; repeated double samples = 2; Status status = 3; }
+//	service Metrics {
+//	  rpc CPU(CPURequest) returns (CPUReply);
+//	  rpc WatchCPU(CPURequest) returns (stream CPUReply);
+//	}
+func metricsFile() *dpb.FileDescriptorProto {
+	optional, repeated := dpb.FieldDescriptorProto_LABEL_OPTIONAL, dpb.FieldDescriptorProto_LABEL_REPEATED
+	return &dpb.FileDescriptorProto{
+		Name:    proto.String("metrics.proto"),
+		Package: proto.String("metrics"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*dpb.DescriptorProto{
+			{
+				Name: proto.String("CPURequest"),
+				Field: []*dpb.FieldDescriptorProto{
+					protoField("app", 1, optional, dpb.FieldDescriptorProto_TYPE_STRING, ""),
+					protoField("window", 2, optional, dpb.FieldDescriptorProto_TYPE_INT64, ""),
+				},
+			},
+			{
+				Name: proto.String("CPUReply"),
+				Field: []*dpb.FieldDescriptorProto{
+					protoField("value", 1, optional, dpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+					protoField("samples", 2, repeated, dpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+					protoField("status", 3, optional, dpb.FieldDescriptorProto_TYPE_ENUM, ".metrics.Status"),
+				},
+			},
+		},
+		EnumType: []*dpb.EnumDescriptorProto{{
+			Name: proto.String("Status"),
+			Value: []*dpb.EnumValueDescriptorProto{
+				{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("OK"), Number: proto.Int32(1)},
+			},
+		}},
+		Service: []*dpb.ServiceDescriptorProto{{
+			Name: proto.String("Metrics"),
+			Method: []*dpb.MethodDescriptorProto{
+				{Name: proto.String("CPU"), InputType: proto.String(".metrics.CPURequest"), OutputType: proto.String(".metrics.CPUReply")},
+				{Name: proto.String("WatchCPU"), InputType: proto.String(".metrics.CPURequest"), OutputType: proto.String(".metrics.CPUReply"), ServerStreaming: proto.Bool(true)},
+			},
+		}},
+	}
+}
+
+// metricsDescriptor returns the metrics file as a base64 encoded
+// FileDescriptorSet.
+func metricsDescriptor(c *check.C) string {
+	data, err := proto.Marshal(&dpb.FileDescriptorSet{File: []*dpb.FileDescriptorProto{metricsFile()}})
+	c.Assert(err, check.IsNil)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// startMetricsServer starts a gRPC server with the reflection service and
+// the CPU method of the metrics service, implemented by cpu, returning its
+// url.
+func startMetricsServer(c *check.C, cpu func(ctx context.Context, request *dynamic.Message) (*dynamic.Message, error)) (string, func()) {
+	file, err := desc.CreateFileDescriptor(metricsFile())
+	c.Assert(err, check.IsNil)
+	data, err := proto.Marshal(metricsFile())
+	c.Assert(err, check.IsNil)
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	w.Write(data)
+	w.Close()
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "metrics.Metrics",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "CPU",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				request := dynamic.NewMessage(file.FindMessage("metrics.CPURequest"))
+				if err := dec(request); err != nil {
+					return nil, err
+				}
+				return cpu(ctx, request)
+			},
+		}},
+		Metadata: compressed.Bytes(),
+	}, struct{}{})
+	reflection.Register(server)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	go server.Serve(l)
+	return "http://" + l.Addr().String(), server.Stop
+}
+
+func cpuReply(request *dynamic.Message) *dynamic.Message {
+	reply := dynamic.NewMessage(request.GetMessageDescriptor().GetFile().FindMessage("metrics.CPUReply"))
+	reply.SetFieldByName("value", 85.5)
+	reply.SetFieldByName("samples", []float64{50, 85.5})
+	reply.SetFieldByName("status", int32(1))
+	return reply
+}
+
+func (s *S) TestDataSourceGetGRPC(c *check.C) {
+	url, stop := startMetricsServer(c, func(ctx context.Context, request *dynamic.Message) (*dynamic.Message, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		c.Check(md["x-tenant"], check.DeepEquals, []string{"team"})
+		c.Check(request.GetFieldByName("app"), check.Equals, "myapp")
+		c.Check(request.GetFieldByName("window"), check.Equals, int64(300))
+		return cpuReply(request), nil
+	})
+	defer stop()
+	ds := DataSource{
+		URL:     url,
+		Type:    TypeGRPC,
+		Headers: map[string]string{"X-Tenant": "team"},
+		Body:    `{"app": "{app}", "window": 300}`,
+		GRPC:    &GRPC{Descriptor: metricsDescriptor(c), Method: "metrics.Metrics/CPU"},
+	}
+	c.Assert(ds.Validate(), check.IsNil)
+	result, err := ds.Get("myapp", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, `{"value":85.5,"samples":[50,85.5],"status":"OK"}`)
+	ds.Extract = "$.samples[-1]"
+	result, err = ds.Get("myapp", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "85.5")
+}
+
+func (s *S) TestDataSourceGetGRPCServerReflection(c *check.C) {
+	url, stop := startMetricsServer(c, func(ctx context.Context, request *dynamic.Message) (*dynamic.Message, error) {
+		return cpuReply(request), nil
+	})
+	defer stop()
+	ds := DataSource{URL: url, Type: TypeGRPC, Extract: "$.value", GRPC: &GRPC{Method: "metrics.Metrics/CPU"}}
+	c.Assert(ds.Validate(), check.IsNil)
+	result, err := ds.Get("myapp", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "85.5")
+	ds.GRPC.Method = "metrics.Metrics/Memory"
+	_, err = ds.Get("myapp", nil)
+	c.Assert(err, check.ErrorMatches, `datasource: grpc method "metrics.Metrics/Memory" not found by the server reflection`)
+	ds.GRPC.Method = "metrics.Metrics/WatchCPU"
+	_, err = ds.Get("myapp", nil)
+	c.Assert(err, check.ErrorMatches, `datasource: grpc method "metrics.Metrics/WatchCPU" is not unary`)
+}
+
+func (s *S) TestDataSourceGetGRPCError(c *check.C) {
+	url, stop := startMetricsServer(c, func(ctx context.Context, request *dynamic.Message) (*dynamic.Message, error) {
+		return nil, status.Errorf(codes.NotFound, "app not found")
+	})
+	defer stop()
+	ds := DataSource{URL: url, Type: TypeGRPC, GRPC: &GRPC{Descriptor: metricsDescriptor(c), Method: "metrics.Metrics/CPU"}}
+	_, err := ds.Get("myapp", nil)
+	c.Assert(err, check.ErrorMatches, `datasource "": grpc call failed with status 5: app not found`)
+}
+
+func (s *S) TestValidateGRPC(c *check.C) {
+	ds := DataSource{URL: "http://metrics", Type: TypeGRPC, GRPC: &GRPC{Descriptor: metricsDescriptor(c), Method: "metrics.Metrics/Memory"}}
+	c.Assert(ds.Validate(), check.ErrorMatches, `datasource: grpc method "metrics.Metrics/Memory" not found in the descriptor`)
+	ds.GRPC.Method = "metrics.Metrics/WatchCPU"
+	c.Assert(ds.Validate(), check.ErrorMatches, `datasource: grpc method "metrics.Metrics/WatchCPU" is not unary`)
+	ds.GRPC = &GRPC{Method: "Memory"}
+	c.Assert(ds.Validate(), check.ErrorMatches, `datasource: invalid grpc method "Memory", it must be package.Service/Method`)
+	ds.GRPC = &GRPC{}
+	c.Assert(ds.Validate(), check.ErrorMatches, "datasource: grpc method required")
+}
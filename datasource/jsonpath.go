@@ -0,0 +1,142 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// pathStep represents a single step of a JSONPath expression: a key of an
+// object or an index of an array. Negative indexes count from the end.
+type pathStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parsePath parses a JSONPath expression supporting the dot notation
+// ($.a.b), the bracket notation ($['a']) and array indexes ($.a[0], $.a[-1]).
+func parsePath(path string) ([]pathStep, error) {
+	p := strings.TrimSpace(path)
+	p = strings.TrimPrefix(p, "$")
+	var steps []pathStep
+	for len(p) > 0 {
+		switch p[0] {
+		case '.':
+			p = p[1:]
+			end := strings.IndexAny(p, ".[")
+			if end == -1 {
+				end = len(p)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("datasource: invalid path %q: empty key", path)
+			}
+			steps = append(steps, pathStep{key: p[:end]})
+			p = p[end:]
+		case '[':
+			end := strings.Index(p, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("datasource: invalid path %q: missing ]", path)
+			}
+			content := strings.TrimSpace(p[1:end])
+			p = p[end+1:]
+			if len(content) > 1 && (content[0] == '\'' || content[0] == '"') && content[len(content)-1] == content[0] {
+				steps = append(steps, pathStep{key: content[1 : len(content)-1]})
+				continue
+			}
+			index, err := strconv.Atoi(content)
+			if err != nil {
+				return nil, fmt.Errorf("datasource: invalid path %q: invalid index %q", path, content)
+			}
+			steps = append(steps, pathStep{index: index, isIndex: true})
+		default:
+			if len(steps) == 0 && !strings.HasPrefix(strings.TrimSpace(path), "$") {
+				p = "." + p
+				continue
+			}
+			return nil, fmt.Errorf("datasource: invalid path %q", path)
+		}
+	}
+	return steps, nil
+}
+
+// decodeJSON decodes a JSON document from r, keeping numbers as json.Number.
+func decodeJSON(r io.Reader) (interface{}, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+// lookup evaluates the JSONPath expression against a decoded JSON value.
+func lookup(value interface{}, path string) (interface{}, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range steps {
+		if step.isIndex {
+			list, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("datasource: path %q: index %d applied to a non array value", path, step.index)
+			}
+			index := step.index
+			if index < 0 {
+				index += len(list)
+			}
+			if index < 0 || index >= len(list) {
+				return nil, fmt.Errorf("datasource: path %q: index %d out of range", path, step.index)
+			}
+			value = list[index]
+			continue
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("datasource: path %q: key %q applied to a non object value", path, step.key)
+		}
+		value, ok = object[step.key]
+		if !ok {
+			return nil, fmt.Errorf("datasource: path %q: key %q not found", path, step.key)
+		}
+	}
+	return value, nil
+}
+
+// extract decodes the JSON document from r and evaluates the JSONPath
+// expression against it, returning the selected value encoded as JSON.
+func extract(r io.Reader, path string) (string, error) {
+	if _, err := parsePath(path); err != nil {
+		return "", err
+	}
+	value, err := decodeJSON(r)
+	if err != nil {
+		return "", err
+	}
+	value, err = lookup(value, path)
+	if err != nil {
+		return "", err
+	}
+	result, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// extractValue encodes value as JSON, selecting the value of the JSONPath
+// expression when it is not empty.
+func extractValue(value interface{}, path string) (string, error) {
+	result, err := json.Marshal(value)
+	if err != nil || path == "" {
+		return string(result), err
+	}
+	return extract(bytes.NewReader(result), path)
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestExtract(c *check.C) {
+	data := []byte(`{"a": {"b": [1, 2, 3.5], "c d": "x", "e": {"f": true}}}`)
+	var tests = []struct {
+		path     string
+		expected string
+	}{
+		{"$.a.b[0]", "1"},
+		{"$.a.b[-1]", "3.5"},
+		{"$.a['c d']", `"x"`},
+		{`$["a"].e.f`, "true"},
+		{"a.e", `{"f":true}`},
+		{"$", `{"a":{"b":[1,2,3.5],"c d":"x","e":{"f":true}}}`},
+	}
+	for _, tt := range tests {
+		result, err := extract(bytes.NewReader(data), tt.path)
+		c.Check(err, check.IsNil)
+		c.Check(result, check.Equals, tt.expected)
+	}
+}
+
+func (s *S) TestExtractErrors(c *check.C) {
+	data := []byte(`{"a": {"b": [1, 2, 3]}}`)
+	paths := []string{"$.a.c", "$.a.b[3]", "$.a.b[-4]", "$.a[0]", "$.a.b.c", "$.a[x]", "$.a[0", "$..a"}
+	for _, path := range paths {
+		_, err := extract(bytes.NewReader(data), path)
+		c.Check(err, check.NotNil, check.Commentf("path %q", path))
+	}
+}
+
+func (s *S) TestHttpDataSourceGetWithExtract(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"aggregations": {"buckets": [{"max": {"value": 10}}, {"max": {"value": 85}}]}}`))
+	}))
+	defer ts.Close()
+	ds := DataSource{Method: "GET", URL: ts.URL, Extract: "$.aggregations.buckets[-1].max.value"}
+	result, err := ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "85")
+}
+
+func (s *S) TestNewWithInvalidExtract(c *check.C) {
+	err := New(&DataSource{URL: "http://tsuru.io", Method: "GET", Extract: "$.a[x]"})
+	c.Assert(err, check.NotNil)
+}
@@ -0,0 +1,168 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expiryDelta is how long before the real expiration a token is
+// considered expired, so requests never race against the expiry.
+const expiryDelta = 30 * time.Second
+
+// OAuth2 represents the OAuth2 client credentials configuration
+// used to authenticate the data source requests.
+type OAuth2 struct {
+	TokenURL     string   `json:"token_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
+type token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	expiry      time.Time
+}
+
+func (t *token) valid() bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	if t.expiry.IsZero() {
+		return true
+	}
+	return time.Now().Add(expiryDelta).Before(t.expiry)
+}
+
+func (t *token) header() string {
+	tokenType := t.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	return fmt.Sprintf("%s %s", tokenType, t.AccessToken)
+}
+
+// cachedToken is the token of a cache key. Its lock is held while the
+// token is fetched, so the concurrent requests of the same credentials
+// wait for a single fetch without blocking the ones of other credentials.
+type cachedToken struct {
+	sync.Mutex
+	token *token
+}
+
+var tokens = struct {
+	sync.Mutex
+	cache map[string]*cachedToken
+}{cache: map[string]*cachedToken{}}
+
+// cacheKey identifies the credentials of the token. The client secret is
+// hashed, so data sources sharing the client id with another secret do not
+// share the token and the secret is not kept in memory by the cache.
+func (o *OAuth2) cacheKey() string {
+	secret := sha256.Sum256([]byte(o.ClientSecret))
+	return strings.Join([]string{o.TokenURL, o.ClientID, hex.EncodeToString(secret[:]), strings.Join(o.Scopes, " ")}, "|")
+}
+
+// token returns a cached token or fetches a new one with client when the
+// cached token is missing or about to expire.
+func (o *OAuth2) token(client *http.Client) (*token, error) {
+	key := o.cacheKey()
+	tokens.Lock()
+	cached := tokens.cache[key]
+	if cached == nil {
+		cached = &cachedToken{}
+		tokens.cache[key] = cached
+	}
+	tokens.Unlock()
+	cached.Lock()
+	defer cached.Unlock()
+	if cached.token.valid() {
+		return cached.token, nil
+	}
+	t, err := o.fetchToken(client)
+	if err != nil {
+		return nil, err
+	}
+	cached.token = t
+	return t, nil
+}
+
+// fetchToken requests a new token with client, the client of the data
+// source, so the token requests use its timeout, TLS and proxy.
+func (o *OAuth2) fetchToken(client *http.Client) (*token, error) {
+	if o.TokenURL == "" {
+		return nil, errors.New("datasource: oauth2 token url required")
+	}
+	values := url.Values{"grant_type": {"client_credentials"}}
+	if len(o.Scopes) > 0 {
+		values.Set("scope", strings.Join(o.Scopes, " "))
+	}
+	req, err := http.NewRequest("POST", o.TokenURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	resp, err := client.Do(req)
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	if resp.StatusCode > 399 {
+		err = fmt.Errorf("datasource: oauth2 token request failed with status %d: %s", resp.StatusCode, string(body))
+		logger().Error(err)
+		return nil, err
+	}
+	var t token
+	err = json.Unmarshal(body, &t)
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	if t.AccessToken == "" {
+		return nil, errors.New("datasource: oauth2 server returned an empty access token")
+	}
+	if t.ExpiresIn > 0 {
+		t.expiry = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+	}
+	return &t, nil
+}
+
+// invalidate drops the cached token, forcing a new one to be fetched
+// on the next request.
+func (o *OAuth2) invalidate() {
+	tokens.Lock()
+	defer tokens.Unlock()
+	delete(tokens.cache, o.cacheKey())
+}
+
+// authorize sets the Authorization header using the client credentials
+// token, fetched with client.
+func (o *OAuth2) authorize(client *http.Client, req *http.Request) error {
+	t, err := o.token(client)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", t.header())
+	return nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/httpclient"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestOAuth2DataSourceGet(c *check.C) {
+	var tokenCalls int
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenCalls++
+		user, pass, ok := r.BasicAuth()
+		c.Check(ok, check.Equals, true)
+		c.Check(user, check.Equals, "client")
+		c.Check(pass, check.Equals, "secret")
+		c.Check(r.FormValue("grant_type"), check.Equals, "client_credentials")
+		c.Check(r.FormValue("scope"), check.Equals, "read metrics")
+		fmt.Fprintf(w, `{"access_token": "token%d", "token_type": "bearer", "expires_in": 3600}`, tokenCalls)
+	}))
+	defer tokenServer.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer ts.Close()
+	ds := DataSource{
+		Method: "GET",
+		URL:    ts.URL,
+		OAuth2: &OAuth2{
+			TokenURL:     tokenServer.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Scopes:       []string{"read", "metrics"},
+		},
+	}
+	defer ds.OAuth2.invalidate()
+	result, err := ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "Bearer token1")
+	result, err = ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "Bearer token1")
+	c.Assert(tokenCalls, check.Equals, 1)
+}
+
+func (s *S) TestOAuth2TokenUsesDataSourceClient(c *check.C) {
+	tokenServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token": "token", "expires_in": 3600}`)
+	}))
+	defer tokenServer.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer ts.Close()
+	ds := DataSource{
+		Method: "GET",
+		URL:    ts.URL,
+		OAuth2: &OAuth2{TokenURL: tokenServer.URL, ClientID: "client"},
+	}
+	defer ds.OAuth2.invalidate()
+	_, err := ds.Get("app", nil)
+	c.Assert(err, check.ErrorMatches, ".*certificate.*")
+	ds.TLS = &httpclient.TLS{InsecureSkipVerify: true}
+	result, err := ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "Bearer token")
+}
+
+func (s *S) TestOAuth2TokenRefreshBeforeExpiry(c *check.C) {
+	var tokenCalls int
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenCalls++
+		fmt.Fprintf(w, `{"access_token": "token%d", "expires_in": 10}`, tokenCalls)
+	}))
+	defer tokenServer.Close()
+	o := &OAuth2{TokenURL: tokenServer.URL, ClientID: "client"}
+	defer o.invalidate()
+	t, err := o.token(&http.Client{})
+	c.Assert(err, check.IsNil)
+	c.Assert(t.AccessToken, check.Equals, "token1")
+	t, err = o.token(&http.Client{})
+	c.Assert(err, check.IsNil)
+	c.Assert(t.AccessToken, check.Equals, "token2")
+}
+
+func (s *S) TestOAuth2TokenBySecret(c *check.C) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pass, _ := r.BasicAuth()
+		fmt.Fprintf(w, `{"access_token": "token-%s", "expires_in": 3600}`, pass)
+	}))
+	defer tokenServer.Close()
+	o1 := &OAuth2{TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "secret1"}
+	defer o1.invalidate()
+	o2 := &OAuth2{TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "secret2"}
+	defer o2.invalidate()
+	c.Assert(o1.cacheKey(), check.Not(check.Equals), o2.cacheKey())
+	c.Assert(strings.Contains(o1.cacheKey(), "secret1"), check.Equals, false)
+	t, err := o1.token(&http.Client{})
+	c.Assert(err, check.IsNil)
+	c.Assert(t.AccessToken, check.Equals, "token-secret1")
+	t, err = o2.token(&http.Client{})
+	c.Assert(err, check.IsNil)
+	c.Assert(t.AccessToken, check.Equals, "token-secret2")
+}
+
+func (s *S) TestOAuth2TokenLockPerKey(c *check.C) {
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		if user == "slow" {
+			close(fetching)
+			<-release
+		}
+		fmt.Fprintf(w, `{"access_token": "token-%s", "expires_in": 3600}`, user)
+	}))
+	defer tokenServer.Close()
+	slow := &OAuth2{TokenURL: tokenServer.URL, ClientID: "slow"}
+	defer slow.invalidate()
+	fast := &OAuth2{TokenURL: tokenServer.URL, ClientID: "fast"}
+	defer fast.invalidate()
+	defer close(release)
+	go slow.token(&http.Client{})
+	<-fetching
+	done := make(chan *token)
+	go func() {
+		t, _ := fast.token(&http.Client{})
+		done <- t
+	}()
+	select {
+	case t := <-done:
+		c.Assert(t, check.NotNil)
+		c.Assert(t.AccessToken, check.Equals, "token-fast")
+	case <-time.After(5 * time.Second):
+		c.Fatal("the fetch of a token blocked the tokens of other credentials")
+	}
+}
+
+func (s *S) TestOAuth2TokenError(c *check.C) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid client", http.StatusUnauthorized)
+	}))
+	defer tokenServer.Close()
+	o := &OAuth2{TokenURL: tokenServer.URL, ClientID: "client"}
+	_, err := o.token(&http.Client{})
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestTokenValid(c *check.C) {
+	var tests = []struct {
+		t     *token
+		valid bool
+	}{
+		{nil, false},
+		{&token{}, false},
+		{&token{AccessToken: "abc"}, true},
+		{&token{AccessToken: "abc", expiry: time.Now().Add(time.Hour)}, true},
+		{&token{AccessToken: "abc", expiry: time.Now().Add(10 * time.Second)}, false},
+	}
+	for _, tt := range tests {
+		c.Check(tt.t.valid(), check.Equals, tt.valid)
+	}
+}
+
+func (s *S) TestOAuth2JSON(c *check.C) {
+	ds := DataSource{
+		Name:   "cpu",
+		OAuth2: &OAuth2{TokenURL: "http://auth.example.com/token", ClientID: "autoscale", ClientSecret: "secret", Scopes: []string{"metrics:read"}},
+	}
+	data, err := json.Marshal(ds)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Matches, `.*"oauth2":\{"token_url":"http://auth.example.com/token","client_id":"autoscale","client_secret":"secret","scopes":\["metrics:read"\]\}.*`)
+	var decoded DataSource
+	err = json.Unmarshal(data, &decoded)
+	c.Assert(err, check.IsNil)
+	c.Assert(decoded.OAuth2, check.DeepEquals, ds.OAuth2)
+}
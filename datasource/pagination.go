@@ -0,0 +1,156 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// DefaultMaxPages is the maximum number of pages fetched when the
+// pagination does not define one.
+const DefaultMaxPages = 10
+
+// Pagination represents how to follow the pages of a data source response.
+//
+// Items is the JSONPath of the array of items in each page, and the page
+// itself is used when it is empty. The next page is found using the JSONPath
+// Next, that selects its url, or using the JSONPath Cursor, that selects a
+// cursor sent in the query string parameter CursorParam. When both are
+// empty, the "next" url of the Link header is used.
+type Pagination struct {
+	Items       string `json:"items,omitempty" bson:",omitempty"`
+	Next        string `json:"next,omitempty" bson:",omitempty"`
+	Cursor      string `json:"cursor,omitempty" bson:",omitempty"`
+	CursorParam string `json:"cursor_param,omitempty" bson:",omitempty"`
+	MaxPages    int    `json:"max_pages,omitempty" bson:",omitempty"`
+}
+
+func (p *Pagination) validate() error {
+	for _, path := range []string{p.Items, p.Next, p.Cursor} {
+		if path == "" {
+			continue
+		}
+		if _, err := parsePath(path); err != nil {
+			return err
+		}
+	}
+	if p.Cursor != "" && p.CursorParam == "" {
+		return errors.New("datasource: pagination cursor_param required")
+	}
+	if p.MaxPages < 0 {
+		return errors.New("datasource: pagination max_pages must not be negative")
+	}
+	return nil
+}
+
+func (p *Pagination) maxPages() int {
+	if p.MaxPages > 0 {
+		return p.MaxPages
+	}
+	return DefaultMaxPages
+}
+
+var linkNext = regexp.MustCompile(`<([^>]*)>\s*;[^,]*rel="?next"?`)
+
+// next returns the url of the page after the current one, or an empty
+// string when it is the last page.
+func (p *Pagination) next(current string, header http.Header, page interface{}) (string, error) {
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+	var next string
+	switch {
+	case p.Next != "":
+		value, err := lookup(page, p.Next)
+		if err != nil || value == nil {
+			return "", nil
+		}
+		next = fmt.Sprint(value)
+	case p.Cursor != "":
+		value, err := lookup(page, p.Cursor)
+		if err != nil || value == nil || value == "" {
+			return "", nil
+		}
+		q := base.Query()
+		q.Set(p.CursorParam, fmt.Sprint(value))
+		u := *base
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	default:
+		for _, link := range header["Link"] {
+			if m := linkNext.FindStringSubmatch(link); m != nil {
+				next = m[1]
+				break
+			}
+		}
+	}
+	if next == "" {
+		return "", nil
+	}
+	ref, err := url.Parse(next)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// fetchPages fetches all the pages of the data source, accumulating their
+// items into a single array.
+func (ds *DataSource) fetchPages(client *http.Client, pageURL, body string) (string, error) {
+	p := ds.Pagination
+	items := []interface{}{}
+	for page := 0; pageURL != "" && page < p.maxPages(); page++ {
+		response, err := ds.do(client, pageURL, body)
+		if err != nil {
+			return "", err
+		}
+		if response.StatusCode >= http.StatusInternalServerError {
+			response.Body.Close()
+			err = fmt.Errorf("datasource %q: request failed with status %d", ds.Name, response.StatusCode)
+			logger().Error(err)
+			return "", err
+		}
+		value, err := decodeJSON(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		pageItems := value
+		if p.Items != "" {
+			pageItems, err = lookup(value, p.Items)
+			if err != nil {
+				return "", err
+			}
+		}
+		list, ok := pageItems.([]interface{})
+		if !ok {
+			return "", fmt.Errorf("datasource %q: page items must be an array", ds.Name)
+		}
+		items = append(items, list...)
+		pageURL, err = p.next(pageURL, response.Header, value)
+		if err != nil {
+			return "", err
+		}
+	}
+	var result interface{} = items
+	if ds.Extract != "" {
+		var err error
+		result, err = lookup(items, ds.Extract)
+		if err != nil {
+			return "", err
+		}
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
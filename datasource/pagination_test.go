@@ -0,0 +1,96 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestHttpDataSourceGetPaginationLinkHeader(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 2 {
+			w.Header().Set("Link", fmt.Sprintf(`</items?page=%d>; rel="next", </items?page=0>; rel="first"`, page+1))
+		}
+		fmt.Fprintf(w, `[%d, %d]`, page*2, page*2+1)
+	}))
+	defer ts.Close()
+	ds := DataSource{Method: "GET", URL: ts.URL + "/items", Pagination: &Pagination{}}
+	result, err := ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "[0,1,2,3,4,5]")
+}
+
+func (s *S) TestHttpDataSourceGetPaginationNextURL(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "" {
+			w.Write([]byte(`{"data": [{"value": 1}], "links": {"next": "/items?page=2"}}`))
+			return
+		}
+		w.Write([]byte(`{"data": [{"value": 2}], "links": {"next": null}}`))
+	}))
+	defer ts.Close()
+	ds := DataSource{
+		Method:     "GET",
+		URL:        ts.URL + "/items",
+		Pagination: &Pagination{Items: "$.data", Next: "$.links.next"},
+		Extract:    "$[-1].value",
+	}
+	result, err := ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "2")
+}
+
+func (s *S) TestHttpDataSourceGetPaginationCursor(c *check.C) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		cursor, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+		c.Check(r.URL.Query().Get("app"), check.Equals, "myapp")
+		fmt.Fprintf(w, `{"items": [%d], "cursor": %d}`, cursor, cursor+1)
+	}))
+	defer ts.Close()
+	ds := DataSource{
+		Method:     "GET",
+		URL:        ts.URL + "?app={app}",
+		Pagination: &Pagination{Items: "items", Cursor: "cursor", CursorParam: "cursor", MaxPages: 3},
+	}
+	result, err := ds.Get("myapp", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "[0,1,2]")
+	c.Assert(calls, check.Equals, 3)
+}
+
+func (s *S) TestHttpDataSourceGetPaginationInvalidItems(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items": 1}`))
+	}))
+	defer ts.Close()
+	ds := DataSource{Method: "GET", URL: ts.URL, Pagination: &Pagination{Items: "items"}}
+	_, err := ds.Get("app", nil)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestPaginationValidate(c *check.C) {
+	var tests = []struct {
+		p     Pagination
+		valid bool
+	}{
+		{Pagination{}, true},
+		{Pagination{Items: "$.items", Cursor: "$.cursor", CursorParam: "cursor"}, true},
+		{Pagination{Cursor: "$.cursor"}, false},
+		{Pagination{Next: "$.a[x]"}, false},
+		{Pagination{MaxPages: -1}, false},
+	}
+	for _, tt := range tests {
+		err := tt.p.validate()
+		c.Check(err == nil, check.Equals, tt.valid, check.Commentf("%#v", tt.p))
+	}
+}
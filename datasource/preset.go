@@ -0,0 +1,109 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+)
+
+// Preset represents a preconfigured data source definition. The data
+// source url is built appending Path to the base url given by the user, and
+// {name} in the expression template is replaced by the data source name.
+type Preset struct {
+	Name               string `json:"name"`
+	Description        string `json:"description"`
+	Path               string `json:"path"`
+	Method             string `json:"method"`
+	Body               string `json:"body,omitempty"`
+	Extract            string `json:"extract,omitempty"`
+	ExpressionTemplate string `json:"expressionTemplate"`
+}
+
+var presets = map[string]Preset{
+	"tsuru-units": {
+		Name:               "tsuru-units",
+		Description:        "Units of the app, read from the tsuru API. The base url is the tsuru API url and the Authorization header should contain a tsuru token.",
+		Path:               "/apps/{app}",
+		Method:             "GET",
+		ExpressionTemplate: `!{name}.lock.Locked && {name}.units.map(function(unit){ if (unit.ProcessName === "{process}") {return 1} else {return 0}}).reduce(function(c, p) { return c + p }) > {minUnits}`,
+	},
+	"elasticsearch": {
+		Name:               "elasticsearch",
+		Description:        "Max and avg aggregations of the last five minutes of a tsuru metric stored in ElasticSearch. The base url is the search url of the metric, e.g. http://<elasticsearch>/<index>/cpu_max/_search.",
+		Method:             "POST",
+		Body:               `{"size":0, "query": {"filtered": {"filter": {"bool": {"must": [{"range": {"value": {"lt": 500}}},{ "term": {"app.raw": "{app}"}}, {"term": {"process.raw": "{process}"}}]}}}}, "aggs": {"range": {"date_range": {"field": "@timestamp", "ranges": [{"from": "now-5m/m", "to": "now"}]}, "aggs": {"date": {"date_histogram": {"field": "@timestamp", "interval": "1m"}, "aggs": {"max": {"max": {"field": "value"}}, "avg": {"avg": {"field": "value"}}}}}}}}`,
+		ExpressionTemplate: `{metric}.aggregations.range.buckets[0].date.buckets[{metric}.aggregations.range.buckets[0].date.buckets.length - 1].{aggregator}.value {operator} {value}`,
+	},
+	"prometheus-cpu": {
+		Name:               "prometheus-cpu",
+		Description:        "CPU usage percentage of the app process, read from Prometheus. The base url is the Prometheus url.",
+		Path:               `/api/v1/query?query=max(irate(container_cpu_system_seconds_total{container_label_tsuru_process_name="{process}",container_label_tsuru_app_name="{app}"}[1m]))*100`,
+		Method:             "GET",
+		Extract:            "$.data.result[0].value[1]",
+		ExpressionTemplate: `{metric} {operator} {value}`,
+	},
+	"graphite": {
+		Name:               "graphite",
+		Description:        "Last value of the Graphite target defined by the alarm env \"target\". The base url is the Graphite url.",
+		Path:               "/render?target={target}&from=-5min&format=json",
+		Method:             "GET",
+		Extract:            "$[0].datapoints[-1][0]",
+		ExpressionTemplate: `{metric} {operator} {value}`,
+	},
+}
+
+// Presets returns the available data source presets, sorted by name.
+func Presets() []Preset {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]Preset, len(names))
+	for i, name := range names {
+		list[i] = presets[name]
+	}
+	return list
+}
+
+// FromPreset creates a data source named name using the preset. The base
+// url and headers are used by the data source requests.
+func FromPreset(preset, name, baseURL string, headers map[string]string, public bool) (*DataSource, error) {
+	p, ok := presets[preset]
+	if !ok {
+		return nil, &db.NotFoundError{Resource: "preset", Name: preset}
+	}
+	v := &ValidationError{}
+	if name == "" {
+		v.addf("name", "name required")
+	}
+	if baseURL == "" {
+		v.addf("url", "url required")
+	}
+	if len(v.Errors) > 0 {
+		return nil, v
+	}
+	ds := DataSource{
+		Name:               name,
+		URL:                strings.TrimRight(baseURL, "/") + p.Path,
+		Method:             p.Method,
+		Body:               p.Body,
+		Headers:            headers,
+		Public:             public,
+		Extract:            p.Extract,
+		ExpressionTemplate: strings.Replace(p.ExpressionTemplate, "{name}", name, -1),
+	}
+	if p.Path == "" {
+		ds.URL = baseURL
+	}
+	err := New(&ds)
+	if err != nil {
+		return nil, err
+	}
+	return &ds, nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestPresets(c *check.C) {
+	list := Presets()
+	c.Assert(list, check.HasLen, len(presets))
+	for i := 1; i < len(list); i++ {
+		c.Check(list[i-1].Name < list[i].Name, check.Equals, true)
+	}
+	for _, p := range list {
+		if p.Extract != "" {
+			_, err := parsePath(p.Extract)
+			c.Check(err, check.IsNil, check.Commentf("preset %s", p.Name))
+		}
+	}
+}
+
+func (s *S) TestFromPreset(c *check.C) {
+	headers := map[string]string{"Authorization": "bearer token"}
+	ds, err := FromPreset("tsuru-units", "myunits", "http://tsuru.io/", headers, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(ds.URL, check.Equals, "http://tsuru.io/apps/{app}")
+	c.Assert(ds.Method, check.Equals, "GET")
+	c.Assert(ds.Public, check.Equals, true)
+	c.Assert(ds.ExpressionTemplate, check.Matches, `^!myunits\.lock\.Locked && myunits\.units.*`)
+	got, err := Get("myunits")
+	c.Assert(err, check.IsNil)
+	c.Assert(got.Headers, check.DeepEquals, headers)
+}
+
+func (s *S) TestFromPresetErrors(c *check.C) {
+	_, err := FromPreset("unknown", "ds", "http://tsuru.io", nil, false)
+	c.Assert(err, check.ErrorMatches, `preset "unknown" not found`)
+	_, err = FromPreset("graphite", "", "http://tsuru.io", nil, false)
+	c.Assert(err, check.ErrorMatches, "datasource: name required")
+	_, err = FromPreset("graphite", "ds", "", nil, false)
+	c.Assert(err, check.ErrorMatches, "datasource: url required")
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"math"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DefaultMaxSamples is the number of samples kept by push data sources
+// when they do not define one.
+const DefaultMaxSamples = 1000
+
+// window represents the samples pushed to a data source in its range,
+// encoded as the data source data. The statistics are null when there are
+// no samples.
+type window struct {
+	Samples []Sample `json:"samples"`
+	Count   int      `json:"count"`
+	Latest  *float64 `json:"latest"`
+	Min     *float64 `json:"min"`
+	Max     *float64 `json:"max"`
+	Sum     *float64 `json:"sum"`
+	Avg     *float64 `json:"avg"`
+}
+
+func newWindow(samples []Sample) *window {
+	w := window{Samples: samples, Count: len(samples)}
+	if len(samples) == 0 {
+		w.Samples = []Sample{}
+		return &w
+	}
+	latest := samples[len(samples)-1].Value
+	min, max, sum := math.Inf(1), math.Inf(-1), 0.0
+	for _, s := range samples {
+		min = math.Min(min, s.Value)
+		max = math.Max(max, s.Value)
+		sum += s.Value
+	}
+	avg := sum / float64(len(samples))
+	w.Latest, w.Min, w.Max, w.Sum, w.Avg = &latest, &min, &max, &sum, &avg
+	return &w
+}
+
+// maxSamples returns the number of samples kept by the data source.
+func (ds *DataSource) maxSamples() int {
+	if ds.MaxSamples > 0 {
+		return ds.MaxSamples
+	}
+	return DefaultMaxSamples
+}
+
+// Push stores samples in a push data source. Samples without timestamp
+// are stored with the current time. Only the latest samples, up to the data
+// source max samples, in its range are kept.
+func (ds *DataSource) Push(samples []Sample) error {
+	if ds.Type != TypePush {
+		v := &ValidationError{}
+		v.addf("type", "samples can only be pushed to %s data sources", TypePush)
+		return v
+	}
+	now := toMillis(time.Now())
+	for i := range samples {
+		if samples[i].Timestamp == 0 {
+			samples[i].Timestamp = now
+		}
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return err
+	}
+	defer conn.Close()
+	push := bson.D{
+		{Name: "$each", Value: samples},
+		{Name: "$sort", Value: bson.M{"timestamp": 1}},
+		{Name: "$slice", Value: -ds.maxSamples()},
+	}
+	_, err = conn.DataSourceSamples().Upsert(bson.M{"name": ds.Name}, bson.M{"$push": bson.M{"samples": push}})
+	if err != nil {
+		logger().Error(err)
+		return err
+	}
+	start := toMillis(time.Now().Add(-ds.rangeDuration()))
+	err = conn.DataSourceSamples().Update(bson.M{"name": ds.Name}, bson.M{"$pull": bson.M{"samples": bson.M{"timestamp": bson.M{"$lt": start}}}})
+	if err != nil {
+		logger().Error(err)
+	}
+	return err
+}
+
+// pushed returns the samples pushed to the data source in its range, with
+// their statistics, encoded as JSON.
+func (ds *DataSource) pushed() (string, error) {
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return "", err
+	}
+	defer conn.Close()
+	var stored struct {
+		Samples []Sample
+	}
+	err = conn.DataSourceSamples().Find(bson.M{"name": ds.Name}).One(&stored)
+	if err != nil && err != mgo.ErrNotFound {
+		logger().Error(err)
+		return "", err
+	}
+	start := toMillis(time.Now().Add(-ds.rangeDuration()))
+	var samples []Sample
+	for _, s := range stored.Samples {
+		if s.Timestamp >= start {
+			samples = append(samples, s)
+		}
+	}
+	return extractValue(newWindow(samples), ds.Extract)
+}
+
+func removeSamples(name string) error {
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.DataSourceSamples().RemoveAll(bson.M{"name": name})
+	return err
+}
+
+func checkPush(ds *DataSource, v *ValidationError) {
+	if ds.MaxSamples < 0 {
+		v.addf("max_samples", "max_samples must not be negative")
+	}
+	if ds.URL != "" || len(ds.FailoverURLs) > 0 {
+		v.addf("url", "urls are not used by %s data sources", ds.Type)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"encoding/json"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestPush(c *check.C) {
+	ds := DataSource{Name: "orders", Type: TypePush, MaxSamples: 3}
+	err := New(&ds)
+	c.Assert(err, check.IsNil)
+	now := toMillis(time.Now())
+	old := toMillis(time.Now().Add(-time.Hour))
+	err = ds.Push([]Sample{{Timestamp: old, Value: 100}, {Timestamp: now - 2000, Value: 1}})
+	c.Assert(err, check.IsNil)
+	err = ds.Push([]Sample{{Timestamp: now - 1000, Value: 2}, {Timestamp: now - 3000, Value: 3}, {Value: 6}})
+	c.Assert(err, check.IsNil)
+	data, err := ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	var w window
+	err = json.Unmarshal([]byte(data), &w)
+	c.Assert(err, check.IsNil)
+	c.Assert(w.Count, check.Equals, 3)
+	c.Assert(*w.Latest, check.Equals, 6.0)
+	c.Assert(*w.Sum, check.Equals, 9.0)
+	c.Assert(*w.Min, check.Equals, 1.0)
+	ds.Extract = "$.max"
+	data, err = ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(data, check.Equals, "6")
+	err = Remove(&ds)
+	c.Assert(err, check.IsNil)
+	n, err := s.conn.DataSourceSamples().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+}
+
+func (s *S) TestPushWithoutSamples(c *check.C) {
+	ds := DataSource{Name: "orders", Type: TypePush}
+	data, err := ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(data, check.Equals, `{"samples":[],"count":0,"latest":null,"min":null,"max":null,"sum":null,"avg":null}`)
+}
+
+func (s *S) TestPushInvalidType(c *check.C) {
+	ds := DataSource{Name: "cpu", URL: "http://tsuru.io", Method: "GET"}
+	err := ds.Push([]Sample{{Value: 1}})
+	c.Assert(err, check.FitsTypeOf, &ValidationError{})
+	c.Assert(err, check.ErrorMatches, `datasource: samples can only be pushed to push data sources`)
+}
+
+func (s *S) TestNewWindow(c *check.C) {
+	w := newWindow([]Sample{{Timestamp: 1, Value: 4}, {Timestamp: 2, Value: 1}, {Timestamp: 3, Value: 7}})
+	c.Assert(w.Count, check.Equals, 3)
+	c.Assert(*w.Latest, check.Equals, 7.0)
+	c.Assert(*w.Min, check.Equals, 1.0)
+	c.Assert(*w.Max, check.Equals, 7.0)
+	c.Assert(*w.Sum, check.Equals, 12.0)
+	c.Assert(*w.Avg, check.Equals, 4.0)
+}
+
+func (s *S) TestValidatePush(c *check.C) {
+	ds := DataSource{Type: TypePush}
+	c.Assert(ds.Validate(), check.IsNil)
+	ds = DataSource{Type: TypePush, URL: "http://tsuru.io", MaxSamples: -1}
+	err := ds.Validate()
+	c.Assert(err, check.FitsTypeOf, &ValidationError{})
+	c.Assert(err.(*ValidationError).Errors, check.DeepEquals, []FieldError{
+		{Field: "max_samples", Message: "max_samples must not be negative"},
+		{Field: "url", Message: "urls are not used by push data sources"},
+	})
+}
@@ -0,0 +1,310 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultRange is the time range read by Prometheus remote-read data
+// sources when they do not define one.
+const DefaultRange = 5 * time.Minute
+
+// label matcher types of the remote-read protocol.
+const (
+	matchEqual = iota
+	matchNotEqual
+	matchRegexp
+	matchNotRegexp
+)
+
+var matcherTypes = map[string]uint64{
+	"=":  matchEqual,
+	"!=": matchNotEqual,
+	"=~": matchRegexp,
+	"!~": matchNotRegexp,
+}
+
+type labelMatcher struct {
+	kind  uint64
+	name  string
+	value string
+}
+
+var (
+	metricName     = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*`)
+	matcherPattern = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*("(?:[^"\\]|\\.)*")\s*(,|$)`)
+)
+
+// parseSelector parses a Prometheus series selector, like
+// metric{label="value",other!~"regexp"}, into label matchers.
+func parseSelector(selector string) ([]labelMatcher, error) {
+	s := strings.TrimSpace(selector)
+	var matchers []labelMatcher
+	if name := metricName.FindString(s); name != "" {
+		matchers = append(matchers, labelMatcher{kind: matchEqual, name: "__name__", value: name})
+		s = strings.TrimSpace(s[len(name):])
+	}
+	if s != "" {
+		if s[0] != '{' || s[len(s)-1] != '}' {
+			return nil, fmt.Errorf("datasource: invalid selector %q", selector)
+		}
+		s = strings.TrimSpace(s[1 : len(s)-1])
+		for s != "" {
+			m := matcherPattern.FindStringSubmatch(s)
+			if m == nil {
+				return nil, fmt.Errorf("datasource: invalid selector %q", selector)
+			}
+			var value string
+			if err := json.Unmarshal([]byte(m[3]), &value); err != nil {
+				return nil, fmt.Errorf("datasource: invalid selector %q: %s", selector, err)
+			}
+			matchers = append(matchers, labelMatcher{kind: matcherTypes[m[2]], name: m[1], value: value})
+			s = s[len(m[0]):]
+		}
+	}
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("datasource: invalid selector %q: no matchers", selector)
+	}
+	return matchers, nil
+}
+
+// series represents a time series of the remote-read response.
+type series struct {
+	Labels  map[string]string `json:"labels"`
+	Samples []Sample          `json:"samples"`
+}
+
+// Sample represents a value of a time series. Timestamp is a unix
+// timestamp in milliseconds.
+type Sample struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// rangeDuration returns the time range read by the data source.
+func (ds *DataSource) rangeDuration() time.Duration {
+	if ds.Range > 0 {
+		return time.Duration(ds.Range) * time.Second
+	}
+	return DefaultRange
+}
+
+// fetchRemoteRead reads the series matching selector in the data source
+// range using the Prometheus remote-read protocol and returns them encoded
+// as JSON.
+func (ds *DataSource) fetchRemoteRead(client *http.Client, url, selector string) (string, error) {
+	matchers, err := parseSelector(selector)
+	if err != nil {
+		return "", err
+	}
+	end := time.Now()
+	start := end.Add(-ds.rangeDuration())
+	payload := encodeReadRequest(toMillis(start), toMillis(end), matchers)
+	req, err := ds.newRequest(client, http.MethodPost, url, bytes.NewReader(snappyEncode(payload)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Read-Version", "0.1.0")
+	response, err := ds.send(client, req)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		logger().Error(err)
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		err = fmt.Errorf("datasource %q: request failed with status %d: %s", ds.Name, response.StatusCode, strings.TrimSpace(string(data)))
+		logger().Error(err)
+		return "", err
+	}
+	data, err = snappyDecode(data, ds.maxResponseSize())
+	if err != nil {
+		logger().Error(err)
+		return "", err
+	}
+	result, err := decodeReadResponse(data)
+	if err != nil {
+		logger().Error(err)
+		return "", err
+	}
+	return extractValue(result, ds.Extract)
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// encodeReadRequest encodes a ReadRequest message with a single query.
+func encodeReadRequest(start, end int64, matchers []labelMatcher) []byte {
+	var query []byte
+	query = appendVarintField(query, 1, uint64(start))
+	query = appendVarintField(query, 2, uint64(end))
+	for _, m := range matchers {
+		var matcher []byte
+		matcher = appendVarintField(matcher, 1, m.kind)
+		matcher = appendBytesField(matcher, 2, []byte(m.name))
+		matcher = appendBytesField(matcher, 3, []byte(m.value))
+		query = appendBytesField(query, 3, matcher)
+	}
+	return appendBytesField(nil, 1, query)
+}
+
+// decodeReadResponse decodes a ReadResponse message, returning the series
+// of all its results. Samples with NaN or infinite values are ignored.
+func decodeReadResponse(data []byte) ([]series, error) {
+	result := []series{}
+	err := eachField(data, func(field int, value []byte, _ uint64) error {
+		if field != 1 {
+			return nil
+		}
+		return eachField(value, func(field int, value []byte, _ uint64) error {
+			if field != 1 {
+				return nil
+			}
+			s, err := decodeSeries(value)
+			if err != nil {
+				return err
+			}
+			result = append(result, s)
+			return nil
+		})
+	})
+	return result, err
+}
+
+func decodeSeries(data []byte) (series, error) {
+	s := series{Labels: map[string]string{}, Samples: []Sample{}}
+	err := eachField(data, func(field int, value []byte, _ uint64) error {
+		switch field {
+		case 1:
+			var name, labelValue string
+			err := eachField(value, func(field int, value []byte, _ uint64) error {
+				switch field {
+				case 1:
+					name = string(value)
+				case 2:
+					labelValue = string(value)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			s.Labels[name] = labelValue
+		case 2:
+			var smp Sample
+			err := eachField(value, func(field int, value []byte, n uint64) error {
+				switch field {
+				case 1:
+					smp.Value = math.Float64frombits(n)
+				case 2:
+					smp.Timestamp = int64(n)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if !math.IsNaN(smp.Value) && !math.IsInf(smp.Value, 0) {
+				s.Samples = append(s.Samples, smp)
+			}
+		}
+		return nil
+	})
+	return s, err
+}
+
+// protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errInvalidProtobuf = errors.New("datasource: invalid protobuf message")
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendVarint(b, uint64(field)<<3|wireVarint)
+	return appendVarint(b, v)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendVarint(b, uint64(field)<<3|wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// eachField calls fn for each field of the protobuf message. Length
+// delimited fields are given in value, and numeric fields in n.
+func eachField(data []byte, fn func(field int, value []byte, n uint64) error) error {
+	for len(data) > 0 {
+		key, size := binary.Uvarint(data)
+		if size <= 0 {
+			return errInvalidProtobuf
+		}
+		data = data[size:]
+		field := int(key >> 3)
+		var value []byte
+		var n uint64
+		switch key & 0x07 {
+		case wireVarint:
+			n, size = binary.Uvarint(data)
+			if size <= 0 {
+				return errInvalidProtobuf
+			}
+			data = data[size:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errInvalidProtobuf
+			}
+			n = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireBytes:
+			length, size := binary.Uvarint(data)
+			if size <= 0 || uint64(len(data)-size) < length {
+				return errInvalidProtobuf
+			}
+			value = data[size : size+int(length)]
+			data = data[size+int(length):]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errInvalidProtobuf
+			}
+			n = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		default:
+			return errInvalidProtobuf
+		}
+		if err := fn(field, value, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
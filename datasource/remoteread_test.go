@@ -0,0 +1,125 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseSelector(c *check.C) {
+	matchers, err := parseSelector(`cpu_usage{app="myapp", process!="worker",pool=~"prod.*",team!~"a\"b"}`)
+	c.Assert(err, check.IsNil)
+	c.Assert(matchers, check.DeepEquals, []labelMatcher{
+		{kind: matchEqual, name: "__name__", value: "cpu_usage"},
+		{kind: matchEqual, name: "app", value: "myapp"},
+		{kind: matchNotEqual, name: "process", value: "worker"},
+		{kind: matchRegexp, name: "pool", value: "prod.*"},
+		{kind: matchNotRegexp, name: "team", value: `a"b`},
+	})
+	matchers, err = parseSelector(`{job="api"}`)
+	c.Assert(err, check.IsNil)
+	c.Assert(matchers, check.DeepEquals, []labelMatcher{{kind: matchEqual, name: "job", value: "api"}})
+	for _, selector := range []string{"", "{}", `cpu{app=myapp}`, `cpu{app="a"`, `cpu{app="a" process="b"}`} {
+		_, err = parseSelector(selector)
+		c.Check(err, check.NotNil, check.Commentf("selector %q", selector))
+	}
+}
+
+func encodeSample(value float64, timestamp int64) []byte {
+	b := appendVarint(nil, 1<<3|wireFixed64)
+	bits := math.Float64bits(value)
+	for i := uint(0); i < 64; i += 8 {
+		b = append(b, byte(bits>>i))
+	}
+	return appendVarintField(b, 2, uint64(timestamp))
+}
+
+func encodeLabel(name, value string) []byte {
+	return appendBytesField(appendBytesField(nil, 1, []byte(name)), 2, []byte(value))
+}
+
+func (s *S) TestDataSourceGetPrometheusRemoteRead(c *check.C) {
+	var timeseries []byte
+	timeseries = appendBytesField(timeseries, 1, encodeLabel("__name__", "cpu"))
+	timeseries = appendBytesField(timeseries, 1, encodeLabel("app", "myapp"))
+	timeseries = appendBytesField(timeseries, 2, encodeSample(0.5, 1000))
+	timeseries = appendBytesField(timeseries, 2, encodeSample(math.NaN(), 2000))
+	timeseries = appendBytesField(timeseries, 2, encodeSample(1.5, 3000))
+	response := appendBytesField(nil, 1, appendBytesField(nil, 1, timeseries))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.Header.Get("Content-Encoding"), check.Equals, "snappy")
+		c.Check(r.Header.Get("X-Prometheus-Remote-Read-Version"), check.Equals, "0.1.0")
+		body, err := ioutil.ReadAll(r.Body)
+		c.Check(err, check.IsNil)
+		payload, err := snappyDecode(body, 1<<20)
+		c.Check(err, check.IsNil)
+		var start, end uint64
+		var matchers []labelMatcher
+		err = eachField(payload, func(_ int, query []byte, _ uint64) error {
+			return eachField(query, func(field int, value []byte, n uint64) error {
+				switch field {
+				case 1:
+					start = n
+				case 2:
+					end = n
+				case 3:
+					var m labelMatcher
+					eachField(value, func(field int, value []byte, n uint64) error {
+						switch field {
+						case 1:
+							m.kind = n
+						case 2:
+							m.name = string(value)
+						case 3:
+							m.value = string(value)
+						}
+						return nil
+					})
+					matchers = append(matchers, m)
+				}
+				return nil
+			})
+		})
+		c.Check(err, check.IsNil)
+		c.Check(end-start, check.Equals, uint64(60000))
+		c.Check(matchers, check.DeepEquals, []labelMatcher{
+			{kind: matchEqual, name: "__name__", value: "cpu"},
+			{kind: matchEqual, name: "app", value: "myapp"},
+		})
+		w.Header().Set("Content-Encoding", "snappy")
+		w.Write(snappyEncode(response))
+	}))
+	defer ts.Close()
+	ds := DataSource{
+		URL:    ts.URL,
+		Type:   TypePrometheusRemoteRead,
+		Body:   `cpu{app="{app}"}`,
+		Range:  60,
+		Method: "POST",
+	}
+	result, err := ds.Get("myapp", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, `[{"labels":{"__name__":"cpu","app":"myapp"},"samples":[{"timestamp":1000,"value":0.5},{"timestamp":3000,"value":1.5}]}]`)
+	ds.Extract = "$[0].samples[-1].value"
+	result, err = ds.Get("myapp", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "1.5")
+}
+
+func (s *S) TestDataSourceGetPrometheusRemoteReadError(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "remote read disabled", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+	ds := DataSource{URL: ts.URL, Type: TypePrometheusRemoteRead, Body: "cpu"}
+	_, err := ds.Get("myapp", nil)
+	c.Assert(err, check.ErrorMatches, `datasource "": request failed with status 400: remote read disabled`)
+}
@@ -0,0 +1,206 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// dataSourceName matches the names that can be used as variables in the
+// alarm expressions.
+var dataSourceName = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// Rename renames a data source and updates its references: the data
+// sources and expressions of the alarms, the metrics of the auto scales
+// created by the wizard and the sources of aggregate data sources.
+//
+// MongoDB does not update multiple documents atomically, so when an update
+// fails the ones already applied are reverted before returning the error.
+func Rename(oldName, newName string) error {
+	if !dataSourceName.MatchString(newName) {
+		v := &ValidationError{}
+		v.addf("name", "invalid name %q", newName)
+		return v
+	}
+	ds, err := Get(oldName)
+	if err != nil {
+		return err
+	}
+	if oldName == newName {
+		return nil
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return err
+	}
+	defer conn.Close()
+	n, err := conn.DataSources().Find(bson.M{"name": newName}).Count()
+	if err != nil {
+		logger().Error(err)
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("datasource %q already exists", newName)
+	}
+	alarms, wizards, aggregates, err := references(conn, oldName)
+	if err != nil {
+		return err
+	}
+	var undo []func()
+	update := func(apply, revert func() error) error {
+		if err := apply(); err != nil {
+			logger().Error(err)
+			for i := len(undo) - 1; i >= 0; i-- {
+				undo[i]()
+			}
+			return err
+		}
+		undo = append(undo, func() {
+			if err := revert(); err != nil {
+				logger().Error(err)
+			}
+		})
+		return nil
+	}
+	setName := func(from, to string) func() error {
+		return func() error {
+			return conn.DataSources().Update(bson.M{"name": from}, bson.M{"$set": bson.M{"name": to}})
+		}
+	}
+	err = update(setName(ds.Name, newName), setName(newName, ds.Name))
+	if err != nil {
+		return err
+	}
+	for _, a := range alarms {
+		renamed := alarmRef{Name: a.Name, Expression: renameIdentifier(a.Expression, oldName, newName)}
+		for _, name := range a.DataSources {
+			if name == oldName {
+				name = newName
+			}
+			renamed.DataSources = append(renamed.DataSources, name)
+		}
+		setAlarm := func(ref alarmRef) func() error {
+			return func() error {
+				return conn.Alarms().Update(bson.M{"name": ref.Name}, bson.M{"$set": bson.M{"datasources": ref.DataSources, "expression": ref.Expression}})
+			}
+		}
+		err = update(setAlarm(renamed), setAlarm(a))
+		if err != nil {
+			return err
+		}
+	}
+	for _, w := range wizards {
+		metrics := map[string]string{"scaleup.metric": w.ScaleUp.Metric, "scaledown.metric": w.ScaleDown.Metric}
+		for field, metric := range metrics {
+			if metric != oldName {
+				continue
+			}
+			setMetric := func(name, field, metric string) func() error {
+				return func() error {
+					return conn.Wizards().Update(bson.M{"name": name}, bson.M{"$set": bson.M{field: metric}})
+				}
+			}
+			err = update(setMetric(w.Name, field, newName), setMetric(w.Name, field, oldName))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, aggregate := range aggregates {
+		for key, source := range aggregate.Sources {
+			if source != oldName {
+				continue
+			}
+			setSource := func(name, key, source string) func() error {
+				return func() error {
+					return conn.DataSources().Update(bson.M{"name": name}, bson.M{"$set": bson.M{"sources." + key: source}})
+				}
+			}
+			err = update(setSource(aggregate.Name, key, newName), setSource(aggregate.Name, key, oldName))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = conn.DataSourceSamples().UpdateAll(bson.M{"name": oldName}, bson.M{"$set": bson.M{"name": newName}})
+	if err != nil {
+		logger().Error(err)
+	}
+	_, err = conn.DataSourceStatus().UpdateAll(bson.M{"name": oldName}, bson.M{"$set": bson.M{"name": newName}})
+	if err != nil {
+		logger().Error(err)
+	}
+	return nil
+}
+
+// renameIdentifier replaces the variable oldName by newName in the
+// javascript expression, ignoring properties and string literals.
+func renameIdentifier(expression, oldName, newName string) string {
+	var result []byte
+	for i := 0; i < len(expression); {
+		ch := expression[i]
+		switch {
+		case ch == '"' || ch == '\'':
+			j := i + 1
+			for j < len(expression) && expression[j] != ch {
+				if expression[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(expression) {
+				j++
+			}
+			result = append(result, expression[i:j]...)
+			i = j
+		case ch >= '0' && ch <= '9':
+			j := i + 1
+			for j < len(expression) && (isIdentifierStart(expression[j]) || (expression[j] >= '0' && expression[j] <= '9') || expression[j] == '.') {
+				j++
+			}
+			result = append(result, expression[i:j]...)
+			i = j
+		case isIdentifierStart(ch):
+			j := i + 1
+			for j < len(expression) && (isIdentifierStart(expression[j]) || (expression[j] >= '0' && expression[j] <= '9')) {
+				j++
+			}
+			word := expression[i:j]
+			if word == oldName && !isProperty(expression[:i]) {
+				word = newName
+			}
+			result = append(result, word...)
+			i = j
+		default:
+			result = append(result, ch)
+			i++
+		}
+	}
+	return string(result)
+}
+
+func isIdentifierStart(ch byte) bool {
+	return ch == '_' || ch == '$' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+// isProperty returns whether the identifier following prefix is accessed as
+// a property, like b in a.b.
+func isProperty(prefix string) bool {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		switch prefix[i] {
+		case ' ', '\t', '\n':
+			continue
+		case '.':
+			return true
+		}
+		return false
+	}
+	return false
+}
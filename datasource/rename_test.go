@@ -0,0 +1,66 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestRename(c *check.C) {
+	err := New(&DataSource{Name: "cpu", URL: "http://tsuru.io", Method: "GET"})
+	c.Assert(err, check.IsNil)
+	err = New(&DataSource{Name: "total", Type: TypeAggregate, Sources: map[string]string{"a": "cpu", "b": "memory"}})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Alarms().Insert(bson.M{"name": "scale_up", "datasources": []string{"units", "cpu"}, "expression": `cpu.value > 10 && units.cpu == "cpu"`})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Wizards().Insert(bson.M{"name": "instance", "scaleup": bson.M{"metric": "cpu"}, "scaledown": bson.M{"metric": "memory"}})
+	c.Assert(err, check.IsNil)
+	err = Rename("cpu", "cpu_usage")
+	c.Assert(err, check.IsNil)
+	_, err = Get("cpu")
+	c.Assert(err, check.ErrorMatches, `datasource "cpu" not found`)
+	_, err = Get("cpu_usage")
+	c.Assert(err, check.IsNil)
+	var a alarmRef
+	err = s.conn.Alarms().Find(bson.M{"name": "scale_up"}).One(&a)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.DataSources, check.DeepEquals, []string{"units", "cpu_usage"})
+	c.Assert(a.Expression, check.Equals, `cpu_usage.value > 10 && units.cpu == "cpu"`)
+	var w wizardRef
+	err = s.conn.Wizards().Find(bson.M{"name": "instance"}).One(&w)
+	c.Assert(err, check.IsNil)
+	c.Assert(w.ScaleUp.Metric, check.Equals, "cpu_usage")
+	c.Assert(w.ScaleDown.Metric, check.Equals, "memory")
+	total, err := Get("total")
+	c.Assert(err, check.IsNil)
+	c.Assert(total.Sources, check.DeepEquals, map[string]string{"a": "cpu_usage", "b": "memory"})
+}
+
+func (s *S) TestRenameInvalid(c *check.C) {
+	err := New(&DataSource{Name: "cpu", URL: "http://tsuru.io", Method: "GET"})
+	c.Assert(err, check.IsNil)
+	err = New(&DataSource{Name: "memory", URL: "http://tsuru.io", Method: "GET"})
+	c.Assert(err, check.IsNil)
+	c.Assert(Rename("cpu", "memory"), check.ErrorMatches, `datasource "memory" already exists`)
+	c.Assert(Rename("cpu", "cpu-usage"), check.ErrorMatches, `datasource: invalid name "cpu-usage"`)
+	c.Assert(Rename("disk", "disk_usage"), check.ErrorMatches, `datasource "disk" not found`)
+}
+
+func (s *S) TestRenameIdentifier(c *check.C) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"cpu > 10", "usage > 10"},
+		{"cpu.value > 10 && units.cpu > 1", "usage.value > 10 && units.cpu > 1"},
+		{"cpu['cpu'] > 1.5e3 && cpus > 1", "usage['cpu'] > 1.5e3 && cpus > 1"},
+		{`"cpu" == cpu . cpu`, `"cpu" == usage . cpu`},
+		{`'it\'s cpu' == cpu`, `'it\'s cpu' == usage`},
+	}
+	for _, tt := range tests {
+		c.Check(renameIdentifier(tt.expression, "cpu", "usage"), check.Equals, tt.expected)
+	}
+}
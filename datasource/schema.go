@@ -0,0 +1,100 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var schemaTypes = map[string]bool{
+	"any":     true,
+	"string":  true,
+	"number":  true,
+	"boolean": true,
+	"array":   true,
+	"object":  true,
+	"null":    true,
+}
+
+// InsufficientDataError is returned when the data source response does not
+// match its response schema, so the alarm can not be evaluated.
+type InsufficientDataError struct {
+	Name   string
+	Reason string
+}
+
+func (e *InsufficientDataError) Error() string {
+	return fmt.Sprintf("datasource %q: insufficient data: %s", e.Name, e.Reason)
+}
+
+// IsInsufficientData returns whether err is an *InsufficientDataError.
+func IsInsufficientData(err error) bool {
+	_, ok := err.(*InsufficientDataError)
+	return ok
+}
+
+func validateSchema(schema map[string]string, v *ValidationError) {
+	for path, typ := range schema {
+		if _, err := parsePath(path); err != nil {
+			v.add("response_schema", err)
+		}
+		if !schemaTypes[typ] {
+			v.addf("response_schema", "invalid type %q for path %q", typ, path)
+		}
+	}
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// checkSchema checks that the data returned by the data source has all the
+// paths of its response schema, with the expected types.
+func (ds *DataSource) checkSchema(data string) error {
+	if len(ds.ResponseSchema) == 0 {
+		return nil
+	}
+	value, err := decodeJSON(strings.NewReader(data))
+	if err != nil {
+		return &InsufficientDataError{Name: ds.Name, Reason: fmt.Sprintf("response is not valid JSON: %s", err)}
+	}
+	var paths []string
+	for path := range ds.ResponseSchema {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var problems []string
+	for _, path := range paths {
+		expected := ds.ResponseSchema[path]
+		found, err := lookup(value, path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s is missing", path))
+			continue
+		}
+		if typ := jsonType(found); expected != "any" && typ != expected {
+			problems = append(problems, fmt.Sprintf("%s is %s, expected %s", path, typ, expected))
+		}
+	}
+	if len(problems) > 0 {
+		return &InsufficientDataError{Name: ds.Name, Reason: strings.Join(problems, "; ")}
+	}
+	return nil
+}
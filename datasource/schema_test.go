@@ -0,0 +1,50 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestCheckSchema(c *check.C) {
+	ds := DataSource{
+		Name: "cpu",
+		ResponseSchema: map[string]string{
+			"$.status":              "string",
+			"$.data.result":         "array",
+			"$.data.result[0].ok":   "boolean",
+			"$.data.result[0].max":  "number",
+			"$.data.result[0].tags": "any",
+		},
+	}
+	err := ds.checkSchema(`{"status": "success", "data": {"result": [{"ok": true, "max": 1.5, "tags": null}]}}`)
+	c.Assert(err, check.IsNil)
+	err = ds.checkSchema(`{"status": 1, "data": {"result": []}}`)
+	c.Assert(IsInsufficientData(err), check.Equals, true)
+	c.Assert(err, check.ErrorMatches, `datasource "cpu": insufficient data: \$\.data\.result\[0\]\.max is missing; \$\.data\.result\[0\]\.ok is missing; \$\.data\.result\[0\]\.tags is missing; \$\.status is number, expected string`)
+	err = ds.checkSchema(`<html>`)
+	c.Assert(IsInsufficientData(err), check.Equals, true)
+	ds.ResponseSchema = nil
+	c.Assert(ds.checkSchema(`<html>`), check.IsNil)
+}
+
+func (s *S) TestValidateResponseSchema(c *check.C) {
+	ds := DataSource{URL: "http://tsuru.io", Method: "GET", ResponseSchema: map[string]string{"$.a": "integer"}}
+	c.Assert(ds.Validate(), check.ErrorMatches, `datasource: invalid type "integer" for path "\$\.a"`)
+}
+
+func (s *S) TestDataSourceGetResponseSchema(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"value": "NaN"}`))
+	}))
+	defer ts.Close()
+	ds := DataSource{URL: ts.URL, Method: "GET", ResponseSchema: map[string]string{"$.value": "number"}}
+	data, err := ds.Get("app", nil)
+	c.Assert(IsInsufficientData(err), check.Equals, true)
+	c.Assert(data, check.Equals, "")
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"github.com/tsuru/tsuru-autoscale/secret"
+)
+
+// copy returns a copy of the data source that can be changed without
+// changing the original one.
+func (ds *DataSource) copy() *DataSource {
+	c := *ds
+	if ds.Headers != nil {
+		c.Headers = make(map[string]string, len(ds.Headers))
+		for key, value := range ds.Headers {
+			c.Headers[key] = value
+		}
+	}
+	if ds.OAuth2 != nil {
+		o := *ds.OAuth2
+		c.OAuth2 = &o
+	}
+	if ds.TLS != nil {
+		t := *ds.TLS
+		c.TLS = &t
+	}
+	return &c
+}
+
+// transformSecrets replaces the data source secret values, the
+// credentials headers, the basic authentication password, the OAuth2 client
+// secret and the TLS key, by the result of fn.
+func (ds *DataSource) transformSecrets(fn func(string) (string, error)) error {
+	var err error
+	for key, value := range ds.Headers {
+		if secret.IsSecretHeader(key) {
+			if ds.Headers[key], err = fn(value); err != nil {
+				return err
+			}
+		}
+	}
+	if ds.Password, err = fn(ds.Password); err != nil {
+		return err
+	}
+	if ds.OAuth2 != nil {
+		if ds.OAuth2.ClientSecret, err = fn(ds.OAuth2.ClientSecret); err != nil {
+			return err
+		}
+	}
+	if ds.TLS != nil {
+		if ds.TLS.Key, err = fn(ds.TLS.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encrypted returns a copy of the data source with its secrets encrypted.
+func (ds *DataSource) encrypted() (*DataSource, error) {
+	c := ds.copy()
+	return c, c.transformSecrets(secret.Encrypt)
+}
+
+func (ds *DataSource) decrypt() error {
+	return ds.transformSecrets(secret.Decrypt)
+}
+
+// Redacted returns a copy of the data source with its secrets redacted,
+// to be shown to users.
+func (ds *DataSource) Redacted() *DataSource {
+	c := ds.copy()
+	c.transformSecrets(func(value string) (string, error) {
+		return secret.Redact(value), nil
+	})
+	return c
+}
+
+// keepSecrets replaces the redacted secrets of the data source by the ones
+// of the existing data source.
+func (ds *DataSource) keepSecrets(existing *DataSource) {
+	for key, value := range ds.Headers {
+		if value == secret.Redacted && secret.IsSecretHeader(key) {
+			ds.Headers[key] = existing.Headers[key]
+		}
+	}
+	if ds.Password == secret.Redacted {
+		ds.Password = existing.Password
+	}
+	if ds.OAuth2 != nil && ds.OAuth2.ClientSecret == secret.Redacted && existing.OAuth2 != nil {
+		ds.OAuth2.ClientSecret = existing.OAuth2.ClientSecret
+	}
+	if ds.TLS != nil && ds.TLS.Key == secret.Redacted && existing.TLS != nil {
+		ds.TLS.Key = existing.TLS.Key
+	}
+}
+
+// hasRedactedSecrets returns whether any secret of the data source is
+// redacted.
+func (ds *DataSource) hasRedactedSecrets() bool {
+	redacted := false
+	ds.copy().transformSecrets(func(value string) (string, error) {
+		redacted = redacted || value == secret.Redacted
+		return value, nil
+	})
+	return redacted
+}
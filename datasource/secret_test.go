@@ -0,0 +1,64 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"os"
+
+	"github.com/tsuru/tsuru-autoscale/httpclient"
+	"github.com/tsuru/tsuru-autoscale/secret"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestNewEncryptsSecrets(c *check.C) {
+	os.Setenv("AUTOSCALE_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	defer os.Unsetenv("AUTOSCALE_ENCRYPTION_KEY")
+	ds := DataSource{
+		Name:    "ds",
+		URL:     "http://tsuru.io",
+		Method:  "GET",
+		Headers: map[string]string{"Authorization": "bearer abc", "Content-Type": "application/json"},
+		OAuth2:  &OAuth2{ClientID: "client", ClientSecret: "secret"},
+	}
+	err := New(&ds)
+	c.Assert(err, check.IsNil)
+	c.Assert(ds.Headers["Authorization"], check.Equals, "bearer abc")
+	var stored DataSource
+	err = s.conn.DataSources().Find(bson.M{"name": "ds"}).One(&stored)
+	c.Assert(err, check.IsNil)
+	c.Assert(secret.IsEncrypted(stored.Headers["Authorization"]), check.Equals, true)
+	c.Assert(stored.Headers["Content-Type"], check.Equals, "application/json")
+	c.Assert(secret.IsEncrypted(stored.OAuth2.ClientSecret), check.Equals, true)
+	got, err := Get("ds")
+	c.Assert(err, check.IsNil)
+	c.Assert(got.Headers["Authorization"], check.Equals, "bearer abc")
+	c.Assert(got.OAuth2.ClientSecret, check.Equals, "secret")
+	all, err := FindBy(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(all[0].Headers["Authorization"], check.Equals, "bearer abc")
+}
+
+func (s *S) TestRedacted(c *check.C) {
+	ds := DataSource{
+		Headers:  map[string]string{"Authorization": "bearer abc", "Content-Type": "application/json"},
+		OAuth2:   &OAuth2{ClientID: "client", ClientSecret: "secret"},
+		TLS:      &httpclient.TLS{Cert: "cert", Key: "key"},
+		Username: "user",
+		Password: "pass",
+	}
+	redacted := ds.Redacted()
+	c.Assert(redacted.Headers, check.DeepEquals, map[string]string{"Authorization": secret.Redacted, "Content-Type": "application/json"})
+	c.Assert(redacted.OAuth2.ClientSecret, check.Equals, secret.Redacted)
+	c.Assert(redacted.OAuth2.ClientID, check.Equals, "client")
+	c.Assert(redacted.TLS.Key, check.Equals, secret.Redacted)
+	c.Assert(redacted.TLS.Cert, check.Equals, "cert")
+	c.Assert(redacted.Username, check.Equals, "user")
+	c.Assert(redacted.Password, check.Equals, secret.Redacted)
+	c.Assert(ds.Password, check.Equals, "pass")
+	c.Assert(ds.Headers["Authorization"], check.Equals, "bearer abc")
+	c.Assert(ds.OAuth2.ClientSecret, check.Equals, "secret")
+	c.Assert(ds.TLS.Key, check.Equals, "key")
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errInvalidSnappy = errors.New("datasource: invalid snappy data")
+
+// snappyEncode encodes data using the snappy block format. Data is not
+// compressed: it is encoded as a sequence of literals, which is valid input
+// for any snappy decoder.
+func snappyEncode(data []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(len(data)))
+	dst := buf[:n]
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > 1<<16 {
+			chunk = chunk[:1<<16]
+		}
+		size := len(chunk) - 1
+		switch {
+		case size < 60:
+			dst = append(dst, byte(size)<<2)
+		case size < 1<<8:
+			dst = append(dst, 60<<2, byte(size))
+		default:
+			dst = append(dst, 61<<2, byte(size), byte(size>>8))
+		}
+		dst = append(dst, chunk...)
+		data = data[len(chunk):]
+	}
+	return dst
+}
+
+// snappyDecode decodes data encoded using the snappy block format. It fails
+// when the decoded data is larger than limit bytes.
+func snappyDecode(src []byte, limit int64) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errInvalidSnappy
+	}
+	if length > uint64(limit) {
+		return nil, errors.New("datasource: decoded response exceeds the limit")
+	}
+	src = src[n:]
+	dst := make([]byte, 0, length)
+	for len(src) > 0 {
+		tag := src[0]
+		var size, offset int
+		switch tag & 0x03 {
+		case 0x00:
+			size = int(tag >> 2)
+			src = src[1:]
+			if size >= 60 {
+				extra := size - 59
+				if len(src) < extra {
+					return nil, errInvalidSnappy
+				}
+				size = 0
+				for i := extra - 1; i >= 0; i-- {
+					size = size<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			size++
+			if size <= 0 || len(src) < size || uint64(len(dst)+size) > length {
+				return nil, errInvalidSnappy
+			}
+			dst = append(dst, src[:size]...)
+			src = src[size:]
+			continue
+		case 0x01:
+			if len(src) < 2 {
+				return nil, errInvalidSnappy
+			}
+			size = int(tag>>2&0x07) + 4
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case 0x02:
+			if len(src) < 3 {
+				return nil, errInvalidSnappy
+			}
+			size = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint16(src[1:3]))
+			src = src[3:]
+		case 0x03:
+			if len(src) < 5 {
+				return nil, errInvalidSnappy
+			}
+			size = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint32(src[1:5]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+size) > length {
+			return nil, errInvalidSnappy
+		}
+		start := len(dst) - offset
+		for i := 0; i < size; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	if uint64(len(dst)) != length {
+		return nil, errInvalidSnappy
+	}
+	return dst, nil
+}
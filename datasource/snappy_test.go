@@ -0,0 +1,34 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"bytes"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestSnappyDecode(c *check.C) {
+	data, err := snappyDecode([]byte{0x09, 0x08, 'a', 'b', 'c', 0x09, 0x03}, 100)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "abcabcabc")
+}
+
+func (s *S) TestSnappyDecodeInvalid(c *check.C) {
+	_, err := snappyDecode([]byte{0x09, 0x08, 'a', 'b', 'c', 0x09, 0x05}, 100)
+	c.Assert(err, check.Equals, errInvalidSnappy)
+	_, err = snappyDecode([]byte{0x09, 0x08, 'a', 'b', 'c', 0x09, 0x03}, 8)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestSnappyEncode(c *check.C) {
+	for _, size := range []int{0, 1, 60, 61, 300, 1 << 17} {
+		data := bytes.Repeat([]byte("x"), size)
+		decoded, err := snappyDecode(snappyEncode(data), int64(size))
+		c.Assert(err, check.IsNil)
+		c.Assert(decoded, check.DeepEquals, data[:len(decoded)])
+		c.Assert(decoded, check.HasLen, size)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Status represents the health of a data source, based on the result
+// of the requests made to it.
+type Status struct {
+	Name          string    `json:"name"`
+	LastURL       string    `json:"lastURL"`
+	LastSuccess   time.Time `json:"lastSuccess"`
+	LastError     string    `json:"lastError"`
+	LastErrorTime time.Time `json:"lastErrorTime"`
+	Successes     int       `json:"successes"`
+	Failures      int       `json:"failures"`
+	ErrorRate     float64   `json:"errorRate" bson:"-"`
+}
+
+// recordStatus stores the result of a data source request, including the
+// url that served it.
+func recordStatus(name, url string, reqErr error) error {
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	now := time.Now().UTC()
+	var update bson.M
+	if reqErr == nil {
+		update = bson.M{
+			"$set": bson.M{"lastsuccess": now, "lasturl": url},
+			"$inc": bson.M{"successes": 1},
+		}
+	} else {
+		update = bson.M{
+			"$set": bson.M{"lasterror": reqErr.Error(), "lasterrortime": now},
+			"$inc": bson.M{"failures": 1},
+		}
+	}
+	_, err = conn.DataSourceStatus().Upsert(bson.M{"name": name}, update)
+	return err
+}
+
+// GetStatus returns the status of a data source.
+func GetStatus(name string) (*Status, error) {
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	defer conn.Close()
+	status := Status{Name: name}
+	err = conn.DataSourceStatus().Find(bson.M{"name": name}).One(&status)
+	if err != nil && err != mgo.ErrNotFound {
+		logger().Error(err)
+		return nil, err
+	}
+	if total := status.Successes + status.Failures; total > 0 {
+		status.ErrorRate = float64(status.Failures) / float64(total)
+	}
+	return &status, nil
+}
+
+func removeStatus(name string) error {
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.DataSourceStatus().RemoveAll(bson.M{"name": name})
+	return err
+}
@@ -0,0 +1,44 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestStatus(c *check.C) {
+	var fail bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	ds := DataSource{Name: "ds", Method: "GET", URL: ts.URL}
+	_, err := ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	fail = true
+	_, err = ds.Get("app", nil)
+	c.Assert(err, check.NotNil)
+	status, err := GetStatus(ds.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(status.Successes, check.Equals, 1)
+	c.Assert(status.Failures, check.Equals, 1)
+	c.Assert(status.ErrorRate, check.Equals, 0.5)
+	c.Assert(status.LastSuccess.IsZero(), check.Equals, false)
+	c.Assert(status.LastURL, check.Equals, ts.URL)
+	c.Assert(status.LastErrorTime.IsZero(), check.Equals, false)
+	c.Assert(status.LastError, check.Not(check.Equals), "")
+}
+
+func (s *S) TestStatusWithoutRequests(c *check.C) {
+	status, err := GetStatus("unknown")
+	c.Assert(err, check.IsNil)
+	c.Assert(status.Name, check.Equals, "unknown")
+	c.Assert(status.ErrorRate, check.Equals, 0.0)
+}
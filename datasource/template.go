@@ -0,0 +1,64 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateData is the data available to the data source templates.
+type templateData struct {
+	App  string
+	Envs map[string]string
+}
+
+var templateFuncs = template.FuncMap{
+	"now": func() time.Time {
+		return time.Now().UTC()
+	},
+	"duration": time.ParseDuration,
+	"ago": func(d string) (time.Time, error) {
+		duration, err := time.ParseDuration(d)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Now().UTC().Add(-duration), nil
+	},
+	"unix": func(t time.Time) int64 {
+		return t.Unix()
+	},
+	"unixMillis": func(t time.Time) int64 {
+		return t.UnixNano() / int64(time.Millisecond)
+	},
+	"rfc3339": func(t time.Time) string {
+		return t.Format(time.RFC3339)
+	},
+}
+
+// parseTemplate parses text as a data source template. Texts without
+// template actions are not parsed, so plain JSON bodies are kept untouched.
+func parseTemplate(text string) (*template.Template, error) {
+	if !strings.Contains(text, "{{") {
+		return nil, nil
+	}
+	return template.New("datasource").Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// executeTemplate executes text as a template using the app name and envs.
+func executeTemplate(text, appName string, envs map[string]string) (string, error) {
+	tmpl, err := parseTemplate(text)
+	if err != nil || tmpl == nil {
+		return text, err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, templateData{App: appName, Envs: envs})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
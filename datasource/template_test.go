@@ -0,0 +1,58 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"encoding/json"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestExecuteTemplate(c *check.C) {
+	before := time.Now().Add(-5*time.Minute).UnixNano() / int64(time.Millisecond)
+	text := `{"app": "{{.App}}", "process": "{{.Envs.process}}", "missing": "{{.Envs.missing}}", "from": {{ ago "5m" | unixMillis }}, "to": {{ now | unix }}}`
+	result, err := executeTemplate(text, "myapp", map[string]string{"process": "web"})
+	c.Assert(err, check.IsNil)
+	var data struct {
+		App     string
+		Process string
+		Missing string
+		From    int64
+		To      int64
+	}
+	err = json.Unmarshal([]byte(result), &data)
+	c.Assert(err, check.IsNil)
+	c.Assert(data.App, check.Equals, "myapp")
+	c.Assert(data.Process, check.Equals, "web")
+	c.Assert(data.Missing, check.Equals, "")
+	c.Assert(data.From >= before, check.Equals, true)
+	c.Assert(data.To >= before/1000, check.Equals, true)
+}
+
+func (s *S) TestExecuteTemplateDuration(c *check.C) {
+	result, err := executeTemplate(`{{ (now.Add (duration "-1h")).Before now }}`, "myapp", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, "true")
+}
+
+func (s *S) TestExecuteTemplateWithoutActions(c *check.C) {
+	text := `{"query": {"term": {"app": "{app}"}}}`
+	result, err := executeTemplate(text, "myapp", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.Equals, text)
+}
+
+func (s *S) TestExecuteTemplateInvalid(c *check.C) {
+	_, err := executeTemplate(`{{ ago "5x" | unix }}`, "myapp", nil)
+	c.Assert(err, check.NotNil)
+	_, err = executeTemplate(`{{ now `, "myapp", nil)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestNewWithInvalidTemplate(c *check.C) {
+	err := New(&DataSource{URL: "http://tsuru.io", Method: "GET", Body: "{{ now "})
+	c.Assert(err, check.NotNil)
+}
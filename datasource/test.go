@@ -0,0 +1,99 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/tsuru"
+)
+
+// TestResult represents the raw result of a data source request.
+type TestResult struct {
+	URL        string        `json:"url"`
+	Body       string        `json:"body"`
+	StatusCode int           `json:"statusCode"`
+	Latency    time.Duration `json:"latency"`
+}
+
+// Test executes a single request to the data source using the app bound
+// to the service instance and the given envs, and returns the raw response.
+// The failover urls are used when the request fails.
+func (ds *DataSource) Test(instanceName string, envs map[string]string) (*TestResult, error) {
+	instance, err := tsuru.GetInstanceByName(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	if len(instance.Apps) < 1 {
+		return nil, fmt.Errorf("instance %q has no apps bound", instanceName)
+	}
+	placeholders := instance.Envs()
+	for key, value := range envs {
+		placeholders[key] = value
+	}
+	if ds.Type == TypeAggregate || ds.Type == TypePush {
+		start := time.Now()
+		var data string
+		if ds.Type == TypeAggregate {
+			data, err = ds.aggregate(instance.Apps[0], placeholders)
+		} else {
+			data, err = ds.pushed()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &TestResult{Body: data, StatusCode: http.StatusOK, Latency: time.Since(start)}, nil
+	}
+	urls, body, err := ds.expand(instance.Apps[0], placeholders)
+	if err != nil {
+		return nil, err
+	}
+	client, err := ds.client()
+	if err != nil {
+		return nil, err
+	}
+	var result *TestResult
+	for _, url := range urls {
+		result, err = ds.test(client, url, body)
+		if err == nil && result.StatusCode < http.StatusInternalServerError {
+			break
+		}
+	}
+	return result, err
+}
+
+func (ds *DataSource) test(client *http.Client, url, body string) (*TestResult, error) {
+	start := time.Now()
+	if ds.Type != "" && ds.Type != TypeHTTP {
+		data, err := ds.fetch(client, url, body)
+		if err != nil {
+			return nil, err
+		}
+		return &TestResult{
+			URL:        url,
+			Body:       data,
+			StatusCode: http.StatusOK,
+			Latency:    time.Since(start),
+		}, nil
+	}
+	response, err := ds.do(client, url, body)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &TestResult{
+		URL:        url,
+		Body:       string(data),
+		StatusCode: response.StatusCode,
+		Latency:    time.Since(start),
+	}, nil
+}
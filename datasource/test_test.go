@@ -0,0 +1,37 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/tsuru/tsuru-autoscale/tsuru"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestTest(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(r.URL.Query().Get("app") + r.URL.Query().Get("process")))
+	}))
+	defer ts.Close()
+	instance := tsuru.Instance{Name: "instance", Apps: []string{"myapp"}}
+	err := tsuru.NewInstance(&instance)
+	c.Assert(err, check.IsNil)
+	ds := DataSource{Name: "ds", Method: "GET", URL: ts.URL + "?app={app}&process={process}"}
+	result, err := ds.Test(instance.Name, map[string]string{"process": "web"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result.StatusCode, check.Equals, http.StatusServiceUnavailable)
+	c.Assert(result.Body, check.Equals, "myappweb")
+	c.Assert(result.URL, check.Equals, ts.URL+"?app=myapp&process=web")
+	c.Assert(result.Latency > 0, check.Equals, true)
+}
+
+func (s *S) TestTestInstanceNotFound(c *check.C) {
+	ds := DataSource{Name: "ds", Method: "GET", URL: "http://localhost"}
+	_, err := ds.Test("notfound", nil)
+	c.Assert(err, check.NotNil)
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// TransformTimeout is the maximum time a transform script can run.
+const TransformTimeout = time.Second
+
+var errTransformTimeout = errors.New("timeout")
+
+// transformSource wraps the transform script in a function receiving the
+// data source data.
+func transformSource(script string) string {
+	return fmt.Sprintf("(function(data) {\n%s\n})", script)
+}
+
+func validateTransform(script string) error {
+	_, err := otto.New().Compile("transform", transformSource(script))
+	if err != nil {
+		return fmt.Errorf("datasource: invalid transform: %s", err)
+	}
+	return nil
+}
+
+// transform runs the data source transform script with data, the JSON
+// encoded data source data, returning the result of the script encoded as
+// JSON.
+func (ds *DataSource) transform(data string) (result string, err error) {
+	vm := otto.New()
+	vm.Interrupt = make(chan func(), 1)
+	timer := time.AfterFunc(TransformTimeout, func() {
+		vm.Interrupt <- func() {
+			panic(errTransformTimeout)
+		}
+	})
+	defer timer.Stop()
+	defer func() {
+		if r := recover(); r != nil {
+			if r != errTransformTimeout {
+				panic(r)
+			}
+			err = fmt.Errorf("datasource %q: transform exceeded %s", ds.Name, TransformTimeout)
+			logger().Error(err)
+		}
+	}()
+	if err = vm.Set("raw", data); err != nil {
+		return "", err
+	}
+	source := fmt.Sprintf("JSON.stringify(%s(JSON.parse(raw)))", transformSource(ds.Transform))
+	value, err := vm.Run(source)
+	if err != nil {
+		err = fmt.Errorf("datasource %q: transform failed: %s", ds.Name, err)
+		logger().Error(err)
+		return "", err
+	}
+	if value.IsUndefined() {
+		err = fmt.Errorf("datasource %q: transform returned undefined", ds.Name)
+		logger().Error(err)
+		return "", err
+	}
+	return value.String(), nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestGetTransform(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"values": [[1, "10"], [2, "30"], [3, "20"]]}`))
+	}))
+	defer ts.Close()
+	ds := DataSource{
+		Method: "GET",
+		URL:    ts.URL,
+		Transform: `var values = data.values.map(function(v) { return parseFloat(v[1]) });
+var sum = values.reduce(function(a, b) { return a + b }, 0);
+return {latest: values[values.length - 1], avg: sum / values.length, max: Math.max.apply(null, values)};`,
+	}
+	c.Assert(ds.Validate(), check.IsNil)
+	data, err := ds.Get("app", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(data, check.Equals, `{"avg":20,"latest":20,"max":30}`)
+}
+
+func (s *S) TestTransformErrors(c *check.C) {
+	tests := []struct {
+		transform string
+		err       string
+	}{
+		{"return data.missing.value", `datasource "ds": transform failed: TypeError: .*`},
+		{"data.value", `datasource "ds": transform returned undefined`},
+		{"while (true) {}", `datasource "ds": transform exceeded 1s`},
+	}
+	for _, tt := range tests {
+		ds := DataSource{Name: "ds", Transform: tt.transform}
+		_, err := ds.transform(`{"value": 1}`)
+		c.Check(err, check.ErrorMatches, tt.err)
+	}
+}
+
+func (s *S) TestValidateTransform(c *check.C) {
+	ds := DataSource{URL: "http://tsuru.io", Method: "GET", Transform: "return {"}
+	err := ds.Validate()
+	c.Assert(err, check.FitsTypeOf, &ValidationError{})
+	c.Assert(err.(*ValidationError).Errors[0].Field, check.Equals, "transform")
+	c.Assert(err, check.ErrorMatches, `datasource: invalid transform: .*`)
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// References lists the names of the alarms, auto scales created by the
+// wizard and aggregate data sources using a data source.
+type References struct {
+	Alarms      []string `json:"alarms"`
+	Wizards     []string `json:"wizards"`
+	DataSources []string `json:"datasources"`
+}
+
+// Empty returns whether there are no references.
+func (r *References) Empty() bool {
+	return len(r.Alarms) == 0 && len(r.Wizards) == 0 && len(r.DataSources) == 0
+}
+
+// InUseError is returned when removing a data source that is still
+// referenced.
+type InUseError struct {
+	Name       string      `json:"name"`
+	References *References `json:"references"`
+}
+
+func (e *InUseError) Error() string {
+	var used []string
+	if len(e.References.Alarms) > 0 {
+		used = append(used, fmt.Sprintf("alarms %s", strings.Join(e.References.Alarms, ", ")))
+	}
+	if len(e.References.Wizards) > 0 {
+		used = append(used, fmt.Sprintf("wizards %s", strings.Join(e.References.Wizards, ", ")))
+	}
+	if len(e.References.DataSources) > 0 {
+		used = append(used, fmt.Sprintf("datasources %s", strings.Join(e.References.DataSources, ", ")))
+	}
+	return fmt.Sprintf("datasource %q is used by %s", e.Name, strings.Join(used, "; "))
+}
+
+// alarmRef holds the fields of an alarm referencing data sources.
+type alarmRef struct {
+	Name        string
+	DataSources []string
+	Expression  string
+}
+
+// wizardRef holds the fields of an auto scale referencing data sources.
+type wizardRef struct {
+	Name      string
+	ScaleUp   struct{ Metric string }
+	ScaleDown struct{ Metric string }
+}
+
+// references finds the alarms, auto scales and aggregate data sources
+// using the data source name.
+func references(conn db.Storage, name string) ([]alarmRef, []wizardRef, []DataSource, error) {
+	var alarms []alarmRef
+	err := conn.Alarms().Find(bson.M{"datasources": name}).Sort("name").All(&alarms)
+	if err != nil {
+		logger().Error(err)
+		return nil, nil, nil, err
+	}
+	var wizards []wizardRef
+	err = conn.Wizards().Find(bson.M{"$or": []bson.M{{"scaleup.metric": name}, {"scaledown.metric": name}}}).Sort("name").All(&wizards)
+	if err != nil {
+		logger().Error(err)
+		return nil, nil, nil, err
+	}
+	var aggregates []DataSource
+	err = conn.DataSources().Find(bson.M{"type": TypeAggregate}).Sort("name").All(&aggregates)
+	if err != nil {
+		logger().Error(err)
+		return nil, nil, nil, err
+	}
+	var using []DataSource
+	for _, aggregate := range aggregates {
+		for _, source := range aggregate.Sources {
+			if source == name {
+				using = append(using, aggregate)
+				break
+			}
+		}
+	}
+	return alarms, wizards, using, nil
+}
+
+func usage(conn db.Storage, name string) (*References, error) {
+	alarms, wizards, aggregates, err := references(conn, name)
+	if err != nil {
+		return nil, err
+	}
+	refs := References{Alarms: []string{}, Wizards: []string{}, DataSources: []string{}}
+	for _, a := range alarms {
+		refs.Alarms = append(refs.Alarms, a.Name)
+	}
+	for _, w := range wizards {
+		refs.Wizards = append(refs.Wizards, w.Name)
+	}
+	for _, ds := range aggregates {
+		refs.DataSources = append(refs.DataSources, ds.Name)
+	}
+	return &refs, nil
+}
+
+// Usage returns the alarms, auto scales and aggregate data sources using
+// the data source.
+func Usage(name string) (*References, error) {
+	ds, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Open()
+	if err != nil {
+		logger().Error(err)
+		return nil, err
+	}
+	defer conn.Close()
+	return usage(conn, ds.Name)
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestUsage(c *check.C) {
+	err := New(&DataSource{Name: "cpu", URL: "http://tsuru.io", Method: "GET"})
+	c.Assert(err, check.IsNil)
+	err = New(&DataSource{Name: "total", Type: TypeAggregate, Sources: map[string]string{"a": "cpu"}})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Alarms().Insert(bson.M{"name": "scale_up", "datasources": []string{"cpu"}})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Alarms().Insert(bson.M{"name": "scale_down", "datasources": []string{"units"}})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Wizards().Insert(bson.M{"name": "instance", "scaleup": bson.M{"metric": "memory"}, "scaledown": bson.M{"metric": "cpu"}})
+	c.Assert(err, check.IsNil)
+	refs, err := Usage("cpu")
+	c.Assert(err, check.IsNil)
+	c.Assert(refs, check.DeepEquals, &References{
+		Alarms:      []string{"scale_up"},
+		Wizards:     []string{"instance"},
+		DataSources: []string{"total"},
+	})
+	refs, err = Usage("total")
+	c.Assert(err, check.IsNil)
+	c.Assert(refs.Empty(), check.Equals, true)
+	_, err = Usage("memory")
+	c.Assert(err, check.ErrorMatches, `datasource "memory" not found`)
+}
+
+func (s *S) TestRemoveInUse(c *check.C) {
+	ds := DataSource{Name: "cpu", URL: "http://tsuru.io", Method: "GET"}
+	err := New(&ds)
+	c.Assert(err, check.IsNil)
+	err = s.conn.Alarms().Insert(bson.M{"name": "scale_up", "datasources": []string{"cpu"}})
+	c.Assert(err, check.IsNil)
+	err = Remove(&ds)
+	c.Assert(err, check.FitsTypeOf, &InUseError{})
+	c.Assert(err, check.ErrorMatches, `datasource "cpu" is used by alarms scale_up`)
+	_, err = Get(ds.Name)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestInUseError(c *check.C) {
+	err := &InUseError{Name: "cpu", References: &References{Alarms: []string{"a", "b"}, DataSources: []string{"total"}}}
+	c.Assert(err.Error(), check.Equals, `datasource "cpu" is used by alarms a, b; datasources total`)
+}
@@ -0,0 +1,269 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tsuru/tsuru-autoscale/httpclient"
+)
+
+// FieldError represents an invalid data source field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a data source does not match the schema
+// of its type. It holds all the invalid fields.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		messages[i] = fieldErr.Message
+	}
+	return "datasource: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field string, err error) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: strings.TrimPrefix(err.Error(), "datasource: ")})
+}
+
+func (e *ValidationError) addf(field, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// schema represents the fields of a data source type: the required ones,
+// the default method and the type specific checks.
+type schema struct {
+	required []string
+	method   string
+	check    func(ds *DataSource, v *ValidationError)
+}
+
+var schemas = map[string]schema{
+	TypeHTTP: {
+		required: []string{"url", "method"},
+	},
+	TypePrometheusRemoteRead: {
+		required: []string{"url", "body"},
+		method:   http.MethodPost,
+		check:    checkRemoteRead,
+	},
+	TypeElasticsearch: {
+		required: []string{"url", "elasticsearch"},
+		method:   http.MethodPost,
+		check:    checkElasticsearch,
+	},
+	TypeGRPC: {
+		required: []string{"url", "grpc"},
+		method:   http.MethodPost,
+		check:    checkGRPC,
+	},
+	TypeAggregate: {
+		required: []string{"sources"},
+		method:   http.MethodGet,
+		check:    checkAggregate,
+	},
+	TypePush: {
+		check: checkPush,
+	},
+}
+
+var presentFields = map[string]func(ds *DataSource) bool{
+	"url":           func(ds *DataSource) bool { return ds.URL != "" },
+	"method":        func(ds *DataSource) bool { return ds.Method != "" },
+	"body":          func(ds *DataSource) bool { return ds.Body != "" },
+	"elasticsearch": func(ds *DataSource) bool { return ds.Elasticsearch != nil },
+	"sources":       func(ds *DataSource) bool { return len(ds.Sources) > 0 },
+	"grpc":          func(ds *DataSource) bool { return ds.GRPC != nil },
+}
+
+var methods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+var placeholders = regexp.MustCompile(`\{\{.*?\}\}|\{[A-Za-z_][A-Za-z0-9_.]*\}`)
+
+// withoutPlaceholders replaces the placeholders and template actions of
+// text, so its format can be checked before they are expanded.
+func withoutPlaceholders(text string) string {
+	return placeholders.ReplaceAllString(text, "placeholder")
+}
+
+func checkURL(field, rawURL string, v *ValidationError) {
+	u, err := url.Parse(withoutPlaceholders(rawURL))
+	if err != nil {
+		v.addf(field, "invalid %s %q: %s", field, rawURL, err)
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.addf(field, "invalid %s %q: must be an absolute http or https url", field, rawURL)
+	}
+}
+
+func checkRemoteRead(ds *DataSource, v *ValidationError) {
+	if ds.Pagination != nil {
+		v.addf("pagination", "pagination is not supported by %s data sources", ds.Type)
+	}
+	if ds.Body != "" {
+		if _, err := parseSelector(withoutPlaceholders(ds.Body)); err != nil {
+			v.add("body", err)
+		}
+	}
+}
+
+func checkElasticsearch(ds *DataSource, v *ValidationError) {
+	if ds.Elasticsearch != nil {
+		if err := ds.Elasticsearch.validate(); err != nil {
+			v.add("elasticsearch", err)
+		}
+	}
+}
+
+func checkGRPC(ds *DataSource, v *ValidationError) {
+	if ds.GRPC != nil {
+		if err := ds.GRPC.validate(); err != nil {
+			v.add("grpc", err)
+		}
+	}
+}
+
+var sourceKey = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+func checkAggregate(ds *DataSource, v *ValidationError) {
+	var keys []string
+	for key := range ds.Sources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !sourceKey.MatchString(key) {
+			v.addf("sources", "invalid source key %q: must be a valid javascript identifier", key)
+		}
+		if name := ds.Sources[key]; name == "" || name == ds.Name {
+			v.addf("sources", "invalid source %q for key %q", name, key)
+		}
+	}
+}
+
+// Types returns the data source types, sorted by name.
+func Types() []string {
+	types := make([]string, 0, len(schemas))
+	for t := range schemas {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Validate checks the data source against the schema of its type, setting
+// the type default method when it is empty. The returned error is a
+// *ValidationError listing all the invalid fields.
+func (ds *DataSource) Validate() error {
+	v := &ValidationError{}
+	typ := ds.Type
+	if typ == "" {
+		typ = TypeHTTP
+	}
+	s, ok := schemas[typ]
+	if !ok {
+		v.addf("type", "invalid type %q", ds.Type)
+		s = schemas[TypeHTTP]
+	}
+	if ds.Method == "" {
+		ds.Method = s.method
+	}
+	for _, field := range s.required {
+		if !presentFields[field](ds) {
+			v.addf(field, "%s required", field)
+		}
+	}
+	if ds.URL != "" {
+		checkURL("url", ds.URL, v)
+	}
+	for _, u := range ds.FailoverURLs {
+		if u == "" {
+			v.addf("failover_urls", "failover urls must not be empty")
+			continue
+		}
+		checkURL("failover_urls", u, v)
+	}
+	if err := httpclient.ValidateProxy(ds.ProxyURL); err != nil {
+		v.addf("proxy_url", "%s", strings.TrimPrefix(err.Error(), "httpclient: "))
+	}
+	if ds.Password != "" && ds.Username == "" {
+		v.addf("username", "username required by password")
+	}
+	if ds.Username != "" && ds.OAuth2 != nil {
+		v.addf("username", "basic authentication can not be used with oauth2")
+	}
+	if ds.Method != "" && !methods[ds.Method] {
+		v.addf("method", "invalid method %q", ds.Method)
+	}
+	if ds.Timeout < 0 || ds.Retries < 0 || ds.RetryInterval < 0 {
+		v.addf("timeout", "timeout, retries and retry_interval must not be negative")
+	}
+	if ds.Range < 0 {
+		v.addf("range", "range must not be negative")
+	}
+	if ds.MaxResponseSize < 0 {
+		v.addf("max_response_size", "max_response_size must not be negative")
+	}
+	if ds.Extract != "" {
+		if _, err := parsePath(ds.Extract); err != nil {
+			v.add("extract", err)
+		}
+	}
+	if ds.Pagination != nil {
+		if err := ds.Pagination.validate(); err != nil {
+			v.add("pagination", err)
+		}
+	}
+	if !formats[ds.Format] {
+		v.addf("format", "invalid format %q", ds.Format)
+	}
+	if ds.Format != "" && ds.Format != FormatJSON && (typ != TypeHTTP || ds.Pagination != nil) {
+		v.addf("format", "format %q is only supported by http data sources without pagination", ds.Format)
+	}
+	if ds.Column != "" && ds.Format != FormatCSV {
+		v.addf("column", "column requires the csv format")
+	}
+	validateSchema(ds.ResponseSchema, v)
+	if ds.Transform != "" {
+		if err := validateTransform(ds.Transform); err != nil {
+			v.add("transform", err)
+		}
+	}
+	texts := map[string][]string{"url": {ds.URL}, "body": {ds.Body}, "failover_urls": ds.FailoverURLs}
+	for _, field := range []string{"url", "body", "failover_urls"} {
+		for _, text := range texts[field] {
+			if _, err := parseTemplate(text); err != nil {
+				v.add(field, err)
+			}
+		}
+	}
+	if s.check != nil {
+		s.check(ds, v)
+	}
+	if len(v.Errors) > 0 {
+		return v
+	}
+	return nil
+}
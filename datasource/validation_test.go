@@ -0,0 +1,75 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package datasource
+
+import (
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestValidate(c *check.C) {
+	ds := DataSource{
+		URL:          "tsuru.io/metrics",
+		Method:       "FETCH",
+		FailoverURLs: []string{"http://backup.tsuru.io", ""},
+		Extract:      "$.a[",
+	}
+	err := ds.Validate()
+	c.Assert(err, check.FitsTypeOf, &ValidationError{})
+	c.Assert(err.(*ValidationError).Errors, check.DeepEquals, []FieldError{
+		{Field: "url", Message: `invalid url "tsuru.io/metrics": must be an absolute http or https url`},
+		{Field: "failover_urls", Message: "failover urls must not be empty"},
+		{Field: "method", Message: `invalid method "FETCH"`},
+		{Field: "extract", Message: `invalid path "$.a[": missing ]`},
+	})
+}
+
+func (s *S) TestValidatePlaceholders(c *check.C) {
+	valid := []DataSource{
+		{URL: "http://{env.METRICS_HOST}/apps/{app}?from={{ ago \"5m\" | unix }}", Method: "GET"},
+		{URL: "https://tsuru.io/api/v1/query?query=max(cpu{app=\"{app}\"})", Method: "GET"},
+		{URL: "http://prometheus/api/v1/read", Type: TypePrometheusRemoteRead, Body: `{metric}{app="{app}"}`},
+		{URL: "http://es:9200", Type: TypeElasticsearch, Elasticsearch: &Elasticsearch{Index: "metrics", Aggregation: "count"}},
+	}
+	for i := range valid {
+		c.Check(valid[i].Validate(), check.IsNil, check.Commentf("url %q", valid[i].URL))
+	}
+	c.Assert(valid[2].Method, check.Equals, "POST")
+}
+
+func (s *S) TestTypes(c *check.C) {
+	c.Assert(Types(), check.DeepEquals, []string{"aggregate", "elasticsearch", "grpc", "http", "prometheus-remote-read", "push"})
+}
+
+func (s *S) TestValidateType(c *check.C) {
+	ds := DataSource{URL: "http://prometheus/api/v1/read", Type: TypePrometheusRemoteRead, Body: "cpu{app=}", Pagination: &Pagination{}}
+	err := ds.Validate()
+	c.Assert(err, check.FitsTypeOf, &ValidationError{})
+	c.Assert(err.(*ValidationError).Errors, check.DeepEquals, []FieldError{
+		{Field: "pagination", Message: "pagination is not supported by prometheus-remote-read data sources"},
+		{Field: "body", Message: `invalid selector "cpu{app=}"`},
+	})
+	ds = DataSource{URL: "http://es:9200", Type: TypeElasticsearch, Elasticsearch: &Elasticsearch{Index: "metrics", Aggregation: "median"}}
+	c.Assert(ds.Validate(), check.ErrorMatches, `datasource: invalid elasticsearch aggregation "median"`)
+}
+
+func (s *S) TestValidateProxyURL(c *check.C) {
+	ds := DataSource{URL: "http://tsuru.io", Method: "GET", ProxyURL: "proxy:3128"}
+	err := ds.Validate()
+	c.Assert(err, check.FitsTypeOf, &ValidationError{})
+	c.Assert(err.(*ValidationError).Errors, check.DeepEquals, []FieldError{
+		{Field: "proxy_url", Message: `invalid proxy url "proxy:3128": must be an absolute http, https or socks5 url`},
+	})
+	ds.ProxyURL = "direct"
+	c.Assert(ds.Validate(), check.IsNil)
+}
+
+func (s *S) TestValidateBasicAuth(c *check.C) {
+	ds := DataSource{URL: "http://tsuru.io", Method: "GET", Password: "pass"}
+	c.Assert(ds.Validate(), check.ErrorMatches, `datasource: username required by password`)
+	ds = DataSource{URL: "http://tsuru.io", Method: "GET", Username: "user", OAuth2: &OAuth2{ClientID: "client"}}
+	c.Assert(ds.Validate(), check.ErrorMatches, `datasource: basic authentication can not be used with oauth2`)
+	ds = DataSource{URL: "http://tsuru.io", Method: "GET", Username: "user"}
+	c.Assert(ds.Validate(), check.IsNil)
+}
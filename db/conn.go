@@ -2,17 +2,29 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package db encapsulates tsuru-autoscale connection with MongoDB.
+// Package db encapsulates tsuru-autoscale storage and its connection with
+// MongoDB.
 //
-// The function Conn dials to MongoDB using data from the configuration file
-// and returns a connection (represented by the storage.Storage type). It
-// manages an internal pool of connections, and reconnects in case of failures.
-// That means that you should not store references to the connection, but
-// always call Open.
+// The function Open returns the storage of the auto scales, alarms, data
+// sources, actions and events (represented by the Storage interface), from
+// the backend set with SetBackend, MongoDB by default.
+//
+// The function Conn returns a connection to MongoDB (represented by the
+// MongoStorage type), for the other collections, copied from a session
+// shared by the process, which is dialed on the first call, using data from
+// the environment, and pools the sockets to MongoDB. The connections must be
+// closed after use, returning their sockets to the pool, so you should not
+// store references to them, but always call Open or Conn. The function
+// OpenReports returns the storage of the reports, whose queries may read
+// from the secondaries.
 package db
 
 import (
 	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/tsuru/tsuru/db/storage"
 	"gopkg.in/mgo.v2"
@@ -25,13 +37,24 @@ const (
 	DefaultDatabaseName = "tsuru_autoscale"
 )
 
-// Storage represents a storage
-type Storage struct {
-	*storage.Storage
+var (
+	// sessions are the sessions shared by the process, by database url.
+	sessions   = map[string]*mgo.Session{}
+	sessionsMu sync.Mutex
+)
+
+// MongoStorage represents a connection to MongoDB: a session copied from
+// the shared session, with its own socket, the database name and the prefix
+// of the collections.
+type MongoStorage struct {
+	session *mgo.Session
+	dbname  string
+	prefix  string
+	closed  int32
 }
 
-// conn reads the tsuru-autoscale config and calls storage.Open to get a database connection.
-func conn() (*storage.Storage, error) {
+// config returns the database url and name from the environment.
+func config() (string, string) {
 	url := os.Getenv("MONGODB_URL")
 	if url == "" {
 		url = DefaultDatabaseURL
@@ -40,73 +63,194 @@ func conn() (*storage.Storage, error) {
 	if dbname == "" {
 		dbname = DefaultDatabaseName
 	}
-	return storage.Open(url, dbname)
+	return url, dbname
+}
+
+// poolLimit is the maximum number of sockets to each MongoDB server, from
+// MONGODB_POOL_LIMIT, or 0 for the mgo default.
+func poolLimit() int {
+	if l := os.Getenv("MONGODB_POOL_LIMIT"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err == nil && v > 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+// shared returns the session shared by the process for the url, dialing it
+// on the first call with the options of the environment. Failed dials are
+// not cached, so they are retried, but only after a backoff, doubled after
+// each failure in a row; until then UnavailableError is returned.
+func shared(url string) (*mgo.Session, error) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if s, ok := sessions[url]; ok {
+		return s, nil
+	}
+	failure := dialFailures[url]
+	if failure != nil && time.Now().Before(failure.retry) {
+		connectionErrors.Inc("unavailable")
+		return nil, &UnavailableError{Err: failure.err, Retry: failure.retry}
+	}
+	s, err := dial(url)
+	if err != nil {
+		connectionErrors.Inc("dial")
+		if failure == nil {
+			failure = &dialFailure{}
+			dialFailures[url] = failure
+		}
+		failure.failures++
+		failure.err = err
+		failure.retry = time.Now().Add(reconnectBackoff(failure.failures))
+		return nil, err
+	}
+	delete(dialFailures, url)
+	sessions[url] = s
+	return s, nil
+}
+
+// Conn creates a database connection, copying the shared session, so the
+// operations of the connection do not block the other connections. It
+// reconnects in case of failures, as the shared session keeps monitoring
+// the MongoDB servers.
+func Conn() (*MongoStorage, error) {
+	url, dbname := config()
+	if err := checkDatabaseName(dbname); err != nil {
+		return nil, err
+	}
+	prefix, err := collectionPrefix()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	s, err := shared(url)
+	connectionWait.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+	addInUse(1)
+	return &MongoStorage{session: s.Copy(), dbname: dbname, prefix: prefix}, nil
+}
+
+// ReportsConn creates a database connection for the expensive read-only
+// queries of the reports, like the exports of the events and the
+// statistics of the instances, with the read preference of
+// MONGODB_REPORTS_READ_PREFERENCE, so they can be routed to the
+// secondaries while the reads of the alarms stay on the primary.
+func ReportsConn() (*MongoStorage, error) {
+	mode, err := reportsReadMode()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := Conn()
+	if err != nil {
+		return nil, err
+	}
+	conn.session.SetMode(mode, true)
+	return conn, nil
 }
 
-// Conn creates a database connection
-func Conn() (*Storage, error) {
-	var (
-		strg Storage
-		err  error
-	)
-	strg.Storage, err = conn()
-	return &strg, err
+// Close closes the shared sessions, when the process stops. The connections
+// created later dial new ones.
+func Close() {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	for url, s := range sessions {
+		s.Close()
+		delete(sessions, url)
+	}
+	dialFailures = map[string]*dialFailure{}
+}
+
+// Close closes the connection, returning its socket to the pool.
+func (s *MongoStorage) Close() {
+	if atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		addInUse(-1)
+	}
+	s.session.Close()
+}
+
+// Collection returns a collection of the database, with the prefix of
+// MONGODB_COLLECTION_PREFIX. If the collection does not exist, MongoDB will
+// create it.
+func (s *MongoStorage) Collection(name string) *storage.Collection {
+	return &storage.Collection{Collection: s.session.DB(s.dbname).C(s.prefix + name)}
 }
 
 // Events returns the events collection from MongoDB.
-func (s *Storage) Events() *storage.Collection {
-	c := s.Collection("events")
-	alarmName := mgo.Index{Key: []string{"alarm.name"}}
-	c.EnsureIndex(alarmName)
-	startTime := mgo.Index{Key: []string{"-starttime"}}
-	c.EnsureIndex(startTime)
-	return c
+func (s *MongoStorage) Events() *storage.Collection {
+	return s.indexed("events")
 }
 
 // Configs returns the configs collection from MongoDB.
-func (s *Storage) Configs() *storage.Collection {
-	c := s.Collection("configs")
-	return c
+func (s *MongoStorage) Configs() *storage.Collection {
+	return s.indexed("configs")
 }
 
 // Instances returns the instances collection from MongoDB.
-func (s *Storage) Instances() *storage.Collection {
-	nameIndex := mgo.Index{Key: []string{"name"}, Unique: true}
-	c := s.Collection("instances")
-	c.EnsureIndex(nameIndex)
-	return c
+func (s *MongoStorage) Instances() *storage.Collection {
+	return s.indexed("instances")
 }
 
 // DataSources returns the datasources collection from MongoDB.
-func (s *Storage) DataSources() *storage.Collection {
-	nameIndex := mgo.Index{Key: []string{"name"}, Unique: true}
-	c := s.Collection("datasources")
-	c.EnsureIndex(nameIndex)
-	return c
+func (s *MongoStorage) DataSources() *storage.Collection {
+	return s.indexed("datasources")
+}
+
+// DataSourceStatus returns the datasource status collection from MongoDB.
+func (s *MongoStorage) DataSourceStatus() *storage.Collection {
+	return s.indexed("datasource_status")
+}
+
+// DataSourceSamples returns the collection of samples pushed to data
+// sources from MongoDB.
+func (s *MongoStorage) DataSourceSamples() *storage.Collection {
+	return s.indexed("datasource_samples")
 }
 
 // Alarms returns the alarms collection from MongoDB.
-func (s *Storage) Alarms() *storage.Collection {
-	c := s.Collection("alarms")
-	nameIndex := mgo.Index{Key: []string{"name"}, Unique: true}
-	c.EnsureIndex(nameIndex)
-	instanceIndex := mgo.Index{Key: []string{"instance"}}
-	c.EnsureIndex(instanceIndex)
-	return c
+func (s *MongoStorage) Alarms() *storage.Collection {
+	return s.indexed("alarms")
 }
 
 // Actions returns the actions collection from MongoDB.
-func (s *Storage) Actions() *storage.Collection {
-	nameIndex := mgo.Index{Key: []string{"name"}, Unique: true}
-	c := s.Collection("actions")
-	c.EnsureIndex(nameIndex)
-	return c
+func (s *MongoStorage) Actions() *storage.Collection {
+	return s.indexed("actions")
+}
+
+// ActionExecutions returns the collection of the action executions history
+// from MongoDB.
+func (s *MongoStorage) ActionExecutions() *storage.Collection {
+	return s.indexed("action_executions")
+}
+
+// ActionDeadLetters returns the collection of the failed action executions
+// from MongoDB.
+func (s *MongoStorage) ActionDeadLetters() *storage.Collection {
+	return s.indexed("action_dead_letters")
+}
+
+// Runners returns the collection of the auto scale runners status from
+// MongoDB.
+func (s *MongoStorage) Runners() *storage.Collection {
+	return s.indexed("runners")
+}
+
+// IdempotencyKeys returns the collection of the outcomes of the requests
+// with idempotency keys from MongoDB. They are removed after their
+// expiration time.
+func (s *MongoStorage) IdempotencyKeys() *storage.Collection {
+	return s.indexed("idempotency_keys")
+}
+
+// AuditLog returns the collection of the state changing api requests from
+// MongoDB. They are removed after their expiration time.
+func (s *MongoStorage) AuditLog() *storage.Collection {
+	return s.indexed("audit_log")
 }
 
 // Wizard returns the wizard collection from MongoDB.
-func (s *Storage) Wizard() *storage.Collection {
-	nameIndex := mgo.Index{Key: []string{"name"}, Unique: true}
-	c := s.Collection("wizard")
-	c.EnsureIndex(nameIndex)
-	return c
+func (s *MongoStorage) Wizard() *storage.Collection {
+	return s.indexed("wizard")
 }
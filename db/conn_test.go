@@ -5,11 +5,19 @@
 package db
 
 import (
+	"bytes"
+	"errors"
+	"os"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/tsuru/tsuru-autoscale/metrics"
 	"github.com/tsuru/tsuru/db/storage"
 	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func Test(t *testing.T) { check.TestingT(t) }
@@ -18,6 +26,20 @@ type S struct{}
 
 var _ = check.Suite(&S{})
 
+// SetUpSuite skips the tests of MongoDB unless AUTOSCALE_TEST_STORAGE is
+// mongodb, like the suites using storagetest.
+func (s *S) SetUpSuite(c *check.C) {
+	if os.Getenv("AUTOSCALE_TEST_STORAGE") != "mongodb" {
+		c.Skip("AUTOSCALE_TEST_STORAGE is not mongodb")
+	}
+}
+
+// ConfigSuite tests the configuration of the environment, without a
+// MongoDB.
+type ConfigSuite struct{}
+
+var _ = check.Suite(&ConfigSuite{})
+
 type hasIndexChecker struct{}
 
 func (c *hasIndexChecker) Info() *check.CheckerInfo {
@@ -76,14 +98,112 @@ func (c *hasUniqueIndexChecker) Check(params []interface{}, names []string) (boo
 
 var HasUniqueIndex check.Checker = &hasUniqueIndexChecker{}
 
+func (s *S) TestConnCopiesSharedSession(c *check.C) {
+	conn1, err := Conn()
+	c.Assert(err, check.IsNil)
+	conn2, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn2.Close()
+	c.Assert(conn1.session, check.Not(check.Equals), conn2.session)
+	url, _ := config()
+	c.Assert(sessions[url], check.NotNil)
+	conn1.Close()
+	err = conn2.session.Ping()
+	c.Assert(err, check.IsNil)
+	conn3, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn3.Close()
+	_, err = conn3.Events().Count()
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestReportsConn(c *check.C) {
+	os.Setenv("MONGODB_REPORTS_READ_PREFERENCE", "secondaryPreferred")
+	defer os.Unsetenv("MONGODB_REPORTS_READ_PREFERENCE")
+	conn, err := ReportsConn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	c.Assert(conn.session.Mode(), check.Equals, mgo.SecondaryPreferred)
+	_, err = conn.Events().Count()
+	c.Assert(err, check.IsNil)
+	other, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer other.Close()
+	c.Assert(other.session.Mode(), check.Equals, mgo.Primary)
+}
+
+func (s *S) TestConnInUse(c *check.C) {
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	n := atomic.LoadInt64(&inUse)
+	conn.Close()
+	conn.Close()
+	c.Assert(atomic.LoadInt64(&inUse), check.Equals, n-1)
+}
+
+func (s *ConfigSuite) TestConnBackoff(c *check.C) {
+	url := "mongodb://unreachable:27017"
+	os.Setenv("MONGODB_URL", url)
+	defer os.Unsetenv("MONGODB_URL")
+	sessionsMu.Lock()
+	dialFailures[url] = &dialFailure{failures: 1, err: errors.New("no reachable servers"), retry: time.Now().Add(time.Minute)}
+	sessionsMu.Unlock()
+	defer func() {
+		sessionsMu.Lock()
+		delete(dialFailures, url)
+		sessionsMu.Unlock()
+	}()
+	_, err := Conn()
+	c.Assert(err, check.FitsTypeOf, &UnavailableError{})
+	c.Assert(err, check.ErrorMatches, "db: mongodb is unavailable, retrying in (59s|1m0s): no reachable servers")
+}
+
+func (s *ConfigSuite) TestReconnectBackoff(c *check.C) {
+	var backoffs []time.Duration
+	for _, failures := range []int{1, 2, 3, 6, 7, 100} {
+		backoffs = append(backoffs, reconnectBackoff(failures))
+	}
+	c.Assert(backoffs, check.DeepEquals, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 32 * time.Second, time.Minute, time.Minute})
+}
+
+func (s *ConfigSuite) TestCollectMetrics(c *check.C) {
+	CollectMetrics()
+	var buf bytes.Buffer
+	err := metrics.Write(&buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, `(?s).*autoscale_db_sockets\{state="alive"\} \d+\nautoscale_db_sockets\{state="in_use"\} \d+\n.*`)
+}
+
+func (s *S) TestCollectionPrefix(c *check.C) {
+	os.Setenv("MONGODB_COLLECTION_PREFIX", "staging_")
+	defer os.Unsetenv("MONGODB_COLLECTION_PREFIX")
+	conn, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	c.Assert(conn.Events().Name, check.Equals, "staging_events")
+	c.Assert(conn.Collection("alarms").FullName, check.Equals, conn.Collection("alarms").Database.Name+".staging_alarms")
+}
+
+func (s *ConfigSuite) TestPoolLimit(c *check.C) {
+	os.Setenv("MONGODB_POOL_LIMIT", "10")
+	defer os.Unsetenv("MONGODB_POOL_LIMIT")
+	c.Assert(poolLimit(), check.Equals, 10)
+	os.Setenv("MONGODB_POOL_LIMIT", "none")
+	c.Assert(poolLimit(), check.Equals, 0)
+	os.Unsetenv("MONGODB_POOL_LIMIT")
+	c.Assert(poolLimit(), check.Equals, 0)
+}
+
 func (s *S) TestEvents(c *check.C) {
 	strg, err := Conn()
 	c.Assert(err, check.IsNil)
 	event := strg.Events()
 	eventc := strg.Collection("events")
 	c.Assert(event, check.DeepEquals, eventc)
-	c.Assert(event, HasIndex, []string{"alarm.name"})
+	c.Assert(event, HasIndex, []string{"alarm.name", "-starttime"})
 	c.Assert(event, HasIndex, []string{"-starttime"})
+	c.Assert(event, HasIndex, []string{"alarm.instance", "-starttime"})
+	c.Assert(event, HasIndex, []string{"alarm.instance", "action.name"})
 }
 
 func (s *S) TestConfigs(c *check.C) {
@@ -112,6 +232,71 @@ func (s *S) TestDataSources(c *check.C) {
 	c.Assert(datasource, HasUniqueIndex, []string{"name"})
 }
 
+func (s *S) TestDataSourceStatus(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	status := strg.DataSourceStatus()
+	statusc := strg.Collection("datasource_status")
+	c.Assert(status, check.DeepEquals, statusc)
+	c.Assert(status, HasUniqueIndex, []string{"name"})
+}
+
+func (s *S) TestDataSourceSamples(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	samples := strg.DataSourceSamples()
+	samplesc := strg.Collection("datasource_samples")
+	c.Assert(samples, check.DeepEquals, samplesc)
+	c.Assert(samples, HasUniqueIndex, []string{"name"})
+}
+
+func (s *S) TestActionExecutions(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	executions := strg.ActionExecutions()
+	executionsc := strg.Collection("action_executions")
+	c.Assert(executions, check.DeepEquals, executionsc)
+	c.Assert(executions, HasIndex, []string{"action", "-time"})
+	c.Assert(executions, HasIndex, []string{"alarm", "-time"})
+}
+
+func (s *S) TestActionDeadLetters(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	letters := strg.ActionDeadLetters()
+	lettersc := strg.Collection("action_dead_letters")
+	c.Assert(letters, check.DeepEquals, lettersc)
+	c.Assert(letters, HasIndex, []string{"action", "-time"})
+}
+
+func (s *S) TestRunners(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	runners := strg.Runners()
+	runnersc := strg.Collection("runners")
+	c.Assert(runners, check.DeepEquals, runnersc)
+}
+
+func (s *S) TestIdempotencyKeys(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	keys := strg.IdempotencyKeys()
+	keysc := strg.Collection("idempotency_keys")
+	c.Assert(keys, check.DeepEquals, keysc)
+	c.Assert(keys, HasIndex, []string{"expiresat"})
+}
+
+func (s *S) TestAuditLog(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	audit := strg.AuditLog()
+	auditc := strg.Collection("audit_log")
+	c.Assert(audit, check.DeepEquals, auditc)
+	c.Assert(audit, HasIndex, []string{"-time"})
+	c.Assert(audit, HasIndex, []string{"resource.type", "resource.name", "-time"})
+	c.Assert(audit, HasIndex, []string{"expiresat"})
+}
+
 func (s *S) TestAlarms(c *check.C) {
 	strg, err := Conn()
 	c.Assert(err, check.IsNil)
@@ -120,6 +305,7 @@ func (s *S) TestAlarms(c *check.C) {
 	c.Assert(alarm, check.DeepEquals, alarmc)
 	c.Assert(alarm, HasUniqueIndex, []string{"name"})
 	c.Assert(alarm, HasIndex, []string{"instance"})
+	c.Assert(alarm, HasIndex, []string{"enabled"})
 }
 
 func (s *S) TestActions(c *check.C) {
@@ -139,3 +325,117 @@ func (s *S) TestWizard(c *check.C) {
 	c.Assert(wizard, check.DeepEquals, wizardc)
 	c.Assert(wizard, HasUniqueIndex, []string{"name"})
 }
+
+func (s *S) TestEnsureIndexes(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	err = strg.Collection("alarms").DropCollection()
+	c.Assert(err, check.IsNil)
+	strg.session.ResetIndexCache()
+	err = EnsureIndexes()
+	c.Assert(err, check.IsNil)
+	alarms := strg.Collection("alarms")
+	c.Assert(alarms, HasUniqueIndex, []string{"name"})
+	c.Assert(alarms, HasIndex, []string{"enabled"})
+	c.Assert(strg.Collection("events"), HasIndex, []string{"alarm.name", "-starttime"})
+}
+
+func (s *ConfigSuite) TestEventsTTL(c *check.C) {
+	ttl, err := EventsTTL()
+	c.Assert(err, check.IsNil)
+	c.Assert(ttl, check.Equals, time.Duration(0))
+	os.Setenv("AUTOSCALE_EVENTS_TTL", "86400")
+	defer os.Unsetenv("AUTOSCALE_EVENTS_TTL")
+	ttl, err = EventsTTL()
+	c.Assert(err, check.IsNil)
+	c.Assert(ttl, check.Equals, 24*time.Hour)
+	os.Setenv("AUTOSCALE_EVENTS_TTL", "1d")
+	_, err = EventsTTL()
+	c.Assert(err, check.ErrorMatches, `invalid AUTOSCALE_EVENTS_TTL "1d"`)
+}
+
+func (s *S) TestEnsureEventsTTL(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	events := strg.Collection("events")
+	events.DropCollection()
+	ttl := func() time.Duration {
+		indexes, err := events.Indexes()
+		c.Assert(err, check.IsNil)
+		for _, index := range indexes {
+			if reflect.DeepEqual(index.Key, []string{"starttime"}) {
+				return index.ExpireAfter
+			}
+		}
+		return 0
+	}
+	c.Assert(ensureEventsTTL(events, 0), check.IsNil)
+	c.Assert(ensureEventsTTL(events, time.Hour), check.IsNil)
+	c.Assert(ttl(), check.Equals, time.Hour)
+	c.Assert(ensureEventsTTL(events, 2*time.Hour), check.IsNil)
+	c.Assert(ttl(), check.Equals, 2*time.Hour)
+	c.Assert(ensureEventsTTL(events, 0), check.IsNil)
+	c.Assert(ttl(), check.Equals, time.Duration(0))
+}
+
+func (s *ConfigSuite) TestEventsCapped(c *check.C) {
+	capped, err := EventsCapped()
+	c.Assert(err, check.IsNil)
+	c.Assert(capped, check.IsNil)
+	os.Setenv("AUTOSCALE_EVENTS_CAPPED_SIZE", "1048576")
+	defer os.Unsetenv("AUTOSCALE_EVENTS_CAPPED_SIZE")
+	capped, err = EventsCapped()
+	c.Assert(err, check.IsNil)
+	c.Assert(capped, check.DeepEquals, &Capped{Size: 1048576, DocumentSize: 16384})
+	os.Setenv("AUTOSCALE_EVENTS_CAPPED_MAX", "100")
+	defer os.Unsetenv("AUTOSCALE_EVENTS_CAPPED_MAX")
+	os.Setenv("AUTOSCALE_EVENTS_CAPPED_DOCUMENT_SIZE", "4096")
+	defer os.Unsetenv("AUTOSCALE_EVENTS_CAPPED_DOCUMENT_SIZE")
+	capped, err = EventsCapped()
+	c.Assert(err, check.IsNil)
+	c.Assert(capped, check.DeepEquals, &Capped{Size: 1048576, MaxDocs: 100, DocumentSize: 4096})
+	os.Setenv("AUTOSCALE_EVENTS_CAPPED_DOCUMENT_SIZE", "2097152")
+	_, err = EventsCapped()
+	c.Assert(err, check.ErrorMatches, `invalid AUTOSCALE_EVENTS_CAPPED_DOCUMENT_SIZE 2097152, .*`)
+	os.Setenv("AUTOSCALE_EVENTS_CAPPED_MAX", "-1")
+	_, err = EventsCapped()
+	c.Assert(err, check.ErrorMatches, `invalid AUTOSCALE_EVENTS_CAPPED_MAX "-1"`)
+	os.Setenv("AUTOSCALE_EVENTS_CAPPED_SIZE", "1mb")
+	_, err = EventsCapped()
+	c.Assert(err, check.ErrorMatches, `invalid AUTOSCALE_EVENTS_CAPPED_SIZE "1mb"`)
+}
+
+func (s *S) TestEnsureEventsCapped(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	events := strg.Collection("events")
+	events.DropCollection()
+	defer events.DropCollection()
+	stats := func() (capped bool, size int) {
+		var result struct {
+			Capped  bool `bson:"capped"`
+			MaxSize int  `bson:"maxSize"`
+		}
+		err := events.Database.Run(bson.D{{Name: "collStats", Value: events.Name}}, &result)
+		c.Assert(err, check.IsNil)
+		return result.Capped, result.MaxSize
+	}
+	c.Assert(ensureEventsCapped(events, nil), check.IsNil)
+	err = events.Insert(bson.M{"name": "event"})
+	c.Assert(err, check.IsNil)
+	c.Assert(ensureEventsCapped(events, &Capped{Size: 1 << 20, DocumentSize: 1024}), check.IsNil)
+	capped, size := stats()
+	c.Assert(capped, check.Equals, true)
+	c.Assert(size, check.Equals, 1<<20)
+	n, err := events.Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+	events.DropCollection()
+	c.Assert(ensureEventsCapped(events, &Capped{Size: 1 << 20, DocumentSize: 1024}), check.IsNil)
+	capped, size = stats()
+	c.Assert(capped, check.Equals, true)
+	c.Assert(size, check.Equals, 1<<20)
+}
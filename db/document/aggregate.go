@@ -0,0 +1,398 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package document
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// NewPipe returns the aggregation pipeline of the documents loaded by load,
+// which loads the documents matching the first $match stage.
+func NewPipe(pipeline interface{}, load Loader) db.Pipe {
+	return &loadedPipe{pipeline: pipeline, load: load}
+}
+
+type loadedPipe struct {
+	pipeline interface{}
+	load     Loader
+}
+
+func (p *loadedPipe) All(result interface{}) error {
+	stages, err := toStages(p.pipeline)
+	if err != nil {
+		return err
+	}
+	q := bson.M{}
+	if len(stages) > 0 {
+		if match, ok := stages[0]["$match"].(bson.M); ok {
+			q = match
+		}
+	}
+	docs, err := p.load(q)
+	if err != nil {
+		return err
+	}
+	docs, err = aggregate(docs, stages)
+	if err != nil {
+		return err
+	}
+	return decodeAll(docs, result)
+}
+
+// Aggregate runs the aggregation pipeline on the documents: the $match,
+// $sort, $skip, $limit, $project and $group stages, with the $sum, $avg,
+// $min, $max, $first, $last, $push and $addToSet accumulators, and the
+// $cond and $ifNull expressions. The keys of a $sort stage are sorted by
+// name, as the order of a bson.M is lost.
+func Aggregate(docs []bson.M, pipeline interface{}) ([]bson.M, error) {
+	stages, err := toStages(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return aggregate(docs, stages)
+}
+
+// toStages converts the pipeline, like a []bson.M, to its stages.
+func toStages(pipeline interface{}) ([]bson.M, error) {
+	doc, err := ToDoc(bson.M{"pipeline": pipeline})
+	if err != nil {
+		return nil, err
+	}
+	items, ok := doc["pipeline"].([]interface{})
+	if !ok {
+		return nil, errors.New("document: the pipeline must be an array")
+	}
+	stages := make([]bson.M, len(items))
+	for i, item := range items {
+		stage, ok := item.(bson.M)
+		if !ok || len(stage) != 1 {
+			return nil, errors.New("document: a pipeline stage must be a document with one field")
+		}
+		stages[i] = stage
+	}
+	return stages, nil
+}
+
+func aggregate(docs []bson.M, stages []bson.M) ([]bson.M, error) {
+	var err error
+	for _, stage := range stages {
+		for name, arg := range stage {
+			switch name {
+			case "$match":
+				q, ok := arg.(bson.M)
+				if !ok {
+					return nil, errors.New("document: $match must be a document")
+				}
+				docs, err = Filter(docs, q)
+			case "$sort":
+				docs, err = sortStage(docs, arg)
+			case "$skip", "$limit":
+				n, ok := number(arg)
+				if !ok || n < 0 {
+					return nil, fmt.Errorf("document: %s must be a positive number", name)
+				}
+				i := len(docs)
+				if int(n) < i {
+					i = int(n)
+				}
+				if name == "$skip" {
+					docs = docs[i:]
+				} else {
+					docs = docs[:i]
+				}
+			case "$project":
+				selector, ok := arg.(bson.M)
+				if !ok {
+					return nil, errors.New("document: $project must be a document")
+				}
+				projected := make([]bson.M, len(docs))
+				for i, doc := range docs {
+					if projected[i], err = Project(doc, selector); err != nil {
+						return nil, err
+					}
+				}
+				docs = projected
+			case "$group":
+				docs, err = group(docs, arg)
+			default:
+				return nil, fmt.Errorf("document: unsupported pipeline stage %s", name)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return docs, nil
+}
+
+func sortStage(docs []bson.M, arg interface{}) ([]bson.M, error) {
+	keys, ok := arg.(bson.M)
+	if !ok || len(keys) == 0 {
+		return nil, errors.New("document: $sort must be a document with the sort keys")
+	}
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]string, len(names))
+	for i, name := range names {
+		n, _ := number(keys[name])
+		switch n {
+		case 1:
+			fields[i] = name
+		case -1:
+			fields[i] = "-" + name
+		default:
+			return nil, fmt.Errorf("document: invalid $sort order of %s", name)
+		}
+	}
+	sorted := append([]bson.M{}, docs...)
+	Sort(sorted, fields)
+	return sorted, nil
+}
+
+// accumulator accumulates the values of a field of a group.
+type accumulator struct {
+	op     string
+	values []interface{}
+}
+
+func (a *accumulator) result() (interface{}, error) {
+	switch a.op {
+	case "$sum", "$avg":
+		var sum float64
+		var n int
+		integral := a.op == "$sum"
+		for _, value := range a.values {
+			if v, ok := number(value); ok {
+				sum += v
+				n++
+				if _, ok := value.(float64); ok {
+					integral = false
+				}
+			}
+		}
+		if a.op == "$avg" {
+			if n == 0 {
+				return nil, nil
+			}
+			return sum / float64(n), nil
+		}
+		if integral {
+			return int(sum), nil
+		}
+		return sum, nil
+	case "$min", "$max":
+		var result interface{}
+		for _, value := range a.values {
+			if value == nil {
+				continue
+			}
+			if result == nil {
+				result = value
+				continue
+			}
+			n, ok := compare(value, result)
+			if ok && (n < 0) == (a.op == "$min") && n != 0 {
+				result = value
+			}
+		}
+		return result, nil
+	case "$first":
+		if len(a.values) == 0 {
+			return nil, nil
+		}
+		return a.values[0], nil
+	case "$last":
+		if len(a.values) == 0 {
+			return nil, nil
+		}
+		return a.values[len(a.values)-1], nil
+	case "$push":
+		return append([]interface{}{}, a.values...), nil
+	case "$addToSet":
+		var set []interface{}
+		for _, value := range a.values {
+			found := false
+			for _, item := range set {
+				if Equal(item, value) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				set = append(set, value)
+			}
+		}
+		return set, nil
+	}
+	return nil, fmt.Errorf("document: unsupported accumulator %s", a.op)
+}
+
+func group(docs []bson.M, arg interface{}) ([]bson.M, error) {
+	spec, ok := arg.(bson.M)
+	if !ok {
+		return nil, errors.New("document: $group must be a document")
+	}
+	id, ok := spec["_id"]
+	if !ok {
+		return nil, errors.New("document: $group must have an _id")
+	}
+	type groupAccumulators struct {
+		id           interface{}
+		accumulators map[string]*accumulator
+	}
+	var groups []*groupAccumulators
+	for _, doc := range docs {
+		key, err := eval(doc, id)
+		if err != nil {
+			return nil, err
+		}
+		var g *groupAccumulators
+		for _, other := range groups {
+			if Equal(other.id, key) {
+				g = other
+				break
+			}
+		}
+		if g == nil {
+			g = &groupAccumulators{id: key, accumulators: map[string]*accumulator{}}
+			groups = append(groups, g)
+		}
+		for field, value := range spec {
+			if field == "_id" {
+				continue
+			}
+			acc, ok := value.(bson.M)
+			if !ok || len(acc) != 1 {
+				return nil, fmt.Errorf("document: the accumulator of %s must be a document with one operator", field)
+			}
+			for op, expr := range acc {
+				v, err := eval(doc, expr)
+				if err != nil {
+					return nil, err
+				}
+				a := g.accumulators[field]
+				if a == nil {
+					a = &accumulator{op: op}
+					g.accumulators[field] = a
+				}
+				a.values = append(a.values, v)
+			}
+		}
+	}
+	result := make([]bson.M, len(groups))
+	for i, g := range groups {
+		doc := bson.M{"_id": g.id}
+		for field, a := range g.accumulators {
+			value, err := a.result()
+			if err != nil {
+				return nil, err
+			}
+			doc[field] = value
+		}
+		result[i] = doc
+	}
+	return result, nil
+}
+
+// eval evaluates the expression on the document: the field paths, like
+// "$alarm.name", the $cond and $ifNull operators, and the literals.
+func eval(doc bson.M, expr interface{}) (interface{}, error) {
+	switch e := expr.(type) {
+	case string:
+		if strings.HasPrefix(e, "$") {
+			values := Lookup(doc, strings.Split(e[1:], "."))
+			if len(values) == 0 {
+				return nil, nil
+			}
+			return values[0], nil
+		}
+	case []interface{}:
+		values := make([]interface{}, len(e))
+		for i, item := range e {
+			v, err := eval(doc, item)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return values, nil
+	case bson.M:
+		if !IsOperators(e) {
+			result := bson.M{}
+			for key, item := range e {
+				v, err := eval(doc, item)
+				if err != nil {
+					return nil, err
+				}
+				result[key] = v
+			}
+			return result, nil
+		}
+		if len(e) != 1 {
+			return nil, errors.New("document: an expression must have one operator")
+		}
+		for op, arg := range e {
+			return evalOperator(doc, op, arg)
+		}
+	}
+	return expr, nil
+}
+
+func evalOperator(doc bson.M, op string, arg interface{}) (interface{}, error) {
+	var args []interface{}
+	switch a := arg.(type) {
+	case []interface{}:
+		args = a
+	case bson.M:
+		if op == "$cond" {
+			args = []interface{}{a["if"], a["then"], a["else"]}
+		}
+	}
+	switch op {
+	case "$cond":
+		if len(args) != 3 {
+			return nil, errors.New("document: $cond must have the if, then and else expressions")
+		}
+		cond, err := eval(doc, args[0])
+		if err != nil {
+			return nil, err
+		}
+		if truthy(cond) {
+			return eval(doc, args[1])
+		}
+		return eval(doc, args[2])
+	case "$ifNull":
+		if len(args) != 2 {
+			return nil, errors.New("document: $ifNull must have two expressions")
+		}
+		value, err := eval(doc, args[0])
+		if err != nil || value != nil {
+			return value, err
+		}
+		return eval(doc, args[1])
+	}
+	return nil, fmt.Errorf("document: unsupported expression operator %s", op)
+}
+
+// truthy returns whether the value is true in a $cond: all the values but
+// false, null and zero.
+func truthy(value interface{}) bool {
+	if value == nil || value == false {
+		return false
+	}
+	if n, ok := number(value); ok {
+		return n != 0
+	}
+	return true
+}
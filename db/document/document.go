@@ -0,0 +1,788 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package document evaluates the MongoDB query and update documents used
+// by tsuru-autoscale on the documents loaded by the storage backends which
+// are not MongoDB: the comparison, $in, $nin, $exists, $regex and $not
+// operators, the $and, $or and $nor queries, the $set, $unset, $inc,
+// $push, $pull and $addToSet updates, and the aggregation pipelines of
+// Aggregate.
+package document
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ToDoc converts the value to a document, as stored by MongoDB.
+func ToDoc(value interface{}) (bson.M, error) {
+	doc := bson.M{}
+	if value == nil {
+		return doc, nil
+	}
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	err = bson.Unmarshal(data, &doc)
+	return doc, err
+}
+
+// Decode stores the document in result, like the results of MongoDB.
+func Decode(doc bson.M, result interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, result)
+}
+
+// Loader loads the documents of a repository which may match the query,
+// evaluated by the query afterwards. The loader can return all the
+// documents, or use the query to skip the ones not matching it.
+type Loader func(q bson.M) ([]bson.M, error)
+
+// NewQuery returns the query of the documents loaded by load, evaluating
+// the query, sort, selector and page of the query.
+func NewQuery(query interface{}, load Loader) db.Query {
+	return &loadedQuery{query: query, load: load}
+}
+
+type loadedQuery struct {
+	query    interface{}
+	load     Loader
+	sort     []string
+	selector interface{}
+	skip     int
+	limit    int
+}
+
+func (q *loadedQuery) Sort(fields ...string) db.Query {
+	q.sort = fields
+	return q
+}
+
+func (q *loadedQuery) Select(selector interface{}) db.Query {
+	q.selector = selector
+	return q
+}
+
+func (q *loadedQuery) Skip(n int) db.Query {
+	q.skip = n
+	return q
+}
+
+func (q *loadedQuery) Limit(n int) db.Query {
+	q.limit = n
+	return q
+}
+
+// run returns the documents of the query.
+func (q *loadedQuery) run() ([]bson.M, error) {
+	filter, err := ToDoc(q.query)
+	if err != nil {
+		return nil, err
+	}
+	loaded, err := q.load(filter)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := Filter(loaded, filter)
+	if err != nil {
+		return nil, err
+	}
+	Sort(docs, q.sort)
+	if q.skip >= len(docs) {
+		docs = nil
+	} else {
+		docs = docs[q.skip:]
+	}
+	if q.limit > 0 && q.limit < len(docs) {
+		docs = docs[:q.limit]
+	}
+	selector, err := ToDoc(q.selector)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]bson.M, len(docs))
+	for i, doc := range docs {
+		if result[i], err = Project(doc, selector); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (q *loadedQuery) Count() (int, error) {
+	docs, err := q.run()
+	return len(docs), err
+}
+
+func (q *loadedQuery) One(result interface{}) error {
+	docs, err := q.run()
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return mgo.ErrNotFound
+	}
+	return Decode(docs[0], result)
+}
+
+func (q *loadedQuery) All(result interface{}) error {
+	docs, err := q.run()
+	if err != nil {
+		return err
+	}
+	return decodeAll(docs, result)
+}
+
+// decodeAll stores the documents in result, the address of a slice.
+func decodeAll(docs []bson.M, result interface{}) error {
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
+	slicev := resultv.Elem().Slice(0, 0)
+	elemt := slicev.Type().Elem()
+	for _, doc := range docs {
+		elemp := reflect.New(elemt)
+		if err := Decode(doc, elemp.Interface()); err != nil {
+			return err
+		}
+		slicev = reflect.Append(slicev, elemp.Elem())
+	}
+	resultv.Elem().Set(slicev)
+	return nil
+}
+
+func (q *loadedQuery) Iter() db.Iter {
+	docs, err := q.run()
+	return &iter{docs: docs, err: err}
+}
+
+type iter struct {
+	docs []bson.M
+	err  error
+}
+
+func (it *iter) Next(result interface{}) bool {
+	if it.err != nil || len(it.docs) == 0 {
+		return false
+	}
+	it.err = Decode(it.docs[0], result)
+	it.docs = it.docs[1:]
+	return it.err == nil
+}
+
+func (it *iter) Close() error {
+	return it.err
+}
+
+// Filter returns the documents matching the query.
+func Filter(docs []bson.M, q bson.M) ([]bson.M, error) {
+	var result []bson.M
+	for _, doc := range docs {
+		ok, err := Match(doc, q)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, doc)
+		}
+	}
+	return result, nil
+}
+
+// Upserted returns the document inserted by an upsert without matches: the
+// replacement document, or the fields of the selector with the update
+// operators applied.
+func Upserted(selector, update bson.M) (bson.M, error) {
+	doc := bson.M{}
+	if IsOperators(update) {
+		for key, value := range selector {
+			if !strings.HasPrefix(key, "$") && !IsOperators(value) {
+				SetPath(doc, key, value)
+			}
+		}
+	}
+	doc, err := Apply(doc, update)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectId()
+	}
+	return doc, nil
+}
+
+// IsOperators returns whether the value is a document of operators, like
+// {"$gte": 1}.
+func IsOperators(value interface{}) bool {
+	doc, ok := value.(bson.M)
+	if !ok || len(doc) == 0 {
+		return false
+	}
+	for key := range doc {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup returns the values of the field path in the document, following
+// the documents of the arrays in the path.
+func Lookup(value interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{value}
+	}
+	switch v := value.(type) {
+	case bson.M:
+		child, ok := v[path[0]]
+		if !ok {
+			return nil
+		}
+		return Lookup(child, path[1:])
+	case []interface{}:
+		if i, err := strconv.Atoi(path[0]); err == nil {
+			if i < 0 || i >= len(v) {
+				return nil
+			}
+			return Lookup(v[i], path[1:])
+		}
+		var values []interface{}
+		for _, item := range v {
+			if _, ok := item.(bson.M); ok {
+				values = append(values, Lookup(item, path)...)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// candidates returns the values compared with the query values: the values
+// of the field and the items of the arrays among them.
+func candidates(values []interface{}) []interface{} {
+	var result []interface{}
+	for _, v := range values {
+		result = append(result, v)
+		if items, ok := v.([]interface{}); ok {
+			result = append(result, items...)
+		}
+	}
+	return result
+}
+
+// Match returns whether the document matches the query.
+func Match(doc bson.M, q bson.M) (bool, error) {
+	for key, value := range q {
+		var ok bool
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, key, value)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("document: unsupported operator %s", key)
+			}
+			ok, err = matchField(Lookup(doc, strings.Split(key, ".")), value)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc bson.M, op string, value interface{}) (bool, error) {
+	queries, ok := value.([]interface{})
+	if !ok {
+		return false, fmt.Errorf("document: %s must be an array", op)
+	}
+	for _, item := range queries {
+		q, ok := item.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("document: %s must be an array of documents", op)
+		}
+		matched, err := Match(doc, q)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case op == "$and" && !matched:
+			return false, nil
+		case op == "$or" && matched:
+			return true, nil
+		case op == "$nor" && matched:
+			return false, nil
+		}
+	}
+	return op != "$or", nil
+}
+
+func matchField(values []interface{}, value interface{}) (bool, error) {
+	if !IsOperators(value) {
+		return matchEqual(values, value), nil
+	}
+	for op, operand := range value.(bson.M) {
+		ok, err := matchOperator(values, op, operand, value.(bson.M))
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchEqual returns whether a value of the field, or an item of its arrays,
+// is equal to value. Nil matches the missing fields.
+func matchEqual(values []interface{}, value interface{}) bool {
+	if value == nil && len(values) == 0 {
+		return true
+	}
+	if re, ok := value.(bson.RegEx); ok {
+		return matchRegex(values, re)
+	}
+	for _, v := range candidates(values) {
+		if Equal(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOperator(values []interface{}, op string, operand interface{}, ops bson.M) (bool, error) {
+	switch op {
+	case "$eq":
+		return matchEqual(values, operand), nil
+	case "$ne":
+		return !matchEqual(values, operand), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		for _, v := range candidates(values) {
+			n, ok := compare(v, operand)
+			if !ok {
+				continue
+			}
+			if op == "$gt" && n > 0 || op == "$gte" && n >= 0 || op == "$lt" && n < 0 || op == "$lte" && n <= 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$in", "$nin":
+		items, ok := operand.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("document: %s must be an array", op)
+		}
+		in := false
+		for _, item := range items {
+			if matchEqual(values, item) {
+				in = true
+				break
+			}
+		}
+		return in == (op == "$in"), nil
+	case "$exists":
+		exists, _ := operand.(bool)
+		return (len(values) > 0) == exists, nil
+	case "$regex":
+		re := bson.RegEx{}
+		switch p := operand.(type) {
+		case string:
+			re.Pattern = p
+		case bson.RegEx:
+			re = p
+		default:
+			return false, errors.New("document: $regex must be a string")
+		}
+		if options, ok := ops["$options"].(string); ok {
+			re.Options = options
+		}
+		return matchRegex(values, re), nil
+	case "$options":
+		return true, nil
+	case "$not":
+		ok, err := matchField(values, operand)
+		return !ok, err
+	}
+	return false, fmt.Errorf("document: unsupported operator %s", op)
+}
+
+func matchRegex(values []interface{}, re bson.RegEx) bool {
+	pattern := re.Pattern
+	if strings.Contains(re.Options, "i") {
+		pattern = "(?i)" + pattern
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return false
+	}
+	for _, v := range candidates(values) {
+		if s, ok := v.(string); ok && compiled.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// compare compares the values of the same type, returning false when their
+// types can not be compared.
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	case time.Time:
+		y, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x.Before(y):
+			return -1, true
+		case x.After(y):
+			return 1, true
+		}
+		return 0, true
+	case bson.ObjectId:
+		y, ok := b.(bson.ObjectId)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(string(x), string(y)), true
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x == y:
+			return 0, true
+		case !x:
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+// Equal returns whether the values are equal, like the numbers of different
+// types.
+func Equal(a, b interface{}) bool {
+	if n, ok := compare(a, b); ok {
+		return n == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Sort sorts the documents by the fields, prefixed by - for descending
+// order. The documents missing the field come first.
+func Sort(docs []bson.M, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, field := range fields {
+			desc := strings.HasPrefix(field, "-")
+			path := strings.Split(strings.TrimLeft(field, "-+"), ".")
+			a, b := Lookup(docs[i], path), Lookup(docs[j], path)
+			var n int
+			switch {
+			case len(a) == 0 && len(b) == 0:
+				continue
+			case len(a) == 0:
+				n = -1
+			case len(b) == 0:
+				n = 1
+			default:
+				n, _ = compare(a[0], b[0])
+			}
+			if n == 0 {
+				continue
+			}
+			return n < 0 != desc
+		}
+		return false
+	})
+}
+
+// Project returns the fields of the document selected, all of them without
+// selector.
+func Project(doc bson.M, selector bson.M) (bson.M, error) {
+	if len(selector) == 0 {
+		return doc, nil
+	}
+	include := false
+	for _, value := range selector {
+		if n, _ := number(value); n != 0 || value == true {
+			include = true
+		}
+	}
+	result := bson.M{}
+	if include {
+		if n, _ := number(selector["_id"]); selector["_id"] == nil || n != 0 {
+			if id, ok := doc["_id"]; ok {
+				result["_id"] = id
+			}
+		}
+		for key := range selector {
+			if key == "_id" {
+				continue
+			}
+			if values := Lookup(doc, strings.Split(key, ".")); len(values) == 1 {
+				SetPath(result, key, values[0])
+			}
+		}
+		return result, nil
+	}
+	result, err := ToDoc(doc)
+	if err != nil {
+		return nil, err
+	}
+	for key := range selector {
+		unsetPath(result, key)
+	}
+	return result, nil
+}
+
+// SetPath sets the value of the field path, creating the documents of the
+// path.
+func SetPath(doc bson.M, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := doc[part].(bson.M)
+		if !ok {
+			child = bson.M{}
+			doc[part] = child
+		}
+		doc = child
+	}
+	doc[parts[len(parts)-1]] = value
+}
+
+func unsetPath(doc bson.M, path string) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := doc[part].(bson.M)
+		if !ok {
+			return
+		}
+		doc = child
+	}
+	delete(doc, parts[len(parts)-1])
+}
+
+// Apply returns a copy of the document with the update: the replacement
+// document, keeping the id, or the update operators.
+func Apply(doc bson.M, update bson.M) (bson.M, error) {
+	if !IsOperators(update) {
+		result := bson.M{}
+		for key, value := range update {
+			result[key] = value
+		}
+		if id, ok := doc["_id"]; ok {
+			result["_id"] = id
+		}
+		return result, nil
+	}
+	result, err := ToDoc(doc)
+	if err != nil {
+		return nil, err
+	}
+	for op, value := range update {
+		fields, ok := value.(bson.M)
+		if !ok {
+			return nil, fmt.Errorf("document: %s must be a document", op)
+		}
+		for path, operand := range fields {
+			if err := applyOperator(result, op, path, operand); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+func applyOperator(doc bson.M, op, path string, operand interface{}) error {
+	current := Lookup(doc, strings.Split(path, "."))
+	switch op {
+	case "$set":
+		SetPath(doc, path, operand)
+	case "$unset":
+		unsetPath(doc, path)
+	case "$inc":
+		n, ok := number(operand)
+		if !ok {
+			return fmt.Errorf("document: $inc of %s must be a number", path)
+		}
+		if len(current) == 0 {
+			SetPath(doc, path, operand)
+			return nil
+		}
+		m, ok := number(current[0])
+		if !ok {
+			return fmt.Errorf("document: $inc of non numeric field %s", path)
+		}
+		_, floatField := current[0].(float64)
+		_, floatOperand := operand.(float64)
+		if !floatField && !floatOperand {
+			SetPath(doc, path, int64(m)+int64(n))
+		} else {
+			SetPath(doc, path, m+n)
+		}
+	case "$push", "$addToSet", "$pull":
+		var items []interface{}
+		if len(current) > 0 {
+			var ok bool
+			if items, ok = current[0].([]interface{}); !ok {
+				return fmt.Errorf("document: %s of non array field %s", op, path)
+			}
+		}
+		if op == "$pull" {
+			kept := []interface{}{}
+			for _, item := range items {
+				pulled, err := matchPull(item, operand)
+				if err != nil {
+					return err
+				}
+				if !pulled {
+					kept = append(kept, item)
+				}
+			}
+			SetPath(doc, path, kept)
+			return nil
+		}
+		values := []interface{}{operand}
+		each, modifiers := operand.(bson.M)
+		if modifiers {
+			if list, ok := each["$each"].([]interface{}); ok {
+				values = list
+			} else {
+				modifiers = false
+			}
+		}
+		for _, v := range values {
+			if op == "$addToSet" && matchEqual(items, v) {
+				continue
+			}
+			items = append(items, v)
+		}
+		if modifiers && op == "$push" {
+			var err error
+			if items, err = pushModifiers(items, each); err != nil {
+				return err
+			}
+		}
+		SetPath(doc, path, items)
+	default:
+		return fmt.Errorf("document: unsupported operator %s", op)
+	}
+	return nil
+}
+
+// matchPull returns whether $pull removes the item of an array: the items
+// equal to the operand, matching its conditions, like {"$lt": 5}, or, for
+// the documents, matching it as a query.
+func matchPull(item, operand interface{}) (bool, error) {
+	q, ok := operand.(bson.M)
+	if !ok {
+		return Equal(item, operand), nil
+	}
+	if IsOperators(q) {
+		return matchField([]interface{}{item}, q)
+	}
+	doc, ok := item.(bson.M)
+	if !ok {
+		return false, nil
+	}
+	return Match(doc, q)
+}
+
+// pushModifiers applies the $sort and $slice modifiers of $push to the
+// items: $sort is 1 or -1 to sort the values, or the sort keys of the
+// documents, and $slice keeps the first items, or the last ones when it is
+// negative.
+func pushModifiers(items []interface{}, each bson.M) ([]interface{}, error) {
+	if order, ok := each["$sort"]; ok {
+		docs := make([]bson.M, len(items))
+		for i, item := range items {
+			docs[i] = bson.M{"v": item}
+		}
+		var fields []string
+		if keys, ok := order.(bson.M); ok {
+			for name := range keys {
+				fields = append(fields, name)
+			}
+			sort.Strings(fields)
+			for i, name := range fields {
+				if n, _ := number(keys[name]); n < 0 {
+					fields[i] = "-v." + name
+				} else {
+					fields[i] = "v." + name
+				}
+			}
+		} else if n, _ := number(order); n < 0 {
+			fields = []string{"-v"}
+		} else {
+			fields = []string{"v"}
+		}
+		Sort(docs, fields)
+		for i, doc := range docs {
+			items[i] = doc["v"]
+		}
+	}
+	if value, ok := each["$slice"]; ok {
+		n, ok := number(value)
+		if !ok {
+			return nil, errors.New("document: $slice must be a number")
+		}
+		switch {
+		case n >= 0 && int(n) < len(items):
+			items = items[:int(n)]
+		case n < 0 && int(-n) < len(items):
+			items = items[len(items)+int(n):]
+		}
+	}
+	return items, nil
+}
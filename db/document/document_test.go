@@ -0,0 +1,121 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package document
+
+import (
+	"testing"
+
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func (s *S) TestSort(c *check.C) {
+	docs := []bson.M{
+		{"name": "b", "wait": 1},
+		{"name": "a", "wait": 2},
+		{"name": "c"},
+		{"name": "d", "wait": 1},
+	}
+	Sort(docs, []string{"-wait", "name"})
+	var names []interface{}
+	for _, doc := range docs {
+		names = append(names, doc["name"])
+	}
+	c.Assert(names, check.DeepEquals, []interface{}{"a", "b", "d", "c"})
+}
+
+func (s *S) TestProject(c *check.C) {
+	doc := bson.M{"_id": 1, "name": "a", "alarm": bson.M{"name": "b", "wait": 2}}
+	result, err := Project(doc, bson.M{"alarm.name": 1})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, bson.M{"_id": 1, "alarm": bson.M{"name": "b"}})
+	result, err = Project(doc, bson.M{"alarm": 0, "_id": 0})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, bson.M{"name": "a"})
+	result, err = Project(doc, bson.M{"_id": 1})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, bson.M{"_id": 1})
+}
+
+func (s *S) TestUpserted(c *check.C) {
+	doc, err := Upserted(bson.M{"name": "a", "wait": bson.M{"$gt": 1}}, bson.M{"$set": bson.M{"enabled": true}})
+	c.Assert(err, check.IsNil)
+	c.Assert(doc["_id"], check.NotNil)
+	delete(doc, "_id")
+	c.Assert(doc, check.DeepEquals, bson.M{"name": "a", "enabled": true})
+	doc, err = Upserted(bson.M{"name": "a"}, bson.M{"_id": 1, "name": "b"})
+	c.Assert(err, check.IsNil)
+	c.Assert(doc, check.DeepEquals, bson.M{"_id": 1, "name": "b"})
+}
+
+func (s *S) TestApplyArrays(c *check.C) {
+	doc := bson.M{"samples": []interface{}{bson.M{"t": 3}, bson.M{"t": 1}}, "tags": []interface{}{1, 2, 3}}
+	update, err := ToDoc(bson.M{
+		"$push": bson.M{"samples": bson.M{"$each": []bson.M{{"t": 4}, {"t": 2}}, "$sort": bson.M{"t": 1}, "$slice": -3}},
+		"$pull": bson.M{"tags": bson.M{"$gte": 2}},
+	})
+	c.Assert(err, check.IsNil)
+	doc, err = Apply(doc, update)
+	c.Assert(err, check.IsNil)
+	c.Assert(doc["samples"], check.DeepEquals, []interface{}{bson.M{"t": 2}, bson.M{"t": 3}, bson.M{"t": 4}})
+	c.Assert(doc["tags"], check.DeepEquals, []interface{}{1})
+	doc, err = Apply(doc, bson.M{"$pull": bson.M{"samples": bson.M{"t": bson.M{"$lt": 4}}}})
+	c.Assert(err, check.IsNil)
+	c.Assert(doc["samples"], check.DeepEquals, []interface{}{bson.M{"t": 4}})
+}
+
+func (s *S) TestAggregate(c *check.C) {
+	docs := []bson.M{
+		{"instance": "a", "enabled": true, "wait": 1, "error": "failed"},
+		{"instance": "b", "enabled": false, "wait": 2},
+		{"instance": "a", "enabled": false, "wait": 3},
+		{"instance": "c", "enabled": true, "wait": 4},
+	}
+	result, err := Aggregate(docs, []bson.M{
+		{"$match": bson.M{"instance": bson.M{"$in": []string{"a", "b"}}}},
+		{"$sort": bson.M{"instance": 1, "wait": -1}},
+		{"$group": bson.M{
+			"_id":      "$instance",
+			"alarms":   bson.M{"$sum": 1},
+			"enabled":  bson.M{"$sum": bson.M{"$cond": []interface{}{"$enabled", 1, 0}}},
+			"failures": bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$ifNull": []interface{}{"$error", false}}, 1, 0}}},
+			"last":     bson.M{"$first": "$wait"},
+			"max":      bson.M{"$max": "$wait"},
+			"avg":      bson.M{"$avg": "$wait"},
+			"waits":    bson.M{"$push": "$wait"},
+		}},
+		{"$skip": 0},
+		{"$limit": 5},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, []bson.M{
+		{"_id": "a", "alarms": 2, "enabled": 1, "failures": 1, "last": 3, "max": 3, "avg": 2.0, "waits": []interface{}{3, 1}},
+		{"_id": "b", "alarms": 1, "enabled": 0, "failures": 0, "last": 2, "max": 2, "avg": 2.0, "waits": []interface{}{2}},
+	})
+	_, err = Aggregate(docs, []bson.M{{"$unwind": "$tags"}})
+	c.Assert(err, check.ErrorMatches, `document: unsupported pipeline stage \$unwind`)
+	_, err = Aggregate(docs, []bson.M{{"$group": bson.M{"_id": nil, "n": bson.M{"$stdDevPop": "$wait"}}}})
+	c.Assert(err, check.ErrorMatches, `document: unsupported accumulator \$stdDevPop`)
+}
+
+func (s *S) TestNewPipe(c *check.C) {
+	var loaded bson.M
+	pipe := NewPipe([]bson.M{{"$match": bson.M{"name": "a"}}, {"$project": bson.M{"name": 1, "_id": 0}}}, func(q bson.M) ([]bson.M, error) {
+		loaded = q
+		return []bson.M{{"_id": 1, "name": "a", "wait": 1}, {"_id": 2, "name": "b"}}, nil
+	})
+	var result []struct{ Name string }
+	err := pipe.All(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(loaded, check.DeepEquals, bson.M{"name": "a"})
+	c.Assert(result, check.HasLen, 1)
+	c.Assert(result[0].Name, check.Equals, "a")
+}
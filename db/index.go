@@ -0,0 +1,257 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/tsuru/db/storage"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// errNamespaceNotFound is the code of the MongoDB error of the collections
+// which do not exist.
+const errNamespaceNotFound = 26
+
+// indexes are the indexes of the collections, for the queries of the alarms
+// checks and of the api, like the enabled alarms and the last event of an
+// alarm, so they do not scan the collections.
+var indexes = map[string][]mgo.Index{
+	"events": {
+		{Key: []string{"alarm.name", "-starttime"}, Background: true},
+		{Key: []string{"-starttime"}, Background: true},
+		{Key: []string{"alarm.instance", "-starttime"}, Background: true},
+		{Key: []string{"alarm.instance", "action.name"}, Background: true},
+	},
+	"instances":          {{Key: []string{"name"}, Unique: true}},
+	"datasources":        {{Key: []string{"name"}, Unique: true}},
+	"datasource_status":  {{Key: []string{"name"}, Unique: true}},
+	"datasource_samples": {{Key: []string{"name"}, Unique: true}},
+	"alarms": {
+		{Key: []string{"name"}, Unique: true},
+		{Key: []string{"instance"}, Background: true},
+		{Key: []string{"enabled"}, Background: true},
+	},
+	"actions": {{Key: []string{"name"}, Unique: true}},
+	"action_executions": {
+		{Key: []string{"action", "-time"}, Background: true},
+		{Key: []string{"alarm", "-time"}, Background: true},
+	},
+	"action_dead_letters": {{Key: []string{"action", "-time"}, Background: true}},
+	"idempotency_keys":    {{Key: []string{"expiresat"}, ExpireAfter: time.Second}},
+	"audit_log": {
+		{Key: []string{"-time"}, Background: true},
+		{Key: []string{"resource.type", "resource.name", "-time"}, Background: true},
+		{Key: []string{"expiresat"}, ExpireAfter: time.Second},
+	},
+	"wizard": {{Key: []string{"name"}, Unique: true}},
+}
+
+// indexed returns the collection, ensuring its indexes. mgo caches the
+// ensured indexes, so only the first call of the process creates them.
+func (s *MongoStorage) indexed(name string) *storage.Collection {
+	c := s.Collection(name)
+	for _, index := range indexes[name] {
+		c.EnsureIndex(index)
+	}
+	return c
+}
+
+// EnsureIndexes creates the missing indexes of the collections, when the
+// process starts, so the first alarms check does not scan the collections.
+// The indexes which are not unique are built in background. It also
+// creates, changes or drops the TTL index of the events, from
+// AUTOSCALE_EVENTS_TTL, or makes the events collection capped, from
+// AUTOSCALE_EVENTS_CAPPED_SIZE.
+func EnsureIndexes() error {
+	ttl, err := EventsTTL()
+	if err != nil {
+		return err
+	}
+	capped, err := EventsCapped()
+	if err != nil {
+		return err
+	}
+	if capped != nil && ttl > 0 {
+		return errors.New("AUTOSCALE_EVENTS_TTL and AUTOSCALE_EVENTS_CAPPED_SIZE can not be both set")
+	}
+	conn, err := Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err = ensureEventsCapped(conn.Collection("events"), capped); err != nil {
+		return fmt.Errorf("capped events: %s", err)
+	}
+	if err = ensureEventsTTL(conn.Collection("events"), ttl); err != nil {
+		return fmt.Errorf("ttl index of events: %s", err)
+	}
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := conn.Collection(name)
+		for _, index := range indexes[name] {
+			if err = c.EnsureIndex(index); err != nil {
+				return fmt.Errorf("index %v of %s: %s", index.Key, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// EventsTTL is how long the events are kept, from AUTOSCALE_EVENTS_TTL, in
+// seconds, or 0 when they are kept forever.
+func EventsTTL() (time.Duration, error) {
+	t := os.Getenv("AUTOSCALE_EVENTS_TTL")
+	if t == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(t)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid AUTOSCALE_EVENTS_TTL %q", t)
+	}
+	return time.Duration(v) * time.Second, nil
+}
+
+// ensureEventsTTL makes MongoDB remove the events started more than ttl
+// ago, with a TTL index of their start time, as the events in progress have
+// no end time. The expiration of an existing index is changed in place, and
+// the index is dropped when ttl is 0.
+func ensureEventsTTL(c *storage.Collection, ttl time.Duration) error {
+	existing, err := c.Indexes()
+	if qerr, ok := err.(*mgo.QueryError); ok && qerr.Code == errNamespaceNotFound {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	var current *mgo.Index
+	for i, index := range existing {
+		if len(index.Key) == 1 && index.Key[0] == "starttime" && index.ExpireAfter > 0 {
+			current = &existing[i]
+		}
+	}
+	switch {
+	case current == nil && ttl == 0:
+		return nil
+	case current == nil:
+		return c.EnsureIndex(mgo.Index{Key: []string{"starttime"}, ExpireAfter: ttl, Background: true})
+	case ttl == 0:
+		return c.DropIndexName(current.Name)
+	case current.ExpireAfter == ttl:
+		return nil
+	}
+	return c.Database.Run(bson.D{
+		{Name: "collMod", Value: c.Name},
+		{Name: "index", Value: bson.M{"keyPattern": bson.M{"starttime": 1}, "expireAfterSeconds": int(ttl / time.Second)}},
+	}, nil)
+}
+
+// defaultEventDocumentSize is the size of the event documents of a capped
+// collection when AUTOSCALE_EVENTS_CAPPED_DOCUMENT_SIZE is not set.
+const defaultEventDocumentSize = 16 * 1024
+
+// Capped is the configuration of the capped collection of the events.
+// MongoDB does not let the updates change the size of the documents of a
+// capped collection, so every event is written with DocumentSize bytes.
+type Capped struct {
+	// Size is the size of the collection, in bytes. MongoDB removes the
+	// oldest events when it is full.
+	Size int
+	// MaxDocs is the maximum number of events, or 0 for no limit.
+	MaxDocs int
+	// DocumentSize is the size of each event document, in bytes.
+	DocumentSize int
+}
+
+// EventsCapped returns the configuration of the capped collection of the
+// events, from AUTOSCALE_EVENTS_CAPPED_SIZE, AUTOSCALE_EVENTS_CAPPED_MAX
+// and AUTOSCALE_EVENTS_CAPPED_DOCUMENT_SIZE, or nil when the events are not
+// capped.
+func EventsCapped() (*Capped, error) {
+	size, err := envSize("AUTOSCALE_EVENTS_CAPPED_SIZE", 0)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	capped := Capped{Size: size}
+	if capped.MaxDocs, err = envSize("AUTOSCALE_EVENTS_CAPPED_MAX", 0); err != nil {
+		return nil, err
+	}
+	if capped.DocumentSize, err = envSize("AUTOSCALE_EVENTS_CAPPED_DOCUMENT_SIZE", defaultEventDocumentSize); err != nil {
+		return nil, err
+	}
+	if capped.DocumentSize == 0 || capped.DocumentSize > capped.Size {
+		return nil, fmt.Errorf("invalid AUTOSCALE_EVENTS_CAPPED_DOCUMENT_SIZE %d, must be between 1 and AUTOSCALE_EVENTS_CAPPED_SIZE", capped.DocumentSize)
+	}
+	return &capped, nil
+}
+
+// envSize returns the non-negative integer of the environment variable, or
+// def when it is not set.
+func envSize(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return v, nil
+}
+
+// ensureEventsCapped creates the events collection capped, converts it when
+// it exists and is not capped, or resizes it when its size or maximum
+// number of events changed. Nothing is done when capped is nil, as MongoDB
+// can not turn a capped collection back into a regular one.
+func ensureEventsCapped(c *storage.Collection, capped *Capped) error {
+	if capped == nil {
+		return nil
+	}
+	names, err := c.Database.CollectionNames()
+	if err != nil {
+		return err
+	}
+	exists := false
+	for _, name := range names {
+		exists = exists || name == c.Name
+	}
+	if !exists {
+		return c.Create(&mgo.CollectionInfo{Capped: true, MaxBytes: capped.Size, MaxDocs: capped.MaxDocs})
+	}
+	var stats struct {
+		Capped  bool `bson:"capped"`
+		MaxSize int  `bson:"maxSize"`
+		Max     int  `bson:"max"`
+	}
+	if err = c.Database.Run(bson.D{{Name: "collStats", Value: c.Name}}, &stats); err != nil {
+		return err
+	}
+	if !stats.Capped {
+		// convertToCapped keeps the newest events which fit in the size,
+		// but drops the indexes, which are created again by EnsureIndexes.
+		if err = c.Database.Run(bson.D{{Name: "convertToCapped", Value: c.Name}, {Name: "size", Value: capped.Size}}, nil); err != nil {
+			return err
+		}
+		stats.MaxSize = capped.Size
+	}
+	if stats.MaxSize == capped.Size && stats.Max == capped.MaxDocs {
+		return nil
+	}
+	return c.Database.Run(bson.D{
+		{Name: "collMod", Value: c.Name},
+		{Name: "cappedSize", Value: capped.Size},
+		{Name: "cappedMax", Value: capped.MaxDocs},
+	}, nil)
+}
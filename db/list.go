@@ -0,0 +1,51 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import "gopkg.in/mgo.v2/bson"
+
+// ListOptions are the pagination, sorting and filtering options of the list
+// queries. Limit and Offset select the page, Limit 0 meaning all the
+// documents. Sort are the fields ordering the documents, prefixed by - for
+// descending order. Filter are the field values the documents must have,
+// besides the ones of the query.
+type ListOptions struct {
+	Limit  int
+	Offset int
+	Sort   []string
+	Filter bson.M
+}
+
+// List finds the documents of the repository matching q and the options,
+// storing the page in result, and returns the total of documents matching
+// q and the filters. Nil options return all the documents.
+func List(c Repository, q bson.M, opts *ListOptions, result interface{}) (int, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	query := q
+	if len(opts.Filter) > 0 {
+		if len(q) > 0 {
+			query = bson.M{"$and": []bson.M{q, opts.Filter}}
+		} else {
+			query = opts.Filter
+		}
+	}
+	total, err := c.Find(query).Count()
+	if err != nil {
+		return 0, err
+	}
+	find := c.Find(query)
+	if len(opts.Sort) > 0 {
+		find = find.Sort(opts.Sort...)
+	}
+	if opts.Offset > 0 {
+		find = find.Skip(opts.Offset)
+	}
+	if opts.Limit > 0 {
+		find = find.Limit(opts.Limit)
+	}
+	return total, find.All(result)
+}
@@ -0,0 +1,33 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestList(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	coll := strg.Collection("list_test")
+	defer coll.DropCollection()
+	for _, name := range []string{"c", "a", "d", "b"} {
+		err = coll.Insert(bson.M{"name": name, "enabled": name != "d"})
+		c.Assert(err, check.IsNil)
+	}
+	var docs []struct{ Name string }
+	total, err := List(NewRepository(coll), nil, nil, &docs)
+	c.Assert(err, check.IsNil)
+	c.Assert(total, check.Equals, 4)
+	c.Assert(docs, check.HasLen, 4)
+	opts := &ListOptions{Limit: 2, Offset: 1, Sort: []string{"-name"}, Filter: bson.M{"enabled": true}}
+	total, err = List(NewRepository(coll), bson.M{"name": bson.M{"$ne": "a"}}, opts, &docs)
+	c.Assert(err, check.IsNil)
+	c.Assert(total, check.Equals, 2)
+	c.Assert(docs, check.HasLen, 1)
+	c.Assert(docs[0].Name, check.Equals, "b")
+}
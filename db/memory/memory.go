@@ -0,0 +1,341 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package memory implements an in-memory storage of tsuru-autoscale, so the
+// unit tests do not need a MongoDB:
+//
+//	db.SetBackend(memory.New().Open)
+//	defer db.SetBackend(nil)
+//
+// The repositories evaluate the query and update documents, and the
+// aggregation pipelines, with the document package. The expired documents,
+// like the audit log records, are not removed.
+package memory
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/db/document"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Storage is an in-memory storage. Its documents are kept until the storage
+// is dropped, so the connections opened by Open share them.
+type Storage struct {
+	mu          sync.Mutex
+	collections map[string][]bson.M
+}
+
+// New returns an empty storage.
+func New() *Storage {
+	return &Storage{collections: map[string][]bson.M{}}
+}
+
+// Open returns the storage, as a backend of db.
+func (s *Storage) Open() (db.Storage, error) {
+	return s, nil
+}
+
+// Wizards returns the repository of the auto scales, with unique names.
+func (s *Storage) Wizards() db.Repository {
+	return &repository{storage: s, name: "wizard", unique: "name"}
+}
+
+// Alarms returns the repository of the alarms, with unique names.
+func (s *Storage) Alarms() db.Repository {
+	return &repository{storage: s, name: "alarms", unique: "name"}
+}
+
+// DataSources returns the repository of the data sources, with unique
+// names.
+func (s *Storage) DataSources() db.Repository {
+	return &repository{storage: s, name: "datasources", unique: "name"}
+}
+
+// Actions returns the repository of the actions, with unique names.
+func (s *Storage) Actions() db.Repository {
+	return &repository{storage: s, name: "actions", unique: "name"}
+}
+
+// Events returns the repository of the events.
+func (s *Storage) Events() db.Repository {
+	return &repository{storage: s, name: "events"}
+}
+
+// Instances returns the repository of the service instances, with unique
+// names.
+func (s *Storage) Instances() db.Repository {
+	return &repository{storage: s, name: "instances", unique: "name"}
+}
+
+// DataSourceStatus returns the repository of the status of the data
+// sources, with unique names.
+func (s *Storage) DataSourceStatus() db.Repository {
+	return &repository{storage: s, name: "datasource_status", unique: "name"}
+}
+
+// DataSourceSamples returns the repository of the samples pushed to the
+// data sources, with unique names.
+func (s *Storage) DataSourceSamples() db.Repository {
+	return &repository{storage: s, name: "datasource_samples", unique: "name"}
+}
+
+// ActionExecutions returns the repository of the action executions.
+func (s *Storage) ActionExecutions() db.Repository {
+	return &repository{storage: s, name: "action_executions"}
+}
+
+// ActionDeadLetters returns the repository of the failed action executions.
+func (s *Storage) ActionDeadLetters() db.Repository {
+	return &repository{storage: s, name: "action_dead_letters"}
+}
+
+// Runners returns the repository of the status of the auto scale runners.
+func (s *Storage) Runners() db.Repository {
+	return &repository{storage: s, name: "runners"}
+}
+
+// IdempotencyKeys returns the repository of the outcomes of the requests
+// with idempotency keys. They are not removed when they expire.
+func (s *Storage) IdempotencyKeys() db.Repository {
+	return &repository{storage: s, name: "idempotency_keys"}
+}
+
+// AuditLog returns the repository of the audit log. The records are not
+// removed when they expire.
+func (s *Storage) AuditLog() db.Repository {
+	return &repository{storage: s, name: "audit_log"}
+}
+
+// Ping does nothing, the storage is always reachable.
+func (s *Storage) Ping() error {
+	return nil
+}
+
+// Close does nothing, the documents are kept in the storage.
+func (s *Storage) Close() {}
+
+// Drop removes all the documents of the storage.
+func (s *Storage) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collections = map[string][]bson.M{}
+}
+
+type repository struct {
+	storage *Storage
+	name    string
+	unique  string
+}
+
+func (r *repository) docs() []bson.M {
+	return r.storage.collections[r.name]
+}
+
+// find returns the indexes of the documents matching the query, up to n
+// documents, or all of them when n is 0.
+func (r *repository) find(q bson.M, n int) ([]int, error) {
+	var found []int
+	for i, doc := range r.docs() {
+		ok, err := document.Match(doc, q)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found = append(found, i)
+			if len(found) == n {
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+// checkUnique returns the duplicate key error of MongoDB when another
+// document has the unique field of doc.
+func (r *repository) checkUnique(doc bson.M, skip int) error {
+	if r.unique == "" {
+		return nil
+	}
+	value, ok := doc[r.unique]
+	if !ok {
+		return nil
+	}
+	for i, other := range r.docs() {
+		if i != skip && document.Equal(other[r.unique], value) {
+			return &mgo.LastError{
+				Code: 11000,
+				Err:  fmt.Sprintf("E11000 duplicate key error collection: %s index: %s_1 dup key: { : %v }", r.name, r.unique, value),
+			}
+		}
+	}
+	return nil
+}
+
+// load returns a copy of the list of documents, as they are replaced by
+// the updates.
+func (r *repository) load(bson.M) ([]bson.M, error) {
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+	return append([]bson.M{}, r.docs()...), nil
+}
+
+func (r *repository) Find(query interface{}) db.Query {
+	return document.NewQuery(query, r.load)
+}
+
+func (r *repository) FindId(id interface{}) db.Query {
+	return r.Find(bson.M{"_id": id})
+}
+
+func (r *repository) Insert(docs ...interface{}) error {
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+	for _, d := range docs {
+		doc, err := document.ToDoc(d)
+		if err != nil {
+			return err
+		}
+		if _, ok := doc["_id"]; !ok {
+			doc["_id"] = bson.NewObjectId()
+		}
+		for _, other := range r.docs() {
+			if document.Equal(other["_id"], doc["_id"]) {
+				return &mgo.LastError{Code: 11000, Err: fmt.Sprintf("E11000 duplicate key error collection: %s index: _id_ dup key: { : %v }", r.name, doc["_id"])}
+			}
+		}
+		if err = r.checkUnique(doc, -1); err != nil {
+			return err
+		}
+		r.storage.collections[r.name] = append(r.docs(), doc)
+	}
+	return nil
+}
+
+// update applies the update to the documents matching the selector, up to
+// n documents, or all of them when n is 0, returning the number of updated
+// documents.
+func (r *repository) update(selector, update interface{}, n int) (int, error) {
+	q, err := document.ToDoc(selector)
+	if err != nil {
+		return 0, err
+	}
+	u, err := document.ToDoc(update)
+	if err != nil {
+		return 0, err
+	}
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+	found, err := r.find(q, n)
+	if err != nil {
+		return 0, err
+	}
+	for _, i := range found {
+		doc, err := document.Apply(r.docs()[i], u)
+		if err != nil {
+			return 0, err
+		}
+		if err = r.checkUnique(doc, i); err != nil {
+			return 0, err
+		}
+		r.docs()[i] = doc
+	}
+	return len(found), nil
+}
+
+func (r *repository) Update(selector, update interface{}) error {
+	n, err := r.update(selector, update, 1)
+	if err == nil && n == 0 {
+		err = mgo.ErrNotFound
+	}
+	return err
+}
+
+func (r *repository) UpdateId(id, update interface{}) error {
+	return r.Update(bson.M{"_id": id}, update)
+}
+
+func (r *repository) UpdateAll(selector, update interface{}) (*mgo.ChangeInfo, error) {
+	n, err := r.update(selector, update, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &mgo.ChangeInfo{Updated: n, Matched: n}, nil
+}
+
+func (r *repository) Upsert(selector, update interface{}) (*mgo.ChangeInfo, error) {
+	n, err := r.update(selector, update, 1)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		return &mgo.ChangeInfo{Updated: n, Matched: n}, nil
+	}
+	q, err := document.ToDoc(selector)
+	if err != nil {
+		return nil, err
+	}
+	u, err := document.ToDoc(update)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := document.Upserted(q, u)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.Insert(doc); err != nil {
+		return nil, err
+	}
+	return &mgo.ChangeInfo{UpsertedId: doc["_id"]}, nil
+}
+
+// remove removes the documents matching the selector, up to n documents, or
+// all of them when n is 0, returning the number of removed documents.
+func (r *repository) remove(selector interface{}, n int) (int, error) {
+	q, err := document.ToDoc(selector)
+	if err != nil {
+		return 0, err
+	}
+	r.storage.mu.Lock()
+	defer r.storage.mu.Unlock()
+	found, err := r.find(q, n)
+	if err != nil {
+		return 0, err
+	}
+	removed := make(map[int]bool, len(found))
+	for _, i := range found {
+		removed[i] = true
+	}
+	var docs []bson.M
+	for i, doc := range r.docs() {
+		if !removed[i] {
+			docs = append(docs, doc)
+		}
+	}
+	r.storage.collections[r.name] = docs
+	return len(found), nil
+}
+
+func (r *repository) Remove(selector interface{}) error {
+	n, err := r.remove(selector, 1)
+	if err == nil && n == 0 {
+		err = mgo.ErrNotFound
+	}
+	return err
+}
+
+func (r *repository) RemoveAll(selector interface{}) (*mgo.ChangeInfo, error) {
+	n, err := r.remove(selector, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &mgo.ChangeInfo{Removed: n, Matched: n}, nil
+}
+
+func (r *repository) Pipe(pipeline interface{}) db.Pipe {
+	return document.NewPipe(pipeline, r.load)
+}
@@ -0,0 +1,234 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	storage *Storage
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpTest(c *check.C) {
+	s.storage = New()
+}
+
+type doc struct {
+	Name     string
+	Instance string
+	Enabled  bool
+	Wait     int
+	Tags     []string
+	Time     time.Time
+	Envs     map[string]string
+}
+
+func (s *S) TestInsertFind(c *check.C) {
+	now := time.Now().UTC()
+	alarms := s.storage.Alarms()
+	err := alarms.Insert(
+		doc{Name: "c", Instance: "i1", Enabled: true, Wait: 3, Tags: []string{"web"}, Time: now},
+		doc{Name: "a", Instance: "i1", Wait: 1, Time: now.Add(-time.Hour)},
+		doc{Name: "b", Instance: "i2", Enabled: true, Wait: 2, Tags: []string{"db", "web"}, Envs: map[string]string{"step": "1"}},
+	)
+	c.Assert(err, check.IsNil)
+	tests := []struct {
+		query interface{}
+		names []string
+	}{
+		{nil, []string{"a", "b", "c"}},
+		{bson.M{"instance": "i1"}, []string{"a", "c"}},
+		{bson.M{"tags": "web"}, []string{"b", "c"}},
+		{bson.M{"envs.step": "1"}, []string{"b"}},
+		{bson.M{"wait": bson.M{"$gte": 2, "$lt": 3}}, []string{"b"}},
+		{bson.M{"name": bson.M{"$in": []string{"a", "b", "d"}}}, []string{"a", "b"}},
+		{bson.M{"name": bson.M{"$nin": []string{"a"}}, "enabled": true}, []string{"b", "c"}},
+		{bson.M{"envs.step": bson.M{"$exists": false}}, []string{"a", "c"}},
+		{bson.M{"name": bson.M{"$regex": "^[AB]", "$options": "i"}}, []string{"a", "b"}},
+		{bson.M{"name": bson.RegEx{Pattern: "c"}}, []string{"c"}},
+		{bson.M{"time": bson.M{"$gte": now.Add(-time.Minute)}}, []string{"c"}},
+		{bson.M{"$or": []bson.M{{"name": "a"}, {"wait": 3}}}, []string{"a", "c"}},
+		{bson.M{"$and": []bson.M{{"instance": "i1"}, {"enabled": true}}}, []string{"c"}},
+		{bson.M{"$nor": []bson.M{{"instance": "i1"}}}, []string{"b"}},
+		{bson.M{"name": bson.M{"$ne": "a"}, "wait": bson.M{"$not": bson.M{"$gt": 2}}}, []string{"b"}},
+	}
+	for _, tt := range tests {
+		var result []doc
+		err = alarms.Find(tt.query).Sort("name").All(&result)
+		c.Assert(err, check.IsNil)
+		var names []string
+		for _, d := range result {
+			names = append(names, d.Name)
+		}
+		c.Check(names, check.DeepEquals, tt.names, check.Commentf("%v", tt.query))
+	}
+	var result doc
+	err = alarms.Find(bson.M{"instance": "i1"}).Sort("-time").One(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Name, check.Equals, "c")
+	c.Assert(result.Time.Equal(now.Truncate(time.Millisecond)), check.Equals, true)
+	err = alarms.Find(bson.M{"name": "d"}).One(&result)
+	c.Assert(err, check.Equals, mgo.ErrNotFound)
+	_, err = alarms.Find(bson.M{"$where": "true"}).Count()
+	c.Assert(err, check.ErrorMatches, "document: unsupported operator \\$where")
+}
+
+func (s *S) TestQueryPage(c *check.C) {
+	events := s.storage.Events()
+	for i := 0; i < 5; i++ {
+		err := events.Insert(bson.M{"n": i, "name": "event"})
+		c.Assert(err, check.IsNil)
+	}
+	var result []bson.M
+	err := events.Find(nil).Sort("-n").Skip(1).Limit(2).Select(bson.M{"n": 1, "_id": 0}).All(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, []bson.M{{"n": 3}, {"n": 2}})
+	n, err := events.Find(bson.M{"n": bson.M{"$gt": 1}}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 3)
+	iter := events.Find(nil).Sort("n").Iter()
+	var d bson.M
+	var values []interface{}
+	for iter.Next(&d) {
+		values = append(values, d["n"])
+	}
+	c.Assert(iter.Close(), check.IsNil)
+	c.Assert(values, check.DeepEquals, []interface{}{0, 1, 2, 3, 4})
+	var groups []struct {
+		ID    string `bson:"_id"`
+		Total int
+	}
+	err = events.Pipe([]bson.M{
+		{"$match": bson.M{"n": bson.M{"$gte": 3}}},
+		{"$group": bson.M{"_id": "$name", "total": bson.M{"$sum": "$n"}}},
+	}).All(&groups)
+	c.Assert(err, check.IsNil)
+	c.Assert(groups, check.HasLen, 1)
+	c.Assert(groups[0].ID, check.Equals, "event")
+	c.Assert(groups[0].Total, check.Equals, 7)
+}
+
+func (s *S) TestUpdate(c *check.C) {
+	alarms := s.storage.Alarms()
+	err := alarms.Insert(doc{Name: "a", Wait: 1, Tags: []string{"web"}}, doc{Name: "b", Wait: 2})
+	c.Assert(err, check.IsNil)
+	err = alarms.Update(bson.M{"name": "a"}, bson.M{
+		"$set":  bson.M{"enabled": true, "envs.step": "2"},
+		"$inc":  bson.M{"wait": 10},
+		"$push": bson.M{"tags": "db"},
+	})
+	c.Assert(err, check.IsNil)
+	var result doc
+	err = alarms.Find(bson.M{"name": "a"}).One(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, doc{Name: "a", Enabled: true, Wait: 11, Tags: []string{"web", "db"}, Envs: map[string]string{"step": "2"}})
+	err = alarms.Update(bson.M{"name": "a"}, bson.M{"$pull": bson.M{"tags": "web"}, "$unset": bson.M{"envs": ""}})
+	c.Assert(err, check.IsNil)
+	err = alarms.Find(bson.M{"name": "a"}).One(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Tags, check.DeepEquals, []string{"db"})
+	c.Assert(result.Envs, check.IsNil)
+	err = alarms.Update(bson.M{"name": "b"}, doc{Name: "b", Wait: 5})
+	c.Assert(err, check.IsNil)
+	err = alarms.Find(bson.M{"name": "b"}).One(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Wait, check.Equals, 5)
+	err = alarms.Update(bson.M{"name": "b"}, bson.M{"$set": bson.M{"name": "a"}})
+	c.Assert(mgo.IsDup(err), check.Equals, true)
+	err = alarms.Update(bson.M{"name": "d"}, bson.M{"$set": bson.M{"wait": 1}})
+	c.Assert(err, check.Equals, mgo.ErrNotFound)
+	info, err := alarms.UpdateAll(nil, bson.M{"$set": bson.M{"instance": "i1"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(info.Updated, check.Equals, 2)
+	n, err := alarms.Find(bson.M{"instance": "i1"}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 2)
+}
+
+func (s *S) TestUpsert(c *check.C) {
+	wizards := s.storage.Wizards()
+	info, err := wizards.Upsert(bson.M{"name": "a"}, doc{Name: "a", Wait: 1})
+	c.Assert(err, check.IsNil)
+	c.Assert(info.UpsertedId, check.NotNil)
+	info, err = wizards.Upsert(bson.M{"name": "a"}, doc{Name: "a", Wait: 2})
+	c.Assert(err, check.IsNil)
+	c.Assert(info.UpsertedId, check.IsNil)
+	c.Assert(info.Updated, check.Equals, 1)
+	info, err = wizards.Upsert(bson.M{"name": "b"}, bson.M{"$set": bson.M{"wait": 3}})
+	c.Assert(err, check.IsNil)
+	c.Assert(info.UpsertedId, check.NotNil)
+	var result []struct {
+		Name string
+		Wait int
+	}
+	err = wizards.Find(nil).Sort("name").All(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 2)
+	c.Assert(result[0].Name, check.Equals, "a")
+	c.Assert(result[0].Wait, check.Equals, 2)
+	c.Assert(result[1].Name, check.Equals, "b")
+	c.Assert(result[1].Wait, check.Equals, 3)
+	err = wizards.Insert(doc{Name: "a"})
+	c.Assert(mgo.IsDup(err), check.Equals, true)
+}
+
+func (s *S) TestRemove(c *check.C) {
+	events := s.storage.Events()
+	id := bson.NewObjectId()
+	err := events.Insert(bson.M{"_id": id, "name": "a"}, bson.M{"name": "a"}, bson.M{"name": "b"})
+	c.Assert(err, check.IsNil)
+	err = events.Insert(bson.M{"_id": id})
+	c.Assert(mgo.IsDup(err), check.Equals, true)
+	var result bson.M
+	err = events.FindId(id).One(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["name"], check.Equals, "a")
+	err = events.UpdateId(id, bson.M{"$set": bson.M{"name": "c"}})
+	c.Assert(err, check.IsNil)
+	info, err := events.RemoveAll(bson.M{"name": "a"})
+	c.Assert(err, check.IsNil)
+	c.Assert(info.Removed, check.Equals, 1)
+	err = events.Remove(bson.M{"_id": id})
+	c.Assert(err, check.IsNil)
+	err = events.Remove(bson.M{"_id": id})
+	c.Assert(err, check.Equals, mgo.ErrNotFound)
+	n, err := events.Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+	s.storage.Drop()
+	n, err = events.Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+}
+
+func (s *S) TestBackend(c *check.C) {
+	db.SetBackend(s.storage.Open)
+	defer db.SetBackend(nil)
+	conn, err := db.Open()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	for _, name := range []string{"c", "a", "d", "b"} {
+		err = conn.Actions().Insert(bson.M{"name": name, "enabled": name != "d"})
+		c.Assert(err, check.IsNil)
+	}
+	var docs []struct{ Name string }
+	opts := &db.ListOptions{Limit: 2, Offset: 1, Sort: []string{"-name"}, Filter: bson.M{"enabled": true}}
+	total, err := db.List(s.storage.Actions(), bson.M{"name": bson.M{"$ne": "a"}}, opts, &docs)
+	c.Assert(err, check.IsNil)
+	c.Assert(total, check.Equals, 2)
+	c.Assert(docs, check.HasLen, 1)
+	c.Assert(docs[0].Name, check.Equals, "b")
+}
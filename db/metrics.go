@@ -0,0 +1,101 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/metrics"
+	"gopkg.in/mgo.v2"
+)
+
+var (
+	connectionsInUse = metrics.NewGaugeVec(
+		"autoscale_db_connections_in_use",
+		"Connections to MongoDB opened and not closed yet.",
+	)
+	sockets = metrics.NewGaugeVec(
+		"autoscale_db_sockets",
+		"Sockets of the MongoDB pool, by state, alive or in_use.",
+		"state",
+	)
+	connectionWait = metrics.NewHistogramVec(
+		"autoscale_db_connection_wait_seconds",
+		"Time waiting for the connections to MongoDB, including the dials of the shared sessions.",
+		metrics.DefBuckets,
+	)
+	connectionErrors = metrics.NewCounterVec(
+		"autoscale_db_connection_errors_total",
+		"Failures of the connections to MongoDB, by operation, dial or ping, and the dials refused during the reconnection backoff, as unavailable.",
+		"operation",
+	)
+	// inUse is the number of connections opened and not closed.
+	inUse int64
+)
+
+func init() {
+	mgo.SetStats(true)
+	metrics.Register(connectionsInUse, sockets, connectionWait, connectionErrors)
+}
+
+// CollectMetrics sets the gauges of the sockets of the MongoDB pool, before
+// the metrics are written.
+func CollectMetrics() {
+	stats := mgo.GetStats()
+	sockets.Set(float64(stats.SocketsAlive), "alive")
+	sockets.Set(float64(stats.SocketsInUse), "in_use")
+}
+
+// addInUse adds delta to the connections in use.
+func addInUse(delta int64) {
+	connectionsInUse.Set(float64(atomic.AddInt64(&inUse, delta)))
+}
+
+// Reconnection backoff of the shared sessions, doubled after each failed
+// dial.
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = time.Minute
+)
+
+// UnavailableError is returned by Conn, without dialing MongoDB, until the
+// backoff after a failed dial elapses, so the callers do not wait for the
+// dial timeout while the servers are unreachable.
+type UnavailableError struct {
+	// Err is the error of the last dial.
+	Err error
+	// Retry is when the next dial is allowed.
+	Retry time.Time
+}
+
+func (e *UnavailableError) Error() string {
+	retry := time.Until(e.Retry)
+	return fmt.Sprintf("db: mongodb is unavailable, retrying in %s: %s", retry-retry%time.Second, e.Err)
+}
+
+// dialFailure is the backoff of the dials of an url.
+type dialFailure struct {
+	failures int
+	err      error
+	retry    time.Time
+}
+
+// dialFailures are the backoffs of the urls whose last dial failed,
+// guarded by sessionsMu.
+var dialFailures = map[string]*dialFailure{}
+
+// reconnectBackoff returns the backoff after the failures in a row.
+func reconnectBackoff(failures int) time.Duration {
+	backoff := minReconnectBackoff
+	for i := 1; i < failures && backoff < maxReconnectBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxReconnectBackoff {
+		return maxReconnectBackoff
+	}
+	return backoff
+}
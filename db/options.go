@@ -0,0 +1,236 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// dialTimeout is how long dialing MongoDB waits for the servers, like
+// mgo.Dial.
+const dialTimeout = 10 * time.Second
+
+// readModes are the MongoDB read preferences, by MONGODB_READ_PREFERENCE.
+var readModes = map[string]mgo.Mode{
+	"primary":            mgo.Primary,
+	"primaryPreferred":   mgo.PrimaryPreferred,
+	"secondary":          mgo.Secondary,
+	"secondaryPreferred": mgo.SecondaryPreferred,
+	"nearest":            mgo.Nearest,
+}
+
+// dialInfo returns the information to dial the MongoDB url, with the
+// credentials, replica set and TLS of the environment, which override the
+// ones of the url:
+//
+//   - MONGODB_USERNAME and MONGODB_PASSWORD, authenticated against the
+//     database MONGODB_AUTH_SOURCE, with MONGODB_AUTH_MECHANISM;
+//   - MONGODB_REPLICA_SET, the name of the replica set;
+//   - MONGODB_TLS, connecting with TLS, verifying the servers with the CA
+//     certificates of MONGODB_TLS_CA_FILE, and presenting the client
+//     certificate of MONGODB_TLS_CERT_FILE and MONGODB_TLS_KEY_FILE.
+func dialInfo(url string) (*mgo.DialInfo, error) {
+	info, err := mgo.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	info.Timeout = dialTimeout
+	if username := os.Getenv("MONGODB_USERNAME"); username != "" {
+		info.Username = username
+		info.Password = os.Getenv("MONGODB_PASSWORD")
+	}
+	if source := os.Getenv("MONGODB_AUTH_SOURCE"); source != "" {
+		info.Source = source
+	}
+	if mechanism := os.Getenv("MONGODB_AUTH_MECHANISM"); mechanism != "" {
+		info.Mechanism = mechanism
+	}
+	if name := os.Getenv("MONGODB_REPLICA_SET"); name != "" {
+		info.ReplicaSetName = name
+	}
+	if limit := poolLimit(); limit > 0 {
+		info.PoolLimit = limit
+	}
+	config, err := tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		dialer := &net.Dialer{Timeout: info.Timeout}
+		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			return tls.DialWithDialer(dialer, "tcp", addr.String(), config)
+		}
+	}
+	return info, nil
+}
+
+// tlsConfig returns the TLS configuration of the connections to MongoDB,
+// or nil when MONGODB_TLS is not set. MONGODB_TLS_INSECURE_SKIP_VERIFY
+// disables the verification of the servers, for the tests only.
+func tlsConfig() (*tls.Config, error) {
+	enabled, err := envBool("MONGODB_TLS")
+	if err != nil || !enabled {
+		return nil, err
+	}
+	insecure, err := envBool("MONGODB_TLS_INSECURE_SKIP_VERIFY")
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if file := os.Getenv("MONGODB_TLS_CA_FILE"); file != "" {
+		ca, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("db: invalid CA certificate in MONGODB_TLS_CA_FILE")
+		}
+		config.RootCAs = pool
+	}
+	certFile, keyFile := os.Getenv("MONGODB_TLS_CERT_FILE"), os.Getenv("MONGODB_TLS_KEY_FILE")
+	if certFile != "" || keyFile != "" {
+		pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
+// readMode returns the read preference of the sessions, from
+// MONGODB_READ_PREFERENCE, primary by default.
+func readMode() (mgo.Mode, error) {
+	return envReadMode("MONGODB_READ_PREFERENCE", mgo.Primary)
+}
+
+// reportsReadMode returns the read preference of the connections of the
+// reports, from MONGODB_REPORTS_READ_PREFERENCE, the one of the other
+// sessions by default.
+func reportsReadMode() (mgo.Mode, error) {
+	mode, err := readMode()
+	if err != nil {
+		return 0, err
+	}
+	return envReadMode("MONGODB_REPORTS_READ_PREFERENCE", mode)
+}
+
+// envReadMode returns the read preference of the environment variable, or
+// def when it is not set.
+func envReadMode(name string, def mgo.Mode) (mgo.Mode, error) {
+	p := os.Getenv(name)
+	if p == "" {
+		return def, nil
+	}
+	mode, ok := readModes[p]
+	if !ok {
+		return 0, fmt.Errorf("db: invalid %s %q", name, p)
+	}
+	return mode, nil
+}
+
+// checkDatabaseName checks that MongoDB accepts the database name, from
+// MONGODB_DATABASE_NAME, so an invalid one fails the connections instead of
+// the first operations.
+func checkDatabaseName(name string) error {
+	if len(name) > 63 || strings.ContainsAny(name, `/\. "$`) {
+		return fmt.Errorf("db: invalid MONGODB_DATABASE_NAME %q", name)
+	}
+	return nil
+}
+
+// validPrefix matches the prefixes of the collections: letters, digits,
+// underscores, hyphens and dots, not starting with a dot.
+var validPrefix = regexp.MustCompile(`^([A-Za-z0-9_-][A-Za-z0-9_.-]*)?$`)
+
+// collectionPrefix returns the prefix of the names of the collections, from
+// MONGODB_COLLECTION_PREFIX, so the deployments sharing a database, like
+// staging and production, do not share their collections.
+func collectionPrefix() (string, error) {
+	p := os.Getenv("MONGODB_COLLECTION_PREFIX")
+	if !validPrefix.MatchString(p) || strings.HasPrefix(p, "system.") {
+		return "", fmt.Errorf("db: invalid MONGODB_COLLECTION_PREFIX %q", p)
+	}
+	return p, nil
+}
+
+// writeConcern returns the write concern of the sessions: the number of
+// servers, or a mode like majority, acknowledging the writes, from
+// MONGODB_WRITE_CONCERN, waiting up to MONGODB_WRITE_TIMEOUT milliseconds,
+// and waiting for the journal when MONGODB_JOURNAL is set. It returns nil,
+// the acknowledgment of the primary, when none is set.
+func writeConcern() (*mgo.Safe, error) {
+	w, t := os.Getenv("MONGODB_WRITE_CONCERN"), os.Getenv("MONGODB_WRITE_TIMEOUT")
+	journal, err := envBool("MONGODB_JOURNAL")
+	if err != nil {
+		return nil, err
+	}
+	if w == "" && t == "" && !journal {
+		return nil, nil
+	}
+	safe := &mgo.Safe{J: journal}
+	if n, err := strconv.Atoi(w); err == nil {
+		safe.W = n
+	} else {
+		safe.WMode = w
+	}
+	if t != "" {
+		safe.WTimeout, err = strconv.Atoi(t)
+		if err != nil || safe.WTimeout < 0 {
+			return nil, fmt.Errorf("db: invalid MONGODB_WRITE_TIMEOUT %q", t)
+		}
+	}
+	return safe, nil
+}
+
+func envBool(name string) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("db: invalid %s %q", name, v)
+	}
+	return b, nil
+}
+
+// dial dials MongoDB with the options of the environment.
+func dial(url string) (*mgo.Session, error) {
+	info, err := dialInfo(url)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := readMode()
+	if err != nil {
+		return nil, err
+	}
+	safe, err := writeConcern()
+	if err != nil {
+		return nil, err
+	}
+	s, err := mgo.DialWithInfo(info)
+	if err != nil {
+		return nil, err
+	}
+	s.SetMode(mode, true)
+	if safe != nil {
+		s.SetSafe(safe)
+	}
+	return s, nil
+}
@@ -0,0 +1,968 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package postgres implements a PostgreSQL storage of tsuru-autoscale, for
+// the organizations which do not operate a MongoDB.
+//
+// The documents of each repository are stored in a JSONB column of a
+// table, with columns, and indexes, for the fields of the frequent queries:
+// the ids and unique names of the configurations, and the alarm, instance
+// and start time of the events, for instance. The queries select the rows
+// by these columns, and evaluate the other fields, and the aggregation
+// pipelines, with the document package. The queries whose conditions and
+// sort are all on columns are run entirely by PostgreSQL, including their
+// page. The expired documents, like the audit log records, are removed
+// every minute, as MongoDB does with its TTL indexes.
+//
+// The database/sql driver registered as "postgres", like the one of
+// github.com/lib/pq, must be imported by the program.
+package postgres
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/db/document"
+	"github.com/tsuru/tsuru-autoscale/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// driverName is the name of the database/sql driver of PostgreSQL.
+const driverName = "postgres"
+
+// purgeInterval is how often the expired documents are removed, like the
+// TTL monitor of MongoDB.
+const purgeInterval = time.Minute
+
+// column is a column of a table with the value of a field of the
+// documents, used to select the rows.
+type column struct {
+	name string
+	path string
+	typ  string
+}
+
+// table is the table of a repository, with the documents in the doc column,
+// and the indexes of its columns, like "alarm_name, starttime DESC NULLS
+// LAST". The descending indexes keep the nulls last, so they serve the
+// sorts of both directions with the nulls first in ascending order, like
+// the missing fields in MongoDB.
+type table struct {
+	name    string
+	unique  string
+	columns []column
+	indexes []string
+}
+
+var (
+	idColumn     = column{name: "id", path: "_id", typ: "text PRIMARY KEY"}
+	nameColumn   = column{name: "name", path: "name", typ: "text UNIQUE"}
+	namedColumns = []column{idColumn, nameColumn}
+)
+
+var (
+	wizards     = table{name: "autoscale_wizards", unique: "name", columns: namedColumns}
+	alarms      = table{name: "autoscale_alarms", unique: "name", columns: namedColumns}
+	datasources = table{name: "autoscale_datasources", unique: "name", columns: namedColumns}
+	actions     = table{name: "autoscale_actions", unique: "name", columns: namedColumns}
+	events      = table{
+		name: "autoscale_events",
+		columns: []column{
+			idColumn,
+			{name: "alarm_name", path: "alarm.name", typ: "text"},
+			{name: "instance", path: "alarm.instance", typ: "text"},
+			{name: "starttime", path: "starttime", typ: "timestamptz"},
+		},
+		indexes: []string{"alarm_name", "starttime DESC NULLS LAST", "instance, starttime DESC NULLS LAST"},
+	}
+	instances = table{
+		name:    "autoscale_instances",
+		unique:  "name",
+		columns: []column{idColumn, nameColumn, {name: "team", path: "team", typ: "text"}},
+		indexes: []string{"team"},
+	}
+	datasourceStatus  = table{name: "autoscale_datasource_status", unique: "name", columns: namedColumns}
+	datasourceSamples = table{name: "autoscale_datasource_samples", unique: "name", columns: namedColumns}
+	actionExecutions  = table{
+		name: "autoscale_action_executions",
+		columns: []column{
+			idColumn,
+			{name: "action", path: "action", typ: "text"},
+			{name: "alarm", path: "alarm", typ: "text"},
+			{name: "time", path: "time", typ: "timestamptz"},
+		},
+		indexes: []string{"action, time DESC NULLS LAST", "alarm, time DESC NULLS LAST"},
+	}
+	actionDeadLetters = table{
+		name: "autoscale_action_dead_letters",
+		columns: []column{
+			idColumn,
+			{name: "action", path: "action", typ: "text"},
+			{name: "time", path: "time", typ: "timestamptz"},
+		},
+		indexes: []string{"action, time DESC NULLS LAST"},
+	}
+	expiresColumn   = column{name: "expiresat", path: "expiresat", typ: "timestamptz"}
+	runners         = table{name: "autoscale_runners", columns: []column{idColumn}}
+	idempotencyKeys = table{
+		name:    "autoscale_idempotency_keys",
+		columns: []column{idColumn, expiresColumn},
+		indexes: []string{"expiresat"},
+	}
+	auditLog = table{
+		name: "autoscale_audit_log",
+		columns: []column{
+			idColumn,
+			{name: "resource_type", path: "resource.type", typ: "text"},
+			{name: "resource_name", path: "resource.name", typ: "text"},
+			{name: "time", path: "time", typ: "timestamptz"},
+			expiresColumn,
+		},
+		indexes: []string{"time DESC NULLS LAST", "resource_type, resource_name, time DESC NULLS LAST", "expiresat"},
+	}
+	tables = []*table{
+		&wizards, &alarms, &datasources, &actions, &events, &instances,
+		&datasourceStatus, &datasourceSamples, &actionExecutions,
+		&actionDeadLetters, &runners, &idempotencyKeys, &auditLog,
+	}
+)
+
+// schema returns the statements creating the tables and indexes.
+func schema() []string {
+	var statements []string
+	for _, t := range tables {
+		var columns []string
+		for _, c := range t.columns {
+			columns = append(columns, c.name+" "+c.typ)
+		}
+		columns = append(columns, "doc jsonb NOT NULL")
+		statements = append(statements, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", t.name, strings.Join(columns, ", ")))
+		for _, index := range t.indexes {
+			var names []string
+			for _, field := range strings.Split(index, ", ") {
+				names = append(names, strings.Fields(field)[0])
+			}
+			statements = append(statements, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_%s ON %s (%s)", t.name, strings.Join(names, "_"), t.name, index))
+		}
+	}
+	return statements
+}
+
+// Storage is a PostgreSQL storage, sharing a pool of connections.
+type Storage struct {
+	db        *sql.DB
+	eventsTTL time.Duration
+}
+
+// New connects to the PostgreSQL database of the url, creating its tables
+// when they do not exist, and starts removing the expired documents.
+func New(url string) (*Storage, error) {
+	ttl, err := db.EventsTTL()
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %s", err)
+	}
+	conn, err := sql.Open(driverName, url)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %s", err)
+	}
+	for _, statement := range schema() {
+		if _, err = conn.Exec(statement); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("postgres: %s", err)
+		}
+	}
+	s := &Storage{db: conn, eventsTTL: ttl}
+	go s.purgeExpired()
+	return s, nil
+}
+
+// purge is a statement removing expired documents, with the time they
+// expire before.
+type purge struct {
+	statement string
+	before    time.Time
+}
+
+// purges returns the statements removing the documents expired at now: the
+// idempotency keys and the audit log records after their expiration time,
+// and the events started more than the events TTL ago, when it is set.
+func purges(now time.Time, eventsTTL time.Duration) []purge {
+	p := []purge{
+		{statement: "DELETE FROM " + idempotencyKeys.name + " WHERE expiresat < $1", before: now},
+		{statement: "DELETE FROM " + auditLog.name + " WHERE expiresat < $1", before: now},
+	}
+	if eventsTTL > 0 {
+		p = append(p, purge{statement: "DELETE FROM " + events.name + " WHERE starttime < $1", before: now.Add(-eventsTTL)})
+	}
+	return p
+}
+
+// Purge removes the expired documents: the idempotency keys and audit log
+// records after their expiration time, and the events older than
+// AUTOSCALE_EVENTS_TTL, when it is set.
+func (s *Storage) Purge() error {
+	for _, p := range purges(time.Now().UTC(), s.eventsTTL) {
+		if _, err := s.db.Exec(p.statement, p.before); err != nil {
+			return fmt.Errorf("postgres: %s", err)
+		}
+	}
+	return nil
+}
+
+func (s *Storage) purgeExpired() {
+	for range time.Tick(purgeInterval) {
+		if err := s.Purge(); err != nil {
+			log.Log().Error(err)
+		}
+	}
+}
+
+// Open returns the storage, as a backend of db.
+func (s *Storage) Open() (db.Storage, error) {
+	return s, nil
+}
+
+// Wizards returns the repository of the auto scales.
+func (s *Storage) Wizards() db.Repository {
+	return &repository{db: s.db, table: &wizards}
+}
+
+// Alarms returns the repository of the alarms.
+func (s *Storage) Alarms() db.Repository {
+	return &repository{db: s.db, table: &alarms}
+}
+
+// DataSources returns the repository of the data sources.
+func (s *Storage) DataSources() db.Repository {
+	return &repository{db: s.db, table: &datasources}
+}
+
+// Actions returns the repository of the actions.
+func (s *Storage) Actions() db.Repository {
+	return &repository{db: s.db, table: &actions}
+}
+
+// Events returns the repository of the events.
+func (s *Storage) Events() db.Repository {
+	return &repository{db: s.db, table: &events}
+}
+
+// Instances returns the repository of the service instances.
+func (s *Storage) Instances() db.Repository {
+	return &repository{db: s.db, table: &instances}
+}
+
+// DataSourceStatus returns the repository of the status of the data
+// sources.
+func (s *Storage) DataSourceStatus() db.Repository {
+	return &repository{db: s.db, table: &datasourceStatus}
+}
+
+// DataSourceSamples returns the repository of the samples pushed to the
+// data sources.
+func (s *Storage) DataSourceSamples() db.Repository {
+	return &repository{db: s.db, table: &datasourceSamples}
+}
+
+// ActionExecutions returns the repository of the action executions.
+func (s *Storage) ActionExecutions() db.Repository {
+	return &repository{db: s.db, table: &actionExecutions}
+}
+
+// ActionDeadLetters returns the repository of the failed action executions.
+func (s *Storage) ActionDeadLetters() db.Repository {
+	return &repository{db: s.db, table: &actionDeadLetters}
+}
+
+// Runners returns the repository of the status of the auto scale runners.
+func (s *Storage) Runners() db.Repository {
+	return &repository{db: s.db, table: &runners}
+}
+
+// IdempotencyKeys returns the repository of the outcomes of the requests
+// with idempotency keys.
+func (s *Storage) IdempotencyKeys() db.Repository {
+	return &repository{db: s.db, table: &idempotencyKeys}
+}
+
+// AuditLog returns the repository of the audit log.
+func (s *Storage) AuditLog() db.Repository {
+	return &repository{db: s.db, table: &auditLog}
+}
+
+// Ping checks the connection to the database.
+func (s *Storage) Ping() error {
+	return s.db.Ping()
+}
+
+// Close does nothing, the connections are kept in the pool.
+func (s *Storage) Close() {}
+
+// toJSON converts the document values to JSON values, with the times, ids
+// and binaries in the MongoDB extended JSON, like {"$date": "..."}, so they
+// are decoded to the same types.
+func toJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = toJSON(item)
+		}
+		return m
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = toJSON(item)
+		}
+		return items
+	case time.Time:
+		return map[string]interface{}{"$date": v.UTC().Format(time.RFC3339Nano)}
+	case bson.ObjectId:
+		return map[string]interface{}{"$oid": v.Hex()}
+	case []byte:
+		return map[string]interface{}{"$binary": base64.StdEncoding.EncodeToString(v)}
+	}
+	return value
+}
+
+// fromJSON converts the JSON values, decoded with numbers, to the document
+// values.
+func fromJSON(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 1 {
+			for key, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					break
+				}
+				switch key {
+				case "$date":
+					t, err := time.Parse(time.RFC3339Nano, s)
+					return t.Local(), err
+				case "$oid":
+					if !bson.IsObjectIdHex(s) {
+						return nil, fmt.Errorf("postgres: invalid object id %q", s)
+					}
+					return bson.ObjectIdHex(s), nil
+				case "$binary":
+					return base64.StdEncoding.DecodeString(s)
+				}
+			}
+		}
+		doc := make(bson.M, len(v))
+		for key, item := range v {
+			converted, err := fromJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			doc[key] = converted
+		}
+		return doc, nil
+	case []interface{}:
+		for i, item := range v {
+			converted, err := fromJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	}
+	return value, nil
+}
+
+func encodeDoc(doc bson.M) ([]byte, error) {
+	return json.Marshal(toJSON(doc))
+}
+
+func decodeDoc(data []byte) (bson.M, error) {
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	doc, err := fromJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := doc.(bson.M)
+	if !ok {
+		return nil, errors.New("postgres: the document is not an object")
+	}
+	return m, nil
+}
+
+// columnValue returns the value of the column in the document: the ids as
+// hex, the strings and the times, or nil.
+func columnValue(c column, value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case bson.ObjectId:
+		if c.path == "_id" {
+			return v.Hex(), true
+		}
+	case string:
+		if c.typ != "timestamptz" {
+			return v, true
+		}
+	case time.Time:
+		if c.typ == "timestamptz" {
+			return v.UTC(), true
+		}
+	}
+	return nil, false
+}
+
+// row returns the values of the columns of the document, followed by the
+// document in JSON.
+func (t *table) row(doc bson.M) ([]interface{}, error) {
+	var values []interface{}
+	for _, c := range t.columns {
+		var value interface{}
+		if found := document.Lookup(doc, strings.Split(c.path, ".")); len(found) == 1 {
+			value, _ = columnValue(c, found[0])
+		}
+		if c.path == "_id" {
+			value = idValue(doc["_id"])
+		}
+		values = append(values, value)
+	}
+	data, err := encodeDoc(doc)
+	if err != nil {
+		return nil, err
+	}
+	return append(values, string(data)), nil
+}
+
+// idValue returns the value of the id column of the document id.
+func idValue(id interface{}) interface{} {
+	if value, ok := columnValue(column{path: "_id"}, id); ok {
+		return value
+	}
+	return fmt.Sprint(id)
+}
+
+func (t *table) column(path string) (column, bool) {
+	for _, c := range t.columns {
+		if c.path == path {
+			return c, true
+		}
+	}
+	return column{}, false
+}
+
+// where returns the conditions on the columns of the table selecting the
+// rows which may match the query, and whether they select exactly the rows
+// matching it. The conditions the columns can not express are skipped, and
+// the rows are evaluated by the query afterwards.
+func (t *table) where(q bson.M) (string, []interface{}, bool) {
+	var conds []string
+	var args []interface{}
+	exact := true
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+	var add func(q bson.M)
+	add = func(q bson.M) {
+		keys := make([]string, 0, len(q))
+		for key := range q {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := q[key]
+			if key == "$and" {
+				items, ok := value.([]interface{})
+				exact = exact && ok
+				for _, item := range items {
+					sub, ok := item.(bson.M)
+					if !ok {
+						exact = false
+						continue
+					}
+					add(sub)
+				}
+				continue
+			}
+			c, ok := t.column(key)
+			if !ok {
+				exact = false
+				continue
+			}
+			if !document.IsOperators(value) {
+				v, ok := columnValue(c, value)
+				if ok {
+					conds = append(conds, c.name+" = "+arg(v))
+				}
+				exact = exact && ok
+				continue
+			}
+			ops := value.(bson.M)
+			opKeys := make([]string, 0, len(ops))
+			for op := range ops {
+				opKeys = append(opKeys, op)
+			}
+			sort.Strings(opKeys)
+			for _, op := range opKeys {
+				operand := ops[op]
+				switch op {
+				case "$eq", "$gt", "$gte", "$lt", "$lte":
+					v, ok := columnValue(c, operand)
+					if ok {
+						sign := map[string]string{"$eq": "=", "$gt": ">", "$gte": ">=", "$lt": "<", "$lte": "<="}[op]
+						conds = append(conds, c.collated()+" "+sign+" "+arg(v))
+					}
+					exact = exact && ok
+				case "$in":
+					items, _ := operand.([]interface{})
+					var values []interface{}
+					for _, item := range items {
+						if v, ok := columnValue(c, item); ok {
+							values = append(values, v)
+						}
+					}
+					if len(items) == 0 {
+						conds = append(conds, "false")
+					} else if len(values) == len(items) {
+						placeholders := make([]string, len(values))
+						for i, v := range values {
+							placeholders[i] = arg(v)
+						}
+						conds = append(conds, c.name+" IN ("+strings.Join(placeholders, ", ")+")")
+					} else {
+						exact = false
+					}
+				default:
+					exact = false
+				}
+			}
+		}
+	}
+	add(q)
+	if len(conds) == 0 {
+		return "", args, exact
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args, exact
+}
+
+// collated returns the column compared like in MongoDB: the text columns
+// byte by byte, instead of by the collation of the database.
+func (c column) collated() string {
+	if c.typ == "timestamptz" {
+		return c.name
+	}
+	return c.name + ` COLLATE "C"`
+}
+
+// orderBy returns the ORDER BY clause of the sort fields, prefixed by - for
+// descending order, and whether the columns of the table express all of
+// them. The nulls, the documents without the field, sort first in
+// ascending order, like in MongoDB.
+func (t *table) orderBy(fields []string) (string, bool) {
+	var terms []string
+	for _, field := range fields {
+		order := " ASC NULLS FIRST"
+		if strings.HasPrefix(field, "-") {
+			order = " DESC NULLS LAST"
+		}
+		c, ok := t.column(strings.TrimLeft(field, "+-"))
+		if !ok {
+			return "", false
+		}
+		terms = append(terms, c.collated()+order)
+	}
+	if len(terms) == 0 {
+		return "", true
+	}
+	return " ORDER BY " + strings.Join(terms, ", "), true
+}
+
+// page returns the LIMIT and OFFSET clauses of the page, with n documents,
+// or all of them when n is 0, after the first skip ones.
+func page(skip, n int) string {
+	var clauses string
+	if n > 0 {
+		clauses += " LIMIT " + strconv.Itoa(n)
+	}
+	if skip > 0 {
+		clauses += " OFFSET " + strconv.Itoa(skip)
+	}
+	return clauses
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type repository struct {
+	db    *sql.DB
+	table *table
+}
+
+// rows returns the documents of the rows selected by the clauses.
+func (r *repository) rows(qr queryer, clauses string, args []interface{}) ([]bson.M, error) {
+	rows, err := qr.Query("SELECT doc FROM "+r.table.name+clauses, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var docs []bson.M
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		doc, err := decodeDoc(data)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// load returns the documents of the rows which may match the query.
+func (r *repository) load(qr queryer, q bson.M) ([]bson.M, error) {
+	where, args, _ := r.table.where(q)
+	return r.rows(qr, where, args)
+}
+
+// find returns the documents matching the query in the transaction, locked
+// for update, up to n documents, or all of them when n is 0.
+func (r *repository) find(tx *sql.Tx, q bson.M, n int) ([]bson.M, error) {
+	where, args, exact := r.table.where(q)
+	if exact {
+		return r.rows(tx, where+page(0, n)+" FOR UPDATE", args)
+	}
+	docs, err := r.rows(tx, where+" FOR UPDATE", args)
+	if err != nil {
+		return nil, err
+	}
+	docs, err = document.Filter(docs, q)
+	if n > 0 && len(docs) > n {
+		docs = docs[:n]
+	}
+	return docs, err
+}
+
+func (r *repository) transaction(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *repository) dupError(doc bson.M) error {
+	return &mgo.LastError{
+		Code: 11000,
+		Err:  fmt.Sprintf("E11000 duplicate key error table: %s dup key: { : %v }", r.table.name, doc[r.table.unique]),
+	}
+}
+
+func (r *repository) insert(tx *sql.Tx, doc bson.M) error {
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectId()
+	}
+	values, err := r.table.row(doc)
+	if err != nil {
+		return err
+	}
+	var names, placeholders []string
+	for i, c := range r.table.columns {
+		names = append(names, c.name)
+		placeholders = append(placeholders, "$"+strconv.Itoa(i+1))
+	}
+	names = append(names, "doc")
+	placeholders = append(placeholders, "$"+strconv.Itoa(len(values)))
+	result, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING", r.table.name, strings.Join(names, ", "), strings.Join(placeholders, ", ")), values...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		err = r.dupError(doc)
+	}
+	return err
+}
+
+// replace stores the document of the row with the id.
+func (r *repository) replace(tx *sql.Tx, old, doc bson.M) error {
+	if r.table.unique != "" && !document.Equal(old[r.table.unique], doc[r.table.unique]) {
+		var n int
+		err := tx.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s WHERE %s = $1 AND id <> $2", r.table.name, r.table.unique), doc[r.table.unique], idValue(old["_id"])).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return r.dupError(doc)
+		}
+	}
+	values, err := r.table.row(doc)
+	if err != nil {
+		return err
+	}
+	var sets []string
+	for i, c := range r.table.columns {
+		sets = append(sets, c.name+" = $"+strconv.Itoa(i+1))
+	}
+	sets = append(sets, "doc = $"+strconv.Itoa(len(values)))
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE id = $%d", r.table.name, strings.Join(sets, ", "), len(values)+1), append(values, idValue(old["_id"]))...)
+	return err
+}
+
+// update applies the update to the documents matching the selector, up to
+// n documents, or all of them when n is 0, returning the number of updated
+// documents.
+func (r *repository) update(tx *sql.Tx, q, u bson.M, n int) (int, error) {
+	docs, err := r.find(tx, q, n)
+	if err != nil {
+		return 0, err
+	}
+	for _, old := range docs {
+		doc, err := document.Apply(old, u)
+		if err != nil {
+			return 0, err
+		}
+		if err = r.replace(tx, old, doc); err != nil {
+			return 0, err
+		}
+	}
+	return len(docs), nil
+}
+
+func (r *repository) Find(q interface{}) db.Query {
+	return &query{r: r, query: q}
+}
+
+func (r *repository) FindId(id interface{}) db.Query {
+	return r.Find(bson.M{"_id": id})
+}
+
+func (r *repository) Insert(docs ...interface{}) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		for _, d := range docs {
+			doc, err := document.ToDoc(d)
+			if err != nil {
+				return err
+			}
+			if err = r.insert(tx, doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// updates runs the update of the documents matching the selector in a
+// transaction.
+func (r *repository) updates(selector, update interface{}, n int, upsert bool) (*mgo.ChangeInfo, error) {
+	q, err := document.ToDoc(selector)
+	if err != nil {
+		return nil, err
+	}
+	u, err := document.ToDoc(update)
+	if err != nil {
+		return nil, err
+	}
+	var info mgo.ChangeInfo
+	err = r.transaction(func(tx *sql.Tx) error {
+		updated, err := r.update(tx, q, u, n)
+		if err != nil {
+			return err
+		}
+		info.Updated, info.Matched = updated, updated
+		if updated > 0 || !upsert {
+			return nil
+		}
+		doc, err := document.Upserted(q, u)
+		if err != nil {
+			return err
+		}
+		info.UpsertedId = doc["_id"]
+		return r.insert(tx, doc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (r *repository) Update(selector, update interface{}) error {
+	info, err := r.updates(selector, update, 1, false)
+	if err == nil && info.Updated == 0 {
+		err = mgo.ErrNotFound
+	}
+	return err
+}
+
+func (r *repository) UpdateId(id, update interface{}) error {
+	return r.Update(bson.M{"_id": id}, update)
+}
+
+func (r *repository) UpdateAll(selector, update interface{}) (*mgo.ChangeInfo, error) {
+	return r.updates(selector, update, 0, false)
+}
+
+func (r *repository) Upsert(selector, update interface{}) (*mgo.ChangeInfo, error) {
+	return r.updates(selector, update, 1, true)
+}
+
+// remove removes the documents matching the selector, up to n documents, or
+// all of them when n is 0, returning the number of removed documents.
+func (r *repository) remove(selector interface{}, n int) (int, error) {
+	q, err := document.ToDoc(selector)
+	if err != nil {
+		return 0, err
+	}
+	var removed int
+	err = r.transaction(func(tx *sql.Tx) error {
+		docs, err := r.find(tx, q, n)
+		if err != nil || len(docs) == 0 {
+			return err
+		}
+		var ids []interface{}
+		var placeholders []string
+		for _, doc := range docs {
+			ids = append(ids, idValue(doc["_id"]))
+			placeholders = append(placeholders, "$"+strconv.Itoa(len(ids)))
+		}
+		_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id IN (%s)", r.table.name, strings.Join(placeholders, ", ")), ids...)
+		removed = len(docs)
+		return err
+	})
+	return removed, err
+}
+
+func (r *repository) Remove(selector interface{}) error {
+	n, err := r.remove(selector, 1)
+	if err == nil && n == 0 {
+		err = mgo.ErrNotFound
+	}
+	return err
+}
+
+func (r *repository) RemoveAll(selector interface{}) (*mgo.ChangeInfo, error) {
+	n, err := r.remove(selector, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &mgo.ChangeInfo{Removed: n, Matched: n}, nil
+}
+
+func (r *repository) Pipe(pipeline interface{}) db.Pipe {
+	return document.NewPipe(pipeline, func(q bson.M) ([]bson.M, error) {
+		return r.load(r.db, q)
+	})
+}
+
+// query is a query of a repository. When the columns of the table express
+// its conditions and sort, PostgreSQL selects its page, using the indexes,
+// and the rows are not evaluated again. Otherwise, the rows which may match
+// it are loaded and evaluated by the document package.
+type query struct {
+	r        *repository
+	query    interface{}
+	sort     []string
+	selector interface{}
+	skip     int
+	limit    int
+}
+
+func (q *query) Sort(fields ...string) db.Query {
+	q.sort = fields
+	return q
+}
+
+func (q *query) Select(selector interface{}) db.Query {
+	q.selector = selector
+	return q
+}
+
+func (q *query) Skip(n int) db.Query {
+	q.skip = n
+	return q
+}
+
+func (q *query) Limit(n int) db.Query {
+	q.limit = n
+	return q
+}
+
+// where returns the conditions of the query and whether they select
+// exactly the rows matching it.
+func (q *query) where() (string, []interface{}, bool) {
+	filter, err := document.ToDoc(q.query)
+	if err != nil {
+		return "", nil, false
+	}
+	return q.r.table.where(filter)
+}
+
+// documents returns the query evaluating the loaded rows.
+func (q *query) documents() db.Query {
+	where, args, exact := q.where()
+	order, sorted := q.r.table.orderBy(q.sort)
+	if exact && sorted {
+		clauses := where + order + page(q.skip, q.limit)
+		return document.NewQuery(q.query, func(bson.M) ([]bson.M, error) {
+			return q.r.rows(q.r.db, clauses, args)
+		}).Select(q.selector)
+	}
+	return document.NewQuery(q.query, func(filter bson.M) ([]bson.M, error) {
+		return q.r.load(q.r.db, filter)
+	}).Sort(q.sort...).Select(q.selector).Skip(q.skip).Limit(q.limit)
+}
+
+func (q *query) Count() (int, error) {
+	where, args, exact := q.where()
+	if !exact {
+		return q.documents().Count()
+	}
+	var n int
+	err := q.r.db.QueryRow("SELECT count(*) FROM "+q.r.table.name+where, args...).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	if n -= q.skip; n < 0 {
+		n = 0
+	}
+	if q.limit > 0 && n > q.limit {
+		n = q.limit
+	}
+	return n, nil
+}
+
+func (q *query) One(result interface{}) error {
+	one := *q
+	one.limit = 1
+	return one.documents().One(result)
+}
+
+func (q *query) All(result interface{}) error {
+	return q.documents().All(result)
+}
+
+func (q *query) Iter() db.Iter {
+	return q.documents().Iter()
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func (s *S) TestJSONRoundTrip(c *check.C) {
+	now := time.Now().Truncate(time.Millisecond)
+	doc := bson.M{
+		"_id":       bson.NewObjectId(),
+		"name":      "alarm",
+		"wait":      int64(1) << 60,
+		"expr":      1.5,
+		"enabled":   true,
+		"starttime": now,
+		"data":      []byte("raw"),
+		"envs":      bson.M{"step": "1"},
+		"actions":   []interface{}{"scale_up", bson.M{"$oid": 1}},
+		"error":     nil,
+	}
+	data, err := encodeDoc(doc)
+	c.Assert(err, check.IsNil)
+	decoded, err := decodeDoc(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(decoded["starttime"].(time.Time).Equal(now), check.Equals, true)
+	decoded["starttime"] = now
+	doc["actions"] = []interface{}{"scale_up", bson.M{"$oid": int64(1)}}
+	c.Assert(decoded, check.DeepEquals, doc)
+	_, err = decodeDoc([]byte(`{"_id": {"$oid": "invalid"}}`))
+	c.Assert(err, check.ErrorMatches, `postgres: invalid object id "invalid"`)
+	_, err = decodeDoc([]byte(`[]`))
+	c.Assert(err, check.ErrorMatches, "postgres: the document is not an object")
+}
+
+func (s *S) TestRow(c *check.C) {
+	id := bson.NewObjectId()
+	now := time.Now()
+	values, err := events.row(bson.M{"_id": id, "alarm": bson.M{"name": "a", "instance": 1}, "starttime": now})
+	c.Assert(err, check.IsNil)
+	c.Assert(values[:4], check.DeepEquals, []interface{}{id.Hex(), "a", nil, now.UTC()})
+	values, err = alarms.row(bson.M{"_id": "custom", "name": "a"})
+	c.Assert(err, check.IsNil)
+	c.Assert(values[:2], check.DeepEquals, []interface{}{"custom", "a"})
+	c.Assert(idValue(10), check.Equals, "10")
+}
+
+func (s *S) TestWhere(c *check.C) {
+	id := bson.NewObjectId()
+	now := time.Now()
+	tests := []struct {
+		query bson.M
+		where string
+		args  []interface{}
+		exact bool
+	}{
+		{nil, "", nil, true},
+		{bson.M{"name": "a", "wait": 1}, " WHERE name = $1", []interface{}{"a"}, false},
+		{bson.M{"_id": id}, " WHERE id = $1", []interface{}{id.Hex()}, true},
+		{bson.M{"name": bson.M{"$in": []interface{}{"a", "b"}}}, " WHERE name IN ($1, $2)", []interface{}{"a", "b"}, true},
+		{bson.M{"name": bson.M{"$in": []interface{}{"a", nil}}}, "", nil, false},
+		{bson.M{"name": bson.M{"$in": []interface{}{}}}, " WHERE false", nil, true},
+		{bson.M{"name": bson.M{"$gt": "a"}}, ` WHERE name COLLATE "C" > $1`, []interface{}{"a"}, true},
+		{bson.M{"name": bson.M{"$ne": "a"}}, "", nil, false},
+		{bson.M{"name": 1}, "", nil, false},
+		{bson.M{"$or": []interface{}{bson.M{"name": "a"}}}, "", nil, false},
+	}
+	for _, tt := range tests {
+		where, args, exact := alarms.where(tt.query)
+		c.Check(where, check.Equals, tt.where, check.Commentf("%v", tt.query))
+		c.Check(args, check.DeepEquals, tt.args, check.Commentf("%v", tt.query))
+		c.Check(exact, check.Equals, tt.exact, check.Commentf("%v", tt.query))
+	}
+	where, args, exact := events.where(bson.M{
+		"$and": []interface{}{
+			bson.M{"alarm.name": "a"},
+			bson.M{"starttime": bson.M{"$gte": now, "$lt": "invalid"}},
+		},
+		"alarm.instance": "i1",
+	})
+	c.Assert(where, check.Equals, " WHERE alarm_name = $1 AND starttime >= $2 AND instance = $3")
+	c.Assert(args, check.DeepEquals, []interface{}{"a", now.UTC(), "i1"})
+	c.Assert(exact, check.Equals, false)
+	where, args, exact = events.where(bson.M{"alarm.name": "a", "starttime": bson.M{"$gte": now, "$lt": now}})
+	c.Assert(where, check.Equals, " WHERE alarm_name = $1 AND starttime >= $2 AND starttime < $3")
+	c.Assert(args, check.DeepEquals, []interface{}{"a", now.UTC(), now.UTC()})
+	c.Assert(exact, check.Equals, true)
+}
+
+func (s *S) TestOrderBy(c *check.C) {
+	order, ok := events.orderBy(nil)
+	c.Assert(order, check.Equals, "")
+	c.Assert(ok, check.Equals, true)
+	order, ok = events.orderBy([]string{"alarm.name", "-starttime"})
+	c.Assert(order, check.Equals, ` ORDER BY alarm_name COLLATE "C" ASC NULLS FIRST, starttime DESC NULLS LAST`)
+	c.Assert(ok, check.Equals, true)
+	_, ok = events.orderBy([]string{"-starttime", "endtime"})
+	c.Assert(ok, check.Equals, false)
+	c.Assert(page(0, 0), check.Equals, "")
+	c.Assert(page(20, 10), check.Equals, " LIMIT 10 OFFSET 20")
+}
+
+func (s *S) TestPurges(c *check.C) {
+	now := time.Now().UTC()
+	c.Assert(purges(now, 0), check.DeepEquals, []purge{
+		{statement: "DELETE FROM autoscale_idempotency_keys WHERE expiresat < $1", before: now},
+		{statement: "DELETE FROM autoscale_audit_log WHERE expiresat < $1", before: now},
+	})
+	p := purges(now, time.Hour)
+	c.Assert(p, check.HasLen, 3)
+	c.Assert(p[2], check.DeepEquals, purge{statement: "DELETE FROM autoscale_events WHERE starttime < $1", before: now.Add(-time.Hour)})
+}
+
+func (s *S) TestSchema(c *check.C) {
+	statements := schema()
+	c.Assert(statements, check.HasLen, 24)
+	c.Assert(statements[0], check.Equals, "CREATE TABLE IF NOT EXISTS autoscale_wizards (id text PRIMARY KEY, name text UNIQUE, doc jsonb NOT NULL)")
+	c.Assert(statements[4:8], check.DeepEquals, []string{
+		"CREATE TABLE IF NOT EXISTS autoscale_events (id text PRIMARY KEY, alarm_name text, instance text, starttime timestamptz, doc jsonb NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS autoscale_events_alarm_name ON autoscale_events (alarm_name)",
+		"CREATE INDEX IF NOT EXISTS autoscale_events_starttime ON autoscale_events (starttime DESC NULLS LAST)",
+		"CREATE INDEX IF NOT EXISTS autoscale_events_instance_starttime ON autoscale_events (instance, starttime DESC NULLS LAST)",
+	})
+	c.Assert(statements[22:], check.DeepEquals, []string{
+		"CREATE INDEX IF NOT EXISTS autoscale_audit_log_resource_type_resource_name_time ON autoscale_audit_log (resource_type, resource_name, time DESC NULLS LAST)",
+		"CREATE INDEX IF NOT EXISTS autoscale_audit_log_expiresat ON autoscale_audit_log (expiresat)",
+	})
+}
+
+func (s *S) TestNewUnknownDriver(c *check.C) {
+	_, err := New("postgres://localhost/autoscale")
+	c.Assert(err, check.ErrorMatches, `postgres: sql: unknown driver "postgres".*`)
+}
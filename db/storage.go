@@ -0,0 +1,260 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package db
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/tsuru/tsuru/db/storage"
+	"gopkg.in/mgo.v2"
+)
+
+// Storage is the storage of the configurations of the autoscale, of the
+// events of the alarms and of the state of the service, by repository. The
+// storage must be closed after use.
+type Storage interface {
+	Wizards() Repository
+	Alarms() Repository
+	DataSources() Repository
+	Actions() Repository
+	Events() Repository
+	Instances() Repository
+	DataSourceStatus() Repository
+	DataSourceSamples() Repository
+	ActionExecutions() Repository
+	ActionDeadLetters() Repository
+	Runners() Repository
+	IdempotencyKeys() Repository
+	AuditLog() Repository
+	// Ping checks that the storage is reachable.
+	Ping() error
+	Close()
+}
+
+// Repository stores the documents of a kind. The selectors, queries and
+// updates are MongoDB documents, like bson.M, and the backends return the
+// errors of mgo: mgo.ErrNotFound when no document matches and the ones
+// accepted by mgo.IsDup when a unique field is duplicated.
+type Repository interface {
+	Find(query interface{}) Query
+	FindId(id interface{}) Query
+	Insert(docs ...interface{}) error
+	Update(selector, update interface{}) error
+	UpdateId(id, update interface{}) error
+	UpdateAll(selector, update interface{}) (*mgo.ChangeInfo, error)
+	Upsert(selector, update interface{}) (*mgo.ChangeInfo, error)
+	Remove(selector interface{}) error
+	RemoveAll(selector interface{}) (*mgo.ChangeInfo, error)
+	Pipe(pipeline interface{}) Pipe
+}
+
+// Query is a query of the documents of a repository.
+type Query interface {
+	Sort(fields ...string) Query
+	Select(selector interface{}) Query
+	Skip(n int) Query
+	Limit(n int) Query
+	Count() (int, error)
+	One(result interface{}) error
+	All(result interface{}) error
+	Iter() Iter
+}
+
+// Iter iterates over the documents of a query.
+type Iter interface {
+	Next(result interface{}) bool
+	Close() error
+}
+
+// Pipe is an aggregation pipeline of a repository.
+type Pipe interface {
+	All(result interface{}) error
+}
+
+// Backend opens the storage of a backend.
+type Backend func() (Storage, error)
+
+var backend struct {
+	sync.RWMutex
+	open Backend
+}
+
+// SetBackend sets the backend of the storage returned by Open, like an
+// in-memory one in the tests. A nil backend restores MongoDB.
+func SetBackend(b Backend) {
+	backend.Lock()
+	defer backend.Unlock()
+	backend.open = b
+}
+
+// Open opens the storage of the backend, MongoDB by default.
+func Open() (Storage, error) {
+	backend.RLock()
+	open := backend.open
+	backend.RUnlock()
+	if open != nil {
+		return open()
+	}
+	conn, err := Conn()
+	if err != nil {
+		return nil, err
+	}
+	return mongoRepositories{conn}, nil
+}
+
+// OpenReports opens the storage of the backend for the expensive read-only
+// queries of the reports, which MongoDB reads with the read preference of
+// ReportsConn. The data read may lag behind the primary, so it must not be
+// used by the alarms checks or to update the documents.
+func OpenReports() (Storage, error) {
+	backend.RLock()
+	open := backend.open
+	backend.RUnlock()
+	if open != nil {
+		return open()
+	}
+	conn, err := ReportsConn()
+	if err != nil {
+		return nil, err
+	}
+	return mongoRepositories{conn}, nil
+}
+
+// mongoRepositories is the storage of the MongoDB connection.
+type mongoRepositories struct {
+	conn *MongoStorage
+}
+
+func (s mongoRepositories) Wizards() Repository {
+	return NewRepository(s.conn.Wizard())
+}
+
+func (s mongoRepositories) Alarms() Repository {
+	return NewRepository(s.conn.Alarms())
+}
+
+func (s mongoRepositories) DataSources() Repository {
+	return NewRepository(s.conn.DataSources())
+}
+
+func (s mongoRepositories) Actions() Repository {
+	return NewRepository(s.conn.Actions())
+}
+
+func (s mongoRepositories) Events() Repository {
+	return NewRepository(s.conn.Events())
+}
+
+func (s mongoRepositories) Instances() Repository {
+	return NewRepository(s.conn.Instances())
+}
+
+func (s mongoRepositories) DataSourceStatus() Repository {
+	return NewRepository(s.conn.DataSourceStatus())
+}
+
+func (s mongoRepositories) DataSourceSamples() Repository {
+	return NewRepository(s.conn.DataSourceSamples())
+}
+
+func (s mongoRepositories) ActionExecutions() Repository {
+	return NewRepository(s.conn.ActionExecutions())
+}
+
+func (s mongoRepositories) ActionDeadLetters() Repository {
+	return NewRepository(s.conn.ActionDeadLetters())
+}
+
+func (s mongoRepositories) Runners() Repository {
+	return NewRepository(s.conn.Runners())
+}
+
+func (s mongoRepositories) IdempotencyKeys() Repository {
+	return NewRepository(s.conn.IdempotencyKeys())
+}
+
+func (s mongoRepositories) AuditLog() Repository {
+	return NewRepository(s.conn.AuditLog())
+}
+
+func (s mongoRepositories) Ping() error {
+	err := s.conn.session.Ping()
+	if err != nil {
+		connectionErrors.Inc("ping")
+	}
+	return err
+}
+
+func (s mongoRepositories) Close() {
+	s.conn.Close()
+}
+
+// NewRepository returns the repository of the MongoDB collection.
+func NewRepository(c *storage.Collection) Repository {
+	return mongoRepository{c.Collection}
+}
+
+// mongoRepository is the repository of a MongoDB collection.
+type mongoRepository struct {
+	*mgo.Collection
+}
+
+func (r mongoRepository) Find(query interface{}) Query {
+	return mongoQuery{r.Collection.Find(query)}
+}
+
+func (r mongoRepository) FindId(id interface{}) Query {
+	return mongoQuery{r.Collection.FindId(id)}
+}
+
+func (r mongoRepository) Pipe(pipeline interface{}) Pipe {
+	return r.Collection.Pipe(pipeline)
+}
+
+type mongoQuery struct {
+	*mgo.Query
+}
+
+func (q mongoQuery) Sort(fields ...string) Query {
+	return mongoQuery{q.Query.Sort(fields...)}
+}
+
+func (q mongoQuery) Select(selector interface{}) Query {
+	return mongoQuery{q.Query.Select(selector)}
+}
+
+func (q mongoQuery) Skip(n int) Query {
+	return mongoQuery{q.Query.Skip(n)}
+}
+
+func (q mongoQuery) Limit(n int) Query {
+	return mongoQuery{q.Query.Limit(n)}
+}
+
+func (q mongoQuery) Iter() Iter {
+	return q.Query.Iter()
+}
+
+// NotFoundError is returned by the lookups of the resources, like alarms
+// and actions, which do not exist.
+type NotFoundError struct {
+	// Resource is the kind of the resource, like alarm.
+	Resource string
+	Name     string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %q not found", e.Resource, e.Name)
+}
+
+// IsNotFound reports whether err is a *NotFoundError or mgo.ErrNotFound,
+// returned by the repositories when no document matches.
+func IsNotFound(err error) bool {
+	if _, ok := err.(*NotFoundError); ok {
+		return true
+	}
+	return err == mgo.ErrNotFound
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package storagetest sets up the storage of the test suites: an in-memory
+// storage by default, so the tests do not need a MongoDB, or the MongoDB
+// database of the suite when AUTOSCALE_TEST_STORAGE is mongodb:
+//
+//	AUTOSCALE_TEST_STORAGE=mongodb go test ./...
+package storagetest
+
+import (
+	"os"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/db/memory"
+	"github.com/tsuru/tsuru/db/dbtest"
+)
+
+// Storage is the storage of a test suite, set as the backend of db.
+type Storage struct {
+	db.Storage
+	open  db.Backend
+	clear func()
+}
+
+// New sets the storage of the suite as the backend of db, using the
+// MongoDB database dbname when AUTOSCALE_TEST_STORAGE is mongodb.
+func New(dbname string) (*Storage, error) {
+	if os.Getenv("AUTOSCALE_TEST_STORAGE") != "mongodb" {
+		storage := memory.New()
+		db.SetBackend(storage.Open)
+		return &Storage{Storage: storage, open: storage.Open, clear: storage.Drop}, nil
+	}
+	os.Setenv("MONGODB_DATABASE_NAME", dbname)
+	db.SetBackend(nil)
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	storage, err := db.Open()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	database := conn.Collection("storagetest").Database
+	return &Storage{Storage: storage, clear: func() { dbtest.ClearAllCollections(database) }}, nil
+}
+
+// Use sets the storage back as the backend of db, after a test which
+// changed it.
+func (s *Storage) Use() {
+	db.SetBackend(s.open)
+}
+
+// Clear removes the documents of the storage, between the tests.
+func (s *Storage) Clear() {
+	s.clear()
+}
@@ -0,0 +1,8 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// The PostgreSQL driver of AUTOSCALE_STORAGE=postgres.
+import _ "github.com/lib/pq"
@@ -0,0 +1,174 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package httpclient provides the HTTP clients used by tsuru-autoscale to
+// reach data sources and actions.
+//
+// The clients are cached by configuration, so connections are reused
+// between requests to the same endpoints. A global TLS configuration can be
+// set using the environment variables AUTOSCALE_TLS_CA_FILE,
+// AUTOSCALE_TLS_CERT_FILE and AUTOSCALE_TLS_KEY_FILE, and it is combined
+// with the TLS configuration of each data source or action.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// TLS represents the TLS configuration of outgoing requests. Certificates
+// and keys are PEM encoded.
+type TLS struct {
+	CACert             string `json:"ca_cert,omitempty" bson:",omitempty"`
+	Cert               string `json:"cert,omitempty" bson:",omitempty"`
+	Key                string `json:"key,omitempty" bson:",omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty" bson:",omitempty"`
+}
+
+// Direct is the proxy url that disables proxies, including the ones
+// defined by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+const Direct = "direct"
+
+type clientKey struct {
+	tls   TLS
+	proxy string
+}
+
+var clients = struct {
+	sync.Mutex
+	cache map[clientKey]*http.Client
+}{cache: map[clientKey]*http.Client{}}
+
+// Client returns an HTTP client configured with the given TLS
+// configuration, which may be nil.
+func Client(t *TLS) (*http.Client, error) {
+	return ProxyClient(t, "")
+}
+
+// ProxyClient returns an HTTP client configured with the given TLS
+// configuration, which may be nil, sending the requests through the proxy
+// proxyURL. When proxyURL is empty, the proxy environment variables are
+// used, and when it is Direct no proxy is used.
+func ProxyClient(t *TLS, proxyURL string) (*http.Client, error) {
+	key := clientKey{proxy: proxyURL}
+	if t != nil {
+		key.tls = *t
+	}
+	clients.Lock()
+	defer clients.Unlock()
+	if c, ok := clients.cache[key]; ok {
+		return c, nil
+	}
+	proxy, err := proxyFunc(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	config, err := key.tls.config()
+	if err != nil {
+		return nil, err
+	}
+	c := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           proxy,
+			TLSClientConfig: config,
+		},
+	}
+	clients.cache[key] = c
+	return c, nil
+}
+
+// ValidateProxy checks that proxyURL is empty, Direct or an absolute http,
+// https or socks5 url.
+func ValidateProxy(proxyURL string) error {
+	_, err := proxyFunc(proxyURL)
+	return err
+}
+
+func proxyFunc(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxyURL {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case Direct:
+		return nil, nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("httpclient: invalid proxy url %q: %s", proxyURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+		return nil, fmt.Errorf("httpclient: invalid proxy url %q: must be an absolute http, https or socks5 url", proxyURL)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// TLSConfig returns the TLS configuration of the given TLS, which may be
+// nil, combined with the global one, for the clients that do not use HTTP,
+// like the gRPC ones.
+func TLSConfig(t *TLS) (*tls.Config, error) {
+	if t == nil {
+		t = &TLS{}
+	}
+	return t.config()
+}
+
+func readEnvFile(name string) ([]byte, error) {
+	path := os.Getenv(name)
+	if path == "" {
+		return nil, nil
+	}
+	return ioutil.ReadFile(path)
+}
+
+func (t *TLS) config() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify}
+	globalCA, err := readEnvFile("AUTOSCALE_TLS_CA_FILE")
+	if err != nil {
+		return nil, err
+	}
+	var cas [][]byte
+	if len(globalCA) > 0 {
+		cas = append(cas, globalCA)
+	}
+	if t.CACert != "" {
+		cas = append(cas, []byte(t.CACert))
+	}
+	if len(cas) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, ca := range cas {
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, errors.New("httpclient: invalid CA certificate")
+			}
+		}
+		config.RootCAs = pool
+	}
+	cert, key := []byte(t.Cert), []byte(t.Key)
+	if len(cert) == 0 && len(key) == 0 {
+		cert, err = readEnvFile("AUTOSCALE_TLS_CERT_FILE")
+		if err != nil {
+			return nil, err
+		}
+		key, err = readEnvFile("AUTOSCALE_TLS_KEY_FILE")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(cert) > 0 || len(key) > 0 {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
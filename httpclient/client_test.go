@@ -0,0 +1,149 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpclient
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	cert string
+	key  string
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, check.IsNil)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	c.Assert(err, check.IsNil)
+	s.cert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	s.key = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}))
+}
+
+func (s *S) newServer(c *check.C) *httptest.Server {
+	pair, err := tls.X509KeyPair([]byte(s.cert), []byte(s.key))
+	c.Assert(err, check.IsNil)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM([]byte(s.cert))
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	ts.StartTLS()
+	return ts
+}
+
+func (s *S) TestClientWithClientCertificate(c *check.C) {
+	ts := s.newServer(c)
+	defer ts.Close()
+	client, err := Client(&TLS{CACert: s.cert, Cert: s.cert, Key: s.key})
+	c.Assert(err, check.IsNil)
+	resp, err := client.Get(ts.URL)
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+}
+
+func (s *S) TestClientWithoutClientCertificate(c *check.C) {
+	ts := s.newServer(c)
+	defer ts.Close()
+	client, err := Client(&TLS{CACert: s.cert})
+	c.Assert(err, check.IsNil)
+	_, err = client.Get(ts.URL)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestClientUnknownAuthority(c *check.C) {
+	ts := s.newServer(c)
+	defer ts.Close()
+	client, err := Client(nil)
+	c.Assert(err, check.IsNil)
+	_, err = client.Get(ts.URL)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestClientIsCached(c *check.C) {
+	c1, err := Client(&TLS{CACert: s.cert})
+	c.Assert(err, check.IsNil)
+	c2, err := Client(&TLS{CACert: s.cert})
+	c.Assert(err, check.IsNil)
+	c.Assert(c1, check.Equals, c2)
+}
+
+func (s *S) TestClientInvalidCA(c *check.C) {
+	_, err := Client(&TLS{CACert: "invalid"})
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestClientInvalidKeyPair(c *check.C) {
+	_, err := Client(&TLS{Cert: s.cert})
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestProxyClient(c *check.C) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+	client, err := ProxyClient(nil, proxy.URL)
+	c.Assert(err, check.IsNil)
+	_, err = client.Get("http://metrics.example.com/cpu")
+	c.Assert(err, check.IsNil)
+	c.Assert(proxied, check.Equals, "http://metrics.example.com/cpu")
+	direct, err := ProxyClient(nil, Direct)
+	c.Assert(err, check.IsNil)
+	c.Assert(direct, check.Not(check.Equals), client)
+	c.Assert(direct.Transport.(*http.Transport).Proxy, check.IsNil)
+}
+
+func (s *S) TestValidateProxy(c *check.C) {
+	c.Assert(ValidateProxy(""), check.IsNil)
+	c.Assert(ValidateProxy(Direct), check.IsNil)
+	c.Assert(ValidateProxy("socks5://proxy:1080"), check.IsNil)
+	c.Assert(ValidateProxy("proxy:3128"), check.ErrorMatches, `httpclient: invalid proxy url "proxy:3128": must be an absolute http, https or socks5 url`)
+}
+
+func (s *S) TestTLSJSON(c *check.C) {
+	t := TLS{CACert: "ca", Cert: "cert", Key: "key", InsecureSkipVerify: true}
+	data, err := json.Marshal(t)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, `{"ca_cert":"ca","cert":"cert","key":"key","insecure_skip_verify":true}`)
+	var decoded TLS
+	err = json.Unmarshal(data, &decoded)
+	c.Assert(err, check.IsNil)
+	c.Assert(decoded, check.DeepEquals, t)
+}
@@ -0,0 +1,42 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpclient
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRetryAfter(c *check.C) {
+	tests := []struct {
+		header   string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.header != "" {
+			resp.Header.Set("Retry-After", tt.header)
+		}
+		c.Check(RetryAfter(resp), check.Equals, tt.expected, check.Commentf("header %q", tt.header))
+	}
+	resp := &http.Response{Header: http.Header{"Retry-After": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}}
+	d := RetryAfter(resp)
+	c.Assert(d > 59*time.Minute && d <= time.Hour, check.Equals, true)
+}
+
+func (s *S) TestThrottled(c *check.C) {
+	c.Assert(Throttled(&http.Response{StatusCode: http.StatusTooManyRequests}), check.Equals, true)
+	c.Assert(Throttled(&http.Response{StatusCode: http.StatusServiceUnavailable}), check.Equals, true)
+	c.Assert(Throttled(&http.Response{StatusCode: http.StatusInternalServerError}), check.Equals, false)
+}
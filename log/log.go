@@ -5,8 +5,11 @@
 package log
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/getsentry/raven-go"
 )
@@ -22,24 +25,43 @@ var lg *Logger
 // Logger represents a logger
 type Logger struct {
 	lg *log.Logger
+	// fields are written before the messages, in the key=value form.
+	fields string
+	tags   map[string]string
 }
 
 // Print writes an info in the log
 func (l *Logger) Print(v ...interface{}) {
-	l.lg.Print(v...)
+	l.lg.Print(l.fields + fmt.Sprint(v...))
 }
 
 // Printf writes an info with format in the log
 func (l *Logger) Printf(format string, v ...interface{}) {
-	l.lg.Printf(format, v...)
+	l.lg.Print(l.fields + fmt.Sprintf(format, v...))
 }
 
 // Error writes an error in the log
 func (l *Logger) Error(err error) {
-	raven.CaptureError(err, nil)
+	raven.CaptureError(err, l.tags)
 	l.Print(err)
 }
 
+// With returns a logger writing the field, along with the fields of l,
+// before the messages, in the key=value form. The fields are also sent as
+// tags of the errors.
+func (l *Logger) With(key string, value interface{}) *Logger {
+	v := fmt.Sprint(value)
+	tags := make(map[string]string, len(l.tags)+1)
+	for k, t := range l.tags {
+		tags[k] = t
+	}
+	tags[key] = v
+	if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+		v = strconv.Quote(v)
+	}
+	return &Logger{lg: l.lg, fields: l.fields + key + "=" + v + " ", tags: tags}
+}
+
 // New returns a new Logger
 func New() *Logger {
 	return &Logger{lg: log.New(os.Stdout, "[autoscale] ", 0)}
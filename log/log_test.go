@@ -0,0 +1,41 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+func (s *S) TestWith(c *check.C) {
+	var buf bytes.Buffer
+	l := &Logger{lg: log.New(&buf, "", 0)}
+	l.With("request_id", "abc").With("route", "/alarm/{name}").Printf("alarm %s executed", "cpu")
+	l.Print("no fields")
+	c.Assert(buf.String(), check.Equals, "request_id=abc route=/alarm/{name} alarm cpu executed\nno fields\n")
+}
+
+func (s *S) TestWithQuotesValues(c *check.C) {
+	var buf bytes.Buffer
+	l := &Logger{lg: log.New(&buf, "", 0)}
+	l.With("error", `invalid "body"`).With("principal", "").Print("request")
+	c.Assert(buf.String(), check.Equals, `error="invalid \"body\"" principal="" request`+"\n")
+}
+
+func (s *S) TestWithTags(c *check.C) {
+	l := New().With("request_id", "abc")
+	child := l.With("status", 500)
+	c.Assert(l.tags, check.DeepEquals, map[string]string{"request_id": "abc"})
+	c.Assert(child.tags, check.DeepEquals, map[string]string{"request_id": "abc", "status": "500"})
+}
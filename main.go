@@ -5,15 +5,28 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/codegangsta/negroni"
 	"github.com/gorilla/mux"
 	"github.com/tsuru/tsuru-autoscale/alarm"
 	"github.com/tsuru/tsuru-autoscale/api"
+	"github.com/tsuru/tsuru-autoscale/backup"
+	"github.com/tsuru/tsuru-autoscale/db"
+	"github.com/tsuru/tsuru-autoscale/db/memory"
+	"github.com/tsuru/tsuru-autoscale/db/postgres"
+	"github.com/tsuru/tsuru-autoscale/tlscert"
 	"github.com/tsuru/tsuru-autoscale/web"
 )
 
@@ -38,16 +51,246 @@ func router() http.Handler {
 	return n
 }
 
+// shutdownTimeout is how long the server waits for the requests in
+// progress when stopping, from AUTOSCALE_SHUTDOWN_TIMEOUT, in seconds.
+func shutdownTimeout() time.Duration {
+	if t := os.Getenv("AUTOSCALE_SHUTDOWN_TIMEOUT"); t != "" {
+		v, err := strconv.Atoi(t)
+		if err == nil {
+			return time.Duration(v) * time.Second
+		}
+		log.Print(err)
+	}
+	return 30 * time.Second
+}
+
+// serverTimeout returns the timeout of the server from the environment
+// variable, in seconds, or def.
+func serverTimeout(name string, def time.Duration) time.Duration {
+	if t := os.Getenv(name); t != "" {
+		v, err := strconv.Atoi(t)
+		if err == nil && v >= 0 {
+			return time.Duration(v) * time.Second
+		}
+		log.Printf("invalid %s %q", name, t)
+	}
+	return def
+}
+
+// newServer returns the api server, with the timeouts of reading the
+// requests, from AUTOSCALE_SERVER_READ_TIMEOUT, writing the responses, from
+// AUTOSCALE_SERVER_WRITE_TIMEOUT, long enough for the exports, and of the
+// idle connections, from AUTOSCALE_SERVER_IDLE_TIMEOUT, so slow clients do
+// not hold the connections. Zero disables a timeout.
+func newServer(handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%s", port()),
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       serverTimeout("AUTOSCALE_SERVER_READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:      serverTimeout("AUTOSCALE_SERVER_WRITE_TIMEOUT", 10*time.Minute),
+		IdleTimeout:       serverTimeout("AUTOSCALE_SERVER_IDLE_TIMEOUT", 2*time.Minute),
+	}
+}
+
+// tlsReloadInterval is how often the server certificate files are checked
+// for changes, from AUTOSCALE_SERVER_TLS_RELOAD_INTERVAL, in seconds.
+func tlsReloadInterval() time.Duration {
+	if i := os.Getenv("AUTOSCALE_SERVER_TLS_RELOAD_INTERVAL"); i != "" {
+		v, err := strconv.Atoi(i)
+		if err == nil && v > 0 {
+			return time.Duration(v) * time.Second
+		}
+		log.Printf("invalid AUTOSCALE_SERVER_TLS_RELOAD_INTERVAL %q", i)
+	}
+	return time.Minute
+}
+
+// serverTLS returns the TLS configuration of the server, when
+// AUTOSCALE_SERVER_TLS_CERT_FILE and AUTOSCALE_SERVER_TLS_KEY_FILE are set.
+// The certificate is reloaded on SIGHUP and when the files change, until
+// stop is closed.
+func serverTLS(stop <-chan struct{}) (*tls.Config, error) {
+	certFile := os.Getenv("AUTOSCALE_SERVER_TLS_CERT_FILE")
+	keyFile := os.Getenv("AUTOSCALE_SERVER_TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	reloader, err := tlscert.NewReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.Watch(tlsReloadInterval(), stop)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				signal.Stop(hup)
+				return
+			case <-hup:
+				log.Print("received SIGHUP, reloading the tls certificate")
+				if err := reloader.Reload(); err != nil {
+					log.Printf("tls certificate not reloaded: %s", err)
+				}
+			}
+		}
+	}()
+	return &tls.Config{GetCertificate: reloader.GetCertificate}, nil
+}
+
+// stopSignal returns a channel receiving the signals stopping the process.
+func stopSignal() <-chan os.Signal {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	return sig
+}
+
+// runServer serves the api until the process is stopped, then drains the
+// requests in progress, like alarm triggers executing actions, and stops
+// the auto scale.
 func runServer() {
-	http.Handle("/", router())
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port()), nil))
+	root := http.NewServeMux()
+	root.Handle("/", router())
+	root.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	stop := make(chan struct{})
+	defer close(stop)
+	tlsConfig, err := serverTLS(stop)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := newServer(root, tlsConfig)
+	errs := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			errs <- server.ListenAndServeTLS("", "")
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+	select {
+	case err := <-errs:
+		log.Fatal(err)
+	case sig := <-stopSignal():
+		log.Printf("received %s, shutting down", sig)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %s", err)
+	}
+	alarm.StopAutoScale()
+	db.Close()
 }
 
-func main() {
-	if os.Args[1] == "agent" {
+// runAgent checks the alarms until the process is stopped, then waits for
+// the check in progress, so the scale operations are not cut off.
+func runAgent() {
+	done := make(chan struct{})
+	go func() {
 		alarm.StartAutoScale()
-	} else {
+		close(done)
+	}()
+	select {
+	case <-done:
+	case sig := <-stopSignal():
+		log.Printf("received %s, waiting for the alarms check in progress", sig)
+		alarm.StopAutoScale()
+	}
+	db.Close()
+}
+
+// runExport writes the archive of the configuration to the standard
+// output, with the secrets when -secrets is set.
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	secrets := flags.Bool("secrets", false, "export the secrets of the data sources and actions")
+	flags.Parse(args)
+	defer db.Close()
+	archive, err := backup.Export(*secrets)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(archive); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runImport restores the archive of the file, or of the standard input,
+// and writes the report to the standard output.
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	conflict := flags.String("conflict", backup.ConflictSkip, "strategy for the existing configurations: skip, overwrite or fail")
+	flags.Parse(args)
+	defer db.Close()
+	in := os.Stdin
+	if flags.NArg() > 0 {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	var archive backup.Archive
+	if err := json.NewDecoder(in).Decode(&archive); err != nil {
+		log.Fatalf("invalid archive: %s", err)
+	}
+	report, err := backup.Import(&archive, *conflict)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// setStorage sets the storage backend from AUTOSCALE_STORAGE: mongodb, the
+// default, postgres, connecting to POSTGRES_URL, or memory, for the demos,
+// losing the data when the process stops. It returns whether the storage
+// is MongoDB.
+func setStorage() bool {
+	switch backend := os.Getenv("AUTOSCALE_STORAGE"); backend {
+	case "", "mongodb":
+		return true
+	case "postgres":
+		storage, err := postgres.New(os.Getenv("POSTGRES_URL"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		db.SetBackend(storage.Open)
+	case "memory":
+		db.SetBackend(memory.New().Open)
+	default:
+		log.Fatalf("invalid AUTOSCALE_STORAGE %q, must be mongodb, postgres or memory", backend)
+	}
+	return false
+}
+
+func main() {
+	if setStorage() {
+		if err := db.EnsureIndexes(); err != nil {
+			log.Printf("indexes not created: %s", err)
+		}
+	}
+	var command string
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+	switch command {
+	case "agent":
+		runAgent()
+	case "export":
+		runExport(os.Args[2:])
+	case "import":
+		runImport(os.Args[2:])
+	default:
 		runServer()
 	}
 }
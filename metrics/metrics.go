@@ -0,0 +1,254 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package metrics provides counters, gauges and histograms exposed in the
+// Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, fit to request
+// latencies.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metric is a family of samples written in the Prometheus text format.
+type Metric interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	mu       sync.Mutex
+	registry []Metric
+)
+
+// Register adds metrics to the ones written by Write.
+func Register(metrics ...Metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry = append(registry, metrics...)
+}
+
+// Write writes the registered metrics in the Prometheus text format.
+func Write(w io.Writer) error {
+	mu.Lock()
+	metrics := append([]Metric{}, registry...)
+	mu.Unlock()
+	buf := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buf)
+	}
+	return buf.Flush()
+}
+
+// vec holds the samples of a metric by their label values.
+type vec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	keys   []string
+	values map[string][]string
+}
+
+func newVec(name, help string, labels []string) vec {
+	return vec{name: name, help: help, labels: labels, values: map[string][]string{}}
+}
+
+// key returns the key of the label values, adding them when they are new.
+// It must be called with the lock held.
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", v.name, len(v.labels), len(values)))
+	}
+	k := strings.Join(values, "\xff")
+	if _, ok := v.values[k]; !ok {
+		v.values[k] = append([]string{}, values...)
+		v.keys = append(v.keys, k)
+		sort.Strings(v.keys)
+	}
+	return k
+}
+
+func (v *vec) header(w *bufio.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escape(v.help, false), v.name, kind)
+}
+
+// sample writes a sample of the metric with the label values of key and
+// the extra label pairs.
+func (v *vec) sample(w *bufio.Writer, suffix, key string, value float64, extra ...string) {
+	w.WriteString(v.name + suffix)
+	pairs := make([]string, 0, len(v.labels)+len(extra)/2)
+	for i, l := range v.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l, escape(v.values[key][i], true)))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], extra[i+1]))
+	}
+	if len(pairs) > 0 {
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec
+	counts map[string]float64
+}
+
+// NewCounterVec returns a counter with the label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{vec: newVec(name, help, labels), counts: map[string]float64{}}
+}
+
+// Inc increments the counter with the label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds delta, that must not be negative, to the counter with the label
+// values.
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metrics: %s can not decrease", c.name))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[c.key(values)] += delta
+}
+
+// Value returns the counter with the label values.
+func (c *CounterVec) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[strings.Join(values, "\xff")]
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w, "counter")
+	for _, k := range c.keys {
+		c.sample(w, "", k, c.counts[k])
+	}
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	vec
+	gauges map[string]float64
+}
+
+// NewGaugeVec returns a gauge with the label names.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{vec: newVec(name, help, labels), gauges: map[string]float64{}}
+}
+
+// Set sets the gauge with the label values.
+func (g *GaugeVec) Set(value float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.gauges[g.key(values)] = value
+}
+
+// Reset removes the gauges of all the label values.
+func (g *GaugeVec) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.keys = nil
+	g.values = map[string][]string{}
+	g.gauges = map[string]float64{}
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w, "gauge")
+	for _, k := range g.keys {
+		g.sample(w, "", k, g.gauges[k])
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec
+	buckets    []float64
+	histograms map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec returns a histogram with the buckets upper bounds, in
+// increasing order, and the label names.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{vec: newVec(name, help, labels), buckets: buckets, histograms: map[string]*histogram{}}
+}
+
+// Observe adds a value to the histogram with the label values.
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := h.key(values)
+	hist := h.histograms[k]
+	if hist == nil {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.histograms[k] = hist
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += value
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w, "histogram")
+	for _, k := range h.keys {
+		hist := h.histograms[k]
+		for i, upper := range h.buckets {
+			h.sample(w, "_bucket", k, float64(hist.counts[i]), "le", formatFloat(upper))
+		}
+		h.sample(w, "_bucket", k, float64(hist.count), "le", "+Inf")
+		h.sample(w, "_sum", k, hist.sum)
+		h.sample(w, "_count", k, float64(hist.count))
+	}
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// escape escapes the backslashes and new lines of help texts, and the
+// double quotes of label values.
+func escape(s string, quote bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quote {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func write(m Metric) string {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	m.write(w)
+	w.Flush()
+	return buf.String()
+}
+
+func (s *S) TestCounterVec(c *check.C) {
+	counter := NewCounterVec("requests_total", "Requests.", "method", "code")
+	counter.Inc("GET", "200")
+	counter.Inc("GET", "200")
+	counter.Add(3, "POST", "500")
+	c.Assert(counter.Value("GET", "200"), check.Equals, float64(2))
+	c.Assert(counter.Value("GET", "404"), check.Equals, float64(0))
+	expected := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{method="GET",code="200"} 2
+requests_total{method="POST",code="500"} 3
+`
+	c.Assert(write(counter), check.Equals, expected)
+	c.Assert(func() { counter.Inc("GET") }, check.PanicMatches, "metrics: requests_total has 2 labels, got 1 values")
+}
+
+func (s *S) TestGaugeVec(c *check.C) {
+	gauge := NewGaugeVec("runner_last_cycle", "Last cycle.", "host")
+	gauge.Set(10, `a"b`)
+	gauge.Set(1.5, "c")
+	expected := `# HELP runner_last_cycle Last cycle.
+# TYPE runner_last_cycle gauge
+runner_last_cycle{host="a\"b"} 10
+runner_last_cycle{host="c"} 1.5
+`
+	c.Assert(write(gauge), check.Equals, expected)
+	gauge.Reset()
+	gauge.Set(1, "d")
+	c.Assert(write(gauge), check.Equals, "# HELP runner_last_cycle Last cycle.\n# TYPE runner_last_cycle gauge\nrunner_last_cycle{host=\"d\"} 1\n")
+}
+
+func (s *S) TestGaugeWithoutLabels(c *check.C) {
+	gauge := NewGaugeVec("alarms", "Alarms.")
+	gauge.Set(3)
+	c.Assert(write(gauge), check.Equals, "# HELP alarms Alarms.\n# TYPE alarms gauge\nalarms 3\n")
+}
+
+func (s *S) TestHistogramVec(c *check.C) {
+	histogram := NewHistogramVec("duration_seconds", "Duration.", []float64{0.1, 1}, "route")
+	histogram.Observe(0.05, "/a")
+	histogram.Observe(0.5, "/a")
+	histogram.Observe(2, "/a")
+	expected := `# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/a",le="0.1"} 1
+duration_seconds_bucket{route="/a",le="1"} 2
+duration_seconds_bucket{route="/a",le="+Inf"} 3
+duration_seconds_sum{route="/a"} 2.55
+duration_seconds_count{route="/a"} 3
+`
+	c.Assert(write(histogram), check.Equals, expected)
+}
+
+func (s *S) TestWrite(c *check.C) {
+	counter := NewCounterVec("test_write_total", "Test.")
+	counter.Inc()
+	Register(counter)
+	var buf bytes.Buffer
+	err := Write(&buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, "(?s).*test_write_total 1\n.*")
+}
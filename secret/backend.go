@@ -0,0 +1,123 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Backend resolves the secrets referenced by the {secret.NAME}
+// placeholders. Lookup returns false when the backend has no secret with
+// the name.
+type Backend interface {
+	Lookup(name string) (string, bool, error)
+}
+
+// EnvBackend resolves secrets from the AUTOSCALE_SECRET_<NAME> environment
+// variables.
+type EnvBackend struct{}
+
+// Lookup returns the value of the AUTOSCALE_SECRET_<NAME> environment
+// variable.
+func (EnvBackend) Lookup(name string) (string, bool, error) {
+	value, ok := os.LookupEnv("AUTOSCALE_SECRET_" + name)
+	return value, ok, nil
+}
+
+// DirBackend resolves secrets from the files of a directory, one file per
+// secret, like the ones written by the vault agent or mounted from
+// kubernetes secrets. Trailing new lines are removed from the values.
+type DirBackend struct {
+	Dir string
+}
+
+// Lookup returns the content of the file with the secret name.
+func (b DirBackend) Lookup(name string) (string, bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(b.Dir, name))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+var backends struct {
+	sync.RWMutex
+	list []Backend
+}
+
+// RegisterBackend registers a backend used to resolve secrets. Registered
+// backends are queried in order, before the default ones.
+func RegisterBackend(b Backend) {
+	backends.Lock()
+	defer backends.Unlock()
+	backends.list = append(backends.list, b)
+}
+
+// defaultBackends returns the environment backend, followed by the
+// directory backend when AUTOSCALE_SECRETS_DIR is set.
+func defaultBackends() []Backend {
+	list := []Backend{EnvBackend{}}
+	if dir := os.Getenv("AUTOSCALE_SECRETS_DIR"); dir != "" {
+		list = append(list, DirBackend{Dir: dir})
+	}
+	return list
+}
+
+// secretName matches the names that can be used in the placeholders.
+var secretName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Lookup resolves the secret with the given name using the registered
+// backends, then the default ones.
+func Lookup(name string) (string, error) {
+	if !secretName.MatchString(name) {
+		return "", fmt.Errorf("secret: invalid name %q", name)
+	}
+	backends.RLock()
+	list := append([]Backend{}, backends.list...)
+	backends.RUnlock()
+	for _, b := range append(list, defaultBackends()...) {
+		value, ok, err := b.Lookup(name)
+		if err != nil {
+			return "", fmt.Errorf("secret %q: %s", name, err)
+		}
+		if ok {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("secret %q not found", name)
+}
+
+var placeholder = regexp.MustCompile(`\{secret\.([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Expand replaces the {secret.NAME} placeholders in text by the values of
+// the secrets, returning the text and the values used.
+func Expand(text string) (string, []string, error) {
+	var values []string
+	var err error
+	text = placeholder.ReplaceAllStringFunc(text, func(p string) string {
+		if err != nil {
+			return p
+		}
+		var value string
+		value, err = Lookup(placeholder.FindStringSubmatch(p)[1])
+		if value != "" {
+			values = append(values, value)
+		}
+		return value
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return text, values, nil
+}
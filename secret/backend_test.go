@@ -0,0 +1,68 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+type mapBackend map[string]string
+
+func (b mapBackend) Lookup(name string) (string, bool, error) {
+	value, ok := b[name]
+	return value, ok, nil
+}
+
+func (s *S) TestExpand(c *check.C) {
+	os.Setenv("AUTOSCALE_SECRET_TOKEN", "abc")
+	defer os.Unsetenv("AUTOSCALE_SECRET_TOKEN")
+	text, values, err := Expand("http://host/{secret.TOKEN}?t={secret.TOKEN}&env={env.HOME}")
+	c.Assert(err, check.IsNil)
+	c.Assert(text, check.Equals, "http://host/abc?t=abc&env={env.HOME}")
+	c.Assert(values, check.DeepEquals, []string{"abc", "abc"})
+	text, values, err = Expand("no secrets")
+	c.Assert(err, check.IsNil)
+	c.Assert(text, check.Equals, "no secrets")
+	c.Assert(values, check.HasLen, 0)
+}
+
+func (s *S) TestExpandNotFound(c *check.C) {
+	_, _, err := Expand("{secret.MISSING}")
+	c.Assert(err, check.ErrorMatches, `secret "MISSING" not found`)
+}
+
+func (s *S) TestLookupDir(c *check.C) {
+	dir, err := ioutil.TempDir("", "secrets")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "TOKEN"), []byte("fromfile\n"), 0600)
+	c.Assert(err, check.IsNil)
+	os.Setenv("AUTOSCALE_SECRETS_DIR", dir)
+	defer os.Unsetenv("AUTOSCALE_SECRETS_DIR")
+	value, err := Lookup("TOKEN")
+	c.Assert(err, check.IsNil)
+	c.Assert(value, check.Equals, "fromfile")
+	os.Setenv("AUTOSCALE_SECRET_TOKEN", "fromenv")
+	defer os.Unsetenv("AUTOSCALE_SECRET_TOKEN")
+	value, err = Lookup("TOKEN")
+	c.Assert(err, check.IsNil)
+	c.Assert(value, check.Equals, "fromenv")
+	_, err = Lookup("../TOKEN")
+	c.Assert(err, check.ErrorMatches, `secret: invalid name "../TOKEN"`)
+}
+
+func (s *S) TestRegisterBackend(c *check.C) {
+	defer func() { backends.list = nil }()
+	os.Setenv("AUTOSCALE_SECRET_TOKEN", "fromenv")
+	defer os.Unsetenv("AUTOSCALE_SECRET_TOKEN")
+	RegisterBackend(mapBackend{"TOKEN": "fromvault"})
+	value, err := Lookup("TOKEN")
+	c.Assert(err, check.IsNil)
+	c.Assert(value, check.Equals, "fromvault")
+}
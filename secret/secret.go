@@ -0,0 +1,164 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package secret provides envelope encryption for the secrets stored by
+// tsuru-autoscale.
+//
+// Each value is encrypted with a random data key, and the data key is
+// encrypted with the master key, read from the AUTOSCALE_ENCRYPTION_KEY
+// environment variable as a base64 encoded 16, 24 or 32 bytes AES key. When
+// the master key is not configured, values are stored in plaintext.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const prefix = "enc:v1:"
+
+// Redacted is the value shown in place of secrets.
+const Redacted = "*****"
+
+// ErrKeyNotConfigured is returned when decrypting a value without the
+// master key.
+var ErrKeyNotConfigured = errors.New("secret: AUTOSCALE_ENCRYPTION_KEY is not configured")
+
+func masterKey() ([]byte, error) {
+	k := os.Getenv("AUTOSCALE_ENCRYPTION_KEY")
+	if k == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(k)
+	if err != nil {
+		return nil, fmt.Errorf("secret: invalid AUTOSCALE_ENCRYPTION_KEY: %s", err)
+	}
+	return key, nil
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("secret: invalid encrypted value")
+	}
+	nonce := ciphertext[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, ciphertext[gcm.NonceSize():], nil)
+}
+
+// IsEncrypted returns whether the value was encrypted by Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt encrypts the value. Empty and already encrypted values, and all
+// values when the master key is not configured, are returned unchanged.
+func Encrypt(value string) (string, error) {
+	if value == "" || IsEncrypted(value) {
+		return value, nil
+	}
+	key, err := masterKey()
+	if err != nil || key == nil {
+		return value, err
+	}
+	dataKey := make([]byte, 32)
+	if _, err = io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	encryptedKey, err := seal(key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	encryptedValue, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return prefix + base64.StdEncoding.EncodeToString(encryptedKey) + ":" + base64.StdEncoding.EncodeToString(encryptedValue), nil
+}
+
+// Decrypt decrypts a value encrypted by Encrypt. Values that are not
+// encrypted are returned unchanged.
+func Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	key, err := masterKey()
+	if err != nil {
+		return "", err
+	}
+	if key == nil {
+		return "", ErrKeyNotConfigured
+	}
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 2 {
+		return "", errors.New("secret: invalid encrypted value")
+	}
+	encryptedKey, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", err
+	}
+	encryptedValue, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(key, encryptedKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, encryptedValue)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Redact returns Redacted for non empty values.
+func Redact(value string) string {
+	if value == "" {
+		return value
+	}
+	return Redacted
+}
+
+var secretHeaderWords = []string{"authorization", "token", "secret", "password", "key"}
+
+// IsSecretHeader returns whether the value of the HTTP header is a secret.
+func IsSecretHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range secretHeaderWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpTest(c *check.C) {
+	os.Setenv("AUTOSCALE_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	os.Unsetenv("AUTOSCALE_ENCRYPTION_KEY")
+}
+
+func (s *S) TestEncryptDecrypt(c *check.C) {
+	encrypted, err := Encrypt("bearer abc")
+	c.Assert(err, check.IsNil)
+	c.Assert(IsEncrypted(encrypted), check.Equals, true)
+	c.Assert(encrypted, check.Not(check.Matches), ".*bearer abc.*")
+	other, err := Encrypt("bearer abc")
+	c.Assert(err, check.IsNil)
+	c.Assert(other, check.Not(check.Equals), encrypted)
+	again, err := Encrypt(encrypted)
+	c.Assert(err, check.IsNil)
+	c.Assert(again, check.Equals, encrypted)
+	decrypted, err := Decrypt(encrypted)
+	c.Assert(err, check.IsNil)
+	c.Assert(decrypted, check.Equals, "bearer abc")
+}
+
+func (s *S) TestEncryptWithoutKey(c *check.C) {
+	os.Unsetenv("AUTOSCALE_ENCRYPTION_KEY")
+	encrypted, err := Encrypt("bearer abc")
+	c.Assert(err, check.IsNil)
+	c.Assert(encrypted, check.Equals, "bearer abc")
+	decrypted, err := Decrypt(encrypted)
+	c.Assert(err, check.IsNil)
+	c.Assert(decrypted, check.Equals, "bearer abc")
+}
+
+func (s *S) TestDecryptWithoutKey(c *check.C) {
+	encrypted, err := Encrypt("bearer abc")
+	c.Assert(err, check.IsNil)
+	os.Unsetenv("AUTOSCALE_ENCRYPTION_KEY")
+	_, err = Decrypt(encrypted)
+	c.Assert(err, check.Equals, ErrKeyNotConfigured)
+}
+
+func (s *S) TestDecryptWithWrongKey(c *check.C) {
+	encrypted, err := Encrypt("bearer abc")
+	c.Assert(err, check.IsNil)
+	os.Setenv("AUTOSCALE_ENCRYPTION_KEY", "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	_, err = Decrypt(encrypted)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestEncryptInvalidKey(c *check.C) {
+	os.Setenv("AUTOSCALE_ENCRYPTION_KEY", "invalid")
+	_, err := Encrypt("bearer abc")
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestRedact(c *check.C) {
+	c.Assert(Redact(""), check.Equals, "")
+	c.Assert(Redact("abc"), check.Equals, Redacted)
+}
+
+func (s *S) TestIsSecretHeader(c *check.C) {
+	c.Assert(IsSecretHeader("Authorization"), check.Equals, true)
+	c.Assert(IsSecretHeader("X-Api-Key"), check.Equals, true)
+	c.Assert(IsSecretHeader("X-Auth-Token"), check.Equals, true)
+	c.Assert(IsSecretHeader("Content-Type"), check.Equals, false)
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tlscert loads the TLS certificate of the api server, reloading it
+// when the files change, so renewed certificates are used without
+// restarting the server.
+package tlscert
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/log"
+)
+
+func logger() *log.Logger {
+	return log.Log()
+}
+
+// Reloader holds the certificate loaded from the PEM encoded certificate
+// and key files.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader returns a reloader with the certificate loaded from the files.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate from the files. When they are invalid, the
+// previous certificate is kept.
+func (r *Reloader) Reload() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate returns the certificate, to be used as the GetCertificate
+// of the tls.Config of the server.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch checks the files every interval, reloading the certificate when
+// they were modified, until stop is closed.
+func (r *Reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := r.reloadIfModified(); err != nil {
+				logger().Error(err)
+			}
+		}
+	}
+}
+
+// reloadIfModified reloads the certificate when the files were modified
+// after it was loaded.
+func (r *Reloader) reloadIfModified() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	modified := modTime.After(r.modTime)
+	r.mu.RUnlock()
+	if !modified {
+		return nil
+	}
+	logger().Printf("reloading the tls certificate %s", r.certFile)
+	return r.Reload()
+}
+
+// lastModified returns the latest modification time of the files.
+func (r *Reloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last, nil
+}
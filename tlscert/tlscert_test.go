@@ -0,0 +1,139 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tlscert
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	dir      string
+	certFile string
+	keyFile  string
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpTest(c *check.C) {
+	s.dir = c.MkDir()
+	s.certFile = filepath.Join(s.dir, "cert.pem")
+	s.keyFile = filepath.Join(s.dir, "key.pem")
+}
+
+// writePair writes a self signed certificate for name and its key.
+func (s *S) writePair(c *check.C, name string) {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	c.Assert(err, check.IsNil)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(s.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(s.keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}), 0600)
+	c.Assert(err, check.IsNil)
+}
+
+// touch sets the modification time of the files.
+func (s *S) touch(c *check.C, t time.Time) {
+	for _, name := range []string{s.certFile, s.keyFile} {
+		c.Assert(os.Chtimes(name, t, t), check.IsNil)
+	}
+}
+
+func commonName(c *check.C, r *Reloader) string {
+	cert, err := r.GetCertificate(nil)
+	c.Assert(err, check.IsNil)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	c.Assert(err, check.IsNil)
+	return parsed.Subject.CommonName
+}
+
+func (s *S) TestNewReloader(c *check.C) {
+	s.writePair(c, "first")
+	r, err := NewReloader(s.certFile, s.keyFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(commonName(c, r), check.Equals, "first")
+}
+
+func (s *S) TestNewReloaderMissingFiles(c *check.C) {
+	_, err := NewReloader(s.certFile, s.keyFile)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestReload(c *check.C) {
+	s.writePair(c, "first")
+	r, err := NewReloader(s.certFile, s.keyFile)
+	c.Assert(err, check.IsNil)
+	s.writePair(c, "second")
+	c.Assert(r.Reload(), check.IsNil)
+	c.Assert(commonName(c, r), check.Equals, "second")
+}
+
+func (s *S) TestReloadInvalidKeepsCertificate(c *check.C) {
+	s.writePair(c, "first")
+	r, err := NewReloader(s.certFile, s.keyFile)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(s.keyFile, []byte("invalid"), 0600)
+	c.Assert(err, check.IsNil)
+	c.Assert(r.Reload(), check.NotNil)
+	c.Assert(commonName(c, r), check.Equals, "first")
+}
+
+func (s *S) TestReloadIfModified(c *check.C) {
+	loaded := time.Now().Add(-time.Minute)
+	s.writePair(c, "first")
+	s.touch(c, loaded)
+	r, err := NewReloader(s.certFile, s.keyFile)
+	c.Assert(err, check.IsNil)
+	s.writePair(c, "second")
+	s.touch(c, loaded)
+	c.Assert(r.reloadIfModified(), check.IsNil)
+	c.Assert(commonName(c, r), check.Equals, "first")
+	s.touch(c, time.Now())
+	c.Assert(r.reloadIfModified(), check.IsNil)
+	c.Assert(commonName(c, r), check.Equals, "second")
+}
+
+func (s *S) TestWatch(c *check.C) {
+	s.writePair(c, "first")
+	s.touch(c, time.Now().Add(-time.Minute))
+	r, err := NewReloader(s.certFile, s.keyFile)
+	c.Assert(err, check.IsNil)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		r.Watch(10*time.Millisecond, stop)
+		close(done)
+	}()
+	s.writePair(c, "second")
+	s.touch(c, time.Now())
+	for i := 0; i < 100 && commonName(c, r) != "second"; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(commonName(c, r), check.Equals, "second")
+	close(stop)
+	<-done
+}
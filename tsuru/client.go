@@ -11,8 +11,31 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"time"
 )
 
+// HealthcheckTimeout is the maximum time the tsuru api healthcheck can take.
+const HealthcheckTimeout = 5 * time.Second
+
+// Healthcheck checks the tsuru api, configured by the TSURU_HOST environment
+// variable, is reachable and healthy.
+func Healthcheck() error {
+	tsuruHost := os.Getenv("TSURU_HOST")
+	if tsuruHost == "" {
+		return errors.New("TSURU_HOST is not set")
+	}
+	client := http.Client{Timeout: HealthcheckTimeout}
+	resp, err := client.Get(fmt.Sprintf("%s/healthcheck", tsuruHost))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tsuru healthcheck returned status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // FindServiceInstance returns an auto scale instance
 func FindServiceInstance(token string) ([]Instance, error) {
 	tsuruHost := os.Getenv("TSURU_HOST")
@@ -26,3 +26,24 @@ func (s *S) TestFindServiceInstance(c *check.C) {
 	c.Assert(instances, check.HasLen, 1)
 	c.Assert(instances[0].Name, check.Equals, "instance")
 }
+
+func (s *S) TestHealthcheck(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.URL.Path, check.Equals, "/healthcheck")
+		w.Write([]byte("WORKING"))
+	}))
+	defer ts.Close()
+	os.Setenv("TSURU_HOST", ts.URL)
+	c.Assert(Healthcheck(), check.IsNil)
+}
+
+func (s *S) TestHealthcheckFailure(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	os.Setenv("TSURU_HOST", ts.URL)
+	c.Assert(Healthcheck(), check.ErrorMatches, "tsuru healthcheck returned status code 500")
+	os.Setenv("TSURU_HOST", "")
+	c.Assert(Healthcheck(), check.ErrorMatches, "TSURU_HOST is not set")
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+)
+
+// Plan is a plan of the service, advertised to tsuru, with the JSON schema
+// of the parameters accepted when creating its instances, in the format of
+// the Open Service Broker API.
+type Plan struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Schemas     PlanSchemas `json:"schemas"`
+}
+
+// PlanSchemas are the schemas of a plan.
+type PlanSchemas struct {
+	ServiceInstance struct {
+		Create struct {
+			Parameters ParametersSchema `json:"parameters"`
+		} `json:"create"`
+	} `json:"service_instance"`
+}
+
+// ParametersSchema is the JSON schema of the parameters of the instances.
+type ParametersSchema struct {
+	Schema               string               `json:"$schema"`
+	Type                 string               `json:"type"`
+	Properties           map[string]Parameter `json:"properties"`
+	AdditionalProperties bool                 `json:"additionalProperties"`
+}
+
+// Parameter is the schema of a parameter, an integer or a string matching
+// Pattern. Parameters with a default are set when they are not sent.
+type Parameter struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Default     string `json:"default,omitempty"`
+	Minimum     *int   `json:"minimum,omitempty"`
+	Pattern     string `json:"pattern,omitempty"`
+}
+
+var zero = 0
+
+// scaleParameters are the parameters of the plans, used as the defaults of
+// the envs of the alarms of the instances, by the scale actions.
+func scaleParameters(step, minUnits string) map[string]Parameter {
+	return map[string]Parameter{
+		"step": {
+			Type:        "string",
+			Description: "Units added or removed by each scale, or a percentage of the current units, like 25%.",
+			Default:     step,
+			Pattern:     `^[0-9]+%?$`,
+		},
+		"minUnits": {
+			Type:        "integer",
+			Description: "Minimum units of the app process after scaling down.",
+			Default:     minUnits,
+			Minimum:     &zero,
+		},
+		"maxUnits": {
+			Type:        "integer",
+			Description: "Maximum units of the app process after scaling up, unlimited by default.",
+			Minimum:     &zero,
+		},
+	}
+}
+
+func newPlan(name, description string, parameters map[string]Parameter) Plan {
+	p := Plan{Name: name, Description: description}
+	p.Schemas.ServiceInstance.Create.Parameters = ParametersSchema{
+		Schema:     "http://json-schema.org/draft-04/schema#",
+		Type:       "object",
+		Properties: parameters,
+	}
+	return p
+}
+
+var plans = map[string]Plan{
+	"basic":      newPlan("basic", "Scales one unit at a time, keeping at least one unit.", scaleParameters("1", "1")),
+	"aggressive": newPlan("aggressive", "Scales a quarter of the units at a time, keeping at least two units.", scaleParameters("25%", "2")),
+}
+
+// Plans returns the plans of the service, sorted by name.
+func Plans() []Plan {
+	names := make([]string, 0, len(plans))
+	for name := range plans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]Plan, len(names))
+	for i, name := range names {
+		list[i] = plans[name]
+	}
+	return list
+}
+
+// SetPlan sets the plan of the instance and its parameters, validated by
+// the schema of the plan, with the defaults of the plan for the ones not
+// sent. Instances without a plan have no parameters.
+func (i *Instance) SetPlan(name string, params map[string]string) error {
+	if name == "" {
+		if len(params) > 0 {
+			return fmt.Errorf("parameters require a plan")
+		}
+		return nil
+	}
+	plan, ok := plans[name]
+	if !ok {
+		return &db.NotFoundError{Resource: "plan", Name: name}
+	}
+	schema := plan.Schemas.ServiceInstance.Create.Parameters
+	values := map[string]string{}
+	for key, value := range params {
+		p, ok := schema.Properties[key]
+		if !ok {
+			return fmt.Errorf("invalid parameter %q for plan %q", key, name)
+		}
+		if err := p.validate(value); err != nil {
+			return fmt.Errorf("invalid parameter %q: %s", key, err)
+		}
+		values[key] = value
+	}
+	for key, p := range schema.Properties {
+		if _, ok := values[key]; !ok && p.Default != "" {
+			values[key] = p.Default
+		}
+	}
+	i.Plan = name
+	i.Params = values
+	return nil
+}
+
+func (p *Parameter) validate(value string) error {
+	if p.Type == "integer" {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		if p.Minimum != nil && v < *p.Minimum {
+			return fmt.Errorf("%d is less than %d", v, *p.Minimum)
+		}
+	}
+	if p.Pattern != "" && !regexp.MustCompile(p.Pattern).MatchString(value) {
+		return fmt.Errorf("%q does not match %s", value, p.Pattern)
+	}
+	return nil
+}
+
+// Defaults returns the envs with the parameters of the instance for the
+// envs not set, or empty.
+func (i *Instance) Defaults(envs map[string]string) map[string]string {
+	if len(i.Params) == 0 {
+		return envs
+	}
+	merged := make(map[string]string, len(envs)+len(i.Params))
+	for key, value := range i.Params {
+		merged[key] = value
+	}
+	for key, value := range envs {
+		if _, ok := merged[key]; !ok || value != "" {
+			merged[key] = value
+		}
+	}
+	return merged
+}
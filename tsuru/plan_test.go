@@ -0,0 +1,62 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import "gopkg.in/check.v1"
+
+func (s *S) TestPlans(c *check.C) {
+	list := Plans()
+	c.Assert(list, check.HasLen, 2)
+	c.Assert(list[0].Name, check.Equals, "aggressive")
+	c.Assert(list[1].Name, check.Equals, "basic")
+	schema := list[1].Schemas.ServiceInstance.Create.Parameters
+	c.Assert(schema.Type, check.Equals, "object")
+	c.Assert(schema.Properties["step"].Default, check.Equals, "1")
+}
+
+func (s *S) TestSetPlan(c *check.C) {
+	var i Instance
+	err := i.SetPlan("aggressive", map[string]string{"maxUnits": "10", "step": "2"})
+	c.Assert(err, check.IsNil)
+	c.Assert(i.Plan, check.Equals, "aggressive")
+	c.Assert(i.Params, check.DeepEquals, map[string]string{"maxUnits": "10", "step": "2", "minUnits": "2"})
+}
+
+func (s *S) TestSetPlanErrors(c *check.C) {
+	tests := []struct {
+		plan   string
+		params map[string]string
+		err    string
+	}{
+		{"", map[string]string{"step": "1"}, "parameters require a plan"},
+		{"premium", nil, `plan "premium" not found`},
+		{"basic", map[string]string{"cooldown": "1"}, `invalid parameter "cooldown" for plan "basic"`},
+		{"basic", map[string]string{"minUnits": "one"}, `invalid parameter "minUnits": "one" is not an integer`},
+		{"basic", map[string]string{"maxUnits": "-1"}, `invalid parameter "maxUnits": -1 is less than 0`},
+		{"basic", map[string]string{"step": "1u"}, `invalid parameter "step": "1u" does not match .*`},
+	}
+	for _, tt := range tests {
+		var i Instance
+		err := i.SetPlan(tt.plan, tt.params)
+		c.Check(err, check.ErrorMatches, tt.err)
+	}
+	var i Instance
+	c.Assert(i.SetPlan("", nil), check.IsNil)
+	c.Assert(i.Params, check.IsNil)
+}
+
+func (s *S) TestInstanceDefaults(c *check.C) {
+	i := Instance{Params: map[string]string{"step": "2", "minUnits": "1"}}
+	envs := i.Defaults(map[string]string{"step": "", "minUnits": "3", "process": "web", "empty": ""})
+	c.Assert(envs, check.DeepEquals, map[string]string{"step": "2", "minUnits": "3", "process": "web", "empty": ""})
+	envs = map[string]string{"step": "1"}
+	c.Assert((&Instance{}).Defaults(envs), check.DeepEquals, envs)
+}
+
+func (s *S) TestInstanceEnvsWithParams(c *check.C) {
+	i := Instance{Name: "myinstance", Params: map[string]string{"step": "2", "instance": "other"}}
+	expected := map[string]string{"instance": "myinstance", "team": "", "pool": "", "step": "2"}
+	c.Assert(i.Envs(), check.DeepEquals, expected)
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Resources and scopes of the quotas.
+const (
+	QuotaAlarms  = "alarms"
+	QuotaWizards = "wizards"
+
+	ScopeInstance = "instance"
+	ScopeTeam     = "team"
+)
+
+// quotaLimit is a quota, configured by an environment variable, zero or
+// unset meaning unlimited.
+type quotaLimit struct {
+	resource string
+	scope    string
+	env      string
+}
+
+// quotaLimits are the quotas of the resources of the instances.
+var quotaLimits = []quotaLimit{
+	{QuotaAlarms, ScopeInstance, "AUTOSCALE_QUOTA_ALARMS_PER_INSTANCE"},
+	{QuotaAlarms, ScopeTeam, "AUTOSCALE_QUOTA_ALARMS_PER_TEAM"},
+	{QuotaWizards, ScopeTeam, "AUTOSCALE_QUOTA_WIZARDS_PER_TEAM"},
+}
+
+func (q *quotaLimit) limit() int {
+	if l := os.Getenv(q.env); l != "" {
+		v, err := strconv.Atoi(l)
+		if err == nil && v >= 0 {
+			return v
+		}
+		logger().Printf("invalid %s %q", q.env, l)
+	}
+	return 0
+}
+
+// Quota is the usage of a resource by an instance or a team, and its
+// limit, zero meaning unlimited.
+type Quota struct {
+	Resource string `json:"resource"`
+	Scope    string `json:"scope"`
+	Name     string `json:"name"`
+	Limit    int    `json:"limit"`
+	Usage    int    `json:"usage"`
+}
+
+// QuotaExceededError is returned when creating resources beyond a quota.
+type QuotaExceededError struct {
+	Quota
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s %q exceeded the quota of %d %s", e.Scope, e.Name, e.Limit, e.Resource)
+}
+
+// quotaUsage counts the resources of the instance, or of the instances of
+// its team, with the names of the instances of the team.
+func quotaUsage(conn db.Storage, resource, scope, instance string, team []string) (int, error) {
+	var names interface{} = bson.M{"$in": team}
+	if scope == ScopeInstance {
+		names = instance
+	}
+	if resource == QuotaWizards {
+		return conn.Wizards().Find(bson.M{"name": names}).Count()
+	}
+	return conn.Alarms().Find(bson.M{"instance": names}).Count()
+}
+
+// quotas returns the quotas of the instance, with their usage. Only the
+// configured ones are returned, unless all is true.
+func quotas(instance string, all bool) ([]Quota, error) {
+	var result []Quota
+	store, err := db.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	var i Instance
+	err = store.Instances().Find(bson.M{"name": instance}).One(&i)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	var team []string
+	if i.Team != "" {
+		var instances []Instance
+		if err = store.Instances().Find(bson.M{"team": i.Team}).Select(bson.M{"name": 1}).All(&instances); err != nil {
+			return nil, err
+		}
+		for _, other := range instances {
+			team = append(team, other.Name)
+		}
+	}
+	for _, q := range quotaLimits {
+		limit := q.limit()
+		if limit == 0 && !all {
+			continue
+		}
+		quota := Quota{Resource: q.resource, Scope: q.scope, Name: instance, Limit: limit}
+		if q.scope == ScopeTeam {
+			if i.Team == "" {
+				continue
+			}
+			quota.Name = i.Team
+		}
+		quota.Usage, err = quotaUsage(store, q.resource, q.scope, instance, team)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, quota)
+	}
+	return result, nil
+}
+
+// Quotas returns the usage of the resources of the instance and of its
+// team, with their quotas.
+func Quotas(instance string) ([]Quota, error) {
+	q, err := quotas(instance, true)
+	if err != nil {
+		return nil, err
+	}
+	if q == nil {
+		q = []Quota{}
+	}
+	return q, nil
+}
+
+// CheckQuota returns a QuotaExceededError when creating the alarms and the
+// auto scales for the instance exceeds the quotas of the instance or of
+// its team. Resources without instance are not limited.
+func CheckQuota(instance string, alarms, wizards int) error {
+	if instance == "" {
+		return nil
+	}
+	configured := false
+	for _, q := range quotaLimits {
+		configured = configured || q.limit() > 0
+	}
+	if !configured {
+		return nil
+	}
+	current, err := quotas(instance, false)
+	if err != nil {
+		return err
+	}
+	added := map[string]int{QuotaAlarms: alarms, QuotaWizards: wizards}
+	for _, q := range current {
+		if added[q.Resource] > 0 && q.Usage+added[q.Resource] > q.Limit {
+			return &QuotaExceededError{Quota: q}
+		}
+	}
+	return nil
+}
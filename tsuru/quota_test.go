@@ -0,0 +1,49 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"os"
+
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestCheckQuotaUnlimited(c *check.C) {
+	c.Assert(CheckQuota("myinstance", 100, 100), check.IsNil)
+	os.Setenv("AUTOSCALE_QUOTA_ALARMS_PER_INSTANCE", "1")
+	defer os.Unsetenv("AUTOSCALE_QUOTA_ALARMS_PER_INSTANCE")
+	c.Assert(CheckQuota("", 100, 100), check.IsNil)
+}
+
+func (s *S) TestQuotas(c *check.C) {
+	os.Setenv("AUTOSCALE_QUOTA_ALARMS_PER_INSTANCE", "3")
+	defer os.Unsetenv("AUTOSCALE_QUOTA_ALARMS_PER_INSTANCE")
+	os.Setenv("AUTOSCALE_QUOTA_ALARMS_PER_TEAM", "4")
+	defer os.Unsetenv("AUTOSCALE_QUOTA_ALARMS_PER_TEAM")
+	for _, name := range []string{"first", "second"} {
+		err := NewInstance(&Instance{Name: name, Team: "myteam"})
+		c.Assert(err, check.IsNil)
+	}
+	for _, a := range []bson.M{{"name": "a", "instance": "first"}, {"name": "b", "instance": "first"}, {"name": "c", "instance": "second"}} {
+		err := s.conn.Alarms().Insert(a)
+		c.Assert(err, check.IsNil)
+	}
+	err := s.conn.Wizards().Insert(bson.M{"name": "first"})
+	c.Assert(err, check.IsNil)
+	quotas, err := Quotas("first")
+	c.Assert(err, check.IsNil)
+	c.Assert(quotas, check.DeepEquals, []Quota{
+		{Resource: QuotaAlarms, Scope: ScopeInstance, Name: "first", Limit: 3, Usage: 2},
+		{Resource: QuotaAlarms, Scope: ScopeTeam, Name: "myteam", Limit: 4, Usage: 3},
+		{Resource: QuotaWizards, Scope: ScopeTeam, Name: "myteam", Limit: 0, Usage: 1},
+	})
+	c.Assert(CheckQuota("first", 1, 0), check.IsNil)
+	err = CheckQuota("first", 2, 1)
+	c.Assert(err, check.FitsTypeOf, &QuotaExceededError{})
+	c.Assert(err, check.ErrorMatches, `instance "first" exceeded the quota of 3 alarms`)
+	err = CheckQuota("second", 2, 1)
+	c.Assert(err, check.ErrorMatches, `team "myteam" exceeded the quota of 4 alarms`)
+}
@@ -0,0 +1,36 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+)
+
+// Run runs the command in the units of the app, or in one of them when
+// once is set, returning its output, truncated to maxOutput bytes, and
+// whether it was truncated.
+func (c *Client) Run(app, command string, once bool, maxOutput int) (string, bool, error) {
+	body := url.Values{"command": {command}, "isolated": {"false"}}
+	if once {
+		body.Set("once", "true")
+	}
+	resp, err := c.do(http.MethodPost, app, "/run", "", body.Encode())
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	var output bytes.Buffer
+	var truncated bool
+	err = streamError(resp, func(message string) {
+		if output.Len()+len(message) > maxOutput {
+			message = message[:maxOutput-output.Len()]
+			truncated = true
+		}
+		output.WriteString(message)
+	})
+	return output.String(), truncated, err
+}
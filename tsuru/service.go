@@ -6,7 +6,6 @@ package tsuru
 
 import (
 	"errors"
-	"fmt"
 	"strings"
 
 	"github.com/tsuru/tsuru-autoscale/db"
@@ -19,17 +18,34 @@ func logger() *log.Logger {
 	return log.Log()
 }
 
-// Instance represents a tsuru service instance.
+// Instance represents a tsuru service instance. Params are the parameters
+// of its plan.
 type Instance struct {
-	ID   bson.ObjectId `bson:"_id" json:"-"`
-	Name string
-	User string
-	Team string
-	Apps []string `json:",omitempty"`
+	ID     bson.ObjectId `bson:"_id" json:"-"`
+	Name   string
+	User   string
+	Team   string
+	Pool   string            `json:",omitempty" bson:",omitempty"`
+	Apps   []string          `json:",omitempty"`
+	Plan   string            `json:",omitempty" bson:",omitempty"`
+	Params map[string]string `json:",omitempty" bson:",omitempty"`
+}
+
+// Envs returns the instance values available as placeholders in data
+// sources and actions, with the parameters of its plan.
+func (i *Instance) Envs() map[string]string {
+	envs := map[string]string{}
+	for key, value := range i.Params {
+		envs[key] = value
+	}
+	envs["instance"] = i.Name
+	envs["team"] = i.Team
+	envs["pool"] = i.Pool
+	return envs
 }
 
 func (i *Instance) update() error {
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return err
@@ -86,7 +102,7 @@ func NewInstance(i *Instance) error {
 	if i.ID.Hex() == "" {
 		i.ID = bson.NewObjectId()
 	}
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return err
@@ -97,7 +113,7 @@ func NewInstance(i *Instance) error {
 
 // RemoveInstance removes an auto scale instance
 func RemoveInstance(i *Instance) error {
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return err
@@ -108,7 +124,7 @@ func RemoveInstance(i *Instance) error {
 
 // GetInstanceByName finds a service instance by name.
 func GetInstanceByName(name string) (*Instance, error) {
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
 		return nil, err
@@ -118,7 +134,7 @@ func GetInstanceByName(name string) (*Instance, error) {
 	err = conn.Instances().Find(bson.M{"name": name}).One(&i)
 	if err != nil {
 		if err == mgo.ErrNotFound {
-			err = fmt.Errorf("instance %q not found", name)
+			err = &db.NotFoundError{Resource: "instance", Name: name}
 		}
 		logger().Error(err)
 		return nil, err
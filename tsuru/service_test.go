@@ -7,26 +7,25 @@ package tsuru
 import (
 	"testing"
 
-	"github.com/tsuru/tsuru-autoscale/db"
-	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru-autoscale/db/storagetest"
 	"gopkg.in/check.v1"
 )
 
 func Test(t *testing.T) { check.TestingT(t) }
 
 type S struct {
-	conn *db.Storage
+	conn *storagetest.Storage
 }
 
 var _ = check.Suite(&S{})
 
 func (s *S) SetUpSuite(c *check.C) {
 	var err error
-	s.conn, err = db.Conn()
+	s.conn, err = storagetest.New("tsuru_autoscale")
 	c.Assert(err, check.IsNil)
 }
 func (s *S) TearDownTest(c *check.C) {
-	dbtest.ClearAllCollections(s.conn.Instances().Database)
+	s.conn.Clear()
 }
 
 func (s *S) TestNewInstance(c *check.C) {
@@ -135,3 +134,9 @@ func (s *S) TestRemoveAppByHost(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(i.Apps, check.HasLen, 0)
 }
+
+func (s *S) TestInstanceEnvs(c *check.C) {
+	i := Instance{Name: "myinstance", Team: "myteam", Pool: "mypool"}
+	expected := map[string]string{"instance": "myinstance", "team": "myteam", "pool": "mypool"}
+	c.Assert(i.Envs(), check.DeepEquals, expected)
+}
@@ -0,0 +1,174 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru-autoscale/httpclient"
+)
+
+// Client is a client of the tsuru api app endpoints. Host defaults to the
+// TSURU_HOST environment variable.
+type Client struct {
+	Host       string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// APIError represents an error returned by the tsuru api. RetryAfter is
+// the interval requested by the api before retrying throttled requests.
+type APIError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("tsuru: %s (status %d)", e.Message, e.StatusCode)
+}
+
+// Temporary returns whether the request may succeed if retried.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+// AddUnits adds units to the app process.
+func (c *Client) AddUnits(app, process string, units int) error {
+	body := url.Values{"units": {strconv.Itoa(units)}, "process": {process}}
+	return c.units(http.MethodPut, app, "", body.Encode())
+}
+
+// RemoveUnits removes units from the app process.
+func (c *Client) RemoveUnits(app, process string, units int) error {
+	query := url.Values{"units": {strconv.Itoa(units)}, "process": {process}}
+	return c.units(http.MethodDelete, app, query.Encode(), "")
+}
+
+// Units returns the number of units of the app process, or of the app when
+// process is empty.
+func (c *Client) Units(app, process string) (int, error) {
+	resp, err := c.do(http.MethodGet, app, "", "", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var info struct {
+		Units []struct {
+			ProcessName string
+		}
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return 0, fmt.Errorf("tsuru: invalid app info: %s", err)
+	}
+	var units int
+	for _, unit := range info.Units {
+		if process == "" || unit.ProcessName == process {
+			units++
+		}
+	}
+	return units, nil
+}
+
+func (c *Client) units(method, app, query, body string) error {
+	resp, err := c.do(method, app, "/units", query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return streamError(resp, nil)
+}
+
+// do sends a request to the app endpoint path, returning an APIError when
+// the request fails.
+func (c *Client) do(method, app, path, query, body string) (*http.Response, error) {
+	host := c.Host
+	if host == "" {
+		host = os.Getenv("TSURU_HOST")
+	}
+	u := fmt.Sprintf("%s/apps/%s%s", strings.TrimRight(host, "/"), url.QueryEscape(app), path)
+	if query != "" {
+		u += "?" + query
+	}
+	req, err := http.NewRequest(method, u, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "bearer "+c.Token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: errorMessage(resp.StatusCode, app, data)}
+		if httpclient.Throttled(resp) {
+			apiErr.RetryAfter = httpclient.RetryAfter(resp)
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// errorMessage returns a message describing a failed units request.
+func errorMessage(status int, app string, body []byte) string {
+	message := strings.TrimSpace(string(body))
+	switch status {
+	case http.StatusUnauthorized:
+		return "unauthorized, the token is invalid or expired"
+	case http.StatusForbidden:
+		return fmt.Sprintf("the token is not allowed to manage the app %q", app)
+	case http.StatusNotFound:
+		if message == "" {
+			message = fmt.Sprintf("app %q not found", app)
+		}
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return message
+}
+
+// streamError returns the error reported in the stream of messages of a
+// successful response, in the format used by the tsuru api to report the
+// progress of long operations. The messages are passed to message, when
+// set.
+func streamError(resp *http.Response, message func(string)) error {
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var msg struct {
+			Message string
+			Error   string
+		}
+		if json.Unmarshal(scanner.Bytes(), &msg) != nil {
+			continue
+		}
+		if msg.Error != "" {
+			return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(msg.Error)}
+		}
+		if message != nil && msg.Message != "" {
+			message(msg.Message)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestClientAddUnits(c *check.C) {
+	var method, path, authorization string
+	var form map[string][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, authorization = r.Method, r.URL.Path, r.Header.Get("Authorization")
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"Message":"adding 2 units\n"}` + "\n" + `{"Message":"OK\n"}` + "\n"))
+	}))
+	defer ts.Close()
+	client := Client{Host: ts.URL, Token: "token"}
+	err := client.AddUnits("myapp", "web", 2)
+	c.Assert(err, check.IsNil)
+	c.Assert(method, check.Equals, "PUT")
+	c.Assert(path, check.Equals, "/apps/myapp/units")
+	c.Assert(authorization, check.Equals, "bearer token")
+	c.Assert(form["units"], check.DeepEquals, []string{"2"})
+	c.Assert(form["process"], check.DeepEquals, []string{"web"})
+}
+
+func (s *S) TestClientRemoveUnits(c *check.C) {
+	var method, query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, query = r.Method, r.URL.RawQuery
+	}))
+	defer ts.Close()
+	client := Client{Host: ts.URL, Token: "token"}
+	err := client.RemoveUnits("myapp", "worker", 1)
+	c.Assert(err, check.IsNil)
+	c.Assert(method, check.Equals, "DELETE")
+	c.Assert(query, check.Equals, "process=worker&units=1")
+}
+
+func (s *S) TestClientUnitsStreamError(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Message":"adding 2 units\n"}` + "\n" + `{"Message":"","Error":"quota exceeded\n"}` + "\n"))
+	}))
+	defer ts.Close()
+	client := Client{Host: ts.URL, Token: "token"}
+	err := client.AddUnits("myapp", "web", 2)
+	c.Assert(err, check.DeepEquals, &APIError{StatusCode: http.StatusOK, Message: "quota exceeded"})
+}
+
+func (s *S) TestClientUnitsErrors(c *check.C) {
+	tests := []struct {
+		status    int
+		body      string
+		message   string
+		temporary bool
+	}{
+		{http.StatusUnauthorized, "invalid token", "unauthorized, the token is invalid or expired", false},
+		{http.StatusForbidden, "", `the token is not allowed to manage the app "myapp"`, false},
+		{http.StatusNotFound, "", `app "myapp" not found`, false},
+		{http.StatusBadRequest, "Invalid number of units: the app has only 1 unit\n", "Invalid number of units: the app has only 1 unit", false},
+		{http.StatusServiceUnavailable, "", "Service Unavailable", true},
+	}
+	for _, tt := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}))
+		client := Client{Host: ts.URL, Token: "token"}
+		err := client.RemoveUnits("myapp", "web", 1)
+		ts.Close()
+		apiErr, ok := err.(*APIError)
+		c.Assert(ok, check.Equals, true)
+		c.Check(apiErr.StatusCode, check.Equals, tt.status)
+		c.Check(apiErr.Message, check.Equals, tt.message)
+		c.Check(apiErr.Temporary(), check.Equals, tt.temporary)
+	}
+}
+
+func (s *S) TestClientRetryAfter(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+	client := Client{Host: ts.URL, Token: "token"}
+	err := client.AddUnits("myapp", "web", 1)
+	apiErr, ok := err.(*APIError)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(apiErr.Temporary(), check.Equals, true)
+	c.Assert(apiErr.RetryAfter, check.Equals, 30*time.Second)
+}
+
+func (s *S) TestClientUnits(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/apps/myapp")
+		w.Write([]byte(`{"name": "myapp", "units": [{"ProcessName": "web"}, {"ProcessName": "web"}, {"ProcessName": "worker"}]}`))
+	}))
+	defer ts.Close()
+	client := Client{Host: ts.URL, Token: "token"}
+	units, err := client.Units("myapp", "web")
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.Equals, 2)
+	units, err = client.Units("myapp", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.Equals, 3)
+}
+
+func (s *S) TestClientRun(c *check.C) {
+	var method, path string
+	var form map[string][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"Message":"warming\n"}` + "\n" + `{"Message":"cache warmed\n"}` + "\n"))
+	}))
+	defer ts.Close()
+	client := Client{Host: ts.URL, Token: "token"}
+	output, truncated, err := client.Run("myapp", "./warm-cache", true, 1024)
+	c.Assert(err, check.IsNil)
+	c.Assert(output, check.Equals, "warming\ncache warmed\n")
+	c.Assert(truncated, check.Equals, false)
+	c.Assert(method, check.Equals, "POST")
+	c.Assert(path, check.Equals, "/apps/myapp/run")
+	c.Assert(form["command"], check.DeepEquals, []string{"./warm-cache"})
+	c.Assert(form["once"], check.DeepEquals, []string{"true"})
+	c.Assert(form["isolated"], check.DeepEquals, []string{"false"})
+	output, truncated, err = client.Run("myapp", "./warm-cache", false, 10)
+	c.Assert(err, check.IsNil)
+	c.Assert(output, check.Equals, "warming\nca")
+	c.Assert(truncated, check.Equals, true)
+}
+
+func (s *S) TestClientRunError(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Message":"warming\n"}` + "\n" + `{"Error":"exit status 1"}` + "\n"))
+	}))
+	defer ts.Close()
+	client := Client{Host: ts.URL, Token: "token"}
+	output, _, err := client.Run("myapp", "./warm-cache", false, 1024)
+	c.Assert(err, check.DeepEquals, &APIError{StatusCode: http.StatusOK, Message: "exit status 1"})
+	c.Assert(output, check.Equals, "warming\n")
+}
@@ -5,6 +5,7 @@
 package web
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/ajg/form"
@@ -27,7 +28,10 @@ func dataSourceDetailHandler(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
-	return render(w, "web/templates/datasource/detail.html", ds[0])
+	if len(ds) == 0 {
+		return fmt.Errorf("datasource %q not found", vars["name"])
+	}
+	return render(w, "web/templates/datasource/detail.html", ds[0].Redacted())
 }
 
 func dataSourceAdd(w http.ResponseWriter, r *http.Request) error {
@@ -8,15 +8,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
-	"os"
 	"strings"
 	"testing"
 
 	"github.com/ajg/form"
 	"github.com/gorilla/mux"
 	"github.com/tsuru/tsuru-autoscale/datasource"
-	"github.com/tsuru/tsuru-autoscale/db"
-	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru-autoscale/db/storagetest"
 	"gopkg.in/check.v1"
 )
 
@@ -29,25 +27,19 @@ func server(w http.ResponseWriter, r *http.Request) {
 func Test(t *testing.T) { check.TestingT(t) }
 
 type S struct {
-	conn *db.Storage
+	conn *storagetest.Storage
 }
 
 var _ = check.Suite(&S{})
 
 func (s *S) SetUpSuite(c *check.C) {
-	err := os.Setenv("MONGODB_DATABASE_NAME", "tsuru_autoscale_web")
-	c.Assert(err, check.IsNil)
-	s.conn, err = db.Conn()
+	var err error
+	s.conn, err = storagetest.New("tsuru_autoscale_web")
 	c.Assert(err, check.IsNil)
 }
 
 func (s *S) TearDownTest(c *check.C) {
-	dbtest.ClearAllCollections(s.conn.Actions().Database)
-	dbtest.ClearAllCollections(s.conn.Alarms().Database)
-	dbtest.ClearAllCollections(s.conn.DataSources().Database)
-	dbtest.ClearAllCollections(s.conn.Events().Database)
-	dbtest.ClearAllCollections(s.conn.Instances().Database)
-	dbtest.ClearAllCollections(s.conn.Wizard().Database)
+	s.conn.Clear()
 }
 
 func (s *S) TestDataSourceAdd(c *check.C) {
@@ -73,7 +65,7 @@ func (s *S) TestDataSourceAddEmptyHeader(c *check.C) {
 		"key":    []string{"", "f", ""},
 		"value":  []string{"", "f", ""},
 		"name":   []string{"new"},
-		"url":    []string{"sdfasd"},
+		"url":    []string{"http://tsuru.io"},
 		"method": []string{"GET"},
 	}
 	body := strings.NewReader(v.Encode())
@@ -0,0 +1,154 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wizard
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/tsuru/tsuru-autoscale/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// patchFields are the stored fields of the auto scale, by their json name,
+// updated by the patches.
+var patchFields = map[string]string{
+	"scaleUp":   "scaleup",
+	"scaleDown": "scaledown",
+	"minUnits":  "minunits",
+	"process":   "process",
+}
+
+// Patch applies a JSON merge patch (RFC 7386) to the auto scale, updating
+// only the fields present in the patch, so clients can change a single
+// value, like scaleUp.value, without resending the whole configuration.
+// The alarms of the auto scale are recreated, keeping their events and
+// whether they are enabled, which can be changed by the enabled field.
+func Patch(name string, patch []byte) (*AutoScale, error) {
+	var changes map[string]interface{}
+	if err := decodeJSON(patch, &changes); err != nil || changes == nil {
+		return nil, invalid("patch must be a json object")
+	}
+	old, err := FindByName(name)
+	if err != nil {
+		return nil, err
+	}
+	enabled := old.Enabled()
+	if e, ok := changes["enabled"]; ok {
+		if enabled, ok = e.(bool); !ok {
+			return nil, invalid("enabled must be true or false")
+		}
+		delete(changes, "enabled")
+	}
+	a, err := old.merge(changes)
+	if err != nil {
+		return nil, err
+	}
+	if a.Name != old.Name {
+		return nil, invalid("name can not be changed")
+	}
+	if err = a.ScaleUp.validateVars(); err != nil {
+		return nil, err
+	}
+	if err = a.ScaleDown.validateVars(); err != nil {
+		return nil, err
+	}
+	if a.MinUnits <= 0 {
+		a.MinUnits = 1
+	}
+	set := bson.M{}
+	for field, stored := range patchFields {
+		if _, ok := changes[field]; ok {
+			set[stored] = fieldValue(a, field)
+		}
+	}
+	if len(set) > 0 {
+		conn, err := db.Open()
+		if err != nil {
+			logger().Error(err)
+			return nil, err
+		}
+		defer conn.Close()
+		err = a.saveAlarms(conn, old, func() error {
+			return conn.Wizards().Update(bson.M{"name": name}, bson.M{"$set": set})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if enabled {
+		err = a.Enable()
+	} else {
+		err = a.Disable()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// merge returns the auto scale with the merge patch applied.
+func (a *AutoScale) merge(changes map[string]interface{}) (*AutoScale, error) {
+	type alias AutoScale
+	data, err := json.Marshal((*alias)(a))
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err = decodeJSON(data, &doc); err != nil {
+		return nil, err
+	}
+	data, err = json.Marshal(mergePatch(doc, changes))
+	if err != nil {
+		return nil, err
+	}
+	var merged AutoScale
+	if err = json.Unmarshal(data, (*alias)(&merged)); err != nil {
+		return nil, invalid("invalid patch: %s", err)
+	}
+	return &merged, nil
+}
+
+func fieldValue(a *AutoScale, field string) interface{} {
+	switch field {
+	case "scaleUp":
+		return a.ScaleUp
+	case "scaleDown":
+		return a.ScaleDown
+	case "minUnits":
+		return a.MinUnits
+	}
+	return a.Process
+}
+
+// decodeJSON decodes the data keeping the numbers as json.Number, so
+// integers are not changed by the float conversion.
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// mergePatch applies the merge patch to the target: objects are merged
+// recursively, null values remove the members and the other values replace
+// the target.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = mergePatch(t[key], value)
+	}
+	return t
+}
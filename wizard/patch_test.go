@@ -0,0 +1,86 @@
+// Copyright 2017 tsuru-autoscale authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wizard
+
+import (
+	"github.com/tsuru/tsuru-autoscale/alarm"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestMergePatch(c *check.C) {
+	tests := []struct {
+		target, patch, result string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":{"b":"c","d":"e"}}`, `{"a":{"b":"f"}}`, `{"a":{"b":"f","d":"e"}}`},
+		{`{"a":["b"]}`, `{"a":["c","d"]}`, `{"a":["c","d"]}`},
+		{`{"a":"b"}`, `{"c":{"d":null}}`, `{"a":"b","c":{}}`},
+		{`{"a":{"b":"c"}}`, `{"a":"d"}`, `{"a":"d"}`},
+	}
+	for _, tt := range tests {
+		var target, patch, expected interface{}
+		c.Assert(decodeJSON([]byte(tt.target), &target), check.IsNil)
+		c.Assert(decodeJSON([]byte(tt.patch), &patch), check.IsNil)
+		c.Assert(decodeJSON([]byte(tt.result), &expected), check.IsNil)
+		c.Check(mergePatch(target, patch), check.DeepEquals, expected, check.Commentf("%s + %s", tt.target, tt.patch))
+	}
+}
+
+func (s *S) TestPatch(c *check.C) {
+	a := AutoScale{
+		Name:      "test",
+		ScaleUp:   ScaleAction{Metric: "cpu", Operator: ">", Step: "1", Value: "10", Wait: 50, Vars: map[string]string{"query": "cpu"}},
+		ScaleDown: ScaleAction{Metric: "cpu", Operator: "<", Step: "1", Value: "2", Wait: 50},
+		Process:   "web",
+		MinUnits:  2,
+	}
+	err := New(&a)
+	c.Assert(err, check.IsNil)
+	err = a.Disable()
+	c.Assert(err, check.IsNil)
+	patched, err := Patch("test", []byte(`{"scaleUp":{"value":"90","vars":{"query":null}}}`))
+	c.Assert(err, check.IsNil)
+	c.Assert(patched.ScaleUp.Value, check.Equals, "90")
+	r, err := FindByName("test")
+	c.Assert(err, check.IsNil)
+	c.Assert(r.ScaleUp.Value, check.Equals, "90")
+	c.Assert(r.ScaleUp.Operator, check.Equals, ">")
+	c.Assert(r.ScaleUp.Wait, check.Equals, a.ScaleUp.Wait)
+	c.Assert(r.ScaleUp.Vars, check.HasLen, 0)
+	c.Assert(r.ScaleDown, check.DeepEquals, a.ScaleDown)
+	c.Assert(r.MinUnits, check.Equals, 2)
+	c.Assert(r.Enabled(), check.Equals, false)
+	al, err := alarm.FindAlarmByName("scale_up_test_web")
+	c.Assert(err, check.IsNil)
+	c.Assert(al.Expression, check.Matches, ".* > 90")
+	_, err = Patch("test", []byte(`{"enabled":true}`))
+	c.Assert(err, check.IsNil)
+	r, err = FindByName("test")
+	c.Assert(err, check.IsNil)
+	c.Assert(r.Enabled(), check.Equals, true)
+	c.Assert(r.ScaleUp.Value, check.Equals, "90")
+}
+
+func (s *S) TestPatchInvalid(c *check.C) {
+	_, err := Patch("test", []byte(`[]`))
+	c.Assert(err, check.ErrorMatches, "wizard: patch must be a json object")
+	_, err = Patch("unknown", []byte(`{}`))
+	c.Assert(err, check.ErrorMatches, `wizard "unknown" not found`)
+	a := AutoScale{
+		Name:      "test",
+		ScaleUp:   ScaleAction{Metric: "cpu", Operator: ">", Step: "1", Value: "10"},
+		ScaleDown: ScaleAction{Metric: "cpu", Operator: "<", Step: "1", Value: "2"},
+	}
+	err = New(&a)
+	c.Assert(err, check.IsNil)
+	_, err = Patch("test", []byte(`{"name":"other"}`))
+	c.Assert(err, check.ErrorMatches, "wizard: name can not be changed")
+	_, err = Patch("test", []byte(`{"minUnits":"two"}`))
+	c.Assert(err, check.ErrorMatches, "wizard: invalid patch: .*")
+	_, err = Patch("test", []byte(`{"enabled":"yes"}`))
+	c.Assert(err, check.ErrorMatches, "wizard: enabled must be true or false")
+}
@@ -7,6 +7,7 @@ package wizard
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -15,6 +16,8 @@ import (
 	"github.com/tsuru/tsuru-autoscale/datasource"
 	"github.com/tsuru/tsuru-autoscale/db"
 	"github.com/tsuru/tsuru-autoscale/log"
+	"github.com/tsuru/tsuru-autoscale/tsuru"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -34,6 +37,22 @@ type AutoScale struct {
 	ScaleDown ScaleAction `json:"scaleDown"`
 	MinUnits  int         `json:"minUnits"`
 	Process   string      `json:"process"`
+
+	// lg is the logger of the request changing the auto scale.
+	lg *log.Logger
+}
+
+// SetLogger sets the logger of the changes of the auto scale, so their logs
+// carry the fields of the request, like its request id.
+func (a *AutoScale) SetLogger(l *log.Logger) {
+	a.lg = l
+}
+
+func (a *AutoScale) logger() *log.Logger {
+	if a.lg != nil {
+		return a.lg
+	}
+	return logger()
 }
 
 // MarshalJSON marshals AutoScale in json format
@@ -49,37 +68,80 @@ func (a *AutoScale) MarshalJSON() ([]byte, error) {
 }
 
 // ScaleAction represents a auto scale action like scale up or scale down.
+// Vars are added to the alarm envs, so they can be used as placeholders in
+// the data source requests, e.g. to select the query of a generic data
+// source.
 type ScaleAction struct {
-	Aggregator string        `json:"aggregator"`
-	Metric     string        `json:"metric"`
-	Operator   string        `json:"operator"`
-	Value      string        `json:"value"`
-	Step       string        `json:"step"`
-	Wait       time.Duration `json:"wait"`
+	Aggregator string            `json:"aggregator"`
+	Metric     string            `json:"metric"`
+	Operator   string            `json:"operator"`
+	Value      string            `json:"value"`
+	Step       string            `json:"step"`
+	Wait       time.Duration     `json:"wait"`
+	Vars       map[string]string `json:"vars,omitempty" bson:",omitempty"`
 }
 
-// New creates a new auto scale based on AutoScale configuration
+var (
+	varName      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	reservedVars = []string{"app", "now", "interval", "instance", "team", "pool", "step", "process", "aggregator"}
+)
+
+func (a *ScaleAction) validateVars() error {
+	for name := range a.Vars {
+		if !varName.MatchString(name) {
+			return invalid("invalid variable name %q", name)
+		}
+		for _, reserved := range reservedVars {
+			if name == reserved {
+				return invalid("variable %q is reserved", name)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidationError is returned when an auto scale, or a change of it, is
+// invalid.
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return "wizard: " + e.Message
+}
+
+// invalid returns the *ValidationError with the formatted message.
+func invalid(format string, args ...interface{}) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// New creates a new auto scale based on AutoScale configuration, within
+// the quotas of its instance.
 func New(a *AutoScale) error {
 	if a.MinUnits <= 0 {
 		a.MinUnits = 1
 	}
-	err := newScaleAction(a, "scale_up")
+	err := tsuru.CheckQuota(a.Name, 2, 1)
 	if err != nil {
-		logger().Error(err)
+		return err
+	}
+	err = newScaleAction(a, "scale_up")
+	if err != nil {
+		a.logger().Error(err)
 		return err
 	}
 	err = newScaleAction(a, "scale_down")
 	if err != nil {
-		logger().Error(err)
+		a.logger().Error(err)
 		return err
 	}
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
-		logger().Error(err)
+		a.logger().Error(err)
 		return nil
 	}
 	defer conn.Close()
-	return conn.Wizard().Insert(&a)
+	return conn.Wizards().Insert(&a)
 }
 
 func newScaleAction(scaleConfig *AutoScale, kind string) error {
@@ -97,6 +159,9 @@ func newScaleAction(scaleConfig *AutoScale, kind string) error {
 		action = scaleConfig.ScaleDown
 		datasources = []string{"units", action.Metric}
 	}
+	if err := action.validateVars(); err != nil {
+		return err
+	}
 	if scaleConfig.Process == "" {
 		name = fmt.Sprintf("%s_%s", kind, scaleConfig.Name)
 		processName = "web"
@@ -134,6 +199,9 @@ func newScaleAction(scaleConfig *AutoScale, kind string) error {
 		"process":    processName,
 		"aggregator": aggregator,
 	}
+	for name, value := range action.Vars {
+		envs[name] = value
+	}
 	a := alarm.Alarm{
 		Name:        name,
 		Expression:  replacer.Replace(expression),
@@ -149,19 +217,26 @@ func newScaleAction(scaleConfig *AutoScale, kind string) error {
 
 // FindByfinds auto scale by a query "q"
 func FindBy(q bson.M) ([]AutoScale, error) {
-	conn, err := db.Conn()
+	a, _, err := List(q, nil)
+	return a, err
+}
+
+// List returns the page of the auto scales matching q selected by opts and
+// the total of auto scales matching q and the filters.
+func List(q bson.M, opts *db.ListOptions) ([]AutoScale, int, error) {
+	conn, err := db.Open()
 	if err != nil {
 		logger().Error(err)
-		return nil, err
+		return nil, 0, err
 	}
 	defer conn.Close()
 	var a []AutoScale
-	err = conn.Wizard().Find(q).All(&a)
+	total, err := db.List(conn.Wizards(), q, opts, &a)
 	if err != nil {
 		logger().Error(err)
-		return nil, err
+		return nil, 0, err
 	}
-	return a, nil
+	return a, total, nil
 }
 
 // FindByName finds auto scale by name
@@ -174,10 +249,12 @@ func FindByName(name string) (*AutoScale, error) {
 	if len(l) > 0 {
 		return &l[0], nil
 	}
-	return nil, fmt.Errorf("wizard %q not found", name)
+	return nil, &db.NotFoundError{Resource: "wizard", Name: name}
 }
 
-func (a *AutoScale) alarms() []string {
+// AlarmNames returns the names of the scale up and scale down alarms of the
+// auto scale.
+func (a *AutoScale) AlarmNames() []string {
 	var alarms []string
 	if a.Process == "" {
 		alarms = append(alarms, fmt.Sprintf("scale_up_%s", a.Name))
@@ -190,15 +267,15 @@ func (a *AutoScale) alarms() []string {
 }
 
 func removeAlarms(autoScale *AutoScale) error {
-	for _, a := range autoScale.alarms() {
+	for _, a := range autoScale.AlarmNames() {
 		al, err := alarm.FindAlarmByName(a)
 		if err != nil {
-			logger().Error(err)
+			autoScale.logger().Error(err)
 			return err
 		}
 		err = alarm.RemoveAlarm(al)
 		if err != nil {
-			logger().Error(err)
+			autoScale.logger().Error(err)
 			return err
 		}
 	}
@@ -209,39 +286,35 @@ func removeAlarms(autoScale *AutoScale) error {
 func Remove(a *AutoScale) error {
 	err := removeAlarms(a)
 	if err != nil {
-		logger().Error(err)
+		a.logger().Error(err)
 		return err
 	}
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
-		logger().Error(err)
+		a.logger().Error(err)
 		return err
 	}
 	defer conn.Close()
-	return conn.Wizard().Remove(a)
+	return conn.Wizards().Remove(a)
 }
 
 // Events return a list of AutoScale events
 func (a *AutoScale) Events() ([]alarm.Event, error) {
-	conn, err := db.Conn()
-	if err != nil {
-		logger().Error(err)
-		return nil, err
-	}
-	defer conn.Close()
-	var events []alarm.Event
+	events, _, err := a.ListEvents(&db.ListOptions{Limit: 200})
+	return events, err
+}
+
+// ListEvents returns the page of the scale events of the auto scale
+// selected by opts, the latest ones first by default, and the total of
+// events matching its filters.
+func (a *AutoScale) ListEvents(opts *db.ListOptions) ([]alarm.Event, int, error) {
 	q := bson.M{"alarm.instance": a.Name, "alarm.actions": bson.M{"$in": []string{"scale_up", "scale_down"}}}
-	err = conn.Events().Find(q).Sort("-starttime").Limit(200).All(&events)
-	if err != nil {
-		logger().Error(err)
-		return nil, err
-	}
-	return events, nil
+	return alarm.FindEvents(q, opts)
 }
 
 // Enable enables the AutoScale alarms
 func (a *AutoScale) Enable() error {
-	for _, alarmName := range a.alarms() {
+	for _, alarmName := range a.AlarmNames() {
 		al, err := alarm.FindAlarmByName(alarmName)
 		if err != nil {
 			return err
@@ -256,7 +329,7 @@ func (a *AutoScale) Enable() error {
 
 // Disable disables the AutoScale alarms
 func (a *AutoScale) Disable() error {
-	for _, alarmName := range a.alarms() {
+	for _, alarmName := range a.AlarmNames() {
 		al, err := alarm.FindAlarmByName(alarmName)
 		if err != nil {
 			return err
@@ -271,7 +344,7 @@ func (a *AutoScale) Disable() error {
 
 // Enabled returns true if the AutoScale alarms are enabled
 func (a *AutoScale) Enabled() bool {
-	for _, alarmName := range a.alarms() {
+	for _, alarmName := range a.AlarmNames() {
 		al, err := alarm.FindAlarmByName(alarmName)
 		if err != nil {
 			return false
@@ -283,6 +356,43 @@ func (a *AutoScale) Enabled() bool {
 	return true
 }
 
+// Scale directions of the manual scales.
+const (
+	ScaleUp   = "up"
+	ScaleDown = "down"
+)
+
+// Scale scales the instance manually on behalf of user, executing the
+// action of the scale up or scale down alarm immediately, with units as
+// its step, or the step of the auto scale when units is zero.
+func (a *AutoScale) Scale(direction string, units int, user string) (*alarm.TriggerResult, error) {
+	alarms := a.AlarmNames()
+	var name string
+	switch direction {
+	case ScaleUp:
+		name = alarms[0]
+	case ScaleDown:
+		name = alarms[1]
+	default:
+		return nil, invalid("invalid direction %q, must be up or down", direction)
+	}
+	if units < 0 {
+		return nil, invalid("units must not be negative")
+	}
+	al, err := alarm.FindAlarmByName(name)
+	if err != nil {
+		return nil, err
+	}
+	al.SetLogger(a.logger())
+	if units > 0 {
+		if al.Envs == nil {
+			al.Envs = map[string]string{}
+		}
+		al.Envs["step"] = strconv.Itoa(units)
+	}
+	return al.Scale(user)
+}
+
 // Update updates an auto scale
 func Update(a *AutoScale) error {
 	old, err := FindByName(a.Name)
@@ -298,19 +408,110 @@ func Update(a *AutoScale) error {
 	}
 	err = newScaleAction(a, "scale_up")
 	if err != nil {
-		logger().Error(err)
+		a.logger().Error(err)
 		return err
 	}
 	err = newScaleAction(a, "scale_down")
 	if err != nil {
-		logger().Error(err)
+		a.logger().Error(err)
 		return err
 	}
-	conn, err := db.Conn()
+	conn, err := db.Open()
 	if err != nil {
-		logger().Error(err)
+		a.logger().Error(err)
 		return nil
 	}
 	defer conn.Close()
-	return conn.Wizard().Update(bson.M{"name": a.Name}, a)
+	return conn.Wizards().Update(bson.M{"name": a.Name}, a)
+}
+
+// Save creates the auto scale, or replaces the existing one with the same
+// name, returning whether it was created. The auto scale and its alarms are
+// saved all or nothing: when saving any of them fails, the previous alarms
+// are restored. The events of the alarms are kept.
+func Save(a *AutoScale) (bool, error) {
+	if a.Name == "" {
+		return false, invalid("name required")
+	}
+	if err := a.ScaleUp.validateVars(); err != nil {
+		return false, err
+	}
+	if err := a.ScaleDown.validateVars(); err != nil {
+		return false, err
+	}
+	if a.MinUnits <= 0 {
+		a.MinUnits = 1
+	}
+	old, err := FindByName(a.Name)
+	if err != nil && !db.IsNotFound(err) {
+		return false, err
+	}
+	if old == nil {
+		if err = tsuru.CheckQuota(a.Name, 2, 1); err != nil {
+			return false, err
+		}
+	}
+	conn, err := db.Open()
+	if err != nil {
+		a.logger().Error(err)
+		return false, err
+	}
+	defer conn.Close()
+	var info *mgo.ChangeInfo
+	err = a.saveAlarms(conn, old, func() error {
+		var err error
+		info, err = conn.Wizards().Upsert(bson.M{"name": a.Name}, a)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return info.UpsertedId != nil, nil
+}
+
+// saveAlarms replaces the alarms of the old auto scale, when there is one,
+// by the alarms of the auto scale and calls save. When creating the alarms
+// or save fails, the previous alarms are restored.
+func (a *AutoScale) saveAlarms(conn db.Storage, old *AutoScale, save func() error) error {
+	var previous []alarm.Alarm
+	if old != nil {
+		q := bson.M{"name": bson.M{"$in": old.AlarmNames()}}
+		if err := conn.Alarms().Find(q).All(&previous); err != nil {
+			a.logger().Error(err)
+			return err
+		}
+		if _, err := conn.Alarms().RemoveAll(q); err != nil {
+			a.logger().Error(err)
+			return err
+		}
+	}
+	var created []string
+	var err error
+	for i, kind := range []string{"scale_up", "scale_down"} {
+		if err = newScaleAction(a, kind); err != nil {
+			break
+		}
+		created = append(created, a.AlarmNames()[i])
+	}
+	if err == nil {
+		err = save()
+	}
+	if err != nil {
+		a.logger().Error(err)
+		a.restoreAlarms(conn, created, previous)
+	}
+	return err
+}
+
+// restoreAlarms replaces the alarms created by a failed save by the previous
+// alarms of the auto scale.
+func (a *AutoScale) restoreAlarms(conn db.Storage, created []string, previous []alarm.Alarm) {
+	if _, err := conn.Alarms().RemoveAll(bson.M{"name": bson.M{"$in": created}}); err != nil {
+		a.logger().Error(err)
+	}
+	for i := range previous {
+		if err := conn.Alarms().Insert(&previous[i]); err != nil {
+			a.logger().Error(err)
+		}
+	}
 }
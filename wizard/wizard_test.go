@@ -7,32 +7,34 @@ package wizard
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/tsuru/tsuru-autoscale/action"
 	"github.com/tsuru/tsuru-autoscale/alarm"
 	"github.com/tsuru/tsuru-autoscale/datasource"
-	"github.com/tsuru/tsuru-autoscale/db"
-	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru-autoscale/db/storagetest"
+	"github.com/tsuru/tsuru-autoscale/tsuru"
 	"gopkg.in/check.v1"
 )
 
 func Test(t *testing.T) { check.TestingT(t) }
 
 type S struct {
-	conn *db.Storage
+	conn *storagetest.Storage
 }
 
 func (s *S) SetUpSuite(c *check.C) {
-	err := os.Setenv("MONGODB_DATABASE_NAME", "tsuru_autoscale_wizard")
-	c.Assert(err, check.IsNil)
-	s.conn, err = db.Conn()
+	var err error
+	s.conn, err = storagetest.New("tsuru_autoscale_wizard")
 	c.Assert(err, check.IsNil)
 }
 
 func (s *S) TearDownTest(c *check.C) {
-	dbtest.ClearAllCollections(s.conn.Actions().Database)
+	s.conn.Clear()
 }
 
 func (s *S) TearDownSuite(c *check.C) {
@@ -95,6 +97,42 @@ func (s *S) TestNewScaleCustomAggregator(c *check.C) {
 	c.Assert(al.Actions, check.DeepEquals, []string{action})
 }
 
+func (s *S) TestNewScaleVars(c *check.C) {
+	a := ScaleAction{
+		Metric:   "prometheus",
+		Operator: ">",
+		Step:     "1",
+		Value:    "10",
+		Vars:     map[string]string{"query": "max(cpu)", "range": "5m"},
+	}
+	config := AutoScale{
+		Process: "web",
+		Name:    "instanceName",
+		ScaleUp: a,
+	}
+	err := newScaleAction(&config, "scale_up")
+	c.Assert(err, check.IsNil)
+	al, err := alarm.FindAlarmByName("scale_up_instanceName_web")
+	c.Assert(err, check.IsNil)
+	c.Assert(al.Envs, check.DeepEquals, map[string]string{"step": "1", "process": "web", "aggregator": "max", "query": "max(cpu)", "range": "5m"})
+}
+
+func (s *S) TestNewScaleInvalidVars(c *check.C) {
+	tests := []struct {
+		vars map[string]string
+		err  string
+	}{
+		{map[string]string{"step": "10"}, `wizard: variable "step" is reserved`},
+		{map[string]string{"app": "other"}, `wizard: variable "app" is reserved`},
+		{map[string]string{"env.HOME": "/"}, `wizard: invalid variable name "env.HOME"`},
+	}
+	for _, tt := range tests {
+		config := AutoScale{Name: "instanceName", ScaleUp: ScaleAction{Metric: "cpu", Vars: tt.vars}}
+		err := newScaleAction(&config, "scale_up")
+		c.Check(err, check.ErrorMatches, tt.err)
+	}
+}
+
 func (s *S) TestNew(c *check.C) {
 	scaleUp := ScaleAction{
 		Metric:   "cpu",
@@ -141,7 +179,7 @@ func (s *S) TestNew(c *check.C) {
 	c.Assert(al.Wait, check.Equals, 50*time.Second)
 	c.Assert(al.DataSources, check.DeepEquals, []string{"units", scaleDown.Metric})
 	var as AutoScale
-	err = s.conn.Wizard().Find(&a).One(&as)
+	err = s.conn.Wizards().Find(&a).One(&as)
 	c.Assert(err, check.IsNil)
 	c.Assert(as.Name, check.Equals, a.Name)
 	c.Assert(as.MinUnits, check.Equals, 2)
@@ -171,7 +209,7 @@ func (s *S) TestNewCustomDataSourceExpressionTemplate(c *check.C) {
 	}
 	err := datasource.New(&datasource.DataSource{
 		Name:               "cpu_prometheus",
-		URL:                "url",
+		URL:                "http://prometheus.tsuru.io",
 		Method:             "GET",
 		ExpressionTemplate: "cpu_prometheus['data']['result'][0]['values'] {operator} {value}",
 	})
@@ -200,7 +238,7 @@ func (s *S) TestNewCustomDataSourceExpressionTemplate(c *check.C) {
 	c.Assert(al.Wait, check.Equals, 50*time.Second)
 	c.Assert(al.DataSources, check.DeepEquals, []string{"units", scaleDown.Metric})
 	var as AutoScale
-	err = s.conn.Wizard().Find(&a).One(&as)
+	err = s.conn.Wizards().Find(&a).One(&as)
 	c.Assert(err, check.IsNil)
 	c.Assert(as.Name, check.Equals, a.Name)
 	c.Assert(as.MinUnits, check.Equals, 2)
@@ -231,7 +269,7 @@ func (s *S) TestNewMinUnitsLessThanZero(c *check.C) {
 	err := New(&a)
 	c.Assert(err, check.IsNil)
 	var as AutoScale
-	err = s.conn.Wizard().Find(&a).One(&as)
+	err = s.conn.Wizards().Find(&a).One(&as)
 	c.Assert(err, check.IsNil)
 	c.Assert(as.MinUnits, check.Equals, 1)
 }
@@ -260,7 +298,7 @@ func (s *S) TestNewWithoutMinUnits(c *check.C) {
 	err := New(&a)
 	c.Assert(err, check.IsNil)
 	var as AutoScale
-	err = s.conn.Wizard().Find(&a).One(&as)
+	err = s.conn.Wizards().Find(&a).One(&as)
 	c.Assert(err, check.IsNil)
 	c.Assert(as.MinUnits, check.Equals, 1)
 }
@@ -273,21 +311,22 @@ func (s *S) TestAutoScaleUnmarshal(c *check.C) {
 }
 
 func (s *S) TestScaleActionUnmarshal(c *check.C) {
-	data := []byte(`{"metric":"cpu","operator":">","value":"10","step":"2","wait":200}`)
+	data := []byte(`{"metric":"cpu","operator":">","value":"10","step":"2","wait":200,"vars":{"query":"max(cpu)"}}`)
 	sa := &ScaleAction{}
 	err := json.Unmarshal(data, sa)
 	c.Assert(err, check.IsNil)
+	c.Assert(sa.Vars, check.DeepEquals, map[string]string{"query": "max(cpu)"})
 }
 
 func (s *S) TestFindByName(c *check.C) {
 	a := AutoScale{
 		Name: "xpto123",
 	}
-	s.conn.Wizard().Insert(&a)
+	s.conn.Wizards().Insert(&a)
 	a = AutoScale{
 		Name: "xpto1234",
 	}
-	s.conn.Wizard().Insert(&a)
+	s.conn.Wizards().Insert(&a)
 	na, err := FindByName(a.Name)
 	c.Assert(err, check.IsNil)
 	c.Assert(na, check.DeepEquals, &a)
@@ -391,7 +430,7 @@ func (s *S) TestNewWithoutProcess(c *check.C) {
 	c.Assert(al.Enabled, check.Equals, true)
 	c.Assert(al.Actions, check.DeepEquals, []string{"scale_down"})
 	var as AutoScale
-	err = s.conn.Wizard().Find(&a).One(&as)
+	err = s.conn.Wizards().Find(&a).One(&as)
 	c.Assert(err, check.IsNil)
 	c.Assert(as.Name, check.Equals, a.Name)
 }
@@ -407,7 +446,7 @@ func (s *S) TestEvents(c *check.C) {
 	a := AutoScale{
 		Name: "xpto1234",
 	}
-	err = s.conn.Wizard().Insert(&a)
+	err = s.conn.Wizards().Insert(&a)
 	c.Assert(err, check.IsNil)
 	na, err := FindByName(a.Name)
 	c.Assert(err, check.IsNil)
@@ -453,11 +492,11 @@ func (s *S) TestFindBy(c *check.C) {
 	a := AutoScale{
 		Name: "xpto123",
 	}
-	s.conn.Wizard().Insert(&a)
+	s.conn.Wizards().Insert(&a)
 	a = AutoScale{
 		Name: "xpto1234",
 	}
-	s.conn.Wizard().Insert(&a)
+	s.conn.Wizards().Insert(&a)
 	na, err := FindBy(nil)
 	c.Assert(err, check.IsNil)
 	c.Assert(len(na), check.Equals, 2)
@@ -635,3 +674,104 @@ func (s *S) TestUpdateChangeProcess(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(r.Process, check.Equals, "worker")
 }
+
+func (s *S) TestSave(c *check.C) {
+	a := AutoScale{
+		Name:      "test",
+		ScaleUp:   ScaleAction{Metric: "cpu", Operator: ">", Step: "1", Value: "10", Wait: 50},
+		ScaleDown: ScaleAction{Metric: "cpu", Operator: "<", Step: "1", Value: "2", Wait: 50},
+		Process:   "web",
+	}
+	created, err := Save(&a)
+	c.Assert(err, check.IsNil)
+	c.Assert(created, check.Equals, true)
+	c.Assert(a.MinUnits, check.Equals, 1)
+	a.ScaleUp.Value = "90"
+	created, err = Save(&a)
+	c.Assert(err, check.IsNil)
+	c.Assert(created, check.Equals, false)
+	r, err := FindByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(r.ScaleUp.Value, check.Equals, "90")
+	al, err := alarm.FindAlarmByName("scale_up_test_web")
+	c.Assert(err, check.IsNil)
+	c.Assert(al.Expression, check.Matches, ".* > 90")
+	n, err := s.conn.Alarms().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 2)
+}
+
+func (s *S) TestSaveRestoresAlarms(c *check.C) {
+	a := AutoScale{
+		Name:      "test",
+		ScaleUp:   ScaleAction{Metric: "cpu", Operator: ">", Step: "1", Value: "10", Wait: 50},
+		ScaleDown: ScaleAction{Metric: "cpu", Operator: "<", Step: "1", Value: "2", Wait: 50},
+		Process:   "web",
+	}
+	_, err := Save(&a)
+	c.Assert(err, check.IsNil)
+	err = alarm.NewAlarm(&alarm.Alarm{Name: "scale_down_test_worker"})
+	c.Assert(err, check.IsNil)
+	a.Process = "worker"
+	_, err = Save(&a)
+	c.Assert(err, check.NotNil)
+	r, err := FindByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(r.Process, check.Equals, "web")
+	var alarms []alarm.Alarm
+	err = s.conn.Alarms().Find(nil).Sort("name").All(&alarms)
+	c.Assert(err, check.IsNil)
+	names := make([]string, len(alarms))
+	for i := range alarms {
+		names[i] = alarms[i].Name
+	}
+	c.Assert(names, check.DeepEquals, []string{"scale_down_test_web", "scale_down_test_worker", "scale_up_test_web"})
+}
+
+func (s *S) TestSaveInvalid(c *check.C) {
+	_, err := Save(&AutoScale{})
+	c.Assert(err, check.ErrorMatches, "wizard: name required")
+	_, err = Save(&AutoScale{Name: "test", ScaleUp: ScaleAction{Vars: map[string]string{"step": "1"}}})
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestScale(c *check.C) {
+	var steps []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		steps = append(steps, r.URL.Query().Get("step"))
+	}))
+	defer ts.Close()
+	err := action.New(&action.Action{Name: "scale_up", URL: ts.URL + "/{app}?step={step}", Method: "POST"})
+	c.Assert(err, check.IsNil)
+	err = tsuru.NewInstance(&tsuru.Instance{Name: "test", Apps: []string{"app"}})
+	c.Assert(err, check.IsNil)
+	a := AutoScale{
+		Name:      "test",
+		ScaleUp:   ScaleAction{Metric: "cpu", Operator: ">", Step: "1", Value: "10", Wait: 3600},
+		ScaleDown: ScaleAction{Metric: "cpu", Operator: "<", Step: "1", Value: "2"},
+	}
+	err = New(&a)
+	c.Assert(err, check.IsNil)
+	err = a.Disable()
+	c.Assert(err, check.IsNil)
+	result, err := a.Scale(ScaleUp, 3, "admin")
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, &alarm.TriggerResult{Alarm: "scale_up_test", Executed: true})
+	result, err = a.Scale(ScaleUp, 0, "admin")
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Executed, check.Equals, true)
+	c.Assert(steps, check.DeepEquals, []string{"3", "1"})
+	events, err := a.Events()
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 2)
+	c.Assert(events[0].Manual, check.Equals, true)
+	c.Assert(events[0].User, check.Equals, "admin")
+}
+
+func (s *S) TestScaleInvalid(c *check.C) {
+	a := AutoScale{Name: "test"}
+	_, err := a.Scale("sideways", 1, "admin")
+	c.Assert(err, check.ErrorMatches, `wizard: invalid direction "sideways", must be up or down`)
+	_, err = a.Scale(ScaleDown, -1, "admin")
+	c.Assert(err, check.ErrorMatches, "wizard: units must not be negative")
+}